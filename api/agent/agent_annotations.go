@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+type SetAgentAnnotationRequest struct {
+	Spiffeid string `json:"spiffeid"`
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+}
+type SetAgentAnnotationResponse tornjakTypes.AgentAnnotation
+
+// SetAgentAnnotation sets the value of an annotation of an agent, e.g.
+// status: pending decommission, replacing its previous value
+func (s *Server) SetAgentAnnotation(ctx context.Context, inp SetAgentAnnotationRequest) (*SetAgentAnnotationResponse, error) {
+	annotation := tornjakTypes.AgentAnnotation{
+		Spiffeid:  inp.Spiffeid,
+		Key:       inp.Key,
+		Value:     strings.TrimSpace(inp.Value),
+		UpdatedAt: s.clock().Now().UTC().Format(time.RFC3339),
+	}
+	if err := annotation.Validate(); err != nil {
+		return nil, err
+	}
+	if u := userFromContext(ctx); u != nil {
+		annotation.UpdatedBy = u.Username
+	}
+	if err := s.Db.SetAgentAnnotation(annotation); err != nil {
+		return nil, err
+	}
+	return (*SetAgentAnnotationResponse)(&annotation), nil
+}
+
+type DeleteAgentAnnotationRequest struct {
+	Spiffeid string `json:"spiffeid"`
+	Key      string `json:"key"`
+}
+
+// DeleteAgentAnnotation removes an annotation of an agent
+func (s *Server) DeleteAgentAnnotation(inp DeleteAgentAnnotationRequest) error {
+	if len(inp.Spiffeid) == 0 {
+		return errors.New("input missing mandatory field - Spiffeid")
+	}
+	if len(inp.Key) == 0 {
+		return errors.New("input missing mandatory field - Key")
+	}
+	return s.Db.DeleteAgentAnnotation(inp.Spiffeid, inp.Key)
+}
+
+type ListAgentAnnotationsRequest struct {
+	// agent of the annotations, all agents if empty
+	Spiffeid string `json:"spiffeid"`
+}
+type ListAgentAnnotationsResponse tornjakTypes.AgentAnnotationList
+
+// ListAgentAnnotations returns the annotations of an agent, or of all agents,
+// by SPIFFE ID and key
+func (s *Server) ListAgentAnnotations(inp ListAgentAnnotationsRequest) (*ListAgentAnnotationsResponse, error) {
+	var spiffeids []string
+	if inp.Spiffeid != "" {
+		spiffeids = []string{inp.Spiffeid}
+	}
+	retVal, err := s.Db.GetAgentAnnotations(spiffeids)
+	if err != nil {
+		return nil, err
+	}
+	return (*ListAgentAnnotationsResponse)(&retVal), nil
+}
@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"mime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// number of finished asynchronous agent assignment jobs kept for their status
+const maxFinishedAssignmentJobs = 100
+
+// assignmentJobs keeps the asynchronous agent assignment jobs in memory,
+// so the status of a job is lost on restart
+type assignmentJobs struct {
+	mu   sync.Mutex
+	jobs []*tornjakTypes.AgentAssignmentJob
+}
+
+func newAssignmentJobs() *assignmentJobs {
+	return &assignmentJobs{}
+}
+
+// add keeps a new running job, dropping the oldest finished jobs beyond the limit
+func (j *assignmentJobs) add(job *tornjakTypes.AgentAssignmentJob) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.jobs = append(j.jobs, job)
+	finished := 0
+	for i := len(j.jobs) - 1; i >= 0; i-- {
+		if j.jobs[i].State == tornjakTypes.AgentAssignmentJobRunning {
+			continue
+		}
+		finished++
+		if finished > maxFinishedAssignmentJobs {
+			j.jobs = append(j.jobs[:i], j.jobs[i+1:]...)
+		}
+	}
+}
+
+// finish records the outcome of a job finished at now
+func (j *assignmentJobs) finish(job *tornjakTypes.AgentAssignmentJob, result tornjakTypes.AgentAssignmentResult, err error, now time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job.FinishedAt = now.UTC().Format(time.RFC3339)
+	// progress is only reported while running
+	job.Progress = nil
+	if err != nil {
+		job.State = tornjakTypes.AgentAssignmentJobFailed
+		job.Error = err.Error()
+		return
+	}
+	job.State = tornjakTypes.AgentAssignmentJobSucceeded
+	job.Result = &result
+}
+
+// progress records the number of agents a running job has written
+func (j *assignmentJobs) progress(job *tornjakTypes.AgentAssignmentJob, applied int, total int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job.Progress = &tornjakTypes.AgentAssignmentProgress{Applied: applied, Total: total}
+}
+
+// list returns copies of the jobs with the given ID, all if empty, most recent first
+func (j *assignmentJobs) list(id string) []tornjakTypes.AgentAssignmentJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	ret := []tornjakTypes.AgentAssignmentJob{}
+	for i := len(j.jobs) - 1; i >= 0; i-- {
+		if id == "" || j.jobs[i].ID == id {
+			ret = append(ret, *j.jobs[i])
+		}
+	}
+	return ret
+}
+
+// agentAssignmentFormat returns the upload format of a content type
+func agentAssignmentFormat(contentType string) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return tornjakTypes.AgentAssignmentCSV, nil
+	case "application/x-ndjson", "application/jsonl":
+		return tornjakTypes.AgentAssignmentNDJSON, nil
+	}
+	return "", errors.Errorf("unsupported Content-Type %q, expected text/csv or application/x-ndjson", contentType)
+}
+
+type UploadAgentAssignmentsRequest struct {
+	// csv or ndjson
+	Format string
+	Body   io.Reader
+	// validate the rows without applying them
+	DryRun bool
+	// apply the rows in the background and return the job
+	Async bool
+}
+
+type UploadAgentAssignmentsResponse struct {
+	// set unless the upload is applied asynchronously
+	Result *tornjakTypes.AgentAssignmentResult `json:"result,omitempty"`
+	// set if the upload is applied asynchronously
+	Job *tornjakTypes.AgentAssignmentJob `json:"job,omitempty"`
+}
+
+// UploadAgentAssignments assigns agents to clusters from a CSV or NDJSON upload of
+// spiffeid and cluster_uid pairs; invalid rows are reported and the valid rows
+// applied in one transaction
+func (s *Server) UploadAgentAssignments(ctx context.Context, inp UploadAgentAssignmentsRequest) (*UploadAgentAssignmentsResponse, error) {
+	if s.Db == nil {
+		return nil, errors.New("agent assignments require a DataStore plugin")
+	}
+	// rows are parsed before returning, so asynchronous jobs do not depend on the request body
+	assignments, rowErrors, err := tornjakTypes.ParseAgentAssignments(inp.Body, inp.Format)
+	if err != nil {
+		return nil, err
+	}
+	user := ""
+	if u := userFromContext(ctx); u != nil {
+		user = u.Username
+	}
+	// the actor is taken from the request, as asynchronous jobs outlive it
+	db := s.dbAs(ctx)
+
+	// progress, if not nil, is called after each chunk of agents written
+	apply := func(progress func(applied int, total int)) (tornjakTypes.AgentAssignmentResult, error) {
+		var result tornjakTypes.AgentAssignmentResult
+		var err error
+		if assigner, ok := db.(agentdb.ProgressAssigner); ok && progress != nil {
+			result, err = assigner.AssignAgentsToClustersWithProgress(assignments, inp.DryRun, progress)
+		} else {
+			result, err = db.AssignAgentsToClusters(assignments, inp.DryRun)
+		}
+		if err != nil {
+			return tornjakTypes.AgentAssignmentResult{}, err
+		}
+		result.Rows += len(rowErrors)
+		result.Errors = append(result.Errors, rowErrors...)
+		sort.SliceStable(result.Errors, func(i, j int) bool { return result.Errors[i].Row < result.Errors[j].Row })
+		result.Batch = tornjakTypes.AgentAssignmentBatch(assignments, result.Errors)
+		if !inp.DryRun {
+			log.Printf("user %q assigned %d agents to clusters from an upload of %d rows, %d rejected",
+				user, result.Assigned, result.Rows, len(result.Errors))
+		}
+		return result, nil
+	}
+
+	if !inp.Async {
+		result, err := apply(nil)
+		if err != nil {
+			return nil, err
+		}
+		return &UploadAgentAssignmentsResponse{Result: &result}, nil
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, errors.Errorf("could not generate job ID: %v", err)
+	}
+	job := &tornjakTypes.AgentAssignmentJob{
+		ID:          hex.EncodeToString(idBytes),
+		State:       tornjakTypes.AgentAssignmentJobRunning,
+		SubmittedBy: user,
+		SubmittedAt: s.clock().Now().UTC().Format(time.RFC3339),
+	}
+	s.assignmentJobs.add(job)
+	ret := *job
+	go func() {
+		result, err := apply(func(applied int, total int) {
+			s.assignmentJobs.progress(job, applied, total)
+		})
+		if err != nil {
+			log.Printf("WARNING: agent assignment job %s failed: %v", job.ID, err)
+		}
+		s.assignmentJobs.finish(job, result, err, s.clock().Now())
+	}()
+	return &UploadAgentAssignmentsResponse{Job: &ret}, nil
+}
+
+type MoveAgentsRequest struct {
+	FromClusterUID string   `json:"fromClusterUid"`
+	ToClusterUID   string   `json:"toClusterUid"`
+	Agents         []string `json:"agents"`
+}
+type MoveAgentsResponse tornjakTypes.AgentMoveResult
+
+// MoveAgents moves agents from a cluster to another in one transaction, so
+// the agents are never in both clusters or in neither; no agent is moved if
+// one of them is not in the cluster it is moved from
+func (s *Server) MoveAgents(ctx context.Context, inp MoveAgentsRequest) (*MoveAgentsResponse, error) {
+	if s.proposer != nil {
+		return nil, errors.New("clusters are changed by change proposals; propose the edit of both clusters")
+	}
+	if inp.FromClusterUID == "" || inp.ToClusterUID == "" {
+		return nil, errors.New("input missing mandatory field - fromClusterUid or toClusterUid")
+	}
+	if len(inp.Agents) == 0 {
+		return nil, errors.New("input missing mandatory field - agents")
+	}
+	result, err := s.dbAs(ctx).MoveAgentsBetweenClusters(inp.FromClusterUID, inp.ToClusterUID, inp.Agents)
+	if err != nil {
+		return nil, err
+	}
+	user := ""
+	if u := userFromContext(ctx); u != nil {
+		user = u.Username
+	}
+	log.Printf("user %q moved %d agents from cluster %s to cluster %s", user, result.Moved, result.FromCluster, result.ToCluster)
+	return (*MoveAgentsResponse)(&result), nil
+}
+
+type ListAgentAssignmentJobsRequest struct {
+	// ID of the job, all jobs if empty
+	Id string `json:"id"`
+}
+type ListAgentAssignmentJobsResponse tornjakTypes.AgentAssignmentJobList
+
+// ListAgentAssignmentJobs returns the asynchronous agent assignment jobs since the last restart
+func (s *Server) ListAgentAssignmentJobs(inp ListAgentAssignmentJobsRequest) (*ListAgentAssignmentJobsResponse, error) {
+	jobs := s.assignmentJobs.list(inp.Id)
+	if inp.Id != "" && len(jobs) == 0 {
+		return nil, errors.Errorf("agent assignment job %q not found", inp.Id)
+	}
+	return &ListAgentAssignmentJobsResponse{Jobs: jobs}, nil
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	agent "github.com/spiffe/spire-api-sdk/proto/spire/api/server/agent/v1"
+	types "github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// maximum number of agents read from SPIRE at once when hydrating a list of agents
+const agentHydrationConcurrency = 8
+
+// hydrateAgents reads the SPIRE details of the agents in parallel over one
+// connection, with at most agentHydrationConcurrency calls in flight
+// agents that SPIRE does not know or that cannot be read are reported in the
+// errors by SPIFFE ID rather than failing the whole list
+func (s *Server) hydrateAgents(ctx context.Context, spiffeids []string) (map[string]tornjakTypes.SpireAgentDetails, map[string]string, error) {
+	details := map[string]tornjakTypes.SpireAgentDetails{}
+	errs := map[string]string{}
+	if len(spiffeids) == 0 {
+		return details, errs, nil
+	}
+	conn, err := s.dialSPIRE()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	client := agent.NewAgentClient(conn)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, agentHydrationConcurrency)
+	for _, spiffeid := range spiffeids {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(spiffeid string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			d, err := getAgentDetails(ctx, client, spiffeid)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[spiffeid] = err.Error()
+				return
+			}
+			details[spiffeid] = d
+		}(spiffeid)
+	}
+	wg.Wait()
+	return details, errs, nil
+}
+
+// getAgentDetails reads the attestation type, SVID expiry and ban of an agent from SPIRE
+func getAgentDetails(ctx context.Context, client agent.AgentClient, agentID string) (tornjakTypes.SpireAgentDetails, error) {
+	id, err := spiffeid.FromString(agentID)
+	if err != nil {
+		return tornjakTypes.SpireAgentDetails{}, err
+	}
+	a, err := client.GetAgent(ctx, &agent.GetAgentRequest{
+		Id:         &types.SPIFFEID{TrustDomain: id.TrustDomain().String(), Path: id.Path()},
+		OutputMask: &types.AgentMask{AttestationType: true, X509SvidExpiresAt: true, Banned: true},
+	})
+	if status.Code(err) == codes.NotFound {
+		return tornjakTypes.SpireAgentDetails{}, status.Errorf(codes.NotFound, "agent %s not found in SPIRE", agentID)
+	} else if err != nil {
+		return tornjakTypes.SpireAgentDetails{}, err
+	}
+	d := tornjakTypes.SpireAgentDetails{AttestationType: a.AttestationType, Banned: a.Banned}
+	if a.X509SvidExpiresAt > 0 {
+		d.ExpiresAt = time.Unix(a.X509SvidExpiresAt, 0).UTC().Format(time.RFC3339)
+	}
+	return d, nil
+}
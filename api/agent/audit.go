@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// actors recorded in the audit log for the changes of background components,
+// which are not made by a user
+const (
+	auditActorDesiredState = "tornjak:desired-state"
+	auditActorReplication  = "tornjak:replication"
+)
+
+// dbAs returns the DB recording the authenticated user of the request as
+// the actor of its changes in the audit log
+func (s *Server) dbAs(ctx context.Context) agentdb.AgentDB {
+	if u := userFromContext(ctx); u != nil {
+		return s.Db.WithActor(u.Username)
+	}
+	return s.Db
+}
+
+type ListAuditEventsRequest tornjakTypes.ListOptions
+type ListAuditEventsResponse tornjakTypes.List[tornjakTypes.AuditEvent]
+
+// ListAuditEvents returns a page of the changes of clusters, agents and
+// memberships, most recent first, optionally filtered on actor, action,
+// objectType, objectId and clusterUid
+func (s *Server) ListAuditEvents(inp ListAuditEventsRequest) (*ListAuditEventsResponse, error) {
+	retVal, err := s.Db.GetAuditEvents(tornjakTypes.ListOptions(inp))
+	if err != nil {
+		return nil, err
+	}
+	return (*ListAuditEventsResponse)(&retVal), nil
+}
+
+type GetClusterHistoryRequest struct {
+	// cluster, by UID or by name; deleted clusters by UID only
+	UID    string `json:"uid,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Limit  int    `json:"limit"`
+	Cursor string `json:"cursor"`
+}
+type GetClusterHistoryResponse tornjakTypes.List[tornjakTypes.ClusterHistoryEvent]
+
+// GetClusterHistory returns a page of the changes of a cluster and of its
+// agents from the audit log, most recent first, with the previous values of
+// the fields changed by each edit
+// users restricted to a cluster only read the history of that cluster
+func (s *Server) GetClusterHistory(ctx context.Context, inp GetClusterHistoryRequest) (*GetClusterHistoryResponse, error) {
+	uid := inp.UID
+	switch {
+	case inp.UID != "" && inp.Name != "":
+		return nil, errors.New("only one of uid and name may be set")
+	case inp.Name != "":
+		clusters, err := s.Db.GetClusters()
+		if err != nil {
+			return nil, err
+		}
+		for _, cluster := range clusters.Clusters {
+			if cluster.Name == inp.Name {
+				uid = cluster.UID
+			}
+		}
+		if uid == "" {
+			return nil, errors.Errorf("cluster %s does not exist", inp.Name)
+		}
+	case inp.UID == "":
+		return nil, errors.New("input missing mandatory field - UID or Name")
+	}
+	if u := userFromContext(ctx); u != nil && u.ClusterScope != nil && u.ClusterScope.ClusterUID != uid {
+		return nil, errors.New("cluster token is restricted to another cluster")
+	}
+	events, err := s.Db.GetAuditEvents(tornjakTypes.ListOptions{Limit: inp.Limit, Cursor: inp.Cursor,
+		Filters: []tornjakTypes.Filter{{Field: "clusterUid", Value: uid}}})
+	if err != nil {
+		return nil, err
+	}
+	retVal, err := agentdb.ClusterHistory(events)
+	if err != nil {
+		return nil, err
+	}
+	return (*GetClusterHistoryResponse)(&retVal), nil
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// default time an authorization decision is cached
+const defaultAuthorizationCacheTTL = 10 * time.Second
+
+// requestScope is the resource scope authorization decisions are cached for
+// APIs select resources by their query, the body is not read for authorization
+func requestScope(r *http.Request) string {
+	return r.URL.RawQuery
+}
+
+// invalidateAuthorizationCache drops cached decisions after role bindings changed
+func (s *Server) invalidateAuthorizationCache(ctx context.Context) {
+	if s.authzCache == nil {
+		return
+	}
+	if err := s.authzCache.Invalidate(ctx); err != nil {
+		log.Printf("WARNING: could not invalidate authorization cache: %v", err)
+	}
+}
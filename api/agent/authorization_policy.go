@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/authorization"
+)
+
+type EvaluatePolicyRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// roles of the user
+	Roles []string `json:"roles"`
+	// service account whose roles are added to those of the user
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+	// YAML or JSON policy evaluated in place of the policy file, e.g. a change
+	// under review; the enforced policy if empty
+	Policy string `json:"policy,omitempty"`
+}
+type EvaluatePolicyResponse authorization.PolicyDecision
+
+// EvaluatePolicy tells whether a user would be allowed to call an API under
+// the RBAC policy, without calling it
+func (s *Server) EvaluatePolicy(inp EvaluatePolicyRequest) (*EvaluatePolicyResponse, error) {
+	if s.policyAuthorizer == nil {
+		return nil, errors.New("policy evaluation requires the RBAC Authorizer")
+	}
+	if len(inp.Method) == 0 || len(inp.Path) == 0 {
+		return nil, errors.New("input missing mandatory field - Method or Path")
+	}
+	method := strings.ToUpper(inp.Method)
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete:
+	default:
+		return nil, errors.Errorf("invalid method %q", inp.Method)
+	}
+
+	roles := append([]string{}, inp.Roles...)
+	if inp.ServiceAccount != "" {
+		if s.Db == nil {
+			return nil, errors.New("service accounts require a DataStore plugin")
+		}
+		accounts, err := s.Db.GetServiceAccounts()
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, account := range accounts.ServiceAccounts {
+			if account.Name == inp.ServiceAccount {
+				roles = append(roles, account.Roles...)
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("service account %q does not exist", inp.ServiceAccount)
+		}
+	}
+
+	var candidate *authorization.Policy
+	if inp.Policy != "" {
+		policy, err := authorization.ParsePolicy([]byte(inp.Policy))
+		if err != nil {
+			return nil, err
+		}
+		candidate = &policy
+	}
+	decision, err := s.policyAuthorizer.Evaluate(candidate, method, inp.Path, roles)
+	if err != nil {
+		return nil, err
+	}
+	return (*EvaluatePolicyResponse)(&decision), nil
+}
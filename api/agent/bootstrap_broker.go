@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	agent "github.com/spiffe/spire-api-sdk/proto/spire/api/server/agent/v1"
+	types "github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+
+	"github.com/spiffe/tornjak/pkg/agent/bootstrap"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// defaults of the bootstrap broker configuration
+const (
+	defaultBootstrapTokenTTL    = 15 * time.Minute
+	defaultBootstrapTokenMaxTTL = time.Hour
+	defaultBootstrapSweepPeriod = time.Minute
+)
+
+// newBootstrapBroker returns the broker for the bootstrap_broker configuration
+func (s *Server) newBootstrapBroker(config *BootstrapBrokerConfig) (*bootstrap.Broker, error) {
+	defaultTTL, err := parseConfigDuration("default_ttl", config.DefaultTTL, defaultBootstrapTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	maxTTL, err := parseConfigDuration("max_ttl", config.MaxTTL, defaultBootstrapTokenMaxTTL)
+	if err != nil {
+		return nil, err
+	}
+	if defaultTTL > maxTTL {
+		return nil, errors.Errorf("'default_ttl' %v exceeds 'max_ttl' %v", defaultTTL, maxTTL)
+	}
+	sweepInterval, err := parseConfigDuration("sweep_interval", config.SweepInterval, defaultBootstrapSweepPeriod)
+	if err != nil {
+		return nil, err
+	}
+
+	return bootstrap.New(bootstrap.Config{
+		CreateJoinToken:     s.createBootstrapJoinToken,
+		ListJoinTokenAgents: s.listJoinTokenAgents,
+		Store:               s.Db,
+		Hasher:              s.keyHasher,
+		DefaultTTL:          defaultTTL,
+		MaxTTL:              maxTTL,
+		SweepInterval:       sweepInterval,
+		Clock:               s.Clock,
+	}), nil
+}
+
+// createBootstrapJoinToken creates a join token in SPIRE, with a node entry
+// mapping it to agentID if not empty
+func (s *Server) createBootstrapJoinToken(ctx context.Context, ttl time.Duration, agentID string) (string, error) {
+	req := CreateJoinTokenRequest{Ttl: int32(ttl / time.Second)}
+	if agentID != "" {
+		id, err := spiffeid.FromString(agentID)
+		if err != nil {
+			return "", errors.Errorf("invalid agent ID %q: %v", agentID, err)
+		}
+		req.AgentId = &types.SPIFFEID{TrustDomain: id.TrustDomain().String(), Path: id.Path()}
+	}
+	resp, err := s.CreateJoinToken(ctx, req) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+	if err != nil {
+		return "", err
+	}
+	return resp.Value, nil
+}
+
+// listJoinTokenAgents returns the SPIFFE IDs of the agents attested with a join token
+func (s *Server) listJoinTokenAgents(ctx context.Context) ([]string, error) {
+	ids := []string{}
+	req := ListAgentsRequest{
+		Filter:     &agent.ListAgentsRequest_Filter{ByAttestationType: "join_token"},
+		OutputMask: &types.AgentMask{},
+	}
+	for {
+		resp, err := s.ListAgents(ctx, req) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range resp.Agents {
+			ids = append(ids, "spiffe://"+a.GetId().GetTrustDomain()+a.GetId().GetPath())
+		}
+		if resp.NextPageToken == "" {
+			return ids, nil
+		}
+		req.PageToken = resp.NextPageToken
+	}
+}
+
+type IssueBootstrapTokenRequest struct {
+	// TTL of the token, e.g. "10m", the configured default if empty
+	TTL string `json:"ttl"`
+	// SPIFFE ID to pre-register the agent under, optional
+	AgentID     string `json:"agentId"`
+	Description string `json:"description"`
+}
+type IssueBootstrapTokenResponse tornjakTypes.BootstrapCredential
+
+// IssueBootstrapToken creates a single-use join token for a provisioning system
+// the token is returned only once; the local DB keeps its hash
+func (s *Server) IssueBootstrapToken(ctx context.Context, inp IssueBootstrapTokenRequest) (*IssueBootstrapTokenResponse, error) {
+	if s.bootstrapBroker == nil {
+		return nil, errors.New("bootstrap broker is not configured")
+	}
+	req := bootstrap.Request{AgentID: inp.AgentID, Description: inp.Description}
+	if inp.TTL != "" {
+		ttl, err := time.ParseDuration(inp.TTL)
+		if err != nil {
+			return nil, errors.Errorf("invalid TTL %q", inp.TTL)
+		}
+		req.TTL = ttl
+	}
+	if u := userFromContext(ctx); u != nil {
+		req.IssuedBy = u.Username
+	}
+	retVal, err := s.bootstrapBroker.Issue(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return (*IssueBootstrapTokenResponse)(&retVal), nil
+}
+
+type ListBootstrapTokensRequest struct {
+	// one of issued, consumed or expired, all if empty
+	State string `json:"state"`
+}
+type ListBootstrapTokensResponse tornjakTypes.BootstrapTokenList
+
+// ListBootstrapTokens returns the records of the issued tokens, most recent first
+func (s *Server) ListBootstrapTokens(inp ListBootstrapTokensRequest) (*ListBootstrapTokensResponse, error) {
+	if s.bootstrapBroker == nil {
+		return nil, errors.New("bootstrap broker is not configured")
+	}
+	switch inp.State {
+	case "", tornjakTypes.BootstrapTokenIssued, tornjakTypes.BootstrapTokenConsumed, tornjakTypes.BootstrapTokenExpired:
+	default:
+		return nil, errors.Errorf("invalid state %q", inp.State)
+	}
+	retVal, err := s.Db.GetBootstrapTokens(inp.State)
+	if err != nil {
+		return nil, err
+	}
+	return (*ListBootstrapTokensResponse)(&retVal), nil
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+	types "github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+
+	"github.com/spiffe/tornjak/pkg/agent/bundleendpoint"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// defaults of the bundle endpoint configuration
+const (
+	defaultBundleEndpointRefreshInterval = time.Minute
+	defaultBundleEndpointFetchTimeout    = 10 * time.Second
+)
+
+// bundleEndpoint serves the bundle of the trust domain to federation partners
+// on its own port, with the https_web or https_spiffe profile
+type bundleEndpoint struct {
+	endpoint *bundleendpoint.Endpoint
+	port     int
+	profile  string
+	// certificate of the https_web profile
+	keyPair *bundleendpoint.KeyPair
+	// address of the SPIFFE Workload API the X509-SVID of the https_spiffe profile is fetched from
+	workloadAPIAddr string
+}
+
+// newBundleEndpoint returns the bundle endpoint for the bundle_endpoint configuration
+func (s *Server) newBundleEndpoint(config *BundleEndpointConfig) (*bundleEndpoint, error) {
+	if config.Port == 0 {
+		return nil, errors.New("'port' is required")
+	}
+	refreshInterval, err := parseConfigDuration("refresh_interval", config.RefreshInterval, defaultBundleEndpointRefreshInterval)
+	if err != nil {
+		return nil, err
+	}
+	var refreshHint time.Duration
+	if config.RefreshHint != "" {
+		refreshHint, err = parseConfigDuration("refresh_hint", config.RefreshHint, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	fetchTimeout, err := parseConfigDuration("fetch_timeout", config.FetchTimeout, defaultBundleEndpointFetchTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bundleEndpoint{port: config.Port, profile: config.Profile}
+	switch config.Profile {
+	case tornjakTypes.BundleEndpointProfileHTTPSWeb:
+		if config.Cert == "" || config.Key == "" {
+			return nil, errors.New("'cert' and 'key' are required by the https_web profile")
+		}
+		b.keyPair, err = bundleendpoint.NewKeyPair(config.Cert, config.Key)
+		if err != nil {
+			return nil, err
+		}
+	case tornjakTypes.BundleEndpointProfileHTTPSSPIFFE:
+		if config.WorkloadAPISocket == "" {
+			return nil, errors.New("'workload_api_socket' is required by the https_spiffe profile")
+		}
+		b.workloadAPIAddr = config.WorkloadAPISocket
+	default:
+		return nil, errors.Errorf("invalid 'profile' %q, expected %s or %s", config.Profile,
+			tornjakTypes.BundleEndpointProfileHTTPSWeb, tornjakTypes.BundleEndpointProfileHTTPSSPIFFE)
+	}
+
+	b.endpoint, err = bundleendpoint.New(bundleendpoint.Config{
+		FetchBundle:     s.fetchTrustDomainBundle,
+		TrustDomain:     s.SpireServerInfo.TrustDomain,
+		RefreshInterval: refreshInterval,
+		RefreshHint:     refreshHint,
+		FetchTimeout:    fetchTimeout,
+		Clock:           s.Clock,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// fetchTrustDomainBundle returns the bundle of the trust domain of the SPIRE server
+func (s *Server) fetchTrustDomainBundle(ctx context.Context) (*spiffebundle.Bundle, error) {
+	resp, err := s.GetBundle(ctx, GetBundleRequest{}) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+	if err != nil {
+		return nil, err
+	}
+	return spiffeBundleFromProto((*types.Bundle)(resp))
+}
+
+// runBundleEndpoint refreshes the served bundle in the background and serves
+// it until the listener fails
+func (s *Server) runBundleEndpoint(ctx context.Context) {
+	b := s.bundleEndpoint
+	go b.endpoint.Run(ctx)
+
+	var tlsConfig *tls.Config
+	if b.profile == tornjakTypes.BundleEndpointProfileHTTPSSPIFFE {
+		// the source follows the rotations of the X509-SVID
+		source, err := workloadapi.NewX509Source(ctx, workloadapi.WithClientOptions(workloadapi.WithAddr(b.workloadAPIAddr)))
+		if err != nil {
+			log.Printf("ERROR: bundle endpoint: could not fetch X509-SVID from the Workload API: %v", err)
+			return
+		}
+		defer source.Close()
+		tlsConfig = tlsconfig.TLSServerConfig(source)
+	} else {
+		tlsConfig = &tls.Config{GetCertificate: b.keyPair.GetCertificate, MinVersion: tls.VersionTLS12}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", b.endpoint)
+	addr := fmt.Sprintf(":%d", b.port)
+	server := &http.Server{
+		Handler:   mux,
+		Addr:      addr,
+		TLSConfig: tlsConfig,
+	}
+	fmt.Printf("Starting bundle endpoint with profile %s on %s...\n", b.profile, addr)
+	if err := server.ListenAndServeTLS("", ""); err != nil {
+		log.Printf("ERROR: bundle endpoint: %v", err)
+	}
+}
+
+type GetBundleEndpointStatusRequest struct{}
+type GetBundleEndpointStatusResponse bundleendpoint.Status
+
+// GetBundleEndpointStatus returns the state of the bundle served to federation partners
+func (s *Server) GetBundleEndpointStatus(inp GetBundleEndpointStatusRequest) (*GetBundleEndpointStatusResponse, error) {
+	if s.bundleEndpoint == nil {
+		return nil, errors.New("bundle endpoint is not configured")
+	}
+	status := s.bundleEndpoint.endpoint.Status()
+	return (*GetBundleEndpointStatusResponse)(&status), nil
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	types "github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+
+	"github.com/spiffe/tornjak/pkg/agent/bundlemonitor"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// defaults of the bundle monitor configuration
+const (
+	defaultBundleCheckInterval = 5 * time.Minute
+	defaultBundleStaleAfter    = time.Hour
+	defaultBundleFetchTimeout  = 10 * time.Second
+)
+
+// newBundleMonitor returns the bundle monitor for the bundle_monitor configuration
+func (s *Server) newBundleMonitor(config *BundleMonitorConfig) (*bundlemonitor.Monitor, error) {
+	interval, err := parseConfigDuration("check_interval", config.CheckInterval, defaultBundleCheckInterval)
+	if err != nil {
+		return nil, err
+	}
+	staleAfter, err := parseConfigDuration("stale_after", config.StaleAfter, defaultBundleStaleAfter)
+	if err != nil {
+		return nil, err
+	}
+	fetchTimeout, err := parseConfigDuration("fetch_timeout", config.FetchTimeout, defaultBundleFetchTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return bundlemonitor.New(bundlemonitor.Config{
+		ListFederations: s.listFederations,
+		ListBundles:     s.listFederatedBundles,
+		Store:           s.Db,
+		Notify:          notifyBundleFreshness,
+		Interval:        interval,
+		StaleAfter:      staleAfter,
+		FetchTimeout:    fetchTimeout,
+		Clock:           s.Clock,
+	}), nil
+}
+
+// parseConfigDuration parses the positive duration of a config option, fallback if empty
+func parseConfigDuration(name, value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, errors.Errorf("invalid '%s': %q", name, value)
+	}
+	return d, nil
+}
+
+// notifyBundleFreshness logs trust domains becoming stale or fresh again
+func notifyBundleFreshness(status tornjakTypes.BundleFreshness) {
+	if status.Stale {
+		log.Printf("WARNING: bundle of federated trust domain %s is stale: %s", status.TrustDomain, status.StaleReason)
+	} else {
+		log.Printf("bundle of federated trust domain %s is fresh again", status.TrustDomain)
+	}
+}
+
+// listFederations returns the federation relationships of the SPIRE server
+func (s *Server) listFederations(ctx context.Context) ([]bundlemonitor.Federation, error) {
+	feds := []bundlemonitor.Federation{}
+	req := ListFederationRelationshipsRequest{}
+	for {
+		resp, err := s.ListFederationRelationships(ctx, req) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range resp.FederationRelationships {
+			fed := bundlemonitor.Federation{
+				TrustDomain:       rel.GetTrustDomain(),
+				BundleEndpointURL: rel.GetBundleEndpointUrl(),
+			}
+			if profile := rel.GetHttpsSpiffe(); profile != nil {
+				fed.Profile = tornjakTypes.BundleEndpointProfileHTTPSSPIFFE
+				fed.EndpointSPIFFEID = profile.GetEndpointSpiffeId()
+			} else if rel.GetHttpsWeb() != nil {
+				fed.Profile = tornjakTypes.BundleEndpointProfileHTTPSWeb
+			}
+			feds = append(feds, fed)
+		}
+		if resp.NextPageToken == "" {
+			return feds, nil
+		}
+		req.PageToken = resp.NextPageToken
+	}
+}
+
+// listFederatedBundles returns the bundles of the federated trust domains held by the SPIRE server
+func (s *Server) listFederatedBundles(ctx context.Context) ([]*spiffebundle.Bundle, error) {
+	bundles := []*spiffebundle.Bundle{}
+	req := ListFederatedBundlesRequest{}
+	for {
+		resp, err := s.ListFederatedBundles(ctx, req) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range resp.Bundles {
+			bundle, err := spiffeBundleFromProto(b)
+			if err != nil {
+				return nil, errors.Errorf("invalid bundle of trust domain %s: %v", b.GetTrustDomain(), err)
+			}
+			bundles = append(bundles, bundle)
+		}
+		if resp.NextPageToken == "" {
+			return bundles, nil
+		}
+		req.PageToken = resp.NextPageToken
+	}
+}
+
+// spiffeBundleFromProto converts a bundle of the SPIRE API
+func spiffeBundleFromProto(b *types.Bundle) (*spiffebundle.Bundle, error) {
+	td, err := spiffeid.TrustDomainFromString(b.GetTrustDomain())
+	if err != nil {
+		return nil, err
+	}
+	bundle := spiffebundle.New(td)
+	for _, authority := range b.GetX509Authorities() {
+		cert, err := x509.ParseCertificate(authority.GetAsn1())
+		if err != nil {
+			return nil, err
+		}
+		bundle.AddX509Authority(cert)
+	}
+	for _, authority := range b.GetJwtAuthorities() {
+		key, err := x509.ParsePKIXPublicKey(authority.GetPublicKey())
+		if err != nil {
+			return nil, err
+		}
+		if err := bundle.AddJWTAuthority(authority.GetKeyId(), key); err != nil {
+			return nil, err
+		}
+	}
+	bundle.SetSequenceNumber(b.GetSequenceNumber())
+	if hint := b.GetRefreshHint(); hint > 0 {
+		bundle.SetRefreshHint(time.Duration(hint) * time.Second)
+	}
+	return bundle, nil
+}
+
+type GetBundleFreshnessRequest struct{}
+type GetBundleFreshnessResponse tornjakTypes.BundleFreshnessList
+
+// GetBundleFreshness returns the last check result of each federated trust domain
+func (s *Server) GetBundleFreshness(inp GetBundleFreshnessRequest) (*GetBundleFreshnessResponse, error) {
+	if s.bundleMonitor == nil {
+		return nil, errors.New("bundle monitor is not configured")
+	}
+	retVal, err := s.Db.GetBundleFreshness()
+	if err != nil {
+		return nil, err
+	}
+	return (*GetBundleFreshnessResponse)(&retVal), nil
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/proposal"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// newProposer returns the proposer for the change proposal configuration
+// branch names are dated by c, the clock of the system if nil
+func newProposer(config *ChangeProposalsConfig, c clock.Clock) (*proposal.Proposer, error) {
+	return proposal.New(proposal.Config{
+		RepoPath:     config.RepoPath,
+		File:         config.File,
+		BaseBranch:   config.BaseBranch,
+		BranchPrefix: config.BranchPrefix,
+		Remote:       config.Remote,
+		Clock:        c,
+	})
+}
+
+// proposedState returns the desired state a change is proposed against:
+// the desired-state document if one is reconciled, otherwise the clusters in the DB
+func (s *Server) proposedState(ctx context.Context) (tornjakTypes.DesiredState, error) {
+	if s.reconciler != nil {
+		return s.reconciler.DesiredState(ctx)
+	}
+	clusters, err := s.Db.GetClusters()
+	if err != nil {
+		return tornjakTypes.DesiredState{}, err
+	}
+	return tornjakTypes.DesiredState{Clusters: clusters.Clusters, Prune: true}, nil
+}
+
+// proposeClusterChange commits the desired state with change applied to the
+// clusters as a change proposal
+func (s *Server) proposeClusterChange(ctx context.Context, summary string, change func([]tornjakTypes.ClusterInfo) ([]tornjakTypes.ClusterInfo, error)) (*ChangeProposalResponse, error) {
+	state, err := s.proposedState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	state.Clusters, err = change(state.Clusters)
+	if err != nil {
+		return nil, err
+	}
+	author := ""
+	if userInfo := userFromContext(ctx); userInfo != nil {
+		author = userInfo.Username
+	}
+	ret, err := s.proposer.Propose(ctx, state, summary, author)
+	if err != nil {
+		return nil, err
+	}
+	return (*ChangeProposalResponse)(&ret), nil
+}
+
+// clusterIndex returns the index of the cluster with the given name, or -1
+// cluster names are case-insensitive as in the DB
+func clusterIndex(clusters []tornjakTypes.ClusterInfo, name string) int {
+	for i, cluster := range clusters {
+		if strings.EqualFold(cluster.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+type ChangeProposalResponse tornjakTypes.ChangeProposal
+
+// ProposeDefineCluster proposes the creation of a cluster
+func (s *Server) ProposeDefineCluster(ctx context.Context, inp RegisterClusterRequest) (*ChangeProposalResponse, error) {
+	cinfo, err := s.checkDefineCluster(inp)
+	if err != nil {
+		return nil, err
+	}
+	return s.proposeClusterChange(ctx, fmt.Sprintf("Create cluster %s", cinfo.Name), func(clusters []tornjakTypes.ClusterInfo) ([]tornjakTypes.ClusterInfo, error) {
+		if clusterIndex(clusters, cinfo.Name) >= 0 {
+			return nil, errors.New("Cluster already exists; use Edit Cluster")
+		}
+		return append(clusters, cinfo), nil
+	})
+}
+
+// ProposeEditCluster proposes the update of a cluster
+func (s *Server) ProposeEditCluster(ctx context.Context, inp EditClusterRequest) (*ChangeProposalResponse, error) {
+	cinfo, err := s.checkEditCluster(inp)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.checkClusterScope(ctx, cinfo.Name); err != nil {
+		return nil, err
+	}
+	return s.proposeClusterChange(ctx, fmt.Sprintf("Edit cluster %s", cinfo.Name), func(clusters []tornjakTypes.ClusterInfo) ([]tornjakTypes.ClusterInfo, error) {
+		i := clusterIndex(clusters, cinfo.Name)
+		if i < 0 {
+			return nil, errors.New("Cluster does not exist; use Create Cluster")
+		}
+		if j := clusterIndex(clusters, cinfo.EditedName); j >= 0 && j != i {
+			return nil, errors.New("Cluster already exists; use Edit Cluster")
+		}
+		cinfo.Name = cinfo.EditedName
+		// edits keep the protection of the cluster
+		cinfo.Protected = clusters[i].Protected
+		clusters[i] = cinfo
+		return clusters, nil
+	})
+}
+
+// ProposeDeleteCluster proposes the deletion of a cluster
+func (s *Server) ProposeDeleteCluster(ctx context.Context, inp DeleteClusterRequest) (*ChangeProposalResponse, error) {
+	cinfo := tornjakTypes.ClusterInfo(inp.ClusterInstance)
+	if len(cinfo.Name) == 0 {
+		return nil, errors.New("input missing mandatory field - Name")
+	}
+	return s.proposeClusterChange(ctx, fmt.Sprintf("Delete cluster %s", cinfo.Name), func(clusters []tornjakTypes.ClusterInfo) ([]tornjakTypes.ClusterInfo, error) {
+		i := clusterIndex(clusters, cinfo.Name)
+		if i < 0 {
+			return nil, errors.New("Cluster does not exist")
+		}
+		if clusters[i].Protected {
+			return nil, agentdb.ProtectedClusterFailure(clusters[i].Name)
+		}
+		return append(clusters[:i], clusters[i+1:]...), nil
+	})
+}
+
+// clusterHandlers returns the handlers of the cluster create, edit and delete
+// routes, which propose changes instead of applying them if configured
+func (s *Server) clusterHandlers() (create, edit, del http.HandlerFunc) {
+	if s.proposer != nil {
+		return s.clusterCreateProposal, s.clusterEditProposal, s.clusterDeleteProposal
+	}
+	return s.clusterCreate, s.clusterEdit, s.clusterDelete
+}
@@ -0,0 +1,363 @@
+//go:build tornjak_dev
+
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/pkg/errors"
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Fault injection for dev builds, built with the tornjak_dev tag.
+// Operators simulate SPIRE latency and unavailability and DataStore lock
+// contention to rehearse failure handling and check dashboards and alerts.
+
+// time after which faults are cleared unless a duration is given
+const defaultChaosDuration = 5 * time.Minute
+
+// ChaosSettings are the faults to inject
+type ChaosSettings struct {
+	// delay added to every SPIRE call, as a duration
+	SPIRELatency string `json:"spireLatency,omitempty"`
+	// fail every SPIRE call with Unavailable
+	SPIREUnavailable bool `json:"spireUnavailable,omitempty"`
+	// fraction of SPIRE calls failing with Unavailable, between 0 and 1
+	SPIREFailureRate float64 `json:"spireFailureRate,omitempty"`
+	// hold an exclusive lock on the DataStore for this duration
+	DBLock string `json:"dbLock,omitempty"`
+	// time after which the SPIRE faults are cleared
+	Duration string `json:"duration,omitempty"`
+}
+
+// ChaosStatus describes the faults currently injected
+type ChaosStatus struct {
+	SPIRELatencyMs   int64   `json:"spireLatencyMs"`
+	SPIREUnavailable bool    `json:"spireUnavailable"`
+	SPIREFailureRate float64 `json:"spireFailureRate"`
+	DBLockedUntil    string  `json:"dbLockedUntil,omitempty"`
+	ExpiresAt        string  `json:"expiresAt,omitempty"`
+}
+
+type chaosState struct {
+	mu               sync.Mutex
+	spireLatency     time.Duration
+	spireUnavailable bool
+	spireFailureRate float64
+	expiresAt        time.Time
+	dbLockedUntil    time.Time
+}
+
+// spireFaults returns the SPIRE faults in effect
+func (c *chaosState) spireFaults() (time.Duration, bool, float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().After(c.expiresAt) {
+		return 0, false, 0
+	}
+	return c.spireLatency, c.spireUnavailable, c.spireFailureRate
+}
+
+func (c *chaosState) status() ChaosStatus {
+	latency, unavailable, failureRate := c.spireFaults()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := ChaosStatus{
+		SPIRELatencyMs:   latency.Milliseconds(),
+		SPIREUnavailable: unavailable,
+		SPIREFailureRate: failureRate,
+	}
+	if latency > 0 || unavailable || failureRate > 0 {
+		ret.ExpiresAt = c.expiresAt.UTC().Format(time.RFC3339)
+	}
+	if time.Now().Before(c.dbLockedUntil) {
+		ret.DBLockedUntil = c.dbLockedUntil.UTC().Format(time.RFC3339)
+	}
+	return ret
+}
+
+// chaosInterceptors returns the interceptors injecting faults into SPIRE calls
+func (s *Server) chaosInterceptors() []grpc.UnaryClientInterceptor {
+	return []grpc.UnaryClientInterceptor{s.chaosUnaryClientInterceptor}
+}
+
+func (s *Server) chaosUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if s.chaos == nil {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	latency, unavailable, failureRate := s.chaos.spireFaults()
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if unavailable || (failureRate > 0 && rand.Float64() < failureRate) { //nolint:gosec // simulated failures need no secure randomness
+		return status.Errorf(codes.Unavailable, "chaos: simulated SPIRE failure of %s", method)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// registerChaosRoutes adds the fault injection API
+func (s *Server) registerChaosRoutes(apiRtr *mux.Router) {
+	if s.chaos == nil {
+		s.chaos = &chaosState{}
+	}
+	apiRtr.HandleFunc("/api/v1/tornjak/chaos", s.tornjakChaosGet).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/chaos", s.tornjakChaosSet).Methods(http.MethodPost)
+	apiRtr.HandleFunc("/api/v1/tornjak/chaos", s.tornjakChaosReset).Methods(http.MethodDelete)
+}
+
+type GetChaosRequest struct{}
+
+// GetChaos returns the faults currently injected
+func (s *Server) GetChaos(inp GetChaosRequest) (*ChaosStatus, error) {
+	ret := s.chaos.status()
+	return &ret, nil
+}
+
+type SetChaosRequest ChaosSettings
+
+// SetChaos replaces the SPIRE faults and optionally locks the DataStore
+func (s *Server) SetChaos(inp SetChaosRequest) (*ChaosStatus, error) {
+	var latency, lock time.Duration
+	duration := defaultChaosDuration
+	var err error
+	if inp.SPIRELatency != "" {
+		if latency, err = time.ParseDuration(inp.SPIRELatency); err != nil || latency < 0 {
+			return nil, errors.Errorf("invalid spireLatency %q", inp.SPIRELatency)
+		}
+	}
+	if inp.DBLock != "" {
+		if lock, err = time.ParseDuration(inp.DBLock); err != nil || lock <= 0 {
+			return nil, errors.Errorf("invalid dbLock %q", inp.DBLock)
+		}
+	}
+	if inp.Duration != "" {
+		if duration, err = time.ParseDuration(inp.Duration); err != nil || duration <= 0 {
+			return nil, errors.Errorf("invalid duration %q", inp.Duration)
+		}
+	}
+	if inp.SPIREFailureRate < 0 || inp.SPIREFailureRate > 1 {
+		return nil, errors.Errorf("spireFailureRate %v not between 0 and 1", inp.SPIREFailureRate)
+	}
+
+	if lock > 0 {
+		if err := s.lockDataStore(lock); err != nil {
+			return nil, err
+		}
+	}
+
+	s.chaos.mu.Lock()
+	s.chaos.spireLatency = latency
+	s.chaos.spireUnavailable = inp.SPIREUnavailable
+	s.chaos.spireFailureRate = inp.SPIREFailureRate
+	s.chaos.expiresAt = time.Now().Add(duration)
+	s.chaos.mu.Unlock()
+	return s.GetChaos(GetChaosRequest{})
+}
+
+type ResetChaosRequest struct{}
+
+// ResetChaos clears the SPIRE faults
+// a DataStore lock is held until it expires
+func (s *Server) ResetChaos(inp ResetChaosRequest) error {
+	s.chaos.mu.Lock()
+	defer s.chaos.mu.Unlock()
+	s.chaos.spireLatency = 0
+	s.chaos.spireUnavailable = false
+	s.chaos.spireFailureRate = 0
+	s.chaos.expiresAt = time.Time{}
+	return nil
+}
+
+// lockDataStore holds an exclusive lock on the SQL DataStore from a separate
+// connection, so Tornjak's own queries contend for it as they would with
+// another writer
+func (s *Server) lockDataStore(d time.Duration) error {
+	driverName, dbFile, err := s.dataStoreFile()
+	if err != nil {
+		return err
+	}
+	database, err := sql.Open(driverName, dbFile)
+	if err != nil {
+		return errors.Errorf("could not open DataStore: %v", err)
+	}
+	ctx := context.Background()
+	conn, err := database.Conn(ctx)
+	if err != nil {
+		database.Close()
+		return errors.Errorf("could not open DataStore: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN EXCLUSIVE"); err != nil {
+		conn.Close()
+		database.Close()
+		return errors.Errorf("could not lock DataStore: %v", err)
+	}
+
+	s.chaos.mu.Lock()
+	s.chaos.dbLockedUntil = time.Now().Add(d)
+	s.chaos.mu.Unlock()
+	go func() {
+		time.Sleep(d)
+		if _, err := conn.ExecContext(ctx, "ROLLBACK"); err != nil {
+			fmt.Printf("chaos: could not release DataStore lock: %v\n", err)
+		}
+		conn.Close()
+		database.Close()
+	}()
+	return nil
+}
+
+// dataStoreFile returns the driver and file of the configured SQL DataStore
+func (s *Server) dataStoreFile() (string, string, error) {
+	if s.TornjakConfig == nil || s.TornjakConfig.Plugins == nil {
+		return "", "", errors.New("no DataStore configured")
+	}
+	pluginList, ok := (*s.TornjakConfig.Plugins).(*ast.ObjectList)
+	if !ok {
+		return "", "", errors.New("no DataStore configured")
+	}
+	for _, pluginObject := range pluginList.Items {
+		if len(pluginObject.Keys) != 2 {
+			continue
+		}
+		if pluginType, err := stringFromToken(pluginObject.Keys[0].Token); err != nil || pluginType != "DataStore" {
+			continue
+		}
+		key, data, err := getPluginConfig(pluginObject)
+		if err != nil || key != "sql" || data == nil {
+			break
+		}
+		var config pluginDataStoreSQL
+		if err := hcl.DecodeObject(&config, data); err != nil {
+			return "", "", errors.Errorf("Couldn't parse DB config: %v", err)
+		}
+		return config.Drivername, config.Filename, nil
+	}
+	return "", "", errors.New("no SQL DataStore configured")
+}
+
+func (s *Server) tornjakChaosGet(w http.ResponseWriter, r *http.Request) {
+	buf := new(strings.Builder)
+	n, err := io.Copy(buf, r.Body)
+	if err != nil {
+		emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	data := buf.String()
+	var input GetChaosRequest
+	if n == 0 {
+		input = GetChaosRequest{}
+	} else {
+		err := json.Unmarshal([]byte(data), &input)
+		if err != nil {
+			emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+			retError(w, emsg, http.StatusBadRequest)
+			return
+		}
+	}
+	ret, err := s.GetChaos(input)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	cors(w, r)
+	je := json.NewEncoder(w)
+	err = je.Encode(ret)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+}
+
+func (s *Server) tornjakChaosSet(w http.ResponseWriter, r *http.Request) {
+	buf := new(strings.Builder)
+	n, err := io.Copy(buf, r.Body)
+	if err != nil {
+		emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	data := buf.String()
+	var input SetChaosRequest
+	if n == 0 {
+		input = SetChaosRequest{}
+	} else {
+		err := json.Unmarshal([]byte(data), &input)
+		if err != nil {
+			emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+			retError(w, emsg, http.StatusBadRequest)
+			return
+		}
+	}
+	ret, err := s.SetChaos(input)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	cors(w, r)
+	je := json.NewEncoder(w)
+	err = je.Encode(ret)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+}
+
+func (s *Server) tornjakChaosReset(w http.ResponseWriter, r *http.Request) {
+	buf := new(strings.Builder)
+	n, err := io.Copy(buf, r.Body)
+	if err != nil {
+		emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	data := buf.String()
+	var input ResetChaosRequest
+	if n == 0 {
+		input = ResetChaosRequest{}
+	} else {
+		err := json.Unmarshal([]byte(data), &input)
+		if err != nil {
+			emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+			retError(w, emsg, http.StatusBadRequest)
+			return
+		}
+	}
+	err = s.ResetChaos(input)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	cors(w, r)
+	_, err = w.Write([]byte("SUCCESS"))
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+}
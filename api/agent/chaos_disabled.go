@@ -0,0 +1,17 @@
+//go:build !tornjak_dev
+
+package api
+
+import (
+	"github.com/gorilla/mux"
+	grpc "google.golang.org/grpc"
+)
+
+// fault injection is only available in dev builds, see chaos.go
+type chaosState struct{}
+
+func (s *Server) chaosInterceptors() []grpc.UnaryClientInterceptor {
+	return nil
+}
+
+func (s *Server) registerChaosRoutes(_ *mux.Router) {}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/cloudevents"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// value of the format query parameter of the event lists returning CloudEvents
+const eventFormatCloudEvents = "cloudevents"
+
+// cloudEventsQuery returns whether the format query parameter of an event
+// list requests CloudEvents rather than the events of the Tornjak API
+func cloudEventsQuery(r *http.Request) (bool, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "":
+		return false, nil
+	case eventFormatCloudEvents:
+		return true, nil
+	default:
+		return false, errors.Errorf("invalid format %q, expected %q", format, eventFormatCloudEvents)
+	}
+}
+
+type ListCloudEventsResponse tornjakTypes.List[tornjakTypes.CloudEvent]
+
+// ListAuditCloudEvents returns a page of the audit log as ListAuditEvents
+// does, each event as a CloudEvent
+func (s *Server) ListAuditCloudEvents(inp ListAuditEventsRequest) (*ListCloudEventsResponse, error) {
+	events, err := s.ListAuditEvents(inp)
+	if err != nil {
+		return nil, err
+	}
+	retVal := ListCloudEventsResponse{Items: []tornjakTypes.CloudEvent{}, NextCursor: events.NextCursor, Total: events.Total}
+	for _, e := range events.Items {
+		event, err := cloudevents.FromAuditEvent(e)
+		if err != nil {
+			return nil, err
+		}
+		retVal.Items = append(retVal.Items, event)
+	}
+	return &retVal, nil
+}
+
+// GetClusterHistoryCloudEvents returns a page of the history of a cluster as
+// GetClusterHistory does, each event as a CloudEvent
+func (s *Server) GetClusterHistoryCloudEvents(ctx context.Context, inp GetClusterHistoryRequest) (*ListCloudEventsResponse, error) {
+	events, err := s.GetClusterHistory(ctx, inp)
+	if err != nil {
+		return nil, err
+	}
+	retVal := ListCloudEventsResponse{Items: []tornjakTypes.CloudEvent{}, NextCursor: events.NextCursor, Total: events.Total}
+	for _, e := range events.Items {
+		event, err := cloudevents.FromClusterHistoryEvent(e)
+		if err != nil {
+			return nil, err
+		}
+		retVal.Items = append(retVal.Items, event)
+	}
+	return &retVal, nil
+}
+
+type GetEventSchemasRequest struct {
+	// type of the events, all types if empty
+	Type string `json:"type,omitempty"`
+}
+type GetEventSchemasResponse tornjakTypes.EventSchemaList
+
+// GetEventSchemas returns the schema registry of the CloudEvents of Tornjak,
+// the schema of one event type if inp.Type is set
+func (s *Server) GetEventSchemas(inp GetEventSchemasRequest) (*GetEventSchemasResponse, error) {
+	retVal := cloudevents.Schemas()
+	if inp.Type == "" {
+		return (*GetEventSchemasResponse)(&retVal), nil
+	}
+	for _, schema := range retVal.Schemas {
+		if schema.Type == inp.Type {
+			return &GetEventSchemasResponse{Schemas: []tornjakTypes.EventSchema{schema}}, nil
+		}
+	}
+	return nil, errors.Errorf("unknown event type %q", inp.Type)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// maximum number of clusters of a bulk creation or deletion, all created or
+// deleted in one transaction
+const (
+	maxClusterBulkCreate = 1000
+	maxClusterBulkDelete = 1000
+)
+
+type CreateClustersRequest struct {
+	Clusters []tornjakTypes.ClusterInfo `json:"clusters"`
+}
+type CreateClustersResponse struct {
+	// outcome of each cluster by position in the request, identified by name
+	Batch tornjakTypes.BatchResult `json:"batch"`
+}
+
+// CreateClusters registers many clusters in one transaction, e.g. imported
+// from an inventory; the clusters that are invalid or whose name exists are
+// reported in the result and left out, the others are created
+func (s *Server) CreateClusters(ctx context.Context, inp CreateClustersRequest) (*CreateClustersResponse, error) {
+	if s.proposer != nil {
+		return nil, errors.New("clusters are changed by change proposals; propose the creation of each cluster")
+	}
+	if len(inp.Clusters) == 0 {
+		return nil, errors.New("input missing mandatory field - Clusters")
+	}
+	if len(inp.Clusters) > maxClusterBulkCreate {
+		return nil, errors.Errorf("at most %d clusters may be created at once, got %d", maxClusterBulkCreate, len(inp.Clusters))
+	}
+
+	// REJECT invalid clusters, passing the others to the DB by position
+	items := []tornjakTypes.BatchItem{}
+	valid := []tornjakTypes.ClusterInfo{}
+	positions := []int{}
+	for i, cluster := range inp.Clusters {
+		cinfo, err := s.checkDefineCluster(RegisterClusterRequest{ClusterInstance: cluster})
+		if err != nil {
+			items = append(items, tornjakTypes.BatchItem{Index: i, ID: cluster.Name, Status: tornjakTypes.BatchItemFailed,
+				Code: tornjakTypes.BatchCodeInvalidArgument, Error: err.Error()})
+			continue
+		}
+		valid = append(valid, cinfo)
+		positions = append(positions, i)
+	}
+	if len(valid) > 0 {
+		created, err := s.dbAs(ctx).CreateClusterEntries(valid)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range created.Items {
+			item.Index = positions[item.Index]
+			items = append(items, item)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Index < items[j].Index })
+	batch := tornjakTypes.NewBatchResult()
+	for _, item := range items {
+		batch.Add(item)
+	}
+
+	user := ""
+	if u := userFromContext(ctx); u != nil {
+		user = u.Username
+	}
+	log.Printf("%d clusters created by %q in bulk: %d skipped, %d failed", batch.Succeeded, user, batch.Skipped, batch.Failed)
+	return &CreateClustersResponse{Batch: batch}, nil
+}
+
+type DeleteClustersRequest struct {
+	UIDs []string `json:"uids"`
+}
+type DeleteClustersResponse struct {
+	// outcome of each UID by position in the request
+	Batch tornjakTypes.BatchResult `json:"batch"`
+}
+
+// DeleteClusters deletes many clusters, named by UID, in one transaction; the
+// UIDs of no cluster and the protected clusters are reported in the result and
+// left, the others are deleted
+// the memberships of clusters with many agents are removed in the background
+// as for DeleteCluster
+func (s *Server) DeleteClusters(ctx context.Context, inp DeleteClustersRequest) (*DeleteClustersResponse, error) {
+	if s.proposer != nil {
+		return nil, errors.New("clusters are changed by change proposals; propose the deletion of each cluster")
+	}
+	if len(inp.UIDs) == 0 {
+		return nil, errors.New("input missing mandatory field - UIDs")
+	}
+	if len(inp.UIDs) > maxClusterBulkDelete {
+		return nil, errors.Errorf("at most %d clusters may be deleted at once, got %d", maxClusterBulkDelete, len(inp.UIDs))
+	}
+
+	var batch tornjakTypes.BatchResult
+	var err error
+	db := s.dbAs(ctx)
+	if deleter, ok := db.(agentdb.BackgroundClusterDeleter); ok && s.clusterDeletions != nil {
+		batch, err = deleter.DeleteClusterEntriesInBackground(inp.UIDs, s.clusterDeletions.threshold)
+		if err == nil && batch.Succeeded > 0 {
+			s.clusterDeletions.notify()
+		}
+	} else {
+		batch, err = db.DeleteClusterEntries(inp.UIDs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user := ""
+	if u := userFromContext(ctx); u != nil {
+		user = u.Username
+	}
+	log.Printf("%d clusters deleted by %q in bulk: %d failed", batch.Succeeded, user, batch.Failed)
+	return &DeleteClustersResponse{Batch: batch}, nil
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// defaults of the background deletion of clusters with many agents
+const (
+	defaultClusterDeletionThreshold     = 10000
+	defaultClusterDeletionBatchSize     = 1000
+	defaultClusterDeletionBatchInterval = 100 * time.Millisecond
+)
+
+// interval at which deletions whose last batch failed are resumed
+const clusterDeletionRetryInterval = time.Minute
+
+// clusterDeletions removes the memberships of deleted clusters with many
+// agents in the background, one batch at a time, so the DataStore is not
+// locked by a single large delete
+type clusterDeletions struct {
+	deleter agentdb.BackgroundClusterDeleter
+	// memberships from which deletes of clusters run in the background
+	threshold int
+	batchSize int
+	interval  time.Duration
+	// wakes the worker up upon a new deletion
+	wake chan struct{}
+
+	mu sync.Mutex
+	// error of the last batch of each deletion, by ID
+	errors map[int64]string
+}
+
+// newClusterDeletions returns the background deletion of the cluster_deletion
+// configuration, the defaults if config is nil
+func newClusterDeletions(deleter agentdb.BackgroundClusterDeleter, config *ClusterDeletionConfig) (*clusterDeletions, error) {
+	if config == nil {
+		config = &ClusterDeletionConfig{}
+	}
+	d := &clusterDeletions{
+		deleter:   deleter,
+		threshold: config.BackgroundThreshold,
+		batchSize: config.BatchSize,
+		wake:      make(chan struct{}, 1),
+		errors:    map[int64]string{},
+	}
+	if d.threshold == 0 {
+		d.threshold = defaultClusterDeletionThreshold
+	} else if d.threshold < 0 {
+		return nil, errors.Errorf("invalid 'background_threshold': %d", d.threshold)
+	}
+	if d.batchSize == 0 {
+		d.batchSize = defaultClusterDeletionBatchSize
+	} else if d.batchSize < 0 {
+		return nil, errors.Errorf("invalid 'batch_size': %d", d.batchSize)
+	}
+	var err error
+	d.interval, err = parseConfigDuration("batch_interval", config.BatchInterval, defaultClusterDeletionBatchInterval)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// notify wakes the worker up, unless it is already to wake up
+func (d *clusterDeletions) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *clusterDeletions) setError(id int64, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err == nil {
+		delete(d.errors, id)
+		return
+	}
+	d.errors[id] = err.Error()
+}
+
+// list returns the deletions not ended, with the error of their last batch
+func (d *clusterDeletions) list() (tornjakTypes.ClusterDeletionList, error) {
+	deletions, err := d.deleter.GetClusterDeletions()
+	if err != nil {
+		return tornjakTypes.ClusterDeletionList{}, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range deletions.Deletions {
+		deletions.Deletions[i].Error = d.errors[deletions.Deletions[i].ID]
+	}
+	return deletions, nil
+}
+
+// runClusterDeletions removes the memberships of the deletions not ended,
+// including those interrupted by a restart, until ctx is done
+func (s *Server) runClusterDeletions(ctx context.Context) {
+	d := s.clusterDeletions
+	retry := s.clock().NewTicker(clusterDeletionRetryInterval)
+	defer retry.Stop()
+	for {
+		deletions, err := d.deleter.GetClusterDeletions()
+		if err != nil {
+			log.Printf("WARNING: could not read cluster deletions: %v", err)
+		}
+		for _, deletion := range deletions.Deletions {
+			if !s.removeClusterMemberships(ctx, deletion) {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-retry.C():
+		}
+	}
+}
+
+// removeClusterMemberships removes the memberships of a deletion one batch at
+// a time, waiting the batch interval between two batches
+// returns false if ctx is done
+func (s *Server) removeClusterMemberships(ctx context.Context, deletion tornjakTypes.ClusterDeletion) bool {
+	d := s.clusterDeletions
+	ticker := s.clock().NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		remaining, err := d.deleter.DeleteClusterMemberships(deletion.ID, d.batchSize)
+		d.setError(deletion.ID, err)
+		if err != nil {
+			log.Printf("WARNING: could not remove memberships of deleted cluster %s, retrying in %s: %v",
+				deletion.Name, clusterDeletionRetryInterval, err)
+			return true
+		}
+		if remaining == 0 {
+			log.Printf("memberships of deleted cluster %s removed", deletion.Name)
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C():
+		}
+	}
+}
+
+type ListClusterDeletionsRequest struct{}
+type ListClusterDeletionsResponse tornjakTypes.ClusterDeletionList
+
+// ListClusterDeletions returns the deleted clusters whose memberships are
+// being removed in the background, with the memberships remaining
+func (s *Server) ListClusterDeletions(inp ListClusterDeletionsRequest) (*ListClusterDeletionsResponse, error) {
+	if s.clusterDeletions == nil {
+		return nil, errors.New("DataStore does not support background deletion of clusters")
+	}
+	retVal, err := s.clusterDeletions.list()
+	if err != nil {
+		return nil, err
+	}
+	return (*ListClusterDeletionsResponse)(&retVal), nil
+}
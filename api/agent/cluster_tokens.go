@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/authentication/authenticator"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// checkClusterScope returns an error if the user of ctx is restricted to a
+// cluster other than the cluster with the given name
+func (s *Server) checkClusterScope(ctx context.Context, name string) error {
+	u := userFromContext(ctx)
+	if u == nil || u.ClusterScope == nil {
+		return nil
+	}
+	scopeName, err := s.Db.GetClusterNameByUID(u.ClusterScope.ClusterUID)
+	if err != nil {
+		return errors.New("cluster of the cluster token does not exist")
+	}
+	// cluster names are case-insensitive as in the DB
+	if !strings.EqualFold(scopeName, name) {
+		return errors.Errorf("cluster token is restricted to cluster %s", scopeName)
+	}
+	return nil
+}
+
+// scopedClusters returns the clusters the user of ctx may read
+func scopedClusters(ctx context.Context, clusters []tornjakTypes.ClusterInfo) []tornjakTypes.ClusterInfo {
+	u := userFromContext(ctx)
+	if u == nil || u.ClusterScope == nil {
+		return clusters
+	}
+	for _, cluster := range clusters {
+		if cluster.UID == u.ClusterScope.ClusterUID {
+			return []tornjakTypes.ClusterInfo{cluster}
+		}
+	}
+	return []tornjakTypes.ClusterInfo{}
+}
+
+type CreateClusterTokenRequest struct {
+	Name        string `json:"name"`
+	ClusterUID  string `json:"clusterUid"`
+	Access      string `json:"access"`
+	Description string `json:"description"`
+}
+type CreateClusterTokenResponse tornjakTypes.ClusterTokenCredential
+
+// CreateClusterToken issues an API key restricted to reading or editing a single
+// cluster, for a controller syncing the agent membership of its own cluster
+// the API key is returned only once; the local DB keeps its hash
+func (s *Server) CreateClusterToken(ctx context.Context, inp CreateClusterTokenRequest) (*CreateClusterTokenResponse, error) {
+	if !serviceAccountNameRegexp.MatchString(inp.Name) {
+		return nil, fmt.Errorf("invalid cluster token name %q", inp.Name)
+	}
+	if len(inp.ClusterUID) == 0 {
+		return nil, errors.New("input missing mandatory field - ClusterUID")
+	}
+	if inp.Access != tornjakTypes.ClusterTokenRead && inp.Access != tornjakTypes.ClusterTokenWrite {
+		return nil, fmt.Errorf("invalid access %q, must be %s or %s", inp.Access, tornjakTypes.ClusterTokenRead, tornjakTypes.ClusterTokenWrite)
+	}
+	if _, err := s.Db.GetClusterNameByUID(inp.ClusterUID); err != nil {
+		return nil, err
+	}
+
+	keyBytes := make([]byte, 32)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return nil, fmt.Errorf("could not generate API key: %w", err)
+	}
+	apiKey := authenticator.ClusterTokenAPIKeyPrefix + hex.EncodeToString(keyBytes)
+
+	token := tornjakTypes.ClusterToken{
+		Name:         inp.Name,
+		ClusterUID:   inp.ClusterUID,
+		Access:       inp.Access,
+		Description:  inp.Description,
+		CreationTime: s.clock().Now().UTC().Format(time.RFC3339),
+	}
+	if u := userFromContext(ctx); u != nil {
+		token.CreatedBy = u.Username
+	}
+	if err := s.Db.CreateClusterToken(token, s.keyHasher.Hash(apiKey)); err != nil {
+		return nil, err
+	}
+
+	return &CreateClusterTokenResponse{
+		ClusterToken: token,
+		APIKey:       apiKey,
+	}, nil
+}
+
+type ListClusterTokensRequest struct{}
+type ListClusterTokensResponse tornjakTypes.ClusterTokenList
+
+// ListClusterTokens returns the cluster tokens and their clusters from the local DB
+func (s *Server) ListClusterTokens(inp ListClusterTokensRequest) (*ListClusterTokensResponse, error) {
+	retVal, err := s.Db.GetClusterTokens()
+	if err != nil {
+		return nil, err
+	}
+	return (*ListClusterTokensResponse)(&retVal), nil
+}
+
+type DeleteClusterTokenRequest struct {
+	Name string `json:"name"`
+}
+
+// DeleteClusterToken removes a cluster token, revoking its API key
+func (s *Server) DeleteClusterToken(inp DeleteClusterTokenRequest) error {
+	if len(inp.Name) == 0 {
+		return errors.New("input missing mandatory field - Name")
+	}
+	return s.Db.DeleteClusterToken(inp.Name)
+}
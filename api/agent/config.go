@@ -2,6 +2,7 @@ package api
 
 import (
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
@@ -13,7 +14,14 @@ import (
 
 	"github.com/spiffe/tornjak/pkg/agent/authentication/authenticator"
 	"github.com/spiffe/tornjak/pkg/agent/authorization"
+	"github.com/spiffe/tornjak/pkg/agent/cache"
+	"github.com/spiffe/tornjak/pkg/agent/clock"
 	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/db/mysql"
+	"github.com/spiffe/tornjak/pkg/agent/db/postgres"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+	"github.com/spiffe/tornjak/pkg/agent/webhook"
+	"github.com/spiffe/tornjak/pkg/encryption"
 )
 
 func stringFromToken(keyToken token.Token) (string, error) {
@@ -46,43 +54,130 @@ func getPluginConfig(plugin *ast.ObjectItem) (string, ast.Node, error) {
 	return pluginName, hclPluginConfig.PluginData, nil
 }
 
-// NewAgentsDB returns a new agents DB, given a DB connection string
-func NewAgentsDB(dbPlugin *ast.ObjectItem) (agentdb.AgentDB, error) {
+// built-in DataStore backends; others register themselves with agentdb.RegisterBackend
+func init() {
+	agentdb.RegisterBackend("sql", newSQLDataStore)
+	agentdb.RegisterBackend("inmem", newInMemoryDataStore)
+	agentdb.RegisterBackend("postgres", newPostgresDataStore)
+	agentdb.RegisterBackend("mysql", newMySQLDataStore)
+}
+
+// NewAgentsDB returns a new agents DB of the backend registered under the name of the DataStore plugin
+// creation and change times are stamped by c, the clock of the system if nil
+func NewAgentsDB(dbPlugin *ast.ObjectItem, c clock.Clock) (agentdb.AgentDB, error) {
 	key, data, err := getPluginConfig(dbPlugin)
 	if err != nil { // db is required config
 		return nil, errors.New("Required DataStore plugin not configured")
 	}
+	return agentdb.NewBackend(key, agentdb.BackendConfig{Data: data, Clock: c})
+}
 
-	switch key {
-	case "sql":
-		// check if data is defined
-		if data == nil {
-			return nil, errors.New("SQL DataStore plugin ('config > plugins > DataStore sql > plugin_data') not populated")
-		}
-		fmt.Printf("SQL DATASTORE DATA: %+v\n", data)
+func newSQLDataStore(cfg agentdb.BackendConfig) (agentdb.AgentDB, error) {
+	// check if data is defined
+	if cfg.Data == nil {
+		return nil, errors.New("SQL DataStore plugin ('config > plugins > DataStore sql > plugin_data') not populated")
+	}
+	fmt.Printf("SQL DATASTORE DATA: %+v\n", cfg.Data)
 
-		// TODO can probably add this to config
-		expBackoff := backoff.NewExponentialBackOff()
-		expBackoff.MaxElapsedTime = time.Second
+	// TODO can probably add this to config
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
 
-		// decode config to struct
-		var config pluginDataStoreSQL
-		if err := hcl.DecodeObject(&config, data); err != nil {
-			return nil, errors.Errorf("Couldn't parse DB config: %v", err)
-		}
+	// decode config to struct
+	var config pluginDataStoreSQL
+	if err := cfg.Decode(&config); err != nil {
+		return nil, err
+	}
 
-		// create db
-		drivername := config.Drivername
-		dbfile := config.Filename
+	// create db
+	drivername := config.Drivername
+	dbfile := config.Filename
 
-		db, err := agentdb.NewLocalSqliteDB(drivername, dbfile, expBackoff)
-		if err != nil {
-			return nil, errors.Errorf("Could not start DB driver %s, filename: %s: %v", drivername, dbfile, err)
-		}
-		return db, nil
-	default:
-		return nil, errors.Errorf("Couldn't create datastore")
+	opts := agentdb.SqliteOptions{
+		ClusterNameUniqueness: config.ClusterNameUniqueness,
+		Collation:             config.Collation,
+		Locale:                config.Locale,
+		SnapshotDir:           config.SnapshotDir,
+		Clock:                 cfg.Clock,
+	}
+
+	db, err := agentdb.NewLocalSqliteDBWithOptions(drivername, dbfile, expBackoff, opts)
+	if err != nil {
+		return nil, errors.Errorf("Could not start DB driver %s, filename: %s: %v", drivername, dbfile, err)
+	}
+	return db, nil
+}
+
+func newInMemoryDataStore(cfg agentdb.BackendConfig) (agentdb.AgentDB, error) {
+	var config pluginDataStoreInMemory
+	if err := cfg.Decode(&config); err != nil {
+		return nil, err
+	}
+	fmt.Println("WARNING: inmem DataStore in use - Tornjak metadata is lost when the process exits")
+
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+
+	opts := agentdb.SqliteOptions{
+		ClusterNameUniqueness: config.ClusterNameUniqueness,
+		Collation:             config.Collation,
+		Locale:                config.Locale,
+		SnapshotDir:           config.SnapshotDir,
+		Clock:                 cfg.Clock,
+	}
+	db, err := agentdb.NewInMemoryDB(expBackoff, opts)
+	if err != nil {
+		return nil, errors.Errorf("Could not start inmem DataStore: %v", err)
+	}
+	return db, nil
+}
+
+func newPostgresDataStore(cfg agentdb.BackendConfig) (agentdb.AgentDB, error) {
+	// the connection string may hold a password, so data is not printed
+	var config pluginDataStorePostgres
+	if err := cfg.Decode(&config); err != nil {
+		return nil, err
+	}
+
+	// conflicts with concurrent transactions of other replicas are retried for up to 5s
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = 5 * time.Second
+
+	opts := postgres.Options{
+		ClusterNameUniqueness: config.ClusterNameUniqueness,
+		Collation:             config.Collation,
+		Locale:                config.Locale,
+		Clock:                 cfg.Clock,
+	}
+	db, err := postgres.New(config.ConnectionString, expBackoff, opts)
+	if err != nil {
+		return nil, errors.Errorf("Could not start postgres DataStore: %v", err)
+	}
+	return db, nil
+}
+
+func newMySQLDataStore(cfg agentdb.BackendConfig) (agentdb.AgentDB, error) {
+	// the connection string may hold a password, so data is not printed
+	var config pluginDataStoreMySQL
+	if err := cfg.Decode(&config); err != nil {
+		return nil, err
+	}
+
+	// conflicts with concurrent transactions of other replicas are retried for up to 5s
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = 5 * time.Second
+
+	opts := mysql.Options{
+		ClusterNameUniqueness: config.ClusterNameUniqueness,
+		Collation:             config.Collation,
+		Locale:                config.Locale,
+		Clock:                 cfg.Clock,
+	}
+	db, err := mysql.New(config.ConnectionString, expBackoff, opts)
+	if err != nil {
+		return nil, errors.Errorf("Could not start mysql DataStore: %v", err)
 	}
+	return db, nil
 }
 
 // NewAuthenticator returns a new Authenticator
@@ -118,8 +213,12 @@ func NewAuthenticator(authenticatorPlugin *ast.ObjectItem) (authenticator.Authen
 	}
 }
 
+// default time between two reads of the RBAC policy file
+const defaultPolicyReloadInterval = 10 * time.Second
+
 // NewAuthorizer returns a new Authorizer
-func NewAuthorizer(authorizerPlugin *ast.ObjectItem) (authorization.Authorizer, error) {
+// the RBAC policy file is read again by the clock c, the clock of the system if nil
+func NewAuthorizer(authorizerPlugin *ast.ObjectItem, c clock.Clock) (authorization.Authorizer, error) {
 	key, data, _ := getPluginConfig(authorizerPlugin)
 
 	switch key {
@@ -136,35 +235,36 @@ func NewAuthorizer(authorizerPlugin *ast.ObjectItem) (authorization.Authorizer,
 			return nil, errors.Errorf("Couldn't parse Authorizer config: %v", err)
 		}
 
-		// decode into role list and apiMapping
-		roleList := make(map[string]string)
-		apiMapping := make(map[string][]string)
-		apiV1Mapping := make(map[string]map[string][]string)
+		// decode into the policy of the configuration
+		policy := authorization.Policy{Name: config.Name}
 		for _, role := range config.RoleList {
-			roleList[role.Name] = role.Desc
+			policy.Roles = append(policy.Roles, authorization.PolicyRole{Name: role.Name, Desc: role.Desc})
 			// print warning for empty string
 			if role.Name == "" {
 				fmt.Println("WARNING: using the empty string for an API enables access to all authenticated users")
 			}
 		}
 		for _, api := range config.APIRoleMappings {
-			apiMapping[api.Name] = api.AllowedRoles
+			policy.API = append(policy.API, authorization.PolicyAPIRule{Name: api.Name, AllowedRoles: api.AllowedRoles})
 			fmt.Printf("API name: %s, Allowed Roles: %s \n", api.Name, api.AllowedRoles)
 		}
 		for _, apiV1 := range config.APIv1RoleMappings {
 			arr := strings.Split(apiV1.Name, " ")
+			if len(arr) != 2 {
+				return nil, errors.Errorf("Couldn't configure Authorizer: APIv1 %q is not named by method and path", apiV1.Name)
+			}
 			apiV1.Method = arr[0]
 			apiV1.Path = arr[1]
 			fmt.Printf("API V1 method: %s, API V1 path: %s, API V1 allowed roles: %s \n", apiV1.Method, apiV1.Path, apiV1.AllowedRoles)
-			if _, ok := apiV1Mapping[apiV1.Path]; ok {
-				apiV1Mapping[apiV1.Path][apiV1.Method] = apiV1.AllowedRoles
-			} else {
-				apiV1Mapping[apiV1.Path] = map[string][]string{apiV1.Method: apiV1.AllowedRoles}
-			}
+			policy.APIv1 = append(policy.APIv1, authorization.PolicyAPIRule{Name: apiV1.Name, AllowedRoles: apiV1.AllowedRoles})
 		}
-		fmt.Printf("API V1 Mapping: %+v\n", apiV1Mapping)
 
-		authorizer, err := authorization.NewRBACAuthorizer(config.Name, roleList, apiMapping, apiV1Mapping)
+		// roles and API mappings of the policy file are added to those of the configuration
+		reloadInterval, err := parseConfigDuration("policy_reload_interval", config.PolicyReloadInterval, defaultPolicyReloadInterval)
+		if err != nil {
+			return nil, errors.Errorf("Couldn't configure Authorizer: %v", err)
+		}
+		authorizer, err := authorization.NewPolicyAuthorizer(policy, config.PolicyFile, reloadInterval, c)
 		if err != nil {
 			return nil, errors.Errorf("Couldn't configure Authorizer: %v", err)
 		}
@@ -174,6 +274,159 @@ func NewAuthorizer(authorizerPlugin *ast.ObjectItem) (authorization.Authorizer,
 	}
 }
 
+// default prefix of keys stored in a shared Redis cache
+const defaultRedisKeyPrefix = "tornjak:"
+
+// NewCache returns a new Cache
+// entries of the memory cache expire by c, the clock of the system if nil
+func NewCache(cachePlugin *ast.ObjectItem, c clock.Clock) (cache.Cache, error) {
+	key, data, _ := getPluginConfig(cachePlugin)
+
+	switch key {
+	case "memory":
+		return cache.NewMemoryCacheWithClock(c), nil
+	case "redis":
+		// check if data is defined
+		if data == nil {
+			return nil, errors.New("Redis Cache plugin ('config > plugins > Cache redis > plugin_data') not populated")
+		}
+
+		// decode config to struct
+		var config pluginCacheRedis
+		if err := hcl.DecodeObject(&config, data); err != nil {
+			return nil, errors.Errorf("Couldn't parse Cache config: %v", err)
+		}
+		if config.Address == "" {
+			return nil, errors.New("Redis Cache plugin missing mandatory field - address")
+		}
+		if config.KeyPrefix == "" {
+			config.KeyPrefix = defaultRedisKeyPrefix
+		}
+		fmt.Printf("Cache Redis Plugin address: %s, key prefix: %s\n", config.Address, config.KeyPrefix)
+
+		redisCache, err := cache.NewRedisCache(config.Address, config.Password, config.DB, config.KeyPrefix)
+		if err != nil {
+			return nil, errors.Errorf("Couldn't configure Cache: %v", err)
+		}
+		return redisCache, nil
+	default:
+		return nil, errors.Errorf("Invalid option for Cache named %s", key)
+	}
+}
+
+// name the pepper of the hashes of API keys and bootstrap tokens is stored under in the DataStore
+const keyHashPepperName = "key-hash-pepper"
+
+// NewEncryption returns a new encryption Provider for sensitive material stored by Tornjak
+func NewEncryption(encryptionPlugin *ast.ObjectItem) (encryption.Provider, error) {
+	key, data, _ := getPluginConfig(encryptionPlugin)
+
+	switch key {
+	case "local":
+		// check if data is defined
+		if data == nil {
+			return nil, errors.New("local Encryption plugin ('config > plugins > Encryption local > plugin_data') not populated")
+		}
+
+		// decode config to struct
+		var config pluginEncryptionLocal
+		if err := hcl.DecodeObject(&config, data); err != nil {
+			return nil, errors.Errorf("Couldn't parse Encryption config: %v", err)
+		}
+		keys := make(map[string][]byte)
+		for _, k := range config.Keys {
+			keyBytes, err := encryption.ReadKeyFile(k.File)
+			if err != nil {
+				return nil, errors.Errorf("Couldn't configure Encryption: %v", err)
+			}
+			keys[k.ID] = keyBytes
+		}
+		fmt.Printf("Encryption local Plugin primary key: %s, keys configured: %d\n", config.PrimaryKey, len(keys))
+
+		provider, err := encryption.NewLocalAESProvider(config.PrimaryKey, keys)
+		if err != nil {
+			return nil, errors.Errorf("Couldn't configure Encryption: %v", err)
+		}
+		return provider, nil
+	case "vault_transit":
+		// check if data is defined
+		if data == nil {
+			return nil, errors.New("Vault transit Encryption plugin ('config > plugins > Encryption vault_transit > plugin_data') not populated")
+		}
+
+		// decode config to struct
+		var config pluginEncryptionVaultTransit
+		if err := hcl.DecodeObject(&config, data); err != nil {
+			return nil, errors.Errorf("Couldn't parse Encryption config: %v", err)
+		}
+		// keep the token out of config files if possible
+		if config.Token == "" {
+			config.Token = os.Getenv("VAULT_TOKEN")
+		}
+		fmt.Printf("Encryption Vault transit Plugin address: %s, key: %s\n", config.Address, config.KeyName)
+
+		provider, err := encryption.NewVaultTransitProvider(config.Address, config.Token, config.Mount, config.KeyName)
+		if err != nil {
+			return nil, errors.Errorf("Couldn't configure Encryption: %v", err)
+		}
+		return provider, nil
+	case "aws_kms":
+		// check if data is defined
+		if data == nil {
+			return nil, errors.New("AWS KMS Encryption plugin ('config > plugins > Encryption aws_kms > plugin_data') not populated")
+		}
+
+		// decode config to struct
+		var config pluginEncryptionAWSKMS
+		if err := hcl.DecodeObject(&config, data); err != nil {
+			return nil, errors.Errorf("Couldn't parse Encryption config: %v", err)
+		}
+		// keep the credentials out of config files if possible
+		if config.AccessKeyID == "" && config.SecretAccessKey == "" {
+			config.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+			config.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+			config.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+		}
+		if config.Region == "" {
+			config.Region = os.Getenv("AWS_REGION")
+		}
+		fmt.Printf("Encryption AWS KMS Plugin region: %s, key: %s\n", config.Region, config.KeyID)
+
+		provider, err := encryption.NewAWSKMSProvider(config.Region, config.KeyID, config.Endpoint, encryption.AWSCredentials{
+			AccessKeyID:     config.AccessKeyID,
+			SecretAccessKey: config.SecretAccessKey,
+			SessionToken:    config.SessionToken,
+		})
+		if err != nil {
+			return nil, errors.Errorf("Couldn't configure Encryption: %v", err)
+		}
+		return provider, nil
+	case "gcp_kms":
+		// check if data is defined
+		if data == nil {
+			return nil, errors.New("GCP KMS Encryption plugin ('config > plugins > Encryption gcp_kms > plugin_data') not populated")
+		}
+
+		// decode config to struct
+		var config pluginEncryptionGCPKMS
+		if err := hcl.DecodeObject(&config, data); err != nil {
+			return nil, errors.Errorf("Couldn't parse Encryption config: %v", err)
+		}
+		if config.CredentialsFile == "" {
+			config.CredentialsFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		}
+		fmt.Printf("Encryption GCP KMS Plugin key: %s\n", config.KeyName)
+
+		provider, err := encryption.NewGCPKMSProvider(config.KeyName, config.CredentialsFile, config.Endpoint)
+		if err != nil {
+			return nil, errors.Errorf("Couldn't configure Encryption: %v", err)
+		}
+		return provider, nil
+	default:
+		return nil, errors.Errorf("Invalid option for Encryption named %s", key)
+	}
+}
+
 func (s *Server) VerifyConfiguration() error {
 	if s.TornjakConfig == nil {
 		return errors.New("config not given")
@@ -183,9 +436,13 @@ func (s *Server) VerifyConfiguration() error {
 	if s.TornjakConfig.Server == nil { // must be defined
 		return errors.New("'config > server' field not defined")
 	}
-	if s.TornjakConfig.Server.SPIRESocket == "" {
+	// the SPIRE socket is not used in metadata-only mode
+	if s.TornjakConfig.Server.SPIRESocket == "" && !s.TornjakConfig.Server.MetadataOnly {
 		return errors.New("'config > server > spire_socket_path' field not defined")
 	}
+	if err := verifyMetadataOnly(s.TornjakConfig.Server); err != nil {
+		return err
+	}
 
 	/*  Verify Plugins  */
 	if s.TornjakConfig.Plugins == nil {
@@ -198,6 +455,10 @@ func (s *Server) ConfigureDefaults() error {
 	// no authorization is a default
 	s.Authenticator = authenticator.NewNullAuthenticator()
 	s.Authorizer = authorization.NewNullAuthorizer()
+	// in-memory cache local to this instance is a default
+	s.Cache = cache.NewMemoryCacheWithClock(s.Clock)
+	// sensitive material is stored unencrypted unless configured
+	s.Encryption = encryption.NewNullProvider()
 	return nil
 }
 
@@ -212,6 +473,90 @@ func (s *Server) Configure() error {
 	serverConfig := s.TornjakConfig.Server
 	s.SpireServerAddr = serverConfig.SPIRESocket // for convenience
 
+	if callsConfig := serverConfig.SPIRECallsConfig; callsConfig != nil && callsConfig.MaxConcurrent > 0 {
+		queueTimeout := defaultSPIREQueueTimeout
+		if callsConfig.QueueTimeout != "" {
+			queueTimeout, err = time.ParseDuration(callsConfig.QueueTimeout)
+			if err != nil {
+				return errors.Errorf("Tornjak Config error: invalid 'config > server > spire_calls > queue_timeout': %v", err)
+			}
+		}
+		s.spireLimiter = newSPIRECallLimiter(callsConfig.MaxConcurrent, queueTimeout)
+	}
+
+	if logConfig := serverConfig.RequestLogConfig; logConfig != nil {
+		s.requestLogger, err = newRequestLogger(logConfig.RedactPaths, logConfig.LogBodies, logConfig.MaxBodyBytes)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > request_log > redact_paths': %v", err)
+		}
+	}
+
+	if mirrorConfig := serverConfig.SPIREMirrorConfig; mirrorConfig != nil {
+		syncInterval := defaultMirrorSyncInterval
+		if mirrorConfig.SyncInterval != "" {
+			syncInterval, err = time.ParseDuration(mirrorConfig.SyncInterval)
+			if err != nil || syncInterval <= 0 {
+				return errors.Errorf("Tornjak Config error: invalid 'config > server > spire_mirror > sync_interval': %q", mirrorConfig.SyncInterval)
+			}
+		}
+		// by default data is stale once a few syncs in a row have failed
+		staleAfter := 3 * syncInterval
+		if mirrorConfig.StaleAfter != "" {
+			staleAfter, err = time.ParseDuration(mirrorConfig.StaleAfter)
+			if err != nil || staleAfter <= 0 {
+				return errors.Errorf("Tornjak Config error: invalid 'config > server > spire_mirror > stale_after': %q", mirrorConfig.StaleAfter)
+			}
+		}
+		s.spireMirror = newSPIREMirror(syncInterval, staleAfter)
+	}
+
+	schemas := []tornjakTypes.ClusterExtensionSchema{}
+	for _, extConfig := range serverConfig.ClusterExtensions {
+		schema := tornjakTypes.ClusterExtensionSchema{PlatformType: extConfig.PlatformType}
+		for _, field := range extConfig.Fields {
+			schema.Fields = append(schema.Fields, tornjakTypes.ClusterExtensionField{
+				Name:     field.Name,
+				Type:     field.Type,
+				Required: field.Required,
+				Enum:     field.Enum,
+			})
+		}
+		schemas = append(schemas, schema)
+	}
+	s.clusterExtensions, err = tornjakTypes.NewClusterExtensionSchemas(schemas)
+	if err != nil {
+		return errors.Errorf("Tornjak Config error: invalid 'config > server > cluster_extensions': %v", err)
+	}
+
+	if metadataConfig := serverConfig.ClusterMetadataConfig; metadataConfig != nil {
+		if (metadataConfig.Schema == "") == (metadataConfig.SchemaFile == "") {
+			return errors.New("Tornjak Config error: 'config > server > cluster_metadata' requires one of schema or schema_file")
+		}
+		schema := []byte(metadataConfig.Schema)
+		if metadataConfig.SchemaFile != "" {
+			schema, err = os.ReadFile(metadataConfig.SchemaFile)
+			if err != nil {
+				return errors.Errorf("Tornjak Config error: cannot read 'config > server > cluster_metadata > schema_file': %v", err)
+			}
+		}
+		s.clusterMetadata, err = tornjakTypes.NewClusterMetadataSchema(schema, metadataConfig.Required)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > cluster_metadata': %v", err)
+		}
+	}
+
+	if policyConfig := serverConfig.ObjectPolicyConfig; policyConfig != nil {
+		s.objectPolicies, err = tornjakTypes.NewObjectPolicies(tornjakTypes.ObjectPolicy{
+			ClusterName:   namePolicy(policyConfig.ClusterName),
+			LabelKey:      namePolicy(policyConfig.LabelKey),
+			LabelValue:    namePolicy(policyConfig.LabelValue),
+			NoteMaxLength: policyConfig.NoteMaxLength,
+		})
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > object_policy': %v", err)
+		}
+	}
+
 	/*  Configure Plugins  */
 	// configure defaults for optional plugins, reconfigured if given
 	// TODO maybe we should not have this step at all
@@ -228,6 +573,8 @@ func (s *Server) Configure() error {
 	}
 
 	// iterate over plugin list
+	// names of the configured plugins by type, reported by telemetry
+	plugins := make(map[string]string)
 
 	for _, pluginObject := range pluginList.Items {
 		if len(pluginObject.Keys) != 2 {
@@ -238,12 +585,15 @@ func (s *Server) Configure() error {
 		if err != nil {
 			return fmt.Errorf("invalid plugin type key %q: %w", pluginObject.Keys[0].Token.Text, err)
 		}
+		if pluginName, err := stringFromToken(pluginObject.Keys[1].Token); err == nil {
+			plugins[pluginType] = pluginName
+		}
 
 		// create plugin component based on type
 		switch pluginType {
 		// configure datastore
 		case "DataStore":
-			s.Db, err = NewAgentsDB(pluginObject)
+			s.Db, err = NewAgentsDB(pluginObject, s.Clock)
 			if err != nil {
 				return errors.Errorf("Cannot configure datastore plugin: %v", err)
 			}
@@ -255,13 +605,278 @@ func (s *Server) Configure() error {
 			}
 		// configure Authorizer
 		case "Authorizer":
-			s.Authorizer, err = NewAuthorizer(pluginObject)
+			s.Authorizer, err = NewAuthorizer(pluginObject, s.Clock)
 			if err != nil {
 				return errors.Errorf("Cannot configure Authorizer plugin: %v", err)
 			}
+			s.policyAuthorizer, _ = s.Authorizer.(*authorization.PolicyAuthorizer)
+		// configure Cache
+		case "Cache":
+			s.Cache, err = NewCache(pluginObject, s.Clock)
+			if err != nil {
+				return errors.Errorf("Cannot configure Cache plugin: %v", err)
+			}
+		// configure Encryption
+		case "Encryption":
+			s.Encryption, err = NewEncryption(pluginObject)
+			if err != nil {
+				return errors.Errorf("Cannot configure Encryption plugin: %v", err)
+			}
 		}
 		// TODO Handle when multiple plugins configured
 	}
+	// requests are authorized by a configured Authorizer rather than allowed to all
+	_, nullAuthorizer := s.Authorizer.(*authorization.NullAuthorizer)
+
+	// API keys and bootstrap tokens are hashed with a pepper kept encrypted by the Encryption plugin
+	if _, nullEncryption := s.Encryption.(*encryption.NullProvider); !nullEncryption && s.Db != nil {
+		secretStore, ok := s.Db.(agentdb.SecretStore)
+		if !ok {
+			return errors.New("Tornjak Config error: the Encryption plugin requires a DataStore plugin storing secrets")
+		}
+		pepper, err := encryption.LoadPepper(secretStore, s.Encryption, keyHashPepperName)
+		if err != nil {
+			return errors.Errorf("Cannot configure Encryption plugin: %v", err)
+		}
+		s.keyHasher = encryption.NewHasher(pepper)
+	}
+
+	// decisions of the configured Authorizer are cached in the Cache plugin
+	if cacheConfig := serverConfig.AuthorizationCacheConfig; cacheConfig != nil {
+		ttl := defaultAuthorizationCacheTTL
+		if cacheConfig.TTL != "" {
+			ttl, err = time.ParseDuration(cacheConfig.TTL)
+			if err != nil {
+				return errors.Errorf("Tornjak Config error: invalid 'config > server > authorization_cache > ttl': %v", err)
+			}
+		}
+		s.authzCache, err = authorization.NewCachingAuthorizer(s.Authorizer, s.Cache, ttl, requestScope)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > authorization_cache': %v", err)
+		}
+		s.Authorizer = s.authzCache
+	}
+
+	// requests to webhook receivers must be signed, with nonces kept in the Cache plugin
+	if webhookConfig := serverConfig.WebhookVerificationConfig; webhookConfig != nil {
+		maxSkew := defaultWebhookMaxSkew
+		if webhookConfig.MaxSkew != "" {
+			maxSkew, err = time.ParseDuration(webhookConfig.MaxSkew)
+			if err != nil {
+				return errors.Errorf("Tornjak Config error: invalid 'config > server > webhook_verification > max_skew': %v", err)
+			}
+		}
+		s.webhookVerifier, err = webhook.NewVerifier(webhookConfig.Secrets, maxSkew, s.Cache, s.Clock)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > webhook_verification': %v", err)
+		}
+	}
+
+	// service account and cluster token API keys are accepted alongside the configured Authenticator
+	// cluster tokens are only authorized for the routes of their cluster
+	// API keys are not accepted if the DataStore does not store them
+	if s.Db != nil {
+		if agentdb.Supports(s.Db, agentdb.CapabilityServiceAccounts) {
+			s.Authenticator = authenticator.NewServiceAccountAuthenticator(s.Db, s.keyHasher, s.Authenticator)
+		}
+		if agentdb.Supports(s.Db, agentdb.CapabilityClusterTokens) {
+			s.Authenticator = authenticator.NewClusterTokenAuthenticator(s.Db, s.keyHasher, s.Authenticator)
+			s.Authorizer = authorization.NewClusterScopeAuthorizer(s.Authorizer)
+		}
+	}
+
+	// the desired state is reconciled into the DataStore
+	if stateConfig := serverConfig.DesiredStateConfig; stateConfig != nil {
+		if s.Db == nil {
+			return errors.New("Tornjak Config error: 'config > server > desired_state' requires a DataStore plugin")
+		}
+		s.reconciler, err = s.newReconciler(stateConfig)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > desired_state': %v", err)
+		}
+	}
+
+	// clusters of a primary Tornjak are replicated into the DataStore
+	if replicationConfig := serverConfig.ReplicationConfig; replicationConfig != nil {
+		if s.Db == nil {
+			return errors.New("Tornjak Config error: 'config > server > replication' requires a DataStore plugin")
+		}
+		s.replicator, err = s.newReplicator(replicationConfig)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > replication': %v", err)
+		}
+	}
+
+	// cluster changes are committed to git instead of the DataStore
+	if proposalsConfig := serverConfig.ChangeProposalsConfig; proposalsConfig != nil {
+		if s.Db == nil {
+			return errors.New("Tornjak Config error: 'config > server > change_proposals' requires a DataStore plugin")
+		}
+		s.proposer, err = newProposer(proposalsConfig, s.Clock)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > change_proposals': %v", err)
+		}
+	}
+
+	// TTLs of SPIRE entries are checked against the policy on request
+	if policyConfig := serverConfig.EntryTTLPolicyConfig; policyConfig != nil {
+		s.ttlPolicy, err = newTTLPolicy(policyConfig)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > entry_ttl_policy': %v", err)
+		}
+	}
+
+	// bundles of federated trust domains are checked against their endpoints
+	if monitorConfig := serverConfig.BundleMonitorConfig; monitorConfig != nil {
+		if s.Db == nil {
+			return errors.New("Tornjak Config error: 'config > server > bundle_monitor' requires a DataStore plugin")
+		}
+		if !agentdb.Supports(s.Db, agentdb.CapabilityBundleFreshness) {
+			return errors.Errorf("Tornjak Config error: 'config > server > bundle_monitor' requires a DataStore plugin storing %s", agentdb.CapabilityBundleFreshness)
+		}
+		s.bundleMonitor, err = s.newBundleMonitor(monitorConfig)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > bundle_monitor': %v", err)
+		}
+	}
+
+	// the bundle of the trust domain is served to federation partners on its own port
+	if endpointConfig := serverConfig.BundleEndpointConfig; endpointConfig != nil {
+		s.bundleEndpoint, err = s.newBundleEndpoint(endpointConfig)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > bundle_endpoint': %v", err)
+		}
+	}
+
+	// profiles and runtime settings are served to admins only if enabled
+	if diagnosticsConfig := serverConfig.DiagnosticsConfig; diagnosticsConfig != nil {
+		// profiles, heap dumps and runtime settings must not be served to anyone reaching the port
+		if nullAuthorizer {
+			return errors.New("Tornjak Config error: 'config > server > diagnostics' requires an Authorizer plugin restricting the diagnostics to admins")
+		}
+		s.diagnostics, err = newDiagnostics(diagnosticsConfig)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > diagnostics': %v", err)
+		}
+	}
+
+	// entries scheduled for removal are deleted once due, after notifying their owners
+	if lifecycleConfig := serverConfig.EntryLifecycleConfig; lifecycleConfig != nil {
+		if s.Db == nil {
+			return errors.New("Tornjak Config error: 'config > server > entry_lifecycle' requires a DataStore plugin")
+		}
+		if !agentdb.Supports(s.Db, agentdb.CapabilityEntryLifecycles) {
+			return errors.Errorf("Tornjak Config error: 'config > server > entry_lifecycle' requires a DataStore plugin storing %s", agentdb.CapabilityEntryLifecycles)
+		}
+		s.lifecycleEnforcer, err = s.newLifecycleEnforcer(lifecycleConfig)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > entry_lifecycle': %v", err)
+		}
+	}
+
+	// join tokens are issued to provisioning systems and tracked until used or expired
+	if brokerConfig := serverConfig.BootstrapBrokerConfig; brokerConfig != nil {
+		if s.Db == nil {
+			return errors.New("Tornjak Config error: 'config > server > bootstrap_broker' requires a DataStore plugin")
+		}
+		if !agentdb.Supports(s.Db, agentdb.CapabilityBootstrapTokens) {
+			return errors.Errorf("Tornjak Config error: 'config > server > bootstrap_broker' requires a DataStore plugin storing %s", agentdb.CapabilityBootstrapTokens)
+		}
+		s.bootstrapBroker, err = s.newBootstrapBroker(brokerConfig)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > bootstrap_broker': %v", err)
+		}
+	}
+
+	// incomplete steps of partially failed operations are retried in the background
+	// the retry_queue block only tunes the defaults; there is no queue if the DataStore cannot store it
+	if s.Db != nil && agentdb.Supports(s.Db, agentdb.CapabilityRetryQueue) {
+		s.retryQueue, err = s.newRetryQueue(serverConfig.RetryQueueConfig)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > retry_queue': %v", err)
+		}
+	} else if serverConfig.RetryQueueConfig != nil {
+		if s.Db == nil {
+			return errors.New("Tornjak Config error: 'config > server > retry_queue' requires a DataStore plugin")
+		}
+		return errors.Errorf("Tornjak Config error: 'config > server > retry_queue' requires a DataStore plugin storing %s", agentdb.CapabilityRetryQueue)
+	}
+
+	// aggregates of the home page are refreshed in the background
+	// the dashboard block only tunes the defaults
+	if s.Db != nil {
+		s.dashboard, err = newDashboard(serverConfig.DashboardConfig)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > dashboard': %v", err)
+		}
+	} else if serverConfig.DashboardConfig != nil {
+		return errors.New("Tornjak Config error: 'config > server > dashboard' requires a DataStore plugin")
+	}
+
+	// usage is only sent if telemetry is enabled, and can always be previewed
+	s.telemetry, err = s.newTelemetry(serverConfig.TelemetryConfig, plugins)
+	if err != nil {
+		return errors.Errorf("Tornjak Config error: invalid 'config > server > telemetry': %v", err)
+	}
+
+	// requests of metadata fail fast while the DataStore is unavailable
+	// the datastore_health block only tunes the defaults
+	if pinger, ok := s.Db.(agentdb.Pinger); ok {
+		s.datastoreHealth, err = newDatastoreHealth(pinger, serverConfig.DatastoreHealthConfig)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > datastore_health': %v", err)
+		}
+	} else if serverConfig.DatastoreHealthConfig != nil {
+		return errors.New("Tornjak Config error: 'config > server > datastore_health' requires a DataStore plugin")
+	}
+
+	// inconsistent rows of the DataStore are reported in the background
+	// the integrity_check block only tunes the defaults
+	if checker, ok := s.Db.(agentdb.IntegrityChecker); ok {
+		s.integrityCheck, err = newIntegrityCheck(checker, serverConfig.IntegrityCheckConfig)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > integrity_check': %v", err)
+		}
+	} else if serverConfig.IntegrityCheckConfig != nil {
+		return errors.New("Tornjak Config error: 'config > server > integrity_check' requires a DataStore plugin")
+	}
+
+	// the memberships of deleted clusters with many agents are removed in the
+	// background; the cluster_deletion block only tunes the defaults
+	if deleter, ok := s.Db.(agentdb.BackgroundClusterDeleter); ok {
+		s.clusterDeletions, err = newClusterDeletions(deleter, serverConfig.ClusterDeletionConfig)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > cluster_deletion': %v", err)
+		}
+	} else if serverConfig.ClusterDeletionConfig != nil {
+		return errors.New("Tornjak Config error: 'config > server > cluster_deletion' requires a DataStore plugin supporting background deletion")
+	}
+
+	// the readiness probe waits for the first fill of the caches
+	if warmUpConfig := serverConfig.WarmUpConfig; warmUpConfig != nil {
+		s.warmUp, err = newWarmUp(warmUpConfig)
+		if err != nil {
+			return errors.Errorf("Tornjak Config error: invalid 'config > server > warm_up': %v", err)
+		}
+	}
+
+	// entries can always be deleted by filter, the entry_bulk_delete block only tunes the defaults
+	s.entryDeleter, err = s.newEntryDeleter(serverConfig.EntryBulkDeleteConfig)
+	if err != nil {
+		return errors.Errorf("Tornjak Config error: invalid 'config > server > entry_bulk_delete': %v", err)
+	}
 
 	return nil
 }
+
+// namePolicy returns the name policy of a block of the object_policy configuration
+func namePolicy(config *NamePolicyConfig) tornjakTypes.NamePolicy {
+	if config == nil {
+		return tornjakTypes.NamePolicy{}
+	}
+	return tornjakTypes.NamePolicy{
+		MaxLength:        config.MaxLength,
+		Pattern:          config.Pattern,
+		ReservedPrefixes: config.ReservedPrefixes,
+	}
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	backoff "github.com/cenkalti/backoff/v4"
+	"github.com/hashicorp/hcl"
+
+	"github.com/spiffe/tornjak/pkg/agent/authentication/authenticator"
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/encryption"
+)
+
+// partialDB is a DataStore storing none of the optional capabilities, as the
+// postgres and mysql DataStores
+type partialDB struct {
+	agentdb.AgentDB
+}
+
+func (partialDB) Supports(capability string) bool {
+	return false
+}
+
+func init() {
+	agentdb.RegisterBackend("partial", func(agentdb.BackendConfig) (agentdb.AgentDB, error) {
+		db, err := agentdb.NewInMemoryDB(backoff.NewExponentialBackOff(), agentdb.SqliteOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return partialDB{db}, nil
+	})
+}
+
+// configure configures a server from the HCL of a Tornjak config
+func configure(t *testing.T, config string) (*Server, error) {
+	c := &TornjakConfig{}
+	if err := hcl.Decode(&c, config); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{TornjakConfig: c}
+	return s, s.Configure()
+}
+
+// TestConfigureDiagnostics checks diagnostics are only served with an
+// Authorizer plugin configured
+func TestConfigureDiagnostics(t *testing.T) {
+	const server = `
+server {
+  spire_socket_path = "unix:///tmp/spire-server/private/api.sock"
+  http { port = 10000 }
+  diagnostics {}
+}
+`
+	// CHECK diagnostics are refused when all requests are allowed
+	_, err := configure(t, server+`plugins {}`)
+	if err == nil || !strings.Contains(err.Error(), "'config > server > diagnostics' requires an Authorizer plugin") {
+		t.Fatalf("Expected diagnostics to require an Authorizer, got %v", err)
+	}
+
+	// CHECK diagnostics are served with an Authorizer
+	s, err := configure(t, server+`
+plugins {
+  Authorizer "RBAC" {
+    plugin_data {
+      name = "Admin Policy"
+      role "admin" { desc = "admin person" }
+      APIv1 "GET /api/v1/tornjak/debug/vars" { allowed_roles = ["admin"] }
+    }
+  }
+}
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if s.diagnostics == nil {
+		t.Fatal("Expected diagnostics to be configured")
+	}
+}
+
+// TestConfigureEncryption checks API keys are hashed with a pepper kept by
+// the Encryption plugin, the same across restarts
+func TestConfigureEncryption(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "k1.key")
+	if err := os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(make([]byte, 32))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := fmt.Sprintf(`
+server {
+  spire_socket_path = "unix:///tmp/spire-server/private/api.sock"
+  http { port = 10000 }
+}
+plugins {
+  DataStore "sql" {
+    plugin_data {
+      drivername = "sqlite3"
+      filename = %q
+    }
+  }
+  Encryption "local" {
+    plugin_data {
+      primary_key = "k1"
+      key "k1" { file = %q }
+    }
+  }
+}
+`, filepath.Join(dir, "tornjak.sqlite3"), keyFile)
+
+	// ATTEMPT configure the server twice on the same DataStore
+	first, err := configure(t, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := configure(t, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK API keys are hashed with the same pepper
+	hash := first.keyHasher.Hash("tjk_key")
+	if hash == encryption.NewHasher(nil).Hash("tjk_key") {
+		t.Fatal("Expected API keys hashed with a pepper")
+	}
+	if got := second.keyHasher.Hash("tjk_key"); got != hash {
+		t.Fatalf("Expected the same hash after a restart, got %s and %s", hash, got)
+	}
+}
+
+// TestConfigureUnsupportedCapabilities checks the features relying on
+// capabilities the DataStore does not store are refused or disabled
+func TestConfigureUnsupportedCapabilities(t *testing.T) {
+	const server = `
+server {
+  spire_socket_path = "unix:///tmp/spire-server/private/api.sock"
+  http { port = 10000 }
+  %s
+}
+plugins {
+  DataStore "partial" {}
+}
+`
+	// CHECK the blocks relying on unsupported capabilities are refused
+	for block, capability := range map[string]string{
+		"bundle_monitor":   agentdb.CapabilityBundleFreshness,
+		"entry_lifecycle":  agentdb.CapabilityEntryLifecycles,
+		"bootstrap_broker": agentdb.CapabilityBootstrapTokens,
+		"retry_queue":      agentdb.CapabilityRetryQueue,
+	} {
+		_, err := configure(t, fmt.Sprintf(server, block+" {}"))
+		expected := fmt.Sprintf("'config > server > %s' requires a DataStore plugin storing %s", block, capability)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected %s to be refused, got %v", block, err)
+		}
+	}
+
+	// CHECK the retry queue and API keys are disabled
+	s, err := configure(t, fmt.Sprintf(server, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.retryQueue != nil {
+		t.Fatal("Expected no retry queue")
+	}
+	if _, ok := s.Authenticator.(*authenticator.NullAuthenticator); !ok {
+		t.Fatalf("Expected no API key authenticators, got %T", s.Authenticator)
+	}
+
+	// CHECK the query log reports it is disabled
+	w := httptest.NewRecorder()
+	s.tornjakSPIRECallsList(w, httptest.NewRequest(http.MethodGet, "/api/v1/tornjak/spire/calls", nil))
+	var resp FeatureDisabledResponse
+	if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Unexpected response %q: %v", w.Body.String(), err)
+	}
+	if w.Code != http.StatusNotImplemented || resp.Feature != featureSPIREQueryLog {
+		t.Fatalf("Expected the query log disabled, got %d %+v", w.Code, resp)
+	}
+}
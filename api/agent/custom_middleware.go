@@ -0,0 +1,26 @@
+package api
+
+import (
+	"context"
+	"log"
+
+	"github.com/gorilla/mux"
+
+	"github.com/spiffe/tornjak/pkg/agent/authentication/user"
+	"github.com/spiffe/tornjak/pkg/agent/middleware"
+)
+
+// useCustomMiddleware adds the middleware registered at the stage to rtr
+func useCustomMiddleware(rtr *mux.Router, stage middleware.Stage) {
+	for _, m := range middleware.Registered(stage) {
+		rtr.Use(mux.MiddlewareFunc(m.Func))
+		log.Printf("custom %s middleware %s enabled", stage, m.Name)
+	}
+}
+
+// UserFromContext returns the user authenticated for the request of ctx, for
+// custom post-auth and pre-response middleware
+// returns nil before authentication or if no Authenticator is configured
+func UserFromContext(ctx context.Context) *user.UserInfo {
+	return userFromContext(ctx)
+}
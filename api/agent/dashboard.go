@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// defaults of the dashboard configuration
+const (
+	defaultDashboardRefreshInterval = time.Minute
+	defaultDashboardExpiringWithin  = 24 * time.Hour
+)
+
+// number of recent cluster changes shown on the dashboard
+const dashboardRecentChanges = 10
+
+// dashboard keeps the aggregates of the home page, refreshed in the background
+// so a page load does not list the whole fleet
+type dashboard struct {
+	refreshInterval time.Duration
+	expiringWithin  time.Duration
+
+	mu        sync.Mutex
+	current   *tornjakTypes.FleetDashboard
+	lastError string
+}
+
+// newDashboard returns the dashboard for the dashboard configuration,
+// the defaults if config is nil
+func newDashboard(config *DashboardConfig) (*dashboard, error) {
+	if config == nil {
+		config = &DashboardConfig{}
+	}
+	refreshInterval, err := parseConfigDuration("refresh_interval", config.RefreshInterval, defaultDashboardRefreshInterval)
+	if err != nil {
+		return nil, err
+	}
+	expiringWithin, err := parseConfigDuration("expiring_within", config.ExpiringWithin, defaultDashboardExpiringWithin)
+	if err != nil {
+		return nil, err
+	}
+	return &dashboard{
+		refreshInterval: refreshInterval,
+		expiringWithin:  expiringWithin,
+	}, nil
+}
+
+// record keeps the aggregates of a successful refresh, or the error of a failed one
+func (d *dashboard) record(current *tornjakTypes.FleetDashboard, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		d.lastError = err.Error()
+		return
+	}
+	d.current = current
+	d.lastError = ""
+}
+
+// get returns a copy of the last aggregates with the error of the last refresh
+// returns an error if no refresh succeeded yet
+func (d *dashboard) get() (tornjakTypes.FleetDashboard, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.current == nil {
+		if d.lastError != "" {
+			return tornjakTypes.FleetDashboard{}, errors.Errorf("dashboard has not been computed yet: %s", d.lastError)
+		}
+		return tornjakTypes.FleetDashboard{}, errors.New("dashboard has not been computed yet")
+	}
+	ret := *d.current
+	ret.LastRefreshError = d.lastError
+	return ret, nil
+}
+
+// runDashboard refreshes the dashboard every refresh interval until ctx is done
+func (s *Server) runDashboard(ctx context.Context) {
+	ticker := s.clock().NewTicker(s.dashboard.refreshInterval)
+	defer ticker.Stop()
+	for {
+		refreshCtx, cancel := context.WithTimeout(ctx, s.dashboard.refreshInterval)
+		current, err := s.computeDashboard(refreshCtx)
+		cancel()
+		if err != nil {
+			log.Printf("WARNING: could not refresh dashboard: %v", err)
+		}
+		s.dashboard.record(current, err)
+		s.warmUp.primed(warmUpDashboard, err, s.clock().Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// computeDashboard aggregates the clusters of the DataStore and the agents of the SPIRE server
+func (s *Server) computeDashboard(ctx context.Context) (*tornjakTypes.FleetDashboard, error) {
+	clusters, err := s.Db.GetClusters()
+	if err != nil {
+		return nil, errors.Errorf("could not list clusters: %v", err)
+	}
+	changes, err := s.Db.GetClusterChanges(dashboardRecentChanges)
+	if err != nil {
+		return nil, errors.Errorf("could not list cluster changes: %v", err)
+	}
+
+	// the agents of the dashboard are those of SPIRE, none in metadata-only mode
+	agents := []tornjakTypes.DashboardAgent{}
+	req := ListAgentsRequest{}
+	for !s.MetadataOnly() {
+		resp, err := s.ListAgents(ctx, req) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+		if err != nil {
+			return nil, errors.Errorf("could not list agents: %v", err)
+		}
+		for _, a := range resp.Agents {
+			agents = append(agents, tornjakTypes.DashboardAgent{
+				Spiffeid:          "spiffe://" + a.GetId().GetTrustDomain() + a.GetId().GetPath(),
+				X509SvidExpiresAt: a.GetX509SvidExpiresAt(),
+				Banned:            a.GetBanned(),
+			})
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+
+	current := tornjakTypes.NewFleetDashboard(clusters.Clusters, agents, changes, s.clock().Now(), s.dashboard.expiringWithin)
+	return &current, nil
+}
+
+type GetDashboardRequest struct{}
+type GetDashboardResponse tornjakTypes.FleetDashboard
+
+// GetDashboard returns the fleet aggregates of the last refresh
+func (s *Server) GetDashboard(inp GetDashboardRequest) (*GetDashboardResponse, error) {
+	if s.dashboard == nil {
+		return nil, errors.New("dashboard requires a DataStore plugin")
+	}
+	retVal, err := s.dashboard.get()
+	if err != nil {
+		return nil, err
+	}
+	return (*GetDashboardResponse)(&retVal), nil
+}
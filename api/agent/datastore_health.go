@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+)
+
+// default intervals between two probes of the DataStore, while it is
+// available and while it recovers
+const (
+	defaultDatastoreProbeInterval    = 10 * time.Second
+	defaultDatastoreRecoveryInterval = 2 * time.Second
+	// longest wait for the answer to a probe
+	datastoreProbeTimeout = 5 * time.Second
+)
+
+// name of the DataStore in the status of components
+const componentDatastore = "datastore"
+
+// routes of the Tornjak API answered without the DataStore, from SPIRE or
+// from memory, which are served while it is unavailable
+var datastoreFreeRoutes = map[string]bool{
+	"/api/tornjak/serverinfo":                 true,
+	"/api/v1/tornjak/serverinfo":              true,
+	"/api/v1/tornjak/dashboard":               true,
+	"/api/v1/tornjak/db/transactions":         true,
+	"/api/v1/tornjak/metadata/schema":         true,
+	"/api/v1/tornjak/replication/status":      true,
+	"/api/v1/tornjak/bundle-endpoint/status":  true,
+	"/api/v1/tornjak/entries/ttl/advice":      true,
+	"/api/v1/tornjak/agents/assignments/jobs": true,
+}
+
+// ComponentStatus is the state of a backend of Tornjak, as last probed
+type ComponentStatus struct {
+	Available bool `json:"available"`
+	// error of the last probe, while unavailable
+	Error string `json:"error,omitempty"`
+	// RFC 3339 times of the last change of availability and of the last probe
+	Since     string `json:"since,omitempty"`
+	LastProbe string `json:"lastProbe,omitempty"`
+}
+
+// DegradedResponse is the body of the 503 responses of the requests needing
+// the DataStore while it is unavailable
+type DegradedResponse struct {
+	Error      string                     `json:"error"`
+	Degraded   bool                       `json:"degraded"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// datastoreHealth probes the DataStore in the background; while it cannot be
+// reached Tornjak is degraded: SPIRE requests are still served, while the
+// requests of Tornjak metadata fail at once rather than wait for DB retries
+type datastoreHealth struct {
+	pinger           agentdb.Pinger
+	probeInterval    time.Duration
+	recoveryInterval time.Duration
+
+	mu        sync.Mutex
+	status    ComponentStatus
+	lastProbe time.Time
+}
+
+func newDatastoreHealth(pinger agentdb.Pinger, config *DatastoreHealthConfig) (*datastoreHealth, error) {
+	if config == nil {
+		config = &DatastoreHealthConfig{}
+	}
+	probeInterval, err := parseConfigDuration("probe_interval", config.ProbeInterval, defaultDatastoreProbeInterval)
+	if err != nil {
+		return nil, err
+	}
+	recoveryInterval, err := parseConfigDuration("recovery_interval", config.RecoveryInterval, defaultDatastoreRecoveryInterval)
+	if err != nil {
+		return nil, err
+	}
+	if recoveryInterval > probeInterval {
+		return nil, errors.Errorf("'recovery_interval' %v longer than 'probe_interval' %v", recoveryInterval, probeInterval)
+	}
+	return &datastoreHealth{
+		pinger:           pinger,
+		probeInterval:    probeInterval,
+		recoveryInterval: recoveryInterval,
+		// available until probed, so requests are not rejected on startup
+		status: ComponentStatus{Available: true},
+	}, nil
+}
+
+// current returns the status of the DataStore as last probed
+// the DataStore is reported available if it is not probed
+func (h *datastoreHealth) current() ComponentStatus {
+	if h == nil {
+		return ComponentStatus{Available: true}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
+
+// due returns whether the DataStore is to be probed at now: at every tick
+// while it is unavailable, every probe interval while it is available
+func (h *datastoreHealth) due(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.status.Available || h.lastProbe.IsZero() || now.Sub(h.lastProbe) >= h.probeInterval
+}
+
+// probe pings the DataStore and records its availability, logging changes
+func (h *datastoreHealth) probe(ctx context.Context, now time.Time) {
+	probeCtx, cancel := context.WithTimeout(ctx, datastoreProbeTimeout)
+	err := h.pinger.Ping(probeCtx)
+	cancel()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastProbe = now
+	h.status.LastProbe = now.UTC().Format(time.RFC3339)
+	h.status.Error = ""
+	if err != nil {
+		h.status.Error = err.Error()
+	}
+	available := err == nil
+	switch {
+	case !available && h.status.Available:
+		log.Printf("WARNING: DataStore unavailable, serving SPIRE requests only until it recovers: %v", err)
+	case available && !h.status.Available:
+		log.Printf("DataStore recovered after being unavailable since %s", h.status.Since)
+	}
+	if available != h.status.Available || h.status.Since == "" {
+		h.status.Since = h.status.LastProbe
+	}
+	h.status.Available = available
+}
+
+// runDatastoreHealth probes the DataStore until ctx is done
+func (s *Server) runDatastoreHealth(ctx context.Context) {
+	ticker := s.clock().NewTicker(s.datastoreHealth.recoveryInterval)
+	defer ticker.Stop()
+	for {
+		if now := s.clock().Now(); s.datastoreHealth.due(now) {
+			s.datastoreHealth.probe(ctx, now)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// needsDatastore returns whether a route reads or changes the DataStore
+func needsDatastore(path string) bool {
+	if datastoreFreeRoutes[path] {
+		return false
+	}
+	return strings.HasPrefix(path, "/api/tornjak/") || strings.HasPrefix(path, "/api/v1/tornjak/")
+}
+
+// datastoreMiddleware fails the requests needing the DataStore with 503
+// while it is unavailable, with the status of the DataStore
+func (s *Server) datastoreMiddleware(next http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		status := s.datastoreHealth.current()
+		if status.Available || !needsDatastore(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		resp := DegradedResponse{
+			Error:      "Tornjak DataStore unavailable: metadata cannot be read or changed until it recovers, SPIRE requests are still served",
+			Degraded:   true,
+			Components: map[string]ComponentStatus{componentDatastore: status},
+		}
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, DELETE, PATCH")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, access-control-allow-origin, access-control-allow-headers, access-control-allow-credentials, Authorization, access-control-allow-methods, traceparent, tracestate, x-request-id, x-tornjak-api-key")
+		w.Header().Set("Access-Control-Expose-Headers", "*, Authorization")
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(s.datastoreHealth.recoveryInterval.Seconds()))))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("WARNING: could not write DataStore status: %v", err)
+		}
+	}
+	return http.HandlerFunc(f)
+}
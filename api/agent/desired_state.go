@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/reconciler"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// default time between two reconciliations of the desired state
+const defaultDesiredStateInterval = time.Minute
+
+// listAgentIDs returns the SPIFFE IDs of all agents of the SPIRE server
+func (s *Server) listAgentIDs(ctx context.Context) ([]string, error) {
+	ids := []string{}
+	req := ListAgentsRequest{}
+	for {
+		resp, err := s.ListAgents(ctx, req) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+		if err != nil {
+			return nil, err
+		}
+		for _, agent := range resp.Agents {
+			ids = append(ids, "spiffe://"+agent.Id.TrustDomain+agent.Id.Path)
+		}
+		if resp.NextPageToken == "" {
+			return ids, nil
+		}
+		req.PageToken = resp.NextPageToken
+	}
+}
+
+// validateCluster applies the checks of DefineCluster and EditCluster
+// the name is checked against the object policy, the new name on renames
+func (s *Server) validateCluster(cinfo tornjakTypes.ClusterInfo) error {
+	name := cinfo.Name
+	if len(cinfo.EditedName) > 0 {
+		name = cinfo.EditedName
+	}
+	if err := s.objectPolicies.ValidateClusterName(name); err != nil {
+		return err
+	}
+	if err := cinfo.ValidateContacts(); err != nil {
+		return err
+	}
+	if err := s.objectPolicies.ValidateLabels(cinfo.Labels); err != nil {
+		return err
+	}
+	if err := s.clusterExtensions.Validate(cinfo); err != nil {
+		return err
+	}
+	return s.clusterMetadata.Validate(cinfo)
+}
+
+type GetDesiredStateRequest struct{}
+type GetDesiredStateResponse tornjakTypes.DesiredStateReport
+
+// GetDesiredState returns the drift found by the last reconciliation
+func (s *Server) GetDesiredState(inp GetDesiredStateRequest) (*GetDesiredStateResponse, error) {
+	if s.reconciler == nil {
+		return nil, errors.New("desired state is not configured")
+	}
+	report, ok := s.reconciler.Report()
+	if !ok {
+		return nil, errors.New("desired state has not been reconciled yet")
+	}
+	return (*GetDesiredStateResponse)(&report), nil
+}
+
+type ReconcileDesiredStateRequest struct{}
+
+// ReconcileDesiredState reconciles the desired state without waiting for the next interval
+func (s *Server) ReconcileDesiredState(ctx context.Context, inp ReconcileDesiredStateRequest) (*GetDesiredStateResponse, error) {
+	if s.reconciler == nil {
+		return nil, errors.New("desired state is not configured")
+	}
+	report := s.reconciler.Reconcile(ctx)
+	return (*GetDesiredStateResponse)(&report), nil
+}
+
+// newReconciler returns the reconciler for the desired-state configuration
+func (s *Server) newReconciler(config *DesiredStateConfig) (*reconciler.Reconciler, error) {
+	var source reconciler.Source
+	switch {
+	case config.File != "" && config.URL != "":
+		return nil, errors.New("only one of 'file' and 'url' may be set")
+	case config.File != "":
+		source = reconciler.NewFileSource(config.File)
+	case config.URL != "":
+		source = reconciler.NewURLSource(config.URL, nil)
+	default:
+		return nil, errors.New("one of 'file' and 'url' must be set")
+	}
+
+	interval := defaultDesiredStateInterval
+	if config.Interval != "" {
+		var err error
+		interval, err = time.ParseDuration(config.Interval)
+		if err != nil || interval <= 0 {
+			return nil, errors.Errorf("invalid 'interval': %q", config.Interval)
+		}
+	}
+
+	return s.newDocumentReconciler(source, interval, config.DryRun), nil
+}
+
+func (s *Server) newDocumentReconciler(source reconciler.Source, interval time.Duration, dryRun bool) *reconciler.Reconciler {
+	return reconciler.New(reconciler.Config{
+		Source:     source,
+		Store:      s.Db.WithActor(auditActorDesiredState),
+		ListAgents: s.listAgentIDs,
+		Validate:   s.validateCluster,
+		Interval:   interval,
+		DryRun:     dryRun,
+		Clock:      s.Clock,
+	})
+}
+
+// ReconcileDocument reconciles the clusters in the DB once towards the
+// desired-state document of source, e.g. to seed a new DB
+func (s *Server) ReconcileDocument(ctx context.Context, source reconciler.Source, dryRun bool) tornjakTypes.DesiredStateReport {
+	return s.newDocumentReconciler(source, 0, dryRun).Reconcile(ctx)
+}
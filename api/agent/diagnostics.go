@@ -0,0 +1,395 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	rpprof "runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// Runtime diagnostics, enabled with the diagnostics block.
+// The profiles of net/http/pprof, the variables of expvar, snapshots of the
+// goroutines and the heap, and the GOMAXPROCS and GC settings are served to
+// admins, on the API listeners or on a separate admin listener.
+// net/http/pprof and expvar also register on http.DefaultServeMux, which
+// Tornjak never serves.
+
+// host of the admin listener unless one is configured
+const defaultDiagnosticsHost = "127.0.0.1"
+
+type diagnostics struct {
+	// address of the admin listener, empty to serve on the API listeners
+	addr        string
+	snapshotDir string
+
+	// GOMAXPROCS is read back from the runtime, the GC percent is not, so it
+	// is tracked from the value at startup
+	mu        sync.Mutex
+	gcPercent int
+}
+
+func newDiagnostics(config *DiagnosticsConfig) (*diagnostics, error) {
+	if config.Port < 0 || config.Port > 65535 {
+		return nil, errors.Errorf("invalid 'port': %d", config.Port)
+	}
+	d := &diagnostics{snapshotDir: config.SnapshotDir}
+	if config.Port != 0 {
+		host := config.Host
+		if host == "" {
+			host = defaultDiagnosticsHost
+		}
+		d.addr = net.JoinHostPort(host, strconv.Itoa(config.Port))
+	} else if config.Host != "" {
+		return nil, errors.New("'host' requires 'port'")
+	}
+	if d.snapshotDir == "" {
+		d.snapshotDir = filepath.Join(os.TempDir(), "tornjak-diagnostics")
+	}
+	d.gcPercent = debug.SetGCPercent(100)
+	debug.SetGCPercent(d.gcPercent)
+	return d, nil
+}
+
+// registerDiagnosticsRoutes adds the diagnostics API
+func (s *Server) registerDiagnosticsRoutes(rtr *mux.Router) {
+	rtr.HandleFunc("/api/v1/tornjak/debug/pprof", s.tornjakDebugPprof).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+	rtr.Handle("/api/v1/tornjak/debug/vars", expvar.Handler()).Methods(http.MethodGet, http.MethodOptions)
+	rtr.HandleFunc("/api/v1/tornjak/debug/snapshot", s.tornjakDebugSnapshot).Methods(http.MethodPost, http.MethodOptions)
+	rtr.HandleFunc("/api/v1/tornjak/debug/runtime", s.tornjakDebugRuntimeGet).Methods(http.MethodGet, http.MethodOptions)
+	rtr.HandleFunc("/api/v1/tornjak/debug/runtime", s.tornjakDebugRuntimeSet).Methods(http.MethodPost)
+}
+
+// runDiagnosticsListener serves the diagnostics API on the admin listener,
+// behind the same authentication and authorization as the API listeners
+func (s *Server) runDiagnosticsListener(ctx context.Context) {
+	rtr := mux.NewRouter()
+	apiRtr := rtr.PathPrefix("/").Subrouter()
+	s.registerDiagnosticsRoutes(apiRtr)
+	apiRtr.Use(s.tracingMiddleware)
+	apiRtr.Use(s.verificationMiddleware)
+	apiRtr.Use(s.requestLogMiddleware)
+
+	server := &http.Server{
+		Handler:           rtr,
+		Addr:              s.diagnostics.addr,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	fmt.Printf("Starting diagnostics on %s...\n", s.diagnostics.addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("ERROR: diagnostics: %v", err)
+	}
+}
+
+// Profile is a runtime profile served at /api/v1/tornjak/debug/pprof?name=
+type Profile struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// ProfileList contains the runtime profiles, besides the cpu profile,
+// the execution trace, the command line and symbols
+type ProfileList struct {
+	Profiles []Profile `json:"profiles"`
+}
+
+// tornjakDebugPprof serves the net/http/pprof handler named by the name
+// query parameter, e.g. name=heap or name=profile&seconds=30 for the cpu profile
+// without a name it lists the runtime profiles
+func (s *Server) tornjakDebugPprof(w http.ResponseWriter, r *http.Request) {
+	if s.diagnostics == nil {
+		retError(w, "Error: diagnostics are not configured", http.StatusBadRequest)
+		return
+	}
+	// profiles are written with their own content type, without cors
+	switch name := r.URL.Query().Get("name"); name {
+	case "":
+		ret := ProfileList{Profiles: []Profile{}}
+		for _, p := range rpprof.Profiles() {
+			ret.Profiles = append(ret.Profiles, Profile{Name: p.Name(), Count: p.Count()})
+		}
+		sort.Slice(ret.Profiles, func(i, j int) bool { return ret.Profiles[i].Name < ret.Profiles[j].Name })
+		cors(w, r)
+		je := json.NewEncoder(w)
+		err := je.Encode(ret)
+		if err != nil {
+			emsg := fmt.Sprintf("Error: %v", err.Error())
+			retError(w, emsg, http.StatusBadRequest)
+			return
+		}
+	case "profile":
+		pprof.Profile(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	default:
+		if rpprof.Lookup(name) == nil {
+			retError(w, fmt.Sprintf("Error: unknown profile %q", name), http.StatusBadRequest)
+			return
+		}
+		pprof.Handler(name).ServeHTTP(w, r)
+	}
+}
+
+type CreateDiagnosticsSnapshotRequest struct {
+	// run a garbage collection before the heap profile, so it reflects live objects
+	GC bool `json:"gc"`
+}
+
+// DiagnosticsSnapshot describes the profiles written by a snapshot
+type DiagnosticsSnapshot struct {
+	// stacks of all goroutines, as text
+	GoroutineFile string `json:"goroutineFile"`
+	// heap profile, for go tool pprof
+	HeapFile     string `json:"heapFile"`
+	NumGoroutine int    `json:"numGoroutine"`
+	CreatedAt    string `json:"createdAt"`
+}
+
+// CreateDiagnosticsSnapshot writes the goroutine stacks and a heap profile to
+// the snapshot directory, to be collected later, e.g. while an incident is
+// still happening
+func (s *Server) CreateDiagnosticsSnapshot(inp CreateDiagnosticsSnapshotRequest) (*DiagnosticsSnapshot, error) {
+	if s.diagnostics == nil {
+		return nil, errors.New("diagnostics are not configured")
+	}
+	if err := os.MkdirAll(s.diagnostics.snapshotDir, 0o700); err != nil {
+		return nil, errors.Errorf("cannot create snapshot directory: %v", err)
+	}
+	now := s.clock().Now().UTC()
+	prefix := filepath.Join(s.diagnostics.snapshotDir, "tornjak-"+now.Format("20060102T150405.000Z"))
+	ret := DiagnosticsSnapshot{
+		GoroutineFile: prefix + "-goroutine.txt",
+		HeapFile:      prefix + "-heap.pb.gz",
+		NumGoroutine:  runtime.NumGoroutine(),
+		CreatedAt:     now.Format(time.RFC3339),
+	}
+	if err := writeProfile(ret.GoroutineFile, "goroutine", 2); err != nil {
+		return nil, err
+	}
+	if inp.GC {
+		runtime.GC()
+	}
+	if err := writeProfile(ret.HeapFile, "heap", 0); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// writeProfile writes a runtime profile to a new file
+func writeProfile(path, name string, debugLevel int) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return errors.Errorf("cannot write %s profile: %v", name, err)
+	}
+	if err := rpprof.Lookup(name).WriteTo(f, debugLevel); err != nil {
+		f.Close()
+		return errors.Errorf("cannot write %s profile: %v", name, err)
+	}
+	return f.Close()
+}
+
+// RuntimeSettings are the settings of the Go runtime and its current usage
+type RuntimeSettings struct {
+	GoVersion    string `json:"goVersion"`
+	NumCPU       int    `json:"numCPU"`
+	GOMAXPROCS   int    `json:"gomaxprocs"`
+	GCPercent    int    `json:"gcPercent"`
+	NumGoroutine int    `json:"numGoroutine"`
+	HeapAlloc    uint64 `json:"heapAlloc"`
+	HeapSys      uint64 `json:"heapSys"`
+	NumGC        uint32 `json:"numGC"`
+}
+
+type GetRuntimeSettingsRequest struct{}
+
+// GetRuntimeSettings returns the GOMAXPROCS and GC settings and the usage of
+// goroutines and memory
+func (s *Server) GetRuntimeSettings(inp GetRuntimeSettingsRequest) (*RuntimeSettings, error) {
+	if s.diagnostics == nil {
+		return nil, errors.New("diagnostics are not configured")
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	s.diagnostics.mu.Lock()
+	defer s.diagnostics.mu.Unlock()
+	return &RuntimeSettings{
+		GoVersion:    runtime.Version(),
+		NumCPU:       runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		GCPercent:    s.diagnostics.gcPercent,
+		NumGoroutine: runtime.NumGoroutine(),
+		HeapAlloc:    stats.HeapAlloc,
+		HeapSys:      stats.HeapSys,
+		NumGC:        stats.NumGC,
+	}, nil
+}
+
+type SetRuntimeSettingsRequest struct {
+	// unchanged if omitted
+	GOMAXPROCS *int `json:"gomaxprocs,omitempty"`
+	// -1 disables the garbage collector, unchanged if omitted
+	GCPercent *int `json:"gcPercent,omitempty"`
+}
+
+// SetRuntimeSettings changes GOMAXPROCS and the GC percent until the next
+// restart, which applies the GOMAXPROCS and GOGC environment variables again
+func (s *Server) SetRuntimeSettings(ctx context.Context, inp SetRuntimeSettingsRequest) (*RuntimeSettings, error) {
+	if s.diagnostics == nil {
+		return nil, errors.New("diagnostics are not configured")
+	}
+	if inp.GOMAXPROCS != nil && *inp.GOMAXPROCS < 1 {
+		return nil, errors.Errorf("gomaxprocs %d must be at least 1", *inp.GOMAXPROCS)
+	}
+	if inp.GCPercent != nil && *inp.GCPercent < -1 {
+		return nil, errors.Errorf("gcPercent %d must be -1 or more", *inp.GCPercent)
+	}
+	user := ""
+	if u := userFromContext(ctx); u != nil {
+		user = u.Username
+	}
+	s.diagnostics.mu.Lock()
+	if inp.GOMAXPROCS != nil {
+		previous := runtime.GOMAXPROCS(*inp.GOMAXPROCS)
+		log.Printf("diagnostics: GOMAXPROCS changed from %d to %d by %q", previous, *inp.GOMAXPROCS, user)
+	}
+	if inp.GCPercent != nil {
+		previous := debug.SetGCPercent(*inp.GCPercent)
+		s.diagnostics.gcPercent = *inp.GCPercent
+		log.Printf("diagnostics: GC percent changed from %d to %d by %q", previous, *inp.GCPercent, user)
+	}
+	s.diagnostics.mu.Unlock()
+	return s.GetRuntimeSettings(GetRuntimeSettingsRequest{})
+}
+
+func (s *Server) tornjakDebugSnapshot(w http.ResponseWriter, r *http.Request) {
+	buf := new(strings.Builder)
+	n, err := io.Copy(buf, r.Body)
+	if err != nil {
+		emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	data := buf.String()
+	var input CreateDiagnosticsSnapshotRequest
+	if n == 0 {
+		input = CreateDiagnosticsSnapshotRequest{}
+	} else {
+		err := json.Unmarshal([]byte(data), &input)
+		if err != nil {
+			emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+			retError(w, emsg, http.StatusBadRequest)
+			return
+		}
+	}
+	ret, err := s.CreateDiagnosticsSnapshot(input)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	cors(w, r)
+	je := json.NewEncoder(w)
+	err = je.Encode(ret)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+}
+
+func (s *Server) tornjakDebugRuntimeGet(w http.ResponseWriter, r *http.Request) {
+	buf := new(strings.Builder)
+	n, err := io.Copy(buf, r.Body)
+	if err != nil {
+		emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	data := buf.String()
+	var input GetRuntimeSettingsRequest
+	if n == 0 {
+		input = GetRuntimeSettingsRequest{}
+	} else {
+		err := json.Unmarshal([]byte(data), &input)
+		if err != nil {
+			emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+			retError(w, emsg, http.StatusBadRequest)
+			return
+		}
+	}
+	ret, err := s.GetRuntimeSettings(input)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	cors(w, r)
+	je := json.NewEncoder(w)
+	err = je.Encode(ret)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+}
+
+func (s *Server) tornjakDebugRuntimeSet(w http.ResponseWriter, r *http.Request) {
+	buf := new(strings.Builder)
+	n, err := io.Copy(buf, r.Body)
+	if err != nil {
+		emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	data := buf.String()
+	var input SetRuntimeSettingsRequest
+	if n == 0 {
+		input = SetRuntimeSettingsRequest{}
+	} else {
+		err := json.Unmarshal([]byte(data), &input)
+		if err != nil {
+			emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+			retError(w, emsg, http.StatusBadRequest)
+			return
+		}
+	}
+	ret, err := s.SetRuntimeSettings(r.Context(), input)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	cors(w, r)
+	je := json.NewEncoder(w)
+	err = je.Encode(ret)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"google.golang.org/grpc/codes"
+
+	"github.com/spiffe/tornjak/pkg/agent/spireerror"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// CreateEntriesResponse is the response of the entry creation routes: the
+// results of SPIRE, with the outcome of each entry by its index in the request
+type CreateEntriesResponse struct {
+	*BatchCreateEntryResponse
+	Batch tornjakTypes.BatchResult `json:"batch"`
+}
+
+// entryCreateBatch returns the outcome of each entry of a batch creation
+// SPIRE returns the results in the order of the entries of the request
+func entryCreateBatch(resp *BatchCreateEntryResponse) tornjakTypes.BatchResult {
+	batch := tornjakTypes.NewBatchResult()
+	for i, r := range resp.Results {
+		item := tornjakTypes.BatchItem{Index: i, ID: r.GetEntry().GetId(), Status: tornjakTypes.BatchItemSucceeded}
+		if code := codes.Code(r.GetStatus().GetCode()); code != codes.OK {
+			item.Status, item.Error = tornjakTypes.BatchItemFailed, r.GetStatus().GetMessage()
+			item.Code = spireerror.FromStatus(code, item.Error).Code
+		}
+		batch.Add(item)
+	}
+	return batch
+}
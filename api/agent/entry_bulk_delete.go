@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+
+	"github.com/spiffe/tornjak/pkg/agent/bulkdelete"
+	"github.com/spiffe/tornjak/pkg/agent/spireerror"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// defaults of the entry bulk deletion configuration
+const (
+	defaultEntryBulkDeleteConfirmThreshold = 100
+	defaultEntryBulkDeleteBatchSize        = 50
+	defaultEntryBulkDeleteBatchInterval    = time.Second
+)
+
+// newEntryDeleter returns the deleter for the entry bulk deletion configuration,
+// the defaults if config is nil
+func (s *Server) newEntryDeleter(config *EntryBulkDeleteConfig) (*bulkdelete.Deleter, error) {
+	if config == nil {
+		config = &EntryBulkDeleteConfig{}
+	}
+	batchInterval, err := parseConfigDuration("batch_interval", config.BatchInterval, defaultEntryBulkDeleteBatchInterval)
+	if err != nil {
+		return nil, err
+	}
+	if config.ConfirmThreshold < 0 {
+		return nil, errors.New("'confirm_threshold' must not be negative")
+	}
+	if config.BatchSize < 0 {
+		return nil, errors.New("'batch_size' must not be negative")
+	}
+	confirmThreshold := config.ConfirmThreshold
+	if confirmThreshold == 0 {
+		confirmThreshold = defaultEntryBulkDeleteConfirmThreshold
+	}
+	batchSize := config.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultEntryBulkDeleteBatchSize
+	}
+	return bulkdelete.New(bulkdelete.Config{
+		Delete:           s.deleteEntryBatch,
+		ConfirmThreshold: confirmThreshold,
+		BatchSize:        batchSize,
+		BatchInterval:    batchInterval,
+		Clock:            s.Clock,
+	}), nil
+}
+
+// listBulkDeleteEntries returns the fields filters match on of all entries of the SPIRE server
+func (s *Server) listBulkDeleteEntries(ctx context.Context) ([]bulkdelete.Entry, error) {
+	entries := []bulkdelete.Entry{}
+	req := ListEntriesRequest{}
+	for {
+		resp, err := s.ListEntries(ctx, req) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range resp.Entries {
+			selectors := []string{}
+			for _, selector := range e.Selectors {
+				selectors = append(selectors, selector.Type+":"+selector.Value)
+			}
+			entries = append(entries, bulkdelete.Entry{
+				Id:        e.Id,
+				SpiffeId:  "spiffe://" + e.GetSpiffeId().GetTrustDomain() + e.GetSpiffeId().GetPath(),
+				ParentId:  "spiffe://" + e.GetParentId().GetTrustDomain() + e.GetParentId().GetPath(),
+				Selectors: selectors,
+			})
+		}
+		if resp.NextPageToken == "" {
+			return entries, nil
+		}
+		req.PageToken = resp.NextPageToken
+	}
+}
+
+// deleteEntryBatch deletes entries from SPIRE in one call
+func (s *Server) deleteEntryBatch(ctx context.Context, ids []string) ([]tornjakTypes.EntryBulkDeleteFailure, error) {
+	resp, err := s.BatchDeleteEntry(ctx, BatchDeleteEntryRequest{Ids: ids}) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+	if err != nil {
+		return nil, err
+	}
+	failures := []tornjakTypes.EntryBulkDeleteFailure{}
+	for _, r := range resp.Results {
+		if code := codes.Code(r.Status.GetCode()); code != codes.OK {
+			failures = append(failures, tornjakTypes.EntryBulkDeleteFailure{EntryId: r.Id,
+				Code: spireerror.FromStatus(code, r.Status.GetMessage()).Code, Error: r.Status.GetMessage()})
+		}
+	}
+	return failures, nil
+}
+
+type BulkDeleteEntriesRequest struct {
+	Filter tornjakTypes.EntryFilter `json:"filter"`
+	// token returned by a previous call, required when more entries match than the threshold
+	ConfirmationToken string `json:"confirmationToken,omitempty"`
+	// only list the matching entries without deleting them
+	DryRun bool `json:"dryRun"`
+}
+type BulkDeleteEntriesResponse tornjakTypes.EntryBulkDelete
+
+// BulkDeleteEntries deletes the SPIRE entries matching the filter in rate-limited batches
+func (s *Server) BulkDeleteEntries(ctx context.Context, inp BulkDeleteEntriesRequest) (*BulkDeleteEntriesResponse, error) {
+	if s.entryDeleter == nil {
+		return nil, errors.New("entry bulk deletion is not configured")
+	}
+	if err := bulkdelete.ValidateFilter(inp.Filter); err != nil {
+		return nil, err
+	}
+	entries, err := s.listBulkDeleteEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	retVal, err := s.entryDeleter.Delete(ctx, entries, inp.Filter, inp.ConfirmationToken, inp.DryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	if !retVal.DryRun && !retVal.ConfirmationRequired && len(retVal.EntryIds) > 0 {
+		user := ""
+		if u := userFromContext(ctx); u != nil {
+			user = u.Username
+		}
+		log.Printf("bulk deletion of entries matching %+v by %q: %d of %d deleted", inp.Filter, user, retVal.Deleted, len(retVal.EntryIds))
+	}
+	return (*BulkDeleteEntriesResponse)(&retVal), nil
+}
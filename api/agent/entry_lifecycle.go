@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+
+	"github.com/spiffe/tornjak/pkg/agent/lifecycle"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// defaults of the entry lifecycle configuration
+const (
+	defaultEntryLifecycleNoticePeriod  = 7 * 24 * time.Hour
+	defaultEntryLifecycleSweepInterval = time.Hour
+)
+
+// newLifecycleEnforcer returns the enforcer of scheduled removals for the entry_lifecycle configuration
+func (s *Server) newLifecycleEnforcer(config *EntryLifecycleConfig) (*lifecycle.Enforcer, error) {
+	noticePeriod, err := parseConfigDuration("notice_period", config.NoticePeriod, defaultEntryLifecycleNoticePeriod)
+	if err != nil {
+		return nil, err
+	}
+	sweepInterval, err := parseConfigDuration("sweep_interval", config.SweepInterval, defaultEntryLifecycleSweepInterval)
+	if err != nil {
+		return nil, err
+	}
+	return lifecycle.New(lifecycle.Config{
+		DeleteEntries: s.deleteScheduledEntries,
+		Store:         s.Db,
+		Notify:        notifyEntryLifecycle,
+		NoticePeriod:  noticePeriod,
+		SweepInterval: sweepInterval,
+		Clock:         s.Clock,
+	}), nil
+}
+
+// deleteScheduledEntries deletes entries from SPIRE in one call
+// entries SPIRE does not know are reported deleted
+func (s *Server) deleteScheduledEntries(ctx context.Context, ids []string) (map[string]string, error) {
+	resp, err := s.BatchDeleteEntry(ctx, BatchDeleteEntryRequest{Ids: ids}) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+	if err != nil {
+		return nil, err
+	}
+	failures := map[string]string{}
+	for _, r := range resp.Results {
+		if code := codes.Code(r.Status.GetCode()); code != codes.OK && code != codes.NotFound {
+			failures[r.Id] = r.Status.GetMessage()
+		}
+	}
+	return failures, nil
+}
+
+// notifyEntryLifecycle tells the owners of an entry of its removal
+// no notifier is configurable yet, so notices are written to the server log
+func notifyEntryLifecycle(notice tornjakTypes.EntryLifecycleNotice) {
+	owner := notice.OwnerTeam
+	if owner == "" {
+		owner = "(no owner)"
+	}
+	switch notice.Event {
+	case tornjakTypes.EntryRemovalUpcoming:
+		log.Printf("NOTICE to team %s: entry %s is scheduled for removal at %s", owner, notice.EntryId, notice.RemoveAt)
+	case tornjakTypes.EntryRemoved:
+		log.Printf("NOTICE to team %s: entry %s was removed as scheduled for %s", owner, notice.EntryId, notice.RemoveAt)
+	}
+}
+
+type SetEntryLifecycleRequest struct {
+	EntryId string `json:"entryId"`
+	State   string `json:"state"`
+	// RFC 3339 time the entry is deleted at, for the scheduled_for_removal state
+	RemoveAt string `json:"removeAt"`
+	Reason   string `json:"reason"`
+}
+
+// SetEntryLifecycle sets the lifecycle state of an entry
+// removals can only be scheduled in the future, and when the entry_lifecycle
+// configuration enforces them
+func (s *Server) SetEntryLifecycle(ctx context.Context, inp SetEntryLifecycleRequest) error {
+	now := s.clock().Now().UTC()
+	l := tornjakTypes.EntryLifecycle{
+		EntryId:   inp.EntryId,
+		State:     inp.State,
+		RemoveAt:  inp.RemoveAt,
+		Reason:    inp.Reason,
+		UpdatedAt: now.Format(time.RFC3339),
+	}
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	if l.State == tornjakTypes.EntryLifecycleScheduledForRemoval {
+		if s.lifecycleEnforcer == nil {
+			return errors.New("scheduled removals require the entry_lifecycle configuration")
+		}
+		removeAt, _ := time.Parse(time.RFC3339, l.RemoveAt)
+		if !removeAt.After(now) {
+			return errors.Errorf("removal time %s is not in the future", l.RemoveAt)
+		}
+		l.RemoveAt = removeAt.UTC().Format(time.RFC3339)
+	}
+	if u := userFromContext(ctx); u != nil {
+		l.UpdatedBy = u.Username
+	}
+	return s.Db.SetEntryLifecycle(l)
+}
+
+type ListEntryLifecyclesRequest struct {
+	// lifecycle state of the entries, all if empty
+	State string `json:"state"`
+}
+type ListEntryLifecyclesResponse tornjakTypes.EntryLifecycleList
+
+// ListEntryLifecycles returns the lifecycle states of entries, scheduled removals first
+func (s *Server) ListEntryLifecycles(inp ListEntryLifecyclesRequest) (*ListEntryLifecyclesResponse, error) {
+	switch inp.State {
+	case "", tornjakTypes.EntryLifecycleProposed, tornjakTypes.EntryLifecycleActive, tornjakTypes.EntryLifecycleDeprecated,
+		tornjakTypes.EntryLifecycleScheduledForRemoval, tornjakTypes.EntryLifecycleRemoved:
+	default:
+		return nil, errors.Errorf("invalid state %q", inp.State)
+	}
+	retVal, err := s.Db.GetEntryLifecycles(inp.State)
+	if err != nil {
+		return nil, err
+	}
+	return (*ListEntryLifecyclesResponse)(&retVal), nil
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+	types "github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+	"google.golang.org/grpc/codes"
+
+	"github.com/spiffe/tornjak/pkg/agent/spireerror"
+	"github.com/spiffe/tornjak/pkg/agent/ttladvisor"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// newTTLPolicy returns the policy of the entry_ttl_policy configuration
+func newTTLPolicy(config *EntryTTLPolicyConfig) (*ttladvisor.Policy, error) {
+	policy := &ttladvisor.Policy{}
+	bounds := []struct {
+		name  string
+		value string
+		d     *time.Duration
+	}{
+		{"x509_svid_ttl_min", config.X509SVIDTTLMin, &policy.X509SVIDMin},
+		{"x509_svid_ttl_max", config.X509SVIDTTLMax, &policy.X509SVIDMax},
+		{"jwt_svid_ttl_min", config.JWTSVIDTTLMin, &policy.JWTSVIDMin},
+		{"jwt_svid_ttl_max", config.JWTSVIDTTLMax, &policy.JWTSVIDMax},
+		{"agent_ttl", config.AgentTTL, &policy.AgentTTL},
+	}
+	for _, b := range bounds {
+		if b.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(b.value)
+		if err != nil || d <= 0 {
+			return nil, errors.Errorf("invalid '%s': %q", b.name, b.value)
+		}
+		*b.d = d
+	}
+	if policy.X509SVIDMax > 0 && policy.X509SVIDMin > policy.X509SVIDMax {
+		return nil, errors.New("'x509_svid_ttl_min' is greater than 'x509_svid_ttl_max'")
+	}
+	if policy.JWTSVIDMax > 0 && policy.JWTSVIDMin > policy.JWTSVIDMax {
+		return nil, errors.New("'jwt_svid_ttl_min' is greater than 'jwt_svid_ttl_max'")
+	}
+	return policy, nil
+}
+
+// listTTLEntries returns the TTLs of all entries of the SPIRE server
+func (s *Server) listTTLEntries(ctx context.Context) ([]ttladvisor.Entry, error) {
+	entries := []ttladvisor.Entry{}
+	req := ListEntriesRequest{}
+	for {
+		resp, err := s.ListEntries(ctx, req) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range resp.Entries {
+			entries = append(entries, ttladvisor.Entry{
+				Id:          e.Id,
+				SpiffeId:    "spiffe://" + e.SpiffeId.TrustDomain + e.SpiffeId.Path,
+				ParentId:    "spiffe://" + e.ParentId.TrustDomain + e.ParentId.Path,
+				X509SVIDTTL: e.X509SvidTtl,
+				JWTSVIDTTL:  e.JwtSvidTtl,
+			})
+		}
+		if resp.NextPageToken == "" {
+			return entries, nil
+		}
+		req.PageToken = resp.NextPageToken
+	}
+}
+
+type AdviseEntryTTLsRequest struct{}
+type AdviseEntryTTLsResponse tornjakTypes.TTLAdvice
+
+// AdviseEntryTTLs returns the entries with TTLs outside the configured policy
+func (s *Server) AdviseEntryTTLs(ctx context.Context, inp AdviseEntryTTLsRequest) (*AdviseEntryTTLsResponse, error) {
+	if s.ttlPolicy == nil {
+		return nil, errors.New("entry TTL policy is not configured")
+	}
+	entries, err := s.listTTLEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	retVal := s.ttlPolicy.Advise(entries)
+	return (*AdviseEntryTTLsResponse)(&retVal), nil
+}
+
+type RemediateEntryTTLsRequest struct {
+	// entries to apply the suggested TTLs to, all flagged entries if empty
+	EntryIds []string `json:"entryIds,omitempty"`
+	// only report the suggested TTLs without applying them
+	DryRun bool `json:"dryRun"`
+}
+type RemediateEntryTTLsResponse tornjakTypes.TTLRemediation
+
+// RemediateEntryTTLs sets the TTLs of flagged entries to the suggested values
+// the advice is recomputed so entries changed since it was fetched are not
+// overwritten with stale suggestions
+func (s *Server) RemediateEntryTTLs(ctx context.Context, inp RemediateEntryTTLsRequest) (*RemediateEntryTTLsResponse, error) {
+	if s.ttlPolicy == nil {
+		return nil, errors.New("entry TTL policy is not configured")
+	}
+	entries, err := s.listTTLEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	advice := s.ttlPolicy.Advise(entries)
+
+	selected := map[string]bool{}
+	for _, id := range inp.EntryIds {
+		selected[id] = true
+	}
+	ttls := map[string]*ttladvisor.Entry{}
+	for i := range entries {
+		ttls[entries[i].Id] = &entries[i]
+	}
+	retVal := tornjakTypes.TTLRemediation{DryRun: inp.DryRun, Results: []tornjakTypes.TTLRemediationResult{}}
+	index := map[string]int{}
+	for _, f := range advice.Findings {
+		if len(selected) > 0 && !selected[f.EntryId] {
+			continue
+		}
+		i, ok := index[f.EntryId]
+		if !ok {
+			i = len(retVal.Results)
+			index[f.EntryId] = i
+			retVal.Results = append(retVal.Results, tornjakTypes.TTLRemediationResult{EntryId: f.EntryId})
+		}
+		retVal.Results[i].Findings = append(retVal.Results[i].Findings, f)
+		switch f.Field {
+		case tornjakTypes.TTLFieldX509SVID:
+			ttls[f.EntryId].X509SVIDTTL = f.Suggested
+		case tornjakTypes.TTLFieldJWTSVID:
+			ttls[f.EntryId].JWTSVIDTTL = f.Suggested
+		}
+	}
+	if inp.DryRun || len(retVal.Results) == 0 {
+		retVal.Batch = tornjakTypes.TTLRemediationBatch(retVal)
+		return (*RemediateEntryTTLsResponse)(&retVal), nil
+	}
+
+	req := BatchUpdateEntryRequest{
+		InputMask: &types.EntryMask{X509SvidTtl: true, JwtSvidTtl: true},
+	}
+	for _, result := range retVal.Results {
+		ttl := ttls[result.EntryId]
+		req.Entries = append(req.Entries, &types.Entry{
+			Id:          ttl.Id,
+			X509SvidTtl: ttl.X509SVIDTTL,
+			JwtSvidTtl:  ttl.JWTSVIDTTL,
+		})
+	}
+	resp, err := s.BatchUpdateEntry(ctx, req) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+	if err != nil {
+		return nil, err
+	}
+	applied := 0
+	for i, r := range resp.Results {
+		if i >= len(retVal.Results) {
+			break
+		}
+		if code := codes.Code(r.Status.GetCode()); code != codes.OK {
+			retVal.Results[i].Code = spireerror.FromStatus(code, r.Status.GetMessage()).Code
+			retVal.Results[i].Error = r.Status.GetMessage()
+			continue
+		}
+		retVal.Results[i].Applied = true
+		applied++
+	}
+
+	user := ""
+	if u := userFromContext(ctx); u != nil {
+		user = u.Username
+	}
+	log.Printf("suggested TTLs applied to %d of %d entries by %q", applied, len(retVal.Results), user)
+	retVal.Batch = tornjakTypes.TTLRemediationBatch(retVal)
+	return (*RemediateEntryTTLsResponse)(&retVal), nil
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/spiffe/tornjak/pkg/agent/idformat"
+)
+
+// displayOptions returns the SPIFFE ID display options of the display query flag
+func displayOptions(r *http.Request) (idformat.Options, error) {
+	return idformat.ParseOptions(r.URL.Query().Get("display"))
+}
+
+// encodeDisplay writes ret as JSON with the display form of its SPIFFE IDs
+// added next to the canonical IDs, if any display option is set
+func encodeDisplay(w io.Writer, ret interface{}, opts idformat.Options) error {
+	if !opts.Enabled() {
+		return json.NewEncoder(w).Encode(ret)
+	}
+	b, err := json.Marshal(ret)
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(opts.Annotate(value))
+}
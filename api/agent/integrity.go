@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// default interval between two integrity checks of the DataStore
+const defaultIntegrityCheckInterval = 24 * time.Hour
+
+// integrityCheck checks the DataStore for inconsistent rows in the background
+type integrityCheck struct {
+	checker  agentdb.IntegrityChecker
+	interval time.Duration
+}
+
+// newIntegrityCheck returns the integrity check of the integrity_check
+// configuration, the defaults if config is nil
+func newIntegrityCheck(checker agentdb.IntegrityChecker, config *IntegrityCheckConfig) (*integrityCheck, error) {
+	if config == nil {
+		config = &IntegrityCheckConfig{}
+	}
+	interval, err := parseConfigDuration("interval", config.Interval, defaultIntegrityCheckInterval)
+	if err != nil {
+		return nil, err
+	}
+	return &integrityCheck{checker: checker, interval: interval}, nil
+}
+
+// run checks the DataStore, logging a summary of the findings
+func (c *integrityCheck) run(ctx context.Context) (tornjakTypes.IntegrityReport, error) {
+	report, err := c.checker.CheckIntegrity(ctx)
+	if err != nil {
+		return tornjakTypes.IntegrityReport{}, err
+	}
+	if report.Errors > 0 || report.Warnings > 0 {
+		log.Printf("WARNING: DataStore integrity check found %d errors and %d warnings, see GET /api/v1/tornjak/db/integrity",
+			report.Errors, report.Warnings)
+	}
+	return report, nil
+}
+
+// runIntegrityCheck checks the DataStore every interval until ctx is done
+func (s *Server) runIntegrityCheck(ctx context.Context) {
+	ticker := s.clock().NewTicker(s.integrityCheck.interval)
+	defer ticker.Stop()
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, s.integrityCheck.interval)
+		_, err := s.integrityCheck.run(checkCtx)
+		cancel()
+		if err != nil {
+			log.Printf("WARNING: could not check DataStore integrity: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+type GetIntegrityReportRequest struct{}
+type GetIntegrityReportResponse tornjakTypes.IntegrityReport
+
+// GetIntegrityReport returns the findings of the last integrity check of the DataStore
+func (s *Server) GetIntegrityReport(inp GetIntegrityReportRequest) (*GetIntegrityReportResponse, error) {
+	if s.integrityCheck == nil {
+		return nil, errors.New("DataStore does not support integrity checks")
+	}
+	retVal, err := s.integrityCheck.checker.GetIntegrityReport()
+	if err != nil {
+		return nil, err
+	}
+	return (*GetIntegrityReportResponse)(&retVal), nil
+}
+
+type CheckIntegrityRequest struct{}
+type CheckIntegrityResponse tornjakTypes.IntegrityReport
+
+// CheckIntegrity checks the DataStore now rather than at the next interval,
+// returning its findings
+func (s *Server) CheckIntegrity(ctx context.Context, inp CheckIntegrityRequest) (*CheckIntegrityResponse, error) {
+	if s.integrityCheck == nil {
+		return nil, errors.New("DataStore does not support integrity checks")
+	}
+	retVal, err := s.integrityCheck.run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return (*CheckIntegrityResponse)(&retVal), nil
+}
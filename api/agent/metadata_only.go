@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// name of the SPIRE connection in the responses of disabled features
+const featureSPIRE = "spire"
+
+// errSPIREDisabled is returned by the calls to SPIRE in metadata-only mode
+var errSPIREDisabled = errors.New("SPIRE connection disabled: Tornjak runs in metadata-only mode, set by metadata_only in the server config")
+
+// prefixes of the routes of the Tornjak API proxying SPIRE
+var spireRoutePrefixes = []string{"/api/agent/", "/api/entry/", "/api/v1/spire/", "/api/v1/mirror/spire/"}
+
+// other routes of the Tornjak API served from SPIRE, which are disabled in
+// metadata-only mode; routes adding SPIRE details to metadata only fail
+// when the details are requested
+var spireRoutes = map[string]bool{
+	"/api/debugserver":                      true,
+	"/api/healthcheck":                      true,
+	"/api/v1/tornjak/entries/ttl/advice":    true,
+	"/api/v1/tornjak/entries/ttl/remediate": true,
+	"/api/v1/tornjak/entries/bulk-delete":   true,
+}
+
+// FeatureDisabledResponse is the body of the 501 responses of the requests of
+// features disabled by the Tornjak config
+type FeatureDisabledResponse struct {
+	Error   string `json:"error"`
+	Feature string `json:"feature"`
+}
+
+// verifyMetadataOnly returns an error if a block of the server config needing
+// SPIRE is set in metadata-only mode
+func verifyMetadataOnly(serverConfig *serverConfig) error {
+	if !serverConfig.MetadataOnly {
+		return nil
+	}
+	blocks := []struct {
+		name string
+		set  bool
+	}{
+		{"spire_mirror", serverConfig.SPIREMirrorConfig != nil},
+		{"desired_state", serverConfig.DesiredStateConfig != nil},
+		{"bundle_monitor", serverConfig.BundleMonitorConfig != nil},
+		{"bundle_endpoint", serverConfig.BundleEndpointConfig != nil},
+		{"entry_lifecycle", serverConfig.EntryLifecycleConfig != nil},
+		{"bootstrap_broker", serverConfig.BootstrapBrokerConfig != nil},
+	}
+	for _, b := range blocks {
+		if b.set {
+			return errors.Errorf("'config > server > %s' requires a SPIRE connection, which 'metadata_only' disables", b.name)
+		}
+	}
+	return nil
+}
+
+// MetadataOnly returns whether the server runs without a SPIRE connection,
+// serving the metadata of clusters and agents only
+func (s *Server) MetadataOnly() bool {
+	return s.TornjakConfig != nil && s.TornjakConfig.Server != nil && s.TornjakConfig.Server.MetadataOnly
+}
+
+// needsSPIRE returns whether a route is served from SPIRE
+func needsSPIRE(path string) bool {
+	if spireRoutes[path] {
+		return true
+	}
+	for _, prefix := range spireRoutePrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// metadataOnlyMiddleware fails the requests served from SPIRE with 501 in
+// metadata-only mode
+func (s *Server) metadataOnlyMiddleware(next http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		if !s.MetadataOnly() || !needsSPIRE(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		retFeatureDisabled(w, errSPIREDisabled, featureSPIRE)
+	}
+	return http.HandlerFunc(f)
+}
+
+// retFeatureDisabled fails a request of a feature disabled by the Tornjak
+// config with 501 and a FeatureDisabledResponse
+func retFeatureDisabled(w http.ResponseWriter, err error, feature string) {
+	resp := FeatureDisabledResponse{Error: err.Error(), Feature: feature}
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, DELETE, PATCH")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, access-control-allow-origin, access-control-allow-headers, access-control-allow-credentials, Authorization, access-control-allow-methods, traceparent, tracestate, x-request-id, x-tornjak-api-key")
+	w.Header().Set("Access-Control-Expose-Headers", "*, Authorization")
+	w.WriteHeader(http.StatusNotImplemented)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("WARNING: could not write disabled feature error: %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+type CreateNoteRequest struct {
+	ObjectType string `json:"objectType"`
+	// cluster UID, agent SPIFFE ID or entry ID
+	ObjectId string `json:"objectId"`
+	Body     string `json:"body"`
+}
+type CreateNoteResponse tornjakTypes.Note
+
+// CreateNote attaches a markdown note to a cluster, agent or entry
+// the notes of a cluster are attached to its UID, so they follow renames
+func (s *Server) CreateNote(ctx context.Context, inp CreateNoteRequest) (*CreateNoteResponse, error) {
+	note := tornjakTypes.Note{
+		ObjectType: inp.ObjectType,
+		ObjectId:   inp.ObjectId,
+		Body:       inp.Body,
+		CreatedAt:  s.clock().Now().UTC().Format(time.RFC3339),
+	}
+	if err := note.Validate(); err != nil {
+		return nil, err
+	}
+	if err := s.objectPolicies.ValidateNoteBody(note.Body); err != nil {
+		return nil, err
+	}
+	if note.ObjectType == tornjakTypes.NoteObjectCluster {
+		if _, err := s.Db.GetClusterNameByUID(note.ObjectId); err != nil {
+			return nil, err
+		}
+	}
+	if u := userFromContext(ctx); u != nil {
+		note.Author = u.Username
+	}
+	id, err := s.Db.CreateNote(note)
+	if err != nil {
+		return nil, err
+	}
+	note.ID = id
+	return (*CreateNoteResponse)(&note), nil
+}
+
+type EditNoteRequest struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+}
+
+// EditNote replaces the text of a note, the previous text is kept in its history
+func (s *Server) EditNote(ctx context.Context, inp EditNoteRequest) error {
+	if inp.ID == 0 {
+		return errors.New("input missing mandatory field - ID")
+	}
+	if err := s.objectPolicies.ValidateNoteBody(inp.Body); err != nil {
+		return err
+	}
+	var user string
+	if u := userFromContext(ctx); u != nil {
+		user = u.Username
+	}
+	return s.Db.EditNote(inp.ID, inp.Body, user, s.clock().Now().UTC().Format(time.RFC3339))
+}
+
+type DeleteNoteRequest struct {
+	ID int64 `json:"id"`
+}
+
+// DeleteNote deletes a note with its history
+func (s *Server) DeleteNote(inp DeleteNoteRequest) error {
+	if inp.ID == 0 {
+		return errors.New("input missing mandatory field - ID")
+	}
+	return s.Db.DeleteNote(inp.ID)
+}
+
+type ListNotesRequest struct {
+	// type of the objects, all notes if empty
+	ObjectType string `json:"objectType"`
+	// object the notes are attached to, all objects of the type if empty
+	ObjectId string `json:"objectId"`
+}
+type ListNotesResponse tornjakTypes.NoteList
+
+// ListNotes returns the notes of an object, oldest first
+func (s *Server) ListNotes(inp ListNotesRequest) (*ListNotesResponse, error) {
+	var ids []string
+	if inp.ObjectType != "" {
+		if err := tornjakTypes.ValidateNoteObjectType(inp.ObjectType); err != nil {
+			return nil, err
+		}
+	}
+	if inp.ObjectId != "" {
+		if inp.ObjectType == "" {
+			return nil, errors.New("objectId requires objectType")
+		}
+		ids = []string{inp.ObjectId}
+	}
+	retVal, err := s.Db.GetNotes(inp.ObjectType, ids)
+	if err != nil {
+		return nil, err
+	}
+	return (*ListNotesResponse)(&retVal), nil
+}
+
+type GetNoteHistoryRequest struct {
+	ID int64 `json:"id"`
+}
+type GetNoteHistoryResponse tornjakTypes.NoteRevisionList
+
+// GetNoteHistory returns every version of the text of a note, oldest first
+func (s *Server) GetNoteHistory(inp GetNoteHistoryRequest) (*GetNoteHistoryResponse, error) {
+	if inp.ID == 0 {
+		return nil, errors.New("input missing mandatory field - ID")
+	}
+	retVal, err := s.Db.GetNoteRevisions(inp.ID)
+	if err != nil {
+		return nil, err
+	}
+	return (*GetNoteHistoryResponse)(&retVal), nil
+}
+
+// objectNotes returns the notes of the given objects by object ID, nil if none
+// lists of SPIRE entries may be long, so the notes of all objects of the type
+// are read rather than those of each object
+func (s *Server) objectNotes(objectType string, ids []string) (map[string][]tornjakTypes.Note, error) {
+	if s.Db == nil || len(ids) == 0 {
+		return nil, nil
+	}
+	list, err := s.Db.GetNotes(objectType, nil)
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		listed[id] = true
+	}
+	var notes map[string][]tornjakTypes.Note
+	for _, n := range list.Notes {
+		if !listed[n.ObjectId] {
+			continue
+		}
+		if notes == nil {
+			notes = make(map[string][]tornjakTypes.Note)
+		}
+		notes[n.ObjectId] = append(notes[n.ObjectId], n)
+	}
+	return notes, nil
+}
+
+// clusterListWithNotes is a list of clusters with the notes of the clusters by UID
+type clusterListWithNotes struct {
+	*ListClustersResponse
+	Notes map[string][]tornjakTypes.Note `json:"notes,omitempty"`
+}
+
+// withClusterNotes returns the list of clusters with their notes
+func (s *Server) withClusterNotes(ret *ListClustersResponse) (*clusterListWithNotes, error) {
+	ids := make([]string, 0, len(ret.Clusters))
+	for _, c := range ret.Clusters {
+		ids = append(ids, c.UID)
+	}
+	notes, err := s.objectNotes(tornjakTypes.NoteObjectCluster, ids)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterListWithNotes{ListClustersResponse: ret, Notes: notes}, nil
+}
+
+// agentListWithNotes is a list of agents with the notes of the agents by SPIFFE ID
+type agentListWithNotes struct {
+	*ListAgentMetadataResponse
+	Notes map[string][]tornjakTypes.Note `json:"notes,omitempty"`
+}
+
+// withAgentNotes returns the list of agents with their notes
+func (s *Server) withAgentNotes(ret *ListAgentMetadataResponse) (*agentListWithNotes, error) {
+	ids := make([]string, 0, len(ret.Agents))
+	for _, a := range ret.Agents {
+		ids = append(ids, a.Spiffeid)
+	}
+	notes, err := s.objectNotes(tornjakTypes.NoteObjectAgent, ids)
+	if err != nil {
+		return nil, err
+	}
+	return &agentListWithNotes{ListAgentMetadataResponse: ret, Notes: notes}, nil
+}
+
+// entryListWithNotes is a list of entries with the notes of the entries by entry ID
+type entryListWithNotes struct {
+	*ListEntriesResponse
+	Notes map[string][]tornjakTypes.Note `json:"notes,omitempty"`
+}
+
+// withEntryNotes returns the list of entries with their notes
+func (s *Server) withEntryNotes(ret *ListEntriesResponse) (*entryListWithNotes, error) {
+	ids := make([]string, 0, len(ret.Entries))
+	for _, e := range ret.Entries {
+		ids = append(ids, e.GetId())
+	}
+	notes, err := s.objectNotes(tornjakTypes.NoteObjectEntry, ids)
+	if err != nil {
+		return nil, err
+	}
+	return &entryListWithNotes{ListEntriesResponse: ret, Notes: notes}, nil
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/replication"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// defaults of the replication configuration
+const (
+	defaultReplicationInterval  = 10 * time.Second
+	defaultReplicationTimeout   = 30 * time.Second
+	defaultReplicationBatchSize = 500
+)
+
+// newReplicator returns the replicator of a standby for the replication configuration
+func (s *Server) newReplicator(config *ReplicationConfig) (*replication.Replicator, error) {
+	if config.PrimaryURL == "" {
+		return nil, errors.New("'primary_url' must be set")
+	}
+	interval, err := parseConfigDuration("interval", config.Interval, defaultReplicationInterval)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parseConfigDuration("timeout", config.Timeout, defaultReplicationTimeout)
+	if err != nil {
+		return nil, err
+	}
+	batchSize := config.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultReplicationBatchSize
+	}
+	if batchSize < 0 || batchSize > agentdb.MaxChangeLogEntries {
+		return nil, errors.Errorf("'batch_size' must be between 1 and %d", agentdb.MaxChangeLogEntries)
+	}
+	switch config.ConflictPolicy {
+	case "", tornjakTypes.ReplicationPrimaryWins, tornjakTypes.ReplicationStandbyWins:
+	default:
+		return nil, errors.Errorf("invalid 'conflict_policy': %q", config.ConflictPolicy)
+	}
+
+	apiKey := ""
+	if config.APIKeyFile != "" {
+		data, err := os.ReadFile(config.APIKeyFile)
+		if err != nil {
+			return nil, errors.Errorf("cannot read 'api_key_file': %v", err)
+		}
+		apiKey = strings.TrimSpace(string(data))
+	}
+	client := &http.Client{Timeout: timeout}
+	if config.CAFile != "" {
+		caCert, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, errors.Errorf("cannot read 'ca_file': %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.Errorf("no certificates in 'ca_file' %s", config.CAFile)
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}
+	}
+
+	return replication.New(replication.Config{
+		Primary:        replication.NewHTTPPrimary(config.PrimaryURL, apiKey, client),
+		Store:          s.Db.WithActor(auditActorReplication),
+		Interval:       interval,
+		BatchSize:      batchSize,
+		ConflictPolicy: config.ConflictPolicy,
+		Clock:          s.clock(),
+	}), nil
+}
+
+type GetReplicationChangesRequest struct {
+	// ID of the last entry of the history already replicated, 0 for all
+	After int64 `json:"after"`
+	// maximum number of entries, agentdb.MaxChangeLogEntries if 0 or larger
+	Limit int `json:"limit"`
+}
+type GetReplicationChangesResponse tornjakTypes.ClusterChangeLog
+
+// GetReplicationChanges returns the entries of the history of clusters
+// following the entry inp.After, for standby instances to replicate
+func (s *Server) GetReplicationChanges(inp GetReplicationChangesRequest) (*GetReplicationChangesResponse, error) {
+	if inp.After < 0 || inp.Limit < 0 {
+		return nil, errors.New("after and limit must not be negative")
+	}
+	if inp.Limit == 0 {
+		inp.Limit = agentdb.MaxChangeLogEntries
+	}
+	retVal, err := s.Db.GetClusterChangeLog(inp.After, inp.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return (*GetReplicationChangesResponse)(&retVal), nil
+}
+
+type GetReplicationSnapshotRequest struct{}
+type GetReplicationSnapshotResponse tornjakTypes.ReplicationSnapshot
+
+// GetReplicationSnapshot returns the clusters standby instances bootstrap
+// from, with the ID of the latest entry of the history of clusters
+// the ID is read first, so the clusters include its change and maybe later
+// ones, which the standby applies again when it follows the history
+func (s *Server) GetReplicationSnapshot(inp GetReplicationSnapshotRequest) (*GetReplicationSnapshotResponse, error) {
+	changes, err := s.Db.GetClusterChangeLog(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	clusters, err := s.Db.GetClusters()
+	if err != nil {
+		return nil, err
+	}
+	retVal := tornjakTypes.ReplicationSnapshot{LatestID: changes.LatestID, Clusters: clusters.Clusters}
+	return (*GetReplicationSnapshotResponse)(&retVal), nil
+}
+
+type GetReplicationStatusRequest struct{}
+type GetReplicationStatusResponse tornjakTypes.ReplicationStatus
+
+// GetReplicationStatus returns the progress of the replication of a standby
+func (s *Server) GetReplicationStatus(inp GetReplicationStatusRequest) (*GetReplicationStatusResponse, error) {
+	if s.replicator == nil {
+		return nil, errors.New("replication is not configured")
+	}
+	retVal := s.replicator.Status()
+	return (*GetReplicationStatusResponse)(&retVal), nil
+}
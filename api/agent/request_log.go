@@ -0,0 +1,151 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/spiffe/tornjak/pkg/agent/redaction"
+)
+
+// default number of bytes of each request and response body written to the request log
+const defaultRequestLogMaxBodyBytes = 4096
+
+// requestLogger writes a structured log line per API request, with bodies
+// masked by the configured redaction rules
+type requestLogger struct {
+	redactor     *redaction.Redactor
+	logBodies    bool
+	maxBodyBytes int
+}
+
+func newRequestLogger(redactPaths []string, logBodies bool, maxBodyBytes int) (*requestLogger, error) {
+	redactor, err := redaction.NewRedactor(redactPaths)
+	if err != nil {
+		return nil, err
+	}
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = defaultRequestLogMaxBodyBytes
+	}
+	return &requestLogger{
+		redactor:     redactor,
+		logBodies:    logBodies,
+		maxBodyBytes: maxBodyBytes,
+	}, nil
+}
+
+type requestLogRecord struct {
+	Time       string          `json:"time"`
+	RequestID  string          `json:"request_id,omitempty"`
+	User       string          `json:"user,omitempty"`
+	Method     string          `json:"method"`
+	Path       string          `json:"path"`
+	Status     int             `json:"status"`
+	DurationMs int64           `json:"duration_ms"`
+	Request    json.RawMessage `json:"request,omitempty"`
+	Response   json.RawMessage `json:"response,omitempty"`
+}
+
+// recordingResponseWriter keeps the status and a copy of the response body
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   *bytes.Buffer
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	if w.body != nil {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// paths of the routes whose response is a credential not found at a
+// redacted path, e.g. the value of a SPIRE join token; their response bodies
+// are never logged
+var credentialResponsePaths = map[string]bool{
+	"/api/agent/createjointoken":     true,
+	"/api/v1/spire/agents/jointoken": true,
+}
+
+// body returns the redacted body for the log, as JSON
+// bodies over the size limit are dropped, as redaction needs the complete
+// document, and so are bodies that are not JSON, e.g. CSV uploads, as they
+// cannot be redacted
+func (l *requestLogger) body(b []byte) json.RawMessage {
+	if len(b) == 0 {
+		return nil
+	}
+	if len(b) > l.maxBodyBytes {
+		ret, _ := json.Marshal("[body omitted: too large]")
+		return ret
+	}
+	if !json.Valid(b) {
+		ret, _ := json.Marshal(fmt.Sprintf("[body omitted: not JSON, %d bytes]", len(b)))
+		return ret
+	}
+	return l.redactor.Redact(b)
+}
+
+// requestLogMiddleware logs each API request once the response is written
+func (s *Server) requestLogMiddleware(next http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		l := s.requestLogger
+		if l == nil || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		var reqBody []byte
+		rw := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		if l.logBodies {
+			var err error
+			reqBody, err = io.ReadAll(r.Body)
+			if err != nil {
+				retError(w, "Error parsing data: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(reqBody))
+			rw.body = new(bytes.Buffer)
+		}
+
+		next.ServeHTTP(rw, r)
+
+		record := requestLogRecord{
+			Time:       start.UTC().Format(time.RFC3339),
+			RequestID:  requestIDFromContext(r.Context()),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     rw.status,
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		if userInfo := userFromContext(r.Context()); userInfo != nil {
+			record.User = userInfo.Username
+		}
+		if l.logBodies {
+			record.Request = l.body(reqBody)
+			if credentialResponsePaths[r.URL.Path] {
+				record.Response, _ = json.Marshal("[body omitted: credential]")
+			} else {
+				record.Response = l.body(rw.body.Bytes())
+			}
+		}
+		line, err := json.Marshal(record)
+		if err != nil {
+			log.Printf("WARNING: could not log request %s %s: %v", r.Method, r.URL.Path, err)
+			return
+		}
+		log.Printf("request: %s", line)
+	}
+	return http.HandlerFunc(f)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestRequestLogRedaction checks credentials are never logged, also without
+// configured redaction paths, and that bodies that are not JSON are logged
+// as a placeholder
+func TestRequestLogRedaction(t *testing.T) {
+	logger, err := newRequestLogger(nil, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{requestLogger: logger}
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	for _, tc := range []struct {
+		name     string
+		path     string
+		request  string
+		response string
+		logged   []string
+		hidden   []string
+	}{
+		{"service account key", "/api/v1/tornjak/serviceaccounts", `{"name":"ci"}`, `{"name":"ci","apiKey":"tjk_secret"}`,
+			[]string{`"name":"ci"`, `"apiKey":"[REDACTED]"`}, []string{"tjk_secret"}},
+		{"bootstrap token", "/api/v1/tornjak/bootstrap/tokens", `{}`, `{"token":"jt_secret","spiffeid":"spiffe://example.org/a"}`,
+			[]string{`"token":"[REDACTED]"`}, []string{"jt_secret"}},
+		{"join token", "/api/v1/spire/agents/jointoken", `{"ttl":600}`, `{"value":"spire_secret"}`,
+			[]string{"[body omitted: credential]"}, []string{"spire_secret"}},
+		{"CSV upload", "/api/v1/tornjak/agents/assignments", "spiffeid,cluster_uid\nspiffe://example.org/a,secret_uid\n", `SUCCESS`,
+			[]string{"[body omitted: not JSON, 55 bytes]", "[body omitted: not JSON, 7 bytes]"}, []string{"secret_uid"}},
+	} {
+		out.Reset()
+		handler := s.requestLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(tc.response))
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.request)))
+
+		line := out.String()
+		for _, s := range tc.logged {
+			if !strings.Contains(line, s) {
+				t.Fatalf("%s: expected %q in log line %s", tc.name, s, line)
+			}
+		}
+		for _, s := range tc.hidden {
+			if strings.Contains(line, s) {
+				t.Fatalf("%s: %q logged in %s", tc.name, s, line)
+			}
+		}
+	}
+}
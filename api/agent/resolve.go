@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	agent "github.com/spiffe/spire-api-sdk/proto/spire/api/server/agent/v1"
+	types "github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// sources of the objects identifiers are resolved against, the keys of the errors
+const (
+	resolveSourceDB    = "db"
+	resolveSourceSPIRE = "spire"
+)
+
+type ResolveObjectRequest struct {
+	// cluster UID or name, agent SPIFFE ID or entry ID
+	ID string `json:"id"`
+}
+type ResolveObjectResponse tornjakTypes.ResolvedObjectList
+
+// ResolveObject returns the clusters, agents and entries an identifier refers
+// to, with links to their related objects, for deep links into the UI
+// SPIFFE IDs are resolved to agents, other identifiers to clusters by UID or
+// name and to entries by ID
+// sources that cannot be searched are reported in the errors rather than
+// failing the lookup
+func (s *Server) ResolveObject(ctx context.Context, inp ResolveObjectRequest) (*ResolveObjectResponse, error) {
+	id := strings.TrimSpace(inp.ID)
+	if len(id) == 0 {
+		return nil, errors.New("input missing mandatory field - ID")
+	}
+	retVal := tornjakTypes.ResolvedObjectList{Query: id, Objects: []tornjakTypes.ResolvedObject{}}
+	addError := func(source string, err error) {
+		if retVal.Errors == nil {
+			retVal.Errors = map[string]string{}
+		}
+		retVal.Errors[source] = err.Error()
+	}
+
+	clusters, err := s.Db.GetClusters()
+	if err != nil {
+		addError(resolveSourceDB, err)
+	}
+	clusterUIDs := map[string]string{}
+	for _, cluster := range clusters.Clusters {
+		clusterUIDs[cluster.Name] = cluster.UID
+	}
+
+	if strings.HasPrefix(id, "spiffe://") {
+		object, ok, err := s.resolveAgent(ctx, id, clusterUIDs)
+		if err != nil {
+			addError(resolveSourceSPIRE, err)
+		}
+		if ok {
+			retVal.Objects = append(retVal.Objects, object)
+		}
+		return (*ResolveObjectResponse)(&retVal), nil
+	}
+
+	for _, cluster := range clusters.Clusters {
+		matchedBy := ""
+		switch id {
+		case cluster.UID:
+			matchedBy = "uid"
+		case cluster.Name:
+			matchedBy = "name"
+		default:
+			continue
+		}
+		retVal.Objects = append(retVal.Objects, tornjakTypes.ResolvedObject{
+			Type:      tornjakTypes.NoteObjectCluster,
+			ID:        cluster.UID,
+			Name:      cluster.Name,
+			MatchedBy: matchedBy,
+			Links: []tornjakTypes.ObjectLink{
+				{Rel: "agents", Href: "/api/v1/tornjak/clusters/agents?uid=" + url.QueryEscape(cluster.UID)},
+				notesLink(tornjakTypes.NoteObjectCluster, cluster.UID),
+			},
+		})
+	}
+
+	resp, err := s.GetEntry(ctx, GetEntryRequest{Id: id})
+	switch status.Code(err) {
+	case codes.OK:
+		entry := (*types.Entry)(resp)
+		parent := "spiffe://" + entry.GetParentId().GetTrustDomain() + entry.GetParentId().GetPath()
+		object := tornjakTypes.ResolvedObject{
+			Type:      tornjakTypes.NoteObjectEntry,
+			ID:        entry.GetId(),
+			Name:      "spiffe://" + entry.GetSpiffeId().GetTrustDomain() + entry.GetSpiffeId().GetPath(),
+			MatchedBy: "id",
+			Links: []tornjakTypes.ObjectLink{
+				{Rel: "parent", Type: tornjakTypes.NoteObjectAgent, ID: parent},
+			},
+		}
+		if agents, err := s.Db.GetAgentsMetadata(tornjakTypes.AgentMetadataRequest{Agents: []string{parent}}); err != nil {
+			addError(resolveSourceDB, err)
+		} else if len(agents.Agents) > 0 {
+			if link, ok := clusterLink(agents.Agents[0].Cluster, clusterUIDs); ok {
+				object.Links = append(object.Links, link)
+			}
+		}
+		object.Links = append(object.Links, notesLink(tornjakTypes.NoteObjectEntry, object.ID))
+		retVal.Objects = append(retVal.Objects, object)
+	case codes.NotFound, codes.InvalidArgument:
+	default:
+		addError(resolveSourceSPIRE, err)
+	}
+	return (*ResolveObjectResponse)(&retVal), nil
+}
+
+// resolveAgent returns the agent with the given SPIFFE ID, known to Tornjak or SPIRE
+func (s *Server) resolveAgent(ctx context.Context, spiffeid string, clusterUIDs map[string]string) (tornjakTypes.ResolvedObject, bool, error) {
+	object := tornjakTypes.ResolvedObject{
+		Type:      tornjakTypes.NoteObjectAgent,
+		ID:        spiffeid,
+		MatchedBy: "spiffeid",
+	}
+	agents, err := s.Db.GetAgentsMetadata(tornjakTypes.AgentMetadataRequest{Agents: []string{spiffeid}})
+	if err == nil && len(agents.Agents) > 0 {
+		object.Name = agents.Agents[0].DisplayName
+		if link, ok := clusterLink(agents.Agents[0].Cluster, clusterUIDs); ok {
+			object.Links = append(object.Links, link)
+		}
+	} else {
+		conn, err := s.dialSPIRE()
+		if err != nil {
+			return object, false, err
+		}
+		defer conn.Close()
+		_, err = getAgentDetails(ctx, agent.NewAgentClient(conn), spiffeid)
+		if status.Code(err) == codes.NotFound {
+			return object, false, nil
+		} else if err != nil {
+			return object, false, err
+		}
+	}
+	object.Links = append(object.Links,
+		tornjakTypes.ObjectLink{Rel: "annotations", Href: "/api/v1/tornjak/agents/annotations?spiffeid=" + url.QueryEscape(spiffeid)},
+		notesLink(tornjakTypes.NoteObjectAgent, spiffeid))
+	return object, true, nil
+}
+
+// clusterLink returns the link to the cluster with the given name, if it exists
+func clusterLink(name string, clusterUIDs map[string]string) (tornjakTypes.ObjectLink, bool) {
+	uid, ok := clusterUIDs[name]
+	if name == "" || !ok {
+		return tornjakTypes.ObjectLink{}, false
+	}
+	return tornjakTypes.ObjectLink{Rel: "cluster", Type: tornjakTypes.NoteObjectCluster, ID: uid,
+		Href: "/api/v1/tornjak/clusters/agents?uid=" + url.QueryEscape(uid)}, true
+}
+
+// notesLink returns the link to the notes attached to an object
+func notesLink(objectType, objectId string) tornjakTypes.ObjectLink {
+	return tornjakTypes.ObjectLink{Rel: "notes",
+		Href: "/api/v1/tornjak/notes?objectType=" + objectType + "&objectId=" + url.QueryEscape(objectId)}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/retryqueue"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// defaults of the retry queue configuration
+const (
+	defaultRetryInterval    = time.Minute
+	defaultRetryMaxAttempts = 10
+)
+
+// steps of composite operations queued for retry when they fail
+const (
+	// records the lineage of a cloned SPIRE entry in the local DB
+	stepRecordEntryLineage = "record_entry_lineage"
+)
+
+// newRetryQueue returns the retry queue for the retry_queue configuration,
+// the defaults if config is nil
+func (s *Server) newRetryQueue(config *RetryQueueConfig) (*retryqueue.Queue, error) {
+	if config == nil {
+		config = &RetryQueueConfig{}
+	}
+	interval, err := parseConfigDuration("interval", config.Interval, defaultRetryInterval)
+	if err != nil {
+		return nil, err
+	}
+	maxAttempts := defaultRetryMaxAttempts
+	if config.MaxAttempts != 0 {
+		if config.MaxAttempts < 1 {
+			return nil, errors.Errorf("'max_attempts' must be positive, got %d", config.MaxAttempts)
+		}
+		maxAttempts = config.MaxAttempts
+	}
+
+	return retryqueue.New(retryqueue.Config{
+		Store: s.Db,
+		Steps: map[string]retryqueue.Step{
+			stepRecordEntryLineage: s.recordEntryLineageStep,
+		},
+		Interval:    interval,
+		MaxAttempts: maxAttempts,
+		Clock:       s.Clock,
+	}), nil
+}
+
+func (s *Server) recordEntryLineageStep(ctx context.Context, payload []byte) error {
+	var lineage tornjakTypes.EntryLineage
+	if err := json.Unmarshal(payload, &lineage); err != nil {
+		return err
+	}
+	// the lineage may have been recorded by an attempt that failed to report success
+	if existing, err := s.Db.GetEntryLineage(lineage.EntryId); err == nil && existing.SourceEntryId == lineage.SourceEntryId {
+		return nil
+	}
+	return s.Db.CreateEntryLineage(lineage)
+}
+
+type ListFailedOperationsRequest struct {
+	// one of pending, stuck or resolved, all if empty
+	State string `json:"state"`
+}
+type ListFailedOperationsResponse tornjakTypes.FailedOperationList
+
+// ListFailedOperations returns the partially failed operations of the retry queue, oldest first
+func (s *Server) ListFailedOperations(inp ListFailedOperationsRequest) (*ListFailedOperationsResponse, error) {
+	if s.retryQueue == nil {
+		return nil, errors.New("retry queue requires a DataStore plugin storing failed operations")
+	}
+	switch inp.State {
+	case "", tornjakTypes.FailedOperationPending, tornjakTypes.FailedOperationStuck, tornjakTypes.FailedOperationResolved:
+	default:
+		return nil, errors.Errorf("invalid state %q", inp.State)
+	}
+	retVal, err := s.Db.GetFailedOperations(inp.State)
+	if err != nil {
+		return nil, err
+	}
+	return (*ListFailedOperationsResponse)(&retVal), nil
+}
+
+// actions of operators on failed operations
+const (
+	// attempt the step again now
+	failedOperationRetry = "retry"
+	// mark the operation resolved, once the inconsistency was fixed by hand
+	failedOperationResolve = "resolve"
+)
+
+type ResolveFailedOperationRequest struct {
+	Id int64 `json:"id"`
+	// retry or resolve
+	Action string `json:"action"`
+	Note   string `json:"note"`
+}
+type ResolveFailedOperationResponse tornjakTypes.FailedOperation
+
+// ResolveFailedOperation retries a pending or stuck operation, or marks it resolved
+func (s *Server) ResolveFailedOperation(ctx context.Context, inp ResolveFailedOperationRequest) (*ResolveFailedOperationResponse, error) {
+	if s.retryQueue == nil {
+		return nil, errors.New("retry queue requires a DataStore plugin storing failed operations")
+	}
+	if inp.Id == 0 {
+		return nil, errors.New("input missing mandatory field - Id")
+	}
+	var retVal tornjakTypes.FailedOperation
+	var err error
+	switch inp.Action {
+	case failedOperationRetry:
+		retVal, err = s.retryQueue.Retry(ctx, inp.Id)
+	case failedOperationResolve:
+		user := ""
+		if u := userFromContext(ctx); u != nil {
+			user = u.Username
+		}
+		retVal, err = s.retryQueue.Resolve(inp.Id, user, inp.Note)
+	default:
+		return nil, errors.Errorf("invalid action %q, expected %q or %q", inp.Action, failedOperationRetry, failedOperationResolve)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return (*ResolveFailedOperationResponse)(&retVal), nil
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -16,8 +17,26 @@ import (
 	"github.com/hashicorp/hcl/hcl/ast"
 
 	"github.com/spiffe/tornjak/pkg/agent/authentication/authenticator"
+	"github.com/spiffe/tornjak/pkg/agent/authentication/user"
 	"github.com/spiffe/tornjak/pkg/agent/authorization"
+	"github.com/spiffe/tornjak/pkg/agent/bootstrap"
+	"github.com/spiffe/tornjak/pkg/agent/bulkdelete"
+	"github.com/spiffe/tornjak/pkg/agent/bundlemonitor"
+	"github.com/spiffe/tornjak/pkg/agent/cache"
+	"github.com/spiffe/tornjak/pkg/agent/clock"
 	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/lifecycle"
+	"github.com/spiffe/tornjak/pkg/agent/middleware"
+	"github.com/spiffe/tornjak/pkg/agent/proposal"
+	"github.com/spiffe/tornjak/pkg/agent/reconciler"
+	"github.com/spiffe/tornjak/pkg/agent/replication"
+	"github.com/spiffe/tornjak/pkg/agent/retryqueue"
+	"github.com/spiffe/tornjak/pkg/agent/spireerror"
+	"github.com/spiffe/tornjak/pkg/agent/telemetry"
+	"github.com/spiffe/tornjak/pkg/agent/ttladvisor"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+	"github.com/spiffe/tornjak/pkg/agent/webhook"
+	"github.com/spiffe/tornjak/pkg/encryption"
 )
 
 type Server struct {
@@ -30,10 +49,107 @@ type Server struct {
 	// Information from Tornjak Config file passed in as argument
 	TornjakConfig *TornjakConfig
 
+	// source of time of the server and its background jobs, the clock of the system if nil
+	Clock clock.Clock
+
 	// Plugins
 	Db            agentdb.AgentDB
 	Authenticator authenticator.Authenticator
 	Authorizer    authorization.Authorizer
+	Cache         cache.Cache
+	Encryption    encryption.Provider
+
+	// hashes the API keys and bootstrap tokens stored in the DataStore,
+	// with the pepper kept by the Encryption plugin if configured
+	keyHasher *encryption.Hasher
+
+	// caches decisions of the Authorizer, nil if disabled
+	authzCache *authorization.CachingAuthorizer
+
+	// enforces the RBAC policy and evaluates requests against it, nil without the RBAC Authorizer
+	policyAuthorizer *authorization.PolicyAuthorizer
+
+	// bounds concurrent calls to the SPIRE server, nil if unlimited
+	spireLimiter *spireCallLimiter
+
+	// logs API requests with redacted bodies, nil if disabled
+	requestLogger *requestLogger
+
+	// schemas of cluster extension fields by platform type
+	clusterExtensions tornjakTypes.ClusterExtensionSchemas
+	// JSON schema of the metadata of clusters, zero if any metadata is accepted
+	clusterMetadata tornjakTypes.ClusterMetadataSchema
+	// policies of the names, labels and notes of the objects stored in the DB
+	objectPolicies tornjakTypes.ObjectPolicies
+
+	// read-only copy of SPIRE entries and agents kept in the cache, nil if disabled
+	spireMirror *spireMirror
+
+	// reconciles clusters towards a desired-state document, nil if disabled
+	reconciler *reconciler.Reconciler
+
+	// replicates the clusters of a primary Tornjak into the DataStore, nil unless a standby
+	replicator *replication.Replicator
+
+	// commits cluster changes to git for review instead of applying them, nil if disabled
+	proposer *proposal.Proposer
+
+	// verifies requests to webhook receivers, nil if disabled
+	webhookVerifier *webhook.Verifier
+
+	// band entry TTLs are checked against, nil if not configured
+	ttlPolicy *ttladvisor.Policy
+
+	// checks the bundles of federated trust domains, nil if disabled
+	bundleMonitor *bundlemonitor.Monitor
+
+	// serves the bundle of the trust domain to federation partners, nil if disabled
+	bundleEndpoint *bundleEndpoint
+
+	// issues join tokens to provisioning systems and tracks their use, nil if disabled
+	bootstrapBroker *bootstrap.Broker
+
+	// deletes entries past their scheduled removal, nil if disabled
+	lifecycleEnforcer *lifecycle.Enforcer
+
+	// retries the incomplete steps of partially failed operations, nil without a DataStore
+	retryQueue *retryqueue.Queue
+
+	// asynchronous uploads of agent assignments
+	assignmentJobs *assignmentJobs
+
+	// aggregates of the home page refreshed in the background, nil without a DataStore
+	dashboard *dashboard
+
+	// counts API usage and sends anonymous reports if enabled
+	telemetry *telemetry.Reporter
+
+	// deletes the entries matching a filter in batches
+	entryDeleter *bulkdelete.Deleter
+
+	// faults injected in dev builds
+	chaos *chaosState
+
+	// first fill of the caches awaited by the readiness probe, nil if disabled
+	warmUp *warmUp
+
+	// availability of the DataStore, probed in the background; nil without a DataStore
+	datastoreHealth *datastoreHealth
+
+	// checks the DataStore for inconsistent rows in the background, nil without a DataStore
+	integrityCheck *integrityCheck
+
+	// removes the memberships of deleted clusters in the background, nil if
+	// the DataStore does not support it
+	clusterDeletions *clusterDeletions
+
+	// pprof, expvar and runtime settings for admins, nil if disabled
+	diagnostics *diagnostics
+}
+
+// clock returns the source of time of the server
+func (s *Server) clock() clock.Clock {
+	return clock.OrNew(s.Clock)
 }
 
 // config type, as defined by SPIRE
@@ -50,7 +166,7 @@ func cors(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, DELETE, PATCH")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, access-control-allow-origin, access-control-allow-headers, access-control-allow-credentials, Authorization, access-control-allow-methods")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, access-control-allow-origin, access-control-allow-headers, access-control-allow-credentials, Authorization, access-control-allow-methods, traceparent, tracestate, x-request-id, x-tornjak-api-key")
 	w.Header().Set("Access-Control-Expose-Headers", "*, Authorization")
 	w.WriteHeader(http.StatusOK)
 }
@@ -59,11 +175,41 @@ func retError(w http.ResponseWriter, emsg string, status int) {
 	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, DELETE, PATCH")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, access-control-allow-origin, access-control-allow-headers, access-control-allow-credentials, Authorization, access-control-allow-methods")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, access-control-allow-origin, access-control-allow-headers, access-control-allow-credentials, Authorization, access-control-allow-methods, traceparent, tracestate, x-request-id, x-tornjak-api-key")
 	w.Header().Set("Access-Control-Expose-Headers", "*, Authorization")
 	http.Error(w, emsg, status)
 }
 
+// retSPIREError returns an error of the SPIRE server as a Tornjak error code
+// with a remediation hint, rather than the opaque gRPC string
+// errors without a gRPC status are returned as by retError with the given status
+func retSPIREError(w http.ResponseWriter, err error, status int) {
+	spireErr, ok := spireerror.FromError(err)
+	if !ok {
+		retError(w, fmt.Sprintf("Error: %v", err.Error()), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, DELETE, PATCH")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, access-control-allow-origin, access-control-allow-headers, access-control-allow-credentials, Authorization, access-control-allow-methods, traceparent, tracestate, x-request-id, x-tornjak-api-key")
+	w.Header().Set("Access-Control-Expose-Headers", "*, Authorization")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(spireErr.Status)
+	if encErr := json.NewEncoder(w).Encode(spireErr); encErr != nil {
+		log.Printf("WARNING: could not write SPIRE error: %v", encErr)
+	}
+}
+
+type userContextKey struct{}
+
+// userFromContext returns the authenticated user stored by verificationMiddleware
+// returns nil if no Authenticator is configured
+func userFromContext(ctx context.Context) *user.UserInfo {
+	userInfo, _ := ctx.Value(userContextKey{}).(*user.UserInfo)
+	return userInfo
+}
+
 // Handle preflight checks
 func (s *Server) verificationMiddleware(next http.Handler) http.Handler {
 	f := func(w http.ResponseWriter, r *http.Request) {
@@ -82,7 +228,8 @@ func (s *Server) verificationMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), userContextKey{}, userInfo)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 	return http.HandlerFunc(f)
 }
@@ -178,6 +325,8 @@ func (s *Server) GetRouter() http.Handler {
 
 	// Healthcheck (never goes through authn/authz layers)
 	healthRtr.HandleFunc("", s.health)
+	// Readiness, fails until the caches are primed if warm-up is enabled
+	rtr.HandleFunc("/readyz", s.ready)
 
 	// Home
 	apiRtr.HandleFunc("/", s.home)
@@ -204,10 +353,11 @@ func (s *Server) GetRouter() http.Handler {
 	apiRtr.HandleFunc("/api/tornjak/selectors/list", s.tornjakSelectorsList)
 	apiRtr.HandleFunc("/api/tornjak/agents/list", s.tornjakAgentsList)
 	// Clusters
+	clusterCreate, clusterEdit, clusterDelete := s.clusterHandlers()
 	apiRtr.HandleFunc("/api/tornjak/clusters/list", s.clusterList)
-	apiRtr.HandleFunc("/api/tornjak/clusters/create", s.clusterCreate)
-	apiRtr.HandleFunc("/api/tornjak/clusters/edit", s.clusterEdit)
-	apiRtr.HandleFunc("/api/tornjak/clusters/delete", s.clusterDelete)
+	apiRtr.HandleFunc("/api/tornjak/clusters/create", clusterCreate)
+	apiRtr.HandleFunc("/api/tornjak/clusters/edit", clusterEdit)
+	apiRtr.HandleFunc("/api/tornjak/clusters/delete", clusterDelete)
 
 	// Spire APIs with versioning
 	apiRtr.HandleFunc("/api/v1/spire/serverinfo", s.debugServer).Methods(http.MethodGet, http.MethodOptions)
@@ -219,6 +369,7 @@ func (s *Server) GetRouter() http.Handler {
 	apiRtr.HandleFunc("/api/v1/spire/entries", s.entryList).Methods(http.MethodGet, http.MethodOptions)
 	apiRtr.HandleFunc("/api/v1/spire/entries", s.entryCreate).Methods(http.MethodPost)
 	apiRtr.HandleFunc("/api/v1/spire/entries", s.entryDelete).Methods(http.MethodDelete)
+	apiRtr.HandleFunc("/api/v1/spire/entries/clone", s.entryClone).Methods(http.MethodPost, http.MethodOptions)
 	apiRtr.HandleFunc("/api/v1/spire/bundle", s.bundleGet).Methods(http.MethodGet, http.MethodOptions)
 	apiRtr.HandleFunc("/api/v1/spire/federations/bundles", s.federatedBundleList).Methods(http.MethodGet, http.MethodOptions)
 	apiRtr.HandleFunc("/api/v1/spire/federations/bundles", s.federatedBundleCreate).Methods(http.MethodPost)
@@ -229,20 +380,142 @@ func (s *Server) GetRouter() http.Handler {
 	apiRtr.HandleFunc("/api/v1/spire/federations", s.federationUpdate).Methods(http.MethodPatch)
 	apiRtr.HandleFunc("/api/v1/spire/federations", s.federationDelete).Methods(http.MethodDelete)
 
+	// SPIRE mirror served from the cache
+	apiRtr.HandleFunc("/api/v1/mirror/spire/entries", s.mirrorEntryList).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/mirror/spire/agents", s.mirrorAgentList).Methods(http.MethodGet, http.MethodOptions)
+
 	// Tornjak specific
 	apiRtr.HandleFunc("/api/v1/tornjak/serverinfo", s.tornjakGetServerInfo).Methods(http.MethodGet, http.MethodOptions)
 	// Agents Selectors
 	apiRtr.HandleFunc("/api/v1/tornjak/selectors", s.tornjakPluginDefine).Methods(http.MethodPost, http.MethodOptions)
 	apiRtr.HandleFunc("/api/v1/tornjak/selectors", s.tornjakSelectorsList).Methods(http.MethodGet)
+	apiRtr.HandleFunc("/api/v1/tornjak/selectors/plugins", s.tornjakPluginTypesList).Methods(http.MethodGet, http.MethodOptions)
 	apiRtr.HandleFunc("/api/v1/tornjak/agents", s.tornjakAgentsList).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/agents", s.tornjakAgentDisplayNameSet).Methods(http.MethodPatch)
+	apiRtr.HandleFunc("/api/v1/tornjak/agents/match", s.tornjakAgentsFind).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/agents/annotations", s.tornjakAgentAnnotationsList).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/agents/annotations", s.tornjakAgentAnnotationSet).Methods(http.MethodPost)
+	apiRtr.HandleFunc("/api/v1/tornjak/agents/annotations", s.tornjakAgentAnnotationDelete).Methods(http.MethodDelete)
+	apiRtr.HandleFunc("/api/v1/tornjak/agents/compliance", s.tornjakAgentComplianceHistory).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/agents/compliance", s.webhookReceiver(s.tornjakAgentComplianceReport)).Methods(http.MethodPost)
+	// Entry lineage
+	apiRtr.HandleFunc("/api/v1/tornjak/entries/lineage", s.tornjakEntryLineageGet).Methods(http.MethodGet, http.MethodOptions)
+	// Object references
+	apiRtr.HandleFunc("/api/v1/tornjak/resolve", s.tornjakObjectResolve).Methods(http.MethodGet, http.MethodOptions)
+	// Service accounts
+	apiRtr.HandleFunc("/api/v1/tornjak/serviceaccounts", s.tornjakServiceAccountsList).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/serviceaccounts", s.tornjakServiceAccountCreate).Methods(http.MethodPost)
+	apiRtr.HandleFunc("/api/v1/tornjak/serviceaccounts", s.tornjakServiceAccountDelete).Methods(http.MethodDelete)
+	apiRtr.HandleFunc("/api/v1/tornjak/authorization/evaluate", s.tornjakPolicyEvaluate).Methods(http.MethodPost, http.MethodOptions)
+	// Ownership of clusters and entries
+	apiRtr.HandleFunc("/api/v1/tornjak/entries/owners", s.tornjakEntryOwnersList).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/entries/owners", s.tornjakEntryOwnerSet).Methods(http.MethodPost)
+	// lifecycle states of entries, scheduled removals are enforced by the entry_lifecycle worker
+	apiRtr.HandleFunc("/api/v1/tornjak/entries/lifecycle", s.tornjakEntryLifecyclesList).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/entries/lifecycle", s.tornjakEntryLifecycleSet).Methods(http.MethodPost)
+	// markdown notes of operators on clusters, agents and entries
+	apiRtr.HandleFunc("/api/v1/tornjak/notes", s.tornjakNotesList).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/notes", s.tornjakNoteCreate).Methods(http.MethodPost)
+	apiRtr.HandleFunc("/api/v1/tornjak/notes", s.tornjakNoteEdit).Methods(http.MethodPatch)
+	apiRtr.HandleFunc("/api/v1/tornjak/notes", s.tornjakNoteDelete).Methods(http.MethodDelete)
+	apiRtr.HandleFunc("/api/v1/tornjak/notes/history", s.tornjakNoteHistoryGet).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/ownership/transfer", s.tornjakOwnershipTransfer).Methods(http.MethodPost, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/ownership/transfers", s.tornjakOwnershipTransfersList).Methods(http.MethodGet, http.MethodOptions)
+	// changes of clusters, agents and memberships with the users making them
+	apiRtr.HandleFunc("/api/v1/tornjak/audit", s.tornjakAuditEventsList).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters/history", s.tornjakClusterHistoryGet).Methods(http.MethodGet, http.MethodOptions)
+	// schema registry of the events of the audit log as CloudEvents
+	apiRtr.HandleFunc("/api/v1/tornjak/events/schemas", s.tornjakEventSchemasGet).Methods(http.MethodGet, http.MethodOptions)
+	// Bulk label operations on clusters and agents
+	apiRtr.HandleFunc("/api/v1/tornjak/labels/bulk", s.tornjakLabelOperationApply).Methods(http.MethodPost, http.MethodOptions)
+	// Desired state
+	apiRtr.HandleFunc("/api/v1/tornjak/desiredstate", s.tornjakDesiredStateGet).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/desiredstate/reconcile", s.tornjakDesiredStateReconcile).Methods(http.MethodPost, http.MethodOptions)
+	// Replication of clusters to standby instances
+	apiRtr.HandleFunc("/api/v1/tornjak/replication/changes", s.tornjakReplicationChangesGet).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/replication/snapshot", s.tornjakReplicationSnapshotGet).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/replication/status", s.tornjakReplicationStatusGet).Methods(http.MethodGet, http.MethodOptions)
+	// Federated bundle freshness
+	apiRtr.HandleFunc("/api/v1/tornjak/federations/freshness", s.tornjakBundleFreshnessGet).Methods(http.MethodGet, http.MethodOptions)
+	// trust domain bundle served to federation partners
+	apiRtr.HandleFunc("/api/v1/tornjak/bundle-endpoint/status", s.tornjakBundleEndpointStatusGet).Methods(http.MethodGet, http.MethodOptions)
+	// TTL policy of entries
+	apiRtr.HandleFunc("/api/v1/tornjak/entries/ttl/advice", s.tornjakEntryTTLAdviceGet).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/entries/ttl/remediate", s.tornjakEntryTTLRemediate).Methods(http.MethodPost, http.MethodOptions)
+	// SPIRE query log
+	apiRtr.HandleFunc("/api/v1/tornjak/spire/calls", s.tornjakSPIRECallsList).Methods(http.MethodGet, http.MethodOptions)
+	// Bootstrap tokens
+	apiRtr.HandleFunc("/api/v1/tornjak/bootstrap/tokens", s.tornjakBootstrapTokensList).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/bootstrap/tokens", s.tornjakBootstrapTokenIssue).Methods(http.MethodPost)
+	// DB transaction metrics
+	apiRtr.HandleFunc("/api/v1/tornjak/db/transactions", s.tornjakTxStatsGet).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/db/indexes", s.tornjakIndexReportGet).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/db/integrity", s.tornjakIntegrityReportGet).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/db/integrity", s.tornjakIntegrityCheck).Methods(http.MethodPost)
+	// Schema of cluster and agent metadata
+	apiRtr.HandleFunc("/api/v1/tornjak/metadata/schema", s.tornjakMetadataSchemaGet).Methods(http.MethodGet, http.MethodOptions)
+	// Retry queue of partially failed operations
+	apiRtr.HandleFunc("/api/v1/tornjak/operations/failed", s.tornjakFailedOperationsList).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/operations/failed", s.tornjakFailedOperationResolve).Methods(http.MethodPost)
+	// Bulk agent to cluster assignments
+	if s.assignmentJobs == nil {
+		s.assignmentJobs = newAssignmentJobs()
+	}
+	apiRtr.HandleFunc("/api/v1/tornjak/agents/assignments", s.tornjakAgentAssignmentsUpload).Methods(http.MethodPost, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/agents/assignments/jobs", s.tornjakAgentAssignmentJobsList).Methods(http.MethodGet, http.MethodOptions)
+	// Named snapshots
+	apiRtr.HandleFunc("/api/v1/tornjak/snapshots", s.tornjakSnapshotsList).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/snapshots", s.tornjakSnapshotCreate).Methods(http.MethodPost)
+	apiRtr.HandleFunc("/api/v1/tornjak/snapshots", s.tornjakSnapshotDelete).Methods(http.MethodDelete)
+	apiRtr.HandleFunc("/api/v1/tornjak/snapshots/restore", s.tornjakSnapshotRestore).Methods(http.MethodPost, http.MethodOptions)
+	// Aggregates of the home page
+	apiRtr.HandleFunc("/api/v1/tornjak/dashboard", s.tornjakDashboardGet).Methods(http.MethodGet, http.MethodOptions)
+	// Opt-in usage telemetry
+	apiRtr.HandleFunc("/api/v1/tornjak/telemetry/preview", s.tornjakTelemetryPreview).Methods(http.MethodGet, http.MethodOptions)
+	// Bulk deletion of entries by filter
+	apiRtr.HandleFunc("/api/v1/tornjak/entries/bulk-delete", s.tornjakEntryBulkDelete).Methods(http.MethodPost, http.MethodOptions)
 	// Clusters
 	apiRtr.HandleFunc("/api/v1/tornjak/clusters", s.clusterList).Methods(http.MethodGet, http.MethodOptions)
-	apiRtr.HandleFunc("/api/v1/tornjak/clusters", s.clusterCreate).Methods(http.MethodPost)
-	apiRtr.HandleFunc("/api/v1/tornjak/clusters", s.clusterEdit).Methods(http.MethodPatch)
-	apiRtr.HandleFunc("/api/v1/tornjak/clusters", s.clusterDelete).Methods(http.MethodDelete)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters", clusterCreate).Methods(http.MethodPost)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters", clusterEdit).Methods(http.MethodPatch)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters", clusterDelete).Methods(http.MethodDelete)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters/agents", s.clusterAgentsList).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters/agents/move", s.clusterAgentsMove).Methods(http.MethodPost, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters/search", s.clusterSearch).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters/protection", s.clusterProtectionSet).Methods(http.MethodPost, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters/rename", s.clusterRename).Methods(http.MethodPost, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters/bulk", s.clusterBulkCreate).Methods(http.MethodPost, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters/bulk-delete", s.clusterBulkDelete).Methods(http.MethodPost, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters/deleted", s.clusterDeletedList).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters/deletions", s.clusterDeletionList).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters/restore", s.clusterRestore).Methods(http.MethodPost, http.MethodOptions)
+	// Cluster-scoped API tokens
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters/tokens", s.tornjakClusterTokensList).Methods(http.MethodGet, http.MethodOptions)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters/tokens", s.tornjakClusterTokenCreate).Methods(http.MethodPost)
+	apiRtr.HandleFunc("/api/v1/tornjak/clusters/tokens", s.tornjakClusterTokenDelete).Methods(http.MethodDelete)
+
+	// fault injection, only in dev builds
+	s.registerChaosRoutes(apiRtr)
+	// runtime diagnostics, unless served on the admin listener
+	if s.diagnostics != nil && s.diagnostics.addr == "" {
+		s.registerDiagnosticsRoutes(apiRtr)
+	}
 
 	// Middleware
+	validator, err := newRequestValidator()
+	if err != nil {
+		log.Fatal("Cannot load OpenAPI spec: ", err)
+	}
+	apiRtr.Use(s.tracingMiddleware)
+	useCustomMiddleware(apiRtr, middleware.PreAuth)
 	apiRtr.Use(s.verificationMiddleware)
+	useCustomMiddleware(apiRtr, middleware.PostAuth)
+	apiRtr.Use(s.requestLogMiddleware)
+	apiRtr.Use(s.datastoreMiddleware)
+	apiRtr.Use(s.metadataOnlyMiddleware)
+	apiRtr.Use(s.telemetryMiddleware)
+	apiRtr.Use(validator.middleware)
+	useCustomMiddleware(apiRtr, middleware.PreResponse)
 
 	// UI
 	spa := spaHandler{staticPath: "ui-agent", indexPath: "index.html"}
@@ -277,6 +550,57 @@ func (s *Server) HandleRequests() {
 		log.Fatal("Cannot Configure: ", err)
 	}
 
+	// the background jobs below fill the caches awaited by the readiness probe
+	s.startWarmUp()
+
+	if s.datastoreHealth != nil {
+		go s.runDatastoreHealth(context.Background())
+	}
+	if s.spireMirror != nil {
+		go s.runSPIREMirror(context.Background())
+	}
+	if s.policyAuthorizer != nil && s.policyAuthorizer.Watches() {
+		go s.policyAuthorizer.Watch(context.Background(), func() {
+			s.invalidateAuthorizationCache(context.Background())
+		})
+	}
+	if s.reconciler != nil {
+		go s.reconciler.Run(context.Background())
+	}
+	if s.replicator != nil {
+		go s.replicator.Run(context.Background())
+	}
+	if s.bundleMonitor != nil {
+		go s.bundleMonitor.Run(context.Background())
+	}
+	if s.bundleEndpoint != nil {
+		go s.runBundleEndpoint(context.Background())
+	}
+	if s.bootstrapBroker != nil {
+		go s.bootstrapBroker.Run(context.Background())
+	}
+	if s.lifecycleEnforcer != nil {
+		go s.lifecycleEnforcer.Run(context.Background())
+	}
+	if s.retryQueue != nil {
+		go s.retryQueue.Run(context.Background())
+	}
+	if s.dashboard != nil {
+		go s.runDashboard(context.Background())
+	}
+	if s.integrityCheck != nil {
+		go s.runIntegrityCheck(context.Background())
+	}
+	if s.clusterDeletions != nil {
+		go s.runClusterDeletions(context.Background())
+	}
+	if s.telemetry != nil {
+		go s.telemetry.Run(context.Background())
+	}
+	if s.diagnostics != nil && s.diagnostics.addr != "" {
+		go s.runDiagnosticsListener(context.Background())
+	}
+
 	// TODO: replace with workerGroup for thread safety
 	errChannel := make(chan error, 2)
 
@@ -16,20 +16,35 @@ import (
 	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// dialSPIRE opens a client connection to the SPIRE server API socket
+// all calls to SPIRE pass through the interceptors configured here, and fail
+// in metadata-only mode
+func (s *Server) dialSPIRE() (*grpc.ClientConn, error) {
+	if s.MetadataOnly() {
+		return nil, errSPIREDisabled
+	}
+	interceptors := []grpc.UnaryClientInterceptor{traceUnaryClientInterceptor, s.queryLogUnaryClientInterceptor, s.concurrencyLimitUnaryClientInterceptor}
+	interceptors = append(interceptors, s.chaosInterceptors()...)
+	return grpc.Dial(s.SpireServerAddr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(interceptors...),
+	)
+}
+
 type HealthcheckRequest grpc_health_v1.HealthCheckRequest
 type HealthcheckResponse grpc_health_v1.HealthCheckResponse
 
-func (s *Server) SPIREHealthcheck(inp HealthcheckRequest) (*HealthcheckResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) SPIREHealthcheck(ctx context.Context, inp HealthcheckRequest) (*HealthcheckResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := grpc_health_v1.HealthCheckRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := grpc_health_v1.NewHealthClient(conn)
 
-	resp, err := client.Check(context.Background(), &inpReq)
+	resp, err := client.Check(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -40,17 +55,17 @@ func (s *Server) SPIREHealthcheck(inp HealthcheckRequest) (*HealthcheckResponse,
 type DebugServerRequest debugServer.GetInfoRequest
 type DebugServerResponse debugServer.GetInfoResponse
 
-func (s *Server) DebugServer(inp DebugServerRequest) (*DebugServerResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) DebugServer(ctx context.Context, inp DebugServerRequest) (*DebugServerResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := debugServer.GetInfoRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := debugServer.NewDebugClient(conn)
 
-	resp, err := client.GetInfo(context.Background(), &inpReq)
+	resp, err := client.GetInfo(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -61,17 +76,17 @@ func (s *Server) DebugServer(inp DebugServerRequest) (*DebugServerResponse, erro
 type ListAgentsRequest agent.ListAgentsRequest
 type ListAgentsResponse agent.ListAgentsResponse
 
-func (s *Server) ListAgents(inp ListAgentsRequest) (*ListAgentsResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) ListAgents(ctx context.Context, inp ListAgentsRequest) (*ListAgentsResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := agent.ListAgentsRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := agent.NewAgentClient(conn)
 
-	resp, err := client.ListAgents(context.Background(), &inpReq)
+	resp, err := client.ListAgents(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -81,17 +96,17 @@ func (s *Server) ListAgents(inp ListAgentsRequest) (*ListAgentsResponse, error)
 
 type BanAgentRequest agent.BanAgentRequest
 
-func (s *Server) BanAgent(inp BanAgentRequest) error { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) BanAgent(ctx context.Context, inp BanAgentRequest) error { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := agent.BanAgentRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 	client := agent.NewAgentClient(conn)
 
-	_, err = client.BanAgent(context.Background(), &inpReq)
+	_, err = client.BanAgent(ctx, &inpReq)
 	if err != nil {
 		return err
 	}
@@ -101,17 +116,17 @@ func (s *Server) BanAgent(inp BanAgentRequest) error { //nolint:govet //Ignoring
 
 type DeleteAgentRequest agent.DeleteAgentRequest
 
-func (s *Server) DeleteAgent(inp DeleteAgentRequest) error { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) DeleteAgent(ctx context.Context, inp DeleteAgentRequest) error { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := agent.DeleteAgentRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 	client := agent.NewAgentClient(conn)
 
-	_, err = client.DeleteAgent(context.Background(), &inpReq)
+	_, err = client.DeleteAgent(ctx, &inpReq)
 	if err != nil {
 		return err
 	}
@@ -122,17 +137,17 @@ func (s *Server) DeleteAgent(inp DeleteAgentRequest) error { //nolint:govet //Ig
 type CreateJoinTokenRequest agent.CreateJoinTokenRequest
 type CreateJoinTokenResponse types.JoinToken
 
-func (s *Server) CreateJoinToken(inp CreateJoinTokenRequest) (*CreateJoinTokenResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) CreateJoinToken(ctx context.Context, inp CreateJoinTokenRequest) (*CreateJoinTokenResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := agent.CreateJoinTokenRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := agent.NewAgentClient(conn)
 
-	joinToken, err := client.CreateJoinToken(context.Background(), &inpReq)
+	joinToken, err := client.CreateJoinToken(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -145,17 +160,17 @@ func (s *Server) CreateJoinToken(inp CreateJoinTokenRequest) (*CreateJoinTokenRe
 type ListEntriesRequest entry.ListEntriesRequest
 type ListEntriesResponse entry.ListEntriesResponse
 
-func (s *Server) ListEntries(inp ListEntriesRequest) (*ListEntriesResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) ListEntries(ctx context.Context, inp ListEntriesRequest) (*ListEntriesResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := entry.ListEntriesRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := entry.NewEntryClient(conn)
 
-	resp, err := client.ListEntries(context.Background(), &inpReq)
+	resp, err := client.ListEntries(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -166,17 +181,17 @@ func (s *Server) ListEntries(inp ListEntriesRequest) (*ListEntriesResponse, erro
 type BatchCreateEntryRequest entry.BatchCreateEntryRequest
 type BatchCreateEntryResponse entry.BatchCreateEntryResponse
 
-func (s *Server) BatchCreateEntry(inp BatchCreateEntryRequest) (*BatchCreateEntryResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) BatchCreateEntry(ctx context.Context, inp BatchCreateEntryRequest) (*BatchCreateEntryResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := entry.BatchCreateEntryRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := entry.NewEntryClient(conn)
 
-	resp, err := client.BatchCreateEntry(context.Background(), &inpReq)
+	resp, err := client.BatchCreateEntry(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -184,20 +199,41 @@ func (s *Server) BatchCreateEntry(inp BatchCreateEntryRequest) (*BatchCreateEntr
 	return (*BatchCreateEntryResponse)(resp), nil
 }
 
+type BatchUpdateEntryRequest entry.BatchUpdateEntryRequest
+type BatchUpdateEntryResponse entry.BatchUpdateEntryResponse
+
+func (s *Server) BatchUpdateEntry(ctx context.Context, inp BatchUpdateEntryRequest) (*BatchUpdateEntryResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+	inpReq := entry.BatchUpdateEntryRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+	var conn *grpc.ClientConn
+	conn, err := s.dialSPIRE()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	client := entry.NewEntryClient(conn)
+
+	resp, err := client.BatchUpdateEntry(ctx, &inpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return (*BatchUpdateEntryResponse)(resp), nil
+}
+
 type BatchDeleteEntryRequest entry.BatchDeleteEntryRequest
 type BatchDeleteEntryResponse entry.BatchDeleteEntryResponse
 
-func (s *Server) BatchDeleteEntry(inp BatchDeleteEntryRequest) (*BatchDeleteEntryResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) BatchDeleteEntry(ctx context.Context, inp BatchDeleteEntryRequest) (*BatchDeleteEntryResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := entry.BatchDeleteEntryRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := entry.NewEntryClient(conn)
 
-	resp, err := client.BatchDeleteEntry(context.Background(), &inpReq)
+	resp, err := client.BatchDeleteEntry(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -205,6 +241,27 @@ func (s *Server) BatchDeleteEntry(inp BatchDeleteEntryRequest) (*BatchDeleteEntr
 	return (*BatchDeleteEntryResponse)(resp), nil
 }
 
+type GetEntryRequest entry.GetEntryRequest
+type GetEntryResponse types.Entry
+
+func (s *Server) GetEntry(ctx context.Context, inp GetEntryRequest) (*GetEntryResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+	inpReq := entry.GetEntryRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+	var conn *grpc.ClientConn
+	conn, err := s.dialSPIRE()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	client := entry.NewEntryClient(conn)
+
+	resp, err := client.GetEntry(ctx, &inpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return (*GetEntryResponse)(resp), nil
+}
+
 type GetTornjakServerInfoRequest struct{}
 type GetTornjakServerInfoResponse TornjakSpireServerInfo
 
@@ -219,17 +276,17 @@ func (s *Server) GetTornjakServerInfo(inp GetTornjakServerInfoRequest) (*GetTorn
 type GetBundleRequest bundle.GetBundleRequest
 type GetBundleResponse types.Bundle
 
-func (s *Server) GetBundle(inp GetBundleRequest) (*GetBundleResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) GetBundle(ctx context.Context, inp GetBundleRequest) (*GetBundleResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := bundle.GetBundleRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := bundle.NewBundleClient(conn)
 
-	bundle, err := client.GetBundle(context.Background(), &inpReq)
+	bundle, err := client.GetBundle(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -240,17 +297,17 @@ func (s *Server) GetBundle(inp GetBundleRequest) (*GetBundleResponse, error) { /
 type ListFederatedBundlesRequest bundle.ListFederatedBundlesRequest
 type ListFederatedBundlesResponse bundle.ListFederatedBundlesResponse
 
-func (s *Server) ListFederatedBundles(inp ListFederatedBundlesRequest) (*ListFederatedBundlesResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) ListFederatedBundles(ctx context.Context, inp ListFederatedBundlesRequest) (*ListFederatedBundlesResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := bundle.ListFederatedBundlesRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := bundle.NewBundleClient(conn)
 
-	bundle, err := client.ListFederatedBundles(context.Background(), &inpReq)
+	bundle, err := client.ListFederatedBundles(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -261,17 +318,17 @@ func (s *Server) ListFederatedBundles(inp ListFederatedBundlesRequest) (*ListFed
 type CreateFederatedBundleRequest bundle.BatchCreateFederatedBundleRequest
 type CreateFederatedBundleResponse bundle.BatchCreateFederatedBundleResponse
 
-func (s *Server) CreateFederatedBundle(inp CreateFederatedBundleRequest) (*CreateFederatedBundleResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) CreateFederatedBundle(ctx context.Context, inp CreateFederatedBundleRequest) (*CreateFederatedBundleResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := bundle.BatchCreateFederatedBundleRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := bundle.NewBundleClient(conn)
 
-	bundle, err := client.BatchCreateFederatedBundle(context.Background(), &inpReq)
+	bundle, err := client.BatchCreateFederatedBundle(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -282,17 +339,17 @@ func (s *Server) CreateFederatedBundle(inp CreateFederatedBundleRequest) (*Creat
 type UpdateFederatedBundleRequest bundle.BatchUpdateFederatedBundleRequest
 type UpdateFederatedBundleResponse bundle.BatchUpdateFederatedBundleResponse
 
-func (s *Server) UpdateFederatedBundle(inp UpdateFederatedBundleRequest) (*UpdateFederatedBundleResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) UpdateFederatedBundle(ctx context.Context, inp UpdateFederatedBundleRequest) (*UpdateFederatedBundleResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := bundle.BatchUpdateFederatedBundleRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := bundle.NewBundleClient(conn)
 
-	bundle, err := client.BatchUpdateFederatedBundle(context.Background(), &inpReq)
+	bundle, err := client.BatchUpdateFederatedBundle(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -303,17 +360,17 @@ func (s *Server) UpdateFederatedBundle(inp UpdateFederatedBundleRequest) (*Updat
 type DeleteFederatedBundleRequest bundle.BatchDeleteFederatedBundleRequest
 type DeleteFederatedBundleResponse bundle.BatchDeleteFederatedBundleResponse
 
-func (s *Server) DeleteFederatedBundle(inp DeleteFederatedBundleRequest) (*DeleteFederatedBundleResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) DeleteFederatedBundle(ctx context.Context, inp DeleteFederatedBundleRequest) (*DeleteFederatedBundleResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := bundle.BatchDeleteFederatedBundleRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := bundle.NewBundleClient(conn)
 
-	bundle, err := client.BatchDeleteFederatedBundle(context.Background(), &inpReq)
+	bundle, err := client.BatchDeleteFederatedBundle(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -325,17 +382,17 @@ func (s *Server) DeleteFederatedBundle(inp DeleteFederatedBundleRequest) (*Delet
 type ListFederationRelationshipsRequest trustdomain.ListFederationRelationshipsRequest
 type ListFederationRelationshipsResponse trustdomain.ListFederationRelationshipsResponse
 
-func (s *Server) ListFederationRelationships(inp ListFederationRelationshipsRequest) (*ListFederationRelationshipsResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) ListFederationRelationships(ctx context.Context, inp ListFederationRelationshipsRequest) (*ListFederationRelationshipsResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := trustdomain.ListFederationRelationshipsRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := trustdomain.NewTrustDomainClient(conn)
 
-	bundle, err := client.ListFederationRelationships(context.Background(), &inpReq)
+	bundle, err := client.ListFederationRelationships(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -346,17 +403,17 @@ func (s *Server) ListFederationRelationships(inp ListFederationRelationshipsRequ
 type CreateFederationRelationshipRequest trustdomain.BatchCreateFederationRelationshipRequest
 type CreateFederationRelationshipResponse trustdomain.BatchCreateFederationRelationshipResponse
 
-func (s *Server) CreateFederationRelationship(inp CreateFederationRelationshipRequest) (*CreateFederationRelationshipResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) CreateFederationRelationship(ctx context.Context, inp CreateFederationRelationshipRequest) (*CreateFederationRelationshipResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := trustdomain.BatchCreateFederationRelationshipRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := trustdomain.NewTrustDomainClient(conn)
 
-	bundle, err := client.BatchCreateFederationRelationship(context.Background(), &inpReq)
+	bundle, err := client.BatchCreateFederationRelationship(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -367,17 +424,17 @@ func (s *Server) CreateFederationRelationship(inp CreateFederationRelationshipRe
 type UpdateFederationRelationshipRequest trustdomain.BatchUpdateFederationRelationshipRequest
 type UpdateFederationRelationshipResponse trustdomain.BatchUpdateFederationRelationshipResponse
 
-func (s *Server) UpdateFederationRelationship(inp UpdateFederationRelationshipRequest) (*UpdateFederationRelationshipResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) UpdateFederationRelationship(ctx context.Context, inp UpdateFederationRelationshipRequest) (*UpdateFederationRelationshipResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := trustdomain.BatchUpdateFederationRelationshipRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := trustdomain.NewTrustDomainClient(conn)
 
-	bundle, err := client.BatchUpdateFederationRelationship(context.Background(), &inpReq)
+	bundle, err := client.BatchUpdateFederationRelationship(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -388,17 +445,17 @@ func (s *Server) UpdateFederationRelationship(inp UpdateFederationRelationshipRe
 type DeleteFederationRelationshipRequest trustdomain.BatchDeleteFederationRelationshipRequest
 type DeleteFederationRelationshipResponse trustdomain.BatchDeleteFederationRelationshipResponse
 
-func (s *Server) DeleteFederationRelationship(inp DeleteFederationRelationshipRequest) (*DeleteFederationRelationshipResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+func (s *Server) DeleteFederationRelationship(ctx context.Context, inp DeleteFederationRelationshipRequest) (*DeleteFederationRelationshipResponse, error) { //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	inpReq := trustdomain.BatchDeleteFederationRelationshipRequest(inp) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
 	var conn *grpc.ClientConn
-	conn, err := grpc.Dial(s.SpireServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := s.dialSPIRE()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 	client := trustdomain.NewTrustDomainClient(conn)
 
-	bundle, err := client.BatchDeleteFederationRelationship(context.Background(), &inpReq)
+	bundle, err := client.BatchDeleteFederationRelationship(ctx, &inpReq)
 	if err != nil {
 		return nil, err
 	}
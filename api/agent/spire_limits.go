@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// default time a SPIRE call waits for a free slot before it is rejected
+const defaultSPIREQueueTimeout = 5 * time.Second
+
+// spireCallLimiter bounds the number of concurrent calls to the SPIRE server
+type spireCallLimiter struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+}
+
+func newSPIRECallLimiter(maxConcurrent int, queueTimeout time.Duration) *spireCallLimiter {
+	return &spireCallLimiter{
+		slots:        make(chan struct{}, maxConcurrent),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// acquire waits for a free slot until the queue timeout elapses or ctx is done
+func (l *spireCallLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return status.Errorf(codes.ResourceExhausted, "too many concurrent SPIRE calls: no slot free after %v", l.queueTimeout)
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func (l *spireCallLimiter) release() {
+	<-l.slots
+}
+
+// concurrencyLimitUnaryClientInterceptor queues calls to the SPIRE server
+// once the configured number of calls is in flight
+func (s *Server) concurrencyLimitUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if s.spireLimiter == nil {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	if err := s.spireLimiter.acquire(ctx); err != nil {
+		return err
+	}
+	defer s.spireLimiter.release()
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	agent "github.com/spiffe/spire-api-sdk/proto/spire/api/server/agent/v1"
+	entry "github.com/spiffe/spire-api-sdk/proto/spire/api/server/entry/v1"
+	types "github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// default time between two syncs of the SPIRE mirror
+const defaultMirrorSyncInterval = 30 * time.Second
+
+// cache keys of the mirrored SPIRE objects
+const (
+	mirrorEntriesKey = "mirror/spire/entries"
+	mirrorAgentsKey  = "mirror/spire/agents"
+)
+
+// spireMirror periodically copies the entries and agents of the SPIRE server
+// into the cache, so they can be served while SPIRE is unavailable
+type spireMirror struct {
+	syncInterval time.Duration
+	staleAfter   time.Duration
+
+	mu          sync.Mutex
+	lastAttempt time.Time
+	lastError   string
+}
+
+func newSPIREMirror(syncInterval, staleAfter time.Duration) *spireMirror {
+	return &spireMirror{
+		syncInterval: syncInterval,
+		staleAfter:   staleAfter,
+	}
+}
+
+// spireMirrorSnapshot is the cached copy of one SPIRE list
+type spireMirrorSnapshot struct {
+	SyncedAt time.Time `json:"syncedAt"`
+	// protobuf encoding of the list response
+	Data []byte `json:"data"`
+}
+
+// MirrorStaleness describes how current a mirrored response is
+type MirrorStaleness struct {
+	// time the served data was read from SPIRE
+	SyncedAt   string `json:"syncedAt"`
+	AgeSeconds int64  `json:"ageSeconds"`
+	// set if the data is older than the configured staleness bound
+	Stale           bool   `json:"stale"`
+	LastSyncAttempt string `json:"lastSyncAttempt,omitempty"`
+	// error of the last sync, empty if it succeeded
+	LastSyncError string `json:"lastSyncError,omitempty"`
+}
+
+func (m *spireMirror) recordSync(err error, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastAttempt = now
+	m.lastError = ""
+	if err != nil {
+		m.lastError = err.Error()
+	}
+}
+
+func (m *spireMirror) staleness(syncedAt time.Time, now time.Time) MirrorStaleness {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	age := now.Sub(syncedAt)
+	staleness := MirrorStaleness{
+		SyncedAt:      syncedAt.UTC().Format(time.RFC3339),
+		AgeSeconds:    int64(age.Seconds()),
+		Stale:         age > m.staleAfter,
+		LastSyncError: m.lastError,
+	}
+	if !m.lastAttempt.IsZero() {
+		staleness.LastSyncAttempt = m.lastAttempt.UTC().Format(time.RFC3339)
+	}
+	return staleness
+}
+
+// runSPIREMirror syncs the mirror every sync interval until ctx is done
+func (s *Server) runSPIREMirror(ctx context.Context) {
+	ticker := s.clock().NewTicker(s.spireMirror.syncInterval)
+	defer ticker.Stop()
+	for {
+		syncCtx, cancel := context.WithTimeout(ctx, s.spireMirror.syncInterval)
+		err := s.syncSPIREMirror(syncCtx)
+		cancel()
+		if err != nil {
+			log.Printf("WARNING: could not sync SPIRE mirror: %v", err)
+		}
+		s.spireMirror.recordSync(err, s.clock().Now())
+		s.warmUp.primed(warmUpSPIREMirror, err, s.clock().Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// syncSPIREMirror lists all entries and agents of the SPIRE server
+// and stores them in the cache
+// a list that fails keeps its previous copy
+func (s *Server) syncSPIREMirror(ctx context.Context) error {
+	entries := &entry.ListEntriesResponse{}
+	entryReq := ListEntriesRequest{}
+	for {
+		resp, err := s.ListEntries(ctx, entryReq) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+		if err != nil {
+			return errors.Errorf("could not list entries: %v", err)
+		}
+		entries.Entries = append(entries.Entries, resp.Entries...)
+		if resp.NextPageToken == "" {
+			break
+		}
+		entryReq.PageToken = resp.NextPageToken
+	}
+	if err := s.storeMirrorSnapshot(ctx, mirrorEntriesKey, entries); err != nil {
+		return err
+	}
+
+	agents := &agent.ListAgentsResponse{}
+	agentReq := ListAgentsRequest{}
+	for {
+		resp, err := s.ListAgents(ctx, agentReq) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+		if err != nil {
+			return errors.Errorf("could not list agents: %v", err)
+		}
+		agents.Agents = append(agents.Agents, resp.Agents...)
+		if resp.NextPageToken == "" {
+			break
+		}
+		agentReq.PageToken = resp.NextPageToken
+	}
+	return s.storeMirrorSnapshot(ctx, mirrorAgentsKey, agents)
+}
+
+func (s *Server) storeMirrorSnapshot(ctx context.Context, key string, m proto.Message) error {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return errors.Errorf("could not encode %s: %v", key, err)
+	}
+	value, err := json.Marshal(spireMirrorSnapshot{SyncedAt: s.clock().Now(), Data: data})
+	if err != nil {
+		return errors.Errorf("could not encode %s: %v", key, err)
+	}
+	// mirrored objects do not expire, so they are served for as long as SPIRE is down
+	if err := s.Cache.Set(ctx, key, value, 0); err != nil {
+		return errors.Errorf("could not store %s: %v", key, err)
+	}
+	return nil
+}
+
+func (s *Server) loadMirrorSnapshot(ctx context.Context, key string, m proto.Message) (MirrorStaleness, error) {
+	if s.spireMirror == nil {
+		return MirrorStaleness{}, errors.New("SPIRE mirror is not configured")
+	}
+	value, ok, err := s.Cache.Get(ctx, key)
+	if err != nil {
+		return MirrorStaleness{}, errors.Errorf("could not read SPIRE mirror: %v", err)
+	}
+	if !ok {
+		return MirrorStaleness{}, errors.New("SPIRE mirror has not synced yet")
+	}
+	var snapshot spireMirrorSnapshot
+	if err := json.Unmarshal(value, &snapshot); err != nil {
+		return MirrorStaleness{}, errors.Errorf("could not decode SPIRE mirror: %v", err)
+	}
+	if err := proto.Unmarshal(snapshot.Data, m); err != nil {
+		return MirrorStaleness{}, errors.Errorf("could not decode SPIRE mirror: %v", err)
+	}
+	return s.spireMirror.staleness(snapshot.SyncedAt, s.clock().Now()), nil
+}
+
+type MirrorListEntriesRequest struct{}
+
+type MirrorListEntriesResponse struct {
+	Entries   []*types.Entry  `json:"entries"`
+	Staleness MirrorStaleness `json:"staleness"`
+}
+
+// MirrorListEntries returns the entries of the SPIRE server as of the last sync
+func (s *Server) MirrorListEntries(ctx context.Context, inp MirrorListEntriesRequest) (*MirrorListEntriesResponse, error) {
+	var entries entry.ListEntriesResponse
+	staleness, err := s.loadMirrorSnapshot(ctx, mirrorEntriesKey, &entries)
+	if err != nil {
+		return nil, err
+	}
+	return &MirrorListEntriesResponse{Entries: entries.Entries, Staleness: staleness}, nil
+}
+
+type MirrorListAgentsRequest struct{}
+
+type MirrorListAgentsResponse struct {
+	Agents    []*types.Agent  `json:"agents"`
+	Staleness MirrorStaleness `json:"staleness"`
+}
+
+// MirrorListAgents returns the agents of the SPIRE server as of the last sync
+func (s *Server) MirrorListAgents(ctx context.Context, inp MirrorListAgentsRequest) (*MirrorListAgentsResponse, error) {
+	var agents agent.ListAgentsResponse
+	staleness, err := s.loadMirrorSnapshot(ctx, mirrorAgentsKey, &agents)
+	if err != nil {
+		return nil, err
+	}
+	return &MirrorListAgentsResponse{Agents: agents.Agents, Staleness: staleness}, nil
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// name of the SPIRE query log in the responses of disabled features
+const featureSPIREQueryLog = "spire_query_log"
+
+// errSPIREQueryLogDisabled is returned by the query log when the DataStore does not store it
+var errSPIREQueryLogDisabled = errors.New("SPIRE query log disabled: the DataStore plugin does not store " + agentdb.CapabilitySPIREQueryLog)
+
+// queryLogUnaryClientInterceptor records every call made to the SPIRE server
+// in the datastore query log. Failure to record a call does not fail the call.
+// Calls are not recorded if the DataStore does not store the query log.
+func (s *Server) queryLogUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	// calls are not recorded while the DataStore is unavailable, so they do not wait for its retries
+	if s.Db == nil || !agentdb.Supports(s.Db, agentdb.CapabilitySPIREQueryLog) || !s.datastoreHealth.current().Available {
+		return err
+	}
+
+	call := tornjakTypes.SPIRECallInfo{
+		Method:     method,
+		DurationMs: time.Since(start).Milliseconds(),
+		Status:     status.Code(err).String(),
+		RequestID:  requestIDFromContext(ctx),
+		Timestamp:  start.UTC().Format(time.RFC3339),
+	}
+	if userInfo := userFromContext(ctx); userInfo != nil {
+		call.User = userInfo.Username
+	}
+	if dbErr := s.Db.AddSPIRECallRecord(call); dbErr != nil {
+		log.Printf("WARNING: could not record SPIRE call %s: %v", method, dbErr)
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/telemetry"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// defaults of the telemetry configuration
+const (
+	defaultTelemetryInterval = 24 * time.Hour
+	defaultTelemetryTimeout  = 10 * time.Second
+)
+
+// newTelemetry returns the usage reporter for the telemetry configuration
+// without a telemetry block that enables it, usage is counted for the preview but never sent
+// plugins are the names of the configured plugins by plugin type
+func (s *Server) newTelemetry(config *TelemetryConfig, plugins map[string]string) (*telemetry.Reporter, error) {
+	if config == nil {
+		config = &TelemetryConfig{}
+	}
+	interval, err := parseConfigDuration("interval", config.Interval, defaultTelemetryInterval)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parseConfigDuration("timeout", config.Timeout, defaultTelemetryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if config.Enabled {
+		endpoint, err := url.Parse(config.Endpoint)
+		if err != nil || (endpoint.Scheme != "https" && endpoint.Scheme != "http") || endpoint.Host == "" {
+			return nil, errors.Errorf("'endpoint' must be an http or https URL, got %q", config.Endpoint)
+		}
+	}
+
+	version := os.Getenv("VERSION")
+	if version == "" {
+		version = "unknown"
+	}
+	backend := "none"
+	if name, ok := plugins["DataStore"]; ok {
+		backend = name
+	}
+
+	return telemetry.New(telemetry.Config{
+		Enabled:  config.Enabled,
+		Endpoint: config.Endpoint,
+		Interval: interval,
+		Timeout:  timeout,
+		Version:  version,
+		Backend:  backend,
+		Features: s.telemetryFeatures(plugins),
+		Fleet:    s.telemetryFleet,
+		Clock:    s.Clock,
+	}), nil
+}
+
+// telemetryFeatures returns the optional features enabled in the config, by config block,
+// and the configured plugins other than the DataStore as <type>/<name>
+func (s *Server) telemetryFeatures(plugins map[string]string) []string {
+	serverConfig := s.TornjakConfig.Server
+	blocks := map[string]bool{
+		"spire_calls":          serverConfig.SPIRECallsConfig != nil,
+		"request_log":          serverConfig.RequestLogConfig != nil,
+		"cluster_extensions":   len(serverConfig.ClusterExtensions) > 0,
+		"spire_mirror":         serverConfig.SPIREMirrorConfig != nil,
+		"desired_state":        serverConfig.DesiredStateConfig != nil,
+		"change_proposals":     serverConfig.ChangeProposalsConfig != nil,
+		"bundle_monitor":       serverConfig.BundleMonitorConfig != nil,
+		"authorization_cache":  serverConfig.AuthorizationCacheConfig != nil,
+		"entry_ttl_policy":     serverConfig.EntryTTLPolicyConfig != nil,
+		"webhook_verification": serverConfig.WebhookVerificationConfig != nil,
+		"bootstrap_broker":     serverConfig.BootstrapBrokerConfig != nil,
+	}
+	features := []string{}
+	for name, enabled := range blocks {
+		if enabled {
+			features = append(features, name)
+		}
+	}
+	for pluginType, name := range plugins {
+		if pluginType != "DataStore" {
+			features = append(features, strings.ToLower(pluginType+"/"+name))
+		}
+	}
+	return features
+}
+
+// telemetryFleet counts the clusters of the DataStore and the agents assigned to them
+func (s *Server) telemetryFleet(ctx context.Context) (int, int, error) {
+	if s.Db == nil {
+		return 0, 0, nil
+	}
+	clusters, err := s.Db.GetClusters()
+	if err != nil {
+		return 0, 0, err
+	}
+	agents := 0
+	for _, cluster := range clusters.Clusters {
+		agents += len(cluster.AgentsList)
+	}
+	return len(clusters.Clusters), agents, nil
+}
+
+// telemetryMiddleware counts the calls of API routes by method and route template,
+// so no IDs or names in paths or bodies are recorded
+func (s *Server) telemetryMiddleware(next http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		if s.telemetry != nil && r.Method != http.MethodOptions {
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil && strings.HasPrefix(template, "/api/") {
+					s.telemetry.Count(r.Method + " " + template)
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(f)
+}
+
+type PreviewTelemetryRequest struct{}
+type PreviewTelemetryResponse tornjakTypes.TelemetryPreview
+
+// PreviewTelemetry returns exactly the report telemetry would send next, whether or not it is enabled
+func (s *Server) PreviewTelemetry(ctx context.Context, inp PreviewTelemetryRequest) (*PreviewTelemetryResponse, error) {
+	if s.telemetry == nil {
+		return nil, errors.New("telemetry is not configured")
+	}
+	retVal, err := s.telemetry.Preview(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return (*PreviewTelemetryResponse)(&retVal), nil
+}
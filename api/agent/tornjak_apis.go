@@ -1,8 +1,22 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
 
+	types "github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
 	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
 )
 
@@ -23,13 +37,25 @@ GetEntry(GetEntryRequest) returns (spire.types.Entry);
 
 */
 
-type ListSelectorsRequest struct{}
+type ListSelectorsRequest struct {
+	// number of agents of a page, and cursor of the page returned by the
+	// previous call; all agents are returned if neither is set
+	Limit  int    `json:"limit,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+}
 type ListSelectorsResponse tornjakTypes.AgentInfoList
 
 // ListSelectors returns list of agents from the local DB with the following info
 // spiffeid string
 // plugin   string
 func (s *Server) ListSelectors(inp ListSelectorsRequest) (*ListSelectorsResponse, error) {
+	if inp.Limit != 0 || inp.Cursor != "" {
+		page, err := s.Db.GetAgentSelectorsPage(tornjakTypes.ListOptions{Limit: inp.Limit, Cursor: inp.Cursor})
+		if err != nil {
+			return nil, err
+		}
+		return &ListSelectorsResponse{Agents: page.Items, NextCursor: page.NextCursor, Total: page.Total}, nil
+	}
 	resp, err := s.Db.GetAgentSelectors()
 	if err != nil {
 		return nil, err
@@ -41,25 +67,50 @@ type RegisterSelectorRequest tornjakTypes.AgentInfo
 
 // DefineSelectors registers an agent to the local DB with the following info
 // spiffeid string
-// plugin   string
-func (s *Server) DefineSelectors(inp RegisterSelectorRequest) error {
+// plugin   string, a known plugin type in any spelling or a custom plugin type
+func (s *Server) DefineSelectors(ctx context.Context, inp RegisterSelectorRequest) error {
 	sinfo := tornjakTypes.AgentInfo(inp)
 	if len(sinfo.Spiffeid) == 0 {
 		return errors.New("agent's info missing mandatory field - Spiffeid")
 	}
-	return s.Db.CreateAgentEntry(sinfo)
+	if len(sinfo.Plugin) > 0 {
+		pluginType, err := tornjakTypes.NormalizePluginType(sinfo.Plugin)
+		if err != nil {
+			return fmt.Errorf("invalid plugin: %v", err)
+		}
+		sinfo.Plugin = pluginType.Name
+	}
+	return s.dbAs(ctx).CreateAgentEntry(sinfo)
+}
+
+type ListPluginTypesRequest struct{}
+type ListPluginTypesResponse tornjakTypes.PluginTypeList
+
+// ListPluginTypes returns the known plugin types and the custom plugin types assigned to agents
+func (s *Server) ListPluginTypes(inp ListPluginTypesRequest) (*ListPluginTypesResponse, error) {
+	retVal, err := s.Db.GetPluginTypes()
+	if err != nil {
+		return nil, err
+	}
+	return (*ListPluginTypesResponse)(&retVal), nil
 }
 
 type ListAgentMetadataRequest tornjakTypes.AgentMetadataRequest
 type ListAgentMetadataResponse tornjakTypes.AgentInfoList
 
-// ListAgentMetadata takes in list of agent spiffeids
+// ListAgentMetadata takes in list of agent spiffeids and an optional search string
 // and returns list of those agents from the local DB with following info
 // spiffeid string
 // plugin string
 // cluster string
+// displayName string
+// compliance map, current compliance attributes
 // if no metadata found, no row is included
 // if no spiffeids are specified, all agent metadata is returned
+// if search is given, only agents whose spiffeid or display name contain it are returned
+// if plugin is given, only agents with that plugin type are returned
+// if compliance filters are given, only agents whose current attributes match all of them are returned
+// if limit or cursor is given, a page of the matching agents is returned with the cursor of the next page
 func (s *Server) ListAgentMetadata(inp ListAgentMetadataRequest) (*ListAgentMetadataResponse, error) {
 	inpReq := tornjakTypes.AgentMetadataRequest(inp)
 	resp, err := s.Db.GetAgentsMetadata(inpReq)
@@ -69,57 +120,896 @@ func (s *Server) ListAgentMetadata(inp ListAgentMetadataRequest) (*ListAgentMeta
 	return (*ListAgentMetadataResponse)(&resp), nil
 }
 
-type ListClustersRequest struct{}
-type ListClustersResponse tornjakTypes.ClusterInfoList
+type FindAgentsRequest struct {
+	// SPIFFE ID pattern, where * matches any characters and ? any one character
+	Pattern string                   `json:"pattern"`
+	Limit   int                      `json:"limit"`
+	Cursor  string                   `json:"cursor"`
+	Sort    []tornjakTypes.SortField `json:"sort,omitempty"`
+}
+type FindAgentsResponse tornjakTypes.List[string]
+
+// FindAgents returns a page of the SPIFFE IDs of the agents matching the
+// pattern, such as spiffe://example.org/agent/node-* for a prefix
+func (s *Server) FindAgents(inp FindAgentsRequest) (*FindAgentsResponse, error) {
+	page, err := s.Db.FindAgentsByPattern(inp.Pattern, tornjakTypes.ListOptions{Limit: inp.Limit, Cursor: inp.Cursor, Sort: inp.Sort})
+	if err != nil {
+		return nil, err
+	}
+	return (*FindAgentsResponse)(&page), nil
+}
+
+type ReportAgentComplianceRequest tornjakTypes.AgentComplianceReport
+
+// ReportAgentCompliance stores compliance attributes of a node reported by an external agent or scanner
+// the report time is set by Tornjak; the source defaults to the reporting user
+func (s *Server) ReportAgentCompliance(ctx context.Context, inp ReportAgentComplianceRequest) error {
+	report := tornjakTypes.AgentComplianceReport(inp)
+	if err := report.Validate(); err != nil {
+		return err
+	}
+	if u := userFromContext(ctx); u != nil && len(report.Source) == 0 {
+		report.Source = u.Username
+	}
+	report.ReportedAt = s.clock().Now().UTC().Format(time.RFC3339)
+	return s.Db.AddAgentComplianceReport(report)
+}
+
+type GetAgentComplianceHistoryRequest struct {
+	Spiffeid  string `json:"spiffeid"`
+	Attribute string `json:"attribute,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+}
+type GetAgentComplianceHistoryResponse tornjakTypes.AgentComplianceHistory
+
+// GetAgentComplianceHistory returns the reported compliance attribute values of an agent, newest first
+// limit defaults to tornjakTypes.DefaultPageSize and is at most tornjakTypes.MaxPageSize
+func (s *Server) GetAgentComplianceHistory(inp GetAgentComplianceHistoryRequest) (*GetAgentComplianceHistoryResponse, error) {
+	if len(inp.Spiffeid) == 0 {
+		return nil, errors.New("input missing mandatory field - Spiffeid")
+	}
+	limit := inp.Limit
+	if limit == 0 {
+		limit = tornjakTypes.DefaultPageSize
+	} else if limit < 0 || limit > tornjakTypes.MaxPageSize {
+		return nil, fmt.Errorf("limit must be between 1 and %d", tornjakTypes.MaxPageSize)
+	}
+	retVal, err := s.Db.GetAgentComplianceHistory(inp.Spiffeid, inp.Attribute, limit)
+	if err != nil {
+		return nil, err
+	}
+	return (*GetAgentComplianceHistoryResponse)(&retVal), nil
+}
+
+type SetAgentDisplayNameRequest tornjakTypes.AgentDisplayName
+
+// SetAgentDisplayName assigns a human-friendly display name to an agent in the local DB
+// spiffeid    string
+// displayName string, removes the display name if empty
+func (s *Server) SetAgentDisplayName(ctx context.Context, inp SetAgentDisplayNameRequest) error {
+	if len(inp.Spiffeid) == 0 {
+		return errors.New("input missing mandatory field - Spiffeid")
+	}
+	displayName := strings.TrimSpace(inp.DisplayName)
+	if len(displayName) > tornjakTypes.MaxAgentDisplayNameLength {
+		return fmt.Errorf("display name longer than %d characters", tornjakTypes.MaxAgentDisplayNameLength)
+	}
+	return s.dbAs(ctx).SetAgentDisplayName(inp.Spiffeid, displayName)
+}
+
+type ListClustersRequest struct {
+	// RFC 3339 time to list the clusters as of, the current clusters if empty
+	AsOf string `json:"asOf"`
+	// number of clusters of a page, and cursor of the page returned by the
+	// previous call; all clusters are returned at once if neither is set
+	Limit   int                   `json:"limit"`
+	Cursor  string                `json:"cursor"`
+	Filters []tornjakTypes.Filter `json:"filters,omitempty"`
+	// sort of the page, on name, createdAt, platformType or agentCount
+	Sort []tornjakTypes.SortField `json:"sort,omitempty"`
+	// fields the clusters must have, shorthands of filters
+	PlatformType string `json:"platformType,omitempty"`
+	ManagedBy    string `json:"managedBy,omitempty"`
+	DomainName   string `json:"domainName,omitempty"`
+	// labels the clusters must have, e.g. env=prod,region in (us-east, us-west)
+	LabelSelector string `json:"labelSelector,omitempty"`
+}
+
+// filters returns the filters of the request with those of its field shorthands
+func (inp ListClustersRequest) filters() []tornjakTypes.Filter {
+	filters := append([]tornjakTypes.Filter{}, inp.Filters...)
+	for _, f := range []tornjakTypes.Filter{
+		{Field: "platformType", Value: inp.PlatformType},
+		{Field: "managedBy", Value: inp.ManagedBy},
+		{Field: "domainName", Value: inp.DomainName},
+	} {
+		if f.Value != "" {
+			filters = append(filters, f)
+		}
+	}
+	return filters
+}
+
+type ListClustersResponse struct {
+	Clusters []tornjakTypes.ClusterInfo `json:"clusters"`
+	// cursor of the next page, empty on the last page or when not paginated
+	NextCursor string `json:"next_cursor,omitempty"`
+	// number of clusters across all pages, set when paginated
+	Total int `json:"total,omitempty"`
+}
 
 // ListClusters returns list of clusters from the local DB with the following info
 // name string
-// details json
-func (s *Server) ListClusters(inp ListClustersRequest) (*ListClustersResponse, error) {
-	retVal, err := s.Db.GetClusters()
+// details json, including owner email, team and slack channel
+// with inp.AsOf set, the clusters are reconstructed as they were at that time
+// with inp.Limit or inp.Cursor set, a page of the current clusters is returned
+// with inp.LabelSelector set, only the current clusters with matching labels
+func (s *Server) ListClusters(ctx context.Context, inp ListClustersRequest) (*ListClustersResponse, error) {
+	if inp.Limit != 0 || inp.Cursor != "" || len(inp.filters()) > 0 || len(inp.Sort) > 0 || inp.LabelSelector != "" {
+		if inp.AsOf != "" {
+			return nil, errors.New("asOf cannot be combined with limit, cursor, filters, labelSelector or sort")
+		}
+		return s.listClustersPage(ctx, inp)
+	}
+	var retVal tornjakTypes.ClusterInfoList
+	var err error
+	if inp.AsOf != "" {
+		asOf, perr := time.Parse(time.RFC3339, inp.AsOf)
+		if perr != nil {
+			return nil, fmt.Errorf("invalid asOf %q, expected an RFC 3339 time", inp.AsOf)
+		}
+		retVal, err = s.Db.GetClustersAsOf(asOf.UTC().Format(time.RFC3339))
+	} else {
+		retVal, err = s.Db.GetClusters()
+	}
 	if err != nil {
 		return nil, err
 	}
-	return (*ListClustersResponse)(&retVal), nil
+	return &ListClustersResponse{Clusters: scopedClusters(ctx, retVal.Clusters)}, nil
+}
+
+// listClustersPage returns a page of the current clusters
+// users restricted to a cluster only page through that cluster
+func (s *Server) listClustersPage(ctx context.Context, inp ListClustersRequest) (*ListClustersResponse, error) {
+	opts := tornjakTypes.ListOptions{Limit: inp.Limit, Cursor: inp.Cursor, Filters: inp.filters(), Sort: inp.Sort}
+	if inp.LabelSelector != "" {
+		selector, err := tornjakTypes.ParseLabelSelector(inp.LabelSelector)
+		if err != nil {
+			return nil, err
+		}
+		opts.LabelSelector = selector
+	}
+	if u := userFromContext(ctx); u != nil && u.ClusterScope != nil {
+		opts.Filters = append(opts.Filters, tornjakTypes.Filter{Field: "uid", Value: u.ClusterScope.ClusterUID})
+	}
+	page, err := s.Db.GetClustersPage(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ListClustersResponse{
+		Clusters:   page.Items,
+		NextCursor: page.NextCursor,
+		Total:      page.Total,
+	}, nil
+}
+
+type SearchClustersRequest struct {
+	// words to find in the name, domain name, manager or platform type of clusters
+	Query string `json:"query"`
+}
+type SearchClustersResponse tornjakTypes.ClusterInfoList
+
+// SearchClusters returns the clusters matching the query, best match first
+func (s *Server) SearchClusters(ctx context.Context, inp SearchClustersRequest) (*SearchClustersResponse, error) {
+	retVal, err := s.Db.SearchClusters(inp.Query)
+	if err != nil {
+		return nil, err
+	}
+	return &SearchClustersResponse{Clusters: scopedClusters(ctx, retVal.Clusters)}, nil
+}
+
+type ListClusterAgentsRequest struct {
+	// cluster, by UID or by name
+	UID    string                   `json:"uid,omitempty"`
+	Name   string                   `json:"name,omitempty"`
+	Limit  int                      `json:"limit"`
+	Cursor string                   `json:"cursor"`
+	Sort   []tornjakTypes.SortField `json:"sort,omitempty"`
+	// whether to add the SPIRE details of the agents of the page
+	Hydrate bool `json:"hydrate,omitempty"`
+}
+type ListClusterAgentsResponse struct {
+	tornjakTypes.List[string]
+	// SPIRE details of the agents of the page by SPIFFE ID, when hydrated
+	Details map[string]tornjakTypes.SpireAgentDetails `json:"details,omitempty"`
+	// errors reading the SPIRE details of agents by SPIFFE ID
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// ListClusterAgents returns a page of the SPIFFE IDs of the agents of a cluster,
+// sorted by SPIFFE ID, with their SPIRE details if inp.Hydrate is set
+// users restricted to a cluster only list the agents of that cluster
+func (s *Server) ListClusterAgents(ctx context.Context, inp ListClusterAgentsRequest) (*ListClusterAgentsResponse, error) {
+	name := inp.Name
+	switch {
+	case inp.UID != "" && inp.Name != "":
+		return nil, errors.New("only one of uid and name may be set")
+	case inp.UID != "":
+		var err error
+		if name, err = s.Db.GetClusterNameByUID(inp.UID); err != nil {
+			return nil, err
+		}
+	case inp.Name == "":
+		return nil, errors.New("input missing mandatory field - UID or Name")
+	}
+	if err := s.checkClusterScope(ctx, name); err != nil {
+		return nil, err
+	}
+	page, err := s.Db.GetClusterAgentsPage(name, tornjakTypes.ListOptions{Limit: inp.Limit, Cursor: inp.Cursor, Sort: inp.Sort})
+	if err != nil {
+		return nil, err
+	}
+	ret := &ListClusterAgentsResponse{List: page}
+	if inp.Hydrate {
+		if ret.Details, ret.Errors, err = s.hydrateAgents(ctx, page.Items); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
 }
 
 type RegisterClusterRequest tornjakTypes.ClusterInput
 
 // DefineCluster registers cluster to local DB
-func (s *Server) DefineCluster(inp RegisterClusterRequest) error {
+func (s *Server) DefineCluster(ctx context.Context, inp RegisterClusterRequest) error {
+	cinfo, err := s.checkDefineCluster(inp)
+	if err != nil {
+		return err
+	}
+	return s.dbAs(ctx).CreateClusterEntry(cinfo)
+}
+
+// checkDefineCluster returns the cluster to create if the request is valid
+func (s *Server) checkDefineCluster(inp RegisterClusterRequest) (tornjakTypes.ClusterInfo, error) {
 	cinfo := tornjakTypes.ClusterInfo(inp.ClusterInstance)
 	if len(cinfo.Name) == 0 {
-		return errors.New("cluster definition missing mandatory field - Name")
+		return cinfo, errors.New("cluster definition missing mandatory field - Name")
 	} else if len(cinfo.PlatformType) == 0 {
-		return errors.New("cluster definition missing mandatory field - PlatformType")
+		return cinfo, errors.New("cluster definition missing mandatory field - PlatformType")
 	} else if len(cinfo.EditedName) > 0 {
-		return errors.New("cluster definition attempts renaming on create cluster - EditedName")
+		return cinfo, errors.New("cluster definition attempts renaming on create cluster - EditedName")
 	}
-	return s.Db.CreateClusterEntry(cinfo)
+	return cinfo, s.validateCluster(cinfo)
 }
 
 type EditClusterRequest tornjakTypes.ClusterInput
+type EditClusterResponse tornjakTypes.ClusterEditResult
 
-// EditCluster registers cluster to local DB
-func (s *Server) EditCluster(inp EditClusterRequest) error {
+// EditCluster updates cluster in local DB and returns the changed fields
+func (s *Server) EditCluster(ctx context.Context, inp EditClusterRequest) (*EditClusterResponse, error) {
+	cinfo, err := s.checkEditCluster(inp)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.checkClusterScope(ctx, cinfo.Name); err != nil {
+		return nil, err
+	}
+	retVal, err := s.dbAs(ctx).EditClusterEntry(cinfo)
+	if err != nil {
+		return nil, err
+	}
+	if len(retVal.Changes) > 0 {
+		user := ""
+		if u := userFromContext(ctx); u != nil {
+			user = u.Username
+		}
+		changes, err := json.Marshal(retVal.Changes)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("cluster %s edited by %q: %s", cinfo.Name, user, changes)
+	}
+	return (*EditClusterResponse)(&retVal), nil
+}
+
+// checkEditCluster returns the edited cluster if the request is valid
+func (s *Server) checkEditCluster(inp EditClusterRequest) (tornjakTypes.ClusterInfo, error) {
 	cinfo := tornjakTypes.ClusterInfo(inp.ClusterInstance)
 	if len(cinfo.Name) == 0 {
-		return errors.New("cluster definition missing mandatory field - Name")
+		return cinfo, errors.New("cluster definition missing mandatory field - Name")
 	} else if len(cinfo.PlatformType) == 0 {
-		return errors.New("cluster definition missing mandatory field - PlatformType")
+		return cinfo, errors.New("cluster definition missing mandatory field - PlatformType")
 	} else if len(cinfo.EditedName) == 0 {
-		return errors.New("cluster definition missing mandatory field - EditedName")
+		return cinfo, errors.New("cluster definition missing mandatory field - EditedName")
 	}
-	return s.Db.EditClusterEntry(cinfo)
+	return cinfo, s.validateCluster(cinfo)
 }
 
 type DeleteClusterRequest tornjakTypes.ClusterInput
+type DeleteClusterResponse struct {
+	// removal of the memberships of the cluster in the background, nil if the
+	// cluster was deleted at once
+	Deletion *tornjakTypes.ClusterDeletion `json:"deletion,omitempty"`
+}
 
 // DeleteCluster deletes cluster with name cinfo.Name and assignment to agents
-func (s *Server) DeleteCluster(inp DeleteClusterRequest) error {
+// the memberships of clusters with many agents are removed in the background,
+// the cluster being hidden at once
+func (s *Server) DeleteCluster(ctx context.Context, inp DeleteClusterRequest) (*DeleteClusterResponse, error) {
 	cinfo := tornjakTypes.ClusterInfo(inp.ClusterInstance)
 	if len(cinfo.Name) == 0 {
+		return nil, errors.New("input missing mandatory field - Name")
+	}
+	db := s.dbAs(ctx)
+	deleter, ok := db.(agentdb.BackgroundClusterDeleter)
+	if s.clusterDeletions == nil || !ok {
+		return &DeleteClusterResponse{}, db.DeleteClusterEntry(cinfo.Name)
+	}
+	deletion, err := deleter.DeleteClusterEntryInBackground(cinfo.Name, s.clusterDeletions.threshold)
+	if err != nil {
+		return nil, err
+	}
+	if deletion != nil {
+		s.clusterDeletions.notify()
+	}
+	return &DeleteClusterResponse{Deletion: deletion}, nil
+}
+
+type SetClusterProtectionRequest struct {
+	// cluster, by UID or by name
+	UID  string `json:"uid,omitempty"`
+	Name string `json:"name,omitempty"`
+	// whether deletes of the cluster are rejected, mandatory so protection is
+	// only cleared explicitly
+	Protected *bool `json:"protected"`
+}
+
+// SetClusterProtection sets or clears the protection of a cluster against deletes
+func (s *Server) SetClusterProtection(ctx context.Context, inp SetClusterProtectionRequest) error {
+	if inp.Protected == nil {
+		return errors.New("input missing mandatory field - Protected")
+	}
+	name := inp.Name
+	switch {
+	case inp.UID != "" && inp.Name != "":
+		return errors.New("only one of uid and name may be set")
+	case inp.UID != "":
+		var err error
+		if name, err = s.Db.GetClusterNameByUID(inp.UID); err != nil {
+			return err
+		}
+	case inp.Name == "":
+		return errors.New("input missing mandatory field - UID or Name")
+	}
+	if err := s.dbAs(ctx).SetClusterProtection(name, *inp.Protected); err != nil {
+		return err
+	}
+	user := ""
+	if u := userFromContext(ctx); u != nil {
+		user = u.Username
+	}
+	log.Printf("protection of cluster %s set to %t by %q", name, *inp.Protected, user)
+	return nil
+}
+
+type RenameClusterRequest struct {
+	// cluster, by UID or by name
+	UID     string `json:"uid,omitempty"`
+	Name    string `json:"name,omitempty"`
+	NewName string `json:"newName"`
+}
+type RenameClusterResponse tornjakTypes.ClusterEditResult
+
+// RenameCluster changes the name of a cluster only, so its UID, agents, labels,
+// extension fields and protection are kept
+func (s *Server) RenameCluster(ctx context.Context, inp RenameClusterRequest) (*RenameClusterResponse, error) {
+	if s.proposer != nil {
+		return nil, errors.New("clusters are changed by change proposals; propose an edit of the cluster to rename it")
+	}
+	if len(inp.NewName) == 0 {
+		return nil, errors.New("input missing mandatory field - NewName")
+	}
+	name := inp.Name
+	switch {
+	case inp.UID != "" && inp.Name != "":
+		return nil, errors.New("only one of uid and name may be set")
+	case inp.UID != "":
+		var err error
+		if name, err = s.Db.GetClusterNameByUID(inp.UID); err != nil {
+			return nil, err
+		}
+	case inp.Name == "":
+		return nil, errors.New("input missing mandatory field - UID or Name")
+	}
+	if err := s.objectPolicies.ValidateClusterName(inp.NewName); err != nil {
+		return nil, err
+	}
+	if err := s.checkClusterScope(ctx, name); err != nil {
+		return nil, err
+	}
+	retVal, err := s.dbAs(ctx).RenameClusterEntry(name, inp.NewName)
+	if err != nil {
+		return nil, err
+	}
+	user := ""
+	if u := userFromContext(ctx); u != nil {
+		user = u.Username
+	}
+	log.Printf("cluster %s renamed to %s by %q", name, inp.NewName, user)
+	return (*RenameClusterResponse)(&retVal), nil
+}
+
+type RestoreClusterRequest struct {
+	// UID of the deleted cluster, see ListDeletedClusters
+	UID string `json:"uid"`
+}
+type RestoreClusterResponse tornjakTypes.ClusterRestoreResult
+
+// RestoreCluster restores a deleted cluster with its UID, agents, labels and
+// metadata, under the name it had; agents assigned to another cluster since
+// are left there and reported
+func (s *Server) RestoreCluster(ctx context.Context, inp RestoreClusterRequest) (*RestoreClusterResponse, error) {
+	if len(inp.UID) == 0 {
+		return nil, errors.New("input missing mandatory field - UID")
+	}
+	retVal, err := s.dbAs(ctx).RestoreClusterEntry(inp.UID)
+	if err != nil {
+		return nil, err
+	}
+	user := ""
+	if u := userFromContext(ctx); u != nil {
+		user = u.Username
+	}
+	log.Printf("cluster %s restored by %q, skipped agents %v", retVal.Name, user, retVal.SkippedAgents)
+	return (*RestoreClusterResponse)(&retVal), nil
+}
+
+type ListDeletedClustersRequest struct{}
+type ListDeletedClustersResponse tornjakTypes.DeletedClusterList
+
+// ListDeletedClusters returns the deleted clusters that can be restored,
+// most recently deleted first
+func (s *Server) ListDeletedClusters(inp ListDeletedClustersRequest) (*ListDeletedClustersResponse, error) {
+	retVal, err := s.Db.ListDeletedClusters()
+	if err != nil {
+		return nil, err
+	}
+	return (*ListDeletedClustersResponse)(&retVal), nil
+}
+
+type ListSPIRECallsRequest tornjakTypes.ListOptions
+type ListSPIRECallsResponse tornjakTypes.List[tornjakTypes.SPIRECallInfo]
+
+// ListSPIRECalls returns a page of the calls Tornjak made to the SPIRE server
+// with method, duration, status and initiating user, most recent first
+// returns errSPIREQueryLogDisabled if the DataStore does not store the calls
+func (s *Server) ListSPIRECalls(inp ListSPIRECallsRequest) (*ListSPIRECallsResponse, error) {
+	if !agentdb.Supports(s.Db, agentdb.CapabilitySPIREQueryLog) {
+		return nil, errSPIREQueryLogDisabled
+	}
+	retVal, err := s.Db.GetSPIRECallRecords(tornjakTypes.ListOptions(inp))
+	if err != nil {
+		return nil, err
+	}
+	return (*ListSPIRECallsResponse)(&retVal), nil
+}
+
+type GetTxStatsRequest struct{}
+type GetTxStatsResponse tornjakTypes.TxStats
+
+// GetTxStats returns the commits and rollbacks of DB transactions by operation,
+// with rollbacks counted by cause
+func (s *Server) GetTxStats(inp GetTxStatsRequest) (*GetTxStatsResponse, error) {
+	retVal := s.Db.GetTxStats()
+	return (*GetTxStatsResponse)(&retVal), nil
+}
+
+type GetIndexReportRequest struct {
+	// mean duration in milliseconds from which statements are reported as slow,
+	// agentdb.DefaultSlowQueryMillis if 0
+	MinMillis float64 `json:"minMillis"`
+}
+type GetIndexReportResponse tornjakTypes.IndexReport
+
+// GetIndexReport returns the slow queries recorded by the SQL database, its
+// indexes and the indexes missing for the queries of Tornjak, with the DDL
+// creating them
+func (s *Server) GetIndexReport(inp GetIndexReportRequest) (*GetIndexReportResponse, error) {
+	advisor, ok := s.Db.(agentdb.IndexAdvisor)
+	if !ok {
+		return nil, errors.New("DataStore does not support index reports")
+	}
+	if inp.MinMillis < 0 {
+		return nil, errors.New("minMillis must not be negative")
+	}
+	minMillis := inp.MinMillis
+	if minMillis == 0 {
+		minMillis = agentdb.DefaultSlowQueryMillis
+	}
+	retVal, err := advisor.GetIndexReport(minMillis)
+	if err != nil {
+		return nil, err
+	}
+	return (*GetIndexReportResponse)(&retVal), nil
+}
+
+type GetMetadataSchemaRequest struct{}
+type GetMetadataSchemaResponse tornjakTypes.MetadataSchema
+
+// GetMetadataSchema describes the fields of clusters and agents, the configured
+// extension fields per platform type and the JSON schema of cluster metadata,
+// for forms rendered from it
+func (s *Server) GetMetadataSchema(inp GetMetadataSchemaRequest) (*GetMetadataSchemaResponse, error) {
+	retVal := tornjakTypes.NewMetadataSchema(s.clusterExtensions, s.clusterMetadata)
+	return (*GetMetadataSchemaResponse)(&retVal), nil
+}
+
+// CloneEntryOverrides contains the fields replaced in the cloned entry
+// fields that are not set are copied from the source entry
+type CloneEntryOverrides struct {
+	SpiffeId  *types.SPIFFEID   `json:"spiffe_id"`
+	ParentId  *types.SPIFFEID   `json:"parent_id"`
+	Selectors []*types.Selector `json:"selectors"`
+	DnsNames  []string          `json:"dns_names"`
+}
+
+type CloneEntryRequest struct {
+	Id        string              `json:"id"`
+	Overrides CloneEntryOverrides `json:"overrides"`
+}
+type CloneEntryResponse struct {
+	Entry   *types.Entry              `json:"entry"`
+	Lineage tornjakTypes.EntryLineage `json:"lineage"`
+}
+
+// CloneEntry creates a copy of an existing SPIRE entry with the given overrides
+// and records the source entry in the local DB
+func (s *Server) CloneEntry(ctx context.Context, inp CloneEntryRequest) (*CloneEntryResponse, error) {
+	if len(inp.Id) == 0 {
+		return nil, errors.New("input missing mandatory field - Id")
+	}
+	o := inp.Overrides
+	if o.SpiffeId == nil && o.ParentId == nil && len(o.Selectors) == 0 {
+		return nil, errors.New("clone must override at least one of spiffe_id, parent_id or selectors")
+	}
+
+	source, err := s.GetEntry(ctx, GetEntryRequest{Id: inp.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	clone := proto.Clone((*types.Entry)(source)).(*types.Entry)
+	clone.Id = ""
+	clone.CreatedAt = 0
+	clone.RevisionNumber = 0
+	if o.SpiffeId != nil {
+		clone.SpiffeId = o.SpiffeId
+	}
+	if o.ParentId != nil {
+		clone.ParentId = o.ParentId
+	}
+	if len(o.Selectors) > 0 {
+		clone.Selectors = o.Selectors
+	}
+	if o.DnsNames != nil {
+		clone.DnsNames = o.DnsNames
+	}
+
+	resp, err := s.BatchCreateEntry(ctx, BatchCreateEntryRequest{Entries: []*types.Entry{clone}}) //nolint:govet //Ignoring mutex (not being used) - sync.Mutex by value is unused for linter govet
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Results) != 1 {
+		return nil, errors.New("unexpected number of results creating cloned entry")
+	}
+	result := resp.Results[0]
+	if code := codes.Code(result.Status.GetCode()); code != codes.OK {
+		return nil, fmt.Errorf("failed to create cloned entry: %v: %v", code, result.Status.GetMessage())
+	}
+
+	lineage := tornjakTypes.EntryLineage{
+		EntryId:       result.Entry.GetId(),
+		SourceEntryId: inp.Id,
+		CreationTime:  s.clock().Now().UTC().Format(time.RFC3339),
+	}
+	if u := userFromContext(ctx); u != nil {
+		lineage.CreatedBy = u.Username
+	}
+	if err = s.Db.CreateEntryLineage(lineage); err != nil {
+		if s.retryQueue == nil {
+			return nil, fmt.Errorf("entry %v created but lineage not recorded: %w", lineage.EntryId, err)
+		}
+		id, qerr := s.retryQueue.Enqueue("clone_entry", stepRecordEntryLineage, lineage, err, lineage.CreatedBy)
+		if qerr != nil {
+			return nil, fmt.Errorf("entry %v created but lineage not recorded: %w; could not queue retry: %v", lineage.EntryId, err, qerr)
+		}
+		return nil, fmt.Errorf("entry %v created but lineage not recorded, queued for retry as failed operation %d: %w", lineage.EntryId, id, err)
+	}
+
+	return &CloneEntryResponse{
+		Entry:   result.Entry,
+		Lineage: lineage,
+	}, nil
+}
+
+type GetEntryLineageRequest struct {
+	Id string `json:"id"`
+}
+type GetEntryLineageResponse tornjakTypes.EntryLineage
+
+// GetEntryLineage returns the entry the given SPIRE entry was cloned from
+func (s *Server) GetEntryLineage(inp GetEntryLineageRequest) (*GetEntryLineageResponse, error) {
+	if len(inp.Id) == 0 {
+		return nil, errors.New("input missing mandatory field - Id")
+	}
+	retVal, err := s.Db.GetEntryLineage(inp.Id)
+	if err != nil {
+		return nil, err
+	}
+	return (*GetEntryLineageResponse)(&retVal), nil
+}
+
+// service account names are DNS-label-like so they read well in audit usernames
+var serviceAccountNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// prefix of service account API keys, making leaked keys easy to recognize
+const serviceAccountAPIKeyPrefix = "tjk_"
+
+type CreateServiceAccountRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Roles       []string `json:"roles"`
+}
+type CreateServiceAccountResponse tornjakTypes.ServiceAccountCredential
+
+// CreateServiceAccount registers a machine user bound to the given roles and issues its API key
+// the API key is returned only once; the local DB keeps its hash
+func (s *Server) CreateServiceAccount(ctx context.Context, inp CreateServiceAccountRequest) (*CreateServiceAccountResponse, error) {
+	if !serviceAccountNameRegexp.MatchString(inp.Name) {
+		return nil, fmt.Errorf("invalid service account name %q", inp.Name)
+	}
+	if len(inp.Roles) == 0 {
+		return nil, errors.New("input missing mandatory field - Roles")
+	}
+	for _, role := range inp.Roles {
+		if len(role) == 0 || strings.Contains(role, ",") {
+			return nil, fmt.Errorf("invalid role %q", role)
+		}
+	}
+
+	keyBytes := make([]byte, 32)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return nil, fmt.Errorf("could not generate API key: %w", err)
+	}
+	apiKey := serviceAccountAPIKeyPrefix + hex.EncodeToString(keyBytes)
+
+	account := tornjakTypes.ServiceAccount{
+		Name:         inp.Name,
+		Description:  inp.Description,
+		Roles:        inp.Roles,
+		CreationTime: s.clock().Now().UTC().Format(time.RFC3339),
+	}
+	if u := userFromContext(ctx); u != nil {
+		account.CreatedBy = u.Username
+	}
+	if err := s.Db.CreateServiceAccount(account, s.keyHasher.Hash(apiKey)); err != nil {
+		return nil, err
+	}
+	s.invalidateAuthorizationCache(ctx)
+
+	return &CreateServiceAccountResponse{
+		ServiceAccount: account,
+		APIKey:         apiKey,
+	}, nil
+}
+
+type ListServiceAccountsRequest struct{}
+type ListServiceAccountsResponse tornjakTypes.ServiceAccountList
+
+// ListServiceAccounts returns the service accounts and their role bindings from the local DB
+func (s *Server) ListServiceAccounts(inp ListServiceAccountsRequest) (*ListServiceAccountsResponse, error) {
+	retVal, err := s.Db.GetServiceAccounts()
+	if err != nil {
+		return nil, err
+	}
+	return (*ListServiceAccountsResponse)(&retVal), nil
+}
+
+type DeleteServiceAccountRequest struct {
+	Name string `json:"name"`
+}
+
+// DeleteServiceAccount removes a service account, revoking its API key
+func (s *Server) DeleteServiceAccount(inp DeleteServiceAccountRequest) error {
+	if len(inp.Name) == 0 {
+		return errors.New("input missing mandatory field - Name")
+	}
+	if err := s.Db.DeleteServiceAccount(inp.Name); err != nil {
+		return err
+	}
+	s.invalidateAuthorizationCache(context.Background())
+	return nil
+}
+
+type SetEntryOwnerRequest struct {
+	Id        string `json:"id"`
+	OwnerTeam string `json:"ownerTeam"`
+	Tenant    string `json:"tenant"`
+}
+
+// SetEntryOwner assigns an owner team and tenant to a SPIRE entry without owner
+func (s *Server) SetEntryOwner(inp SetEntryOwnerRequest) error {
+	owner := tornjakTypes.EntryOwner{
+		EntryId:   inp.Id,
+		OwnerTeam: inp.OwnerTeam,
+		Tenant:    inp.Tenant,
+		UpdatedAt: s.clock().Now().UTC().Format(time.RFC3339),
+	}
+	if err := owner.Validate(); err != nil {
+		return err
+	}
+	return s.Db.SetEntryOwner(owner)
+}
+
+type ListEntryOwnersRequest struct {
+	Team string `json:"team"`
+}
+type ListEntryOwnersResponse tornjakTypes.EntryOwnerList
+
+// ListEntryOwners returns the owners of entries, restricted to one team if given
+func (s *Server) ListEntryOwners(inp ListEntryOwnersRequest) (*ListEntryOwnersResponse, error) {
+	retVal, err := s.Db.GetEntryOwners(inp.Team)
+	if err != nil {
+		return nil, err
+	}
+	return (*ListEntryOwnersResponse)(&retVal), nil
+}
+
+type TransferOwnershipRequest tornjakTypes.OwnershipTransfer
+type TransferOwnershipResponse tornjakTypes.OwnershipTransferResult
+
+// TransferOwnership moves clusters and entries from one team to another in a
+// single transaction and records an audit record for each of them
+func (s *Server) TransferOwnership(ctx context.Context, inp TransferOwnershipRequest) (*TransferOwnershipResponse, error) {
+	transfer := tornjakTypes.OwnershipTransfer(inp)
+	if err := transfer.Validate(); err != nil {
+		return nil, err
+	}
+	transfer.TransferTime = s.clock().Now().UTC().Format(time.RFC3339)
+	if u := userFromContext(ctx); u != nil {
+		transfer.TransferredBy = u.Username
+	}
+	retVal, err := s.dbAs(ctx).TransferOwnership(transfer)
+	if err != nil {
+		return nil, err
+	}
+	// no notifier is configurable yet, so transfers are announced in the server log
+	log.Printf("ownership of %d objects transferred from team %s to team %s by %q",
+		len(retVal.Transferred), transfer.FromTeam, transfer.ToTeam, transfer.TransferredBy)
+	return (*TransferOwnershipResponse)(&retVal), nil
+}
+
+type ListOwnershipTransfersRequest tornjakTypes.ListOptions
+type ListOwnershipTransfersResponse tornjakTypes.List[tornjakTypes.OwnershipTransferRecord]
+
+// ListOwnershipTransfers returns a page of the ownership transfer audit records, most recent first
+func (s *Server) ListOwnershipTransfers(inp ListOwnershipTransfersRequest) (*ListOwnershipTransfersResponse, error) {
+	retVal, err := s.Db.GetOwnershipTransfers(tornjakTypes.ListOptions(inp))
+	if err != nil {
+		return nil, err
+	}
+	return (*ListOwnershipTransfersResponse)(&retVal), nil
+}
+
+type ApplyLabelOperationRequest tornjakTypes.LabelOperation
+type ApplyLabelOperationResponse tornjakTypes.LabelOperationResult
+
+// ApplyLabelOperation adds, removes or renames a label across the clusters or agents matching a filter
+// all changes are applied in one transaction, or only previewed with DryRun
+func (s *Server) ApplyLabelOperation(ctx context.Context, inp ApplyLabelOperationRequest) (*ApplyLabelOperationResponse, error) {
+	op := tornjakTypes.LabelOperation(inp)
+	if err := s.objectPolicies.ValidateLabelOperation(op); err != nil {
+		return nil, err
+	}
+	retVal, err := s.dbAs(ctx).ApplyLabelOperation(op)
+	if err != nil {
+		return nil, err
+	}
+	if !op.DryRun {
+		user := ""
+		if u := userFromContext(ctx); u != nil {
+			user = u.Username
+		}
+		log.Printf("label %s %s on %d %s by %q", op.Key, op.Action, len(retVal.Changes), op.Target, user)
+	}
+	return (*ApplyLabelOperationResponse)(&retVal), nil
+}
+
+// snapshotter returns db if it supports named snapshots
+func (s *Server) snapshotter(db agentdb.AgentDB) (agentdb.Snapshotter, error) {
+	snapshotter, ok := db.(agentdb.Snapshotter)
+	if !ok {
+		return nil, errors.New("DataStore does not support snapshots")
+	}
+	return snapshotter, nil
+}
+
+type ListSnapshotsRequest struct{}
+type ListSnapshotsResponse tornjakTypes.SnapshotList
+
+// ListSnapshots returns the named snapshots of the Tornjak metadata, most recent first
+func (s *Server) ListSnapshots(inp ListSnapshotsRequest) (*ListSnapshotsResponse, error) {
+	snapshotter, err := s.snapshotter(s.Db)
+	if err != nil {
+		return nil, err
+	}
+	retVal, err := snapshotter.GetSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	return (*ListSnapshotsResponse)(&retVal), nil
+}
+
+type CreateSnapshotRequest struct {
+	Name string `json:"name"`
+}
+type CreateSnapshotResponse tornjakTypes.Snapshot
+
+// CreateSnapshot keeps a named copy of the Tornjak metadata, e.g. before a bulk operation
+func (s *Server) CreateSnapshot(ctx context.Context, inp CreateSnapshotRequest) (*CreateSnapshotResponse, error) {
+	snapshotter, err := s.snapshotter(s.Db)
+	if err != nil {
+		return nil, err
+	}
+	user := ""
+	if u := userFromContext(ctx); u != nil {
+		user = u.Username
+	}
+	retVal, err := snapshotter.CreateSnapshot(inp.Name, user)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("snapshot %q created by %q", retVal.Name, user)
+	return (*CreateSnapshotResponse)(&retVal), nil
+}
+
+type RestoreSnapshotRequest struct {
+	Name string `json:"name"`
+}
+
+// RestoreSnapshot rolls the Tornjak metadata back to a named snapshot
+// the snapshots themselves and the log of SPIRE API calls are kept
+func (s *Server) RestoreSnapshot(ctx context.Context, inp RestoreSnapshotRequest) error {
+	snapshotter, err := s.snapshotter(s.dbAs(ctx))
+	if err != nil {
+		return err
+	}
+	if len(inp.Name) == 0 {
+		return errors.New("input missing mandatory field - Name")
+	}
+	if err := snapshotter.RestoreSnapshot(inp.Name); err != nil {
+		return err
+	}
+	user := ""
+	if u := userFromContext(ctx); u != nil {
+		user = u.Username
+	}
+	log.Printf("snapshot %q restored by %q", inp.Name, user)
+	return nil
+}
+
+type DeleteSnapshotRequest struct {
+	Name string `json:"name"`
+}
+
+// DeleteSnapshot removes a named snapshot
+func (s *Server) DeleteSnapshot(inp DeleteSnapshotRequest) error {
+	snapshotter, err := s.snapshotter(s.Db)
+	if err != nil {
+		return err
+	}
+	if len(inp.Name) == 0 {
 		return errors.New("input missing mandatory field - Name")
 	}
-	return s.Db.DeleteClusterEntry(cinfo.Name)
+	return snapshotter.DeleteSnapshot(inp.Name)
 }
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const requestIDHeader = "X-Request-Id"
+
+// traceHeaders lists the incoming request headers that are propagated
+// to outbound SPIRE calls so traces and logs can be correlated
+var traceHeaders = []string{"traceparent", "tracestate", "x-request-id"}
+
+type traceContextKey struct{}
+
+// traceHeadersFromContext returns the trace headers stored by tracingMiddleware
+func traceHeadersFromContext(ctx context.Context) map[string]string {
+	headers, _ := ctx.Value(traceContextKey{}).(map[string]string)
+	return headers
+}
+
+// requestIDFromContext returns the request id of the incoming request, if any
+func requestIDFromContext(ctx context.Context) string {
+	return traceHeadersFromContext(ctx)["x-request-id"]
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// tracingMiddleware extracts trace headers from the incoming request and
+// stores them in the request context. A request id is generated if the
+// caller did not send one, and echoed back in the response headers.
+func (s *Server) tracingMiddleware(next http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		headers := make(map[string]string)
+		for _, h := range traceHeaders {
+			if v := r.Header.Get(h); v != "" {
+				headers[h] = v
+			}
+		}
+		if headers["x-request-id"] == "" {
+			if id := newRequestID(); id != "" {
+				headers["x-request-id"] = id
+			}
+		}
+		if id := headers["x-request-id"]; id != "" {
+			w.Header().Set(requestIDHeader, id)
+		}
+
+		ctx := context.WithValue(r.Context(), traceContextKey{}, headers)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(f)
+}
+
+// traceUnaryClientInterceptor copies trace headers from the context into
+// outgoing gRPC metadata on calls to the SPIRE server
+func traceUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	for k, v := range traceHeadersFromContext(ctx) {
+		ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
@@ -39,9 +39,200 @@ type TornjakConfig struct {
 /* Server configuration*/
 
 type serverConfig struct {
-	SPIRESocket string       `hcl:"spire_socket_path"`
-	HTTPConfig  *HTTPConfig  `hcl:"http"`
-	HTTPSConfig *HTTPSConfig `hcl:"https"`
+	SPIRESocket      string            `hcl:"spire_socket_path"`
+	MetadataOnly     bool              `hcl:"metadata_only"`
+	HTTPConfig       *HTTPConfig       `hcl:"http"`
+	HTTPSConfig      *HTTPSConfig      `hcl:"https"`
+	SPIRECallsConfig *SPIRECallsConfig `hcl:"spire_calls"`
+	RequestLogConfig *RequestLogConfig `hcl:"request_log"`
+	ClusterExtensions []*ClusterExtensionConfig `hcl:"cluster_extensions,block"`
+	ClusterMetadataConfig *ClusterMetadataConfig `hcl:"cluster_metadata"`
+	SPIREMirrorConfig *SPIREMirrorConfig `hcl:"spire_mirror"`
+	DesiredStateConfig *DesiredStateConfig `hcl:"desired_state"`
+	ReplicationConfig *ReplicationConfig `hcl:"replication"`
+	ChangeProposalsConfig *ChangeProposalsConfig `hcl:"change_proposals"`
+	BundleMonitorConfig *BundleMonitorConfig `hcl:"bundle_monitor"`
+	BundleEndpointConfig *BundleEndpointConfig `hcl:"bundle_endpoint"`
+	AuthorizationCacheConfig *AuthorizationCacheConfig `hcl:"authorization_cache"`
+	EntryTTLPolicyConfig *EntryTTLPolicyConfig `hcl:"entry_ttl_policy"`
+	WebhookVerificationConfig *WebhookVerificationConfig `hcl:"webhook_verification"`
+	BootstrapBrokerConfig *BootstrapBrokerConfig `hcl:"bootstrap_broker"`
+	EntryLifecycleConfig *EntryLifecycleConfig `hcl:"entry_lifecycle"`
+	RetryQueueConfig *RetryQueueConfig `hcl:"retry_queue"`
+	DashboardConfig *DashboardConfig `hcl:"dashboard"`
+	TelemetryConfig *TelemetryConfig `hcl:"telemetry"`
+	EntryBulkDeleteConfig *EntryBulkDeleteConfig `hcl:"entry_bulk_delete"`
+	WarmUpConfig *WarmUpConfig `hcl:"warm_up"`
+	ObjectPolicyConfig *ObjectPolicyConfig `hcl:"object_policy"`
+	DiagnosticsConfig *DiagnosticsConfig `hcl:"diagnostics"`
+	DatastoreHealthConfig *DatastoreHealthConfig `hcl:"datastore_health"`
+	IntegrityCheckConfig *IntegrityCheckConfig `hcl:"integrity_check"`
+	ClusterDeletionConfig *ClusterDeletionConfig `hcl:"cluster_deletion"`
+}
+
+type IntegrityCheckConfig struct {
+	Interval string `hcl:"interval"`
+}
+
+type ClusterDeletionConfig struct {
+	BackgroundThreshold int    `hcl:"background_threshold"`
+	BatchSize           int    `hcl:"batch_size"`
+	BatchInterval       string `hcl:"batch_interval"`
+}
+
+type DatastoreHealthConfig struct {
+	ProbeInterval    string `hcl:"probe_interval"`
+	RecoveryInterval string `hcl:"recovery_interval"`
+}
+
+type DiagnosticsConfig struct {
+	Port        int    `hcl:"port"`
+	Host        string `hcl:"host"`
+	SnapshotDir string `hcl:"snapshot_dir"`
+}
+
+type WarmUpConfig struct {
+	Timeout string `hcl:"timeout"`
+}
+
+type RetryQueueConfig struct {
+	Interval    string `hcl:"interval"`
+	MaxAttempts int    `hcl:"max_attempts"`
+}
+
+type DashboardConfig struct {
+	RefreshInterval string `hcl:"refresh_interval"`
+	ExpiringWithin  string `hcl:"expiring_within"`
+}
+
+type TelemetryConfig struct {
+	Enabled  bool   `hcl:"enabled"`
+	Endpoint string `hcl:"endpoint"`
+	Interval string `hcl:"interval"`
+	Timeout  string `hcl:"timeout"`
+}
+
+type EntryBulkDeleteConfig struct {
+	ConfirmThreshold int    `hcl:"confirm_threshold"`
+	BatchSize        int    `hcl:"batch_size"`
+	BatchInterval    string `hcl:"batch_interval"`
+}
+
+type BootstrapBrokerConfig struct {
+	DefaultTTL    string `hcl:"default_ttl"`
+	MaxTTL        string `hcl:"max_ttl"`
+	SweepInterval string `hcl:"sweep_interval"`
+}
+
+type EntryLifecycleConfig struct {
+	NoticePeriod  string `hcl:"notice_period"`
+	SweepInterval string `hcl:"sweep_interval"`
+}
+
+type WebhookVerificationConfig struct {
+	Secrets []string `hcl:"secrets"`
+	MaxSkew string   `hcl:"max_skew"`
+}
+
+type EntryTTLPolicyConfig struct {
+	X509SVIDTTLMin string `hcl:"x509_svid_ttl_min"`
+	X509SVIDTTLMax string `hcl:"x509_svid_ttl_max"`
+	JWTSVIDTTLMin  string `hcl:"jwt_svid_ttl_min"`
+	JWTSVIDTTLMax  string `hcl:"jwt_svid_ttl_max"`
+	AgentTTL       string `hcl:"agent_ttl"`
+}
+
+type AuthorizationCacheConfig struct {
+	TTL string `hcl:"ttl"`
+}
+
+type BundleMonitorConfig struct {
+	CheckInterval string `hcl:"check_interval"`
+	StaleAfter    string `hcl:"stale_after"`
+	FetchTimeout  string `hcl:"fetch_timeout"`
+}
+
+type BundleEndpointConfig struct {
+	Port              int    `hcl:"port"`
+	Profile           string `hcl:"profile"`
+	Cert              string `hcl:"cert"`
+	Key               string `hcl:"key"`
+	WorkloadAPISocket string `hcl:"workload_api_socket"`
+	RefreshInterval   string `hcl:"refresh_interval"`
+	RefreshHint       string `hcl:"refresh_hint"`
+	FetchTimeout      string `hcl:"fetch_timeout"`
+}
+
+type ChangeProposalsConfig struct {
+	RepoPath     string `hcl:"repo_path"`
+	File         string `hcl:"file"`
+	BaseBranch   string `hcl:"base_branch"`
+	BranchPrefix string `hcl:"branch_prefix"`
+	Remote       string `hcl:"remote"`
+}
+
+type DesiredStateConfig struct {
+	File     string `hcl:"file"`
+	URL      string `hcl:"url"`
+	Interval string `hcl:"interval"`
+	DryRun   bool   `hcl:"dry_run"`
+}
+
+type ReplicationConfig struct {
+	PrimaryURL     string `hcl:"primary_url"`
+	APIKeyFile     string `hcl:"api_key_file"`
+	CAFile         string `hcl:"ca_file"`
+	Interval       string `hcl:"interval"`
+	Timeout        string `hcl:"timeout"`
+	BatchSize      int    `hcl:"batch_size"`
+	ConflictPolicy string `hcl:"conflict_policy"`
+}
+
+type SPIREMirrorConfig struct {
+	SyncInterval string `hcl:"sync_interval"`
+	StaleAfter   string `hcl:"stale_after"`
+}
+
+type ObjectPolicyConfig struct {
+	ClusterName   *NamePolicyConfig `hcl:"cluster_name"`
+	LabelKey      *NamePolicyConfig `hcl:"label_key"`
+	LabelValue    *NamePolicyConfig `hcl:"label_value"`
+	NoteMaxLength int               `hcl:"note_max_length"`
+}
+
+type NamePolicyConfig struct {
+	MaxLength        int      `hcl:"max_length"`
+	Pattern          string   `hcl:"pattern"`
+	ReservedPrefixes []string `hcl:"reserved_prefixes"`
+}
+
+type ClusterExtensionConfig struct {
+	PlatformType string                         `hcl:",key"`
+	Fields       []*ClusterExtensionFieldConfig `hcl:"field,block"`
+}
+
+type ClusterMetadataConfig struct {
+	Schema     string `hcl:"schema"`
+	SchemaFile string `hcl:"schema_file"`
+	Required   bool   `hcl:"required"`
+}
+
+type ClusterExtensionFieldConfig struct {
+	Name     string   `hcl:",key"`
+	Type     string   `hcl:"type"`
+	Required bool     `hcl:"required"`
+	Enum     []string `hcl:"enum"`
+}
+
+type RequestLogConfig struct {
+	LogBodies    bool     `hcl:"log_bodies"`
+	MaxBodyBytes int      `hcl:"max_body_bytes"`
+	RedactPaths  []string `hcl:"redact_paths"`
+}
+
+type SPIRECallsConfig struct {
+	MaxConcurrent int    `hcl:"max_concurrent"`
+	QueueTimeout  string `hcl:"queue_timeout"`
 }
 
 type HTTPConfig struct {
@@ -104,8 +295,72 @@ func (h HTTPSConfig) Parse() (*tls.Config, error) {
 
 /* Plugin types */
 type pluginDataStoreSQL struct {
-	Drivername string `hcl:"drivername"`
-	Filename   string `hcl:"filename"`
+	Drivername            string `hcl:"drivername"`
+	Filename              string `hcl:"filename"`
+	ClusterNameUniqueness string `hcl:"cluster_name_uniqueness"`
+	Collation             string `hcl:"collation"`
+	Locale                string `hcl:"locale"`
+	SnapshotDir           string `hcl:"snapshot_dir"`
+}
+
+type pluginDataStoreInMemory struct {
+	ClusterNameUniqueness string `hcl:"cluster_name_uniqueness"`
+	Collation             string `hcl:"collation"`
+	Locale                string `hcl:"locale"`
+	SnapshotDir           string `hcl:"snapshot_dir"`
+}
+
+type pluginDataStorePostgres struct {
+	ConnectionString      string `hcl:"connection_string"`
+	ClusterNameUniqueness string `hcl:"cluster_name_uniqueness"`
+	Collation             string `hcl:"collation"`
+	Locale                string `hcl:"locale"`
+}
+
+type pluginDataStoreMySQL struct {
+	ConnectionString      string `hcl:"connection_string"`
+	ClusterNameUniqueness string `hcl:"cluster_name_uniqueness"`
+	Collation             string `hcl:"collation"`
+	Locale                string `hcl:"locale"`
+}
+
+type pluginCacheRedis struct {
+	Address   string `hcl:"address"`
+	Password  string `hcl:"password"`
+	DB        int    `hcl:"db"`
+	KeyPrefix string `hcl:"key_prefix"`
+}
+
+type pluginEncryptionLocal struct {
+	PrimaryKey string           `hcl:"primary_key"`
+	Keys       []*encryptionKey `hcl:"key,block"`
+}
+
+type encryptionKey struct {
+	ID   string `hcl:",key"`
+	File string `hcl:"file"`
+}
+
+type pluginEncryptionVaultTransit struct {
+	Address string `hcl:"address"`
+	Token   string `hcl:"token"`
+	Mount   string `hcl:"mount"`
+	KeyName string `hcl:"key_name"`
+}
+
+type pluginEncryptionAWSKMS struct {
+	Region          string `hcl:"region"`
+	KeyID           string `hcl:"key_id"`
+	Endpoint        string `hcl:"endpoint"`
+	AccessKeyID     string `hcl:"access_key_id"`
+	SecretAccessKey string `hcl:"secret_access_key"`
+	SessionToken    string `hcl:"session_token"`
+}
+
+type pluginEncryptionGCPKMS struct {
+	KeyName         string `hcl:"key_name"`
+	CredentialsFile string `hcl:"credentials_file"`
+	Endpoint        string `hcl:"endpoint"`
 }
 
 type pluginAuthenticatorKeycloak struct {
@@ -135,4 +390,7 @@ type pluginAuthorizerRBAC struct {
 	RoleList        []*AuthRole       `hcl:"role,block"`
 	APIRoleMappings []*APIRoleMapping `hcl:"API,block"`
 	APIv1RoleMappings []*APIv1RoleMapping `hcl:"APIv1,block"`
+	// YAML policy adding roles and API mappings, read again when it changes
+	PolicyFile           string `hcl:"policy_file"`
+	PolicyReloadInterval string `hcl:"policy_reload_interval"`
 }
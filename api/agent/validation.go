@@ -0,0 +1,84 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+
+	"github.com/spiffe/tornjak"
+)
+
+// requestValidator checks incoming requests against the Tornjak OpenAPI spec
+type requestValidator struct {
+	router routers.Router
+}
+
+// newRequestValidator loads the embedded OpenAPI spec
+func newRequestValidator() (*requestValidator, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(tornjak.OpenAPISpec)
+	if err != nil {
+		return nil, err
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &requestValidator{router: router}, nil
+}
+
+// middleware rejects requests whose parameters or body do not match the
+// OpenAPI spec before they reach the handlers. Requests to routes not
+// described by the spec, such as the unversioned API, are passed through.
+func (v *requestValidator) middleware(next http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := v.router.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// handlers parse every body as JSON regardless of the declared type
+		vr := r.Clone(r.Context())
+		vr.Body = r.Body
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			vr.Header.Set("Content-Type", "application/json")
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    vr,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		}
+		if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+			emsg := fmt.Sprintf("Error: invalid request: %v", validationErrorMessage(err))
+			retError(w, emsg, http.StatusBadRequest)
+			return
+		}
+
+		// validation consumes the body and replaces it with a copy
+		r.Body = vr.Body
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(f)
+}
+
+// validationErrorMessage returns a short description of a validation error
+// without the offending schema, which is included in the error by default
+func validationErrorMessage(err error) string {
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		return fmt.Sprintf("field \"/%v\": %v", strings.Join(schemaErr.JSONPointer(), "/"), schemaErr.Reason)
+	}
+	return err.Error()
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRequestValidator checks requests violating the OpenAPI spec are rejected
+// before the handler, and valid requests and routes missing from the spec
+// are passed through with their body and context
+func TestRequestValidator(t *testing.T) {
+	validator, err := newRequestValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type ctxKey struct{}
+	var reached bool
+	var body string
+	var ctxValue interface{}
+	handler := validator.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = string(data)
+		ctxValue = r.Context().Value(ctxKey{})
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"valid body", http.MethodPost, "/api/v1/tornjak/clusters/bulk-delete", `{"uids": ["3f2b8c1d9e7a4b6c8d0e1f2a3b4c5d6e"]}`, http.StatusOK},
+		{"field of the wrong type", http.MethodPost, "/api/v1/tornjak/clusters/bulk-delete", `{"uids": 5}`, http.StatusBadRequest},
+		{"missing required field", http.MethodPost, "/api/v1/tornjak/clusters/bulk-delete", `{}`, http.StatusBadRequest},
+		{"route missing from the spec", http.MethodPost, "/api/tornjak/unversioned", `not json`, http.StatusOK},
+	} {
+		reached, body, ctxValue = false, "", nil
+		r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", "application/json")
+		r = r.WithContext(context.WithValue(r.Context(), ctxKey{}, tc.name))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tc.status {
+			t.Fatalf("%s: expected status %d, got %d: %s", tc.name, tc.status, w.Code, w.Body.String())
+		}
+		if tc.status != http.StatusOK {
+			if reached {
+				t.Fatalf("%s: invalid request reached the handler", tc.name)
+			}
+			if !strings.Contains(w.Body.String(), "invalid request") {
+				t.Fatalf("%s: unexpected error %q", tc.name, w.Body.String())
+			}
+			continue
+		}
+		// CHECK the handler gets the body and the context of the request
+		if !reached || body != tc.body || ctxValue != tc.name {
+			t.Fatalf("%s: handler got body %q, context value %v", tc.name, body, ctxValue)
+		}
+	}
+}
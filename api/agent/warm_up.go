@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// default longest time the readiness probe waits for the caches to be primed
+const defaultWarmUpTimeout = time.Minute
+
+// names of the caches primed on startup
+const (
+	warmUpSPIREMirror = "spire_mirror"
+	warmUpDashboard   = "dashboard"
+)
+
+// warmUp tracks the first fill of the caches after startup; the readiness
+// probe fails until all are filled, so the first users after a deploy are
+// not served from cold caches
+type warmUp struct {
+	timeout time.Duration
+
+	mu        sync.Mutex
+	startedAt time.Time
+	pending   map[string]bool
+	// errors of failed first fills by cache, a failed fill still ends the wait
+	errors map[string]string
+}
+
+func newWarmUp(config *WarmUpConfig) (*warmUp, error) {
+	timeout, err := parseConfigDuration("timeout", config.Timeout, defaultWarmUpTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &warmUp{
+		timeout: timeout,
+		pending: map[string]bool{},
+		errors:  map[string]string{},
+	}, nil
+}
+
+// start waits for the first fill of the given caches from now on
+func (w *warmUp) start(now time.Time, caches ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.startedAt = now
+	for _, name := range caches {
+		w.pending[name] = true
+	}
+}
+
+// primed records the end of the first fill of a cache
+// it does nothing if warm-up is disabled or the cache was already primed
+func (w *warmUp) primed(name string, err error, now time.Time) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.pending[name] {
+		return
+	}
+	delete(w.pending, name)
+	if err != nil {
+		w.errors[name] = err.Error()
+	}
+	if len(w.pending) == 0 {
+		log.Printf("Caches primed in %v", now.Sub(w.startedAt).Round(time.Millisecond))
+	}
+}
+
+// ReadinessStatus is the state of the startup phase reported by the readiness probe
+type ReadinessStatus struct {
+	Ready bool `json:"ready"`
+	// caches not primed yet
+	Pending []string `json:"pending,omitempty"`
+	// set if the server became ready before all caches were primed
+	TimedOut bool `json:"timedOut,omitempty"`
+	// errors of failed first fills by cache
+	Errors map[string]string `json:"errors,omitempty"`
+	// set while the DataStore is unavailable; the server stays ready, as
+	// SPIRE requests are still served
+	Degraded bool `json:"degraded,omitempty"`
+	// status of the backends probed in the background, by component
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
+
+// status returns whether all caches are primed, or the timeout has passed
+func (w *warmUp) status(now time.Time) ReadinessStatus {
+	if w == nil {
+		return ReadinessStatus{Ready: true}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status := ReadinessStatus{Ready: true}
+	for name := range w.pending {
+		status.Pending = append(status.Pending, name)
+	}
+	sort.Strings(status.Pending)
+	if len(w.errors) > 0 {
+		status.Errors = map[string]string{}
+		for name, msg := range w.errors {
+			status.Errors[name] = msg
+		}
+	}
+	if len(status.Pending) > 0 {
+		status.Ready = false
+		if !w.startedAt.IsZero() && now.Sub(w.startedAt) >= w.timeout {
+			status.Ready = true
+			status.TimedOut = true
+		}
+	}
+	return status
+}
+
+// startWarmUp waits for the first fill of the caches refreshed in the background
+func (s *Server) startWarmUp() {
+	if s.warmUp == nil {
+		return
+	}
+	caches := []string{}
+	if s.spireMirror != nil {
+		caches = append(caches, warmUpSPIREMirror)
+	}
+	if s.dashboard != nil {
+		caches = append(caches, warmUpDashboard)
+	}
+	s.warmUp.start(s.clock().Now(), caches...)
+}
+
+// ready is the readiness probe, it fails with 503 while the caches are primed
+// and reports whether the server is degraded without failing
+func (s *Server) ready(w http.ResponseWriter, r *http.Request) {
+	status := s.warmUp.status(s.clock().Now())
+	if s.datastoreHealth != nil {
+		datastore := s.datastoreHealth.current()
+		status.Degraded = !datastore.Available
+		status.Components = map[string]ComponentStatus{componentDatastore: datastore}
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	if status.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Printf("WARNING: could not write readiness status: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// default time webhook requests are accepted before or after their timestamp
+const defaultWebhookMaxSkew = 5 * time.Minute
+
+// webhookReceiver wraps the handler of an integration endpoint so requests
+// must carry a valid signature, a recent timestamp and an unused nonce when
+// webhook_verification is configured
+func (s *Server) webhookReceiver(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.webhookVerifier == nil {
+			next(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+			retError(w, emsg, http.StatusBadRequest)
+			return
+		}
+		if err = s.webhookVerifier.Verify(r.Context(), r.Header, body); err != nil {
+			log.Printf("WARNING: rejected webhook request to %s: %v", r.URL.Path, err)
+			emsg := fmt.Sprintf("Error verifying request: %v", err.Error())
+			retError(w, emsg, http.StatusUnauthorized)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
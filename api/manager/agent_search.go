@@ -0,0 +1,144 @@
+package managerapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	agenttypes "github.com/spiffe/tornjak/pkg/agent/types"
+	"github.com/spiffe/tornjak/pkg/manager/aggregation"
+	managertypes "github.com/spiffe/tornjak/pkg/manager/types"
+)
+
+// time to wait for each server on an agent search
+const agentSearchTimeout = 10 * time.Second
+
+type SearchAgentsRequest struct {
+	Spiffeid string
+}
+type SearchAgentsResponse managertypes.AgentSearchResult
+
+// SearchAgents queries the agent metadata of all registered servers in parallel
+// and returns the servers that know the agent
+// servers that cannot be queried are reported in Errors rather than failing the
+// search, or with their last result marked stale in Freshness if still cached
+func (s *Server) SearchAgents(inp SearchAgentsRequest, r *http.Request) (*SearchAgentsResponse, error) {
+	if !strings.HasPrefix(inp.Spiffeid, "spiffe://") {
+		return nil, errors.New("spiffeid must be a SPIFFE ID")
+	}
+	// GetServers rather than ListServers, which drops the client credentials
+	servers, err := s.db.GetServers()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &SearchAgentsResponse{
+		Spiffeid:  inp.Spiffeid,
+		Locations: []managertypes.AgentLocation{},
+		Errors:    map[string]string{},
+		Freshness: map[string]managertypes.SourceFreshness{},
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, sinfo := range servers.Servers {
+		wg.Add(1)
+		go func(sinfo managertypes.ServerInfo) {
+			defer wg.Done()
+			agents, freshness, err := aggregation.Fetch(s.aggregates, "agents/search/"+sinfo.Name+"/"+inp.Spiffeid, func() (*agenttypes.AgentInfoList, error) {
+				return queryServerAgents(sinfo, inp.Spiffeid, r)
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				ret.Errors[sinfo.Name] = err.Error()
+				return
+			}
+			ret.Freshness[sinfo.Name] = freshness
+			for _, agent := range agents.Agents {
+				if agent.Spiffeid != inp.Spiffeid {
+					continue
+				}
+				ret.Locations = append(ret.Locations, managertypes.AgentLocation{
+					Server:      sinfo.Name,
+					Cluster:     agent.Cluster,
+					Plugin:      agent.Plugin,
+					DisplayName: agent.DisplayName,
+					Labels:      agent.Labels,
+				})
+			}
+		}(sinfo)
+	}
+	wg.Wait()
+	sort.Slice(ret.Locations, func(i, j int) bool {
+		return ret.Locations[i].Server < ret.Locations[j].Server
+	})
+	return ret, nil
+}
+
+// queryServerAgents returns the metadata of the agent stored by the Tornjak of the server
+func queryServerAgents(sinfo managertypes.ServerInfo, spiffeid string, r *http.Request) (*agenttypes.AgentInfoList, error) {
+	client, err := sinfo.HttpClient()
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(agenttypes.AgentMetadataRequest{Agents: []string{spiffeid}})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), agentSearchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(sinfo.Address, "/")+"/api/v1/tornjak/agents", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for _, h := range traceHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, errors.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	var ret agenttypes.AgentInfoList
+	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return nil, errors.Errorf("invalid response: %v", err)
+	}
+	return &ret, nil
+}
+
+// agentSearch locates the servers knowing the agent of the spiffeid query parameter
+func (s *Server) agentSearch(w http.ResponseWriter, r *http.Request) {
+	input := SearchAgentsRequest{Spiffeid: r.URL.Query().Get("spiffeid")}
+
+	ret, err := s.SearchAgents(input, r)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	cors(w, r)
+
+	je := json.NewEncoder(w)
+	err = je.Encode(ret)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+}
@@ -0,0 +1,20 @@
+package managerapi
+
+import (
+	"time"
+
+	"github.com/spiffe/tornjak/pkg/manager/aggregation"
+)
+
+// defaults of the cache of fan-out results
+const (
+	DefaultAggregationTTL      = 15 * time.Second
+	DefaultAggregationMaxStale = time.Hour
+)
+
+// ConfigureAggregationCache sets how long the results of each server and peer
+// of fan-out calls are served without querying it again, and how long they are
+// served, marked stale, while it cannot be queried
+func (s *Server) ConfigureAggregationCache(ttl, maxStale time.Duration) {
+	s.aggregates = aggregation.New(ttl, maxStale, nil)
+}
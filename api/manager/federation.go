@@ -0,0 +1,354 @@
+package managerapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/manager/aggregation"
+	managertypes "github.com/spiffe/tornjak/pkg/manager/types"
+)
+
+// viaHeader lists the ids of the managers a federated request passed through, in order
+const viaHeader = "X-Tornjak-Manager-Via"
+
+// maximum number of managers a federated request may pass through
+const maxFederationHops = 8
+
+// errFederationLoop is returned when a federated request reaches a manager twice
+var errFederationLoop = errors.New("federation loop detected")
+
+// ConfigureFederation sets the id of this manager, used for loop detection, and the
+// bearer tokens peers must present on federated requests
+// the default id is kept if id is empty; all requests are accepted if no tokens are given
+func (s *Server) ConfigureFederation(id string, inboundTokens []string) {
+	if id != "" {
+		s.id = id
+	}
+	s.federationTokens = inboundTokens
+}
+
+// defaultManagerID returns the id of this manager if none is configured
+func defaultManagerID(listenAddr string) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return hostname + listenAddr
+}
+
+// federationVia checks the request is authorized and has not looped
+// returns the managers the request passed through, ending with this one
+func (s *Server) federationVia(r *http.Request) ([]string, error) {
+	if len(s.federationTokens) > 0 {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		authorized := false
+		for _, t := range s.federationTokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				authorized = true
+			}
+		}
+		if !authorized {
+			return nil, errors.New("invalid federation token")
+		}
+	}
+
+	via := []string{}
+	if v := r.Header.Get(viaHeader); v != "" {
+		via = strings.Split(v, ",")
+	}
+	for _, id := range via {
+		if id == s.id {
+			return nil, errFederationLoop
+		}
+	}
+	if len(via) >= maxFederationHops {
+		return nil, errors.Errorf("federated request exceeds %d managers", maxFederationHops)
+	}
+	return append(via, s.id), nil
+}
+
+// retFederationError writes a federation error with the matching status
+func retFederationError(w http.ResponseWriter, err error) {
+	status := http.StatusUnauthorized
+	if err == errFederationLoop || strings.Contains(err.Error(), "exceeds") {
+		status = http.StatusLoopDetected
+	}
+	retError(w, fmt.Sprintf("Error: %v", err.Error()), status)
+}
+
+// peerRequest creates a request to path on the peer, carrying its token and the managers passed through
+func peerRequest(pinfo managertypes.PeerInfo, method, path string, body io.Reader, via []string, r *http.Request) (*http.Request, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(pinfo.Address, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if pinfo.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+pinfo.Token)
+	}
+	req.Header.Set(viaHeader, strings.Join(via, ","))
+	for _, h := range traceHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	return req, nil
+}
+
+type ListPeersRequest struct{}
+type ListPeersResponse managertypes.PeerInfoList
+
+// ListPeers returns the peer managers without their tokens
+func (s *Server) ListPeers(inp ListPeersRequest) (*ListPeersResponse, error) {
+	resp, err := s.db.GetPeers()
+	if err != nil {
+		return nil, err
+	}
+	for i := range resp.Peers {
+		resp.Peers[i].Token = ""
+	}
+	return (*ListPeersResponse)(&resp), nil
+}
+
+type RegisterPeerRequest managertypes.PeerInfo
+
+// RegisterPeer federates this manager with a peer manager
+func (s *Server) RegisterPeer(inp RegisterPeerRequest) error {
+	pinfo := managertypes.PeerInfo(inp)
+	if len(pinfo.Name) == 0 || len(pinfo.Address) == 0 {
+		return errors.New("Peer info missing mandatory fields")
+	}
+	if strings.Contains(pinfo.Name, "/") {
+		return errors.New("Peer name must not contain '/'")
+	}
+	return s.db.CreatePeerEntry(pinfo)
+}
+
+// federatedServers returns the servers of this manager and, recursively, of its peers
+func (s *Server) federatedServers(via []string, r *http.Request) (*managertypes.FederatedServerInfoList, error) {
+	local, err := s.ListServers(ListServersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	ret := &managertypes.FederatedServerInfoList{
+		Servers:   []managertypes.FederatedServerInfo{},
+		Errors:    map[string]string{},
+		Freshness: map[string]managertypes.SourceFreshness{},
+	}
+	for _, sinfo := range local.Servers {
+		ret.Servers = append(ret.Servers, managertypes.FederatedServerInfo{Managers: []string{}, Server: sinfo})
+	}
+
+	peers, err := s.db.GetPeers()
+	if err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, pinfo := range peers.Peers {
+		wg.Add(1)
+		go func(pinfo managertypes.PeerInfo) {
+			defer wg.Done()
+			peerList, freshness, err := aggregation.Fetch(s.aggregates, "federation/servers/"+pinfo.Name, func() (*managertypes.FederatedServerInfoList, error) {
+				return queryPeerServers(pinfo, via, r)
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if errors.Is(err, errFederationLoop) {
+				// the peer was already visited on this request
+				return
+			} else if err != nil {
+				ret.Errors[pinfo.Name] = err.Error()
+				return
+			}
+			ret.Freshness[pinfo.Name] = freshness
+			for _, fsinfo := range peerList.Servers {
+				fsinfo.Managers = append([]string{pinfo.Name}, fsinfo.Managers...)
+				ret.Servers = append(ret.Servers, fsinfo)
+			}
+			for name, msg := range peerList.Errors {
+				ret.Errors[pinfo.Name+"/"+name] = msg
+			}
+			for name, f := range peerList.Freshness {
+				if freshness.Stale {
+					// the servers of the peer are no fresher than its own result
+					f.Stale, f.Error = true, freshness.Error
+				}
+				ret.Freshness[pinfo.Name+"/"+name] = f
+			}
+		}(pinfo)
+	}
+	wg.Wait()
+	return ret, nil
+}
+
+func queryPeerServers(pinfo managertypes.PeerInfo, via []string, r *http.Request) (*managertypes.FederatedServerInfoList, error) {
+	client, err := pinfo.HttpClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := peerRequest(pinfo, http.MethodGet, "/manager-api/federation/server/list", nil, via, r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusLoopDetected {
+		// the peer answered, so no stale list is served in place of its list
+		return nil, aggregation.Final(errFederationLoop)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, errors.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var ret managertypes.FederatedServerInfoList
+	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return nil, errors.Errorf("invalid response: %v", err)
+	}
+	return &ret, nil
+}
+
+func (s *Server) peerList(w http.ResponseWriter, r *http.Request) {
+	ret, err := s.ListPeers(ListPeersRequest{})
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	cors(w, r)
+
+	je := json.NewEncoder(w)
+	err = je.Encode(ret)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+}
+
+func (s *Server) peerRegister(w http.ResponseWriter, r *http.Request) {
+	buf := new(strings.Builder)
+
+	n, err := io.Copy(buf, r.Body)
+	if err != nil {
+		emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	data := buf.String()
+
+	var input RegisterPeerRequest
+	if n == 0 {
+		input = RegisterPeerRequest{}
+	} else {
+		err := json.Unmarshal([]byte(data), &input)
+		if err != nil {
+			emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+			retError(w, emsg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = s.RegisterPeer(input)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+
+	cors(w, r)
+	_, err = w.Write([]byte("SUCCESS"))
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+}
+
+// federatedServerList aggregates the servers of this manager and all reachable peers
+func (s *Server) federatedServerList(w http.ResponseWriter, r *http.Request) {
+	via, err := s.federationVia(r)
+	if err != nil {
+		retFederationError(w, err)
+		return
+	}
+
+	ret, err := s.federatedServers(via, r)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	cors(w, r)
+
+	je := json.NewEncoder(w)
+	err = je.Encode(ret)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+}
+
+// peerProxy forwards a manager API call to a peer manager
+// /manager-api/peers/proxy/{peer}/manager-api/... is sent to the peer as /manager-api/...
+func (s *Server) peerProxy(w http.ResponseWriter, r *http.Request) {
+	via, err := s.federationVia(r)
+	if err != nil {
+		retFederationError(w, err)
+		return
+	}
+
+	peerName := mux.Vars(r)["peer"]
+	pinfo, err := s.db.GetPeer(peerName)
+	if err != nil {
+		emsg := fmt.Sprintf("Error getting peer info: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	client, err := pinfo.HttpClient()
+	if err != nil {
+		emsg := fmt.Sprintf("Error initializing peer client: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/manager-api/peers/proxy/"+peerName)
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	req, err := peerRequest(pinfo, r.Method, path, r.Body, via, r)
+	if err != nil {
+		emsg := fmt.Sprintf("Error creating http request: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	req.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		emsg := fmt.Sprintf("Error making api call to peer: %v", err.Error())
+		retError(w, emsg, http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	copyHeader(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(w, resp.Body)
+	if err != nil {
+		emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+}
@@ -0,0 +1,197 @@
+package managerapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/encryption"
+	"github.com/spiffe/tornjak/pkg/manager/registry"
+	managertypes "github.com/spiffe/tornjak/pkg/manager/types"
+)
+
+// maximum size of an imported registry document
+const maxRegistryDocumentSize = 16 << 20
+
+// errRegistrySigningDisabled is returned by exports and imports without a signing key
+var errRegistrySigningDisabled = errors.New("server registry export and import require a signing key, set by TORNJAK_MANAGER_REGISTRY_KEY_FILE")
+
+// ConfigureRegistrySigning sets the key signing exported server registries
+// and verifying imported ones, identified by keyID
+func (s *Server) ConfigureRegistrySigning(keyID string, key []byte) error {
+	signer, err := registry.NewSigner(keyID, key)
+	if err != nil {
+		return err
+	}
+	s.registrySigner = signer
+	return nil
+}
+
+// encryptsSecrets returns whether the manager stores secrets encrypted, so
+// private keys can be exported encrypted
+func (s *Server) encryptsSecrets() bool {
+	if s.encryption == nil {
+		return false
+	}
+	_, null := s.encryption.(*encryption.NullProvider)
+	return !null
+}
+
+// ExportServers returns the signed YAML document of the registered servers
+// private keys are exported encrypted under the encryption key of the
+// manager, and left out if the manager does not encrypt them
+func (s *Server) ExportServers() ([]byte, error) {
+	if s.registrySigner == nil {
+		return nil, errRegistrySigningDisabled
+	}
+	servers, err := s.db.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	doc := registry.Document{
+		Manager:    s.id,
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Servers:    []registry.Server{},
+	}
+	for _, sinfo := range servers.Servers {
+		server := registry.Server{
+			Name:    sinfo.Name,
+			Address: sinfo.Address,
+			TLS:     sinfo.TLS,
+			MTLS:    sinfo.MTLS,
+			CA:      string(sinfo.CA),
+			Cert:    string(sinfo.Cert),
+		}
+		if len(sinfo.Key) > 0 {
+			if !s.encryptsSecrets() {
+				log.Printf("WARNING: private key of server %s left out of the export, as the manager has no encryption key", sinfo.Name)
+			} else {
+				key, err := s.encryption.Encrypt(sinfo.Key)
+				if err != nil {
+					return nil, errors.Errorf("Unable to encrypt key of server %s: %v", sinfo.Name, err)
+				}
+				server.EncryptedKey = string(key)
+			}
+		}
+		doc.Servers = append(doc.Servers, server)
+	}
+	return s.registrySigner.Marshal(doc)
+}
+
+type ImportServersResponse struct {
+	// names of the servers registered
+	Imported []string `json:"imported"`
+	// names of the servers left unchanged, as a server with the name is registered
+	Skipped []string `json:"skipped"`
+	// errors of the servers that could not be registered, by name
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// ImportServers registers the servers of a signed YAML document exported by
+// ExportServers, leaving the servers already registered unchanged
+func (s *Server) ImportServers(data []byte) (*ImportServersResponse, error) {
+	if s.registrySigner == nil {
+		return nil, errRegistrySigningDisabled
+	}
+	doc, err := s.registrySigner.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	resp := &ImportServersResponse{Imported: []string{}, Skipped: []string{}, Failed: map[string]string{}}
+	for _, server := range doc.Servers {
+		if len(server.Name) == 0 || len(server.Address) == 0 {
+			resp.Failed[server.Name] = "Server info missing mandatory fields"
+			continue
+		}
+		if _, err := s.db.GetServer(server.Name); err == nil {
+			resp.Skipped = append(resp.Skipped, server.Name)
+			continue
+		}
+		sinfo := managertypes.ServerInfo{
+			Name:    server.Name,
+			Address: server.Address,
+			TLS:     server.TLS,
+			MTLS:    server.MTLS,
+			CA:      []byte(server.CA),
+			Cert:    []byte(server.Cert),
+		}
+		if server.EncryptedKey != "" {
+			if !s.encryptsSecrets() {
+				resp.Failed[server.Name] = "private key is encrypted, and the manager has no encryption key"
+				continue
+			}
+			if sinfo.Key, err = s.encryption.Decrypt([]byte(server.EncryptedKey)); err != nil {
+				resp.Failed[server.Name] = fmt.Sprintf("Unable to decrypt private key: %v", err)
+				continue
+			}
+		}
+		if sinfo.MTLS && len(sinfo.Key) == 0 {
+			resp.Failed[server.Name] = "private key of mTLS missing from the export; register the server with its key"
+			continue
+		}
+		if err := s.db.CreateServerEntry(sinfo); err != nil {
+			resp.Failed[server.Name] = err.Error()
+			continue
+		}
+		resp.Imported = append(resp.Imported, server.Name)
+	}
+	log.Printf("%d servers imported from the registry of %s: %d skipped, %d failed", len(resp.Imported), doc.Manager, len(resp.Skipped), len(resp.Failed))
+	return resp, nil
+}
+
+func (s *Server) serverExport(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: Server Export")
+
+	data, err := s.ExportServers()
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Content-Disposition", `attachment; filename="tornjak-servers.yaml"`)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type,access-control-allow-origin, access-control-allow-headers, traceparent, tracestate, x-request-id, x-tornjak-manager-via")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		log.Printf("WARNING: could not write server registry: %v", err)
+	}
+}
+
+func (s *Server) serverImport(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: Server Import")
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxRegistryDocumentSize+1))
+	if err != nil {
+		emsg := fmt.Sprintf("Error parsing data: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxRegistryDocumentSize {
+		emsg := fmt.Sprintf("Error: registry document larger than %d bytes", maxRegistryDocumentSize)
+		retError(w, emsg, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ret, err := s.ImportServers(data)
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+	cors(w, r)
+
+	je := json.NewEncoder(w)
+	err = je.Encode(ret)
+
+	if err != nil {
+		emsg := fmt.Sprintf("Error: %v", err.Error())
+		retError(w, emsg, http.StatusBadRequest)
+		return
+	}
+}
@@ -11,7 +11,10 @@ import (
 	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/spiffe/tornjak/pkg/encryption"
+	"github.com/spiffe/tornjak/pkg/manager/aggregation"
 	managerdb "github.com/spiffe/tornjak/pkg/manager/db"
+	"github.com/spiffe/tornjak/pkg/manager/registry"
 )
 
 const (
@@ -19,9 +22,25 @@ const (
 	certShowLen int = 50
 )
 
+// traceHeaders lists the incoming request headers forwarded to Tornjak backends
+var traceHeaders = []string{"traceparent", "tracestate", "x-request-id"}
+
 type Server struct {
 	listenAddr string
 	db         managerdb.ManagerDB
+
+	// id of this manager in federated requests, and tokens peers must present
+	id               string
+	federationTokens []string
+
+	// last results of the servers and peers queried by fan-out calls
+	aggregates *aggregation.Cache
+
+	// encrypts stored secrets, and the private keys of exported servers
+	encryption encryption.Provider
+	// signs exported server registries and verifies imported ones, nil if
+	// no signing key is configured
+	registrySigner *registry.Signer
 }
 
 // Handle preflight checks
@@ -39,14 +58,14 @@ func corsHandler(f func(w http.ResponseWriter, r *http.Request)) http.HandlerFun
 func cors(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=ascii")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type,access-control-allow-origin, access-control-allow-headers")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type,access-control-allow-origin, access-control-allow-headers, traceparent, tracestate, x-request-id, x-tornjak-manager-via")
 	w.WriteHeader(http.StatusOK)
 }
 
 func retError(w http.ResponseWriter, emsg string, status int) {
 	w.Header().Set("Content-Type", "text/html; charset=ascii")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type,access-control-allow-origin, access-control-allow-headers")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type,access-control-allow-origin, access-control-allow-headers, traceparent, tracestate, x-request-id, x-tornjak-manager-via")
 	http.Error(w, emsg, status)
 }
 
@@ -114,8 +133,12 @@ func (s *Server) apiServerProxyFunc(apiPath string, apiMethod string) func(w htt
 			retError(w, emsg, http.StatusBadRequest)
 			return
 		}
+		for _, h := range traceHeaders {
+			if v := r.Header.Get(h); v != "" {
+				req.Header.Set(h, v)
+			}
+		}
 
-		
 		resp, err := client.Do(req)
 		if err != nil {
 			emsg := fmt.Sprintf("Error making api call to server: %v", err.Error())
@@ -183,6 +206,17 @@ func (s *Server) HandleRequests() {
 	// Manger-specific
 	rtr.HandleFunc("/manager-api/server/list", corsHandler(s.serverList))
 	rtr.HandleFunc("/manager-api/server/register", corsHandler(s.serverRegister))
+	rtr.HandleFunc("/manager-api/server/export", corsHandler(s.serverExport))
+	rtr.HandleFunc("/manager-api/server/import", corsHandler(s.serverImport))
+
+	// Federation with peer managers
+	rtr.HandleFunc("/manager-api/peer/list", corsHandler(s.peerList))
+	rtr.HandleFunc("/manager-api/peer/register", corsHandler(s.peerRegister))
+	rtr.HandleFunc("/manager-api/federation/server/list", corsHandler(s.federatedServerList))
+	rtr.PathPrefix("/manager-api/peers/proxy/{peer}/").Handler(corsHandler(s.peerProxy))
+
+	// Search of agents across registered servers
+	rtr.HandleFunc("/manager-api/agents/search", corsHandler(s.agentSearch))
 
 	// SPIRE server info calls
 	rtr.HandleFunc("/manager-api/healthcheck/{server:.*}", corsHandler(s.apiServerProxyFunc("/api/v1/spire/healthcheck", http.MethodGet)))
@@ -232,15 +266,22 @@ func main() {
 */
 
 // NewManagerServer returns a new manager server, given a listening address for the
-// server, and a DB connection string
-func NewManagerServer(listenAddr, dbString string) (*Server, error) {
-	db, err := managerdb.NewLocalSqliteDB(dbString)
+// server, a DB connection string and the provider encrypting stored server credentials
+func NewManagerServer(listenAddr, dbString string, provider encryption.Provider) (*Server, error) {
+	db, err := managerdb.NewLocalSqliteDBWithEncryption(dbString, provider)
 	if err != nil {
 		return nil, err
 	}
+	// migrate keys stored before encryption was enabled or under a rotated key
+	if err = db.ReencryptSecrets(); err != nil {
+		return nil, err
+	}
 	return &Server{
 		listenAddr: listenAddr,
 		db:         db,
+		id:         defaultManagerID(listenAddr),
+		aggregates: aggregation.New(DefaultAggregationTTL, DefaultAggregationMaxStale, nil),
+		encryption: provider,
 	}, nil
 }
 
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+
+	agentapi "github.com/spiffe/tornjak/api/agent"
+	"github.com/spiffe/tornjak/pkg/agent/anonymize"
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/reconciler"
+	tornjakTypes "github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// timeout of the SPIRE healthcheck run by doctor
+const doctorSPIRETimeout = 5 * time.Second
+
+// loadServer parses the SPIRE and Tornjak configs into an unconfigured server
+func loadServer(opt cliOptions) (*agentapi.Server, error) {
+	serverInfo := agentapi.TornjakSpireServerInfo{}
+	if spireFile := opt.genericOptions.spireFile; spireFile != "" { // SPIRE config given
+		configData, err := getConfigString(spireFile, false)
+		if err != nil {
+			return nil, fail(exitConfig, errors.Errorf("Could not find given SPIRE Config file: %v", err))
+		}
+		serverInfo, err = GetServerInfo(configData)
+		if err != nil {
+			return nil, fail(exitConfig, err)
+		}
+	}
+
+	tornjakConfigs, err := parseTornjakConfig(opt.genericOptions.tornjakFile, opt.genericOptions.expandEnv)
+	if err != nil {
+		return nil, fail(exitConfig, errors.Errorf("Unable to parse the tornjak config file provided %v", err))
+	}
+
+	return &agentapi.Server{
+		SpireServerInfo: serverInfo,
+		TornjakConfig:   tornjakConfigs,
+	}, nil
+}
+
+// configureServer parses the configs and configures the server, which
+// creates or upgrades the DataStore schema
+func configureServer(opt cliOptions) (*agentapi.Server, error) {
+	s, err := loadServer(opt)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Configure(); err != nil {
+		return nil, fail(exitConfig, err)
+	}
+	return s, nil
+}
+
+func runServe(c *cli.Context, opt cliOptions) error {
+	s, err := loadServer(opt)
+	if err == nil {
+		err = s.VerifyConfiguration()
+		if err != nil {
+			err = fail(exitConfig, errors.Errorf("Tornjak Config error: %v", err))
+		}
+	}
+	if err != nil {
+		return finish(c, nil, "", err)
+	}
+	s.HandleRequests()
+	return nil
+}
+
+type serverInfoResult struct {
+	SpireServerInfo agentapi.TornjakSpireServerInfo `json:"spireServerInfo"`
+	TornjakConfig   string                          `json:"tornjakConfig"`
+}
+
+func runServerInfo(c *cli.Context, opt cliOptions) error {
+	s, err := loadServer(opt)
+	if err != nil {
+		return finish(c, nil, "", err)
+	}
+	tornjakInfo, err := getConfigString(opt.genericOptions.tornjakFile, opt.genericOptions.expandEnv)
+	if err != nil {
+		return finish(c, nil, "", fail(exitConfig, err))
+	}
+
+	text := ""
+	if s.SpireServerInfo.TrustDomain == "" {
+		text = "No SPIRE config provided to Tornjak\n"
+	} else {
+		text = fmt.Sprintln(s.SpireServerInfo)
+	}
+	text += tornjakInfo
+	result := serverInfoResult{
+		SpireServerInfo: s.SpireServerInfo,
+		TornjakConfig:   tornjakInfo,
+	}
+	return finish(c, result, text, nil)
+}
+
+type migrateResult struct {
+	Message string `json:"message"`
+	// versions of the schema, omitted if the DataStore has no versioned schema
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+	LatestVersion int `json:"latestVersion,omitempty"`
+}
+
+// runMigrate upgrades the DataStore schema, then reverts it to --down-to if set
+func runMigrate(c *cli.Context, opt cliOptions) error {
+	s, err := configureServer(opt)
+	if err != nil {
+		return finish(c, nil, "", err)
+	}
+	migrator, ok := s.Db.(agentdb.Migrator)
+	if !ok {
+		if c.IsSet("down-to") {
+			return finish(c, nil, "", errors.New("DataStore does not support schema versions"))
+		}
+		msg := "DataStore schema is up to date"
+		return finish(c, migrateResult{Message: msg}, msg, nil)
+	}
+	if c.IsSet("down-to") {
+		if err := migrator.MigrateDown(c.Int("down-to")); err != nil {
+			return finish(c, nil, "", err)
+		}
+	}
+	current, latest, err := migrator.SchemaVersion()
+	if err != nil {
+		return finish(c, nil, "", err)
+	}
+	msg := fmt.Sprintf("DataStore schema is up to date at version %d", current)
+	if current != latest {
+		msg = fmt.Sprintf("DataStore schema is at version %d, the latest version is %d", current, latest)
+	}
+	return finish(c, migrateResult{Message: msg, SchemaVersion: current, LatestVersion: latest}, msg, nil)
+}
+
+type backupResult struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+func runBackup(c *cli.Context, opt cliOptions) error {
+	s, err := configureServer(opt)
+	if err != nil {
+		return finish(c, nil, "", err)
+	}
+	backupper, ok := s.Db.(agentdb.Backupper)
+	if !ok {
+		return finish(c, nil, "", errors.New("DataStore does not support backups"))
+	}
+	path := c.String("out")
+	if err := backupper.Backup(path); err != nil {
+		return finish(c, nil, "", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return finish(c, nil, "", errors.Errorf("could not read backup: %v", err))
+	}
+	result := backupResult{Path: path, Bytes: info.Size()}
+	return finish(c, result, fmt.Sprintf("Backup written to %s (%d bytes)", path, info.Size()), nil)
+}
+
+type exportResult struct {
+	Path       string `json:"path"`
+	Clusters   int    `json:"clusters"`
+	Anonymized bool   `json:"anonymized"`
+}
+
+// runExport writes the clusters of the DataStore as a desired-state document,
+// which seed applies to another DataStore
+func runExport(c *cli.Context, opt cliOptions) error {
+	path := c.String("out")
+	if _, err := os.Stat(path); err == nil {
+		return finish(c, nil, "", fail(exitUsage, errors.Errorf("export file %s already exists", path)))
+	}
+	s, err := configureServer(opt)
+	if err != nil {
+		return finish(c, nil, "", err)
+	}
+	clusters, err := s.Db.GetClusters()
+	if err != nil {
+		return finish(c, nil, "", err)
+	}
+
+	state := tornjakTypes.DesiredState{Clusters: clusters.Clusters}
+	if c.Bool("anonymize") {
+		// without a key, pseudonyms are consistent within this export only
+		key := []byte(c.String("anonymize-key"))
+		if len(key) == 0 {
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return finish(c, nil, "", errors.Errorf("could not generate anonymization key: %v", err))
+			}
+		}
+		anonymizer := anonymize.New(key)
+		for i, cluster := range state.Clusters {
+			state.Clusters[i] = anonymizer.Cluster(cluster)
+		}
+	}
+	data, err := reconciler.RenderDesiredState(state)
+	if err != nil {
+		return finish(c, nil, "", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return finish(c, nil, "", errors.Errorf("could not write export: %v", err))
+	}
+
+	result := exportResult{Path: path, Clusters: len(state.Clusters), Anonymized: c.Bool("anonymize")}
+	return finish(c, result, fmt.Sprintf("Exported %d clusters to %s", len(state.Clusters), path), nil)
+}
+
+type doctorCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type doctorResult struct {
+	Checks []doctorCheck `json:"checks"`
+}
+
+// runDoctor runs each check once the check it depends on passed; the
+// others are reported as failed without running them
+func runDoctor(c *cli.Context, opt cliOptions) error {
+	var s *agentapi.Server
+	checks := []struct {
+		name  string
+		needs string
+		run   func() error
+	}{
+		{"config", "", func() (err error) {
+			s, err = loadServer(opt)
+			return err
+		}},
+		{"config-verify", "config", func() error {
+			return s.VerifyConfiguration()
+		}},
+		{"configure", "config-verify", func() error {
+			return s.Configure()
+		}},
+		{"datastore", "configure", func() error {
+			_, err := s.Db.GetClusters()
+			return err
+		}},
+		{"spire", "configure", func() error {
+			// nothing to reach without a SPIRE connection
+			if s.MetadataOnly() {
+				return nil
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), doctorSPIRETimeout)
+			defer cancel()
+			_, err := s.SPIREHealthcheck(ctx, agentapi.HealthcheckRequest{})
+			return err
+		}},
+	}
+
+	result := doctorResult{}
+	passed := map[string]bool{"": true}
+	var failed []string
+	var text strings.Builder
+	for _, check := range checks {
+		err := errors.Errorf("skipped, check %s failed", check.needs)
+		if passed[check.needs] {
+			err = check.run()
+		}
+		res := doctorCheck{Name: check.name, OK: err == nil}
+		if err != nil {
+			res.Error = err.Error()
+			failed = append(failed, check.name)
+			fmt.Fprintf(&text, "FAIL %s: %v\n", check.name, err)
+		} else {
+			passed[check.name] = true
+			fmt.Fprintf(&text, "OK   %s\n", check.name)
+		}
+		result.Checks = append(result.Checks, res)
+	}
+
+	if len(failed) > 0 {
+		err := fail(exitCheckFailed, errors.Errorf("failed checks: %s", strings.Join(failed, ", ")))
+		return finish(c, result, strings.TrimSuffix(text.String(), "\n"), err)
+	}
+	return finish(c, result, strings.TrimSuffix(text.String(), "\n"), nil)
+}
+
+func runSeed(c *cli.Context, opt cliOptions) error {
+	s, err := configureServer(opt)
+	if err != nil {
+		return finish(c, nil, "", err)
+	}
+	source := reconciler.NewFileSource(c.String("file"))
+	report := s.ReconcileDocument(context.Background(), source, c.Bool("dry-run"))
+
+	var text strings.Builder
+	for _, drift := range report.Drift {
+		status := "planned"
+		if drift.Applied {
+			status = "applied"
+		}
+		fmt.Fprintf(&text, "%s cluster %s (%s)\n", drift.Action, drift.Cluster, status)
+	}
+	if report.InSync {
+		text.WriteString("Clusters are in sync with the desired state\n")
+	}
+	for _, e := range report.Errors {
+		fmt.Fprintf(&text, "Error: %s\n", e)
+	}
+	if len(report.Errors) > 0 {
+		err = fail(exitCheckFailed, errors.Errorf("%d errors seeding clusters", len(report.Errors)))
+	}
+	return finish(c, report, strings.TrimSuffix(text.String(), "\n"), err)
+}
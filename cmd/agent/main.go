@@ -47,74 +47,113 @@ func main() {
 				Required:    false,
 			},
 		},
+		Action: func(c *cli.Context) error {
+			if c.Args().Present() {
+				return cli.Exit(fmt.Sprintf("Error: unknown command %q", c.Args().First()), exitUsage)
+			}
+			return cli.ShowAppHelp(c)
+		},
 		Commands: []*cli.Command{
-			{
-				Name:  "http",
-				Usage: "Run the tornjak http server",
+			withOutputFlags(&cli.Command{
+				Name:    "serve",
+				Aliases: []string{"http"},
+				Usage:   "Run the tornjak http server",
 				Action: func(c *cli.Context) error {
-					return runTornjakCmd("http", opt)
+					return runServe(c, opt)
 				},
-			},
-			{
+			}),
+			withOutputFlags(&cli.Command{
 				Name:  "serverinfo",
 				Usage: "Get the serverinfo of the SPIRE server where tornjak resides",
 				Action: func(c *cli.Context) error {
-					return runTornjakCmd("serverinfo", opt)
+					return runServerInfo(c, opt)
 				},
-			},
+			}),
+			withOutputFlags(&cli.Command{
+				Name:  "migrate",
+				Usage: "Create or upgrade the DataStore schema and exit",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "down-to",
+						Usage: "Then revert the schema to this version, for an older Tornjak",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return runMigrate(c, opt)
+				},
+			}),
+			withOutputFlags(&cli.Command{
+				Name:  "backup",
+				Usage: "Write a consistent copy of the DataStore to a new file",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "out",
+						Usage:    "Path of the backup file, must not exist",
+						Required: true,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return runBackup(c, opt)
+				},
+			}),
+			withOutputFlags(&cli.Command{
+				Name:  "export",
+				Usage: "Write the clusters of the DataStore as a desired-state document",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "out",
+						Usage:    "Path of the document, must not exist",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "anonymize",
+						Usage: "Replace SPIFFE IDs, cluster names and owner fields with pseudonyms",
+					},
+					&cli.StringFlag{
+						Name:    "anonymize-key",
+						Usage:   "Key of the pseudonyms, so exports with the same key use the same pseudonyms; random if omitted",
+						EnvVars: []string{"TORNJAK_ANONYMIZE_KEY"},
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return runExport(c, opt)
+				},
+			}),
+			withOutputFlags(&cli.Command{
+				Name:  "doctor",
+				Usage: "Check the configuration, DataStore and SPIRE connection",
+				Action: func(c *cli.Context) error {
+					return runDoctor(c, opt)
+				},
+			}),
+			withOutputFlags(&cli.Command{
+				Name:  "seed",
+				Usage: "Apply a desired-state document of clusters to the DataStore once",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "file",
+						Usage:    "Path of the desired-state document",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Report the changes without applying them",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return runSeed(c, opt)
+				},
+			}),
 		},
 	}
 
+	// commands exit through cli.Exit with their own codes, so remaining
+	// errors come from parsing the command line
 	err := app.Run(os.Args)
 	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func runTornjakCmd(cmd string, opt cliOptions) error {
-	// parse configs
-	spire_config_file := opt.genericOptions.spireFile
-	var serverInfo = agentapi.TornjakSpireServerInfo{}
-	if spire_config_file != "" { // SPIRE config given
-		configData, err := getConfigString(spire_config_file, false)
-		if err != nil {
-			return errors.Errorf("Could not find given SPIRE Config file: %v", err)
-		}
-		serverInfo, err = GetServerInfo(configData)
-		if err != nil {
-			log.Fatalf("Error: %v", err)
-		}
-	}
-
-	tornjakConfigs, err := parseTornjakConfig(opt.genericOptions.tornjakFile, opt.genericOptions.expandEnv)
-	if err != nil {
-		return errors.Errorf("Unable to parse the tornjak config file provided %v", err)
-	}
-
-	switch cmd {
-	case "serverinfo":
-		if serverInfo.TrustDomain == "" {
-			fmt.Println("No SPIRE config provided to Tornjak")
-		} else {
-			fmt.Println(serverInfo)
-		}
-		tornjakInfo, err := getConfigString(opt.genericOptions.tornjakFile, opt.genericOptions.expandEnv)
-		if err != nil {
-			log.Fatalf("Error: %v", err)
-		}
-		fmt.Println(tornjakInfo)
-	case "http":
-
-		apiServer := &agentapi.Server{
-			SpireServerInfo: serverInfo,
-			TornjakConfig:   tornjakConfigs,
-		}
-		apiServer.HandleRequests()
-	default:
-		return errors.New("Unrecognized command from helper func")
+		log.Print(err)
+		os.Exit(exitUsage)
 	}
-	return nil
-
 }
 
 func GetServerInfo(configData string) (agentapi.TornjakSpireServerInfo, error) {
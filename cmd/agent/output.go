@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+// Exit codes of tornjak-backend commands
+const (
+	// command succeeded
+	exitOK = 0
+	// command failed at runtime, e.g. the DB could not be written
+	exitFailure = 1
+	// invalid command line, e.g. a missing flag
+	exitUsage = 2
+	// the Tornjak or SPIRE config could not be read or is invalid
+	exitConfig = 3
+	// command ran, but found problems, e.g. failed doctor checks
+	exitCheckFailed = 4
+)
+
+// jsonFlag is accepted by every command to write its result as JSON to stdout
+var jsonFlag = &cli.BoolFlag{
+	Name:  "json",
+	Usage: "Write the command result as JSON to stdout",
+}
+
+// resultWriter receives JSON results; it stays stdout when os.Stdout is
+// redirected to keep plugin messages out of the JSON output
+var resultWriter io.Writer = os.Stdout
+
+// withOutputFlags adds the json flag to the command and, if it is set,
+// redirects other output on stdout to stderr
+func withOutputFlags(cmd *cli.Command) *cli.Command {
+	cmd.Flags = append(cmd.Flags, jsonFlag)
+	cmd.Before = func(c *cli.Context) error {
+		if c.Bool(jsonFlag.Name) {
+			os.Stdout = os.Stderr
+		}
+		return nil
+	}
+	return cmd
+}
+
+// commandResult is the JSON output of a command
+type commandResult struct {
+	Command  string      `json:"command"`
+	OK       bool        `json:"ok"`
+	ExitCode int         `json:"exitCode"`
+	Error    string      `json:"error,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+}
+
+// commandError is an error that ends a command with an exit code
+type commandError struct {
+	code int
+	err  error
+}
+
+func (e commandError) Error() string {
+	return e.err.Error()
+}
+
+// fail returns an error ending the command with the exit code
+func fail(code int, err error) error {
+	return commandError{code: code, err: err}
+}
+
+// finish writes the outcome of a command and returns the error making the
+// cli exit with the matching code
+// in text mode, text is printed and result is ignored
+func finish(c *cli.Context, result interface{}, text string, err error) error {
+	code := exitOK
+	if err != nil {
+		code = exitFailure
+		if cerr, ok := err.(commandError); ok {
+			code = cerr.code
+		}
+	}
+
+	if c.Bool(jsonFlag.Name) {
+		out := commandResult{
+			Command:  c.Command.Name,
+			OK:       code == exitOK,
+			ExitCode: code,
+			Result:   result,
+		}
+		if err != nil {
+			out.Error = err.Error()
+		}
+		je := json.NewEncoder(resultWriter)
+		je.SetIndent("", "  ")
+		if encErr := je.Encode(out); encErr != nil {
+			return cli.Exit(fmt.Sprintf("Error: %v", encErr), exitFailure)
+		}
+		if code != exitOK {
+			// already reported on stdout
+			return cli.Exit("", code)
+		}
+		return nil
+	}
+
+	if text != "" {
+		fmt.Println(text)
+	}
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Error: %v", err), code)
+	}
+	return nil
+}
@@ -1,19 +1,130 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	managerapi "github.com/spiffe/tornjak/api/manager"
+	"github.com/spiffe/tornjak/pkg/encryption"
 )
 
+// newEncryptionProvider returns the provider for stored server credentials
+// TORNJAK_MANAGER_ENCRYPTION_KEY_FILE names the current key file, and
+// TORNJAK_MANAGER_ENCRYPTION_OLD_KEY_FILES a comma-separated list of rotated
+// key files still needed for decryption; keys are identified by file name
+func newEncryptionProvider() (encryption.Provider, error) {
+	primaryFile := os.Getenv("TORNJAK_MANAGER_ENCRYPTION_KEY_FILE")
+	if primaryFile == "" {
+		return encryption.NewNullProvider(), nil
+	}
+	files := []string{primaryFile}
+	if oldFiles := os.Getenv("TORNJAK_MANAGER_ENCRYPTION_OLD_KEY_FILES"); oldFiles != "" {
+		files = append(files, strings.Split(oldFiles, ",")...)
+	}
+	keys := make(map[string][]byte)
+	for _, file := range files {
+		key, err := encryption.ReadKeyFile(file)
+		if err != nil {
+			return nil, err
+		}
+		keys[filepath.Base(file)] = key
+	}
+	return encryption.NewLocalAESProvider(filepath.Base(primaryFile), keys)
+}
+
+// readFederationTokens returns the bearer tokens peers must present on federated
+// requests, one per line of the file named by TORNJAK_MANAGER_FEDERATION_TOKENS_FILE
+func readFederationTokens() ([]string, error) {
+	path := os.Getenv("TORNJAK_MANAGER_FEDERATION_TOKENS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tokens := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if token := strings.TrimSpace(line); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// readRegistryKey returns the key signing exported server registries and
+// verifying imported ones, read from the key file named by
+// TORNJAK_MANAGER_REGISTRY_KEY_FILE and identified by its file name
+// returns an empty id if the variable is not set
+func readRegistryKey() (string, []byte, error) {
+	path := os.Getenv("TORNJAK_MANAGER_REGISTRY_KEY_FILE")
+	if path == "" {
+		return "", nil, nil
+	}
+	key, err := encryption.ReadKeyFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return filepath.Base(path), key, nil
+}
+
+// readAggregationCacheConfig returns how long the results of servers and peers
+// are cached, TORNJAK_MANAGER_CACHE_TTL, and served stale while they cannot be
+// queried, TORNJAK_MANAGER_CACHE_MAX_STALE, both Go durations such as 30s
+func readAggregationCacheConfig() (time.Duration, time.Duration, error) {
+	ttl, maxStale := managerapi.DefaultAggregationTTL, managerapi.DefaultAggregationMaxStale
+	for _, v := range []struct {
+		name string
+		d    *time.Duration
+	}{{"TORNJAK_MANAGER_CACHE_TTL", &ttl}, {"TORNJAK_MANAGER_CACHE_MAX_STALE", &maxStale}} {
+		value := os.Getenv(v.name)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return 0, 0, fmt.Errorf("invalid %s %q", v.name, value)
+		}
+		*v.d = d
+	}
+	return ttl, maxStale, nil
+}
+
 func main() {
 	var (
 		dbString   = "./serverlocaldb"
 		listenAddr = ":50000"
 	)
-	s, err := managerapi.NewManagerServer(listenAddr, dbString)
+	provider, err := newEncryptionProvider()
+	if err != nil {
+		log.Fatalf("err: %v", err)
+	}
+	s, err := managerapi.NewManagerServer(listenAddr, dbString, provider)
+	if err != nil {
+		log.Fatalf("err: %v", err)
+	}
+	tokens, err := readFederationTokens()
+	if err != nil {
+		log.Fatalf("err: %v", err)
+	}
+	s.ConfigureFederation(os.Getenv("TORNJAK_MANAGER_ID"), tokens)
+	ttl, maxStale, err := readAggregationCacheConfig()
 	if err != nil {
 		log.Fatalf("err: %v", err)
 	}
+	s.ConfigureAggregationCache(ttl, maxStale)
+	keyID, key, err := readRegistryKey()
+	if err != nil {
+		log.Fatalf("err: %v", err)
+	}
+	if keyID != "" {
+		if err = s.ConfigureRegistrySigning(keyID, key); err != nil {
+			log.Fatalf("err: %v", err)
+		}
+	}
 	s.HandleRequests()
 }
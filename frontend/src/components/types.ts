@@ -32,6 +32,8 @@ export interface AgentsWorkLoadAttestorInfo {
   spiffeid: string; // SPIFFEE ID of agent
   plugin: string; // Workload attestor plugin selected for agent
   cluster: string; // Cluster agent is associated with
+  creationTime: string; // Time agent was first stored, RFC3339 in UTC
+  updatedAt: string; // Time agent was last changed, RFC3339 in UTC
 }
 
 
@@ -39,7 +41,8 @@ export interface AgentsWorkLoadAttestorInfo {
 export interface ClustersList {
   name: string; // Name of Cluster
   editedName: string; // Edited Name if Cluster Name is edited from original
-  creationTime: string; // Time cluster is created
+  creationTime: string; // Time cluster is created, RFC3339 in UTC
+  updatedAt: string; // Time cluster was last changed, RFC3339 in UTC
   domainName: string; // Domain Name of cluster if any
   managedBy: string; // Person/ entity managing the cluster
   platformType: string; // Platform type of the cluster
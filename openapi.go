@@ -0,0 +1,11 @@
+// Package tornjak exposes assets shared by the Tornjak binaries
+package tornjak
+
+import (
+	_ "embed"
+)
+
+// OpenAPISpec is the OpenAPI specification of the Tornjak agent API
+//
+//go:embed openapi.yaml
+var OpenAPISpec []byte
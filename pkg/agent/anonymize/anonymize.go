@@ -0,0 +1,85 @@
+package anonymize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// length of the hex-encoded hash in pseudonyms
+const hashLength = 12
+
+// Anonymizer replaces identifying values with pseudonyms derived from a keyed
+// hash, so the same value gets the same pseudonym wherever it appears and in
+// every export made with the same key
+type Anonymizer struct {
+	key []byte
+}
+
+func New(key []byte) *Anonymizer {
+	return &Anonymizer{key: key}
+}
+
+func (a *Anonymizer) hash(kind, value string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(kind + "\x00" + value))
+	return hex.EncodeToString(mac.Sum(nil))[:hashLength]
+}
+
+// pseudonym returns prefix-<hash> for a non-empty value
+func (a *Anonymizer) pseudonym(prefix, value string) string {
+	if value == "" {
+		return ""
+	}
+	return prefix + "-" + a.hash(prefix, value)
+}
+
+// SPIFFEID pseudonymizes the trust domain and each path segment of a SPIFFE ID
+// the /spire/agent/<attestor> prefix of agent IDs is kept, as it names SPIRE
+// plugins rather than internal topology
+func (a *Anonymizer) SPIFFEID(id string) string {
+	rest, ok := strings.CutPrefix(id, "spiffe://")
+	if !ok {
+		return a.pseudonym("id", id)
+	}
+	segments := strings.Split(rest, "/")
+	segments[0] = a.pseudonym("td", segments[0]) + ".test"
+	keep := 1
+	if len(segments) > 3 && segments[1] == "spire" && segments[2] == "agent" {
+		keep = 4
+	}
+	for i := keep; i < len(segments); i++ {
+		if segments[i] != "" {
+			segments[i] = a.hash("path", segments[i])
+		}
+	}
+	return "spiffe://" + strings.Join(segments, "/")
+}
+
+// Cluster returns the cluster with its name, domain, owner fields and agent
+// SPIFFE IDs pseudonymized; platform type, labels and extensions are kept
+func (a *Anonymizer) Cluster(cluster types.ClusterInfo) types.ClusterInfo {
+	cluster.Name = a.pseudonym("cluster", cluster.Name)
+	cluster.EditedName = a.pseudonym("cluster", cluster.EditedName)
+	if cluster.DomainName != "" {
+		cluster.DomainName = a.pseudonym("domain", cluster.DomainName) + ".test"
+	}
+	cluster.ManagedBy = a.pseudonym("managed", cluster.ManagedBy)
+	if cluster.OwnerEmail != "" {
+		cluster.OwnerEmail = a.pseudonym("owner", cluster.OwnerEmail) + "@example.com"
+	}
+	cluster.OwnerTeam = a.pseudonym("team", cluster.OwnerTeam)
+	if cluster.SlackChannel != "" {
+		cluster.SlackChannel = "#" + a.pseudonym("channel", cluster.SlackChannel)
+	}
+	cluster.Tenant = a.pseudonym("tenant", cluster.Tenant)
+	agents := make([]string, len(cluster.AgentsList))
+	for i, id := range cluster.AgentsList {
+		agents[i] = a.SPIFFEID(id)
+	}
+	cluster.AgentsList = agents
+	return cluster
+}
@@ -0,0 +1,64 @@
+package anonymize
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// TestSPIFFEID checks pseudonyms are consistent per key and keep the shape of SPIFFE IDs
+func TestSPIFFEID(t *testing.T) {
+	a := New([]byte("key"))
+	id := "spiffe://example.org/spire/agent/k8s_psat/prod-east/node-1"
+	pseudonym := a.SPIFFEID(id)
+	if pseudonym != a.SPIFFEID(id) || pseudonym == New([]byte("other")).SPIFFEID(id) {
+		t.Fatalf("Expected pseudonyms to be consistent per key, got %s", pseudonym)
+	}
+	if strings.Contains(pseudonym, "example.org") || strings.Contains(pseudonym, "prod-east") ||
+		!strings.HasPrefix(pseudonym, "spiffe://td-") || !strings.Contains(pseudonym, ".test/spire/agent/k8s_psat/") {
+		t.Fatalf("Expected pseudonymized trust domain and path with attestor kept, got %s", pseudonym)
+	}
+	if got := len(strings.Split(pseudonym, "/")); got != len(strings.Split(id, "/")) {
+		t.Fatalf("Expected path depth to be kept, got %s", pseudonym)
+	}
+
+	// shared segments map to the same pseudonym
+	sibling := a.SPIFFEID("spiffe://example.org/spire/agent/k8s_psat/prod-east/node-2")
+	if strings.Split(sibling, "/")[6] != strings.Split(pseudonym, "/")[6] {
+		t.Fatalf("Expected common segments to share pseudonyms, got %s and %s", pseudonym, sibling)
+	}
+}
+
+// TestCluster checks identifying cluster fields are pseudonymized and others kept
+func TestCluster(t *testing.T) {
+	a := New([]byte("key"))
+	cluster := types.ClusterInfo{
+		Name:         "prod-east",
+		DomainName:   "east.corp.example.org",
+		PlatformType: "Kubernetes",
+		OwnerEmail:   "platform@example.org",
+		OwnerTeam:    "platform",
+		SlackChannel: "#platform-alerts",
+		AgentsList:   []string{"spiffe://example.org/agent/node-1"},
+		Labels:       map[string]string{"env": "prod"},
+	}
+	got := a.Cluster(cluster)
+	for _, value := range []string{got.Name, got.DomainName, got.OwnerEmail, got.OwnerTeam, got.SlackChannel, got.AgentsList[0]} {
+		if strings.Contains(value, "prod-east") || strings.Contains(value, "platform") || strings.Contains(value, "example.org") {
+			t.Fatalf("Expected identifying fields to be pseudonymized, got %+v", got)
+		}
+	}
+	if got.PlatformType != "Kubernetes" || got.Labels["env"] != "prod" || got.Tenant != "" || got.ManagedBy != "" {
+		t.Fatalf("Expected other and empty fields to be kept, got %+v", got)
+	}
+	if !strings.HasSuffix(got.OwnerEmail, "@example.com") || !strings.HasPrefix(got.SlackChannel, "#channel-") {
+		t.Fatalf("Expected pseudonyms to keep the field formats, got %+v", got)
+	}
+	if err := got.ValidateContacts(); err != nil {
+		t.Fatalf("Expected pseudonymized cluster to be valid: %v", err)
+	}
+	if cluster.AgentsList[0] != "spiffe://example.org/agent/node-1" {
+		t.Fatal("Expected input cluster to be unchanged")
+	}
+}
@@ -0,0 +1,67 @@
+package authenticator
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/authentication/user"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+	"github.com/spiffe/tornjak/pkg/encryption"
+)
+
+// ClusterTokenAPIKeyPrefix prefixes the API keys of cluster tokens, which are
+// sent in the same header as service account API keys
+const ClusterTokenAPIKeyPrefix = "tjc_"
+
+// ClusterTokenUserPrefix prefixes the username of requests made with cluster tokens
+const ClusterTokenUserPrefix = "clustertoken:"
+
+// ClusterTokenStore looks up cluster tokens by the hash of their API key
+type ClusterTokenStore interface {
+	GetClusterTokenByKeyHash(keyHash string) (types.ClusterToken, error)
+}
+
+// ClusterTokenAuthenticator authenticates requests carrying a cluster token API key
+// as users restricted to the cluster of the token, and passes all other
+// requests to the next authenticator
+// API keys are stored under the hash of hasher, see encryption.Hasher
+type ClusterTokenAuthenticator struct {
+	store  ClusterTokenStore
+	hasher *encryption.Hasher
+	next   Authenticator
+}
+
+func NewClusterTokenAuthenticator(store ClusterTokenStore, hasher *encryption.Hasher, next Authenticator) *ClusterTokenAuthenticator {
+	return &ClusterTokenAuthenticator{
+		store:  store,
+		hasher: hasher,
+		next:   next,
+	}
+}
+
+func (a *ClusterTokenAuthenticator) AuthenticateRequest(r *http.Request) *user.UserInfo {
+	apiKey := r.Header.Get(APIKeyHeader)
+	if !strings.HasPrefix(apiKey, ClusterTokenAPIKeyPrefix) {
+		return a.next.AuthenticateRequest(r)
+	}
+
+	// keys created before the pepper was configured are stored under their SHA-256 hash
+	for _, keyHash := range a.hasher.Hashes(apiKey) {
+		token, err := a.store.GetClusterTokenByKeyHash(keyHash)
+		if err != nil {
+			continue
+		}
+		return &user.UserInfo{
+			Username: ClusterTokenUserPrefix + token.Name,
+			ClusterScope: &user.ClusterScope{
+				ClusterUID: token.ClusterUID,
+				Write:      token.Access == types.ClusterTokenWrite,
+			},
+		}
+	}
+	return &user.UserInfo{
+		AuthenticationError: errors.New("invalid cluster token API key"),
+	}
+}
@@ -21,7 +21,8 @@ type RealmAccessSubclaim struct {
 }
 
 type KeycloakClaim struct {
-	RealmAccess RealmAccessSubclaim `json:"realm_access"`
+	RealmAccess       RealmAccessSubclaim `json:"realm_access"`
+	PreferredUsername string              `json:"preferred_username"`
 	jwt.RegisteredClaims
 }
 
@@ -122,7 +123,13 @@ func (a *KeycloakAuthenticator) AuthenticateRequest(r *http.Request) *user.UserI
 		return wrapAuthenticationError(errors.New("Token invalid"))
 	}
 
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Subject
+	}
+
 	return &user.UserInfo{
-		Roles: claims.RealmAccess.Roles,
+		Username: username,
+		Roles:    claims.RealmAccess.Roles,
 	}
 }
@@ -0,0 +1,61 @@
+package authenticator
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/authentication/user"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+	"github.com/spiffe/tornjak/pkg/encryption"
+)
+
+// APIKeyHeader is the request header carrying a service account API key
+const APIKeyHeader = "X-Tornjak-API-Key"
+
+// ServiceAccountUserPrefix prefixes the username of requests made by service accounts
+const ServiceAccountUserPrefix = "serviceaccount:"
+
+// ServiceAccountStore looks up service accounts by the hash of their API key
+type ServiceAccountStore interface {
+	GetServiceAccountByKeyHash(keyHash string) (types.ServiceAccount, error)
+}
+
+// ServiceAccountAuthenticator authenticates requests carrying a service account API key
+// and passes all other requests to the next authenticator
+// API keys are stored under the hash of hasher, see encryption.Hasher
+type ServiceAccountAuthenticator struct {
+	store  ServiceAccountStore
+	hasher *encryption.Hasher
+	next   Authenticator
+}
+
+func NewServiceAccountAuthenticator(store ServiceAccountStore, hasher *encryption.Hasher, next Authenticator) *ServiceAccountAuthenticator {
+	return &ServiceAccountAuthenticator{
+		store:  store,
+		hasher: hasher,
+		next:   next,
+	}
+}
+
+func (a *ServiceAccountAuthenticator) AuthenticateRequest(r *http.Request) *user.UserInfo {
+	apiKey := r.Header.Get(APIKeyHeader)
+	if apiKey == "" {
+		return a.next.AuthenticateRequest(r)
+	}
+
+	// keys created before the pepper was configured are stored under their SHA-256 hash
+	for _, keyHash := range a.hasher.Hashes(apiKey) {
+		account, err := a.store.GetServiceAccountByKeyHash(keyHash)
+		if err != nil {
+			continue
+		}
+		return &user.UserInfo{
+			Username: ServiceAccountUserPrefix + account.Name,
+			Roles:    account.Roles,
+		}
+	}
+	return &user.UserInfo{
+		AuthenticationError: errors.New("invalid service account API key"),
+	}
+}
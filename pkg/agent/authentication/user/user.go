@@ -2,5 +2,15 @@ package user
 
 type UserInfo struct {
 	AuthenticationError error
+	Username            string
 	Roles               []string
+	// restricts the user to a single cluster, nil if not restricted
+	ClusterScope *ClusterScope
+}
+
+// ClusterScope is the cluster a user authenticated with a cluster token is restricted to
+type ClusterScope struct {
+	ClusterUID string
+	// whether the user may edit the cluster, or only read it
+	Write bool
 }
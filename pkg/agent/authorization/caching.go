@@ -0,0 +1,103 @@
+package authorization
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/authentication/user"
+	"github.com/spiffe/tornjak/pkg/agent/cache"
+)
+
+// prefix of the cache keys of authorization decisions
+const decisionKeyPrefix = "authz:"
+
+// cached decisions are allowedDecision or deniedDecisionPrefix followed by the error
+const (
+	allowedDecision      = "allow"
+	deniedDecisionPrefix = "deny:"
+)
+
+// ScopeFunc returns the resource scope of a request, e.g. the cluster it acts on
+// decisions are cached per scope, so policies may decide on it
+type ScopeFunc func(r *http.Request) string
+
+// CachingAuthorizer caches the decisions of another authorizer for a short TTL
+// decisions are keyed by the hash of the user's claims, the route and the
+// resource scope of the request
+type CachingAuthorizer struct {
+	next  Authorizer
+	cache cache.Cache
+	ttl   time.Duration
+	scope ScopeFunc
+}
+
+// NewCachingAuthorizer returns an authorizer caching the decisions of next in c
+// scope may be nil if next decides on the route only
+func NewCachingAuthorizer(next Authorizer, c cache.Cache, ttl time.Duration, scope ScopeFunc) (*CachingAuthorizer, error) {
+	if ttl <= 0 {
+		return nil, errors.New("authorization cache TTL must be positive")
+	}
+	return &CachingAuthorizer{
+		next:  next,
+		cache: c,
+		ttl:   ttl,
+		scope: scope,
+	}, nil
+}
+
+func (a *CachingAuthorizer) AuthorizeRequest(r *http.Request, u *user.UserInfo) error {
+	// authentication failures are not decisions of the policy
+	if u == nil || u.AuthenticationError != nil {
+		return a.next.AuthorizeRequest(r, u)
+	}
+
+	ctx := r.Context()
+	key := a.decisionKey(r, u)
+	// a failing cache falls back to evaluating the policy
+	if value, ok, err := a.cache.Get(ctx, key); err == nil && ok {
+		decision := string(value)
+		if decision == allowedDecision {
+			return nil
+		}
+		if strings.HasPrefix(decision, deniedDecisionPrefix) {
+			return errors.New(strings.TrimPrefix(decision, deniedDecisionPrefix))
+		}
+	}
+
+	err := a.next.AuthorizeRequest(r, u)
+	decision := allowedDecision
+	if err != nil {
+		decision = deniedDecisionPrefix + err.Error()
+	}
+	_ = a.cache.Set(ctx, key, []byte(decision), a.ttl)
+	return err
+}
+
+// Invalidate removes all cached decisions, e.g. after role bindings changed
+func (a *CachingAuthorizer) Invalidate(ctx context.Context) error {
+	return a.cache.DeletePrefix(ctx, decisionKeyPrefix)
+}
+
+func (a *CachingAuthorizer) decisionKey(r *http.Request, u *user.UserInfo) string {
+	scope := ""
+	if a.scope != nil {
+		scope = a.scope(r)
+	}
+	return decisionKeyPrefix + claimsHash(u) + ":" + r.Method + " " + r.URL.Path + ":" + scope
+}
+
+// claimsHash returns the hash of the username and roles of the user
+// the order of roles does not change the hash
+func claimsHash(u *user.UserInfo) string {
+	roles := append([]string(nil), u.Roles...)
+	sort.Strings(roles)
+	sum := sha256.Sum256([]byte(u.Username + "\n" + strings.Join(roles, "\n")))
+	return hex.EncodeToString(sum[:])
+}
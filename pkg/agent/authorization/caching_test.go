@@ -0,0 +1,91 @@
+package authorization
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spiffe/tornjak/pkg/agent/authentication/user"
+	"github.com/spiffe/tornjak/pkg/agent/cache"
+)
+
+// countingAuthorizer allows admins and counts its evaluations
+type countingAuthorizer struct {
+	calls int
+}
+
+func (a *countingAuthorizer) AuthorizeRequest(r *http.Request, u *user.UserInfo) error {
+	a.calls++
+	for _, role := range u.Roles {
+		if role == "admin" {
+			return nil
+		}
+	}
+	return errors.New("Unauthorized Request")
+}
+
+func TestCachingAuthorizer(t *testing.T) {
+	if _, err := NewCachingAuthorizer(&countingAuthorizer{}, cache.NewMemoryCache(), 0, nil); err == nil {
+		t.Fatal("ERROR: successfully initialized authorization cache without TTL")
+	}
+
+	next := &countingAuthorizer{}
+	a, err := NewCachingAuthorizer(next, cache.NewMemoryCache(), time.Minute, func(r *http.Request) string {
+		return r.URL.Query().Get("cluster")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := &user.UserInfo{Username: "alice", Roles: []string{"viewer", "admin"}}
+	viewer := &user.UserInfo{Username: "bob", Roles: []string{"viewer"}}
+	get := httptest.NewRequest(http.MethodGet, "/api/v1/tornjak/clusters?cluster=a", nil)
+
+	// decisions are cached per claims, independent of the order of roles
+	for _, u := range []*user.UserInfo{admin, {Username: "alice", Roles: []string{"admin", "viewer"}}} {
+		if err := a.AuthorizeRequest(get, u); err != nil {
+			t.Fatalf("ERROR: admin denied: %v", err)
+		}
+	}
+	if next.calls != 1 {
+		t.Fatalf("ERROR: expected 1 policy evaluation, got %d", next.calls)
+	}
+
+	// denials are cached with their error
+	for i := 0; i < 2; i++ {
+		if err := a.AuthorizeRequest(get, viewer); err == nil || err.Error() != "Unauthorized Request" {
+			t.Fatalf("ERROR: expected viewer to be denied, got %v", err)
+		}
+	}
+	if next.calls != 2 {
+		t.Fatalf("ERROR: expected 2 policy evaluations, got %d", next.calls)
+	}
+
+	// other methods and scopes are evaluated again
+	post := httptest.NewRequest(http.MethodPost, "/api/v1/tornjak/clusters?cluster=a", nil)
+	otherScope := httptest.NewRequest(http.MethodGet, "/api/v1/tornjak/clusters?cluster=b", nil)
+	_ = a.AuthorizeRequest(post, admin)
+	_ = a.AuthorizeRequest(otherScope, admin)
+	if next.calls != 4 {
+		t.Fatalf("ERROR: expected 4 policy evaluations, got %d", next.calls)
+	}
+
+	// authentication errors are never cached
+	failed := &user.UserInfo{AuthenticationError: errors.New("invalid token")}
+	_ = a.AuthorizeRequest(get, failed)
+	_ = a.AuthorizeRequest(get, failed)
+	if next.calls != 6 {
+		t.Fatalf("ERROR: expected 6 policy evaluations, got %d", next.calls)
+	}
+
+	// invalidated decisions are evaluated again
+	if err := a.Invalidate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.AuthorizeRequest(get, admin)
+	if next.calls != 7 {
+		t.Fatalf("ERROR: expected 7 policy evaluations, got %d", next.calls)
+	}
+}
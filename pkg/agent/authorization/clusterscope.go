@@ -0,0 +1,44 @@
+package authorization
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/authentication/user"
+)
+
+// routes users restricted to a cluster may call, and whether they need write access
+// the APIs further restrict them to the data of their cluster
+var clusterScopedAPIV1List = map[string]map[string]bool{
+	"/api/v1/tornjak/clusters":         {http.MethodGet: false, http.MethodPatch: true},
+	"/api/v1/tornjak/clusters/agents":  {http.MethodGet: false},
+	"/api/v1/tornjak/clusters/history": {http.MethodGet: false},
+	"/api/v1/tornjak/clusters/rename":  {http.MethodPost: true},
+	"/api/v1/tornjak/clusters/search":  {http.MethodGet: false},
+}
+
+// ClusterScopeAuthorizer authorizes users restricted to a cluster by the
+// routes cluster tokens may call, and passes all other users to the next authorizer
+// cluster-scoped users have no roles, so the policy of next never applies to them
+type ClusterScopeAuthorizer struct {
+	next Authorizer
+}
+
+func NewClusterScopeAuthorizer(next Authorizer) *ClusterScopeAuthorizer {
+	return &ClusterScopeAuthorizer{next: next}
+}
+
+func (a *ClusterScopeAuthorizer) AuthorizeRequest(r *http.Request, u *user.UserInfo) error {
+	if u == nil || u.AuthenticationError != nil || u.ClusterScope == nil {
+		return a.next.AuthorizeRequest(r, u)
+	}
+	write, ok := clusterScopedAPIV1List[r.URL.Path][r.Method]
+	if !ok {
+		return errors.Errorf("Tornjak API V1 Authorization error: %s %s is not available to cluster tokens", r.Method, r.URL.Path)
+	}
+	if write && !u.ClusterScope.Write {
+		return errors.New("Tornjak API V1 Authorization error: cluster token is read-only")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package authorization
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spiffe/tornjak/pkg/agent/authentication/user"
+)
+
+func TestClusterScopeAuthorizer(t *testing.T) {
+	next := &countingAuthorizer{}
+	a := NewClusterScopeAuthorizer(next)
+	reader := &user.UserInfo{Username: "clustertoken:r", ClusterScope: &user.ClusterScope{ClusterUID: "c1"}}
+	writer := &user.UserInfo{Username: "clustertoken:w", ClusterScope: &user.ClusterScope{ClusterUID: "c1", Write: true}}
+	get := httptest.NewRequest(http.MethodGet, "/api/v1/tornjak/clusters", nil)
+	patch := httptest.NewRequest(http.MethodPatch, "/api/v1/tornjak/clusters", nil)
+	del := httptest.NewRequest(http.MethodDelete, "/api/v1/tornjak/clusters", nil)
+	entries := httptest.NewRequest(http.MethodGet, "/api/v1/spire/entries", nil)
+
+	// cluster tokens may read their cluster, and edit it with write access
+	for _, u := range []*user.UserInfo{reader, writer} {
+		if err := a.AuthorizeRequest(get, u); err != nil {
+			t.Fatalf("ERROR: %s denied listing clusters: %v", u.Username, err)
+		}
+	}
+	if err := a.AuthorizeRequest(patch, writer); err != nil {
+		t.Fatalf("ERROR: writer denied editing cluster: %v", err)
+	}
+	if err := a.AuthorizeRequest(patch, reader); err == nil {
+		t.Fatal("ERROR: reader allowed to edit cluster")
+	}
+
+	// other routes are denied, even if the policy of next would allow them
+	for _, r := range []*http.Request{del, entries} {
+		if err := a.AuthorizeRequest(r, &user.UserInfo{Roles: []string{"admin"}, ClusterScope: writer.ClusterScope}); err == nil {
+			t.Fatalf("ERROR: writer allowed %s %s", r.Method, r.URL.Path)
+		}
+	}
+	if next.calls != 0 {
+		t.Fatalf("ERROR: expected no policy evaluation for cluster tokens, got %d", next.calls)
+	}
+
+	// other users are authorized by next
+	if err := a.AuthorizeRequest(del, &user.UserInfo{Username: "alice", Roles: []string{"admin"}}); err != nil {
+		t.Fatalf("ERROR: admin denied: %v", err)
+	}
+	if next.calls != 1 {
+		t.Fatalf("ERROR: expected 1 policy evaluation, got %d", next.calls)
+	}
+}
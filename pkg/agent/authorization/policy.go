@@ -0,0 +1,306 @@
+package authorization
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/invopop/yaml"
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/authentication/user"
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+)
+
+// Policy is an RBAC policy: the roles and the roles allowed to call each API
+// it holds the same blocks as the plugin_data of the RBAC Authorizer
+type Policy struct {
+	Name  string          `json:"name"`
+	Roles []PolicyRole    `json:"roles"`
+	API   []PolicyAPIRule `json:"api"`
+	// APIs of version 1, named by method and path, e.g. "GET /api/v1/tornjak/clusters"
+	APIv1 []PolicyAPIRule `json:"apiv1"`
+}
+
+type PolicyRole struct {
+	Name string `json:"name"`
+	Desc string `json:"desc"`
+}
+
+type PolicyAPIRule struct {
+	Name         string   `json:"name"`
+	AllowedRoles []string `json:"allowed_roles"`
+}
+
+// ParsePolicy parses a policy in YAML or JSON
+func ParsePolicy(data []byte) (Policy, error) {
+	var p Policy
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return p, errors.Errorf("could not parse policy: %v", err)
+	}
+	if err := json.Unmarshal(jsonData, &p); err != nil {
+		return p, errors.Errorf("could not parse policy: %v", err)
+	}
+	return p, nil
+}
+
+// Merge returns the policy with the roles and rules of other added
+// the roles allowed to call an API are those of both policies, so other
+// cannot revoke access granted by p
+func (p Policy) Merge(other Policy) Policy {
+	merged := Policy{Name: p.Name}
+	if other.Name != "" {
+		merged.Name = other.Name
+	}
+	roles := map[string]bool{}
+	for _, r := range append(append([]PolicyRole{}, p.Roles...), other.Roles...) {
+		if !roles[r.Name] {
+			roles[r.Name] = true
+			merged.Roles = append(merged.Roles, r)
+		}
+	}
+	merged.API = mergeRules(p.API, other.API)
+	merged.APIv1 = mergeRules(p.APIv1, other.APIv1)
+	return merged
+}
+
+func mergeRules(rules, others []PolicyAPIRule) []PolicyAPIRule {
+	merged := []PolicyAPIRule{}
+	index := map[string]int{}
+	for _, r := range append(append([]PolicyAPIRule{}, rules...), others...) {
+		i, ok := index[r.Name]
+		if !ok {
+			index[r.Name] = len(merged)
+			merged = append(merged, PolicyAPIRule{Name: r.Name, AllowedRoles: append([]string{}, r.AllowedRoles...)})
+			continue
+		}
+		for _, role := range r.AllowedRoles {
+			if !containsRole(merged[i].AllowedRoles, role) {
+				merged[i].AllowedRoles = append(merged[i].AllowedRoles, role)
+			}
+		}
+	}
+	return merged
+}
+
+func containsRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// mappings returns the role list and API mappings NewRBACAuthorizer takes
+func (p Policy) mappings() (map[string]string, map[string][]string, map[string]map[string][]string, error) {
+	roleList := make(map[string]string)
+	apiMapping := make(map[string][]string)
+	apiV1Mapping := make(map[string]map[string][]string)
+	for _, role := range p.Roles {
+		roleList[role.Name] = role.Desc
+	}
+	for _, api := range p.API {
+		apiMapping[api.Name] = api.AllowedRoles
+	}
+	for _, apiV1 := range p.APIv1 {
+		method, path, ok := strings.Cut(apiV1.Name, " ")
+		if !ok {
+			return nil, nil, nil, errors.Errorf("API V1 %q is not named by method and path", apiV1.Name)
+		}
+		if _, ok := apiV1Mapping[path]; !ok {
+			apiV1Mapping[path] = make(map[string][]string)
+		}
+		apiV1Mapping[path][method] = apiV1.AllowedRoles
+	}
+	return roleList, apiMapping, apiV1Mapping, nil
+}
+
+// allowedRoles returns the roles the policy allows to call method on path,
+// with whether the API is listed
+func (p Policy) allowedRoles(method, path string) ([]string, bool) {
+	rules, name := p.API, path
+	if strings.HasPrefix(path, "/api/v1") {
+		rules, name = p.APIv1, method+" "+path
+	}
+	for _, r := range rules {
+		if r.Name == name {
+			return r.AllowedRoles, true
+		}
+	}
+	return nil, false
+}
+
+// newRBACAuthorizer returns the RBAC authorizer enforcing p
+func (p Policy) newRBACAuthorizer() (*RBACAuthorizer, error) {
+	roleList, apiMapping, apiV1Mapping, err := p.mappings()
+	if err != nil {
+		return nil, errors.Errorf("Could not parse policy %s: %v", p.Name, err)
+	}
+	return NewRBACAuthorizer(p.Name, roleList, apiMapping, apiV1Mapping)
+}
+
+// PolicyDecision is the outcome of evaluating a request against a policy
+type PolicyDecision struct {
+	Policy  string   `json:"policy"`
+	Method  string   `json:"method"`
+	Path    string   `json:"path"`
+	Roles   []string `json:"roles"`
+	Allowed bool     `json:"allowed"`
+	Reason  string   `json:"reason"`
+}
+
+// PolicyAuthorizer enforces the RBAC policy of the configuration, extended by
+// the policy of a file when set
+// the file is read again when it changes, and a file that does not parse or
+// validate leaves the last valid policy in place
+type PolicyAuthorizer struct {
+	base     Policy
+	path     string
+	interval time.Duration
+	clock    clock.Clock
+
+	mu     sync.RWMutex
+	policy Policy
+	rbac   *RBACAuthorizer
+	digest [sha256.Size]byte
+}
+
+// NewPolicyAuthorizer returns an authorizer enforcing base merged with the
+// policy of the file at path, base only if path is empty
+// the file is read again by Reload, and every interval by Watch
+func NewPolicyAuthorizer(base Policy, path string, interval time.Duration, c clock.Clock) (*PolicyAuthorizer, error) {
+	a := &PolicyAuthorizer{base: base, path: path, interval: interval, clock: clock.OrNew(c)}
+	if path == "" {
+		rbac, err := base.newRBACAuthorizer()
+		if err != nil {
+			return nil, err
+		}
+		a.policy, a.rbac = base, rbac
+		return a, nil
+	}
+	if _, err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the policy file again, returning whether the enforced policy changed
+func (a *PolicyAuthorizer) Reload() (bool, error) {
+	if a.path == "" {
+		return false, nil
+	}
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return false, errors.Errorf("could not read policy file: %v", err)
+	}
+	digest := sha256.Sum256(data)
+	a.mu.RLock()
+	unchanged := a.rbac != nil && digest == a.digest
+	a.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	filePolicy, err := ParsePolicy(data)
+	if err != nil {
+		return false, err
+	}
+	policy := a.base.Merge(filePolicy)
+	rbac, err := policy.newRBACAuthorizer()
+	if err != nil {
+		return false, err
+	}
+	a.mu.Lock()
+	a.policy, a.rbac, a.digest = policy, rbac, digest
+	a.mu.Unlock()
+	return true, nil
+}
+
+// Watches returns whether the authorizer enforces a policy file
+func (a *PolicyAuthorizer) Watches() bool {
+	return a.path != ""
+}
+
+// Watch reloads the policy file every interval until ctx is done, calling
+// changed after the enforced policy changed
+func (a *PolicyAuthorizer) Watch(ctx context.Context, changed func()) {
+	if a.path == "" {
+		return
+	}
+	ticker := a.clock.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		reloaded, err := a.Reload()
+		if err != nil {
+			log.Printf("WARNING: keeping the last valid RBAC policy: %v", err)
+			continue
+		}
+		if reloaded {
+			log.Printf("RBAC policy reloaded from %s", a.path)
+			if changed != nil {
+				changed()
+			}
+		}
+	}
+}
+
+func (a *PolicyAuthorizer) AuthorizeRequest(r *http.Request, u *user.UserInfo) error {
+	a.mu.RLock()
+	rbac := a.rbac
+	a.mu.RUnlock()
+	return rbac.AuthorizeRequest(r, u)
+}
+
+// Evaluate tells whether a user with roles would be allowed to call method on
+// path, under the enforced policy or, if candidate is not nil, under the
+// configured policy merged with candidate as if it were the policy file
+// nothing is enforced, so policies can be checked before they are deployed
+func (a *PolicyAuthorizer) Evaluate(candidate *Policy, method, path string, roles []string) (PolicyDecision, error) {
+	a.mu.RLock()
+	policy, rbac := a.policy, a.rbac
+	a.mu.RUnlock()
+	if candidate != nil {
+		policy = a.base.Merge(*candidate)
+		var err error
+		if rbac, err = policy.newRBACAuthorizer(); err != nil {
+			return PolicyDecision{}, err
+		}
+	}
+
+	decision := PolicyDecision{Policy: policy.Name, Method: method, Path: path, Roles: roles}
+	r := &http.Request{Method: method, URL: &url.URL{Path: path}}
+	decision.Allowed = rbac.AuthorizeRequest(r, &user.UserInfo{Roles: roles}) == nil
+
+	allowed, listed := policy.allowedRoles(method, path)
+	switch {
+	case !listed || len(allowed) == 0:
+		decision.Reason = "no role is allowed to call " + method + " " + path
+	case decision.Allowed && containsRole(allowed, ""):
+		decision.Reason = "all authenticated users are allowed"
+	case decision.Allowed:
+		for _, role := range roles {
+			if containsRole(allowed, role) {
+				decision.Reason = "role " + role + " is allowed"
+				break
+			}
+		}
+	default:
+		decision.Reason = "allowed roles are " + strings.Join(allowed, ", ")
+	}
+	return decision, nil
+}
@@ -0,0 +1,140 @@
+package authorization
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spiffe/tornjak/pkg/agent/authentication/user"
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+)
+
+var basePolicy = Policy{
+	Name:  "base",
+	Roles: []PolicyRole{{Name: "admin"}, {Name: "viewer"}},
+	APIv1: []PolicyAPIRule{
+		{Name: "GET /api/v1/tornjak/clusters", AllowedRoles: []string{"admin"}},
+		{Name: "POST /api/v1/tornjak/clusters", AllowedRoles: []string{"admin"}},
+	},
+}
+
+const filePolicy = `
+name: file
+roles:
+  - name: auditor
+apiv1:
+  - name: GET /api/v1/tornjak/clusters
+    allowed_roles: [viewer, auditor]
+`
+
+func allowed(a Authorizer, method, path string, roles ...string) bool {
+	return a.AuthorizeRequest(httptest.NewRequest(method, path, nil), &user.UserInfo{Roles: roles}) == nil
+}
+
+// TestPolicyAuthorizer checks the policy file extends the configured policy,
+// is reloaded on change and keeps the last valid policy when invalid
+func TestPolicyAuthorizer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(filePolicy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fake := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	a, err := NewPolicyAuthorizer(basePolicy, path, time.Minute, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK roles of both policies are allowed
+	if !allowed(a, http.MethodGet, "/api/v1/tornjak/clusters", "admin") || !allowed(a, http.MethodGet, "/api/v1/tornjak/clusters", "auditor") {
+		t.Fatal("Expected admin and auditor to list clusters")
+	}
+	if allowed(a, http.MethodPost, "/api/v1/tornjak/clusters", "viewer") {
+		t.Fatal("Expected viewer not to create clusters")
+	}
+
+	// ATTEMPT invalid policy file; should keep the last valid policy [Reload]
+	if err = os.WriteFile(path, []byte("apiv1:\n  - name: GET /api/v1/unknown\n    allowed_roles: [viewer]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Reload(); err == nil {
+		t.Fatal("Expected an unknown API to fail")
+	}
+	if !allowed(a, http.MethodGet, "/api/v1/tornjak/clusters", "viewer") {
+		t.Fatal("Expected the last valid policy to be enforced")
+	}
+
+	// ATTEMPT change of the policy file while watched [Watch]
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 1)
+	go a.Watch(ctx, func() { changed <- struct{}{} })
+	for fake.Tickers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err = os.WriteFile(path, []byte("name: file\napiv1: []\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fake.Add(time.Minute)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the policy file to be reloaded")
+	}
+	if allowed(a, http.MethodGet, "/api/v1/tornjak/clusters", "viewer") || !allowed(a, http.MethodGet, "/api/v1/tornjak/clusters", "admin") {
+		t.Fatal("Expected only the configured policy after the file dropped its rules")
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	a, err := NewPolicyAuthorizer(basePolicy, "", time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK decisions under the enforced policy [Evaluate]
+	decision, err := a.Evaluate(nil, http.MethodGet, "/api/v1/tornjak/clusters", []string{"viewer", "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decision.Allowed || decision.Reason != "role admin is allowed" || decision.Policy != "base" {
+		t.Fatalf("Expected admin to be allowed, got %+v", decision)
+	}
+	decision, err = a.Evaluate(nil, http.MethodGet, "/api/v1/tornjak/clusters", []string{"viewer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Allowed || decision.Reason != "allowed roles are admin" {
+		t.Fatalf("Expected viewer to be denied, got %+v", decision)
+	}
+	decision, err = a.Evaluate(nil, http.MethodDelete, "/api/v1/tornjak/clusters", []string{"admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Allowed || decision.Reason != "no role is allowed to call DELETE /api/v1/tornjak/clusters" {
+		t.Fatalf("Expected unlisted API to be denied, got %+v", decision)
+	}
+
+	// CHECK decisions under a candidate policy, which is not enforced [Evaluate]
+	candidate, err := ParsePolicy([]byte(filePolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decision, err = a.Evaluate(&candidate, http.MethodGet, "/api/v1/tornjak/clusters", []string{"viewer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decision.Allowed || decision.Policy != "file" {
+		t.Fatalf("Expected viewer to be allowed by the candidate, got %+v", decision)
+	}
+	if allowed(a, http.MethodGet, "/api/v1/tornjak/clusters", "viewer") {
+		t.Fatal("Candidate policy should not be enforced")
+	}
+	invalid := Policy{APIv1: []PolicyAPIRule{{Name: "GET /api/v1/tornjak/clusters", AllowedRoles: []string{"unknown"}}}}
+	if _, err = a.Evaluate(&invalid, http.MethodGet, "/api/v1/tornjak/clusters", nil); err == nil {
+		t.Fatal("Expected a candidate with an undefined role to fail")
+	}
+}
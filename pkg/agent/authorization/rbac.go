@@ -41,15 +41,75 @@ var staticAPIV1List = map[string]map[string]struct{}{
 	"/api/v1/spire/serverinfo" :{"GET": {}},
 	"/api/v1/spire/healthcheck" :{"GET": {}},
 	"/api/v1/spire/entries" :{"GET": {}, "POST": {}, "DELETE": {}},
+	"/api/v1/spire/entries/clone" :{"POST": {}},
 	"/api/v1/spire/agents" :{"GET": {}, "POST": {}, "DELETE": {}},
 	"/api/v1/spire/agents/ban" :{"POST": {}},
 	"/api/v1/spire/agents/jointoken" :{"POST": {}},
 	"/api/v1/tornjak/clusters" :{"GET": {}, "POST": {}, "PATCH": {}, "DELETE": {}},
+	"/api/v1/tornjak/clusters/agents" :{"GET": {}},
+	"/api/v1/tornjak/clusters/agents/move" :{"POST": {}},
+	"/api/v1/tornjak/clusters/search" :{"GET": {}},
+	"/api/v1/tornjak/clusters/protection" :{"POST": {}},
+	"/api/v1/tornjak/clusters/rename" :{"POST": {}},
+	"/api/v1/tornjak/clusters/bulk" :{"POST": {}},
+	"/api/v1/tornjak/clusters/bulk-delete" :{"POST": {}},
+	"/api/v1/tornjak/clusters/deleted" :{"GET": {}},
+	"/api/v1/tornjak/clusters/deletions" :{"GET": {}},
+	"/api/v1/tornjak/clusters/restore" :{"POST": {}},
 	"/api/v1/tornjak/selectors" :{"GET": {}, "POST": {}},
-	"/api/v1/tornjak/agents" :{"GET": {}},
+	"/api/v1/tornjak/selectors/plugins" :{"GET": {}},
+	"/api/v1/tornjak/agents" :{"GET": {}, "PATCH": {}},
+	"/api/v1/tornjak/agents/match" :{"GET": {}},
+	"/api/v1/tornjak/agents/annotations" :{"GET": {}, "POST": {}, "DELETE": {}},
+	"/api/v1/tornjak/agents/compliance" :{"GET": {}, "POST": {}},
 	"/api/v1/tornjak/serverinfo" :{"GET": {}},
+	"/api/v1/tornjak/desiredstate" :{"GET": {}},
+	"/api/v1/tornjak/desiredstate/reconcile" :{"POST": {}},
+	"/api/v1/tornjak/replication/changes" :{"GET": {}},
+	"/api/v1/tornjak/replication/snapshot" :{"GET": {}},
+	"/api/v1/tornjak/replication/status" :{"GET": {}},
+	"/api/v1/tornjak/federations/freshness" :{"GET": {}},
+	"/api/v1/tornjak/bundle-endpoint/status" :{"GET": {}},
+	"/api/v1/tornjak/entries/ttl/advice" :{"GET": {}},
+	"/api/v1/tornjak/entries/ttl/remediate" :{"POST": {}},
+	"/api/v1/tornjak/chaos" :{"GET": {}, "POST": {}, "DELETE": {}},
+	"/api/v1/tornjak/debug/pprof" :{"GET": {}, "POST": {}},
+	"/api/v1/tornjak/debug/vars" :{"GET": {}},
+	"/api/v1/tornjak/debug/snapshot" :{"POST": {}},
+	"/api/v1/tornjak/debug/runtime" :{"GET": {}, "POST": {}},
+	"/api/v1/tornjak/spire/calls" :{"GET": {}},
+	"/api/v1/tornjak/bootstrap/tokens" :{"GET": {}, "POST": {}},
+	"/api/v1/tornjak/db/transactions" :{"GET": {}},
+	"/api/v1/tornjak/db/indexes" :{"GET": {}},
+	"/api/v1/tornjak/db/integrity" :{"GET": {}, "POST": {}},
+	"/api/v1/tornjak/metadata/schema" :{"GET": {}},
+	"/api/v1/tornjak/operations/failed" :{"GET": {}, "POST": {}},
+	"/api/v1/tornjak/agents/assignments" :{"POST": {}},
+	"/api/v1/tornjak/agents/assignments/jobs" :{"GET": {}},
+	"/api/v1/tornjak/snapshots" :{"GET": {}, "POST": {}, "DELETE": {}},
+	"/api/v1/tornjak/snapshots/restore" :{"POST": {}},
+	"/api/v1/tornjak/dashboard" :{"GET": {}},
+	"/api/v1/tornjak/telemetry/preview" :{"GET": {}},
+	"/api/v1/tornjak/entries/bulk-delete" :{"POST": {}},
+	"/api/v1/tornjak/entries/lineage" :{"GET": {}},
+	"/api/v1/tornjak/resolve" :{"GET": {}},
+	"/api/v1/tornjak/serviceaccounts" :{"GET": {}, "POST": {}, "DELETE": {}},
+	"/api/v1/tornjak/authorization/evaluate" :{"POST": {}},
+	"/api/v1/tornjak/clusters/tokens" :{"GET": {}, "POST": {}, "DELETE": {}},
+	"/api/v1/tornjak/entries/owners" :{"GET": {}, "POST": {}},
+	"/api/v1/tornjak/entries/lifecycle" :{"GET": {}, "POST": {}},
+	"/api/v1/tornjak/notes" :{"GET": {}, "POST": {}, "PATCH": {}, "DELETE": {}},
+	"/api/v1/tornjak/notes/history" :{"GET": {}},
+	"/api/v1/tornjak/ownership/transfer" :{"POST": {}},
+	"/api/v1/tornjak/ownership/transfers" :{"GET": {}},
+	"/api/v1/tornjak/audit" :{"GET": {}},
+	"/api/v1/tornjak/clusters/history" :{"GET": {}},
+	"/api/v1/tornjak/events/schemas" :{"GET": {}},
+	"/api/v1/tornjak/labels/bulk" :{"POST": {}},
 	"/api/v1/spire/bundle" :{"GET": {}},
 	"/api/v1/spire/federations/bundles" :{"GET": {}, "POST": {}, "DELETE": {}, "PATCH": {}},
+	"/api/v1/mirror/spire/entries" :{"GET": {}},
+	"/api/v1/mirror/spire/agents" :{"GET": {}},
 }
 
 func validateInitParameters(roleList map[string]string, apiMapping map[string][]string, apiV1Mapping map[string]map[string][]string) error {
@@ -0,0 +1,165 @@
+package bootstrap
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+	"github.com/spiffe/tornjak/pkg/encryption"
+)
+
+// path prefix of the SPIFFE IDs of agents attested with a join token
+const joinTokenAgentPathPrefix = "/spire/agent/join_token/"
+
+// length of the token IDs, a prefix of the hex-encoded token hash
+const tokenIDLength = 16
+
+// Store is the part of the Tornjak DB issued tokens are tracked in
+type Store interface {
+	CreateBootstrapToken(token types.BootstrapToken, tokenHash string) error
+	ConsumeBootstrapToken(tokenHash string, agentID string, consumedAt string) (bool, error)
+	ExpireBootstrapTokens(now string) (int64, error)
+}
+
+type Config struct {
+	// creates a join token in SPIRE valid for ttl, pre-registering the agent
+	// under agentID if not empty
+	CreateJoinToken func(ctx context.Context, ttl time.Duration, agentID string) (string, error)
+	// lists the SPIFFE IDs of the agents attested with a join token
+	ListJoinTokenAgents func(ctx context.Context) ([]string, error)
+	Store               Store
+	// hashes the tokens tracked in Store, with SHA-256 if nil
+	Hasher *encryption.Hasher
+	// TTL of tokens when the request sets none
+	DefaultTTL time.Duration
+	// maximum TTL of tokens
+	MaxTTL time.Duration
+	// time between two checks of consumed and expired tokens
+	SweepInterval time.Duration
+	// source of time, the clock of the system if nil
+	Clock clock.Clock
+}
+
+// Request is a request of a provisioning system for a bootstrap token
+type Request struct {
+	// TTL of the token, Config.DefaultTTL if zero
+	TTL time.Duration
+	// SPIFFE ID to pre-register the agent under, optional
+	AgentID     string
+	Description string
+	// user of the provisioning system
+	IssuedBy string
+}
+
+// Broker issues short-lived join tokens to provisioning systems and tracks
+// whether agents attested with them before they expired
+// join tokens are single-use: SPIRE deletes them once an agent attests
+type Broker struct {
+	config Config
+	clock  clock.Clock
+}
+
+func New(config Config) *Broker {
+	return &Broker{config: config, clock: clock.OrNew(config.Clock)}
+}
+
+// Issue creates a join token for the request and records it as issued
+func (b *Broker) Issue(ctx context.Context, req Request) (types.BootstrapCredential, error) {
+	ttl := req.TTL
+	if ttl == 0 {
+		ttl = b.config.DefaultTTL
+	}
+	if ttl < time.Second {
+		return types.BootstrapCredential{}, errors.New("TTL must be at least 1s")
+	}
+	if ttl > b.config.MaxTTL {
+		return types.BootstrapCredential{}, errors.Errorf("TTL must be at most %v", b.config.MaxTTL)
+	}
+
+	issuedAt := b.clock.Now().UTC()
+	token, err := b.config.CreateJoinToken(ctx, ttl, req.AgentID)
+	if err != nil {
+		return types.BootstrapCredential{}, errors.Errorf("could not create join token: %v", err)
+	}
+	tokenHash := b.config.Hasher.Hash(token)
+	record := types.BootstrapToken{
+		ID:          tokenHash[:tokenIDLength],
+		AgentID:     req.AgentID,
+		Description: req.Description,
+		State:       types.BootstrapTokenIssued,
+		IssuedBy:    req.IssuedBy,
+		IssuedAt:    formatTime(issuedAt),
+		ExpiresAt:   formatTime(issuedAt.Add(ttl)),
+	}
+	if err := b.config.Store.CreateBootstrapToken(record, tokenHash); err != nil {
+		return types.BootstrapCredential{}, err
+	}
+	return types.BootstrapCredential{BootstrapToken: record, Token: token}, nil
+}
+
+// Run sweeps the tokens every interval until ctx is done
+func (b *Broker) Run(ctx context.Context) {
+	ticker := b.clock.NewTicker(b.config.SweepInterval)
+	defer ticker.Stop()
+	for {
+		if err := b.Sweep(ctx); err != nil {
+			log.Printf("WARNING: could not sweep bootstrap tokens: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// Sweep marks the tokens agents attested with as consumed, then the issued
+// tokens past their expiry as expired
+func (b *Broker) Sweep(ctx context.Context) error {
+	agents, err := b.config.ListJoinTokenAgents(ctx)
+	if err != nil {
+		return errors.Errorf("could not list agents: %v", err)
+	}
+	now := formatTime(b.clock.Now().UTC())
+	for _, agentID := range agents {
+		id, err := spiffeid.FromString(agentID)
+		if err != nil {
+			continue
+		}
+		token, ok := strings.CutPrefix(id.Path(), joinTokenAgentPathPrefix)
+		if !ok || token == "" {
+			continue
+		}
+		// tokens issued before the pepper was configured are tracked by their SHA-256 hash
+		for _, tokenHash := range b.config.Hasher.Hashes(token) {
+			consumed, err := b.config.Store.ConsumeBootstrapToken(tokenHash, agentID, now)
+			if err != nil {
+				return err
+			}
+			if consumed {
+				log.Printf("bootstrap token %s consumed by agent %s", tokenHash[:tokenIDLength], agentID)
+				break
+			}
+		}
+	}
+
+	expired, err := b.config.Store.ExpireBootstrapTokens(now)
+	if err != nil {
+		return err
+	}
+	if expired > 0 {
+		log.Printf("%d bootstrap tokens expired unused", expired)
+	}
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
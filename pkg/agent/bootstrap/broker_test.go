@@ -0,0 +1,221 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+	"github.com/spiffe/tornjak/pkg/encryption"
+)
+
+// hash is the hash tokens are tracked by without a pepper
+var hash = encryption.NewHasher(nil).Hash
+
+type memoryStore struct {
+	tokens map[string]types.BootstrapToken
+}
+
+func (s *memoryStore) CreateBootstrapToken(token types.BootstrapToken, tokenHash string) error {
+	s.tokens[tokenHash] = token
+	return nil
+}
+
+func (s *memoryStore) ConsumeBootstrapToken(tokenHash string, agentID string, consumedAt string) (bool, error) {
+	token, ok := s.tokens[tokenHash]
+	if !ok || token.State == types.BootstrapTokenConsumed {
+		return false, nil
+	}
+	token.State, token.ConsumedBy, token.ConsumedAt = types.BootstrapTokenConsumed, agentID, consumedAt
+	s.tokens[tokenHash] = token
+	return true, nil
+}
+
+func (s *memoryStore) ExpireBootstrapTokens(now string) (int64, error) {
+	var n int64
+	for hash, token := range s.tokens {
+		if token.State == types.BootstrapTokenIssued && token.ExpiresAt <= now {
+			token.State = types.BootstrapTokenExpired
+			s.tokens[hash] = token
+			n++
+		}
+	}
+	return n, nil
+}
+
+// TestBroker checks issued tokens are tracked until consumed or expired
+func TestBroker(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	store := &memoryStore{tokens: map[string]types.BootstrapToken{}}
+	tokens := []string{"token-a", "token-b"}
+	agents := []string{}
+	var ttls []time.Duration
+	b := New(Config{
+		CreateJoinToken: func(ctx context.Context, ttl time.Duration, agentID string) (string, error) {
+			ttls = append(ttls, ttl)
+			token := tokens[0]
+			tokens = tokens[1:]
+			return token, nil
+		},
+		ListJoinTokenAgents: func(ctx context.Context) ([]string, error) { return agents, nil },
+		Store:               store,
+		DefaultTTL:          10 * time.Minute,
+		MaxTTL:              time.Hour,
+		Clock:               fake,
+	})
+	ctx := context.Background()
+
+	// ATTEMPT TTLs outside the allowed range [Issue]
+	if _, err := b.Issue(ctx, Request{TTL: 2 * time.Hour}); err == nil {
+		t.Fatal("Expected error on TTL above the maximum")
+	}
+	if _, err := b.Issue(ctx, Request{TTL: time.Millisecond}); err == nil {
+		t.Fatal("Expected error on TTL below 1s")
+	}
+
+	// ATTEMPT issue tokens [Issue]
+	credA, err := b.Issue(ctx, Request{AgentID: "spiffe://example.org/node/a", IssuedBy: "provisioner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credB, err := b.Issue(ctx, Request{TTL: 30 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK tokens are returned with their records
+	if credA.Token != "token-a" || credA.BootstrapToken.State != types.BootstrapTokenIssued ||
+		credA.BootstrapToken.ExpiresAt != "2024-03-01T12:10:00Z" || credA.BootstrapToken.AgentID != "spiffe://example.org/node/a" {
+		t.Fatalf("Unexpected credential %+v", credA)
+	}
+	if credA.BootstrapToken.ID != hash("token-a")[:tokenIDLength] {
+		t.Fatalf("Unexpected token ID %s", credA.BootstrapToken.ID)
+	}
+	if len(ttls) != 2 || ttls[0] != 10*time.Minute || ttls[1] != 30*time.Minute {
+		t.Fatalf("Unexpected TTLs %v", ttls)
+	}
+
+	// ATTEMPT sweep after an agent attested with token-a, past the expiry of token-a [Sweep]
+	agents = []string{"spiffe://example.org/spire/agent/join_token/token-a", "spiffe://example.org/spire/agent/x509pop/abc"}
+	fake.Add(20 * time.Minute)
+	if err := b.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK token-a is consumed and token-b still issued
+	a := store.tokens[hash("token-a")]
+	if a.State != types.BootstrapTokenConsumed || a.ConsumedBy != agents[0] || a.ConsumedAt != "2024-03-01T12:20:00Z" {
+		t.Fatalf("Unexpected record %+v", a)
+	}
+	if state := store.tokens[hash(credB.Token)].State; state != types.BootstrapTokenIssued {
+		t.Fatalf("Expected token-b issued, got %s", state)
+	}
+
+	// ATTEMPT sweep past the expiry of token-b [Sweep]
+	agents = []string{}
+	fake.Add(20 * time.Minute)
+	if err := b.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK token-b expired unused
+	if state := store.tokens[hash(credB.Token)].State; state != types.BootstrapTokenExpired {
+		t.Fatalf("Expected token-b expired, got %s", state)
+	}
+
+	// ATTEMPT issue when SPIRE fails [Issue]
+	b.config.CreateJoinToken = func(ctx context.Context, ttl time.Duration, agentID string) (string, error) {
+		return "", errors.New("SPIRE server unavailable")
+	}
+	// CHECK nothing is tracked
+	if _, err := b.Issue(ctx, Request{}); err == nil {
+		t.Fatal("Expected error when SPIRE fails")
+	}
+	if len(store.tokens) != 2 {
+		t.Fatalf("Expected 2 tracked tokens, got %d", len(store.tokens))
+	}
+}
+
+// TestBrokerPepper checks tokens are tracked by their peppered hash, and that
+// tokens issued before the pepper was configured are still consumed
+func TestBrokerPepper(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	store := &memoryStore{tokens: map[string]types.BootstrapToken{}}
+	hasher := encryption.NewHasher([]byte("pepper"))
+	agents := []string{}
+	b := New(Config{
+		CreateJoinToken: func(ctx context.Context, ttl time.Duration, agentID string) (string, error) {
+			return "token-a", nil
+		},
+		ListJoinTokenAgents: func(ctx context.Context) ([]string, error) { return agents, nil },
+		Store:               store,
+		Hasher:              hasher,
+		DefaultTTL:          10 * time.Minute,
+		MaxTTL:              time.Hour,
+		Clock:               fake,
+	})
+	ctx := context.Background()
+
+	// ATTEMPT issue a token with the pepper, and track one issued before [Issue]
+	cred, err := b.Issue(ctx, Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.tokens[hasher.Hash("token-a")]; !ok || cred.BootstrapToken.ID != hasher.Hash("token-a")[:tokenIDLength] {
+		t.Fatalf("Expected token tracked by its peppered hash, got %+v", store.tokens)
+	}
+	store.tokens[hash("token-b")] = types.BootstrapToken{State: types.BootstrapTokenIssued, ExpiresAt: "2024-03-01T12:10:00Z"}
+
+	// ATTEMPT sweep after agents attested with both tokens [Sweep]
+	agents = []string{"spiffe://example.org/spire/agent/join_token/token-a", "spiffe://example.org/spire/agent/join_token/token-b"}
+	if err := b.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK both tokens are consumed
+	if state := store.tokens[hasher.Hash("token-a")].State; state != types.BootstrapTokenConsumed {
+		t.Fatalf("Expected token-a consumed, got %s", state)
+	}
+	if state := store.tokens[hash("token-b")].State; state != types.BootstrapTokenConsumed {
+		t.Fatalf("Expected token-b consumed, got %s", state)
+	}
+}
+
+// TestBrokerRun checks tokens are swept on start and then every sweep interval of the clock
+func TestBrokerRun(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	sweeps := make(chan struct{})
+	b := New(Config{
+		ListJoinTokenAgents: func(ctx context.Context) ([]string, error) {
+			sweeps <- struct{}{}
+			return nil, nil
+		},
+		Store:         &memoryStore{tokens: map[string]types.BootstrapToken{}},
+		SweepInterval: time.Minute,
+		Clock:         fake,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.Run(ctx)
+		close(done)
+	}()
+
+	// CHECK sweep on start [Run]
+	<-sweeps
+	for fake.Tickers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// ATTEMPT let less than the interval pass
+	fake.Add(30 * time.Second)
+	select {
+	case <-sweeps:
+		t.Fatal("Unexpected sweep before the sweep interval")
+	case <-time.After(10 * time.Millisecond):
+	}
+	// ATTEMPT let the interval pass
+	fake.Add(30 * time.Second)
+	// CHECK sweep
+	<-sweeps
+
+	cancel()
+	<-done
+}
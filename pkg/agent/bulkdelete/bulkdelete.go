@@ -0,0 +1,195 @@
+package bulkdelete
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// Entry contains the fields of a SPIRE entry filters match on
+type Entry struct {
+	Id       string
+	SpiffeId string
+	ParentId string
+	// selectors as type:value
+	Selectors []string
+}
+
+// DeleteBatch deletes the entries with ids from SPIRE
+// returns the entries SPIRE did not delete, or an error if the whole batch failed
+type DeleteBatch func(ctx context.Context, ids []string) ([]types.EntryBulkDeleteFailure, error)
+
+type Config struct {
+	Delete DeleteBatch
+	// number of matching entries above which the confirmation token is required
+	ConfirmThreshold int
+	// maximum number of entries deleted per call to SPIRE
+	BatchSize int
+	// time between two batches
+	BatchInterval time.Duration
+	// source of time, the clock of the system if nil
+	Clock clock.Clock
+}
+
+// Deleter deletes the SPIRE entries matching a filter in rate-limited batches
+type Deleter struct {
+	config Config
+	clock  clock.Clock
+}
+
+func New(config Config) *Deleter {
+	return &Deleter{config: config, clock: clock.OrNew(config.Clock)}
+}
+
+// ValidateFilter returns an error if the filter sets no criterion or a malformed one,
+// so a filter never matches all entries by mistake
+func ValidateFilter(filter types.EntryFilter) error {
+	if filter.ParentId == "" && len(filter.Selectors) == 0 && filter.SpiffeIdPrefix == "" {
+		return errors.New("filter must set a parent ID, selectors or a SPIFFE ID prefix")
+	}
+	if filter.ParentId != "" {
+		if _, err := spiffeid.FromString(filter.ParentId); err != nil {
+			return errors.Errorf("invalid parent ID %q: %v", filter.ParentId, err)
+		}
+	}
+	for _, selector := range filter.Selectors {
+		if i := strings.Index(selector, ":"); i <= 0 || i == len(selector)-1 {
+			return errors.Errorf("invalid selector %q: must be type:value", selector)
+		}
+	}
+	if filter.SpiffeIdPrefix != "" {
+		td, _, _ := strings.Cut(strings.TrimPrefix(filter.SpiffeIdPrefix, "spiffe://"), "/")
+		if !strings.HasPrefix(filter.SpiffeIdPrefix, "spiffe://") || td == "" {
+			return errors.Errorf("invalid SPIFFE ID prefix %q: must start with spiffe://<trust domain>", filter.SpiffeIdPrefix)
+		}
+	}
+	return nil
+}
+
+// Match returns whether the entry matches every criterion of the filter
+func Match(filter types.EntryFilter, entry Entry) bool {
+	if filter.ParentId != "" && entry.ParentId != filter.ParentId {
+		return false
+	}
+	if filter.SpiffeIdPrefix != "" && !strings.HasPrefix(entry.SpiffeId, filter.SpiffeIdPrefix) {
+		return false
+	}
+	selectors := map[string]bool{}
+	for _, selector := range entry.Selectors {
+		selectors[selector] = true
+	}
+	for _, selector := range filter.Selectors {
+		if !selectors[selector] {
+			return false
+		}
+	}
+	return true
+}
+
+// ConfirmationToken returns the token confirming the deletion of the entries
+// with ids matched by filter
+// it changes when the filter matches other entries, so a confirmation is
+// never applied to entries the caller did not see
+func ConfirmationToken(filter types.EntryFilter, ids []string) string {
+	sorted := append([]string{}, ids...)
+	sort.Strings(sorted)
+	selectors := append([]string{}, filter.Selectors...)
+	sort.Strings(selectors)
+	filter.Selectors = selectors
+	data, _ := json.Marshal(struct {
+		Filter types.EntryFilter `json:"filter"`
+		Ids    []string          `json:"ids"`
+	}{filter, sorted})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// Delete deletes the entries matching filter, unless dryRun is set
+// more matching entries than the confirmation threshold are only deleted if
+// token is their confirmation token
+// the deletion stops at the first failed batch or when ctx is done, the
+// outcome then reports the entries not attempted
+func (d *Deleter) Delete(ctx context.Context, entries []Entry, filter types.EntryFilter, token string, dryRun bool) (types.EntryBulkDelete, error) {
+	if err := ValidateFilter(filter); err != nil {
+		return types.EntryBulkDelete{}, err
+	}
+	ids := []string{}
+	for _, entry := range entries {
+		if Match(filter, entry) {
+			ids = append(ids, entry.Id)
+		}
+	}
+	result := types.EntryBulkDelete{DryRun: dryRun, EntryIds: ids, Failures: []types.EntryBulkDeleteFailure{}}
+	confirmation := ConfirmationToken(filter, ids)
+	if len(ids) > d.config.ConfirmThreshold {
+		result.ConfirmationToken = confirmation
+		result.ConfirmationRequired = token != confirmation
+	}
+	if dryRun || result.ConfirmationRequired || len(ids) == 0 {
+		result.Batch = entryBatch(result, 0)
+		return result, nil
+	}
+
+	ticker := d.clock.NewTicker(d.config.BatchInterval)
+	defer ticker.Stop()
+	for start := 0; start < len(ids); start += d.config.BatchSize {
+		if start > 0 {
+			select {
+			case <-ctx.Done():
+				result.Remaining = len(ids) - start
+				result.Error = ctx.Err().Error()
+				result.Batch = entryBatch(result, start)
+				return result, nil
+			case <-ticker.C():
+			}
+		}
+
+		end := min(start+d.config.BatchSize, len(ids))
+		failures, err := d.config.Delete(ctx, ids[start:end])
+		if err != nil {
+			log.Printf("WARNING: bulk deletion of entries stopped after %d deleted: %v", result.Deleted, err)
+			result.Remaining = len(ids) - start
+			result.Error = err.Error()
+			result.Batch = entryBatch(result, start)
+			return result, nil
+		}
+		result.Failures = append(result.Failures, failures...)
+		result.Deleted += end - start - len(failures)
+	}
+	result.Batch = entryBatch(result, len(ids))
+	return result, nil
+}
+
+// entryBatch returns the outcome of each matching entry of a deletion, whose
+// entries from index attempted on were not sent to SPIRE
+func entryBatch(result types.EntryBulkDelete, attempted int) types.BatchResult {
+	failures := map[string]types.EntryBulkDeleteFailure{}
+	for _, f := range result.Failures {
+		failures[f.EntryId] = f
+	}
+	batch := types.NewBatchResult()
+	for i, id := range result.EntryIds {
+		item := types.BatchItem{Index: i, ID: id, Status: types.BatchItemSucceeded}
+		if f, ok := failures[id]; ok {
+			item.Status, item.Code, item.Error = types.BatchItemFailed, f.Code, f.Error
+		} else if i >= attempted {
+			item.Status = types.BatchItemSkipped
+			if result.Error != "" {
+				item.Code, item.Error = types.BatchCodeAborted, result.Error
+			}
+		}
+		batch.Add(item)
+	}
+	return batch
+}
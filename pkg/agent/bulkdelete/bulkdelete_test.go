@@ -0,0 +1,180 @@
+package bulkdelete
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+func TestValidateFilter(t *testing.T) {
+	valid := []types.EntryFilter{
+		{ParentId: "spiffe://example.org/spire/agent/k8s_psat/node1"},
+		{Selectors: []string{"k8s:ns:dev"}},
+		{SpiffeIdPrefix: "spiffe://example.org/ns/dev/"},
+	}
+	for _, filter := range valid {
+		if err := ValidateFilter(filter); err != nil {
+			t.Fatalf("Expected valid filter %+v: %v", filter, err)
+		}
+	}
+	invalid := []types.EntryFilter{
+		{},
+		{ParentId: "example.org/agent"},
+		{Selectors: []string{"k8s"}},
+		{Selectors: []string{"k8s:"}},
+		{SpiffeIdPrefix: "spiffe://"},
+		{SpiffeIdPrefix: "/ns/dev"},
+	}
+	for _, filter := range invalid {
+		if err := ValidateFilter(filter); err == nil {
+			t.Fatalf("Expected error on filter %+v", filter)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	entry := Entry{
+		Id:        "a",
+		SpiffeId:  "spiffe://example.org/ns/dev/sa/web",
+		ParentId:  "spiffe://example.org/spire/agent/k8s_psat/node1",
+		Selectors: []string{"k8s:ns:dev", "k8s:sa:web"},
+	}
+	for filter, expected := range map[*types.EntryFilter]bool{
+		{ParentId: entry.ParentId}:                                                                                true,
+		{ParentId: "spiffe://example.org/spire/agent/other"}:                                                      false,
+		{Selectors: []string{"k8s:ns:dev"}}:                                                                       true,
+		{Selectors: []string{"k8s:ns:dev", "k8s:sa:api"}}:                                                         false,
+		{SpiffeIdPrefix: "spiffe://example.org/ns/dev/"}:                                                          true,
+		{SpiffeIdPrefix: "spiffe://example.org/ns/prod/"}:                                                         false,
+		{ParentId: entry.ParentId, Selectors: []string{"k8s:sa:web"}, SpiffeIdPrefix: "spiffe://example.org/ns/"}: true,
+	} {
+		if got := Match(*filter, entry); got != expected {
+			t.Fatalf("Expected match %v of filter %+v, got %v", expected, *filter, got)
+		}
+	}
+}
+
+// TestDelete checks large deletions need the confirmation token and entries are deleted in batches paced by the clock
+func TestDelete(t *testing.T) {
+	entries := []Entry{}
+	for i := 0; i < 5; i++ {
+		entries = append(entries, Entry{Id: fmt.Sprintf("dev-%d", i), SpiffeId: fmt.Sprintf("spiffe://example.org/ns/dev/sa/%d", i)})
+	}
+	entries = append(entries, Entry{Id: "prod-0", SpiffeId: "spiffe://example.org/ns/prod/sa/0"})
+	filter := types.EntryFilter{SpiffeIdPrefix: "spiffe://example.org/ns/dev/"}
+	matching := []string{"dev-0", "dev-1", "dev-2", "dev-3", "dev-4"}
+
+	fake := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	batches := make(chan []string, 10)
+	failing := false
+	d := New(Config{
+		Delete: func(ctx context.Context, ids []string) ([]types.EntryBulkDeleteFailure, error) {
+			batches <- ids
+			if failing {
+				return nil, errors.New("SPIRE server unavailable")
+			}
+			if ids[0] == "dev-2" {
+				return []types.EntryBulkDeleteFailure{{EntryId: "dev-2", Error: "not found"}}, nil
+			}
+			return nil, nil
+		},
+		ConfirmThreshold: 3,
+		BatchSize:        2,
+		BatchInterval:    time.Second,
+		Clock:            fake,
+	})
+	ctx := context.Background()
+
+	// ATTEMPT delete with a filter matching everything
+	if _, err := d.Delete(ctx, entries, types.EntryFilter{}, "", false); err == nil {
+		t.Fatal("Expected error on empty filter")
+	}
+
+	// ATTEMPT delete more entries than the threshold without confirmation
+	result, err := d.Delete(ctx, entries, filter, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK confirmation required and nothing deleted
+	if !result.ConfirmationRequired || result.ConfirmationToken == "" || !reflect.DeepEqual(result.EntryIds, matching) || result.Deleted != 0 || len(batches) != 0 {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	token := result.ConfirmationToken
+
+	// ATTEMPT confirm with the token after the matching entries changed
+	result, err = d.Delete(ctx, entries[1:], filter, token, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK token rejected
+	if !result.ConfirmationRequired || result.ConfirmationToken == token || len(batches) != 0 {
+		t.Fatalf("Expected stale token rejected, got %+v", result)
+	}
+
+	// ATTEMPT dry run with the token
+	result, err = d.Delete(ctx, entries, filter, token, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ConfirmationRequired || !result.DryRun || len(batches) != 0 || result.Batch.Skipped != len(matching) {
+		t.Fatalf("Unexpected dry run %+v", result)
+	}
+
+	// ATTEMPT delete with the token
+	done := make(chan types.EntryBulkDelete)
+	go func() {
+		result, err := d.Delete(ctx, entries, filter, token, false)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- result
+	}()
+	// CHECK the first batch is sent at once, the next ones at each interval
+	if got := <-batches; !reflect.DeepEqual(got, []string{"dev-0", "dev-1"}) {
+		t.Fatalf("Unexpected first batch %v", got)
+	}
+	select {
+	case got := <-batches:
+		t.Fatalf("Unexpected batch %v before the interval", got)
+	case <-time.After(10 * time.Millisecond):
+	}
+	fake.Add(time.Second)
+	if got := <-batches; !reflect.DeepEqual(got, []string{"dev-2", "dev-3"}) {
+		t.Fatalf("Unexpected second batch %v", got)
+	}
+	fake.Add(time.Second)
+	if got := <-batches; !reflect.DeepEqual(got, []string{"dev-4"}) {
+		t.Fatalf("Unexpected last batch %v", got)
+	}
+	// CHECK entries deleted except the failed one
+	result = <-done
+	if result.Deleted != 4 || len(result.Failures) != 1 || result.Failures[0].EntryId != "dev-2" || result.Remaining != 0 || result.Error != "" {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	if result.Batch.Succeeded != 4 || result.Batch.Failed != 1 || result.Batch.Items[2].Status != types.BatchItemFailed ||
+		result.Batch.Items[2].ID != "dev-2" || result.Batch.Items[2].Error != "not found" {
+		t.Fatalf("Unexpected batch %+v", result.Batch)
+	}
+
+	// ATTEMPT delete when SPIRE fails
+	failing = true
+	result, err = d.Delete(ctx, entries[:3], filter, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-batches
+	// CHECK deletion stopped with the remaining entries
+	if result.Deleted != 0 || result.Remaining != 3 || result.Error == "" {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	// CHECK every entry skipped, to be retried
+	if result.Batch.Skipped != 3 || result.Batch.Items[0].Code != types.BatchCodeAborted {
+		t.Fatalf("Unexpected batch %+v", result.Batch)
+	}
+}
@@ -0,0 +1,203 @@
+package bundleendpoint
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+)
+
+type Config struct {
+	// fetches the bundle of the trust domain of the SPIRE server
+	FetchBundle func(ctx context.Context) (*spiffebundle.Bundle, error)
+	// trust domain of the SPIRE server, taken from the first bundle fetched if empty
+	TrustDomain string
+	// time between two fetches of the bundle
+	RefreshInterval time.Duration
+	// refresh hint served to federation partners, the refresh hint set by SPIRE if zero
+	RefreshHint time.Duration
+	// timeout of fetching the bundle
+	FetchTimeout time.Duration
+	// source of time, the clock of the system if nil
+	Clock clock.Clock
+}
+
+// Status is the state of the bundle served by the endpoint
+type Status struct {
+	TrustDomain    string    `json:"trustDomain"`
+	SequenceNumber uint64    `json:"sequenceNumber"`
+	FetchedAt      time.Time `json:"fetchedAt"`
+	// error of the last fetch, the last bundle fetched is served until a fetch succeeds
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Endpoint serves the bundle of the trust domain of the SPIRE server to
+// federation partners, in the format of the SPIFFE Trust Domain and Bundle
+// specification
+// the bundle is fetched from SPIRE every refresh interval and served from memory,
+// so new authorities prepared by SPIRE ahead of a CA rotation reach the partners
+// within the refresh interval and their refresh hint
+type Endpoint struct {
+	config Config
+	clock  clock.Clock
+
+	mu          sync.RWMutex
+	trustDomain spiffeid.TrustDomain
+	bundle      *spiffebundle.Bundle
+	// bundle as served, encoded once per change
+	data      []byte
+	fetchedAt time.Time
+	lastError string
+}
+
+// New returns the endpoint for the configuration
+// returns an error if the trust domain is invalid
+func New(config Config) (*Endpoint, error) {
+	e := &Endpoint{config: config, clock: clock.OrNew(config.Clock)}
+	if config.TrustDomain != "" {
+		td, err := spiffeid.TrustDomainFromString(config.TrustDomain)
+		if err != nil {
+			return nil, errors.Errorf("invalid trust domain %q: %v", config.TrustDomain, err)
+		}
+		e.trustDomain = td
+	}
+	return e, nil
+}
+
+// Run fetches the bundle every refresh interval until ctx is done
+func (e *Endpoint) Run(ctx context.Context) {
+	ticker := e.clock.NewTicker(e.config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		if err := e.Refresh(ctx); err != nil {
+			log.Printf("WARNING: could not refresh the served trust domain bundle: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// Refresh fetches the bundle once, and serves it if valid
+// on failure the last bundle fetched is kept
+func (e *Endpoint) Refresh(ctx context.Context) error {
+	fetchCtx, cancel := context.WithTimeout(ctx, e.config.FetchTimeout)
+	bundle, err := e.config.FetchBundle(fetchCtx)
+	cancel()
+	if err == nil {
+		err = e.validate(bundle)
+	}
+	if err != nil {
+		e.mu.Lock()
+		e.lastError = err.Error()
+		e.mu.Unlock()
+		return err
+	}
+
+	bundle = bundle.Clone()
+	if e.config.RefreshHint > 0 {
+		bundle.SetRefreshHint(e.config.RefreshHint)
+	}
+	data, err := bundle.Marshal()
+	if err != nil {
+		err = errors.Errorf("could not encode bundle: %v", err)
+		e.mu.Lock()
+		e.lastError = err.Error()
+		e.mu.Unlock()
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.bundle != nil && !e.bundle.Equal(bundle) {
+		log.Printf("Served bundle of trust domain %s changed, sequence number %d to %d",
+			bundle.TrustDomain(), sequenceNumber(e.bundle), sequenceNumber(bundle))
+	}
+	e.trustDomain = bundle.TrustDomain()
+	e.bundle = bundle
+	e.data = data
+	e.fetchedAt = e.clock.Now()
+	e.lastError = ""
+	return nil
+}
+
+// validate returns an error if the bundle cannot be served
+func (e *Endpoint) validate(bundle *spiffebundle.Bundle) error {
+	if bundle == nil {
+		return errors.New("SPIRE returned no bundle")
+	}
+	e.mu.RLock()
+	td := e.trustDomain
+	e.mu.RUnlock()
+	if !td.IsZero() && bundle.TrustDomain() != td {
+		return errors.Errorf("SPIRE returned the bundle of trust domain %s instead of %s", bundle.TrustDomain(), td)
+	}
+	if len(bundle.X509Authorities()) == 0 {
+		return errors.Errorf("bundle of trust domain %s has no X.509 authorities", bundle.TrustDomain())
+	}
+	return nil
+}
+
+// sequenceNumber returns the sequence number of a bundle, 0 if not set
+func sequenceNumber(bundle *spiffebundle.Bundle) uint64 {
+	n, _ := bundle.SequenceNumber()
+	return n
+}
+
+// GetBundleForTrustDomain returns the served bundle, so the endpoint is a spiffebundle.Source
+func (e *Endpoint) GetBundleForTrustDomain(td spiffeid.TrustDomain) (*spiffebundle.Bundle, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.bundle == nil {
+		return nil, errors.New("bundle not fetched yet")
+	}
+	if td != e.trustDomain {
+		return nil, errors.Errorf("no bundle of trust domain %s", td)
+	}
+	return e.bundle.Clone(), nil
+}
+
+// Status returns the state of the served bundle
+func (e *Endpoint) Status() Status {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	status := Status{FetchedAt: e.fetchedAt, LastError: e.lastError}
+	if !e.trustDomain.IsZero() {
+		status.TrustDomain = e.trustDomain.String()
+	}
+	if e.bundle != nil {
+		status.SequenceNumber = sequenceNumber(e.bundle)
+	}
+	return status
+}
+
+// ServeHTTP serves the bundle on GET requests
+// fails with 503 until the bundle is fetched for the first time
+func (e *Endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method is not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	e.mu.RLock()
+	data := e.data
+	e.mu.RUnlock()
+	if data == nil {
+		http.Error(w, "bundle not fetched yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(data); err != nil {
+		log.Printf("WARNING: could not write trust domain bundle: %v", err)
+	}
+}
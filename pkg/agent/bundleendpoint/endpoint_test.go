@@ -0,0 +1,196 @@
+package bundleendpoint
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+)
+
+// newCA returns a self-signed CA certificate and its key
+func newCA(t *testing.T, name string, notAfter time.Time) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             notAfter.Add(-24 * time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// newBundle returns a bundle of the trust domain with the given CA certificates
+func newBundle(trustDomain string, sequence uint64, certs ...*x509.Certificate) *spiffebundle.Bundle {
+	bundle := spiffebundle.New(spiffeid.RequireTrustDomainFromString(trustDomain))
+	for _, cert := range certs {
+		bundle.AddX509Authority(cert)
+	}
+	bundle.SetSequenceNumber(sequence)
+	return bundle
+}
+
+// TestEndpoint checks the bundle is served once fetched, kept on failed
+// fetches and replaced on rotations
+func TestEndpoint(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(now)
+	ca1, _ := newCA(t, "ca1", now.Add(time.Hour))
+	ca2, _ := newCA(t, "ca2", now.Add(2*time.Hour))
+
+	var fetched *spiffebundle.Bundle
+	var fetchErr error
+	endpoint, err := New(Config{
+		FetchBundle: func(ctx context.Context) (*spiffebundle.Bundle, error) {
+			return fetched, fetchErr
+		},
+		TrustDomain:     "example.org",
+		RefreshInterval: time.Minute,
+		RefreshHint:     5 * time.Minute,
+		FetchTimeout:    time.Second,
+		Clock:           fake,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		endpoint.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w
+	}
+
+	// CHECK nothing is served before the first fetch [ServeHTTP]
+	if w := get(); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 before the first fetch, got %d", w.Code)
+	}
+
+	// CHECK the fetched bundle is served with the configured refresh hint [Refresh, ServeHTTP]
+	fetched = newBundle("example.org", 1, ca1)
+	if err = endpoint.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	w := get()
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected the bundle as JSON, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	served, err := spiffebundle.Parse(spiffeid.RequireTrustDomainFromString("example.org"), w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hint, ok := served.RefreshHint(); !ok || hint != 5*time.Minute {
+		t.Fatalf("Expected refresh hint 5m, got %v", hint)
+	}
+	if !served.HasX509Authority(ca1) {
+		t.Fatal("Served bundle should have the CA of SPIRE")
+	}
+	if hint, _ := fetched.RefreshHint(); hint != 0 {
+		t.Fatal("Refresh should not change the fetched bundle")
+	}
+
+	// CHECK failed fetches keep the last bundle [Refresh, Status]
+	fetchErr = errors.New("connection refused")
+	fake.Add(time.Minute)
+	if err = endpoint.Refresh(context.Background()); err == nil {
+		t.Fatal("Refresh should fail when SPIRE is unreachable")
+	}
+	if w = get(); w.Code != http.StatusOK {
+		t.Fatalf("Last bundle should still be served, got %d", w.Code)
+	}
+	status := endpoint.Status()
+	if status.LastError == "" || !status.FetchedAt.Equal(now) || status.SequenceNumber != 1 {
+		t.Fatalf("Unexpected status after a failed fetch: %+v", status)
+	}
+
+	// CHECK bundles of other trust domains and without X.509 authorities are refused [Refresh]
+	fetchErr = nil
+	for name, bundle := range map[string]*spiffebundle.Bundle{
+		"other trust domain": newBundle("other.org", 2, ca2),
+		"no authorities":     newBundle("example.org", 2),
+		"no bundle":          nil,
+	} {
+		fetched = bundle
+		if err = endpoint.Refresh(context.Background()); err == nil {
+			t.Errorf("%s: Refresh should fail", name)
+		}
+	}
+
+	// CHECK a rotation is served [Refresh, GetBundleForTrustDomain]
+	fetched = newBundle("example.org", 2, ca1, ca2)
+	if err = endpoint.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := endpoint.GetBundleForTrustDomain(spiffeid.RequireTrustDomainFromString("example.org"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bundle.HasX509Authority(ca2) {
+		t.Fatal("Served bundle should have the new CA")
+	}
+	status = endpoint.Status()
+	if status.LastError != "" || status.SequenceNumber != 2 || !status.FetchedAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Unexpected status after a rotation: %+v", status)
+	}
+	if _, err = endpoint.GetBundleForTrustDomain(spiffeid.RequireTrustDomainFromString("other.org")); err == nil {
+		t.Fatal("Endpoint should have no bundle of other trust domains")
+	}
+
+	// CHECK only GET is allowed [ServeHTTP]
+	w = httptest.NewRecorder()
+	endpoint.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected 405 on POST, got %d", w.Code)
+	}
+}
+
+// TestEndpointTrustDomain checks the trust domain is taken from the first
+// bundle when not configured
+func TestEndpointTrustDomain(t *testing.T) {
+	ca, _ := newCA(t, "ca", time.Now().Add(time.Hour))
+	fetched := newBundle("example.org", 1, ca)
+	endpoint, err := New(Config{
+		FetchBundle:  func(ctx context.Context) (*spiffebundle.Bundle, error) { return fetched, nil },
+		FetchTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = endpoint.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status := endpoint.Status(); status.TrustDomain != "example.org" {
+		t.Fatalf("Expected trust domain example.org, got %q", status.TrustDomain)
+	}
+	fetched = newBundle("other.org", 2, ca)
+	if err = endpoint.Refresh(context.Background()); err == nil {
+		t.Fatal("Refresh should refuse bundles of another trust domain than the first one")
+	}
+
+	if _, err = New(Config{TrustDomain: "not a trust domain"}); err == nil {
+		t.Fatal("New should fail on an invalid trust domain")
+	}
+}
@@ -0,0 +1,67 @@
+package bundleendpoint
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// KeyPair is the certificate and key of the https_web profile, read from files
+// the files are read again when they change, so renewed certificates are
+// served without a restart
+type KeyPair struct {
+	certFile, keyFile string
+
+	mu              sync.Mutex
+	cert            *tls.Certificate
+	certMod, keyMod time.Time
+}
+
+// NewKeyPair reads the certificate and key
+// returns an error if they cannot be read or do not match
+func NewKeyPair(certFile, keyFile string) (*KeyPair, error) {
+	k := &KeyPair{certFile: certFile, keyFile: keyFile}
+	if err := k.reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// reload reads the files if they changed since the last read
+func (k *KeyPair) reload() error {
+	certInfo, err := os.Stat(k.certFile)
+	if err != nil {
+		return errors.Errorf("could not read certificate: %v", err)
+	}
+	keyInfo, err := os.Stat(k.keyFile)
+	if err != nil {
+		return errors.Errorf("could not read key: %v", err)
+	}
+	if k.cert != nil && certInfo.ModTime().Equal(k.certMod) && keyInfo.ModTime().Equal(k.keyMod) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		return errors.Errorf("could not load certificate and key: %v", err)
+	}
+	k.cert = &cert
+	k.certMod = certInfo.ModTime()
+	k.keyMod = keyInfo.ModTime()
+	return nil
+}
+
+// GetCertificate returns the certificate, for tls.Config
+// if the changed files cannot be loaded, for example while only one of them
+// was written, the previous certificate is served
+func (k *KeyPair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.reload(); err != nil {
+		log.Printf("WARNING: serving the previous bundle endpoint certificate: %v", err)
+	}
+	return k.cert, nil
+}
@@ -0,0 +1,85 @@
+package bundleendpoint
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKeyPair writes a new self-signed certificate and its key as PEM files
+// and returns the certificate
+func writeKeyPair(t *testing.T, certFile, keyFile, name string, mod time.Time) *x509.Certificate {
+	cert, key := newCA(t, name, time.Now().Add(time.Hour))
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err = os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{certFile, keyFile} {
+		if err = os.Chtimes(file, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cert
+}
+
+// TestKeyPair checks renewed certificates are served, and the previous one while
+// the files do not match
+func TestKeyPair(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	mod := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// ATTEMPT loading missing files; should fail [NewKeyPair]
+	if _, err := NewKeyPair(certFile, keyFile); err == nil {
+		t.Fatal("NewKeyPair should fail on missing files")
+	}
+
+	first := writeKeyPair(t, certFile, keyFile, "first", mod)
+	keyPair, err := NewKeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served, err := keyPair.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(served.Certificate[0]) != string(first.Raw) {
+		t.Fatal("Expected the first certificate")
+	}
+
+	// ATTEMPT renewing the certificate; should serve the new one [GetCertificate]
+	second := writeKeyPair(t, certFile, keyFile, "second", mod.Add(time.Hour))
+	if served, err = keyPair.GetCertificate(nil); err != nil {
+		t.Fatal(err)
+	}
+	if string(served.Certificate[0]) != string(second.Raw) {
+		t.Fatal("Expected the renewed certificate")
+	}
+
+	// ATTEMPT writing only the certificate; should keep the previous one [GetCertificate]
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKeyPair(t, certFile, keyFile, "third", mod.Add(2*time.Hour))
+	if err = os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if served, err = keyPair.GetCertificate(nil); err != nil {
+		t.Fatal(err)
+	}
+	if string(served.Certificate[0]) != string(second.Raw) {
+		t.Fatal("Expected the previous certificate while the files do not match")
+	}
+}
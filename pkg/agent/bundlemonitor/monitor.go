@@ -0,0 +1,278 @@
+package bundlemonitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/federation"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// Store is the part of the Tornjak DB check results are kept in
+type Store interface {
+	SetBundleFreshness(status types.BundleFreshness) error
+	GetBundleFreshness() (types.BundleFreshnessList, error)
+	DeleteBundleFreshness(trustDomain string) error
+}
+
+// Federation is a federation relationship of the SPIRE server
+type Federation struct {
+	TrustDomain       string
+	BundleEndpointURL string
+	// one of types.BundleEndpointProfileHTTPSWeb or types.BundleEndpointProfileHTTPSSPIFFE
+	Profile string
+	// SPIFFE ID of the endpoint for the https_spiffe profile
+	EndpointSPIFFEID string
+}
+
+type Config struct {
+	// lists the federation relationships of the SPIRE server
+	ListFederations func(ctx context.Context) ([]Federation, error)
+	// lists the bundles of the federated trust domains held by the SPIRE server
+	ListBundles func(ctx context.Context) ([]*spiffebundle.Bundle, error)
+	Store       Store
+	// called when a trust domain becomes stale or fresh again, may be nil
+	Notify func(status types.BundleFreshness)
+	// time between two checks
+	Interval time.Duration
+	// time a bundle endpoint may be unreachable or out of sync before the bundle is stale
+	StaleAfter time.Duration
+	// timeout of fetching a bundle from its endpoint
+	FetchTimeout time.Duration
+	// source of time, the clock of the system if nil
+	Clock clock.Clock
+}
+
+// fetchFunc fetches the bundle of a federation from its bundle endpoint
+// bundles authenticates https_spiffe endpoints
+type fetchFunc func(ctx context.Context, fed Federation, bundles *spiffebundle.Set) (*spiffebundle.Bundle, error)
+
+// Monitor periodically checks that the bundle endpoints of the federated trust
+// domains are reachable and that SPIRE holds the bundles they serve
+type Monitor struct {
+	config Config
+	fetch  fetchFunc
+	clock  clock.Clock
+
+	// checks read and write the previous results of the store
+	mu sync.Mutex
+}
+
+func New(config Config) *Monitor {
+	return &Monitor{config: config, fetch: fetchBundle, clock: clock.OrNew(config.Clock)}
+}
+
+// Run checks the bundles every interval until ctx is done
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := m.clock.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+	for {
+		if err := m.Check(ctx); err != nil {
+			log.Printf("WARNING: could not check federated bundles: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// Check checks the bundle of each federation relationship once and stores the results
+// results of trust domains that are no longer federated are removed
+func (m *Monitor) Check(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	feds, err := m.config.ListFederations(ctx)
+	if err != nil {
+		return errors.Errorf("could not list federation relationships: %v", err)
+	}
+	bundles, err := m.config.ListBundles(ctx)
+	if err != nil {
+		return errors.Errorf("could not list federated bundles: %v", err)
+	}
+	prevList, err := m.config.Store.GetBundleFreshness()
+	if err != nil {
+		return err
+	}
+	prev := make(map[string]types.BundleFreshness)
+	for _, status := range prevList.TrustDomains {
+		prev[status.TrustDomain] = status
+	}
+	set := spiffebundle.NewSet(bundles...)
+
+	for _, fed := range feds {
+		var local *spiffebundle.Bundle
+		if td, err := spiffeid.TrustDomainFromString(fed.TrustDomain); err == nil {
+			local, _ = set.Get(td)
+		}
+
+		fetchCtx, cancel := context.WithTimeout(ctx, m.config.FetchTimeout)
+		remote, fetchErr := m.fetch(fetchCtx, fed, set)
+		cancel()
+
+		old, known := prev[fed.TrustDomain]
+		status := evaluate(fed, old, known, local, remote, fetchErr, m.clock.Now(), m.config.StaleAfter)
+		if err := m.config.Store.SetBundleFreshness(status); err != nil {
+			return err
+		}
+		// trust domains are fresh until found stale
+		if m.config.Notify != nil && old.Stale != status.Stale {
+			m.config.Notify(status)
+		}
+		delete(prev, fed.TrustDomain)
+	}
+
+	for td := range prev {
+		if err := m.config.Store.DeleteBundleFreshness(td); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evaluate returns the check result of a federation relationship
+// old is the previous result if known is set; remote is the bundle served by
+// the endpoint unless fetchErr is set
+func evaluate(fed Federation, old types.BundleFreshness, known bool, local, remote *spiffebundle.Bundle, fetchErr error, now time.Time, staleAfter time.Duration) types.BundleFreshness {
+	status := types.BundleFreshness{
+		TrustDomain:       fed.TrustDomain,
+		BundleEndpointURL: fed.BundleEndpointURL,
+		Profile:           fed.Profile,
+		MonitoredSince:    formatTime(now),
+		CheckedAt:         formatTime(now),
+	}
+	if known {
+		status.MonitoredSince = old.MonitoredSince
+		status.LastReachableAt = old.LastReachableAt
+		status.LastInSyncAt = old.LastInSyncAt
+	}
+
+	var latestExpiry time.Time
+	if local != nil {
+		status.LocalSequence, _ = local.SequenceNumber()
+		for _, cert := range local.X509Authorities() {
+			if cert.NotAfter.After(latestExpiry) {
+				latestExpiry = cert.NotAfter
+			}
+		}
+		if !latestExpiry.IsZero() {
+			status.LocalExpiresAt = formatTime(latestExpiry)
+		}
+	}
+
+	if fetchErr != nil {
+		status.Error = fetchErr.Error()
+	} else {
+		status.Reachable = true
+		status.LastReachableAt = formatTime(now)
+		status.EndpointSequence, _ = remote.SequenceNumber()
+		if local != nil && sameAuthorities(local, remote) {
+			status.InSync = true
+			status.LastInSyncAt = formatTime(now)
+		}
+	}
+
+	// reasons are checked from the most to the least severe
+	lastReachable := orDefault(status.LastReachableAt, status.MonitoredSince)
+	lastInSync := orDefault(status.LastInSyncAt, status.MonitoredSince)
+	switch {
+	case local == nil && olderThan(status.MonitoredSince, now, staleAfter):
+		status.StaleReason = "SPIRE holds no bundle of the trust domain since " + status.MonitoredSince
+	case !latestExpiry.IsZero() && !now.Before(latestExpiry):
+		status.StaleReason = "all X.509 authorities of the bundle held by SPIRE expired at " + status.LocalExpiresAt
+	case !status.Reachable && olderThan(lastReachable, now, staleAfter):
+		status.StaleReason = "bundle endpoint unreachable since " + lastReachable
+	case status.Reachable && !status.InSync && olderThan(lastInSync, now, staleAfter):
+		status.StaleReason = "bundle held by SPIRE differs from the bundle endpoint since " + lastInSync
+	}
+	status.Stale = status.StaleReason != ""
+	return status
+}
+
+// sameAuthorities returns whether the bundles have the same X.509 and JWT authorities
+func sameAuthorities(a, b *spiffebundle.Bundle) bool {
+	aCerts, bCerts := a.X509Authorities(), b.X509Authorities()
+	if len(aCerts) != len(bCerts) {
+		return false
+	}
+	for _, cert := range aCerts {
+		found := false
+		for _, other := range bCerts {
+			if bytes.Equal(cert.Raw, other.Raw) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	aKeys, bKeys := a.JWTAuthorities(), b.JWTAuthorities()
+	if len(aKeys) != len(bKeys) {
+		return false
+	}
+	for keyID, key := range aKeys {
+		other, ok := bKeys[keyID]
+		if !ok {
+			return false
+		}
+		aDER, errA := x509.MarshalPKIXPublicKey(key)
+		bDER, errB := x509.MarshalPKIXPublicKey(other)
+		if errA != nil || errB != nil || !bytes.Equal(aDER, bDER) {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchBundle fetches the bundle from the endpoint of the federation with the
+// authentication of its profile
+func fetchBundle(ctx context.Context, fed Federation, bundles *spiffebundle.Set) (*spiffebundle.Bundle, error) {
+	td, err := spiffeid.TrustDomainFromString(fed.TrustDomain)
+	if err != nil {
+		return nil, err
+	}
+	var opts []federation.FetchOption
+	switch fed.Profile {
+	case types.BundleEndpointProfileHTTPSWeb:
+	case types.BundleEndpointProfileHTTPSSPIFFE:
+		endpointID, err := spiffeid.FromString(fed.EndpointSPIFFEID)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, federation.WithSPIFFEAuth(bundles, endpointID))
+	default:
+		return nil, fmt.Errorf("unknown bundle endpoint profile %q", fed.Profile)
+	}
+	return federation.FetchBundle(ctx, td, fed.BundleEndpointURL, opts...)
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func olderThan(timestamp string, now time.Time, d time.Duration) bool {
+	t, err := time.Parse(time.RFC3339, timestamp)
+	return err == nil && now.Sub(t) > d
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
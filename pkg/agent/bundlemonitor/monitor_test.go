@@ -0,0 +1,170 @@
+package bundlemonitor
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+type memoryStore struct {
+	statuses map[string]types.BundleFreshness
+}
+
+func (s *memoryStore) SetBundleFreshness(status types.BundleFreshness) error {
+	s.statuses[status.TrustDomain] = status
+	return nil
+}
+
+func (s *memoryStore) GetBundleFreshness() (types.BundleFreshnessList, error) {
+	list := types.BundleFreshnessList{}
+	for _, status := range s.statuses {
+		list.TrustDomains = append(list.TrustDomains, status)
+	}
+	return list, nil
+}
+
+func (s *memoryStore) DeleteBundleFreshness(trustDomain string) error {
+	delete(s.statuses, trustDomain)
+	return nil
+}
+
+// newBundle returns a bundle of the trust domain with one CA certificate expiring at notAfter
+func newBundle(t *testing.T, trustDomain string, notAfter time.Time) *spiffebundle.Bundle {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: trustDomain},
+		NotBefore:             notAfter.Add(-24 * time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := spiffebundle.New(spiffeid.RequireTrustDomainFromString(trustDomain))
+	bundle.AddX509Authority(cert)
+	return bundle
+}
+
+// TestEvaluate checks the staleness reasons of bundle check results
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	hourAgo := formatTime(now.Add(-time.Hour))
+	fed := Federation{TrustDomain: "example.org", BundleEndpointURL: "https://example.org/bundle", Profile: types.BundleEndpointProfileHTTPSWeb}
+	local := newBundle(t, "example.org", now.Add(time.Hour))
+	rotated := newBundle(t, "example.org", now.Add(2*time.Hour))
+	expired := newBundle(t, "example.org", now.Add(-time.Minute))
+	old := types.BundleFreshness{TrustDomain: "example.org", MonitoredSince: hourAgo, LastReachableAt: hourAgo, LastInSyncAt: hourAgo}
+	unreachable := errors.New("connection refused")
+
+	tests := []struct {
+		name          string
+		old           types.BundleFreshness
+		known         bool
+		local, remote *spiffebundle.Bundle
+		fetchErr      error
+		reason        string
+	}{
+		{name: "in sync", old: old, known: true, local: local, remote: local},
+		{name: "first check unreachable", local: local, fetchErr: unreachable},
+		{name: "unreachable", old: old, known: true, local: local, fetchErr: unreachable,
+			reason: "bundle endpoint unreachable since " + hourAgo},
+		{name: "out of sync", old: old, known: true, local: local, remote: rotated,
+			reason: "bundle held by SPIRE differs from the bundle endpoint since " + hourAgo},
+		{name: "expired", old: old, known: true, local: expired, remote: expired,
+			reason: "all X.509 authorities of the bundle held by SPIRE expired at " + formatTime(now.Add(-time.Minute))},
+		{name: "no local bundle", old: old, known: true, remote: local,
+			reason: "SPIRE holds no bundle of the trust domain since " + hourAgo},
+	}
+	for _, tt := range tests {
+		status := evaluate(fed, tt.old, tt.known, tt.local, tt.remote, tt.fetchErr, now, 10*time.Minute)
+		if status.StaleReason != tt.reason || status.Stale != (tt.reason != "") {
+			t.Fatalf("%s: expected stale reason %q, got %+v", tt.name, tt.reason, status)
+		}
+		if tt.fetchErr == nil && status.LastReachableAt != formatTime(now) {
+			t.Fatalf("%s: expected endpoint reachable at %s, got %+v", tt.name, formatTime(now), status)
+		}
+	}
+}
+
+// TestCheck checks results are stored, removed with their federation, and
+// transitions between stale and fresh are notified
+func TestCheck(t *testing.T) {
+	hourAgo := formatTime(time.Now().Add(-time.Hour))
+	local := newBundle(t, "example.org", time.Now().Add(time.Hour))
+	store := &memoryStore{statuses: map[string]types.BundleFreshness{
+		"example.org": {TrustDomain: "example.org", MonitoredSince: hourAgo, LastReachableAt: hourAgo, LastInSyncAt: hourAgo},
+		"removed.org": {TrustDomain: "removed.org"},
+	}}
+	notified := []types.BundleFreshness{}
+	m := New(Config{
+		ListFederations: func(ctx context.Context) ([]Federation, error) {
+			return []Federation{{TrustDomain: "example.org", Profile: types.BundleEndpointProfileHTTPSWeb}}, nil
+		},
+		ListBundles: func(ctx context.Context) ([]*spiffebundle.Bundle, error) {
+			return []*spiffebundle.Bundle{local}, nil
+		},
+		Store:        store,
+		Notify:       func(status types.BundleFreshness) { notified = append(notified, status) },
+		StaleAfter:   time.Minute,
+		FetchTimeout: time.Second,
+	})
+	reachable := false
+	m.fetch = func(ctx context.Context, fed Federation, bundles *spiffebundle.Set) (*spiffebundle.Bundle, error) {
+		if !reachable {
+			return nil, errors.New("connection refused")
+		}
+		return local, nil
+	}
+
+	// ATTEMPT check with unreachable endpoint [Check]
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK stale result stored and notified, removed federation deleted [Check]
+	if status := store.statuses["example.org"]; !status.Stale || status.Reachable {
+		t.Fatalf("Expected stale unreachable result, got %+v", status)
+	}
+	if _, ok := store.statuses["removed.org"]; ok {
+		t.Fatal("Expected result of removed federation to be deleted")
+	}
+	if len(notified) != 1 || !notified[0].Stale {
+		t.Fatalf("Expected one stale notification, got %+v", notified)
+	}
+
+	// ATTEMPT checks with endpoint reachable again [Check]
+	reachable = true
+	for i := 0; i < 2; i++ {
+		if err := m.Check(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// CHECK recovery notified once [Check]
+	if status := store.statuses["example.org"]; status.Stale || !status.InSync || status.MonitoredSince != hourAgo {
+		t.Fatalf("Expected fresh result monitored since %s, got %+v", hourAgo, status)
+	}
+	if len(notified) != 2 || notified[1].Stale {
+		t.Fatalf("Expected stale and fresh notifications, got %+v", notified)
+	}
+}
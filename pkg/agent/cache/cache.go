@@ -0,0 +1,30 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Cache stores values shared between requests, such as SPIRE list results,
+// HTTP responses and rate-limit counters. Implementations may be local to
+// the process or shared between Tornjak replicas.
+type Cache interface {
+	// Get returns the value stored under key
+	// returns false if there is no value or it expired
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+
+	// Set stores value under key, expiring after ttl
+	// a ttl of 0 stores the value without expiry
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Delete removes the values stored under keys
+	Delete(ctx context.Context, keys ...string) error
+
+	// DeletePrefix removes all values with keys starting with prefix
+	// used to invalidate groups of related values
+	DeletePrefix(ctx context.Context, prefix string) error
+
+	// Incr increments the counter stored under key and returns its new value
+	// ttl is applied when the counter is created
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+}
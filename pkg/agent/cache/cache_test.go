@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+)
+
+// testCache checks the behavior shared by all Cache implementations
+// wait lets the given duration pass for the cache implementation
+func testCache(t *testing.T, c Cache, wait func(time.Duration)) {
+	ctx := context.Background()
+
+	// CHECK missing key [Get]
+	_, ok, err := c.Get(ctx, "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("Missing key should not be found")
+	}
+
+	// ATTEMPT store and retrieve values [Set, Get]
+	err = c.Set(ctx, "entries/list", []byte("value1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Set(ctx, "entries/get", []byte("value2"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Set(ctx, "agents/list", []byte("value3"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, ok, err := c.Get(ctx, "entries/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || string(value) != "value1" {
+		t.Fatalf("Expected value1, got %q", value)
+	}
+
+	// ATTEMPT invalidate by prefix [DeletePrefix]
+	err = c.DeletePrefix(ctx, "entries/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ = c.Get(ctx, "entries/get"); ok {
+		t.Fatal("Keys with prefix should be deleted")
+	}
+	if _, ok, _ = c.Get(ctx, "agents/list"); !ok {
+		t.Fatal("Keys without prefix should be kept")
+	}
+
+	// ATTEMPT delete [Delete]
+	err = c.Delete(ctx, "agents/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ = c.Get(ctx, "agents/list"); ok {
+		t.Fatal("Deleted key should not be found")
+	}
+
+	// ATTEMPT expiry [Set]
+	err = c.Set(ctx, "expiring", []byte("value"), 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait(100 * time.Millisecond)
+	if _, ok, _ = c.Get(ctx, "expiring"); ok {
+		t.Fatal("Expired key should not be found")
+	}
+
+	// ATTEMPT counters [Incr]
+	for i := int64(1); i <= 3; i++ {
+		count, err := c.Incr(ctx, "counter", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != i {
+			t.Fatalf("Expected count %v, got %v", i, count)
+		}
+	}
+	err = c.Delete(ctx, "counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	// entries expire when the fake clock is advanced, without waiting
+	fake := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	testCache(t, NewMemoryCacheWithClock(fake), fake.Add)
+}
+
+func TestRedisCache(t *testing.T) {
+	server := miniredis.RunT(t)
+	c, err := NewRedisCache(server.Addr(), "", 0, "tornjak-test:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// miniredis only expires keys when its clock is advanced
+	testCache(t, c, server.FastForward)
+
+	// CHECK keys are stored with prefix
+	err = c.Set(context.Background(), "key", []byte("value"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !server.Exists("tornjak-test:key") {
+		t.Fatal("Expected key stored with prefix")
+	}
+}
+
+func TestEscapeRedisPattern(t *testing.T) {
+	if got := escapeRedisPattern(`a*b?[c]\`); got != `a\*b\?\[c\]\\` {
+		t.Fatalf("Unexpected escaped pattern %q", got)
+	}
+}
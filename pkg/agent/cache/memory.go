@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+)
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// MemoryCache is a Cache local to the process
+// expired entries are removed lazily on access
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	clock   clock.Clock
+}
+
+func NewMemoryCache() *MemoryCache {
+	return NewMemoryCacheWithClock(nil)
+}
+
+// NewMemoryCacheWithClock returns a cache expiring entries by c, the clock of the system if nil
+func NewMemoryCacheWithClock(c clock.Clock) *MemoryCache {
+	return &MemoryCache{
+		entries: make(map[string]memoryEntry),
+		clock:   clock.OrNew(c),
+	}
+}
+
+func expiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if e.expired(c.clock.Now()) {
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	value := make([]byte, len(e.value))
+	copy(value, e.value)
+	return value, true, nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	stored := make([]byte, len(value))
+	copy(stored, value)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = memoryEntry{
+		value:   stored,
+		expires: expiry(c.clock.Now(), ttl),
+	}
+	return nil
+}
+
+func (c *MemoryCache) Delete(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+func (c *MemoryCache) DeletePrefix(ctx context.Context, prefix string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+	return nil
+}
+
+func (c *MemoryCache) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	e, ok := c.entries[key]
+	if !ok || e.expired(now) {
+		e = memoryEntry{expires: expiry(now, ttl)}
+	}
+	var count int64
+	if len(e.value) > 0 {
+		var err error
+		count, err = strconv.ParseInt(string(e.value), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+	count++
+	e.value = []byte(strconv.FormatInt(count, 10))
+	c.entries[key] = e
+	return count, nil
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// number of keys requested per SCAN call when deleting by prefix
+const redisScanCount = 100
+
+// RedisCache is a Cache stored in Redis and shared between Tornjak replicas
+// all keys are stored with keyPrefix so several deployments can share a Redis instance
+type RedisCache struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+// NewRedisCache connects to the Redis server at address and checks it is reachable
+func NewRedisCache(address string, password string, db int, keyPrefix string) (*RedisCache, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     address,
+		Password: password,
+		DB:       db,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, errors.Errorf("Unable to connect to Redis at %s: %v", address, err)
+	}
+	return &RedisCache{
+		client:    client,
+		keyPrefix: keyPrefix,
+	}, nil
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := c.client.Get(ctx, c.keyPrefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, c.keyPrefix+key, value, ttl).Err()
+}
+
+func (c *RedisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.keyPrefix + key
+	}
+	return c.client.Del(ctx, prefixed...).Err()
+}
+
+func (c *RedisCache) DeletePrefix(ctx context.Context, prefix string) error {
+	match := escapeRedisPattern(c.keyPrefix+prefix) + "*"
+	var cursor uint64
+	for {
+		keys, next, err := c.client.Scan(ctx, cursor, match, redisScanCount).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err = c.client.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (c *RedisCache) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	count, err := c.client.Incr(ctx, c.keyPrefix+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 && ttl > 0 {
+		if err = c.client.Expire(ctx, c.keyPrefix+key, ttl).Err(); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// Close closes the connection to Redis
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
+
+// escapeRedisPattern escapes glob characters so s is matched literally by SCAN MATCH
+func escapeRedisPattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
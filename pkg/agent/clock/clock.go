@@ -0,0 +1,153 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of the current time and of tickers
+// components take a Clock so time-dependent behaviors, such as expiry,
+// TTLs and schedules, can be tested without waiting
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks at intervals, like time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// New returns the clock of the system
+func New() Clock {
+	return realClock{}
+}
+
+// OrNew returns c, or the clock of the system if c is nil
+func OrNew(c Clock) Clock {
+	if c == nil {
+		return New()
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTicker) Stop() {
+	t.t.Stop()
+}
+
+// Fake is a Clock for tests that only moves when told to
+// tickers fire when the clock is moved past their next tick
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// NewTicker returns a ticker firing every d of fake time
+// panics if d is not positive, like time.NewTicker
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for clock.Fake.NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTicker{
+		clock:  f,
+		c:      make(chan time.Time, 1),
+		period: d,
+		next:   f.now.Add(d),
+	}
+	f.tickers = append(f.tickers, t)
+	return t
+}
+
+// Tickers returns the number of tickers not stopped,
+// so tests can wait for a scheduler to start before moving the clock
+func (f *Fake) Tickers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.tickers)
+}
+
+// Add moves the clock forward by d
+func (f *Fake) Add(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.set(f.now.Add(d))
+}
+
+// Set moves the clock to now
+// tickers fire at most once per move, as ticks are dropped for slow receivers
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.set(now)
+}
+
+func (f *Fake) set(now time.Time) {
+	f.now = now
+	for _, t := range f.tickers {
+		if t.next.After(now) {
+			continue
+		}
+		select {
+		case t.c <- now:
+		default:
+		}
+		for !t.next.After(now) {
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock  *Fake
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	f := t.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, other := range f.tickers {
+		if other == t {
+			f.tickers = append(f.tickers[:i], f.tickers[i+1:]...)
+			return
+		}
+	}
+}
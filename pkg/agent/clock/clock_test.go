@@ -0,0 +1,78 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+// TestFake checks the fake clock only moves when told to and fires due tickers once per move
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	if !c.Now().Equal(start) {
+		t.Fatalf("Expected %v, got %v", start, c.Now())
+	}
+
+	ticker := c.NewTicker(time.Minute)
+	if c.Tickers() != 1 {
+		t.Fatalf("Expected 1 ticker, got %d", c.Tickers())
+	}
+
+	// ATTEMPT move before the first tick
+	c.Add(30 * time.Second)
+	// CHECK no tick
+	select {
+	case tick := <-ticker.C():
+		t.Fatalf("Unexpected tick at %v", tick)
+	default:
+	}
+
+	// ATTEMPT move past several ticks
+	c.Add(5 * time.Minute)
+	// CHECK a single tick at the new time
+	select {
+	case tick := <-ticker.C():
+		if !tick.Equal(start.Add(5*time.Minute + 30*time.Second)) {
+			t.Fatalf("Unexpected tick at %v", tick)
+		}
+	default:
+		t.Fatal("Expected tick")
+	}
+	select {
+	case tick := <-ticker.C():
+		t.Fatalf("Unexpected second tick at %v", tick)
+	default:
+	}
+
+	// ATTEMPT move to the next tick, at 6m
+	c.Set(start.Add(6 * time.Minute))
+	// CHECK tick
+	select {
+	case <-ticker.C():
+	default:
+		t.Fatal("Expected tick")
+	}
+
+	// ATTEMPT stop the ticker
+	ticker.Stop()
+	c.Add(time.Hour)
+	// CHECK no tick once stopped
+	select {
+	case tick := <-ticker.C():
+		t.Fatalf("Unexpected tick at %v after stop", tick)
+	default:
+	}
+	if c.Tickers() != 0 {
+		t.Fatalf("Expected no ticker, got %d", c.Tickers())
+	}
+}
+
+func TestOrNew(t *testing.T) {
+	fake := NewFake(time.Unix(0, 0))
+	if OrNew(fake) != Clock(fake) {
+		t.Fatal("Expected given clock")
+	}
+	if _, ok := OrNew(nil).(realClock); !ok {
+		t.Fatal("Expected clock of the system")
+	}
+}
@@ -0,0 +1,118 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// SpecVersion is the version of the CloudEvents specification of the events
+const SpecVersion = "1.0"
+
+// Source is the source of the events of the audit log, whose IDs are unique
+// within a DataStore
+const Source = "/tornjak/audit"
+
+// TypePrefix prefixes the types of the events, followed by the type of the
+// object and the action, e.g. io.spiffe.tornjak.cluster.create
+const TypePrefix = "io.spiffe.tornjak."
+
+// content type of the data of the events
+const dataContentType = "application/json"
+
+// schemaVersion is the version of the data schemas of the events, increased
+// on incompatible changes of the data
+const schemaVersion = 1
+
+// schemas of the states of the objects before and after a change
+var stateSchemas = map[string]string{
+	types.AuditObjectCluster: `{"type": "object", "description": "cluster, as returned by GET /api/v1/tornjak/clusters",
+  "properties": {"uid": {"type": "string"}, "name": {"type": "string"}, "platformType": {"type": "string"},
+    "agentsList": {"type": "array", "items": {"type": "string"}}}}`,
+	types.AuditObjectAgent: `{"type": "object", "required": ["spiffeid"],
+  "properties": {"spiffeid": {"type": "string"}, "plugin": {"type": "string"}, "displayName": {"type": "string"},
+    "labels": {"type": "object", "additionalProperties": {"type": "string"}}}}`,
+	types.AuditObjectMembership: `{"type": "object", "required": ["clusterUid", "cluster"],
+  "properties": {"clusterUid": {"type": "string"}, "cluster": {"type": "string"}}}`,
+}
+
+// eventTypes are the types of events, by object type and action, with their descriptions
+var eventTypes = []struct {
+	objectType, action, description string
+}{
+	{types.AuditObjectCluster, types.AuditActionCreate, "Cluster created"},
+	{types.AuditObjectCluster, types.AuditActionEdit, "Cluster edited, including renames"},
+	{types.AuditObjectCluster, types.AuditActionDelete, "Cluster deleted"},
+	{types.AuditObjectCluster, types.AuditActionRestore, "Deleted cluster restored with its UID"},
+	{types.AuditObjectAgent, types.AuditActionCreate, "Metadata of an agent first stored"},
+	{types.AuditObjectAgent, types.AuditActionEdit, "Plugin type, display name or labels of an agent changed"},
+	{types.AuditObjectMembership, types.AuditActionCreate, "Agent assigned to a cluster"},
+	{types.AuditObjectMembership, types.AuditActionDelete, "Agent removed from a cluster"},
+}
+
+// Type returns the type of the events of a change of an object
+func Type(objectType, action string) string {
+	return TypePrefix + objectType + "." + action
+}
+
+// dataSchema returns the identifier of the data schema of an event type
+func dataSchema(eventType string) string {
+	return fmt.Sprintf("urn:tornjak:schema:%s:v%d", eventType, schemaVersion)
+}
+
+// Schemas returns the schema registry of the event types
+func Schemas() types.EventSchemaList {
+	list := types.EventSchemaList{Schemas: []types.EventSchema{}}
+	for _, t := range eventTypes {
+		eventType := Type(t.objectType, t.action)
+		state := stateSchemas[t.objectType]
+		schema := fmt.Sprintf(`{"type": "object", "required": ["id", "action", "objectType", "objectId", "timestamp"],
+  "properties": {"id": {"type": "integer"}, "actor": {"type": "string"}, "action": {"enum": [%q]},
+    "objectType": {"enum": [%q]}, "objectId": {"type": "string"}, "clusterUid": {"type": "string"},
+    "before": %s, "after": %s, "timestamp": {"type": "string", "format": "date-time"},
+    "changes": {"type": "array", "items": {"type": "object", "required": ["field"],
+      "properties": {"field": {"type": "string"}, "before": {}, "after": {}}}}}}`, t.action, t.objectType, state, state)
+		list.Schemas = append(list.Schemas, types.EventSchema{
+			Type:        eventType,
+			Version:     schemaVersion,
+			DataSchema:  dataSchema(eventType),
+			Description: t.description,
+			Schema:      json.RawMessage(schema),
+		})
+	}
+	return list
+}
+
+// FromAuditEvent returns the CloudEvent of an event of the audit log
+func FromAuditEvent(e types.AuditEvent) (types.CloudEvent, error) {
+	return newEvent(e, e)
+}
+
+// FromClusterHistoryEvent returns the CloudEvent of an event of the history
+// of a cluster, whose data has the changed fields
+func FromClusterHistoryEvent(e types.ClusterHistoryEvent) (types.CloudEvent, error) {
+	return newEvent(e.AuditEvent, e)
+}
+
+func newEvent(e types.AuditEvent, data interface{}) (types.CloudEvent, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return types.CloudEvent{}, errors.Errorf("could not encode event %d: %v", e.ID, err)
+	}
+	eventType := Type(e.ObjectType, e.Action)
+	return types.CloudEvent{
+		SpecVersion:     SpecVersion,
+		ID:              strconv.FormatInt(e.ID, 10),
+		Source:          Source,
+		Type:            eventType,
+		Subject:         e.ObjectId,
+		Time:            e.Timestamp,
+		DataContentType: dataContentType,
+		DataSchema:      dataSchema(eventType),
+		Data:            encoded,
+	}, nil
+}
@@ -0,0 +1,88 @@
+package cloudevents
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+func TestSchemas(t *testing.T) {
+	schemas := map[string]types.EventSchema{}
+	for _, s := range Schemas().Schemas {
+		if _, ok := schemas[s.Type]; ok {
+			t.Fatalf("Schema of %s registered twice", s.Type)
+		}
+		schema := &openapi3.Schema{}
+		if err := json.Unmarshal(s.Schema, schema); err != nil {
+			t.Fatalf("Invalid schema of %s: %v", s.Type, err)
+		}
+		if err := schema.Validate(context.Background()); err != nil {
+			t.Fatalf("Invalid schema of %s: %v", s.Type, err)
+		}
+		schemas[s.Type] = s
+	}
+
+	events := []types.ClusterHistoryEvent{
+		{AuditEvent: types.AuditEvent{ID: 1, Actor: "admin", Action: types.AuditActionCreate, ObjectType: types.AuditObjectCluster,
+			ObjectId: "0123456789abcdef0123456789abcdef", ClusterUID: "0123456789abcdef0123456789abcdef",
+			After: json.RawMessage(`{"uid":"0123456789abcdef0123456789abcdef","name":"prod","agentsList":["agent1"]}`), Timestamp: "2024-05-01T10:00:00Z"}},
+		{AuditEvent: types.AuditEvent{ID: 2, Action: types.AuditActionEdit, ObjectType: types.AuditObjectCluster,
+			ObjectId: "0123456789abcdef0123456789abcdef", ClusterUID: "0123456789abcdef0123456789abcdef",
+			Before: json.RawMessage(`{"name":"prod"}`), After: json.RawMessage(`{"name":"production"}`), Timestamp: "2024-05-01T10:01:00Z"},
+			Changes: []types.FieldChange{{Field: "name", Before: "prod", After: "production"}}},
+		{AuditEvent: types.AuditEvent{ID: 3, Action: types.AuditActionCreate, ObjectType: types.AuditObjectMembership,
+			ObjectId: "spiffe://example.org/agent1", ClusterUID: "0123456789abcdef0123456789abcdef",
+			After: json.RawMessage(`{"clusterUid":"0123456789abcdef0123456789abcdef","cluster":"prod"}`), Timestamp: "2024-05-01T10:02:00Z"}},
+		{AuditEvent: types.AuditEvent{ID: 4, Action: types.AuditActionEdit, ObjectType: types.AuditObjectAgent,
+			ObjectId: "spiffe://example.org/agent1", Before: json.RawMessage(`{"spiffeid":"spiffe://example.org/agent1"}`),
+			After: json.RawMessage(`{"spiffeid":"spiffe://example.org/agent1","labels":{"env":"prod"}}`), Timestamp: "2024-05-01T10:03:00Z"}},
+	}
+	for _, e := range events {
+		event, err := FromClusterHistoryEvent(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, ok := schemas[event.Type]
+		if !ok {
+			t.Fatalf("No schema of %s", event.Type)
+		}
+		if event.SpecVersion != SpecVersion || event.Source != Source || event.Subject != e.ObjectId ||
+			event.Time != e.Timestamp || event.DataSchema != s.DataSchema {
+			t.Fatalf("Unexpected event %+v", event)
+		}
+
+		// CHECK data of the event matches the schema of its type
+		schema := &openapi3.Schema{}
+		if err = json.Unmarshal(s.Schema, schema); err != nil {
+			t.Fatal(err)
+		}
+		var data interface{}
+		if err = json.Unmarshal(event.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		if err = schema.VisitJSON(data); err != nil {
+			t.Fatalf("Data of event %s does not match its schema: %v", event.ID, err)
+		}
+	}
+
+	// CHECK events of another type are rejected by the schema
+	event, err := FromAuditEvent(events[2].AuditEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := &openapi3.Schema{}
+	if err = json.Unmarshal(schemas[Type(types.AuditObjectCluster, types.AuditActionCreate)].Schema, schema); err != nil {
+		t.Fatal(err)
+	}
+	var data interface{}
+	if err = json.Unmarshal(event.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if err = schema.VisitJSON(data); err == nil {
+		t.Fatal("Expected membership event to not match the schema of cluster creates")
+	}
+}
@@ -0,0 +1,109 @@
+package collation
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// collations of names in sorted lists
+const (
+	// Binary orders names by their bytes, so "Zeta" sorts before "alpha"
+	Binary = "binary"
+	// NoCase orders names ignoring case
+	NoCase = "nocase"
+	// Unicode orders names by the Unicode Collation Algorithm with the rules
+	// of a locale, as ICU does
+	Unicode = "unicode"
+)
+
+// Collation compares names for sorting
+// names equal under the collation are ordered by their bytes, so sorts are
+// stable across calls and backends
+type Collation struct {
+	name string
+	// BCP 47 language tag of the rules of Unicode, empty for the root locale
+	locale string
+
+	// guards collator and fold, which are not safe for concurrent use
+	mu       sync.Mutex
+	collator *collate.Collator
+	fold     cases.Caser
+}
+
+// New returns the collation of name, Binary if empty
+// locale is a BCP 47 language tag used by Unicode, the root locale if empty
+func New(name string, locale string) (*Collation, error) {
+	c := &Collation{name: name}
+	switch name {
+	case "", Binary:
+		c.name = Binary
+	case NoCase:
+		c.fold = cases.Fold()
+	case Unicode:
+		tag := language.Und
+		if len(locale) > 0 {
+			var err error
+			tag, err = language.Parse(locale)
+			if err != nil {
+				return nil, errors.Errorf("invalid collation locale %q", locale)
+			}
+		}
+		c.collator = collate.New(tag)
+		if len(locale) > 0 {
+			c.locale = tag.String()
+		}
+	default:
+		return nil, errors.Errorf("invalid collation %q", name)
+	}
+	if name != Unicode && len(locale) > 0 {
+		return nil, errors.Errorf("locale is only used by the %s collation", Unicode)
+	}
+	return c, nil
+}
+
+// Name returns the name of the collation
+func (c *Collation) Name() string {
+	return c.name
+}
+
+// Locale returns the BCP 47 language tag of a Unicode collation, empty for the root locale
+func (c *Collation) Locale() string {
+	return c.locale
+}
+
+// Compare returns -1, 0 or 1 if a sorts before, equal to or after b
+func (c *Collation) Compare(a, b string) int {
+	var r int
+	switch c.name {
+	case NoCase:
+		c.mu.Lock()
+		r = strings.Compare(c.fold.String(a), c.fold.String(b))
+		c.mu.Unlock()
+	case Unicode:
+		c.mu.Lock()
+		r = c.collator.CompareString(a, b)
+		c.mu.Unlock()
+	}
+	if r != 0 {
+		return r
+	}
+	return strings.Compare(a, b)
+}
+
+// Sort sorts items by the name key returns
+func Sort[T any](c *Collation, items []T, key func(T) string) {
+	slices.SortStableFunc(items, func(a, b T) int {
+		return c.Compare(key(a), key(b))
+	})
+}
+
+// Strings sorts names
+func (c *Collation) Strings(names []string) {
+	Sort(c, names, func(s string) string { return s })
+}
@@ -0,0 +1,43 @@
+package collation
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestStrings checks names are sorted by each collation, with ties in byte order
+func TestStrings(t *testing.T) {
+	names := []string{"beta", "Alpha", "alpha", "Zeta", "Émile", "eve"}
+	expected := map[string]string{
+		Binary:  "Alpha,Zeta,alpha,beta,eve,Émile",
+		NoCase:  "Alpha,alpha,beta,eve,Zeta,Émile",
+		Unicode: "alpha,Alpha,beta,Émile,eve,Zeta",
+	}
+	for name, order := range expected {
+		c, err := New(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sorted := append([]string{}, names...)
+		c.Strings(sorted)
+		if got := strings.Join(sorted, ","); got != order {
+			t.Fatalf("Expected %s order %s, got %s", name, order, got)
+		}
+	}
+}
+
+// TestNew checks invalid collations and locales are rejected
+func TestNew(t *testing.T) {
+	if c, err := New("", ""); err != nil || c.Name() != Binary {
+		t.Fatalf("Expected binary default collation, got %v, %v", c, err)
+	}
+	if _, err := New(Unicode, "sv"); err != nil {
+		t.Fatal(err)
+	}
+	invalid := [][2]string{{"icu", ""}, {Unicode, "not a locale"}, {NoCase, "sv"}}
+	for _, args := range invalid {
+		if _, err := New(args[0], args[1]); err == nil {
+			t.Fatalf("Expected collation %q with locale %q to be invalid", args[0], args[1])
+		}
+	}
+}
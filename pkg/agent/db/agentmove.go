@@ -0,0 +1,104 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// maximum number of agents named in the failure of a move
+const maxMoveFailureAgents = 10
+
+// MoveAgentsTx is the transaction of a move of agents between clusters, held
+// by the tx helper of a DataStore
+type MoveAgentsTx struct {
+	// LockCluster returns the name of the cluster with the UID and whether it
+	// exists, locking it until the end of the transaction
+	LockCluster func(uid string) (string, bool, error)
+	// ClusterAgents returns the agents of spiffeids in the cluster
+	ClusterAgents func(name string, spiffeids []string) ([]string, error)
+	// MoveMemberships re-points the memberships of the agents from cluster
+	// from to cluster to, marking the agents and both clusters changed
+	MoveMemberships func(from string, to string, spiffeids []string) error
+	// RecordClusterHistory adds the change of the cluster to history
+	RecordClusterHistory func(name string, change string) error
+}
+
+// MoveAgents moves the agents of spiffeids from the cluster with UID fromUID
+// to the cluster with UID toUID in the transaction of tx, all or none
+// returns PostFailure if a cluster does not exist or an agent is not in the
+// cluster it is moved from
+func MoveAgents(tx MoveAgentsTx, fromUID string, toUID string, spiffeids []string) (types.AgentMoveResult, error) {
+	if fromUID == toUID {
+		return types.AgentMoveResult{}, PostFailure{Message: "Agents must be moved to another cluster"}
+	}
+	listed := make(map[string]bool, len(spiffeids))
+	unique := make([]string, 0, len(spiffeids))
+	for _, spiffeid := range spiffeids {
+		if !listed[spiffeid] {
+			listed[spiffeid] = true
+			unique = append(unique, spiffeid)
+		}
+	}
+	if len(unique) == 0 {
+		return types.AgentMoveResult{}, PostFailure{Message: "No agents to move"}
+	}
+
+	// LOCK both clusters, in the order of their UIDs so concurrent moves
+	// between the same clusters cannot deadlock
+	uids := []string{fromUID, toUID}
+	sort.Strings(uids)
+	names := make(map[string]string, 2)
+	for _, uid := range uids {
+		name, found, err := tx.LockCluster(uid)
+		if err != nil {
+			return types.AgentMoveResult{}, err
+		} else if !found {
+			return types.AgentMoveResult{}, ClusterMissingFailure(fmt.Sprintf("Cluster with UID %s does not exist", uid))
+		}
+		names[uid] = name
+	}
+	from, to := names[fromUID], names[toUID]
+
+	// CHECK all agents are in the cluster they are moved from
+	members, err := tx.ClusterAgents(from, unique)
+	if err != nil {
+		return types.AgentMoveResult{}, err
+	}
+	if len(members) < len(unique) {
+		return types.AgentMoveResult{}, notMembersFailure(from, unique, members)
+	}
+
+	// UPDATE memberships, and ADD both clusters to history
+	if err = tx.MoveMemberships(from, to, unique); err != nil {
+		return types.AgentMoveResult{}, err
+	}
+	for _, name := range []string{from, to} {
+		if err = tx.RecordClusterHistory(name, types.ClusterChangeUpdated); err != nil {
+			return types.AgentMoveResult{}, err
+		}
+	}
+	return types.AgentMoveResult{FromCluster: from, ToCluster: to, Moved: len(unique)}, nil
+}
+
+// notMembersFailure is the PostFailure naming the agents of spiffeids that are
+// not members of the cluster
+func notMembersFailure(cluster string, spiffeids []string, members []string) PostFailure {
+	isMember := make(map[string]bool, len(members))
+	for _, spiffeid := range members {
+		isMember[spiffeid] = true
+	}
+	missing := []string{}
+	for _, spiffeid := range spiffeids {
+		if !isMember[spiffeid] {
+			missing = append(missing, spiffeid)
+		}
+	}
+	message := strings.Join(missing, ", ")
+	if len(missing) > maxMoveFailureAgents {
+		message = fmt.Sprintf("%s and %d more", strings.Join(missing[:maxMoveFailureAgents], ", "), len(missing)-maxMoveFailureAgents)
+	}
+	return PostFailure{Message: fmt.Sprintf("Agents not in cluster %s: %s", cluster, message)}
+}
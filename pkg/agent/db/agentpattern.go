@@ -0,0 +1,60 @@
+package db
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// MaxAgentPatternLength is the maximum length of a SPIFFE ID pattern, that of
+// a SPIFFE ID
+const MaxAgentPatternLength = 2048
+
+// AgentPattern is a pattern of SPIFFE IDs of agents, such as
+// spiffe://example.org/agent/node-*, where * matches any characters, ? any one
+// character and other characters themselves, case-sensitively
+// a prefix is written with a trailing *; the DataStores find the matches from
+// the characters before the first wildcard with the index of SPIFFE IDs
+type AgentPattern struct {
+	// the pattern for LIKE ... ESCAPE '!'
+	Like string
+	// the pattern for the GLOB of sqlite, whose LIKE is case-insensitive
+	Glob string
+}
+
+// ParseAgentPattern translates a SPIFFE ID pattern for the SQL databases
+func ParseAgentPattern(pattern string) (AgentPattern, error) {
+	if len(pattern) == 0 {
+		return AgentPattern{}, errors.New("empty SPIFFE ID pattern")
+	}
+	if len(pattern) > MaxAgentPatternLength {
+		return AgentPattern{}, errors.Errorf("SPIFFE ID pattern longer than %d bytes", MaxAgentPatternLength)
+	}
+	if !utf8.ValidString(pattern) {
+		return AgentPattern{}, errors.New("SPIFFE ID pattern is not valid UTF-8")
+	}
+
+	var like, glob strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			like.WriteByte('%')
+			glob.WriteByte('*')
+		case '?':
+			like.WriteByte('_')
+			glob.WriteByte('?')
+		case '%', '_', '!':
+			like.WriteByte('!')
+			like.WriteRune(r)
+			glob.WriteRune(r)
+		case '[':
+			like.WriteRune(r)
+			glob.WriteString("[[]")
+		default:
+			like.WriteRune(r)
+			glob.WriteRune(r)
+		}
+	}
+	return AgentPattern{Like: like.String(), Glob: glob.String()}, nil
+}
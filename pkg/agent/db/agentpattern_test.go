@@ -0,0 +1,32 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAgentPattern(t *testing.T) {
+	// CHECK wildcards are translated and special characters escaped
+	p, err := ParseAgentPattern("spiffe://example.org/agent_[1]/node-*?%!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Like != "spiffe://example.org/agent!_[1]/node-%_!%!!" {
+		t.Fatalf("Unexpected LIKE pattern %q", p.Like)
+	}
+	if p.Glob != "spiffe://example.org/agent_[[]1]/node-*?%!" {
+		t.Fatalf("Unexpected GLOB pattern %q", p.Glob)
+	}
+
+	// CHECK patterns without wildcards match the SPIFFE ID only
+	if p, err = ParseAgentPattern("spiffe://example.org/agent"); err != nil || p.Like != "spiffe://example.org/agent" || p.Glob != p.Like {
+		t.Fatalf("Unexpected pattern %+v, %v", p, err)
+	}
+
+	// CHECK invalid patterns
+	for _, pattern := range []string{"", strings.Repeat("a", MaxAgentPatternLength+1), "spiffe://\xff"} {
+		if _, err = ParseAgentPattern(pattern); err == nil {
+			t.Fatalf("Expected pattern %q to fail", pattern)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package db
+
+import (
+	"sort"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// SortAgentAnnotations orders annotations by SPIFFE ID then key, in binary
+// order so the DataStores list them alike whatever the collation of their database
+func SortAgentAnnotations(annotations []types.AgentAnnotation) {
+	sort.Slice(annotations, func(i, j int) bool {
+		if annotations[i].Spiffeid != annotations[j].Spiffeid {
+			return annotations[i].Spiffeid < annotations[j].Spiffeid
+		}
+		return annotations[i].Key < annotations[j].Key
+	})
+}
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// AuditEventColumns maps the fields audit events can be filtered on to the
+// columns of table audit_events
+var AuditEventColumns = map[string]string{
+	"actor":      "actor",
+	"action":     "action",
+	"objectType": "object_type",
+	"objectId":   "object_id",
+	"clusterUid": "cluster_uid",
+}
+
+// actions of the audit events of the changes recorded in the history of
+// clusters; the states of clusters recorded before their history are not changes
+var clusterAuditActions = map[string]string{
+	types.ClusterChangeCreated:  types.AuditActionCreate,
+	types.ClusterChangeUpdated:  types.AuditActionEdit,
+	types.ClusterChangeDeleted:  types.AuditActionDelete,
+	types.ClusterChangeRestored: types.AuditActionRestore,
+}
+
+// ClusterAuditEvents returns the audit events of a change of the cluster with
+// the given UID recorded in its history, given the snapshots of the cluster
+// before and after the change as stored in cluster_history, empty if it did
+// not exist
+// edits are recorded when a field of the cluster changes, and the agents
+// added to and removed from the cluster as changes of their memberships
+func ClusterAuditEvents(change string, uid string, before string, after string) ([]types.AuditEvent, error) {
+	action, ok := clusterAuditActions[change]
+	if !ok {
+		return nil, nil
+	}
+	beforeInfo, err := parseAuditCluster(before)
+	if err != nil {
+		return nil, err
+	}
+	afterInfo, err := parseAuditCluster(after)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []types.AuditEvent{}
+	same, err := sameClusterFields(beforeInfo, afterInfo)
+	if err != nil {
+		return nil, err
+	}
+	if action != types.AuditActionEdit || !same {
+		events = append(events, types.AuditEvent{Action: action, ObjectType: types.AuditObjectCluster, ObjectId: uid,
+			ClusterUID: uid, Before: AuditState(before), After: AuditState(after)})
+	}
+
+	// an edit of a cluster without prior history has no agents to compare to
+	if action == types.AuditActionEdit && beforeInfo == nil {
+		return events, nil
+	}
+	beforeAgents, afterAgents := map[string]bool{}, map[string]bool{}
+	if beforeInfo != nil {
+		for _, spiffeid := range beforeInfo.AgentsList {
+			beforeAgents[spiffeid] = true
+		}
+	}
+	if afterInfo != nil {
+		for _, spiffeid := range afterInfo.AgentsList {
+			afterAgents[spiffeid] = true
+		}
+	}
+	for _, spiffeid := range sortedKeys(beforeAgents) {
+		if !afterAgents[spiffeid] {
+			state, err := json.Marshal(types.AuditMembership{ClusterUID: uid, Cluster: beforeInfo.Name})
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, types.AuditEvent{Action: types.AuditActionDelete,
+				ObjectType: types.AuditObjectMembership, ObjectId: spiffeid, ClusterUID: uid, Before: state})
+		}
+	}
+	for _, spiffeid := range sortedKeys(afterAgents) {
+		if !beforeAgents[spiffeid] {
+			state, err := json.Marshal(types.AuditMembership{ClusterUID: uid, Cluster: afterInfo.Name})
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, types.AuditEvent{Action: types.AuditActionCreate,
+				ObjectType: types.AuditObjectMembership, ObjectId: spiffeid, ClusterUID: uid, After: state})
+		}
+	}
+	return events, nil
+}
+
+// ClusterHistory returns the events of a page of the audit log filtered on a
+// cluster, with the fields changed by each cluster event
+func ClusterHistory(events types.List[types.AuditEvent]) (types.List[types.ClusterHistoryEvent], error) {
+	history := types.List[types.ClusterHistoryEvent]{Items: []types.ClusterHistoryEvent{},
+		NextCursor: events.NextCursor, Total: events.Total}
+	for _, e := range events.Items {
+		event := types.ClusterHistoryEvent{AuditEvent: e}
+		if e.ObjectType == types.AuditObjectCluster {
+			before, err := parseAuditCluster(string(e.Before))
+			if err != nil {
+				return types.List[types.ClusterHistoryEvent]{}, err
+			}
+			after, err := parseAuditCluster(string(e.After))
+			if err != nil {
+				return types.List[types.ClusterHistoryEvent]{}, err
+			}
+			if before == nil {
+				before = &types.ClusterInfo{}
+			}
+			if after == nil {
+				after = &types.ClusterInfo{}
+			}
+			event.Changes = types.DiffClusters(*before, *after)
+		}
+		history.Items = append(history.Items, event)
+	}
+	return history, nil
+}
+
+// AgentAuditEvent returns the audit event of a change of an agent, given its
+// state before and after the change, nil before it was stored
+// returns false if the agent did not change
+func AgentAuditEvent(before *types.AuditAgent, after *types.AuditAgent) (types.AuditEvent, bool, error) {
+	if after == nil {
+		return types.AuditEvent{}, false, nil
+	}
+	afterState, err := json.Marshal(after)
+	if err != nil {
+		return types.AuditEvent{}, false, errors.Errorf("Invalid state of agent %s: %v", after.Spiffeid, err)
+	}
+	event := types.AuditEvent{Action: types.AuditActionCreate, ObjectType: types.AuditObjectAgent,
+		ObjectId: after.Spiffeid, After: afterState}
+	if before != nil {
+		beforeState, err := json.Marshal(before)
+		if err != nil {
+			return types.AuditEvent{}, false, errors.Errorf("Invalid state of agent %s: %v", before.Spiffeid, err)
+		}
+		if bytes.Equal(beforeState, afterState) {
+			return types.AuditEvent{}, false, nil
+		}
+		event.Action, event.Before = types.AuditActionEdit, beforeState
+	}
+	return event, true, nil
+}
+
+// AuditState returns the state of an object stored with an audit event, nil if empty
+func AuditState(state string) json.RawMessage {
+	if state == "" {
+		return nil
+	}
+	return json.RawMessage(state)
+}
+
+func parseAuditCluster(snapshot string) (*types.ClusterInfo, error) {
+	if snapshot == "" {
+		return nil, nil
+	}
+	cinfo := types.ClusterInfo{}
+	if err := json.Unmarshal([]byte(snapshot), &cinfo); err != nil {
+		return nil, errors.Errorf("Invalid cluster history record: %v", err)
+	}
+	return &cinfo, nil
+}
+
+// sameClusterFields returns whether two states of a cluster only differ in
+// their agents and time of change
+func sameClusterFields(a *types.ClusterInfo, b *types.ClusterInfo) (bool, error) {
+	if a == nil || b == nil {
+		return false, nil
+	}
+	fields := func(c types.ClusterInfo) ([]byte, error) {
+		c.AgentsList, c.UpdatedAt = nil, c.CreationTime
+		return json.Marshal(c)
+	}
+	fieldsA, err := fields(*a)
+	if err != nil {
+		return false, err
+	}
+	fieldsB, err := fields(*b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(fieldsA, fieldsB), nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
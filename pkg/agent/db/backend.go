@@ -0,0 +1,82 @@
+package db
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+)
+
+// BackendConfig is the configuration of a DataStore plugin, passed to the
+// factory of its backend
+type BackendConfig struct {
+	// Data is the plugin_data of the DataStore, nil if not populated
+	Data ast.Node
+	// Clock stamps creation and change times, the clock of the system if nil
+	Clock clock.Clock
+}
+
+// Decode decodes the plugin_data into v, the pointer to a struct with hcl tags
+// v is left unchanged if the plugin_data is not populated
+func (c BackendConfig) Decode(v interface{}) error {
+	if c.Data == nil {
+		return nil
+	}
+	if err := hcl.DecodeObject(v, c.Data); err != nil {
+		return errors.Errorf("Couldn't parse DB config: %v", err)
+	}
+	return nil
+}
+
+// BackendFactory creates the AgentDB of a DataStore from its configuration
+type BackendFactory func(config BackendConfig) (AgentDB, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]BackendFactory)
+)
+
+// RegisterBackend makes a DataStore backend available under the given name,
+// the name of the DataStore plugin in the Tornjak configuration. It is meant
+// to be called from the init function of the package implementing the
+// backend, so it is compiled in with a blank import.
+// It panics if the name is registered twice or the factory is nil.
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if factory == nil {
+		panic("db: RegisterBackend factory is nil")
+	}
+	if _, dup := backends[name]; dup {
+		panic("db: RegisterBackend called twice for backend " + name)
+	}
+	backends[name] = factory
+}
+
+// Backends returns the sorted names of the registered backends
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewBackend creates the AgentDB of the backend registered under the given name
+func NewBackend(name string, config BackendConfig) (AgentDB, error) {
+	backendsMu.RLock()
+	factory, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("Unknown DataStore %q, registered DataStores: %s", name, strings.Join(Backends(), ", "))
+	}
+	return factory(config)
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	backoff "github.com/cenkalti/backoff/v4"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+)
+
+type testBackendConfig struct {
+	Name string `hcl:"name"`
+}
+
+// testBackendData returns the plugin_data of a DataStore configuration
+func testBackendData(t *testing.T, config string) ast.Node {
+	file, err := hcl.Parse(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Node
+}
+
+// TestRegisterBackend checks backends are created by the factory registered under their name
+func TestRegisterBackend(t *testing.T) {
+	var decoded testBackendConfig
+	RegisterBackend("test-backend", func(config BackendConfig) (AgentDB, error) {
+		if err := config.Decode(&decoded); err != nil {
+			return nil, err
+		}
+		return NewInMemoryDB(backoff.NewExponentialBackOff(), SqliteOptions{})
+	})
+
+	found := false
+	for _, name := range Backends() {
+		found = found || name == "test-backend"
+	}
+	if !found {
+		t.Fatalf("Backends should list test-backend, got %v", Backends())
+	}
+
+	// CHECK the factory gets the plugin_data [NewBackend, BackendConfig.Decode]
+	db, err := NewBackend("test-backend", BackendConfig{Data: testBackendData(t, `name = "spanner"`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db == nil || decoded.Name != "spanner" {
+		t.Fatalf("Factory should decode the plugin_data, got %+v", decoded)
+	}
+
+	// CHECK missing plugin_data leaves the config unchanged [BackendConfig.Decode]
+	decoded = testBackendConfig{Name: "default"}
+	if _, err = NewBackend("test-backend", BackendConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Name != "default" {
+		t.Fatalf("Missing plugin_data should leave the config unchanged, got %+v", decoded)
+	}
+
+	// CHECK unknown backend [NewBackend]
+	_, err = NewBackend("no-such-backend", BackendConfig{})
+	if err == nil || !strings.Contains(err.Error(), "test-backend") {
+		t.Fatalf("Unknown backend should fail listing the registered ones, got %v", err)
+	}
+
+	// CHECK duplicate registration [RegisterBackend]
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Registering test-backend twice should panic")
+		}
+	}()
+	RegisterBackend("test-backend", func(config BackendConfig) (AgentDB, error) { return nil, nil })
+}
@@ -0,0 +1,29 @@
+package db
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// MaxChangeLogEntries is the largest number of entries of the history of
+// clusters returned at once by GetClusterChangeLog
+const MaxChangeLogEntries = 1000
+
+// ChangeLogSnapshot returns the state of a cluster stored in its history with
+// a change, nil for deletes, which store none
+func ChangeLogSnapshot(change string, snapshot string) (*types.ClusterInfo, error) {
+	if change == types.ClusterChangeDeleted {
+		return nil, nil
+	}
+	cinfo := types.ClusterInfo{}
+	if err := json.Unmarshal([]byte(snapshot), &cinfo); err != nil {
+		return nil, errors.Errorf("Invalid cluster history record: %v", err)
+	}
+	if cinfo.AgentsList == nil {
+		cinfo.AgentsList = []string{}
+	}
+	return &cinfo, nil
+}
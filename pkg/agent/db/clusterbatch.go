@@ -0,0 +1,146 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// ClusterBatchTx is the transaction of a bulk creation of clusters, held by
+// the tx helper of a DataStore
+type ClusterBatchTx struct {
+	// Savepoint runs fn in a savepoint of the transaction, so a failure of fn
+	// rolls back the changes of fn only; returns the error of fn, or SQLError
+	// if the savepoint fails
+	Savepoint func(fn func() error) error
+	// InsertCluster inserts the metadata of a cluster, returns PostFailure if
+	// a cluster with its name exists
+	InsertCluster func(cinfo types.ClusterInfo) error
+	// AddClusterContents adds the agents, extension fields and labels of a
+	// cluster just inserted, and records it in the history
+	AddClusterContents func(cinfo types.ClusterInfo) error
+}
+
+// CreateClusterBatch creates each cluster of cinfos in the transaction of tx,
+// in a savepoint per cluster so the clusters that cannot be created are left
+// out and the others are created
+// the result has an item per cluster, by position in cinfos:
+// succeeded if created, skipped with BatchCodeAlreadyExists if a cluster with
+// its name exists, failed otherwise
+// returns an error, upon which the transaction must be rolled back, only for
+// failures of the transaction itself
+func CreateClusterBatch(tx ClusterBatchTx, cinfos []types.ClusterInfo) (types.BatchResult, error) {
+	batch := types.NewBatchResult()
+	first := make(map[string]int, len(cinfos))
+	for i, cinfo := range cinfos {
+		item := types.BatchItem{Index: i, ID: cinfo.Name, Status: types.BatchItemSucceeded}
+		if j, ok := first[cinfo.Name]; ok {
+			item.Status, item.Code = types.BatchItemFailed, types.BatchCodeDuplicate
+			item.Error = fmt.Sprintf("cluster %s already created by item %d", cinfo.Name, j)
+			batch.Add(item)
+			continue
+		}
+		first[cinfo.Name] = i
+		if _, err := MetadataValue(cinfo.Metadata); err != nil {
+			item.Status, item.Code, item.Error = types.BatchItemFailed, types.BatchCodeInvalidArgument, err.Error()
+			batch.Add(item)
+			continue
+		}
+
+		inserted := false
+		err := tx.Savepoint(func() error {
+			if err := tx.InsertCluster(cinfo); err != nil {
+				return err
+			}
+			inserted = true
+			return tx.AddClusterContents(cinfo)
+		})
+		var failure PostFailure
+		switch {
+		case err == nil:
+		case !errors.As(err, &failure):
+			return types.BatchResult{}, err
+		case !inserted:
+			item.Status, item.Code, item.Error = types.BatchItemSkipped, types.BatchCodeAlreadyExists, failure.Message
+		default:
+			item.Status, item.Code, item.Error = types.BatchItemFailed, types.BatchCodeFailedPrecondition, failure.Message
+		}
+		batch.Add(item)
+	}
+	return batch, nil
+}
+
+// ClusterDeleteBatchTx is the transaction of a bulk deletion of clusters, held
+// by the tx helper of a DataStore
+type ClusterDeleteBatchTx struct {
+	// Savepoint runs fn in a savepoint of the transaction, as in ClusterBatchTx
+	Savepoint func(fn func() error) error
+	// LockCluster returns the name of the cluster with the UID and whether it
+	// exists, locking it until the end of the transaction
+	LockCluster func(uid string) (string, bool, error)
+	// LockDeletableCluster returns PostFailure if the cluster is protected
+	LockDeletableCluster func(name string) error
+	// DeleteCluster deletes the cluster with its memberships, labels and
+	// extension fields, and records the deletion in the history
+	DeleteCluster func(name string) error
+}
+
+// DeleteClusterBatch deletes each cluster of uids in the transaction of tx, in
+// a savepoint per cluster so the clusters that cannot be deleted are left and
+// the others are deleted
+// the result has an item per UID, by position in uids:
+// succeeded if deleted, failed with BatchCodeNotFound if no cluster has the
+// UID, with BatchCodePermissionDenied if the cluster is protected
+// returns an error, upon which the transaction must be rolled back, only for
+// failures of the transaction itself
+func DeleteClusterBatch(tx ClusterDeleteBatchTx, uids []string) (types.BatchResult, error) {
+	batch := types.NewBatchResult()
+	first := make(map[string]int, len(uids))
+	for i, uid := range uids {
+		item := types.BatchItem{Index: i, ID: uid, Status: types.BatchItemSucceeded}
+		if uid == "" {
+			item.Status, item.Code, item.Error = types.BatchItemFailed, types.BatchCodeInvalidArgument, "missing UID"
+			batch.Add(item)
+			continue
+		}
+		if j, ok := first[uid]; ok {
+			item.Status, item.Code = types.BatchItemFailed, types.BatchCodeDuplicate
+			item.Error = fmt.Sprintf("cluster with UID %s already deleted by item %d", uid, j)
+			batch.Add(item)
+			continue
+		}
+		first[uid] = i
+
+		found, deletable := true, true
+		err := tx.Savepoint(func() error {
+			name, ok, err := tx.LockCluster(uid)
+			if err != nil {
+				return err
+			} else if !ok {
+				found = false
+				return ClusterMissingFailure(fmt.Sprintf("Cluster with UID %s does not exist", uid))
+			}
+			if err = tx.LockDeletableCluster(name); err != nil {
+				deletable = false
+				return err
+			}
+			return tx.DeleteCluster(name)
+		})
+		var failure PostFailure
+		switch {
+		case err == nil:
+		case !errors.As(err, &failure):
+			return types.BatchResult{}, err
+		case !found:
+			item.Status, item.Code, item.Error = types.BatchItemFailed, types.BatchCodeNotFound, failure.Message
+		case !deletable:
+			item.Status, item.Code, item.Error = types.BatchItemFailed, types.BatchCodePermissionDenied, failure.Message
+		default:
+			item.Status, item.Code, item.Error = types.BatchItemFailed, types.BatchCodeFailedPrecondition, failure.Message
+		}
+		batch.Add(item)
+	}
+	return batch, nil
+}
@@ -0,0 +1,22 @@
+package db
+
+import "regexp"
+
+// format of the UIDs of the clusters created by the DataStores
+var clusterUIDFormat = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// ValidateClusterUID returns a PostFailure if uid is not in the format of the
+// UIDs generated by the DataStores, 32 lowercase hex digits, so UIDs set by
+// clients cannot be told apart from generated ones
+func ValidateClusterUID(uid string) error {
+	if !clusterUIDFormat.MatchString(uid) {
+		return PostFailure{Message: "Invalid cluster UID " + uid + "; expected 32 lowercase hex digits"}
+	}
+	return nil
+}
+
+// DeletedClusterUIDFailure returns the PostFailure of an upsert of a cluster
+// with the UID of a deleted cluster, which would prevent its restore
+func DeletedClusterUIDFailure(uid string) PostFailure {
+	return PostFailure{Message: "Cluster with UID " + uid + " is deleted; restore it rather than create it again"}
+}
@@ -1,6 +1,8 @@
 package db
 
 import (
+	"context"
+
 	"github.com/spiffe/tornjak/pkg/agent/types"
 )
 
@@ -8,16 +10,236 @@ type AgentDB interface {
 	// AGENT - SELECTOR/PLUGIN interface
 	CreateAgentEntry(sinfo types.AgentInfo) error
 	GetAgentSelectors() (types.AgentInfoList, error)
+	GetAgentSelectorsPage(opts types.ListOptions) (types.List[types.AgentInfo], error)
 	GetAgentPluginInfo(name string) (types.AgentInfo, error)
+	SetAgentDisplayName(spiffeid string, displayName string) error
+	FindAgentsByPattern(pattern string, opts types.ListOptions) (types.List[string], error)
+	SetAgentAnnotation(annotation types.AgentAnnotation) error
+	DeleteAgentAnnotation(spiffeid string, key string) error
+	GetAgentAnnotations(spiffeids []string) (types.AgentAnnotationList, error)
+	GetPluginTypes() (types.PluginTypeList, error)
 
 	// CLUSTER interface
 	GetClusters() (types.ClusterInfoList, error)
+	GetClustersPage(opts types.ListOptions) (types.List[types.ClusterInfo], error)
 	CreateClusterEntry(cinfo types.ClusterInfo) error
-	EditClusterEntry(cinfo types.ClusterInfo) error
+	CreateClusterEntries(cinfos []types.ClusterInfo) (types.BatchResult, error)
+	EditClusterEntry(cinfo types.ClusterInfo) (types.ClusterEditResult, error)
+	CreateOrUpdateClusterEntry(cinfo types.ClusterInfo) (types.ClusterUpsertResult, error)
 	DeleteClusterEntry(name string) error
+	DeleteClusterEntries(uids []string) (types.BatchResult, error)
+	RenameClusterEntry(name string, newName string) (types.ClusterEditResult, error)
+	SetClusterProtection(name string, protected bool) error
+	RestoreClusterEntry(uid string) (types.ClusterRestoreResult, error)
+	ListDeletedClusters() (types.DeletedClusterList, error)
+	GetClustersAsOf(asOf string) (types.ClusterInfoList, error)
+	GetClusterChanges(limit int) ([]types.ClusterChange, error)
+	GetClusterChangeLog(after int64, limit int) (types.ClusterChangeLog, error)
+	SearchClusters(query string) (types.ClusterInfoList, error)
 
 	// AGENT - CLUSTER Get interface (for testing)e
 	GetAgentClusterName(spiffeid string) (string, error)
+	GetAgentClusterNames(spiffeids []string) (map[string]string, error)
 	GetClusterAgents(name string) ([]string, error)
+	GetClusterAgentsPage(name string, opts types.ListOptions) (types.List[string], error)
 	GetAgentsMetadata(req types.AgentMetadataRequest) (types.AgentInfoList, error)
+
+	// SPIRE QUERY LOG interface
+	AddSPIRECallRecord(call types.SPIRECallInfo) error
+	GetSPIRECallRecords(opts types.ListOptions) (types.List[types.SPIRECallInfo], error)
+
+	// ENTRY LINEAGE interface
+	CreateEntryLineage(lineage types.EntryLineage) error
+	GetEntryLineage(entryId string) (types.EntryLineage, error)
+
+	// AGENT COMPLIANCE interface
+	AddAgentComplianceReport(report types.AgentComplianceReport) error
+	GetAgentComplianceHistory(spiffeid string, attribute string, limit int) (types.AgentComplianceHistory, error)
+
+	// SERVICE ACCOUNT interface
+	CreateServiceAccount(account types.ServiceAccount, keyHash string) error
+	GetServiceAccounts() (types.ServiceAccountList, error)
+	GetServiceAccountByKeyHash(keyHash string) (types.ServiceAccount, error)
+	DeleteServiceAccount(name string) error
+
+	// CLUSTER TOKEN interface
+	GetClusterNameByUID(uid string) (string, error)
+	CreateClusterToken(token types.ClusterToken, keyHash string) error
+	GetClusterTokens() (types.ClusterTokenList, error)
+	GetClusterTokenByKeyHash(keyHash string) (types.ClusterToken, error)
+	DeleteClusterToken(name string) error
+
+	// OWNERSHIP interface
+	SetEntryOwner(owner types.EntryOwner) error
+	GetEntryOwners(team string) (types.EntryOwnerList, error)
+	TransferOwnership(transfer types.OwnershipTransfer) (types.OwnershipTransferResult, error)
+	GetOwnershipTransfers(opts types.ListOptions) (types.List[types.OwnershipTransferRecord], error)
+
+	// BUNDLE FRESHNESS interface
+	SetBundleFreshness(status types.BundleFreshness) error
+	GetBundleFreshness() (types.BundleFreshnessList, error)
+	DeleteBundleFreshness(trustDomain string) error
+
+	// BOOTSTRAP TOKEN interface
+	CreateBootstrapToken(token types.BootstrapToken, tokenHash string) error
+	GetBootstrapTokens(state string) (types.BootstrapTokenList, error)
+	ConsumeBootstrapToken(tokenHash string, agentID string, consumedAt string) (bool, error)
+	ExpireBootstrapTokens(now string) (int64, error)
+
+	// ENTRY LIFECYCLE interface
+	SetEntryLifecycle(lifecycle types.EntryLifecycle) error
+	GetEntryLifecycles(state string) (types.EntryLifecycleList, error)
+	MarkEntryLifecycleNotified(entryId string, notifiedAt string) error
+	MarkEntryRemoved(entryId string, removedAt string) (bool, error)
+
+	// NOTE interface
+	CreateNote(note types.Note) (int64, error)
+	EditNote(id int64, body string, editedBy string, editedAt string) error
+	DeleteNote(id int64) error
+	GetNotes(objectType string, objectIds []string) (types.NoteList, error)
+	GetNoteRevisions(id int64) (types.NoteRevisionList, error)
+
+	// FAILED OPERATION interface
+	AddFailedOperation(op types.FailedOperation) (int64, error)
+	GetFailedOperation(id int64) (types.FailedOperation, error)
+	GetFailedOperations(state string) (types.FailedOperationList, error)
+	UpdateFailedOperation(op types.FailedOperation) error
+
+	// AGENT ASSIGNMENT interface
+	AssignAgentsToClusters(assignments []types.AgentAssignment, dryRun bool) (types.AgentAssignmentResult, error)
+	MoveAgentsBetweenClusters(fromUID string, toUID string, spiffeids []string) (types.AgentMoveResult, error)
+
+	// LABEL interface
+	ApplyLabelOperation(op types.LabelOperation) (types.LabelOperationResult, error)
+
+	// AUDIT interface
+	// WithActor returns a view of the DB recording actor as the user making its changes in the audit log
+	WithActor(actor string) AgentDB
+	GetAuditEvents(opts types.ListOptions) (types.List[types.AuditEvent], error)
+
+	// TRANSACTION METRICS interface
+	GetTxStats() types.TxStats
+}
+
+// Backupper is implemented by AgentDBs that can write a consistent copy of
+// their data while in use
+type Backupper interface {
+	// Backup writes a copy of the DB to a new file at path
+	Backup(path string) error
+}
+
+// Migrator is implemented by AgentDBs with a versioned schema, migrated up
+// to the latest version when opened
+type Migrator interface {
+	// SchemaVersion returns the version of the schema and the latest version known to this Tornjak
+	SchemaVersion() (current int, latest int, err error)
+	// MigrateDown reverts the migrations above version, so an older Tornjak can use the DB
+	MigrateDown(version int) error
+}
+
+// ProgressAssigner is implemented by AgentDBs that report the progress of
+// agent assignments, so asynchronous jobs can show how far they got
+type ProgressAssigner interface {
+	// AssignAgentsToClustersWithProgress is AssignAgentsToClusters, calling progress
+	// with the number of agents applied and to apply as they are written
+	AssignAgentsToClustersWithProgress(assignments []types.AgentAssignment, dryRun bool, progress func(applied int, total int)) (types.AgentAssignmentResult, error)
+}
+
+// BackgroundClusterDeleter is implemented by AgentDBs that can remove the
+// memberships of a deleted cluster after the cluster, in batches, so deleting a
+// cluster with many agents does not hold the DB in one long transaction
+type BackgroundClusterDeleter interface {
+	// DeleteClusterEntryInBackground deletes the cluster as DeleteClusterEntry
+	// does if it has fewer than minMemberships agents, and returns nil;
+	// otherwise it deletes all of the cluster but the memberships of its
+	// agents, which are no longer read, and returns the deletion removing them
+	DeleteClusterEntryInBackground(name string, minMemberships int) (*types.ClusterDeletion, error)
+	// DeleteClusterEntriesInBackground deletes the clusters with the given
+	// UIDs as DeleteClusterEntries does, leaving the memberships of those with
+	// minMemberships agents or more to deletions
+	DeleteClusterEntriesInBackground(uids []string, minMemberships int) (types.BatchResult, error)
+	// DeleteClusterMemberships removes up to limit memberships of the deletion
+	// with the given ID, ending the deletion once none remain
+	// returns the number of memberships remaining
+	DeleteClusterMemberships(id int64, limit int) (int, error)
+	// GetClusterDeletions returns the deletions not ended yet, oldest first,
+	// including those interrupted by a restart
+	GetClusterDeletions() (types.ClusterDeletionList, error)
+}
+
+// IndexAdvisor is implemented by AgentDBs on SQL databases recording the
+// statistics of their statements, to suggest the indexes missing for them
+type IndexAdvisor interface {
+	// GetIndexReport returns the statements taking minMillis or more on
+	// average, the indexes of the DB and the missing indexes
+	GetIndexReport(minMillis float64) (types.IndexReport, error)
+}
+
+// Snapshotter is implemented by AgentDBs that can keep named copies of their
+// data and roll back to them
+type Snapshotter interface {
+	// CreateSnapshot keeps a copy of the DB under name
+	CreateSnapshot(name string, createdBy string) (types.Snapshot, error)
+	GetSnapshots() (types.SnapshotList, error)
+	// RestoreSnapshot replaces the data of the DB with the snapshot
+	RestoreSnapshot(name string) error
+	DeleteSnapshot(name string) error
+}
+
+// Pinger is implemented by AgentDBs that can check their database is
+// reachable, so the server can detect outages and recoveries
+type Pinger interface {
+	// Ping returns an error if the database cannot be reached
+	Ping(ctx context.Context) error
+}
+
+// IntegrityChecker is implemented by AgentDBs that can check their rows for
+// inconsistencies left by earlier releases or changes made outside Tornjak
+type IntegrityChecker interface {
+	// CheckIntegrity runs the integrity checks, storing the report in place of the previous one
+	CheckIntegrity(ctx context.Context) (types.IntegrityReport, error)
+	// GetIntegrityReport returns the report of the last check, empty if none ran
+	GetIntegrityReport() (types.IntegrityReport, error)
+}
+
+// SecretStore is implemented by AgentDBs that can keep secrets of the server,
+// such as the pepper of the hashes of API keys; values are stored as given,
+// encrypted by the caller
+type SecretStore interface {
+	// GetOrCreateSecret stores value under name unless a value is stored
+	// under it, and returns the stored value
+	GetOrCreateSecret(name string, value []byte) ([]byte, error)
+	// UpdateSecret replaces the value stored under name, e.g. reencrypted
+	// under a new key
+	UpdateSecret(name string, value []byte) error
+}
+
+// capabilities of AgentDB that a DataStore may not store, see CapabilityChecker
+const (
+	CapabilitySPIREQueryLog      = "the SPIRE query log"
+	CapabilityEntryLineage       = "entry lineage"
+	CapabilityAgentCompliance    = "agent compliance"
+	CapabilityServiceAccounts    = "service accounts"
+	CapabilityClusterTokens      = "cluster tokens"
+	CapabilityEntryOwnership     = "entry ownership"
+	CapabilityOwnershipTransfers = "ownership transfers"
+	CapabilityBundleFreshness    = "bundle freshness"
+	CapabilityBootstrapTokens    = "bootstrap tokens"
+	CapabilityEntryLifecycles    = "entry lifecycles"
+	CapabilityRetryQueue         = "the retry queue"
+)
+
+// CapabilityChecker is implemented by AgentDBs that do not store every
+// capability of AgentDB; the methods of the capabilities they do not store
+// return UnsupportedError, so the server disables the features relying on them
+type CapabilityChecker interface {
+	// Supports returns whether the capability, one of the Capability constants, is stored
+	Supports(capability string) bool
+}
+
+// Supports returns whether db stores the capability, true unless db is a
+// CapabilityChecker not supporting it
+func Supports(db AgentDB, capability string) bool {
+	checker, ok := db.(CapabilityChecker)
+	return !ok || checker.Supports(capability)
 }
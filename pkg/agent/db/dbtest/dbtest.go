@@ -0,0 +1,884 @@
+// Package dbtest is the conformance suite of the DataStores, run against the
+// sqlite, inmem, postgres and mysql DataStores so they behave the same
+package dbtest
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// Opener returns an empty DataStore stamping creation and change times with
+// clk, the clock of the system if nil, for the life of the test
+type Opener func(t *testing.T, clk clock.Clock) agentdb.AgentDB
+
+// Run runs the conformance suite against the DataStores returned by open,
+// one per test
+func Run(t *testing.T, open Opener) {
+	t.Run("Agents", func(t *testing.T) { testAgents(t, open) })
+	t.Run("Clusters", func(t *testing.T) { testClusters(t, open) })
+	t.Run("Notes", func(t *testing.T) { testNotes(t, open) })
+	t.Run("AgentAnnotations", func(t *testing.T) { testAgentAnnotations(t, open) })
+	t.Run("DeletedClusters", func(t *testing.T) { testDeletedClusters(t, open) })
+	t.Run("ClusterRename", func(t *testing.T) { testClusterRename(t, open) })
+	t.Run("ClusterBulkCreate", func(t *testing.T) { testClusterBulkCreate(t, open) })
+	t.Run("ClusterBulkDelete", func(t *testing.T) { testClusterBulkDelete(t, open) })
+	t.Run("MoveAgentsBetweenClusters", func(t *testing.T) { testMoveAgentsBetweenClusters(t, open) })
+	t.Run("ClusterUpsert", func(t *testing.T) { testClusterUpsert(t, open) })
+	t.Run("AuditEvents", func(t *testing.T) { testAuditEvents(t, open) })
+	t.Run("ClusterAuditHistory", func(t *testing.T) { testClusterAuditHistory(t, open) })
+}
+
+// testAgents checks plugin types and display names of agents, their pages and SPIFFE ID patterns
+func testAgents(t *testing.T, open Opener) {
+	db := open(t, nil)
+
+	// ATTEMPT register plugins and display names
+	if err := db.CreateAgentEntry(types.AgentInfo{Spiffeid: "spiffe://example.org/b", Plugin: "k8s"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateAgentEntry(types.AgentInfo{Spiffeid: "spiffe://example.org/a", Plugin: "My-Attestor"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateAgentEntry(types.AgentInfo{Spiffeid: "spiffe://example.org/c", Plugin: "my-attestor"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetAgentDisplayName("spiffe://example.org/a", "Agent A"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateAgentEntry(types.AgentInfo{Spiffeid: "spiffe://example.org/d", Plugin: "\x00"}); err == nil {
+		t.Fatal("Expected error on invalid plugin")
+	}
+
+	// CHECK agents with their normalized plugin types
+	agents, err := db.GetAgentSelectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []types.AgentInfo{
+		{Spiffeid: "spiffe://example.org/a", Plugin: "My-Attestor", DisplayName: "Agent A"},
+		{Spiffeid: "spiffe://example.org/b", Plugin: types.PluginTypeKubernetes},
+		{Spiffeid: "spiffe://example.org/c", Plugin: "My-Attestor"},
+	}
+	if !reflect.DeepEqual(agents.Agents, expected) {
+		t.Fatalf("Expected agents %+v, got %+v", expected, agents.Agents)
+	}
+	pluginTypes, err := db.GetPluginTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pluginTypes.PluginTypes) != len(types.KnownPluginTypes)+1 {
+		t.Fatalf("Expected known plugin types and one custom type, got %+v", pluginTypes.PluginTypes)
+	}
+	if _, err = db.GetAgentPluginInfo("spiffe://example.org/unknown"); err == nil {
+		t.Fatal("Expected error on agent without plugin")
+	}
+
+	// CHECK search and plugin filters of metadata
+	metadata, err := db.GetAgentsMetadata(types.AgentMetadataRequest{Search: "agent a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.Agents) != 1 || metadata.Agents[0].Spiffeid != "spiffe://example.org/a" {
+		t.Fatalf("Unexpected search result %+v", metadata.Agents)
+	}
+	metadata, err = db.GetAgentsMetadata(types.AgentMetadataRequest{Plugin: "kubernetes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.Agents) != 1 || metadata.Agents[0].Spiffeid != "spiffe://example.org/b" {
+		t.Fatalf("Unexpected plugin filter result %+v", metadata.Agents)
+	}
+
+	// CHECK pages of metadata and selectors follow the SPIFFE IDs
+	metadata, err = db.GetAgentsMetadata(types.AgentMetadataRequest{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Total != 3 || len(metadata.Agents) != 2 || metadata.Agents[1].Spiffeid != "spiffe://example.org/b" {
+		t.Fatalf("Unexpected first page %+v", metadata)
+	}
+	metadata, err = db.GetAgentsMetadata(types.AgentMetadataRequest{Limit: 2, Cursor: metadata.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.Agents) != 1 || metadata.Agents[0].Spiffeid != "spiffe://example.org/c" || metadata.NextCursor != "" {
+		t.Fatalf("Unexpected last page %+v", metadata)
+	}
+	selectors, err := db.GetAgentSelectorsPage(types.ListOptions{Limit: 1, Filters: []types.Filter{{Field: "plugin", Value: "My-Attestor"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selectors.Total != 2 || len(selectors.Items) != 1 || selectors.Items[0].Spiffeid != "spiffe://example.org/a" {
+		t.Fatalf("Unexpected page of selectors %+v", selectors)
+	}
+
+	// CHECK SPIFFE ID patterns match case-sensitively, with escaped LIKE characters
+	if err = db.CreateAgentEntry(types.AgentInfo{Spiffeid: "spiffe://example.org/A_1", Plugin: "k8s"}); err != nil {
+		t.Fatal(err)
+	}
+	found, err := db.FindAgentsByPattern("spiffe://example.org/?", types.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(found.Items, []string{"spiffe://example.org/a", "spiffe://example.org/b", "spiffe://example.org/c"}) {
+		t.Fatalf("Unexpected agents matching the pattern %+v", found)
+	}
+	if found, err = db.FindAgentsByPattern("spiffe://example.org/A_*", types.ListOptions{}); err != nil || found.Total != 1 {
+		t.Fatalf("Unexpected agents matching the prefix %+v, %v", found, err)
+	}
+	if found, err = db.FindAgentsByPattern("spiffe://example.org/a_*", types.ListOptions{}); err != nil || found.Total != 0 {
+		t.Fatalf("Expected patterns to be case-sensitive, got %+v, %v", found, err)
+	}
+}
+
+// testClusters checks clusters with their agents, labels, extension fields and history
+func testClusters(t *testing.T, open Opener) {
+	fake := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	db := open(t, fake)
+	agent1, agent2, agent3 := "spiffe://example.org/agent1", "spiffe://example.org/agent2", "spiffe://example.org/agent3"
+
+	// ATTEMPT create clusters
+	cluster1 := types.ClusterInfo{
+		Name:         "cluster1",
+		PlatformType: "Kubernetes",
+		AgentsList:   []string{agent2, agent1},
+		Labels:       map[string]string{"env": "prod"},
+		Extensions:   map[string]interface{}{"region": "eu-de"},
+		Metadata:     json.RawMessage(`{"costCenter": "CC-1"}`),
+	}
+	if err := db.CreateClusterEntry(cluster1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateClusterEntry(types.ClusterInfo{Name: "cluster2", AgentsList: []string{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK conflicts rejected
+	var pf agentdb.PostFailure
+	if err := db.CreateClusterEntry(types.ClusterInfo{Name: "cluster1"}); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on existing cluster, got %v", err)
+	}
+	if err := db.CreateClusterEntry(types.ClusterInfo{Name: "cluster3", AgentsList: []string{agent1}}); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on assigned agent, got %v", err)
+	}
+	if err := db.CreateClusterEntry(types.ClusterInfo{Name: "cluster3", AgentsList: []string{agent3, agent3}}); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on agent listed twice, got %v", err)
+	}
+
+	// CHECK clusters with agents, labels, extension fields and metadata
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters.Clusters) != 2 {
+		t.Fatalf("Expected 2 clusters, got %+v", clusters.Clusters)
+	}
+	got := clusters.Clusters[0]
+	if got.Name != "cluster1" || got.UID == "" || !got.CreationTime.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) ||
+		!reflect.DeepEqual(got.AgentsList, []string{agent1, agent2}) ||
+		!reflect.DeepEqual(got.Labels, cluster1.Labels) || !reflect.DeepEqual(got.Extensions, cluster1.Extensions) ||
+		string(got.Metadata) != `{"costCenter":"CC-1"}` {
+		t.Fatalf("Unexpected cluster %+v", got)
+	}
+	uid := got.UID
+	if name, err := db.GetClusterNameByUID(uid); err != nil || name != "cluster1" {
+		t.Fatalf("Expected cluster1 by UID, got %q: %v", name, err)
+	}
+	if name, err := db.GetAgentClusterName(agent2); err != nil || name != "cluster1" {
+		t.Fatalf("Expected cluster1 of agent2, got %q: %v", name, err)
+	}
+
+	// CHECK clusters selected by labels, after the arguments of a filter
+	sel, err := types.ParseLabelSelector("env in (prod, staging),!team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := db.GetClustersPage(types.ListOptions{Filters: []types.Filter{{Field: "platformType", Value: "Kubernetes"}}, LabelSelector: sel})
+	if err != nil || page.Total != 1 || page.Items[0].Name != "cluster1" {
+		t.Fatalf("Expected cluster1 selected by labels, got %+v: %v", page, err)
+	}
+	sel, err = types.ParseLabelSelector("env!=prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err = db.GetClustersPage(types.ListOptions{LabelSelector: sel})
+	if err != nil || page.Total != 1 || page.Items[0].Name != "cluster2" {
+		t.Fatalf("Expected cluster2 selected by labels, got %+v: %v", page, err)
+	}
+
+	// ATTEMPT rename and edit cluster1
+	fake.Add(time.Hour)
+	edit := cluster1
+	edit.EditedName = "cluster1-renamed"
+	edit.AgentsList = []string{agent3}
+	edit.Labels = nil
+	result, err := db.EditClusterEntry(edit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK changes reported and agents moved
+	fields := []string{}
+	for _, change := range result.Changes {
+		fields = append(fields, change.Field)
+	}
+	if !reflect.DeepEqual(fields, []string{"name", "agentsList", "labels"}) {
+		t.Fatalf("Unexpected changes %+v", result.Changes)
+	}
+	agents, err := db.GetClusterAgents("cluster1-renamed")
+	if err != nil || !reflect.DeepEqual(agents, []string{agent3}) {
+		t.Fatalf("Expected agent3 in renamed cluster, got %v: %v", agents, err)
+	}
+	names, err := db.GetAgentClusterNames([]string{agent1, agent3})
+	if err != nil || !reflect.DeepEqual(names, map[string]string{agent3: "cluster1-renamed"}) {
+		t.Fatalf("Unexpected cluster names %v: %v", names, err)
+	}
+
+	// CHECK search by the words of the new name and the platform type
+	found, err := db.SearchClusters("Kubernetes renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found.Clusters) != 1 || found.Clusters[0].Name != "cluster1-renamed" || len(found.Clusters[0].AgentsList) != 1 {
+		t.Fatalf("Expected renamed cluster found, got %+v", found.Clusters)
+	}
+
+	// ATTEMPT assign agents by cluster UID
+	assignment, err := db.AssignAgentsToClusters([]types.AgentAssignment{
+		{Row: 1, Spiffeid: agent1, ClusterUID: uid},
+		{Row: 2, Spiffeid: agent3, ClusterUID: uid},
+		{Row: 3, Spiffeid: agent2, ClusterUID: "unknown"},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assignment.Assigned != 1 || assignment.Unchanged != 1 || len(assignment.Errors) != 1 {
+		t.Fatalf("Unexpected assignment %+v", assignment)
+	}
+
+	// ATTEMPT label all clusters
+	labelResult, err := db.ApplyLabelOperation(types.LabelOperation{
+		Target: types.LabelTargetClusters, Action: types.LabelActionAdd, Key: "team", Value: "payments",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labelResult.Matched != 2 || len(labelResult.Changes) != 2 {
+		t.Fatalf("Unexpected label operation %+v", labelResult)
+	}
+
+	// CHECK protected clusters are not deleted until their protection is cleared
+	if err = db.SetClusterProtection("cluster2", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteClusterEntry("cluster2"); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on protected cluster, got %v", err)
+	}
+	if err = db.SetClusterProtection("cluster2", true); err != nil {
+		t.Fatal(err)
+	}
+	clusters, err = db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters.Clusters) != 2 || !clusters.Clusters[1].Protected {
+		t.Fatalf("Expected cluster2 to be protected, got %+v", clusters.Clusters)
+	}
+	if err = db.SetClusterProtection("cluster2", false); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SetClusterProtection("unknown", false); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on unknown cluster, got %v", err)
+	}
+
+	// ATTEMPT delete cluster2
+	fake.Add(time.Hour)
+	if err = db.DeleteClusterEntry("cluster2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteClusterEntry("cluster2"); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on deleted cluster, got %v", err)
+	}
+
+	// CHECK history
+	asOf, err := db.GetClustersAsOf("2024-01-01T00:30:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(asOf.Clusters) != 2 || asOf.Clusters[0].Name != "cluster1" || !reflect.DeepEqual(asOf.Clusters[0].AgentsList, []string{agent1, agent2}) {
+		t.Fatalf("Unexpected clusters as of creation %+v", asOf.Clusters)
+	}
+	changes, err := db.GetClusterChanges(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 9 || changes[0].Name != "cluster2" || changes[0].Change != types.ClusterChangeDeleted ||
+		changes[0].ChangedAt != "2024-01-01T02:00:00Z" {
+		t.Fatalf("Unexpected changes %+v", changes)
+	}
+
+	stats := db.GetTxStats()
+	if len(stats.Operations) == 0 {
+		t.Fatal("Expected transaction stats")
+	}
+}
+
+// testNotes checks notes are created, edited with their history and deleted
+func testNotes(t *testing.T, open Opener) {
+	db := open(t, nil)
+
+	// ATTEMPT create notes of a cluster and an agent [CreateNote]
+	id, err := db.CreateNote(types.Note{ObjectType: types.NoteObjectCluster, ObjectId: "uid1",
+		Body: "Being **migrated**, do not touch", Author: "alice", CreatedAt: "2024-03-01T12:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.CreateNote(types.Note{ObjectType: types.NoteObjectAgent, ObjectId: "spiffe://example.org/a",
+		Body: "Rebuilt", Author: "bob", CreatedAt: "2024-03-01T13:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT edit the note of the cluster [EditNote]
+	if err = db.EditNote(id, "Migration done", "bob", "2024-03-02T12:00:00Z"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.EditNote(id+100, "Unknown", "bob", "2024-03-02T12:00:00Z"); err == nil {
+		t.Fatal("Expected editing an unknown note to fail")
+	}
+
+	// CHECK notes of the cluster [GetNotes]
+	notes, err := db.GetNotes(types.NoteObjectCluster, []string{"uid1", "uid2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []types.Note{{ID: id, ObjectType: types.NoteObjectCluster, ObjectId: "uid1", Body: "Migration done",
+		Author: "alice", CreatedAt: "2024-03-01T12:00:00Z", UpdatedBy: "bob", UpdatedAt: "2024-03-02T12:00:00Z"}}
+	if !reflect.DeepEqual(notes.Notes, expected) {
+		t.Fatalf("Expected notes %+v, got %+v", expected, notes.Notes)
+	}
+	notes, err = db.GetNotes("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes.Notes) != 2 {
+		t.Fatalf("Expected 2 notes, got %+v", notes.Notes)
+	}
+
+	// CHECK history of the note [GetNoteRevisions]
+	revisions, err := db.GetNoteRevisions(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions.Revisions) != 2 || revisions.Revisions[0].Body != "Being **migrated**, do not touch" ||
+		revisions.Revisions[1].Body != "Migration done" || revisions.Revisions[1].EditedBy != "bob" {
+		t.Fatalf("Unexpected revisions %+v", revisions.Revisions)
+	}
+
+	// ATTEMPT delete the note [DeleteNote]
+	if err = db.DeleteNote(id); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteNote(id); err == nil {
+		t.Fatal("Expected deleting a deleted note to fail")
+	}
+	if _, err = db.GetNoteRevisions(id); err == nil {
+		t.Fatal("Expected the history of a deleted note to be deleted")
+	}
+}
+
+// testAgentAnnotations checks annotations are set, overwritten, listed with the agents and deleted
+func testAgentAnnotations(t *testing.T, open Opener) {
+	db := open(t, nil)
+	spiffeid1, spiffeid2 := "spiffe://example.org/a", "spiffe://example.org/b"
+	if err := db.CreateAgentEntry(types.AgentInfo{Spiffeid: spiffeid1, Plugin: "Docker"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT annotate a stored agent and an agent not stored yet [SetAgentAnnotation]
+	for _, a := range []types.AgentAnnotation{
+		{Spiffeid: spiffeid1, Key: "status", Value: "in service", UpdatedBy: "alice", UpdatedAt: "2024-03-01T12:00:00Z"},
+		{Spiffeid: spiffeid1, Key: "hardware", Value: "2x Xeon Gold 6338", UpdatedBy: "alice", UpdatedAt: "2024-03-01T12:00:00Z"},
+		{Spiffeid: spiffeid2, Key: "status", Value: "in service", UpdatedBy: "alice", UpdatedAt: "2024-03-01T12:00:00Z"},
+		{Spiffeid: spiffeid1, Key: "status", Value: "pending decommission", UpdatedBy: "bob", UpdatedAt: "2024-03-02T12:00:00Z"},
+	} {
+		if err := db.SetAgentAnnotation(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// CHECK annotations of an agent, the last value kept [GetAgentAnnotations]
+	annotations, err := db.GetAgentAnnotations([]string{spiffeid1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []types.AgentAnnotation{
+		{Spiffeid: spiffeid1, Key: "hardware", Value: "2x Xeon Gold 6338", UpdatedBy: "alice", UpdatedAt: "2024-03-01T12:00:00Z"},
+		{Spiffeid: spiffeid1, Key: "status", Value: "pending decommission", UpdatedBy: "bob", UpdatedAt: "2024-03-02T12:00:00Z"},
+	}
+	if !reflect.DeepEqual(annotations.Annotations, expected) {
+		t.Fatalf("Expected annotations %+v, got %+v", expected, annotations.Annotations)
+	}
+
+	// CHECK annotations listed with the agents [GetAgentsMetadata]
+	agents, err := db.GetAgentsMetadata(types.AgentMetadataRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents.Agents) != 2 || agents.Agents[0].Annotations["status"] != "pending decommission" ||
+		agents.Agents[1].Annotations["status"] != "in service" {
+		t.Fatalf("Unexpected agents %+v", agents.Agents)
+	}
+
+	// ATTEMPT delete an annotation [DeleteAgentAnnotation]
+	if err = db.DeleteAgentAnnotation(spiffeid1, "status"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteAgentAnnotation(spiffeid1, "status"); err == nil {
+		t.Fatal("Expected deleting a deleted annotation to fail")
+	}
+	if annotations, err = db.GetAgentAnnotations(nil); err != nil || len(annotations.Annotations) != 2 {
+		t.Fatalf("Unexpected annotations %+v: %v", annotations.Annotations, err)
+	}
+}
+
+// testDeletedClusters checks deleted clusters are listed and restored with
+// their UID, agents, labels and metadata, leaving agents assigned since
+func testDeletedClusters(t *testing.T, open Opener) {
+	db := open(t, nil)
+	cinfo := types.ClusterInfo{Name: "prod", PlatformType: "k8s", Labels: map[string]string{"env": "prod"},
+		Metadata: json.RawMessage(`{"costCenter":"CC-1"}`), AgentsList: []string{"agent1", "agent2"}}
+	if err := db.CreateClusterEntry(cinfo); err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := db.GetClusters()
+	if err != nil || len(clusters.Clusters) != 1 {
+		t.Fatalf("Unexpected clusters %+v: %v", clusters.Clusters, err)
+	}
+	uid := clusters.Clusters[0].UID
+
+	// ATTEMPT delete cluster; should be listed as deleted [DeleteClusterEntry, ListDeletedClusters]
+	if err = db.DeleteClusterEntry("prod"); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := db.ListDeletedClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted.Clusters) != 1 || deleted.Clusters[0].Cluster.UID != uid || deleted.Clusters[0].DeletedAt == "" ||
+		len(deleted.Clusters[0].Cluster.AgentsList) != 2 {
+		t.Fatalf("Unexpected deleted clusters %+v", deleted.Clusters)
+	}
+
+	// ATTEMPT restore after an agent is assigned elsewhere; agent is skipped [RestoreClusterEntry]
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "staging", PlatformType: "k8s", AgentsList: []string{"agent2"}}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := db.RestoreClusterEntry(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := types.ClusterRestoreResult{Name: "prod", UID: uid, SkippedAgents: []string{"agent2"}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected result %+v, got %+v", expected, result)
+	}
+
+	// CHECK cluster is back with its UID, labels and metadata [GetClusters]
+	clusters, err = db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored types.ClusterInfo
+	for _, c := range clusters.Clusters {
+		if c.Name == "prod" {
+			restored = c
+		}
+	}
+	if restored.UID != uid || restored.Labels["env"] != "prod" || string(restored.Metadata) != `{"costCenter":"CC-1"}` ||
+		!reflect.DeepEqual(restored.AgentsList, []string{"agent1"}) {
+		t.Fatalf("Unexpected restored cluster %+v", restored)
+	}
+	changes, err := db.GetClusterChanges(1)
+	if err != nil || len(changes) != 1 || changes[0].Change != types.ClusterChangeRestored {
+		t.Fatalf("Unexpected changes %+v: %v", changes, err)
+	}
+	if deleted, err = db.ListDeletedClusters(); err != nil || len(deleted.Clusters) != 0 {
+		t.Fatalf("Expected no deleted clusters, got %+v: %v", deleted.Clusters, err)
+	}
+
+	// CHECK restores of unknown UIDs and taken names fail [RestoreClusterEntry]
+	var pf agentdb.PostFailure
+	if _, err = db.RestoreClusterEntry(uid); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on restored cluster, got %v", err)
+	}
+	if err = db.DeleteClusterEntry("prod"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "prod", PlatformType: "VMs"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.RestoreClusterEntry(uid); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on taken name, got %v", err)
+	}
+	if deleted, err = db.ListDeletedClusters(); err != nil || len(deleted.Clusters) != 1 {
+		t.Fatalf("Expected cluster to stay deleted, got %+v: %v", deleted.Clusters, err)
+	}
+}
+
+// testClusterRename checks renames keep the UID and agents of the cluster, and
+// fail on taken names
+func testClusterRename(t *testing.T, open Opener) {
+	db := open(t, nil)
+	cinfo := types.ClusterInfo{Name: "prod", PlatformType: "k8s", AgentsList: []string{"agent1"}}
+	if err := db.CreateClusterEntry(cinfo); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateClusterEntry(types.ClusterInfo{Name: "staging", PlatformType: "k8s"}); err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := db.GetClusters()
+	if err != nil || len(clusters.Clusters) != 2 {
+		t.Fatalf("Unexpected clusters %+v: %v", clusters.Clusters, err)
+	}
+	uid := clusters.Clusters[0].UID
+
+	// ATTEMPT rename cluster; should keep UID and agents [RenameClusterEntry]
+	result, err := db.RenameClusterEntry("prod", "production")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Name != "production" || len(result.Changes) != 1 {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	if name, err := db.GetClusterNameByUID(uid); err != nil || name != "production" {
+		t.Fatalf("Expected cluster %s to be renamed, got %s: %v", uid, name, err)
+	}
+	agents, err := db.GetClusterAgents("production")
+	if err != nil || !reflect.DeepEqual(agents, []string{"agent1"}) {
+		t.Fatalf("Unexpected agents %v: %v", agents, err)
+	}
+
+	// CHECK renames to taken names fail [RenameClusterEntry]
+	var pf agentdb.PostFailure
+	if _, err = db.RenameClusterEntry("production", "staging"); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on taken name, got %v", err)
+	}
+}
+
+// testClusterBulkCreate checks a bulk creation creates the clusters it can in
+// one transaction, rolling back the others only
+func testClusterBulkCreate(t *testing.T, open Opener) {
+	db := open(t, nil)
+	if err := db.CreateClusterEntry(types.ClusterInfo{Name: "existing", AgentsList: []string{"agent1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT bulk creation of new, existing, conflicting and new clusters [CreateClusterEntries]
+	result, err := db.CreateClusterEntries([]types.ClusterInfo{
+		{Name: "cluster1", AgentsList: []string{"agent2"}},
+		{Name: "existing"},
+		{Name: "conflict", AgentsList: []string{"agent3", "agent1"}},
+		{Name: "cluster2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := []string{}
+	for _, item := range result.Items {
+		statuses = append(statuses, item.Status+" "+item.Code)
+	}
+	expected := []string{"succeeded ", "skipped ALREADY_EXISTS", "failed FAILED_PRECONDITION", "succeeded "}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("Expected items %v, got %v", expected, statuses)
+	}
+
+	// CHECK the items after failed ones are created in the same transaction [GetClusters]
+	clusters, err := db.GetClusters()
+	if err != nil || len(clusters.Clusters) != 3 {
+		t.Fatalf("Expected 3 clusters, got %+v: %v", clusters.Clusters, err)
+	}
+	agents, err := db.GetClusterAgents("cluster1")
+	if err != nil || !reflect.DeepEqual(agents, []string{"agent2"}) {
+		t.Fatalf("Unexpected agents %v: %v", agents, err)
+	}
+}
+
+// testClusterBulkDelete checks a bulk deletion deletes the clusters it can in
+// one transaction, rolling back the others only
+func testClusterBulkDelete(t *testing.T, open Opener) {
+	db := open(t, nil)
+	for _, cinfo := range []types.ClusterInfo{
+		{Name: "cluster1", AgentsList: []string{"agent1"}},
+		{Name: "protected", Protected: true},
+	} {
+		if err := db.CreateClusterEntry(cinfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uids := map[string]string{}
+	for _, c := range clusters.Clusters {
+		uids[c.Name] = c.UID
+	}
+
+	// ATTEMPT bulk deletion of existing, protected and unknown UIDs [DeleteClusterEntries]
+	result, err := db.DeleteClusterEntries([]string{uids["cluster1"], uids["protected"], "0123456789abcdef0123456789abcdef"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := []string{}
+	for _, item := range result.Items {
+		statuses = append(statuses, item.Status+" "+item.Code)
+	}
+	expected := []string{"succeeded ", "failed PERMISSION_DENIED", "failed NOT_FOUND"}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("Expected items %v, got %v", expected, statuses)
+	}
+
+	// CHECK only the protected cluster remains [GetClusters]
+	clusters, err = db.GetClusters()
+	if err != nil || len(clusters.Clusters) != 1 || clusters.Clusters[0].Name != "protected" {
+		t.Fatalf("Expected the protected cluster only, got %+v: %v", clusters.Clusters, err)
+	}
+	if _, err := db.GetAgentClusterName("agent1"); err == nil {
+		t.Fatal("Expected agent1 in no cluster")
+	}
+}
+
+// testMoveAgentsBetweenClusters checks agents are moved between clusters by
+// UID all or none
+func testMoveAgentsBetweenClusters(t *testing.T, open Opener) {
+	db := open(t, nil)
+	for _, cinfo := range []types.ClusterInfo{
+		{Name: "cluster1", AgentsList: []string{"agent1", "agent2"}},
+		{Name: "cluster2", AgentsList: []string{"agent3"}},
+	} {
+		if err := db.CreateClusterEntry(cinfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uids := map[string]string{}
+	for _, c := range clusters.Clusters {
+		uids[c.Name] = c.UID
+	}
+
+	// ATTEMPT move of an agent not in the cluster; should move none [MoveAgentsBetweenClusters]
+	var pf agentdb.PostFailure
+	if _, err = db.MoveAgentsBetweenClusters(uids["cluster1"], uids["cluster2"], []string{"agent1", "agent3"}); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure, got %v", err)
+	}
+	if name, err := db.GetAgentClusterName("agent1"); err != nil || name != "cluster1" {
+		t.Fatalf("Expected agent1 in cluster1, got %q: %v", name, err)
+	}
+
+	// ATTEMPT move [MoveAgentsBetweenClusters]
+	result, err := db.MoveAgentsBetweenClusters(uids["cluster1"], uids["cluster2"], []string{"agent1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (types.AgentMoveResult{FromCluster: "cluster1", ToCluster: "cluster2", Moved: 1}) {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	names, err := db.GetAgentClusterNames([]string{"agent1", "agent2", "agent3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"agent1": "cluster2", "agent2": "cluster1", "agent3": "cluster2"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Expected memberships %v, got %v", expected, names)
+	}
+}
+
+// testClusterUpsert checks clusters are created by UID if new and updated
+// otherwise, in the same transaction as the lookup of the UID
+func testClusterUpsert(t *testing.T, open Opener) {
+	db := open(t, nil)
+	uid := "0123456789abcdef0123456789abcdef"
+
+	// ATTEMPT upsert of a new UID; should create the cluster with the UID [CreateOrUpdateClusterEntry]
+	cinfo := types.ClusterInfo{UID: uid, Name: "prod", PlatformType: "k8s", AgentsList: []string{"agent1"}}
+	result, err := db.CreateOrUpdateClusterEntry(cinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Created || result.Name != "prod" || result.UID != uid {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+
+	// ATTEMPT upsert with another name; should rename the cluster [CreateOrUpdateClusterEntry]
+	cinfo.Name = "production"
+	result, err = db.CreateOrUpdateClusterEntry(cinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Created || len(result.Changes) != 1 || result.Changes[0].Field != "name" {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	clusters, err := db.GetClusters()
+	if err != nil || len(clusters.Clusters) != 1 || clusters.Clusters[0].Name != "production" || clusters.Clusters[0].UID != uid ||
+		!reflect.DeepEqual(clusters.Clusters[0].AgentsList, []string{"agent1"}) {
+		t.Fatalf("Unexpected clusters %+v: %v", clusters.Clusters, err)
+	}
+
+	// CHECK UIDs of deleted clusters are rejected [CreateOrUpdateClusterEntry]
+	if err = db.DeleteClusterEntry("production"); err != nil {
+		t.Fatal(err)
+	}
+	var pf agentdb.PostFailure
+	if _, err = db.CreateOrUpdateClusterEntry(cinfo); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on deleted UID, got %v", err)
+	}
+}
+
+// testAuditEvents checks changes of clusters, memberships and agents are
+// recorded with their actors, and listed most recent first
+func testAuditEvents(t *testing.T, open Opener) {
+	db := open(t, nil)
+	cinfo := types.ClusterInfo{Name: "prod", PlatformType: "k8s", AgentsList: []string{"agent1"}}
+	// ATTEMPT create cluster as a user; cluster and membership recorded [WithActor, CreateClusterEntry, GetAuditEvents]
+	if err := db.WithActor("alice").CreateClusterEntry(cinfo); err != nil {
+		t.Fatal(err)
+	}
+	events, err := db.GetAuditEvents(types.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events.Total != 2 || events.Items[0].ObjectType != types.AuditObjectMembership ||
+		events.Items[1].ObjectType != types.AuditObjectCluster {
+		t.Fatalf("Unexpected events %+v", events.Items)
+	}
+	uid := events.Items[1].ObjectId
+	for _, e := range events.Items {
+		if e.Actor != "alice" || e.Action != types.AuditActionCreate || e.Before != nil || e.Timestamp == "" {
+			t.Fatalf("Unexpected event %+v", e)
+		}
+	}
+
+	// ATTEMPT move cluster to another agent; only memberships recorded [EditClusterEntry]
+	cinfo.EditedName, cinfo.AgentsList = "prod", []string{"agent2"}
+	if _, err = db.WithActor("alice").EditClusterEntry(cinfo); err != nil {
+		t.Fatal(err)
+	}
+	events, err = db.GetAuditEvents(types.ListOptions{Filters: []types.Filter{{Field: "objectType", Value: types.AuditObjectMembership}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events.Total != 3 || events.Items[0].ObjectId != "agent2" || events.Items[0].Action != types.AuditActionCreate ||
+		events.Items[1].ObjectId != "agent1" || events.Items[1].Action != types.AuditActionDelete {
+		t.Fatalf("Unexpected membership events %+v", events.Items)
+	}
+	if string(events.Items[1].Before) != `{"clusterUid":"`+uid+`","cluster":"prod"}` {
+		t.Fatalf("Unexpected membership state %s", events.Items[1].Before)
+	}
+
+	// ATTEMPT set display name twice; recorded once [SetAgentDisplayName]
+	for i := 0; i < 2; i++ {
+		if err = db.WithActor("bob").SetAgentDisplayName("agent2", "Agent Two"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, err = db.GetAuditEvents(types.ListOptions{Filters: []types.Filter{{Field: "actor", Value: "bob"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events.Total != 1 || events.Items[0].ObjectType != types.AuditObjectAgent || events.Items[0].ObjectId != "agent2" ||
+		string(events.Items[0].After) != `{"spiffeid":"agent2","displayName":"Agent Two"}` {
+		t.Fatalf("Unexpected agent events %+v", events.Items)
+	}
+
+	// ATTEMPT delete cluster without an actor [DeleteClusterEntry]
+	if err = db.DeleteClusterEntry("prod"); err != nil {
+		t.Fatal(err)
+	}
+	events, err = db.GetAuditEvents(types.ListOptions{Filters: []types.Filter{
+		{Field: "objectType", Value: types.AuditObjectCluster}, {Field: "objectId", Value: uid}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events.Total != 2 || events.Items[0].Action != types.AuditActionDelete || events.Items[0].Actor != "" ||
+		events.Items[0].Before == nil || events.Items[0].After != nil {
+		t.Fatalf("Unexpected cluster events %+v", events.Items)
+	}
+
+	// CHECK pages, sorts and unknown fields [GetAuditEvents]
+	events, err = db.GetAuditEvents(types.ListOptions{Limit: 2})
+	if err != nil || events.Total != 7 || len(events.Items) != 2 || events.NextCursor == "" {
+		t.Fatalf("Unexpected page %+v: %v", events, err)
+	}
+	if _, err = db.GetAuditEvents(types.ListOptions{Sort: []types.SortField{{Field: "actor"}}}); err == nil {
+		t.Fatal("Expected error on sorted audit events")
+	}
+	if _, err = db.GetAuditEvents(types.ListOptions{Filters: []types.Filter{{Field: "before", Value: "x"}}}); err == nil {
+		t.Fatal("Expected error on unknown filter field")
+	}
+}
+
+// testClusterAuditHistory checks the events of a cluster and of its
+// memberships are listed with the fields changed by each edit
+func testClusterAuditHistory(t *testing.T, open Opener) {
+	db := open(t, nil)
+	cinfo := types.ClusterInfo{Name: "prod", PlatformType: "k8s", AgentsList: []string{"agent1"}}
+	// ATTEMPT create, rename and extend a cluster, and change one of its agents [CreateClusterEntry, EditClusterEntry]
+	if err := db.WithActor("alice").CreateClusterEntry(cinfo); err != nil {
+		t.Fatal(err)
+	}
+	cinfo.EditedName, cinfo.AgentsList = "production", []string{"agent1", "agent2"}
+	if _, err := db.WithActor("bob").EditClusterEntry(cinfo); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetAgentDisplayName("agent1", "Agent One"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateClusterEntry(types.ClusterInfo{Name: "dev", AgentsList: []string{"agent3"}}); err != nil {
+		t.Fatal(err)
+	}
+	created, err := db.GetAuditEvents(types.ListOptions{Filters: []types.Filter{
+		{Field: "objectType", Value: types.AuditObjectCluster}, {Field: "action", Value: types.AuditActionCreate}}})
+	if err != nil || created.Total != 2 {
+		t.Fatalf("Unexpected cluster events %+v: %v", created, err)
+	}
+	uid := created.Items[1].ObjectId
+
+	// CHECK history of the cluster, without agent events and other clusters [GetAuditEvents, ClusterHistory]
+	events, err := db.GetAuditEvents(types.ListOptions{Filters: []types.Filter{{Field: "clusterUid", Value: uid}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := agentdb.ClusterHistory(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Total != 4 {
+		t.Fatalf("Unexpected history %+v", history.Items)
+	}
+	for _, e := range history.Items {
+		if e.ClusterUID != uid || (e.ObjectType == types.AuditObjectMembership) != (len(e.Changes) == 0) {
+			t.Fatalf("Unexpected history event %+v", e)
+		}
+	}
+	membership, edit := history.Items[0], history.Items[1]
+	if membership.ObjectId != "agent2" || membership.Action != types.AuditActionCreate || membership.Actor != "bob" {
+		t.Fatalf("Unexpected membership event %+v", membership)
+	}
+	expected := []types.FieldChange{{Field: "name", Before: "prod", After: "production"},
+		{Field: "agentsList", Before: []string{"agent1"}, After: []string{"agent1", "agent2"}}}
+	if edit.Action != types.AuditActionEdit || edit.Actor != "bob" || !reflect.DeepEqual(edit.Changes, expected) {
+		t.Fatalf("Unexpected edit event %+v", edit)
+	}
+	if history.Items[3].Action != types.AuditActionCreate || history.Items[3].Changes[0].Before != "" {
+		t.Fatalf("Unexpected create event %+v", history.Items[3])
+	}
+}
@@ -0,0 +1,32 @@
+package dbtest
+
+import (
+	"path/filepath"
+	"testing"
+
+	backoff "github.com/cenkalti/backoff/v4"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+)
+
+func TestSqlite(t *testing.T) {
+	Run(t, func(t *testing.T, clk clock.Clock) agentdb.AgentDB {
+		db, err := agentdb.NewLocalSqliteDBWithOptions("sqlite3", filepath.Join(t.TempDir(), "tornjak.sqlite3"),
+			backoff.NewExponentialBackOff(), agentdb.SqliteOptions{Clock: clk})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	})
+}
+
+func TestInMemory(t *testing.T) {
+	Run(t, func(t *testing.T, clk clock.Clock) agentdb.AgentDB {
+		db, err := agentdb.NewInMemoryDB(backoff.NewExponentialBackOff(), agentdb.SqliteOptions{Clock: clk})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	})
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// DeletedClusterSnapshot encodes the state of a cluster as it is kept by
+// the DataStores when the cluster is deleted
+func DeletedClusterSnapshot(cinfo types.ClusterInfo) (string, error) {
+	data, err := json.Marshal(cinfo)
+	if err != nil {
+		return "", errors.Errorf("Invalid state of cluster %s: %v", cinfo.Name, err)
+	}
+	return string(data), nil
+}
+
+// ParseDeletedCluster decodes a deleted cluster kept by a DataStore
+func ParseDeletedCluster(snapshot string, deletedAt string) (types.DeletedCluster, error) {
+	deleted := types.DeletedCluster{DeletedAt: deletedAt}
+	if err := json.Unmarshal([]byte(snapshot), &deleted.Cluster); err != nil {
+		return types.DeletedCluster{}, errors.Errorf("Invalid deleted cluster record: %v", err)
+	}
+	if deleted.Cluster.AgentsList == nil {
+		deleted.Cluster.AgentsList = []string{}
+	}
+	return deleted, nil
+}
+
+// DeletedClusterNotFound returns the PostFailure of a restore of a cluster
+// that is not deleted
+func DeletedClusterNotFound(uid string) PostFailure {
+	return PostFailure{Message: "Deleted cluster with UID " + uid + " does not exist"}
+}
+
+// RestorableAgents splits the agents of a deleted cluster into the agents
+// restored with it and the agents assigned to another cluster since, given
+// the clusters of the assigned agents
+func RestorableAgents(agentsList []string, assigned map[string]string) ([]string, []string) {
+	restored, skipped := []string{}, []string{}
+	for _, spiffeid := range agentsList {
+		if _, ok := assigned[spiffeid]; ok {
+			skipped = append(skipped, spiffeid)
+		} else {
+			restored = append(restored, spiffeid)
+		}
+	}
+	return restored, skipped
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// DefaultSlowQueryMillis is the mean duration from which statements are
+// reported as slow when the index report is requested without one
+const DefaultSlowQueryMillis = 100
+
+// MaxSlowQueries is the number of slow queries read from the log of the
+// database, those taking the most time in total
+const MaxSlowQueries = 100
+
+// IndexCandidate is an index on columns Tornjak filters, sorts or joins on,
+// which the schema does not create as it only pays off in large deployments
+type IndexCandidate struct {
+	Table   string
+	Columns []string
+	Purpose string
+}
+
+// IndexCandidates lists the access paths of Tornjak an index may speed up
+var IndexCandidates = []IndexCandidate{
+	{Table: "cluster_memberships", Columns: []string{"cluster_id"}, Purpose: "agents of a cluster, agent counts and cluster deletes"},
+	{Table: "clusters", Columns: []string{"platform_type"}, Purpose: "cluster lists filtered on platformType"},
+	{Table: "clusters", Columns: []string{"managed_by"}, Purpose: "cluster lists filtered on managedBy"},
+	{Table: "clusters", Columns: []string{"domain_name"}, Purpose: "cluster lists filtered on domainName"},
+	{Table: "clusters", Columns: []string{"owner_team"}, Purpose: "cluster lists filtered on ownerTeam"},
+	{Table: "clusters", Columns: []string{"tenant"}, Purpose: "cluster lists filtered on tenant"},
+	{Table: "cluster_labels", Columns: []string{"label", "value"}, Purpose: "bulk label operations across clusters"},
+	{Table: "agent_labels", Columns: []string{"label", "value"}, Purpose: "bulk label operations across agents"},
+	{Table: "note_revisions", Columns: []string{"note_id"}, Purpose: "revisions of a note"},
+}
+
+// Name returns the name of the index to create
+func (c IndexCandidate) Name() string {
+	return c.Table + "_" + strings.Join(c.Columns, "_")
+}
+
+// coveredBy returns whether an index starts with the columns of the candidate
+func (c IndexCandidate) coveredBy(index types.TableIndex) bool {
+	if index.Table != c.Table || len(index.Columns) < len(c.Columns) {
+		return false
+	}
+	for i, column := range c.Columns {
+		if index.Columns[i] != column {
+			return false
+		}
+	}
+	return true
+}
+
+// matches returns whether a statement names the table and columns of the
+// candidate, as words so backquoted identifiers match too
+func (c IndexCandidate) matches(query string) bool {
+	query = strings.ToLower(query)
+	for _, ident := range append([]string{c.Table}, c.Columns...) {
+		if !regexp.MustCompile(`\b` + regexp.QuoteMeta(ident) + `\b`).MatchString(query) {
+			return false
+		}
+	}
+	return true
+}
+
+// IndexSuggestions returns the candidates no index of indexes starts with,
+// with the slow queries naming their table and columns, those of the slowest
+// queries first; ddl returns the statement creating the index of a candidate
+// candidates no slow query names are still suggested, last, as the log may
+// have been reset or not be recorded
+func IndexSuggestions(indexes []types.TableIndex, slow []types.SlowQuery, ddl func(c IndexCandidate) string) []types.IndexSuggestion {
+	ret := []types.IndexSuggestion{}
+	for _, c := range IndexCandidates {
+		covered := false
+		for _, index := range indexes {
+			if c.coveredBy(index) {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			continue
+		}
+		suggestion := types.IndexSuggestion{Table: c.Table, Columns: c.Columns, Purpose: c.Purpose, DDL: ddl(c)}
+		for _, q := range slow {
+			if c.matches(q.Query) {
+				suggestion.SlowQueries++
+				suggestion.SlowMillis += q.TotalMillis
+			}
+		}
+		ret = append(ret, suggestion)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].SlowMillis > ret[j].SlowMillis
+	})
+	return ret
+}
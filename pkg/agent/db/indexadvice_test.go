@@ -0,0 +1,55 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// TestIndexSuggestions checks covered candidates are left out and the others
+// ranked by the time of the slow queries naming them
+func TestIndexSuggestions(t *testing.T) {
+	indexes := []types.TableIndex{
+		{Table: "cluster_memberships", Name: "cluster_memberships_cluster", Columns: []string{"cluster_id", "agent_id"}},
+		{Table: "clusters", Name: "clusters_platform", Columns: []string{"platform_type"}},
+		// only the first columns of an index serve lookups
+		{Table: "clusters", Name: "clusters_tenant_team", Columns: []string{"tenant", "owner_team"}},
+	}
+	slow := []types.SlowQuery{
+		{Query: "SELECT name FROM clusters WHERE managed_by = $1", Calls: 10, TotalMillis: 500},
+		{Query: "SELECT COUNT(*) FROM `clusters` WHERE `owner_team` = ?", Calls: 2, TotalMillis: 900},
+		{Query: "SELECT name FROM clusters WHERE owner_team = $1 AND tenant = $2", Calls: 1, TotalMillis: 100},
+		// managed_by is not a column of agents
+		{Query: "SELECT spiffeid FROM agents WHERE display_name = 'managed_by'", Calls: 1, TotalMillis: 10000},
+	}
+
+	// ATTEMPT suggest indexes [IndexSuggestions]
+	suggestions := IndexSuggestions(indexes, slow, func(c IndexCandidate) string {
+		return "CREATE INDEX " + c.Name() + " ON " + c.Table + " (" + strings.Join(c.Columns, ", ") + ")"
+	})
+
+	// CHECK covered candidates are not suggested
+	for _, s := range suggestions {
+		if s.Table == "cluster_memberships" || (s.Table == "clusters" && (s.Columns[0] == "platform_type" || s.Columns[0] == "tenant")) {
+			t.Fatalf("Unexpected suggestion of a covered index %+v", s)
+		}
+	}
+	// CHECK suggestions of the slowest queries first, with their DDL
+	if len(suggestions) != len(IndexCandidates)-3 {
+		t.Fatalf("Expected %d suggestions, got %+v", len(IndexCandidates)-3, suggestions)
+	}
+	first, second := suggestions[0], suggestions[1]
+	if first.Columns[0] != "owner_team" || first.SlowQueries != 2 || first.SlowMillis != 1000 ||
+		first.DDL != "CREATE INDEX clusters_owner_team ON clusters (owner_team)" {
+		t.Fatalf("Expected the owner_team index first, got %+v", first)
+	}
+	if second.Columns[0] != "managed_by" || second.SlowQueries != 1 || second.SlowMillis != 500 {
+		t.Fatalf("Expected the managed_by index second, got %+v", second)
+	}
+	for _, s := range suggestions[2:] {
+		if s.SlowQueries != 0 {
+			t.Fatalf("Expected no slow queries of %+v", s)
+		}
+	}
+}
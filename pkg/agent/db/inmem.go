@@ -0,0 +1,44 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	backoff "github.com/cenkalti/backoff/v4"
+	"github.com/pkg/errors"
+)
+
+// inMemoryDBCount numbers the in-memory DBs of the process, so each has its own name
+var inMemoryDBCount atomic.Int64
+
+// NewInMemoryDB returns an AgentDB held in memory, with the semantics of the
+// local sqlite DB: the same unique constraints, membership conflicts and
+// errors. Nothing is written to disk, except the files of named snapshots,
+// which go to opts.SnapshotDir, or a directory under the system temporary
+// directory if empty. The contents are lost when the process exits.
+func NewInMemoryDB(backOffParams backoff.BackOff, opts SqliteOptions) (AgentDB, error) {
+	// connections of the same process to a named in-memory DB with a shared
+	// cache see the same data; the DB is freed when its last connection closes
+	name := fmt.Sprintf("tornjak-inmem-%d-%d", os.Getpid(), inMemoryDBCount.Add(1))
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared&_busy_timeout=5000", name)
+	if len(opts.SnapshotDir) == 0 {
+		opts.SnapshotDir = filepath.Join(os.TempDir(), name+"-snapshots")
+	}
+
+	agentDB, err := NewLocalSqliteDBWithOptions("sqlite3", dsn, backOffParams, opts)
+	if err != nil {
+		return nil, err
+	}
+	db := agentDB.(*LocalSqliteDb)
+
+	// the pool may close idle connections, so one is kept open for the life of the DB
+	conn, err := db.database.Conn(context.Background())
+	if err != nil {
+		return nil, errors.Errorf("Unable to open connection to in-memory DB: %v", err)
+	}
+	db.keepAlive = conn
+	return db, nil
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	backoff "github.com/cenkalti/backoff/v4"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+func newTestInMemoryDB(t *testing.T, opts SqliteOptions) AgentDB {
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewInMemoryDB(expBackoff, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// TestInMemoryDB checks the in-memory DB enforces the constraints of the local sqlite DB
+func TestInMemoryDB(t *testing.T) {
+	db := newTestInMemoryDB(t, SqliteOptions{ClusterNameUniqueness: ClusterNameCaseInsensitive})
+
+	err := db.CreateClusterEntry(types.ClusterInfo{Name: "cluster1", PlatformType: "VMs", AgentsList: []string{"agent1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK duplicate name, also differing only by case [CreateClusterEntry]
+	for _, name := range []string{"cluster1", "CLUSTER1"} {
+		err = db.CreateClusterEntry(types.ClusterInfo{Name: name, PlatformType: "VMs"})
+		if _, ok := err.(PostFailure); !ok {
+			t.Fatalf("Create of cluster %s should fail with PostFailure, got %v", name, err)
+		}
+	}
+
+	// CHECK agent already in another cluster [CreateClusterEntry]
+	err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster2", PlatformType: "VMs", AgentsList: []string{"agent1"}})
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Create of cluster with agent of another cluster should fail with PostFailure, got %v", err)
+	}
+	clusterName, err := db.GetAgentClusterName("agent1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clusterName != "cluster1" {
+		t.Fatalf("agent1 should stay in cluster1, got %q", clusterName)
+	}
+
+	// CHECK a second in-memory DB does not see the clusters of the first [NewInMemoryDB]
+	other := newTestInMemoryDB(t, SqliteOptions{})
+	cList, err := other.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cList.Clusters) != 0 {
+		t.Fatalf("New in-memory DB should be empty, got %d clusters", len(cList.Clusters))
+	}
+}
+
+// TestInMemoryDBConcurrent checks concurrent writes to the in-memory DB all succeed
+func TestInMemoryDBConcurrent(t *testing.T) {
+	db := newTestInMemoryDB(t, SqliteOptions{})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("cluster%d", i)
+			errs <- db.CreateClusterEntry(types.ClusterInfo{Name: name, PlatformType: "VMs", AgentsList: []string{name + "-agent"}})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cList, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cList.Clusters) != 10 {
+		t.Fatalf("Clusters list should have 10 clusters, got %d", len(cList.Clusters))
+	}
+}
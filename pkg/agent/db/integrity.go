@@ -0,0 +1,269 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// IntegrityReference is a column of a table holding the IDs of the rows of
+// another table, checked for rows referencing rows that do not exist
+type IntegrityReference struct {
+	Table  string
+	Column string
+	Parent string
+	// whether the column may be NULL, so dangling references are unset
+	// rather than their rows deleted
+	Optional bool
+}
+
+// IntegrityReferences are the references of the tables of all DataStores,
+// other than the memberships of agents in clusters, checked on their own
+var IntegrityReferences = []IntegrityReference{
+	{Table: "agents", Column: "plugin_type_id", Parent: "plugin_types", Optional: true},
+	{Table: "agent_labels", Column: "agent_id", Parent: "agents"},
+	{Table: "agent_annotations", Column: "agent_id", Parent: "agents"},
+	{Table: "cluster_extensions", Column: "cluster_id", Parent: "clusters"},
+	{Table: "cluster_labels", Column: "cluster_id", Parent: "clusters"},
+	{Table: "note_revisions", Column: "note_id", Parent: "notes"},
+}
+
+// integrityCheck selects the rows failing a check, by the value of column
+// and a name describing them, NULL if none
+type integrityCheck struct {
+	check    string
+	severity string
+	table    string
+	column   string
+	query    string
+	message  func(name sql.NullString) string
+	fix      func(row string) (string, error)
+}
+
+// deferred, if not empty, selects the IDs of the deleted clusters whose
+// memberships are removed in the background, which are not orphaned
+func integrityChecks(references []IntegrityReference, deferred string) []integrityCheck {
+	pending := ""
+	if deferred != "" {
+		pending = " AND m.cluster_id NOT IN (" + deferred + ")"
+	}
+	checks := []integrityCheck{
+		{
+			check: types.IntegrityOrphanedMembership, severity: types.IntegritySeverityError,
+			table: "cluster_memberships", column: "agent_id",
+			query: `SELECT m.agent_id, c.name FROM cluster_memberships m LEFT JOIN agents a ON a.id=m.agent_id
+                    LEFT JOIN clusters c ON c.id=m.cluster_id WHERE a.id IS NULL ORDER BY m.agent_id`,
+			message: func(name sql.NullString) string {
+				if !name.Valid {
+					return "membership of an agent that does not exist in a cluster that does not exist"
+				}
+				return fmt.Sprintf("membership of an agent that does not exist in cluster %q", name.String)
+			},
+			fix: func(row string) (string, error) {
+				return "Delete the membership: DELETE FROM cluster_memberships WHERE " + row, nil
+			},
+		},
+		{
+			check: types.IntegrityOrphanedMembership, severity: types.IntegritySeverityError,
+			table: "cluster_memberships", column: "agent_id",
+			query: `SELECT m.agent_id, a.spiffeid FROM cluster_memberships m JOIN agents a ON a.id=m.agent_id
+                    LEFT JOIN clusters c ON c.id=m.cluster_id WHERE c.id IS NULL` + pending + ` ORDER BY m.agent_id`,
+			message: func(name sql.NullString) string {
+				return fmt.Sprintf("agent %s is a member of a cluster that does not exist, so it cannot be assigned to another cluster", name.String)
+			},
+			fix: func(row string) (string, error) {
+				return "Delete the membership, then assign the agent to its cluster again: DELETE FROM cluster_memberships WHERE " + row, nil
+			},
+		},
+		{
+			check: types.IntegrityAgentMissingSpiffeid, severity: types.IntegritySeverityError,
+			table: "agents", column: "id",
+			query: `SELECT id, display_name FROM agents WHERE spiffeid IS NULL OR spiffeid='' ORDER BY id`,
+			message: func(name sql.NullString) string {
+				if !name.Valid || name.String == "" {
+					return "agent has no SPIFFE ID, so its metadata cannot be read or changed"
+				}
+				return fmt.Sprintf("agent %q has no SPIFFE ID, so its metadata cannot be read or changed", name.String)
+			},
+			fix: func(row string) (string, error) {
+				return "Delete the agent; the rows referencing it are then reported as dangling references: DELETE FROM agents WHERE " + row, nil
+			},
+		},
+		{
+			check: types.IntegrityClusterMissingUID, severity: types.IntegritySeverityError,
+			table: "clusters", column: "id",
+			query: `SELECT id, name FROM clusters WHERE uid IS NULL OR uid='' ORDER BY id`,
+			message: func(name sql.NullString) string {
+				return fmt.Sprintf("cluster %q has no UID, so its changes are not recorded in its history and it cannot be restored once deleted", name.String)
+			},
+			fix: func(row string) (string, error) {
+				uid, err := newIntegrityUID()
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Assign the cluster a new UID: UPDATE clusters SET uid='%s' WHERE %s", uid, row), nil
+			},
+		},
+	}
+	for _, r := range references {
+		r := r
+		check := integrityCheck{
+			check: types.IntegrityDanglingReference, severity: types.IntegritySeverityWarning,
+			table: r.Table, column: r.Column,
+			query: fmt.Sprintf(`SELECT DISTINCT t.%[2]s, NULL FROM %[1]s t LEFT JOIN %[3]s p ON p.id=t.%[2]s
+                    WHERE t.%[2]s IS NOT NULL AND p.id IS NULL ORDER BY t.%[2]s`, r.Table, r.Column, r.Parent),
+			message: func(name sql.NullString) string {
+				return fmt.Sprintf("rows of %s reference a row of %s that does not exist, and are ignored", r.Table, r.Parent)
+			},
+			fix: func(row string) (string, error) {
+				return fmt.Sprintf("Delete the rows: DELETE FROM %s WHERE %s", r.Table, row), nil
+			},
+		}
+		if r.Optional {
+			check.message = func(name sql.NullString) string {
+				return fmt.Sprintf("rows of %s reference a row of %s that does not exist", r.Table, r.Parent)
+			}
+			check.fix = func(row string) (string, error) {
+				return fmt.Sprintf("Unset the reference: UPDATE %s SET %s=NULL WHERE %s", r.Table, r.Column, row), nil
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// CheckIntegrity runs the integrity checks on the tables of a DB through q,
+// with the references of its tables, IntegrityReferences and those of the
+// tables only the DB has
+// deferred is the query selecting the IDs of the deleted clusters whose
+// memberships the DB removes in the background, empty if it removes them with
+// their cluster
+func CheckIntegrity(ctx context.Context, q Queryer, references []IntegrityReference, deferred string, checkedAt string) (types.IntegrityReport, error) {
+	report := types.IntegrityReport{CheckedAt: checkedAt, Findings: []types.IntegrityFinding{}}
+	listed := map[string]int{}
+	for _, c := range integrityChecks(references, deferred) {
+		rows, err := q.QueryContext(ctx, c.query)
+		if err != nil {
+			return types.IntegrityReport{}, SQLError{c.query, err}
+		}
+		err = func() error {
+			defer rows.Close()
+			for rows.Next() {
+				var id sql.NullInt64
+				var name sql.NullString
+				if err := rows.Scan(&id, &name); err != nil {
+					return SQLError{c.query, err}
+				}
+				switch c.severity {
+				case types.IntegritySeverityError:
+					report.Errors++
+				case types.IntegritySeverityWarning:
+					report.Warnings++
+				}
+				if listed[c.check] >= types.MaxIntegrityFindings {
+					report.Omitted++
+					continue
+				}
+				listed[c.check]++
+				row := c.column + " IS NULL"
+				if id.Valid {
+					row = fmt.Sprintf("%s=%d", c.column, id.Int64)
+				}
+				fix, err := c.fix(row)
+				if err != nil {
+					return err
+				}
+				report.Findings = append(report.Findings, types.IntegrityFinding{Check: c.check, Severity: c.severity,
+					Table: c.table, Row: row, Message: c.message(name), Fix: fix})
+			}
+			if err := rows.Err(); err != nil {
+				return SQLError{c.query, err}
+			}
+			return nil
+		}()
+		if err != nil {
+			return types.IntegrityReport{}, err
+		}
+	}
+	return report, nil
+}
+
+// StoreIntegrityReport replaces the stored integrity report by report, in
+// tables integrity_checks and integrity_findings
+// placeholder returns the placeholder of the nth argument of a statement, from 1
+func StoreIntegrityReport(ctx context.Context, q Queryer, placeholder func(n int) string, report types.IntegrityReport) error {
+	for _, cmd := range []string{`DELETE FROM integrity_findings`, `DELETE FROM integrity_checks`} {
+		if _, err := q.ExecContext(ctx, cmd); err != nil {
+			return SQLError{cmd, err}
+		}
+	}
+	cmd := fmt.Sprintf(`INSERT INTO integrity_checks (id, checked_at, errors, warnings, omitted) VALUES (1, %s, %s, %s, %s)`,
+		placeholder(1), placeholder(2), placeholder(3), placeholder(4))
+	if _, err := q.ExecContext(ctx, cmd, report.CheckedAt, report.Errors, report.Warnings, report.Omitted); err != nil {
+		return SQLError{cmd, err}
+	}
+	cmd = fmt.Sprintf(`INSERT INTO integrity_findings (seq, check_name, severity, table_name, row_key, message, fix)
+          VALUES (%s, %s, %s, %s, %s, %s, %s)`, placeholder(1), placeholder(2), placeholder(3), placeholder(4),
+		placeholder(5), placeholder(6), placeholder(7))
+	for i, f := range report.Findings {
+		if _, err := q.ExecContext(ctx, cmd, i, f.Check, f.Severity, f.Table, f.Row, f.Message, f.Fix); err != nil {
+			return SQLError{cmd, err}
+		}
+	}
+	return nil
+}
+
+// LoadIntegrityReport returns the stored integrity report, empty if the DB
+// was never checked
+func LoadIntegrityReport(ctx context.Context, q Queryer) (types.IntegrityReport, error) {
+	report := types.IntegrityReport{Findings: []types.IntegrityFinding{}}
+	cmd := `SELECT checked_at, errors, warnings, omitted FROM integrity_checks WHERE id=1`
+	rows, err := q.QueryContext(ctx, cmd)
+	if err != nil {
+		return types.IntegrityReport{}, SQLError{cmd, err}
+	}
+	checked := rows.Next()
+	if checked {
+		err = rows.Scan(&report.CheckedAt, &report.Errors, &report.Warnings, &report.Omitted)
+	}
+	rows.Close()
+	if err != nil {
+		return types.IntegrityReport{}, SQLError{cmd, err}
+	}
+	if !checked {
+		return report, nil
+	}
+
+	cmd = `SELECT check_name, severity, table_name, row_key, message, fix FROM integrity_findings ORDER BY seq`
+	rows, err = q.QueryContext(ctx, cmd)
+	if err != nil {
+		return types.IntegrityReport{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		f := types.IntegrityFinding{}
+		if err = rows.Scan(&f.Check, &f.Severity, &f.Table, &f.Row, &f.Message, &f.Fix); err != nil {
+			return types.IntegrityReport{}, SQLError{cmd, err}
+		}
+		report.Findings = append(report.Findings, f)
+	}
+	if err = rows.Err(); err != nil {
+		return types.IntegrityReport{}, SQLError{cmd, err}
+	}
+	return report, nil
+}
+
+// newIntegrityUID returns a random cluster UID for the fixes of clusters without UID
+func newIntegrityUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Errorf("could not generate cluster UID: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
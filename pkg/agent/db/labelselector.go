@@ -0,0 +1,42 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// ClusterLabelConds returns the conditions on the clusters table selecting the
+// clusters meeting the requirements of sel, and their arguments, after
+// checking the requirements
+// placeholder returns the placeholder of the nth argument of the statement,
+// n arguments coming before those of the conditions
+// each condition looks the label up with the (cluster_id, label) key of
+// cluster_labels
+func ClusterLabelConds(sel types.LabelSelector, placeholder func(n int) string, n int) ([]string, []interface{}, error) {
+	conds := make([]string, 0, len(sel))
+	args := []interface{}{}
+	arg := func(value string) string {
+		args = append(args, value)
+		return placeholder(n + len(args))
+	}
+	for _, r := range sel {
+		if err := r.Validate(); err != nil {
+			return nil, nil, err
+		}
+		cond := `EXISTS (SELECT 1 FROM cluster_labels WHERE cluster_labels.cluster_id=clusters.id AND cluster_labels.label=` + arg(r.Key)
+		if r.Operator == types.LabelOpIn || r.Operator == types.LabelOpNotIn {
+			values := make([]string, len(r.Values))
+			for i, v := range r.Values {
+				values[i] = arg(v)
+			}
+			cond += ` AND cluster_labels.value IN (` + strings.Join(values, ", ") + `)`
+		}
+		cond += `)`
+		if r.Operator == types.LabelOpNotIn || r.Operator == types.LabelOpDoesNotExist {
+			cond = `NOT ` + cond
+		}
+		conds = append(conds, cond)
+	}
+	return conds, args, nil
+}
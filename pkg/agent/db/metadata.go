@@ -0,0 +1,27 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// MetadataValue returns the metadata of a cluster as stored by the DataStores,
+// compact JSON text, NULL if the cluster has none
+// returns PostFailure if the metadata is not valid JSON
+func MetadataValue(metadata json.RawMessage) (sql.NullString, error) {
+	compact, err := types.CompactMetadata(metadata)
+	if err != nil {
+		return sql.NullString{}, PostFailure{Message: err.Error()}
+	}
+	return sql.NullString{String: string(compact), Valid: compact != nil}, nil
+}
+
+// ParseMetadata returns the stored metadata of a cluster, nil if NULL
+func ParseMetadata(value sql.NullString) json.RawMessage {
+	if !value.Valid || len(value.String) == 0 {
+		return nil
+	}
+	return json.RawMessage(value.String)
+}
@@ -0,0 +1,21 @@
+-- removes all tables of the sqlite DataStore
+DROP TABLE IF EXISTS snapshots;
+DROP TABLE IF EXISTS plugin_types;
+DROP TABLE IF EXISTS cluster_history;
+DROP TABLE IF EXISTS failed_operations;
+DROP TABLE IF EXISTS cluster_tokens;
+DROP TABLE IF EXISTS bootstrap_tokens;
+DROP TABLE IF EXISTS agent_labels;
+DROP TABLE IF EXISTS cluster_labels;
+DROP TABLE IF EXISTS bundle_freshness;
+DROP TABLE IF EXISTS ownership_transfers;
+DROP TABLE IF EXISTS entry_owners;
+DROP TABLE IF EXISTS agent_compliance_history;
+DROP TABLE IF EXISTS agent_compliance;
+DROP TABLE IF EXISTS cluster_extensions;
+DROP TABLE IF EXISTS service_accounts;
+DROP TABLE IF EXISTS entry_lineage;
+DROP TABLE IF EXISTS spire_query_log;
+DROP TABLE IF EXISTS cluster_memberships;
+DROP TABLE IF EXISTS clusters;
+DROP TABLE IF EXISTS agents;
@@ -0,0 +1,120 @@
+-- schema of the sqlite DataStore when versioned migrations were introduced
+-- DBs created before are brought to this version by adoptLegacySchema
+
+-- agent table with fields spiffeid, plugin_type_id and display_name
+-- plugin is the free-form plugin of older versions, see migratePluginTypes
+CREATE TABLE IF NOT EXISTS agents
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, spiffeid TEXT, plugin TEXT, display_name TEXT,
+    plugin_type_id INTEGER REFERENCES plugin_types(id), UNIQUE (spiffeid));
+
+-- cluster table with fields name, uid, domainName, platformtype, managedby, owner contacts and tenant
+CREATE TABLE IF NOT EXISTS clusters
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, created_at TEXT,
+    domain_name TEXT, platform_type TEXT, managed_by TEXT,
+    owner_email TEXT, owner_team TEXT, slack_channel TEXT, tenant TEXT, uid TEXT, UNIQUE (name));
+
+-- cluster - agent relation table specifying by clusterid and spiffeid
+-- enforces uniqueness of spiffeid
+CREATE TABLE IF NOT EXISTS cluster_memberships
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, agent_id int, cluster_id int,
+    FOREIGN KEY (agent_id) REFERENCES agents(id),
+    FOREIGN KEY (cluster_id) REFERENCES clusters(id), UNIQUE (agent_id));
+
+-- ring buffer of calls made to the SPIRE server API
+CREATE TABLE IF NOT EXISTS spire_query_log
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, method TEXT, duration_ms INTEGER,
+    status TEXT, user TEXT, request_id TEXT, created_at TEXT);
+
+-- entry - source entry relation table recording which entry a SPIRE entry was cloned from
+CREATE TABLE IF NOT EXISTS entry_lineage
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, entry_id TEXT, source_entry_id TEXT,
+    created_by TEXT, created_at TEXT, UNIQUE (entry_id));
+
+-- service account table with role bindings and hash of the API key
+CREATE TABLE IF NOT EXISTS service_accounts
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, description TEXT, roles TEXT,
+    key_hash TEXT, created_by TEXT, created_at TEXT, UNIQUE (name), UNIQUE (key_hash));
+
+-- cluster - extension field relation table with JSON-encoded values
+CREATE TABLE IF NOT EXISTS cluster_extensions
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, cluster_id int, field TEXT, value TEXT,
+    FOREIGN KEY (cluster_id) REFERENCES clusters(id), UNIQUE (cluster_id, field));
+
+-- current compliance attributes of agents, one row per agent and attribute
+CREATE TABLE IF NOT EXISTS agent_compliance
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, agent_id int, attribute TEXT, value TEXT,
+    source TEXT, reported_at TEXT,
+    FOREIGN KEY (agent_id) REFERENCES agents(id), UNIQUE (agent_id, attribute));
+
+-- all reported compliance attribute values of agents
+CREATE TABLE IF NOT EXISTS agent_compliance_history
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, agent_id int, attribute TEXT, value TEXT,
+    source TEXT, reported_at TEXT, FOREIGN KEY (agent_id) REFERENCES agents(id));
+
+-- owner team and tenant of SPIRE entries tracked by Tornjak
+CREATE TABLE IF NOT EXISTS entry_owners
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, entry_id TEXT, owner_team TEXT, tenant TEXT,
+    updated_at TEXT, UNIQUE (entry_id));
+
+-- audit records of ownership transfers, one row per transferred cluster or entry
+CREATE TABLE IF NOT EXISTS ownership_transfers
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, object_type TEXT, object_id TEXT,
+    from_team TEXT, to_team TEXT, from_tenant TEXT, to_tenant TEXT, reason TEXT,
+    transferred_by TEXT, transferred_at TEXT);
+
+-- result of the last check of the bundle of each federated trust domain
+CREATE TABLE IF NOT EXISTS bundle_freshness
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, trust_domain TEXT, bundle_endpoint_url TEXT,
+    profile TEXT, reachable INTEGER, error TEXT, in_sync INTEGER, local_sequence INTEGER,
+    endpoint_sequence INTEGER, local_expires_at TEXT, monitored_since TEXT, checked_at TEXT,
+    last_reachable_at TEXT, last_in_sync_at TEXT, stale INTEGER, stale_reason TEXT,
+    UNIQUE (trust_domain));
+
+-- cluster - label relation table
+CREATE TABLE IF NOT EXISTS cluster_labels
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, cluster_id int, label TEXT, value TEXT,
+    FOREIGN KEY (cluster_id) REFERENCES clusters(id), UNIQUE (cluster_id, label));
+
+-- agent - label relation table
+CREATE TABLE IF NOT EXISTS agent_labels
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, agent_id int, label TEXT, value TEXT,
+    FOREIGN KEY (agent_id) REFERENCES agents(id), UNIQUE (agent_id, label));
+
+-- join tokens issued by the bootstrap broker, tracked by the hash of the token
+CREATE TABLE IF NOT EXISTS bootstrap_tokens
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, token_id TEXT, token_hash TEXT, agent_id TEXT,
+    description TEXT, state TEXT, issued_by TEXT, issued_at TEXT, expires_at TEXT,
+    consumed_at TEXT, consumed_by TEXT, UNIQUE (token_id), UNIQUE (token_hash));
+
+-- API keys restricted to reading or editing a single cluster, by cluster UID
+CREATE TABLE IF NOT EXISTS cluster_tokens
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, cluster_uid TEXT, access TEXT,
+    description TEXT, key_hash TEXT, created_by TEXT, created_at TEXT,
+    UNIQUE (name), UNIQUE (key_hash));
+
+-- incomplete steps of partially failed operations, retried until resolved
+CREATE TABLE IF NOT EXISTS failed_operations
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, operation TEXT, step TEXT, payload TEXT,
+    state TEXT, attempts INTEGER, last_error TEXT, created_by TEXT, created_at TEXT,
+    updated_at TEXT, resolved_by TEXT, resolution_note TEXT);
+
+-- state of clusters after each change, by cluster UID, for queries of past states
+CREATE TABLE IF NOT EXISTS cluster_history
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, cluster_uid TEXT, name TEXT, change TEXT,
+    snapshot TEXT, changed_at TEXT);
+CREATE INDEX IF NOT EXISTS cluster_history_changed_at ON cluster_history (changed_at);
+
+-- plugin types of agents, the known types and the custom types assigned to agents
+-- names are unique regardless of case
+CREATE TABLE IF NOT EXISTS plugin_types
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT COLLATE NOCASE, custom INTEGER, UNIQUE (name));
+
+-- named snapshots of the DB, stored as backups in the snapshot directory
+-- kept across restores, see snapshotExcludedTables
+CREATE TABLE IF NOT EXISTS snapshots
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, created_by TEXT, created_at TEXT,
+    bytes INTEGER, UNIQUE (name));
+
+-- UIDs of clusters, stable across renames; clusters created before UIDs are given one
+UPDATE clusters SET uid=lower(hex(randomblob(16))) WHERE uid IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS clusters_uid ON clusters (uid);
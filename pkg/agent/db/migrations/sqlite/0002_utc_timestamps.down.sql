@@ -0,0 +1,4 @@
+-- the rows of clusters and the snapshots of cluster_history are converted back
+-- to "Jan 02 2006 15:04:05" in local time by downgradeTimestamps in
+-- sqlite_migrations.go
+SELECT 1;
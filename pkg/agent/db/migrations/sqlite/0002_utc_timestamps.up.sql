@@ -0,0 +1,8 @@
+-- creation times of clusters are stored in RFC3339 in UTC rather than in
+-- "Jan 02 2006 15:04:05" in the local time of the server, so they sort as text
+-- and do not depend on the time zone of the server
+--
+-- the rows of clusters and the snapshots of cluster_history are converted by
+-- upgradeTimestamps in sqlite_migrations.go, as the local time cannot be
+-- converted to UTC in SQL
+SELECT 1;
@@ -0,0 +1,3 @@
+ALTER TABLE agents DROP COLUMN updated_at;
+ALTER TABLE agents DROP COLUMN created_at;
+ALTER TABLE clusters DROP COLUMN updated_at;
@@ -0,0 +1,8 @@
+-- times of the last change of clusters and agents, and of the creation of agents,
+-- in RFC3339 in UTC
+-- clusters were last changed at the latest when created; agents created before
+-- this version have no creation or change time
+ALTER TABLE clusters ADD COLUMN updated_at TEXT;
+UPDATE clusters SET updated_at=created_at;
+ALTER TABLE agents ADD COLUMN created_at TEXT;
+ALTER TABLE agents ADD COLUMN updated_at TEXT;
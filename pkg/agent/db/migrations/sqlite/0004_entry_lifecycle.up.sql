@@ -0,0 +1,5 @@
+-- lifecycle states of SPIRE entries, with the time scheduled removals are due
+CREATE TABLE IF NOT EXISTS entry_lifecycles
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, entry_id TEXT, state TEXT, remove_at TEXT,
+    reason TEXT, updated_by TEXT, updated_at TEXT, notified_at TEXT, UNIQUE (entry_id));
+CREATE INDEX IF NOT EXISTS entry_lifecycles_state ON entry_lifecycles (state, remove_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS note_revisions;
+DROP TABLE IF EXISTS notes;
@@ -0,0 +1,9 @@
+-- markdown notes of operators attached to clusters, agents and entries
+CREATE TABLE IF NOT EXISTS notes
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, object_type TEXT, object_id TEXT, body TEXT,
+    author TEXT, created_at TEXT, updated_by TEXT, updated_at TEXT);
+CREATE INDEX IF NOT EXISTS notes_object ON notes (object_type, object_id);
+-- every version of the text of a note, written at its creation and at each edit
+CREATE TABLE IF NOT EXISTS note_revisions
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, note_id INTEGER, body TEXT, edited_by TEXT, edited_at TEXT,
+    FOREIGN KEY (note_id) REFERENCES notes(id));
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS clusters_search_delete;
+DROP TRIGGER IF EXISTS clusters_search_update;
+DROP TRIGGER IF EXISTS clusters_search_insert;
+DROP TABLE IF EXISTS clusters_search_pending;
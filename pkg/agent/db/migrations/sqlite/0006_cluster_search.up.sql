@@ -0,0 +1,13 @@
+-- clusters changed since the full-text index of clusters was last updated, see applyClusterSearchIndex
+-- the index itself is created when the DB is opened by a Tornjak built with FTS5
+CREATE TABLE IF NOT EXISTS clusters_search_pending (id INTEGER PRIMARY KEY);
+CREATE TRIGGER IF NOT EXISTS clusters_search_insert AFTER INSERT ON clusters BEGIN
+    INSERT OR IGNORE INTO clusters_search_pending (id) VALUES (new.id);
+END;
+CREATE TRIGGER IF NOT EXISTS clusters_search_update
+    AFTER UPDATE OF name, domain_name, managed_by, platform_type ON clusters BEGIN
+    INSERT OR IGNORE INTO clusters_search_pending (id) VALUES (new.id);
+END;
+CREATE TRIGGER IF NOT EXISTS clusters_search_delete AFTER DELETE ON clusters BEGIN
+    INSERT OR IGNORE INTO clusters_search_pending (id) VALUES (old.id);
+END;
@@ -0,0 +1,2 @@
+-- clusters whose deletes are rejected until their protection is cleared
+ALTER TABLE clusters ADD COLUMN protected INTEGER NOT NULL DEFAULT 0;
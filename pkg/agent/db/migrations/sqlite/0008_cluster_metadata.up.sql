@@ -0,0 +1,2 @@
+-- JSON objects integrators attach to clusters, NULL if none
+ALTER TABLE clusters ADD COLUMN metadata TEXT;
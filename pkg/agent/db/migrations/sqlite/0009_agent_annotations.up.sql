@@ -0,0 +1,5 @@
+-- key-value annotations of operators on agents, e.g. status: pending decommission
+CREATE TABLE IF NOT EXISTS agent_annotations
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, agent_id int, annotation TEXT, value TEXT,
+    updated_by TEXT, updated_at TEXT,
+    FOREIGN KEY (agent_id) REFERENCES agents(id), UNIQUE (agent_id, annotation));
@@ -0,0 +1,4 @@
+-- deleted clusters with their state when deleted, by cluster UID, until they are restored
+CREATE TABLE IF NOT EXISTS deleted_clusters
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, uid TEXT, name TEXT, snapshot TEXT, deleted_at TEXT,
+    UNIQUE (uid));
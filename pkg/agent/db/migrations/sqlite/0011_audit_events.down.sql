@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS cluster_history_cluster_uid;
+DROP TABLE IF EXISTS audit_events;
@@ -0,0 +1,7 @@
+-- changes of clusters, agents and memberships with the user making them, written in the transaction of each change
+CREATE TABLE IF NOT EXISTS audit_events
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, actor TEXT, action TEXT, object_type TEXT, object_id TEXT,
+    before_state TEXT, after_state TEXT, recorded_at TEXT);
+CREATE INDEX IF NOT EXISTS audit_events_object ON audit_events (object_type, object_id);
+-- state of a cluster before each change, read to record the change
+CREATE INDEX IF NOT EXISTS cluster_history_cluster_uid ON cluster_history (cluster_uid, id);
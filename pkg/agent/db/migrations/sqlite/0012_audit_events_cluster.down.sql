@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS audit_events_cluster;
+ALTER TABLE audit_events DROP COLUMN cluster_uid;
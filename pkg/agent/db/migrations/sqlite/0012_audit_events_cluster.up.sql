@@ -0,0 +1,6 @@
+-- cluster of cluster and membership events, for the history of each cluster
+ALTER TABLE audit_events ADD COLUMN cluster_uid TEXT;
+UPDATE audit_events SET cluster_uid=object_id WHERE object_type='cluster';
+UPDATE audit_events SET cluster_uid=json_extract(COALESCE(NULLIF(before_state, ''), after_state), '$.clusterUid')
+    WHERE object_type='membership';
+CREATE INDEX IF NOT EXISTS audit_events_cluster ON audit_events (cluster_uid, id);
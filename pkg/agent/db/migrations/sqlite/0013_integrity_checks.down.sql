@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS integrity_findings;
+DROP TABLE IF EXISTS integrity_checks;
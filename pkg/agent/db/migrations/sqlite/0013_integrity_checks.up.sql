@@ -0,0 +1,6 @@
+-- report of the last integrity check, a single row, and its findings, see CheckIntegrity
+CREATE TABLE IF NOT EXISTS integrity_checks
+    (id INTEGER PRIMARY KEY, checked_at TEXT, errors INTEGER, warnings INTEGER, omitted INTEGER);
+CREATE TABLE IF NOT EXISTS integrity_findings
+    (seq INTEGER PRIMARY KEY, check_name TEXT, severity TEXT, table_name TEXT, row_key TEXT,
+    message TEXT, fix TEXT);
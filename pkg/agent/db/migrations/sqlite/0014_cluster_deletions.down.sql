@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS cluster_memberships_cluster_id;
+DROP TABLE IF EXISTS cluster_deletions;
@@ -0,0 +1,6 @@
+-- deleted clusters whose agent memberships are removed in the background, see DeleteClusterMemberships
+CREATE TABLE IF NOT EXISTS cluster_deletions
+    (id INTEGER PRIMARY KEY AUTOINCREMENT, cluster_id INTEGER, uid TEXT, name TEXT,
+    memberships INTEGER, deleted_at TEXT, UNIQUE (cluster_id));
+-- memberships are removed by cluster in batches
+CREATE INDEX IF NOT EXISTS cluster_memberships_cluster_id ON cluster_memberships (cluster_id);
@@ -0,0 +1,4 @@
+-- secrets of the server by name, such as the pepper of the hashes of API keys,
+-- stored encrypted by the Encryption plugin
+CREATE TABLE IF NOT EXISTS secrets
+    (name TEXT PRIMARY KEY, value BLOB, updated_at TEXT);
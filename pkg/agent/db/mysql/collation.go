@@ -0,0 +1,56 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/collation"
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+)
+
+// unicodeCollation returns the collation of MySQL names are sorted under with
+// the collation.Unicode collation of locale: the accent and case sensitive
+// collation of the Unicode Collation Algorithm of the language of locale, or
+// of the root locale if locale is empty
+// returns an error if MySQL has no such collation, e.g. before MySQL 8.0
+func unicodeCollation(ctx context.Context, database *sql.DB, locale string) (string, error) {
+	name := "utf8mb4_0900_as_cs"
+	if locale != "" {
+		language, _, _ := strings.Cut(strings.ToLower(locale), "-")
+		name = "utf8mb4_" + language + "_0900_as_cs"
+	}
+	var found string
+	cmd := `SELECT COLLATION_NAME FROM information_schema.COLLATIONS WHERE COLLATION_NAME = ?`
+	err := database.QueryRowContext(ctx, cmd, name).Scan(&found)
+	if err == sql.ErrNoRows {
+		return "", errors.Errorf("The %s collation of locale %q needs the MySQL collation %s, which does not exist",
+			collation.Unicode, locale, name)
+	} else if err != nil {
+		return "", agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return name, nil
+}
+
+// nameOrder sorts a column of names under the collation of the DB with a
+// collation of MySQL, names equal under it ordered by their bytes
+// binary compares bytes with utf8mb4_bin, nocase compares the lowercase names,
+// and unicode uses the collation found by unicodeCollation; as the collations
+// of MySQL are not those used by the other DataStores, ties and characters
+// without a lowercase form may sort differently from them
+func (db *DB) nameOrder(column string, desc bool) string {
+	direction := ""
+	if desc {
+		direction = " DESC"
+	}
+	bytewise := column + " COLLATE utf8mb4_bin" + direction
+	switch db.collation.Name() {
+	case collation.NoCase:
+		return "LOWER(" + column + ") COLLATE utf8mb4_bin" + direction + ", " + bytewise
+	case collation.Unicode:
+		return column + " COLLATE " + db.unicodeCollation + direction + ", " + bytewise
+	}
+	return bytewise
+}
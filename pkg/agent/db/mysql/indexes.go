@@ -0,0 +1,134 @@
+package mysql
+
+import (
+	"fmt"
+	"strings"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// INDEX ADVISOR HANDLERS
+
+// indexPrefixLength is the number of characters of TEXT columns indexed, as
+// MySQL does not index TEXT columns without a prefix length
+const indexPrefixLength = 255
+
+// GetIndexReport returns the statement digests recorded by the Performance
+// Schema taking minMillis or more on average, the indexes of the database of
+// Tornjak and the indexes missing for its queries
+// the Performance Schema is enabled by default; when disabled or not readable
+// by the user of Tornjak, the report has no slow queries, and the missing
+// indexes are suggested without them
+func (db *DB) GetIndexReport(minMillis float64) (types.IndexReport, error) {
+	indexes, err := db.getIndexes()
+	if err != nil {
+		return types.IndexReport{}, err
+	}
+	textColumns, err := db.getTextColumns()
+	if err != nil {
+		return types.IndexReport{}, err
+	}
+	ret := types.IndexReport{Backend: "mysql", SlowQuerySource: "performance_schema.events_statements_summary_by_digest", Indexes: indexes}
+	ret.SlowQueries, err = db.getSlowQueries(minMillis)
+	if err != nil {
+		ret.SlowQueries = []types.SlowQuery{}
+		ret.SlowQueryError = err.Error()
+	}
+	// online DDL builds the index without locking the table against writes
+	ret.Suggestions = agentdb.IndexSuggestions(indexes, ret.SlowQueries, func(c agentdb.IndexCandidate) string {
+		columns := make([]string, len(c.Columns))
+		for i, column := range c.Columns {
+			columns[i] = column
+			if textColumns[c.Table+"."+column] {
+				columns[i] = fmt.Sprintf("%s(%d)", column, indexPrefixLength)
+			}
+		}
+		return fmt.Sprintf("CREATE INDEX %s ON %s (%s) ALGORITHM=INPLACE LOCK=NONE", c.Name(), c.Table, strings.Join(columns, ", "))
+	})
+	return ret, nil
+}
+
+// getIndexes returns the indexes of the tables of the database, with their
+// columns in order
+func (db *DB) getIndexes() ([]types.TableIndex, error) {
+	cmd := `SELECT TABLE_NAME, INDEX_NAME, COALESCE(COLUMN_NAME, '(expression)') FROM information_schema.STATISTICS
+          WHERE TABLE_SCHEMA=DATABASE() ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX`
+	rows, err := db.database.Query(cmd)
+	if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	indexes := []types.TableIndex{}
+	for rows.Next() {
+		var table, name, column string
+		if err = rows.Scan(&table, &name, &column); err != nil {
+			return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		if n := len(indexes); n > 0 && indexes[n-1].Table == table && indexes[n-1].Name == name {
+			indexes[n-1].Columns = append(indexes[n-1].Columns, column)
+		} else {
+			indexes = append(indexes, types.TableIndex{Table: table, Name: name, Columns: []string{column}})
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return indexes, nil
+}
+
+// getTextColumns returns the TEXT and BLOB columns of the database, as
+// table.column, which are indexed on a prefix
+func (db *DB) getTextColumns() (map[string]bool, error) {
+	cmd := `SELECT CONCAT(TABLE_NAME, '.', COLUMN_NAME) FROM information_schema.COLUMNS
+          WHERE TABLE_SCHEMA=DATABASE() AND DATA_TYPE IN ('tinytext', 'text', 'mediumtext', 'longtext', 'tinyblob', 'blob', 'mediumblob', 'longblob')`
+	rows, err := db.database.Query(cmd)
+	if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var column string
+		if err = rows.Scan(&column); err != nil {
+			return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		columns[column] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return columns, nil
+}
+
+// getSlowQueries returns the statement digests of the database taking
+// minMillis or more on average, those taking the most time in total first
+// the timers of the Performance Schema are in picoseconds
+func (db *DB) getSlowQueries(minMillis float64) ([]types.SlowQuery, error) {
+	cmd := `SELECT DIGEST_TEXT, COUNT_STAR, AVG_TIMER_WAIT/1e9, SUM_TIMER_WAIT/1e9
+          FROM performance_schema.events_statements_summary_by_digest
+          WHERE SCHEMA_NAME=DATABASE() AND DIGEST_TEXT IS NOT NULL AND AVG_TIMER_WAIT>=?*1e9
+          ORDER BY SUM_TIMER_WAIT DESC LIMIT ?`
+	rows, err := db.database.Query(cmd, minMillis, agentdb.MaxSlowQueries)
+	if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	queries := []types.SlowQuery{}
+	for rows.Next() {
+		var q types.SlowQuery
+		if err = rows.Scan(&q.Query, &q.Calls, &q.MeanMillis, &q.TotalMillis); err != nil {
+			return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		queries = append(queries, q)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return queries, nil
+}
+
+var _ agentdb.IndexAdvisor = (*DB)(nil)
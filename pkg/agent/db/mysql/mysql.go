@@ -0,0 +1,542 @@
+// Package mysql implements the Tornjak DataStore on a MySQL or MariaDB database,
+// so several Tornjak replicas can share one database
+//
+// it creates the tables of the DataStore, which is the sqlstore.Store on them
+// with the SQL of MySQL
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	backoff "github.com/cenkalti/backoff/v4"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/collation"
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/db/sqlstore"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// Text columns that are keys are VARCHARs, as MySQL does not index TEXT columns
+// without a prefix length; SPIFFE IDs of up to 768 characters fit the limit of
+// InnoDB indexes in utf8mb4. Names and SPIFFE IDs compare in binary collation,
+// as they do in the sqlite DataStore, and plugin type names regardless of case.
+const (
+	// plugin types of agents, the known types and the custom types assigned to agents
+	initPluginTypesTable = `CREATE TABLE IF NOT EXISTS plugin_types
+                            (id INTEGER AUTO_INCREMENT PRIMARY KEY,
+                            name VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL UNIQUE,
+                            custom BOOLEAN NOT NULL) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+	// agent table with fields spiffeid, plugin_type_id and display_name
+	initAgentsTable = `CREATE TABLE IF NOT EXISTS agents
+                            (id INTEGER AUTO_INCREMENT PRIMARY KEY, spiffeid VARCHAR(768) NOT NULL UNIQUE,
+                            plugin_type_id INTEGER, display_name TEXT, created_at TEXT, updated_at TEXT,
+                            FOREIGN KEY (plugin_type_id) REFERENCES plugin_types(id))
+                            ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+	// cluster table with fields uid, name, domainName, platformtype, managedby, owner contacts and tenant
+	//                                name_nocase is indexed under the case-insensitive policy, see initClusterNameNocaseIndex
+	initClustersTable = `CREATE TABLE IF NOT EXISTS clusters
+                            (id INTEGER AUTO_INCREMENT PRIMARY KEY, uid VARCHAR(32) NOT NULL UNIQUE,
+                            name VARCHAR(255) NOT NULL UNIQUE, name_nocase VARCHAR(255) AS (lower(name)) STORED,
+                            created_at TEXT, updated_at TEXT, domain_name TEXT, platform_type TEXT, managed_by TEXT,
+                            owner_email TEXT, owner_team TEXT, slack_channel TEXT, tenant TEXT,
+                            protected BOOLEAN NOT NULL DEFAULT FALSE, metadata MEDIUMTEXT,
+                            search_text TEXT AS (` + clusterSearchText + `) STORED)
+                            ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+	// cluster - agent relation table, an agent is in at most one cluster
+	initClusterMemberTable = `CREATE TABLE IF NOT EXISTS cluster_memberships
+                            (agent_id INTEGER PRIMARY KEY, cluster_id INTEGER NOT NULL,
+                            FOREIGN KEY (agent_id) REFERENCES agents(id),
+                            FOREIGN KEY (cluster_id) REFERENCES clusters(id) ON DELETE CASCADE)
+                            ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+	// cluster - extension field relation table with JSON-encoded values
+	initClusterExtensionsTable = `CREATE TABLE IF NOT EXISTS cluster_extensions
+                            (cluster_id INTEGER, field VARCHAR(255), value TEXT,
+                            PRIMARY KEY (cluster_id, field),
+                            FOREIGN KEY (cluster_id) REFERENCES clusters(id) ON DELETE CASCADE)
+                            ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+	// cluster - label relation table
+	initClusterLabelsTable = `CREATE TABLE IF NOT EXISTS cluster_labels
+                            (cluster_id INTEGER, label VARCHAR(255), value TEXT,
+                            PRIMARY KEY (cluster_id, label),
+                            FOREIGN KEY (cluster_id) REFERENCES clusters(id) ON DELETE CASCADE)
+                            ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+	// agent - label relation table
+	initAgentLabelsTable = `CREATE TABLE IF NOT EXISTS agent_labels
+                            (agent_id INTEGER, label VARCHAR(255), value TEXT,
+                            PRIMARY KEY (agent_id, label),
+                            FOREIGN KEY (agent_id) REFERENCES agents(id) ON DELETE CASCADE)
+                            ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+	// agent - annotation relation table, with the user and time of the last change of each value
+	initAgentAnnotationsTable = `CREATE TABLE IF NOT EXISTS agent_annotations
+                            (agent_id INTEGER, annotation VARCHAR(255), value TEXT,
+                            updated_by TEXT, updated_at VARCHAR(32),
+                            PRIMARY KEY (agent_id, annotation),
+                            FOREIGN KEY (agent_id) REFERENCES agents(id) ON DELETE CASCADE)
+                            ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+	// state of clusters after each change, by cluster UID, for queries of past states
+	initClusterHistoryTable = `CREATE TABLE IF NOT EXISTS cluster_history
+                            (id BIGINT AUTO_INCREMENT PRIMARY KEY, cluster_uid VARCHAR(32), name VARCHAR(255),
+                            change_type VARCHAR(32), snapshot MEDIUMTEXT, changed_at VARCHAR(32),
+                            INDEX cluster_history_changed_at (changed_at))
+                            ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+	// deleted clusters with their state when deleted, by cluster UID, until they are restored
+	initDeletedClustersTable = `CREATE TABLE IF NOT EXISTS deleted_clusters
+                            (id BIGINT AUTO_INCREMENT PRIMARY KEY, uid VARCHAR(32) UNIQUE, name VARCHAR(255),
+                            snapshot MEDIUMTEXT, deleted_at VARCHAR(32))
+                            ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+
+	// markdown notes of operators attached to clusters, agents and entries, with every version of their text
+	initNotesTable = `CREATE TABLE IF NOT EXISTS notes
+                            (id BIGINT AUTO_INCREMENT PRIMARY KEY, object_type VARCHAR(32), object_id VARCHAR(768),
+                            body TEXT, author TEXT, created_at TEXT, updated_by TEXT, updated_at TEXT,
+                            INDEX notes_object (object_type, object_id))
+                            ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+	initNoteRevisionsTable = `CREATE TABLE IF NOT EXISTS note_revisions
+                            (id BIGINT AUTO_INCREMENT PRIMARY KEY, note_id BIGINT, body TEXT,
+                            edited_by TEXT, edited_at TEXT,
+                            FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE)
+                            ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+	// changes of clusters, agents and memberships with the user making them, see GetAuditEvents
+	initAuditEventsTable = `CREATE TABLE IF NOT EXISTS audit_events
+                            (id BIGINT AUTO_INCREMENT PRIMARY KEY, actor TEXT, action VARCHAR(32),
+                            object_type VARCHAR(32), object_id VARCHAR(768), before_state MEDIUMTEXT,
+                            after_state MEDIUMTEXT, recorded_at VARCHAR(32),
+                            INDEX audit_events_object (object_type, object_id))
+                            ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+	// secrets of the server by name, such as the pepper of the hashes of API keys,
+	// stored encrypted by the Encryption plugin, see GetOrCreateSecret
+	initSecretsTable = `CREATE TABLE IF NOT EXISTS secrets
+                            (name VARCHAR(255) PRIMARY KEY, value BLOB, updated_at VARCHAR(32))
+                            ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+	// report of the last integrity check, a single row, and its findings, see CheckIntegrity
+	initIntegrityChecksTable = `CREATE TABLE IF NOT EXISTS integrity_checks
+                            (id INTEGER PRIMARY KEY, checked_at VARCHAR(32), errors INTEGER, warnings INTEGER,
+                            omitted INTEGER) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+	initIntegrityFindingsTable = `CREATE TABLE IF NOT EXISTS integrity_findings
+                            (seq INTEGER PRIMARY KEY, check_name VARCHAR(64), severity VARCHAR(32),
+                            table_name VARCHAR(64), row_key TEXT, message TEXT, fix TEXT)
+                            ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`
+
+	// change times added to tables of earlier releases, see hasColumn
+	backfillClustersUpdatedAt = `UPDATE clusters SET updated_at=created_at WHERE updated_at IS NULL`
+	// clusters whose deletes are rejected, none in earlier releases
+	addClustersProtected = `ALTER TABLE clusters ADD COLUMN protected BOOLEAN NOT NULL DEFAULT FALSE`
+	// JSON metadata of clusters, none in earlier releases
+	// MEDIUMTEXT as TEXT holds less than types.MaxClusterMetadataSize bytes
+	addClustersMetadata = `ALTER TABLE clusters ADD COLUMN metadata MEDIUMTEXT`
+
+	// cluster of cluster and membership events, for the history of each cluster; events
+	// of earlier releases are assigned the cluster of their object or of their state
+	addAuditEventsClusterUID      = `ALTER TABLE audit_events ADD COLUMN cluster_uid VARCHAR(32)`
+	backfillAuditEventsClusterUID = `UPDATE audit_events SET cluster_uid=CASE object_type
+                            WHEN 'cluster' THEN object_id
+                            WHEN 'membership' THEN JSON_UNQUOTE(JSON_EXTRACT(COALESCE(NULLIF(before_state, ''), after_state), '$.clusterUid'))
+                            ELSE '' END`
+	auditEventsClusterIndex     = "audit_events_cluster"
+	initAuditEventsClusterIndex = `CREATE INDEX audit_events_cluster ON audit_events (cluster_uid, id)`
+
+	// lowercased searchable fields of clusters and their full-text index, see SearchClusters
+	// FULLTEXT indexes follow the collation of the column, which is case-sensitive
+	clusterSearchText      = `lower(CONCAT_WS(' ', name, domain_name, managed_by, platform_type))`
+	addClustersSearchText  = `ALTER TABLE clusters ADD COLUMN search_text TEXT AS (` + clusterSearchText + `) STORED`
+	clusterSearchIndex     = "clusters_search"
+	initClusterSearchIndex = `CREATE FULLTEXT INDEX clusters_search ON clusters (search_text)`
+
+	// case-insensitive uniqueness of cluster names, on top of the UNIQUE (name) constraint
+	// MySQL has no IF [NOT] EXISTS for indexes, see hasIndex
+	clusterNameNocaseIndex     = "clusters_name_nocase"
+	initClusterNameNocaseIndex = `CREATE UNIQUE INDEX clusters_name_nocase ON clusters (name_nocase)`
+	dropClusterNameNocaseIndex = `DROP INDEX clusters_name_nocase ON clusters`
+
+	// state of a cluster before each change, read to record the change, none in earlier releases
+	clusterHistoryUIDIndex     = "cluster_history_cluster_uid"
+	initClusterHistoryUIDIndex = `CREATE INDEX cluster_history_cluster_uid ON cluster_history (cluster_uid, id)`
+
+	// name of the lock held while the schema is created, so replicas
+	// starting together do not race on CREATE TABLE IF NOT EXISTS
+	// DDL statements commit implicitly in MySQL, so a transaction does not serialize them
+	schemaLockName = "tornjak_schema"
+	// seconds to wait for the schema lock
+	schemaLockTimeout = 30
+)
+
+// error numbers of MySQL, see https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+const (
+	errDuplicateEntry   = 1062
+	errLockWaitTimeout  = 1205
+	errDeadlock         = 1213
+	errQueryInterrupted = 1317
+	errQueryTimeout     = 3024
+)
+
+// Options contains optional settings of the MySQL DB
+type Options struct {
+	// ClusterNameUniqueness is agentdb.ClusterNameCaseSensitive (default) or agentdb.ClusterNameCaseInsensitive
+	// replicas sharing a database must use the same policy
+	ClusterNameUniqueness string
+	// Collation orders cluster and agent names, collation.Binary if empty
+	Collation string
+	// Locale is the BCP 47 language tag of the collation.Unicode collation
+	Locale string
+	// Clock stamps creation and change times, the clock of the system if nil
+	Clock clock.Clock
+}
+
+type DB struct {
+	*sqlstore.Store
+	database *sql.DB
+
+	// orders names in lists; pages of names are sorted with ORDER BY under
+	// the matching collation of the database, see nameOrder
+	collation *collation.Collation
+	// collation of MySQL matching the collation.Unicode collation, see unicodeCollation
+	unicodeCollation string
+}
+
+// New connects to the MySQL database of dsn, a data source name such as
+// tornjak:password@tcp(db.example.org:3306)/tornjak?tls=true, and creates the
+// Tornjak tables missing from it
+// transactions that fail on a conflict with a concurrent transaction, e.g. of
+// another replica, are retried with backOffParams
+func New(dsn string, backOffParams backoff.BackOff, opts Options) (*DB, error) {
+	nameCollation, err := collation.New(opts.Collation, opts.Locale)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := mysqldriver.ParseDSN(dsn)
+	if err != nil {
+		return nil, errors.Errorf("Invalid connection string: %v", err)
+	}
+	// UPDATE reports the rows matched rather than changed, as in sqlite
+	config.ClientFoundRows = true
+	connector, err := mysqldriver.NewConnector(config)
+	if err != nil {
+		return nil, errors.Errorf("Unable to open connection to DB: %v", err)
+	}
+	database := sql.OpenDB(connector)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err = database.PingContext(ctx); err != nil {
+		database.Close()
+		return nil, errors.Errorf("Unable to connect to DB: %v", err)
+	}
+
+	db := &DB{
+		database:  database,
+		collation: nameCollation,
+	}
+	if nameCollation.Name() == collation.Unicode {
+		if db.unicodeCollation, err = unicodeCollation(ctx, database, nameCollation.Locale()); err != nil {
+			database.Close()
+			return nil, err
+		}
+	}
+	if err = db.initSchema(ctx, opts.ClusterNameUniqueness); err != nil {
+		database.Close()
+		return nil, err
+	}
+	db.Store, err = sqlstore.New(database, db.dialect(), backOffParams, sqlstore.Options{Collation: nameCollation, Clock: opts.Clock})
+	if err != nil {
+		database.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// initSchema creates the missing tables and applies the cluster name uniqueness policy
+// on one connection holding the schema lock
+func (db *DB) initSchema(ctx context.Context, clusterNameUniqueness string) error {
+	switch clusterNameUniqueness {
+	case "", agentdb.ClusterNameCaseSensitive, agentdb.ClusterNameCaseInsensitive:
+	default:
+		return errors.Errorf("Invalid cluster name uniqueness policy %q", clusterNameUniqueness)
+	}
+
+	conn, err := db.database.Conn(ctx)
+	if err != nil {
+		return errors.Errorf("Error initializing context: %v", err)
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	cmdLock := `SELECT GET_LOCK(?, ?)`
+	if err = conn.QueryRowContext(ctx, cmdLock, schemaLockName, schemaLockTimeout).Scan(&locked); err != nil {
+		return agentdb.SQLError{Cmd: cmdLock, Err: err}
+	}
+	if locked.Int64 != 1 {
+		return errors.Errorf("Timed out waiting for lock %s held by another Tornjak", schemaLockName)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, schemaLockName) //nolint:errcheck // released on close
+
+	initTableList := []string{initPluginTypesTable, initAgentsTable, initClustersTable,
+		initClusterMemberTable, initClusterExtensionsTable, initClusterLabelsTable, initAgentLabelsTable,
+		initAgentAnnotationsTable, initClusterHistoryTable, initDeletedClustersTable,
+		initNotesTable, initNoteRevisionsTable, initAuditEventsTable, initIntegrityChecksTable, initIntegrityFindingsTable, initSecretsTable}
+	for _, cmd := range initTableList {
+		if _, err = conn.ExecContext(ctx, cmd); err != nil {
+			return agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+	}
+	// MySQL has no IF NOT EXISTS for columns
+	addedColumns := [][2]string{{"clusters", "updated_at"}, {"agents", "created_at"}, {"agents", "updated_at"}}
+	for _, c := range addedColumns {
+		exists, err := hasColumn(ctx, conn, c[0], c[1])
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		cmd := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s TEXT", c[0], c[1])
+		if _, err = conn.ExecContext(ctx, cmd); err != nil {
+			return agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+	}
+	// creation times stored by earlier releases in local time
+	err = agentdb.ConvertTimestamps(ctx, conn, func(n int) string { return "?" }, func(value string) (string, bool) {
+		return agentdb.UpgradeTimestamp(value, time.Local)
+	})
+	if err != nil {
+		return errors.Errorf("could not convert creation times to UTC: %v", err)
+	}
+	if _, err = conn.ExecContext(ctx, backfillClustersUpdatedAt); err != nil {
+		return agentdb.SQLError{Cmd: backfillClustersUpdatedAt, Err: err}
+	}
+
+	exists, err := hasColumn(ctx, conn, "clusters", "protected")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if _, err = conn.ExecContext(ctx, addClustersProtected); err != nil {
+			return agentdb.SQLError{Cmd: addClustersProtected, Err: err}
+		}
+	}
+	exists, err = hasColumn(ctx, conn, "clusters", "metadata")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if _, err = conn.ExecContext(ctx, addClustersMetadata); err != nil {
+			return agentdb.SQLError{Cmd: addClustersMetadata, Err: err}
+		}
+	}
+	exists, err = hasColumn(ctx, conn, "clusters", "search_text")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if _, err = conn.ExecContext(ctx, addClustersSearchText); err != nil {
+			return agentdb.SQLError{Cmd: addClustersSearchText, Err: err}
+		}
+	}
+	exists, err = hasColumn(ctx, conn, "audit_events", "cluster_uid")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		for _, cmd := range []string{addAuditEventsClusterUID, backfillAuditEventsClusterUID} {
+			if _, err = conn.ExecContext(ctx, cmd); err != nil {
+				return agentdb.SQLError{Cmd: cmd, Err: err}
+			}
+		}
+	}
+	indexed, err := hasIndex(ctx, conn, "clusters", clusterSearchIndex)
+	if err != nil {
+		return err
+	}
+	if !indexed {
+		if _, err = conn.ExecContext(ctx, initClusterSearchIndex); err != nil {
+			return agentdb.SQLError{Cmd: initClusterSearchIndex, Err: err}
+		}
+	}
+	indexed, err = hasIndex(ctx, conn, "cluster_history", clusterHistoryUIDIndex)
+	if err != nil {
+		return err
+	}
+	if !indexed {
+		if _, err = conn.ExecContext(ctx, initClusterHistoryUIDIndex); err != nil {
+			return agentdb.SQLError{Cmd: initClusterHistoryUIDIndex, Err: err}
+		}
+	}
+	indexed, err = hasIndex(ctx, conn, "audit_events", auditEventsClusterIndex)
+	if err != nil {
+		return err
+	}
+	if !indexed {
+		if _, err = conn.ExecContext(ctx, initAuditEventsClusterIndex); err != nil {
+			return agentdb.SQLError{Cmd: initAuditEventsClusterIndex, Err: err}
+		}
+	}
+
+	indexed, err = hasIndex(ctx, conn, "clusters", clusterNameNocaseIndex)
+	if err != nil {
+		return err
+	}
+	switch {
+	case clusterNameUniqueness == agentdb.ClusterNameCaseInsensitive && !indexed:
+		if _, err = conn.ExecContext(ctx, initClusterNameNocaseIndex); err != nil {
+			if errorNumber(err) == errDuplicateEntry {
+				return errors.New("Cannot enforce case-insensitive cluster names: existing clusters have names differing only by case")
+			}
+			return agentdb.SQLError{Cmd: initClusterNameNocaseIndex, Err: err}
+		}
+	case clusterNameUniqueness != agentdb.ClusterNameCaseInsensitive && indexed:
+		if _, err = conn.ExecContext(ctx, dropClusterNameNocaseIndex); err != nil {
+			return agentdb.SQLError{Cmd: dropClusterNameNocaseIndex, Err: err}
+		}
+	}
+	return nil
+}
+
+// hasIndex returns whether the table of the current database has the index
+func hasIndex(ctx context.Context, conn *sql.Conn, table, index string) (bool, error) {
+	var n int
+	cmd := `SELECT COUNT(*) FROM information_schema.statistics
+          WHERE table_schema=DATABASE() AND table_name=? AND index_name=?`
+	if err := conn.QueryRowContext(ctx, cmd, table, index).Scan(&n); err != nil {
+		return false, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return n > 0, nil
+}
+
+// hasColumn returns whether the table of the current database has the column
+func hasColumn(ctx context.Context, conn *sql.Conn, table, column string) (bool, error) {
+	var n int
+	cmd := `SELECT COUNT(*) FROM information_schema.columns
+          WHERE table_schema=DATABASE() AND table_name=? AND column_name=?`
+	if err := conn.QueryRowContext(ctx, cmd, table, column).Scan(&n); err != nil {
+		return false, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return n > 0, nil
+}
+
+// WithActor returns the DB recording actor as the user making the changes
+// made through it in the audit log; the DB itself records no actor
+func (db *DB) WithActor(actor string) agentdb.AgentDB {
+	view := *db
+	view.Store = db.Store.ForActor(actor)
+	return &view
+}
+
+// dialect returns the SQL of MySQL run by the Store
+func (db *DB) dialect() *sqlstore.Dialect {
+	return &sqlstore.Dialect{
+		Name: "mysql",
+		Placeholder: func(n int) string {
+			return "?"
+		},
+		NameOrder:           db.nameOrder,
+		HistoryChangeColumn: "change_type",
+		// FOR UPDATE OF is not supported by MariaDB
+		ForUpdateOf: func(table string) string {
+			return "FOR UPDATE"
+		},
+		Upsert:        upsert,
+		UpsertChanged: upsertChanged,
+		InsertID:      insertID,
+		// replicas checking together deadlock on the report, and are retried
+		LockIntegrityReport: "",
+		SearchClusters:      searchClusters,
+		UniqueViolation:     uniqueViolation,
+		Retryable:           isRetryable,
+		RollbackCause:       rollbackCause,
+	}
+}
+
+// upsert returns the ON DUPLICATE KEY clause of sqlstore.Dialect.Upsert
+// without columns key, a single column, is assigned to itself
+func upsert(key string, columns ...string) string {
+	if len(columns) == 0 {
+		return `ON DUPLICATE KEY UPDATE ` + key + `=` + key
+	}
+	set := make([]string, len(columns))
+	for i, column := range columns {
+		set[i] = column + "=VALUES(" + column + ")"
+	}
+	return `ON DUPLICATE KEY UPDATE ` + strings.Join(set, ", ")
+}
+
+// upsertChanged returns the ON DUPLICATE KEY clause of sqlstore.Dialect.UpsertChanged
+// updated_at is assigned first, as assignments see the values assigned before them
+func upsertChanged(table, key, column string) string {
+	return fmt.Sprintf(`ON DUPLICATE KEY UPDATE updated_at=IF(%s <=> VALUES(%s), updated_at, VALUES(updated_at)),
+          %s=VALUES(%s)`, column, column, column, column)
+}
+
+// insertID returns the id of the row inserted by cmd, see sqlstore.Dialect.InsertID
+func insertID(ctx context.Context, tx *sql.Tx, cmd string, args ...interface{}) (int64, error) {
+	res, err := tx.ExecContext(ctx, cmd, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// searchClusters returns the full-text query of sqlstore.Dialect.SearchClusters
+// on the clusters_search index
+// words shorter than innodb_ft_min_token_size or in the stopword list of the
+// server are not indexed by MySQL
+func searchClusters(terms []string) (string, []interface{}) {
+	// words only have letters and digits, so need no escaping in the query
+	prefixes := make([]string, len(terms))
+	for i, term := range terms {
+		prefixes[i] = "+" + term + "*"
+	}
+	against := strings.Join(prefixes, " ")
+	cmd := `SELECT name FROM clusters WHERE MATCH (search_text) AGAINST (? IN BOOLEAN MODE)
+          ORDER BY MATCH (search_text) AGAINST (? IN BOOLEAN MODE) DESC, name`
+	return cmd, []interface{}{against, against}
+}
+
+// errorNumber returns the MySQL error number of err, 0 if err is not raised by MySQL
+func errorNumber(err error) uint16 {
+	var merr *mysqldriver.MySQLError
+	if errors.As(err, &merr) {
+		return merr.Number
+	}
+	return 0
+}
+
+// isRetryable returns whether the transaction failed on a conflict with a concurrent
+// transaction and can be retried as is
+func isRetryable(err error) bool {
+	number := errorNumber(err)
+	return number == errDeadlock || number == errLockWaitTimeout
+}
+
+// uniqueViolation returns whether err is a duplicate entry of a unique index,
+// of the index named index unless empty
+func uniqueViolation(err error, index string) bool {
+	var merr *mysqldriver.MySQLError
+	return errors.As(err, &merr) && merr.Number == errDuplicateEntry && (index == "" || strings.Contains(merr.Message, index))
+}
+
+// rollbackCause returns the cause of an error of MySQL a transaction is
+// rolled back upon, with the causes of the sqlite DataStore
+func rollbackCause(err error) (string, bool) {
+	var merr *mysqldriver.MySQLError
+	if !errors.As(err, &merr) {
+		return "", false
+	}
+	switch {
+	case merr.Number == errQueryInterrupted || merr.Number == errQueryTimeout:
+		return types.RollbackCauseCanceled, true
+	case isRetryable(err):
+		return types.RollbackCauseBusy, true
+	// SQLSTATE class 23 is integrity constraint violation
+	case merr.SQLState[0] == '2' && merr.SQLState[1] == '3':
+		return types.RollbackCauseConstraint, true
+	}
+	return types.RollbackCauseOther, true
+}
+
+var _ agentdb.AgentDB = (*DB)(nil)
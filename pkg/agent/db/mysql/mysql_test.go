@@ -0,0 +1,199 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	backoff "github.com/cenkalti/backoff/v4"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/db/dbtest"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// data source name of the database the tests run against, they are skipped if unset
+// the tests drop the Tornjak tables of the database
+const testConnectionStringEnv = "TORNJAK_TEST_MYSQL"
+
+func TestRollbackCause(t *testing.T) {
+	for err, expected := range map[error]string{
+		agentdb.SQLError{Cmd: "INSERT", Err: &mysqldriver.MySQLError{Number: errDuplicateEntry, SQLState: [5]byte{'2', '3', '0', '0', '0'}}}: types.RollbackCauseConstraint,
+		agentdb.SQLError{Cmd: "INSERT", Err: &mysqldriver.MySQLError{Number: errDeadlock, SQLState: [5]byte{'4', '0', '0', '0', '1'}}}:       types.RollbackCauseBusy,
+		agentdb.SQLError{Cmd: "INSERT", Err: &mysqldriver.MySQLError{Number: errQueryTimeout, SQLState: [5]byte{'H', 'Y', '0', '0', '0'}}}:   types.RollbackCauseCanceled,
+		agentdb.SQLError{Cmd: "INSERT", Err: &mysqldriver.MySQLError{Number: 1146, SQLState: [5]byte{'4', '2', 'S', '0', '2'}}}:              types.RollbackCauseOther,
+	} {
+		if got, ok := rollbackCause(err); !ok || got != expected {
+			t.Fatalf("Expected cause %s of %v, got %s", expected, err, got)
+		}
+	}
+	// CHECK errors not raised by the database are classified by the Store
+	for _, err := range []error{agentdb.PostFailure{Message: "Cluster already exists"}, errors.New("unknown")} {
+		if got, ok := rollbackCause(err); ok {
+			t.Fatalf("Expected no cause of %v, got %s", err, got)
+		}
+	}
+	if !isRetryable(agentdb.SQLError{Cmd: "UPDATE", Err: &mysqldriver.MySQLError{Number: errLockWaitTimeout}}) {
+		t.Fatal("Expected lock wait timeout retryable")
+	}
+}
+
+// newTestDB returns a DataStore on an emptied test database, skips the test if none is configured
+func newTestDB(t *testing.T, opts Options) *DB {
+	connectionString := os.Getenv(testConnectionStringEnv)
+	if connectionString == "" {
+		t.Skipf("%s not set", testConnectionStringEnv)
+	}
+	database, err := sql.Open("mysql", connectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	cmd := `DROP TABLE IF EXISTS secrets, integrity_findings, integrity_checks, audit_events, note_revisions, notes, deleted_clusters, cluster_history, agent_annotations, agent_labels, cluster_labels, cluster_extensions,
+          cluster_memberships, clusters, agents, plugin_types`
+	if _, err = database.Exec(cmd); err != nil {
+		t.Fatal(err)
+	}
+	return openTestDB(t, connectionString, opts)
+}
+
+// openTestDB opens another DataStore on the test database, as a replica would
+func openTestDB(t *testing.T, connectionString string, opts Options) *DB {
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = 5 * time.Second
+	db, err := New(connectionString, expBackoff, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestConformance runs the conformance suite of the DataStores against the test database
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, clk clock.Clock) agentdb.AgentDB {
+		return newTestDB(t, Options{Clock: clk})
+	})
+}
+
+// TestReplicas checks concurrent writes of DataStores sharing the database keep agents in one cluster
+func TestReplicas(t *testing.T) {
+	db := newTestDB(t, Options{ClusterNameUniqueness: agentdb.ClusterNameCaseInsensitive})
+	replica := openTestDB(t, os.Getenv(testConnectionStringEnv), Options{ClusterNameUniqueness: agentdb.ClusterNameCaseInsensitive})
+
+	// ATTEMPT create clusters with the same name regardless of case and the same agent on both
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, d := range []*DB{db, replica} {
+		wg.Add(1)
+		go func(i int, d *DB) {
+			defer wg.Done()
+			errs[i] = d.CreateClusterEntry(types.ClusterInfo{
+				Name:       []string{"Prod", "prod"}[i],
+				AgentsList: []string{"spiffe://example.org/agent"},
+			})
+		}(i, d)
+	}
+	wg.Wait()
+
+	// CHECK exactly one succeeded
+	var pf agentdb.PostFailure
+	if (errs[0] == nil) == (errs[1] == nil) {
+		t.Fatalf("Expected exactly one creation to succeed, got %v", errs)
+	}
+	for _, err := range errs {
+		if err != nil && !errors.As(err, &pf) {
+			t.Fatalf("Expected PostFailure, got %v", err)
+		}
+	}
+	clusters, err := replica.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters.Clusters) != 1 || len(clusters.Clusters[0].AgentsList) != 1 {
+		t.Fatalf("Unexpected clusters %+v", clusters.Clusters)
+	}
+}
+
+// TestIndexReport checks the report lists the indexes of the schema and
+// suggests the missing ones
+func TestIndexReport(t *testing.T) {
+	db := newTestDB(t, Options{})
+
+	// ATTEMPT report on the indexes [GetIndexReport]
+	report, err := db.GetIndexReport(agentdb.DefaultSlowQueryMillis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Backend != "mysql" || report.SlowQueries == nil {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	// CHECK indexes of the schema are listed and not suggested
+	found := false
+	for _, index := range report.Indexes {
+		if index.Table == "notes" && reflect.DeepEqual(index.Columns, []string{"object_type", "object_id"}) {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Expected the index of notes in %+v", report.Indexes)
+	}
+	// CHECK missing indexes are suggested with their DDL
+	for _, s := range report.Suggestions {
+		if s.Table == "clusters" && s.Columns[0] == "platform_type" {
+			if s.DDL == "" {
+				t.Fatalf("Expected the DDL of %+v", s)
+			}
+			return
+		}
+	}
+	t.Fatalf("Expected an index of platform_type suggested, got %+v", report.Suggestions)
+}
+
+// TestIntegrityCheck checks clusters stored without UID are reported with a
+// fix restoring the integrity of the DB
+func TestIntegrityCheck(t *testing.T) {
+	db := newTestDB(t, Options{})
+	ctx := context.Background()
+	if err := db.CreateClusterEntry(types.ClusterInfo{Name: "prod", AgentsList: []string{"agent1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT check a consistent DB [CheckIntegrity]
+	report, err := db.CheckIntegrity(ctx)
+	if err != nil || report.CheckedAt == "" || len(report.Findings) != 0 {
+		t.Fatalf("Unexpected report of a consistent DB %+v: %v", report, err)
+	}
+
+	// ATTEMPT check a cluster without UID [CheckIntegrity, GetIntegrityReport]
+	if _, err = db.database.Exec(`UPDATE clusters SET uid='' WHERE name='prod'`); err != nil {
+		t.Fatal(err)
+	}
+	report, err = db.CheckIntegrity(ctx)
+	if err != nil || len(report.Findings) != 1 || report.Findings[0].Check != types.IntegrityClusterMissingUID ||
+		report.Errors != 1 {
+		t.Fatalf("Unexpected report %+v: %v", report, err)
+	}
+	stored, err := db.GetIntegrityReport()
+	if err != nil || !reflect.DeepEqual(stored, report) {
+		t.Fatalf("Stored report %+v differs from the report of the check %+v: %v", stored, report, err)
+	}
+
+	// CHECK the suggested fix restores the integrity of the DB [CheckIntegrity]
+	fix := report.Findings[0].Fix
+	if _, err = db.database.Exec(fix[strings.Index(fix, ": ")+2:]); err != nil {
+		t.Fatalf("Fix %q failed: %v", fix, err)
+	}
+	report, err = db.CheckIntegrity(ctx)
+	if err != nil || len(report.Findings) != 0 {
+		t.Fatalf("Unexpected report after the fix %+v: %v", report, err)
+	}
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// NameOrder returns the terms of an ORDER BY clause sorting column, a column
+// of names, under the collation of a DataStore, in descending order if desc
+// names equal under the collation are ordered by their bytes, so pages are
+// stable; each DataStore maps its collation to one of its SQL database
+type NameOrder func(column string, desc bool) string
+
+// NamesPage returns the names of column in the rows of from, a FROM clause
+// with its joins and conditions, of the page selected by opts, sorted under
+// order and paged by the SQL database, with the offset of the page and the
+// number of rows
+// names are sorted in descending order if the first sort field of opts is
+// descending; callers check the sort fields name the names
+func NamesPage(ctx context.Context, q Queryer, column string, from string, args []interface{}, order NameOrder, opts types.ListOptions) ([]string, int, int, error) {
+	offset, limit, err := opts.PageBounds()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	cmdCount := `SELECT COUNT(*) FROM ` + from
+	rows, err := q.QueryContext(ctx, cmdCount, args...)
+	if err != nil {
+		return nil, 0, 0, SQLError{cmdCount, err}
+	}
+	var total int
+	for rows.Next() {
+		if err = rows.Scan(&total); err != nil {
+			rows.Close()
+			return nil, 0, 0, SQLError{cmdCount, err}
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, 0, 0, SQLError{cmdCount, err}
+	}
+	if offset >= total {
+		return []string{}, offset, total, nil
+	}
+
+	desc := len(opts.Sort) > 0 && opts.Sort[0].Desc
+	cmd := fmt.Sprintf(`SELECT %s FROM %s ORDER BY %s`, column, from, order(column, desc)) + LimitOffset(offset, limit)
+	rows, err = q.QueryContext(ctx, cmd, args...)
+	if err != nil {
+		return nil, 0, 0, SQLError{cmd, err}
+	}
+	defer rows.Close()
+	page := []string{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, 0, 0, SQLError{cmd, err}
+		}
+		page = append(page, name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, 0, SQLError{cmd, err}
+	}
+	return page, offset, total, nil
+}
+
+// AgentsPage returns the page of the SPIFFE IDs of the agents of from, such as
+// those of a cluster, selected by opts, see NamesPage
+// the agents can only be sorted by SPIFFE ID, and are not filtered
+func AgentsPage(ctx context.Context, q Queryer, from string, args []interface{}, order NameOrder, opts types.ListOptions) (types.List[string], error) {
+	if err := opts.Validate(nil, []string{"spiffeid"}); err != nil {
+		return types.List[string]{}, err
+	}
+	page, offset, total, err := NamesPage(ctx, q, "agents.spiffeid", from, args, order, opts)
+	if err != nil {
+		return types.List[string]{}, err
+	}
+	return types.NewList(page, offset, total), nil
+}
+
+// clusterSortColumns maps the fields clusters can be sorted on to SQL
+// expressions on the clusters table
+var clusterSortColumns = map[string]string{
+	"name":         "clusters.name",
+	"createdAt":    "COALESCE(clusters.created_at, '')",
+	"platformType": "COALESCE(clusters.platform_type, '')",
+	"agentCount":   "(SELECT COUNT(*) FROM cluster_memberships WHERE cluster_memberships.cluster_id = clusters.id)",
+}
+
+// ClusterSortFields lists the fields clusters can be sorted on
+var ClusterSortFields = []string{"name", "createdAt", "platformType", "agentCount"}
+
+// ClusterOrderBy returns the ORDER BY clause on the clusters table sorting on
+// the given fields, by name under order if none, ties broken by name
+func ClusterOrderBy(sort []types.SortField, order NameOrder) string {
+	terms := make([]string, 0, len(sort)+1)
+	byName := false
+	for _, s := range sort {
+		if s.Field == "name" {
+			terms = append(terms, order(clusterSortColumns["name"], s.Desc))
+			byName = true
+			continue
+		}
+		term := clusterSortColumns[s.Field]
+		if s.Desc {
+			term += " DESC"
+		}
+		terms = append(terms, term)
+	}
+	if !byName {
+		terms = append(terms, order(clusterSortColumns["name"], false))
+	}
+	return " ORDER BY " + strings.Join(terms, ", ")
+}
+
+// LimitOffset returns the LIMIT and OFFSET clause of a page
+func LimitOffset(offset, limit int) string {
+	return fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+}
+
+// InOrder returns the items in the order of names, name giving the name of an item
+// items missing from names are dropped
+func InOrder[T any](items []T, names []string, name func(T) string) []T {
+	byName := make(map[string]T, len(items))
+	for _, item := range items {
+		byName[name(item)] = item
+	}
+	ordered := make([]T, 0, len(names))
+	for _, n := range names {
+		if item, ok := byName[n]; ok {
+			ordered = append(ordered, item)
+		}
+	}
+	return ordered
+}
+
+// ClusterName returns the name of a cluster, to sort clusters with InOrder
+func ClusterName(c types.ClusterInfo) string {
+	return c.Name
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/spiffe/tornjak/pkg/agent/collation"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// openNamesDB returns an in-memory sqlite DB with the collation c and an
+// agents table of the given SPIFFE IDs
+func openNamesDB(t *testing.T, name string, spiffeids []string) (*sql.DB, NameOrder) {
+	c, err := collation.New(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	database, err := sql.Open(sqliteDriver(c), ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	// the in-memory DB lives as long as its connection
+	database.SetMaxOpenConns(1)
+	if _, err = database.Exec(`CREATE TABLE agents (spiffeid TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, spiffeid := range spiffeids {
+		if _, err = database.Exec(`INSERT INTO agents (spiffeid) VALUES (?)`, spiffeid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return database, (&LocalSqliteDb{collation: c}).nameOrder
+}
+
+func TestNamesPage(t *testing.T) {
+	database, order := openNamesDB(t, collation.NoCase, []string{"b", "C", "a", "D", "e", "c"})
+	ctx := context.Background()
+
+	page, offset, total, err := NamesPage(ctx, database, "agents.spiffeid", "agents", nil, order, types.ListOptions{Limit: 3, Cursor: types.EncodeCursor(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 2 || total != 6 || !reflect.DeepEqual(page, []string{"C", "c", "D"}) {
+		t.Fatalf("Expected page [C c D] of 6 at offset 2, got %v of %d at %d", page, total, offset)
+	}
+	page, _, _, err = NamesPage(ctx, database, "agents.spiffeid", "agents", nil, order, types.ListOptions{Limit: 2, Cursor: types.EncodeCursor(5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(page, []string{"e"}) {
+		t.Fatalf("Expected last page [e], got %v", page)
+	}
+	page, _, total, err = NamesPage(ctx, database, "agents.spiffeid", "agents", nil, order, types.ListOptions{Cursor: types.EncodeCursor(9)})
+	if err != nil || len(page) != 0 || total != 6 {
+		t.Fatalf("Expected an empty page past the end, got %v of %d, %v", page, total, err)
+	}
+	page, _, _, err = NamesPage(ctx, database, "agents.spiffeid", "agents", nil, order, types.ListOptions{Limit: 2, Sort: []types.SortField{{Field: "name", Desc: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(page, []string{"e", "D"}) {
+		t.Fatalf("Expected descending page [e D], got %v", page)
+	}
+	page, _, total, err = NamesPage(ctx, database, "agents.spiffeid", "agents WHERE agents.spiffeid != ?", []interface{}{"a"}, order, types.ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 5 || !reflect.DeepEqual(page, []string{"b", "C"}) {
+		t.Fatalf("Expected page [b C] of 5, got %v of %d", page, total)
+	}
+	if _, _, _, err = NamesPage(ctx, database, "agents.spiffeid", "agents", nil, order, types.ListOptions{Limit: -1}); err == nil {
+		t.Fatal("Expected a negative limit to fail")
+	}
+}
+
+func TestClusterAgentsPage(t *testing.T) {
+	database, order := openNamesDB(t, collation.Binary, []string{"spiffe://td/b", "spiffe://td/a", "spiffe://td/c"})
+	ctx := context.Background()
+
+	list, err := AgentsPage(ctx, database, "agents", nil, order, types.ListOptions{Limit: 2, Sort: []types.SortField{{Field: "spiffeid", Desc: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list.Items, []string{"spiffe://td/c", "spiffe://td/b"}) || list.Total != 3 || list.NextCursor == "" {
+		t.Fatalf("Expected descending first page of 3 agents, got %+v", list)
+	}
+	list, err = AgentsPage(ctx, database, "agents", nil, order, types.ListOptions{Limit: 2, Cursor: list.NextCursor, Sort: []types.SortField{{Field: "spiffeid", Desc: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list.Items, []string{"spiffe://td/a"}) || list.NextCursor != "" {
+		t.Fatalf("Expected last page [spiffe://td/a], got %+v", list)
+	}
+	if _, err = AgentsPage(ctx, database, "agents", nil, order, types.ListOptions{Sort: []types.SortField{{Field: "name"}}}); err == nil {
+		t.Fatal("Expected sorting on an unknown field to fail")
+	}
+}
+
+func TestClusterOrderBy(t *testing.T) {
+	c, err := collation.New(collation.NoCase, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nocase := (&LocalSqliteDb{collation: c}).nameOrder
+	order := ClusterOrderBy([]types.SortField{{Field: "agentCount", Desc: true}, {Field: "createdAt"}}, nocase)
+	expected := " ORDER BY (SELECT COUNT(*) FROM cluster_memberships WHERE cluster_memberships.cluster_id = clusters.id) DESC, COALESCE(clusters.created_at, ''), clusters.name COLLATE " + sqliteNameCollation
+	if order != expected {
+		t.Fatalf("Expected %q, got %q", expected, order)
+	}
+	order = ClusterOrderBy([]types.SortField{{Field: "name", Desc: true}}, nocase)
+	if expected = " ORDER BY clusters.name COLLATE " + sqliteNameCollation + " DESC"; order != expected {
+		t.Fatalf("Expected %q, got %q", expected, order)
+	}
+
+	clusters := []types.ClusterInfo{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	ordered := InOrder(clusters, []string{"c", "a", "missing"}, ClusterName)
+	if len(ordered) != 2 || ordered[0].Name != "c" || ordered[1].Name != "a" {
+		t.Fatalf("Expected clusters [c a], got %+v", ordered)
+	}
+}
@@ -0,0 +1,64 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/collation"
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+)
+
+// unicodeCollationName returns the name of the ICU collation of the
+// collation.Unicode collation of locale, created with the schema
+func unicodeCollationName(locale string) string {
+	if locale == "" {
+		locale = "und"
+	}
+	return "tornjak_unicode_" + strings.ToLower(strings.ReplaceAll(locale, "-", "_"))
+}
+
+// initNameCollation creates the ICU collation names are sorted under with the
+// collation.Unicode collation, see nameOrder
+// the other collations use the built-in "C" collation
+func (db *DB) initNameCollation(ctx context.Context, tx *sql.Tx) error {
+	if db.collation.Name() != collation.Unicode {
+		return nil
+	}
+	locale := db.collation.Locale()
+	if locale == "" {
+		locale = "und"
+	}
+	// the locale is a BCP 47 tag, validated by collation.New
+	cmd := fmt.Sprintf(`CREATE COLLATION IF NOT EXISTS %s (provider = icu, locale = '%s')`,
+		unicodeCollationName(db.collation.Locale()), locale)
+	if _, err := tx.ExecContext(ctx, cmd); err != nil {
+		return errors.Errorf("Cannot create the ICU collation of the %s collation, which needs PostgreSQL built with ICU: %v",
+			collation.Unicode, agentdb.SQLError{Cmd: cmd, Err: err})
+	}
+	return nil
+}
+
+// nameOrder sorts a column of names under the collation of the DB with a
+// collation of PostgreSQL, names equal under it ordered by their bytes
+// binary compares bytes with the "C" collation, nocase compares the lowercase
+// names, and unicode uses the ICU collation of the locale; as the collations
+// of PostgreSQL are not those used by the other DataStores, ties and
+// characters without a lowercase form may sort differently from them
+func (db *DB) nameOrder(column string, desc bool) string {
+	direction := ""
+	if desc {
+		direction = " DESC"
+	}
+	bytewise := column + ` COLLATE "C"` + direction
+	switch db.collation.Name() {
+	case collation.NoCase:
+		return `lower(` + column + `) COLLATE "C"` + direction + ", " + bytewise
+	case collation.Unicode:
+		return column + ` COLLATE ` + unicodeCollationName(db.collation.Locale()) + direction + ", " + bytewise
+	}
+	return bytewise
+}
@@ -0,0 +1,99 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// INDEX ADVISOR HANDLERS
+
+// GetIndexReport returns the statements recorded by the pg_stat_statements
+// extension taking minMillis or more on average, the indexes of the schema of
+// Tornjak and the indexes missing for its queries
+// without the extension, the report has no slow queries and the reason, and
+// the missing indexes are suggested without their slow queries
+func (db *DB) GetIndexReport(minMillis float64) (types.IndexReport, error) {
+	indexes, err := db.getIndexes()
+	if err != nil {
+		return types.IndexReport{}, err
+	}
+	ret := types.IndexReport{Backend: "postgres", SlowQuerySource: "pg_stat_statements", Indexes: indexes}
+	ret.SlowQueries, err = db.getSlowQueries(minMillis)
+	if err != nil {
+		ret.SlowQueries = []types.SlowQuery{}
+		ret.SlowQueryError = err.Error()
+	}
+	// CONCURRENTLY does not lock the table against writes while the index is built
+	ret.Suggestions = agentdb.IndexSuggestions(indexes, ret.SlowQueries, func(c agentdb.IndexCandidate) string {
+		return fmt.Sprintf("CREATE INDEX CONCURRENTLY IF NOT EXISTS %s ON %s (%s)", c.Name(), c.Table, strings.Join(c.Columns, ", "))
+	})
+	return ret, nil
+}
+
+// getIndexes returns the indexes of the tables of the current schema, with
+// their columns in order
+func (db *DB) getIndexes() ([]types.TableIndex, error) {
+	cmd := `SELECT t.relname::text, i.relname::text, COALESCE(a.attname::text, '(expression)')
+          FROM pg_index x
+          JOIN pg_class t ON t.oid=x.indrelid
+          JOIN pg_class i ON i.oid=x.indexrelid
+          JOIN pg_namespace n ON n.oid=t.relnamespace
+          CROSS JOIN LATERAL unnest(x.indkey::int2[]) WITH ORDINALITY AS k(attnum, ord)
+          LEFT JOIN pg_attribute a ON a.attrelid=t.oid AND a.attnum=k.attnum AND k.attnum>0
+          WHERE n.nspname=current_schema()
+          ORDER BY t.relname, i.relname, k.ord`
+	rows, err := db.database.Query(cmd)
+	if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	indexes := []types.TableIndex{}
+	for rows.Next() {
+		var table, name, column string
+		if err = rows.Scan(&table, &name, &column); err != nil {
+			return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		if n := len(indexes); n > 0 && indexes[n-1].Table == table && indexes[n-1].Name == name {
+			indexes[n-1].Columns = append(indexes[n-1].Columns, column)
+		} else {
+			indexes = append(indexes, types.TableIndex{Table: table, Name: name, Columns: []string{column}})
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return indexes, nil
+}
+
+// getSlowQueries returns the statements of the current database taking
+// minMillis or more on average, those taking the most time in total first
+// fails unless the pg_stat_statements extension is created in the database
+func (db *DB) getSlowQueries(minMillis float64) ([]types.SlowQuery, error) {
+	cmd := `SELECT query, calls, mean_exec_time, total_exec_time FROM pg_stat_statements
+          WHERE dbid=(SELECT oid FROM pg_database WHERE datname=current_database()) AND mean_exec_time>=$1
+          ORDER BY total_exec_time DESC LIMIT $2`
+	rows, err := db.database.Query(cmd, minMillis, agentdb.MaxSlowQueries)
+	if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	queries := []types.SlowQuery{}
+	for rows.Next() {
+		var q types.SlowQuery
+		if err = rows.Scan(&q.Query, &q.Calls, &q.MeanMillis, &q.TotalMillis); err != nil {
+			return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		queries = append(queries, q)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return queries, nil
+}
+
+var _ agentdb.IndexAdvisor = (*DB)(nil)
@@ -0,0 +1,375 @@
+// Package postgres implements the Tornjak DataStore on a PostgreSQL database,
+// so several Tornjak replicas can share one database
+//
+// it creates the tables of the DataStore, which is the sqlstore.Store on them
+// with the SQL of PostgreSQL
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	backoff "github.com/cenkalti/backoff/v4"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/collation"
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/db/sqlstore"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+const (
+	// plugin types of agents, the known types and the custom types assigned to agents
+	//                                names are unique regardless of case, see initPluginTypesIndex
+	initPluginTypesTable = `CREATE TABLE IF NOT EXISTS plugin_types
+                            (id SERIAL PRIMARY KEY, name TEXT NOT NULL, custom BOOLEAN NOT NULL)`
+	initPluginTypesIndex = `CREATE UNIQUE INDEX IF NOT EXISTS plugin_types_name ON plugin_types (lower(name))`
+	// agent table with fields spiffeid, plugin_type_id, display_name and creation and change times
+	initAgentsTable = `CREATE TABLE IF NOT EXISTS agents
+                            (id SERIAL PRIMARY KEY, spiffeid TEXT NOT NULL UNIQUE,
+                            plugin_type_id INTEGER REFERENCES plugin_types(id), display_name TEXT,
+                            created_at TEXT, updated_at TEXT)`
+	// cluster table with fields uid, name, domainName, platformtype, managedby, owner contacts and tenant
+	initClustersTable = `CREATE TABLE IF NOT EXISTS clusters
+                            (id SERIAL PRIMARY KEY, uid TEXT NOT NULL UNIQUE, name TEXT NOT NULL UNIQUE,
+                            created_at TEXT, domain_name TEXT, platform_type TEXT, managed_by TEXT,
+                            owner_email TEXT, owner_team TEXT, slack_channel TEXT, tenant TEXT, updated_at TEXT,
+                            protected BOOLEAN NOT NULL DEFAULT FALSE, metadata TEXT)`
+	// cluster - agent relation table, an agent is in at most one cluster
+	initClusterMemberTable = `CREATE TABLE IF NOT EXISTS cluster_memberships
+                            (agent_id INTEGER PRIMARY KEY REFERENCES agents(id),
+                            cluster_id INTEGER NOT NULL REFERENCES clusters(id) ON DELETE CASCADE)`
+	// cluster - extension field relation table with JSON-encoded values
+	initClusterExtensionsTable = `CREATE TABLE IF NOT EXISTS cluster_extensions
+                            (cluster_id INTEGER REFERENCES clusters(id) ON DELETE CASCADE, field TEXT, value TEXT,
+                            PRIMARY KEY (cluster_id, field))`
+	// cluster - label relation table
+	initClusterLabelsTable = `CREATE TABLE IF NOT EXISTS cluster_labels
+                            (cluster_id INTEGER REFERENCES clusters(id) ON DELETE CASCADE, label TEXT, value TEXT,
+                            PRIMARY KEY (cluster_id, label))`
+	// agent - label relation table
+	initAgentLabelsTable = `CREATE TABLE IF NOT EXISTS agent_labels
+                            (agent_id INTEGER REFERENCES agents(id) ON DELETE CASCADE, label TEXT, value TEXT,
+                            PRIMARY KEY (agent_id, label))`
+	// agent - annotation relation table, with the user and time of the last change of each value
+	initAgentAnnotationsTable = `CREATE TABLE IF NOT EXISTS agent_annotations
+                            (agent_id INTEGER REFERENCES agents(id) ON DELETE CASCADE, annotation TEXT, value TEXT,
+                            updated_by TEXT, updated_at TEXT, PRIMARY KEY (agent_id, annotation))`
+	// state of clusters after each change, by cluster UID, for queries of past states
+	initClusterHistoryTable = `CREATE TABLE IF NOT EXISTS cluster_history
+                            (id SERIAL PRIMARY KEY, cluster_uid TEXT, name TEXT, change TEXT,
+                            snapshot TEXT, changed_at TEXT)`
+	initClusterHistoryIndex = `CREATE INDEX IF NOT EXISTS cluster_history_changed_at ON cluster_history (changed_at)`
+	// deleted clusters with their state when deleted, by cluster UID, until they are restored
+	initDeletedClustersTable = `CREATE TABLE IF NOT EXISTS deleted_clusters
+                            (id SERIAL PRIMARY KEY, uid TEXT UNIQUE, name TEXT, snapshot TEXT, deleted_at TEXT)`
+	// markdown notes of operators attached to clusters, agents and entries, with every version of their text
+	initNotesTable = `CREATE TABLE IF NOT EXISTS notes
+                            (id SERIAL PRIMARY KEY, object_type TEXT, object_id TEXT, body TEXT,
+                            author TEXT, created_at TEXT, updated_by TEXT, updated_at TEXT)`
+	initNotesIndex         = `CREATE INDEX IF NOT EXISTS notes_object ON notes (object_type, object_id)`
+	initNoteRevisionsTable = `CREATE TABLE IF NOT EXISTS note_revisions
+                            (id SERIAL PRIMARY KEY, note_id INTEGER REFERENCES notes(id) ON DELETE CASCADE,
+                            body TEXT, edited_by TEXT, edited_at TEXT)`
+	// changes of clusters, agents and memberships with the user making them, see GetAuditEvents
+	initAuditEventsTable = `CREATE TABLE IF NOT EXISTS audit_events
+                            (id SERIAL PRIMARY KEY, actor TEXT, action TEXT, object_type TEXT, object_id TEXT,
+                            before_state TEXT, after_state TEXT, recorded_at TEXT)`
+	initAuditEventsIndex = `CREATE INDEX IF NOT EXISTS audit_events_object ON audit_events (object_type, object_id)`
+	// secrets of the server by name, such as the pepper of the hashes of API keys,
+	// stored encrypted by the Encryption plugin, see GetOrCreateSecret
+	initSecretsTable = `CREATE TABLE IF NOT EXISTS secrets (name TEXT PRIMARY KEY, value BYTEA, updated_at TEXT)`
+	// report of the last integrity check, a single row, and its findings, see CheckIntegrity
+	initIntegrityChecksTable = `CREATE TABLE IF NOT EXISTS integrity_checks
+                            (id INTEGER PRIMARY KEY, checked_at TEXT, errors INTEGER, warnings INTEGER, omitted INTEGER)`
+	initIntegrityFindingsTable = `CREATE TABLE IF NOT EXISTS integrity_findings
+                            (seq INTEGER PRIMARY KEY, check_name TEXT, severity TEXT, table_name TEXT, row_key TEXT,
+                            message TEXT, fix TEXT)`
+	// state of a cluster before each change, read to record the change
+	initClusterHistoryUIDIndex = `CREATE INDEX IF NOT EXISTS cluster_history_cluster_uid ON cluster_history (cluster_uid, id)`
+
+	// change times of tables created by earlier releases; clusters were last changed at the
+	// latest when created, agents created before have no creation or change time
+	addClustersUpdatedAt      = `ALTER TABLE clusters ADD COLUMN IF NOT EXISTS updated_at TEXT`
+	addAgentsCreatedAt        = `ALTER TABLE agents ADD COLUMN IF NOT EXISTS created_at TEXT`
+	addAgentsUpdatedAt        = `ALTER TABLE agents ADD COLUMN IF NOT EXISTS updated_at TEXT`
+	backfillClustersUpdatedAt = `UPDATE clusters SET updated_at=created_at WHERE updated_at IS NULL`
+	// clusters whose deletes are rejected, none in earlier releases
+	addClustersProtected = `ALTER TABLE clusters ADD COLUMN IF NOT EXISTS protected BOOLEAN NOT NULL DEFAULT FALSE`
+	// JSON metadata of clusters, none in earlier releases
+	addClustersMetadata = `ALTER TABLE clusters ADD COLUMN IF NOT EXISTS metadata TEXT`
+	// cluster of cluster and membership events, for the history of each cluster; events
+	// of earlier releases are assigned the cluster of their object or of their state
+	addAuditEventsClusterUID      = `ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS cluster_uid TEXT`
+	backfillAuditEventsClusterUID = `UPDATE audit_events SET cluster_uid=CASE object_type
+                            WHEN 'cluster' THEN object_id
+                            WHEN 'membership' THEN COALESCE(NULLIF(before_state, ''), after_state)::json->>'clusterUid'
+                            ELSE '' END
+                            WHERE cluster_uid IS NULL`
+	initAuditEventsClusterIndex = `CREATE INDEX IF NOT EXISTS audit_events_cluster ON audit_events (cluster_uid, id)`
+
+	// full-text index of the searchable fields of clusters, see SearchClusters
+	// punctuation is replaced by spaces so words split as in the other DataStores
+	clusterSearchVector = `to_tsvector('simple', regexp_replace(lower(COALESCE(name, '') || ' ' ||
+                            COALESCE(domain_name, '') || ' ' || COALESCE(managed_by, '') || ' ' ||
+                            COALESCE(platform_type, '')), '[^[:alnum:]]+', ' ', 'g'))`
+	initClusterSearchIndex = `CREATE INDEX IF NOT EXISTS clusters_search ON clusters USING GIN (` + clusterSearchVector + `)`
+
+	// index of SPIFFE IDs for LIKE prefixes, as the index of the UNIQUE
+	// constraint follows the collation of the database, see FindAgentsByPattern
+	initAgentsSpiffeidPatternIndex = `CREATE INDEX IF NOT EXISTS agents_spiffeid_pattern ON agents (spiffeid text_pattern_ops)`
+
+	// case-insensitive uniqueness of cluster names, on top of the UNIQUE (name) constraint
+	initClusterNameNocaseIndex = `CREATE UNIQUE INDEX IF NOT EXISTS clusters_name_nocase ON clusters (lower(name))`
+	dropClusterNameNocaseIndex = `DROP INDEX IF EXISTS clusters_name_nocase`
+
+	// key of the advisory lock held while the schema is created, so replicas
+	// starting together do not race on CREATE TABLE IF NOT EXISTS
+	schemaLockKey = 7381063
+)
+
+// error codes of PostgreSQL, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	codeUniqueViolation      = "23505"
+	codeSerializationFailure = "40001"
+	codeDeadlockDetected     = "40P01"
+	codeQueryCanceled        = "57014"
+)
+
+// Options contains optional settings of the PostgreSQL DB
+type Options struct {
+	// ClusterNameUniqueness is agentdb.ClusterNameCaseSensitive (default) or agentdb.ClusterNameCaseInsensitive
+	// replicas sharing a database must use the same policy
+	ClusterNameUniqueness string
+	// Collation orders cluster and agent names, collation.Binary if empty
+	Collation string
+	// Locale is the BCP 47 language tag of the collation.Unicode collation
+	Locale string
+	// Clock stamps creation and change times, the clock of the system if nil
+	Clock clock.Clock
+}
+
+type DB struct {
+	*sqlstore.Store
+	database *sql.DB
+
+	// orders names in lists; pages of names are sorted with ORDER BY under
+	// the matching collation of the database, see nameOrder
+	collation *collation.Collation
+}
+
+// New connects to the PostgreSQL database of connectionString, a URL such as
+// postgres://tornjak@db.example.org/tornjak?sslmode=verify-full or a list of
+// key=value settings, and creates the Tornjak tables missing from it
+// transactions that fail on a conflict with a concurrent transaction, e.g. of
+// another replica, are retried with backOffParams
+func New(connectionString string, backOffParams backoff.BackOff, opts Options) (*DB, error) {
+	nameCollation, err := collation.New(opts.Collation, opts.Locale)
+	if err != nil {
+		return nil, err
+	}
+
+	database, err := sql.Open("postgres", connectionString)
+	if err != nil {
+		return nil, errors.Errorf("Unable to open connection to DB: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err = database.PingContext(ctx); err != nil {
+		database.Close()
+		return nil, errors.Errorf("Unable to connect to DB: %v", err)
+	}
+
+	db := &DB{
+		database:  database,
+		collation: nameCollation,
+	}
+	if err = db.initSchema(ctx, opts.ClusterNameUniqueness); err != nil {
+		database.Close()
+		return nil, err
+	}
+	db.Store, err = sqlstore.New(database, db.dialect(), backOffParams, sqlstore.Options{Collation: nameCollation, Clock: opts.Clock})
+	if err != nil {
+		database.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// initSchema creates the missing tables and applies the cluster name uniqueness policy
+// in one transaction holding the schema lock
+func (db *DB) initSchema(ctx context.Context, clusterNameUniqueness string) error {
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Errorf("Error initializing context: %v", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // no-op once committed
+
+	cmdLock := `SELECT pg_advisory_xact_lock($1)`
+	if _, err = tx.ExecContext(ctx, cmdLock, schemaLockKey); err != nil {
+		return agentdb.SQLError{Cmd: cmdLock, Err: err}
+	}
+	initTableList := []string{initPluginTypesTable, initPluginTypesIndex, initAgentsTable, initClustersTable,
+		initClusterMemberTable, initClusterExtensionsTable, initClusterLabelsTable, initAgentLabelsTable,
+		initAgentAnnotationsTable, initClusterHistoryTable, initClusterHistoryIndex, initDeletedClustersTable, initNotesTable, initNotesIndex,
+		initNoteRevisionsTable, initAuditEventsTable, initAuditEventsIndex, initClusterHistoryUIDIndex, addClustersUpdatedAt, addAgentsCreatedAt, addAgentsUpdatedAt, initClusterSearchIndex,
+		initAgentsSpiffeidPatternIndex, addClustersProtected, addClustersMetadata, addAuditEventsClusterUID,
+		backfillAuditEventsClusterUID, initAuditEventsClusterIndex, initIntegrityChecksTable, initIntegrityFindingsTable, initSecretsTable}
+	for _, cmd := range initTableList {
+		if _, err = tx.ExecContext(ctx, cmd); err != nil {
+			return agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+	}
+	if err = db.initNameCollation(ctx, tx); err != nil {
+		return err
+	}
+	// creation times stored by earlier releases in local time
+	err = agentdb.ConvertTimestamps(ctx, tx, placeholder, func(value string) (string, bool) {
+		return agentdb.UpgradeTimestamp(value, time.Local)
+	})
+	if err != nil {
+		return errors.Errorf("could not convert creation times to UTC: %v", err)
+	}
+	if _, err = tx.ExecContext(ctx, backfillClustersUpdatedAt); err != nil {
+		return agentdb.SQLError{Cmd: backfillClustersUpdatedAt, Err: err}
+	}
+
+	switch clusterNameUniqueness {
+	case "", agentdb.ClusterNameCaseSensitive:
+		if _, err = tx.ExecContext(ctx, dropClusterNameNocaseIndex); err != nil {
+			return agentdb.SQLError{Cmd: dropClusterNameNocaseIndex, Err: err}
+		}
+	case agentdb.ClusterNameCaseInsensitive:
+		if _, err = tx.ExecContext(ctx, initClusterNameNocaseIndex); err != nil {
+			if errorCode(err) == codeUniqueViolation {
+				return errors.New("Cannot enforce case-insensitive cluster names: existing clusters have names differing only by case")
+			}
+			return agentdb.SQLError{Cmd: initClusterNameNocaseIndex, Err: err}
+		}
+	default:
+		return errors.Errorf("Invalid cluster name uniqueness policy %q", clusterNameUniqueness)
+	}
+	return tx.Commit()
+}
+
+// WithActor returns the DB recording actor as the user making the changes
+// made through it in the audit log; the DB itself records no actor
+func (db *DB) WithActor(actor string) agentdb.AgentDB {
+	view := *db
+	view.Store = db.Store.ForActor(actor)
+	return &view
+}
+
+// dialect returns the SQL of PostgreSQL run by the Store
+func (db *DB) dialect() *sqlstore.Dialect {
+	return &sqlstore.Dialect{
+		Name:                "postgres",
+		Placeholder:         placeholder,
+		NameOrder:           db.nameOrder,
+		HistoryChangeColumn: "change",
+		ForUpdateOf: func(table string) string {
+			return "FOR UPDATE OF " + table
+		},
+		Upsert:        upsert,
+		UpsertChanged: upsertChanged,
+		InsertID:      insertID,
+		// replicas checking together would both insert the report
+		LockIntegrityReport: `LOCK TABLE integrity_checks IN EXCLUSIVE MODE`,
+		SearchClusters:      searchClusters,
+		UniqueViolation:     uniqueViolation,
+		Retryable:           isRetryable,
+		RollbackCause:       rollbackCause,
+	}
+}
+
+// placeholder returns the placeholder of the nth argument of a statement
+func placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// upsert returns the ON CONFLICT clause of sqlstore.Dialect.Upsert
+// without columns no conflict is reported, whatever the unique index, as
+// plugin type names are unique with an index on lower(name)
+func upsert(key string, columns ...string) string {
+	if len(columns) == 0 {
+		return `ON CONFLICT DO NOTHING`
+	}
+	set := make([]string, len(columns))
+	for i, column := range columns {
+		set[i] = column + "=EXCLUDED." + column
+	}
+	return `ON CONFLICT (` + key + `) DO UPDATE SET ` + strings.Join(set, ", ")
+}
+
+// upsertChanged returns the ON CONFLICT clause of sqlstore.Dialect.UpsertChanged
+func upsertChanged(table, key, column string) string {
+	return fmt.Sprintf(`ON CONFLICT (%s) DO UPDATE SET %s=EXCLUDED.%s, updated_at=EXCLUDED.updated_at
+          WHERE %s.%s IS DISTINCT FROM EXCLUDED.%s`, key, column, column, table, column, column)
+}
+
+// insertID returns the id of the row inserted by cmd, see sqlstore.Dialect.InsertID
+func insertID(ctx context.Context, tx *sql.Tx, cmd string, args ...interface{}) (int64, error) {
+	var id int64
+	err := tx.QueryRowContext(ctx, cmd+` RETURNING id`, args...).Scan(&id)
+	return id, err
+}
+
+// searchClusters returns the full-text query of sqlstore.Dialect.SearchClusters
+// on the clusters_search index
+func searchClusters(terms []string) (string, []interface{}) {
+	// words only have letters and digits, so need no escaping in the query
+	prefixes := make([]string, len(terms))
+	for i, term := range terms {
+		prefixes[i] = term + ":*"
+	}
+	cmd := `SELECT name FROM clusters, to_tsquery('simple', ?) AS query
+          WHERE ` + clusterSearchVector + ` @@ query
+          ORDER BY ts_rank(` + clusterSearchVector + `, query) DESC, name`
+	return cmd, []interface{}{strings.Join(prefixes, " & ")}
+}
+
+// errorCode returns the PostgreSQL error code of err, empty if err is not raised by PostgreSQL
+func errorCode(err error) string {
+	var perr *pq.Error
+	if errors.As(err, &perr) {
+		return string(perr.Code)
+	}
+	return ""
+}
+
+// uniqueViolation returns whether err is the violation of a unique index,
+// of the index named index unless empty
+func uniqueViolation(err error, index string) bool {
+	var perr *pq.Error
+	return errors.As(err, &perr) && perr.Code == codeUniqueViolation && (index == "" || perr.Constraint == index)
+}
+
+// isRetryable returns whether the transaction failed on a conflict with a concurrent
+// transaction and can be retried as is
+func isRetryable(err error) bool {
+	code := errorCode(err)
+	return code == codeSerializationFailure || code == codeDeadlockDetected
+}
+
+// rollbackCause returns the cause of an error of PostgreSQL a transaction is
+// rolled back upon, with the causes of the sqlite DataStore
+func rollbackCause(err error) (string, bool) {
+	switch code := errorCode(err); {
+	case code == "":
+		return "", false
+	case code == codeQueryCanceled:
+		return types.RollbackCauseCanceled, true
+	case isRetryable(err):
+		return types.RollbackCauseBusy, true
+	case strings.HasPrefix(code, "23"):
+		return types.RollbackCauseConstraint, true
+	}
+	return types.RollbackCauseOther, true
+}
+
+var _ agentdb.AgentDB = (*DB)(nil)
@@ -0,0 +1,29 @@
+package db
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// MaxSearchTerms is the maximum number of words of a cluster search query
+const MaxSearchTerms = 16
+
+// SearchTerms returns the lowercased words of a cluster search query
+// words are the runs of letters and digits, as split by the full-text
+// indexes of the DataStores, so "example.org" is the words example and org
+// SearchClusters returns the clusters with, for each word, a word starting
+// with it in their name, domain name, manager or platform type
+func SearchTerms(query string) ([]string, error) {
+	terms := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(terms) == 0 {
+		return nil, errors.New("search query has no words")
+	}
+	if len(terms) > MaxSearchTerms {
+		return nil, errors.Errorf("search query has more than %d words", MaxSearchTerms)
+	}
+	return terms, nil
+}
@@ -0,0 +1,24 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearchTerms(t *testing.T) {
+	// CHECK words are split at punctuation and lowercased
+	terms, err := SearchTerms("  Prod-East example.org/k8s ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(terms, []string{"prod", "east", "example", "org", "k8s"}) {
+		t.Fatalf("Unexpected words %v", terms)
+	}
+	// CHECK queries without words or with too many words
+	for _, query := range []string{"", `"*" -:`, strings.Repeat("a ", MaxSearchTerms+1)} {
+		if _, err = SearchTerms(query); err == nil {
+			t.Fatalf("Expected query %q to fail", query)
+		}
+	}
+}
@@ -3,35 +3,81 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"log"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	backoff "github.com/cenkalti/backoff/v4"
-	_ "github.com/mattn/go-sqlite3"
+	sqlite3 "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/collation"
 	"github.com/spiffe/tornjak/pkg/agent/types"
 )
 
+// the tables of the DB are created by the migrations in migrations/sqlite, see sqliteMigrations
 const (
-	// agent table with fields spiffeid and plugin
-	initAgentsTable = `CREATE TABLE IF NOT EXISTS agents 
-                            (id INTEGER PRIMARY KEY AUTOINCREMENT, spiffeid TEXT, plugin TEXT, UNIQUE (spiffeid))`
-	// cluster table with fields name, domainName, platformtype, managedby
-	initClustersTable = `CREATE TABLE IF NOT EXISTS clusters 
-                            (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, created_at TEXT, 
-                            domain_name TEXT, platform_type TEXT, managed_by TEXT, UNIQUE (name))`
-	// cluster - agent relation table specifying by clusterid and spiffeid
-	//                                enforces uniqueness of spiffeid
-	initClusterMemberTable = `CREATE TABLE IF NOT EXISTS cluster_memberships 
-                            (id INTEGER PRIMARY KEY AUTOINCREMENT, agent_id int, cluster_id int,
-                            FOREIGN KEY (agent_id) REFERENCES agents(id), 
-                            FOREIGN KEY (cluster_id) REFERENCES clusters(id), UNIQUE (agent_id))`
+	// case-insensitive uniqueness of cluster names, on top of the UNIQUE (name) constraint
+	initClusterNameNocaseIndex = `CREATE UNIQUE INDEX IF NOT EXISTS clusters_name_nocase ON clusters (lower(name))`
+	dropClusterNameNocaseIndex = `DROP INDEX IF EXISTS clusters_name_nocase`
+
+	// number of SPIRE API calls retained in the spire_query_log table
+	defaultSPIREQueryLogSize = 1000
+)
+
+const (
+	// ClusterNameCaseSensitive allows cluster names that differ only by case
+	ClusterNameCaseSensitive = "case-sensitive"
+	// ClusterNameCaseInsensitive rejects cluster names that differ only by case
+	ClusterNameCaseInsensitive = "case-insensitive"
 )
 
+// SqliteOptions contains optional settings of the local sqlite DB
+type SqliteOptions struct {
+	// ClusterNameUniqueness is ClusterNameCaseSensitive (default) or ClusterNameCaseInsensitive
+	ClusterNameUniqueness string
+	// Collation orders cluster, agent and service account names, collation.Binary if empty
+	Collation string
+	// Locale is the BCP 47 language tag of the collation.Unicode collation
+	Locale string
+	// SnapshotDir is the directory of named snapshots, the DB path with suffix -snapshots if empty
+	SnapshotDir string
+	// Clock stamps creation and change times, the clock of the system if nil
+	Clock clock.Clock
+}
+
 type LocalSqliteDb struct {
 	database   *sql.DB
 	expBackoff *backoff.BackOff
+
+	// maximum number of rows kept in spire_query_log
+	queryLogSize int
+
+	// orders names in lists, registered on the connections as
+	// sqliteNameCollation to sort pages of names with ORDER BY, see nameOrder
+	collation *collation.Collation
+
+	// counts commits and rollbacks of transactions by operation
+	txMetrics *txMetrics
+
+	// directory of the files of named snapshots
+	snapshotDir string
+
+	// whether clusters are searched in the FTS5 index, see applyClusterSearchIndex
+	clusterSearch bool
+
+	clock clock.Clock
+
+	// connection holding an in-memory DB open, nil for a DB on disk
+	keepAlive *sql.Conn
+
+	// user or component making the changes, recorded in the audit log, see WithActor
+	actor string
 }
 
 func createDBTable(database *sql.DB, cmd string) error {
@@ -46,94 +92,398 @@ func createDBTable(database *sql.DB, cmd string) error {
 	return nil
 }
 
+// addDBColumn adds a column to a table created by an older version of Tornjak
+// it does nothing if the column already exists
+func addDBColumn(database *sql.DB, table string, column string, columnType string) error {
+	cmdInfo := fmt.Sprintf(`SELECT COUNT(*) FROM pragma_table_info('%s') WHERE name=?`, table)
+	var count int
+	if err := database.QueryRow(cmdInfo, column).Scan(&count); err != nil {
+		return SQLError{cmdInfo, err}
+	}
+	if count > 0 {
+		return nil
+	}
+	cmd := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, columnType)
+	if _, err := database.Exec(cmd); err != nil {
+		return SQLError{cmd, err}
+	}
+	return nil
+}
+
+// applyClusterNameUniqueness creates or drops the case-insensitive index on cluster names
+func applyClusterNameUniqueness(database *sql.DB, policy string) error {
+	switch policy {
+	case "", ClusterNameCaseSensitive:
+		if _, err := database.Exec(dropClusterNameNocaseIndex); err != nil {
+			return SQLError{dropClusterNameNocaseIndex, err}
+		}
+	case ClusterNameCaseInsensitive:
+		if _, err := database.Exec(initClusterNameNocaseIndex); err != nil {
+			if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrConstraint {
+				return errors.New("Cannot enforce case-insensitive cluster names: existing clusters have names differing only by case")
+			}
+			return SQLError{initClusterNameNocaseIndex, err}
+		}
+	default:
+		return errors.Errorf("Invalid cluster name uniqueness policy %q", policy)
+	}
+	return nil
+}
+
 func NewLocalSqliteDB(driverName string, dbpath string, backOffParams backoff.BackOff) (AgentDB, error) {
-	database, err := sql.Open(driverName, dbpath)
+	return NewLocalSqliteDBWithOptions(driverName, dbpath, backOffParams, SqliteOptions{})
+}
+
+func NewLocalSqliteDBWithOptions(driverName string, dbpath string, backOffParams backoff.BackOff, opts SqliteOptions) (AgentDB, error) {
+	nameCollation, err := collation.New(opts.Collation, opts.Locale)
+	if err != nil {
+		return nil, err
+	}
+
+	// the driver registers the collation on its connections
+	if driverName != "sqlite3" {
+		return nil, errors.Errorf("Unsupported driver %q of the sqlite DB", driverName)
+	}
+	database, err := sql.Open(sqliteDriver(nameCollation), dbpath)
 	if err != nil {
 		return nil, errors.New("Unable to open connection to DB")
 	}
 
-	initTableList := []string{initAgentsTable, initClustersTable, initClusterMemberTable}
+	migrations, err := sqliteMigrations()
+	if err != nil {
+		return nil, err
+	}
+	err = migrateSchema(database, migrations, clock.OrNew(opts.Clock).Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
 
-	for i := 0; i < len(initTableList); i++ {
-		err = createDBTable(database, initTableList[i])
-		if err != nil {
-			return nil, err
-		}
+	err = applyClusterNameUniqueness(database, opts.ClusterNameUniqueness)
+	if err != nil {
+		return nil, err
+	}
+	clusterSearch, err := applyClusterSearchIndex(database)
+	if err != nil {
+		return nil, err
 	}
 
-	return &LocalSqliteDb{
-		database:   database,
-		expBackoff: &backOffParams,
-	}, nil
+	snapshotDir := opts.SnapshotDir
+	if len(snapshotDir) == 0 {
+		snapshotDir = dbpath + "-snapshots"
+	}
+
+	db := &LocalSqliteDb{
+		database:      database,
+		expBackoff:    &backOffParams,
+		queryLogSize:  defaultSPIREQueryLogSize,
+		collation:     nameCollation,
+		txMetrics:     newTxMetrics(),
+		snapshotDir:   snapshotDir,
+		clusterSearch: clusterSearch,
+		clock:         clock.OrNew(opts.Clock),
+	}
+	err = db.backfillClusterHistory()
+	if err != nil {
+		return nil, err
+	}
+	err = db.migratePluginTypes()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
 }
 
 // AGENT - SELECTOR/PLUGIN HANDLERS
 
+// CreateAgentEntry assigns the plugin type of sinfo, normalized with types.NormalizePluginType, to the agent
+// an empty plugin removes the agent's plugin type
+// returns PostFailure if the plugin type is invalid
 func (db *LocalSqliteDb) CreateAgentEntry(sinfo types.AgentInfo) error {
-	cmdInsert := `INSERT INTO agents (spiffeid, plugin) VALUES `
-	cmdUpdate := ` ON CONFLICT(spiffeid) DO UPDATE SET plugin=`
+	var pluginType interface{}
+	var normalized types.PluginType
 	if len(sinfo.Plugin) > 0 {
-		cmdInsert += `(?, ?)`
-		cmdUpdate += `(?)`
-	} else {
-		cmdInsert += `(?, NULL)`
-		cmdUpdate += `NULL`
+		var err error
+		normalized, err = types.NormalizePluginType(sinfo.Plugin)
+		if err != nil {
+			return PostFailure{Message: fmt.Sprintf("Invalid plugin of agent %v: %v", sinfo.Spiffeid, err)}
+		}
+		pluginType = normalized.Name
+	}
+
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Errorf("Error initializing context: %v", err)
 	}
-	cmd := cmdInsert + cmdUpdate
-	statement, err := db.database.Prepare(cmd)
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "createAgentEntry")
+
+	before, err := txHelper.getAuditAgent(sinfo.Spiffeid)
 	if err != nil {
-		return SQLError{cmd, err}
+		return txHelper.rollbackHandler(err)
 	}
-	if len(sinfo.Plugin) > 0 {
-		_, err = statement.Exec(sinfo.Spiffeid, sinfo.Plugin, sinfo.Plugin)
-	} else {
-		_, err = statement.Exec(sinfo.Spiffeid)
+	if pluginType != nil {
+		cmdType := `INSERT INTO plugin_types (name, custom) VALUES (?, ?) ON CONFLICT(name) DO NOTHING`
+		if _, err = tx.ExecContext(ctx, cmdType, normalized.Name, normalized.Custom); err != nil {
+			return txHelper.rollbackHandler(SQLError{cmdType, err})
+		}
 	}
+	cmd := `INSERT INTO agents (spiffeid, plugin_type_id, created_at, updated_at) 
+          VALUES (?, (SELECT id FROM plugin_types WHERE name=?), ?, ?) 
+          ON CONFLICT(spiffeid) DO UPDATE SET plugin_type_id=excluded.plugin_type_id, updated_at=excluded.updated_at 
+          WHERE agents.plugin_type_id IS NOT excluded.plugin_type_id`
+	now := txHelper.now()
+	if _, err = tx.ExecContext(ctx, cmd, sinfo.Spiffeid, pluginType, now, now); err != nil {
+		return txHelper.rollbackHandler(SQLError{cmd, err})
+	}
+	if err = txHelper.recordAgentChange(sinfo.Spiffeid, before); err != nil {
+		return txHelper.rollbackHandler(err)
+	}
+	return txHelper.commit()
+}
+
+// GetPluginTypes returns the known plugin types and the custom plugin types assigned to agents
+func (db *LocalSqliteDb) GetPluginTypes() (types.PluginTypeList, error) {
+	cmd := `SELECT name, custom FROM plugin_types 
+          WHERE custom=0 OR id IN (SELECT plugin_type_id FROM agents)`
+	rows, err := db.database.Query(cmd)
+	if err != nil {
+		return types.PluginTypeList{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	pluginTypes := []types.PluginType{}
+	for rows.Next() {
+		var pluginType types.PluginType
+		if err = rows.Scan(&pluginType.Name, &pluginType.Custom); err != nil {
+			return types.PluginTypeList{}, SQLError{cmd, err}
+		}
+		pluginTypes = append(pluginTypes, pluginType)
+	}
+	collation.Sort(db.collation, pluginTypes, func(a types.PluginType) string { return a.Name })
+	return types.PluginTypeList{PluginTypes: pluginTypes}, nil
+}
+
+// migratePluginTypes adds the known plugin types and assigns plugin types to agents
+// from the free-form plugin column of older versions of Tornjak
+// values that are not valid plugin types are left in the plugin column
+func (db *LocalSqliteDb) migratePluginTypes() error {
+	// ADD known plugin types
+	cmdType := `INSERT INTO plugin_types (name, custom) VALUES (?, ?) ON CONFLICT(name) DO NOTHING`
+	for _, name := range types.KnownPluginTypes {
+		if _, err := db.database.Exec(cmdType, name, false); err != nil {
+			return SQLError{cmdType, err}
+		}
+	}
+
+	// SELECT plugins of older versions
+	cmd := `SELECT DISTINCT plugin FROM agents WHERE plugin IS NOT NULL`
+	rows, err := db.database.Query(cmd)
 	if err != nil {
 		return SQLError{cmd, err}
 	}
-	return nil
+	plugins := []string{}
+	for rows.Next() {
+		var plugin string
+		if err = rows.Scan(&plugin); err != nil {
+			rows.Close()
+			return SQLError{cmd, err}
+		}
+		plugins = append(plugins, plugin)
+	}
+	rows.Close()
+	if len(plugins) == 0 {
+		return nil
+	}
+
+	// BEGIN transaction
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "migratePluginTypes")
+
+	// UPDATE agents with their normalized plugin type
+	cmdAgents := `UPDATE agents SET plugin_type_id=(SELECT id FROM plugin_types WHERE name=?), plugin=NULL 
+          WHERE plugin=?`
+	for _, plugin := range plugins {
+		pluginType, err := types.NormalizePluginType(plugin)
+		if err != nil {
+			log.Printf("WARNING: plugin %q of agents is not migrated: %v", plugin, err)
+			continue
+		}
+		if _, err = tx.ExecContext(ctx, cmdType, pluginType.Name, pluginType.Custom); err != nil {
+			return txHelper.rollbackHandler(SQLError{cmdType, err})
+		}
+		if _, err = tx.ExecContext(ctx, cmdAgents, pluginType.Name, plugin); err != nil {
+			return txHelper.rollbackHandler(SQLError{cmdAgents, err})
+		}
+	}
+	return txHelper.commit()
+}
+
+// SetAgentDisplayName assigns a display name to the agent with the given spiffeid
+// an empty display name removes the agent's display name
+func (db *LocalSqliteDb) SetAgentDisplayName(spiffeid string, displayName string) error {
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "setAgentDisplayName")
+
+	before, err := txHelper.getAuditAgent(spiffeid)
+	if err != nil {
+		return txHelper.rollbackHandler(err)
+	}
+	cmd := `INSERT INTO agents (spiffeid, display_name, created_at, updated_at) VALUES (?, ?, ?, ?) 
+          ON CONFLICT(spiffeid) DO UPDATE SET display_name=excluded.display_name, updated_at=excluded.updated_at 
+          WHERE agents.display_name IS NOT excluded.display_name`
+	var name interface{}
+	if len(displayName) > 0 {
+		name = displayName
+	}
+	now := txHelper.now()
+	if _, err = tx.ExecContext(ctx, cmd, spiffeid, name, now, now); err != nil {
+		return txHelper.rollbackHandler(SQLError{cmd, err})
+	}
+	if err = txHelper.recordAgentChange(spiffeid, before); err != nil {
+		return txHelper.rollbackHandler(err)
+	}
+	return txHelper.commit()
+}
+
+// FindAgentsByPattern returns a page of the SPIFFE IDs of the agents matching
+// the pattern, see AgentPattern
+// LIKE is case-insensitive in sqlite and only uses case-insensitive indexes, so
+// the agents are matched with GLOB, which uses the index of SPIFFE IDs
+func (db *LocalSqliteDb) FindAgentsByPattern(pattern string, opts types.ListOptions) (types.List[string], error) {
+	p, err := ParseAgentPattern(pattern)
+	if err != nil {
+		return types.List[string]{}, err
+	}
+	return AgentsPage(context.Background(), db.database, `agents WHERE agents.spiffeid GLOB ?`, []interface{}{p.Glob}, db.nameOrder, opts)
 }
 
 func (db *LocalSqliteDb) GetAgentSelectors() (types.AgentInfoList, error) {
-	cmd := `SELECT spiffeid, plugin FROM agents WHERE plugin IS NOT NULL`
-	rows, err := db.database.Query(cmd)
+	sinfos, err := db.getAgentSelectors("", nil)
 	if err != nil {
-		return types.AgentInfoList{}, SQLError{cmd, err}
+		return types.AgentInfoList{}, err
+	}
+	return types.AgentInfoList{
+		Agents: sinfos,
+	}, nil
+}
+
+// agentSelectorColumns lists the fields agent selectors can be filtered on
+var agentSelectorColumns = listColumns{
+	"plugin": "plugin_types.name",
+}
+
+// GetAgentSelectorsPage returns a page of the agents with a plugin type, in
+// the order of their SPIFFE IDs under the collation of the DB
+func (db *LocalSqliteDb) GetAgentSelectorsPage(opts types.ListOptions) (types.List[types.AgentInfo], error) {
+	if len(opts.Sort) > 0 {
+		return types.List[types.AgentInfo]{}, errors.New("agents are sorted by SPIFFE ID only")
+	}
+	where, _, args, err := listClauses(opts, agentSelectorColumns, "agents.spiffeid")
+	if err != nil {
+		return types.List[types.AgentInfo]{}, err
 	}
 
+	from := `agents JOIN plugin_types ON agents.plugin_type_id = plugin_types.id` + where
+	page, offset, total, err := NamesPage(context.Background(), db.database, "agents.spiffeid", from, args, db.nameOrder, opts)
+	if err != nil {
+		return types.List[types.AgentInfo]{}, err
+	}
+	if len(page) == 0 {
+		return types.NewList([]types.AgentInfo{}, offset, total), nil
+	}
+	pageWhere, vals := inCond("agents.spiffeid", page)
+	sinfos, err := db.getAgentSelectors(" WHERE "+pageWhere, vals)
+	if err != nil {
+		return types.List[types.AgentInfo]{}, err
+	}
+	return types.NewList(sinfos, offset, total), nil
+}
+
+// getAgentSelectors returns the agents with a plugin type selected by where,
+// sorted by SPIFFE ID
+func (db *LocalSqliteDb) getAgentSelectors(where string, vals []interface{}) ([]types.AgentInfo, error) {
+	cmd := `SELECT agents.spiffeid, plugin_types.name, agents.display_name 
+          FROM agents 
+          JOIN plugin_types ON agents.plugin_type_id = plugin_types.id` + where
+	rows, err := db.database.Query(cmd, vals...)
+	if err != nil {
+		return nil, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
 	sinfos := []types.AgentInfo{}
 	var (
-		spiffeid string
-		plugin   string
+		spiffeid    string
+		plugin      string
+		displayName sql.NullString
 	)
 	for rows.Next() {
-		if err = rows.Scan(&spiffeid, &plugin); err != nil {
-			return types.AgentInfoList{}, SQLError{cmd, err}
+		if err = rows.Scan(&spiffeid, &plugin, &displayName); err != nil {
+			return nil, SQLError{cmd, err}
 		}
 
 		sinfos = append(sinfos, types.AgentInfo{
-			Spiffeid: spiffeid,
-			Plugin:   plugin,
+			Spiffeid:    spiffeid,
+			Plugin:      plugin,
+			DisplayName: displayName.String,
 		})
 	}
+	collation.Sort(db.collation, sinfos, func(a types.AgentInfo) string { return a.Spiffeid })
+	return sinfos, nil
+}
 
-	return types.AgentInfoList{
-		Agents: sinfos,
-	}, nil
+// getStrings returns the first column of the rows of cmd
+func (db *LocalSqliteDb) getStrings(cmd string, args ...interface{}) ([]string, error) {
+	rows, err := db.database.Query(cmd, args...)
+	if err != nil {
+		return nil, SQLError{cmd, err}
+	}
+	defer rows.Close()
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err = rows.Scan(&value); err != nil {
+			return nil, SQLError{cmd, err}
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// inCond returns the condition column IN values, with its arguments
+func inCond(column string, values []string) (string, []interface{}) {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return column + ` IN (?` + strings.Repeat(",?", len(values)-1) + `)`, args
 }
 
 func (db *LocalSqliteDb) GetAgentPluginInfo(spiffeid string) (types.AgentInfo, error) {
-	cmd := `SELECT spiffeid, plugin FROM agents WHERE spiffeid=?`
+	cmd := `SELECT agents.spiffeid, plugin_types.name, agents.display_name 
+          FROM agents 
+          LEFT JOIN plugin_types ON agents.plugin_type_id = plugin_types.id 
+          WHERE agents.spiffeid=?`
 	row := db.database.QueryRow(cmd, spiffeid)
 
 	sinfo := types.AgentInfo{}
-	err := row.Scan(&sinfo.Spiffeid, &sinfo.Plugin)
-	if err == sql.ErrNoRows {
+	var plugin, displayName sql.NullString
+	err := row.Scan(&sinfo.Spiffeid, &plugin, &displayName)
+	if err == sql.ErrNoRows || (err == nil && !plugin.Valid) {
 		return types.AgentInfo{}, GetError{fmt.Sprintf("Agent %v has no assigned plugin", spiffeid)}
 	} else if err != nil {
 		return types.AgentInfo{}, SQLError{cmd, err}
 	}
+	sinfo.Plugin = plugin.String
+	sinfo.DisplayName = displayName.String
 	return sinfo, nil
 }
 
@@ -164,11 +514,30 @@ func (db *LocalSqliteDb) GetClusterAgents(name string) ([]string, error) {
 	} else {
 		spiffeidList = []string{}
 	}
+	db.collation.Strings(spiffeidList)
 
 	return spiffeidList, nil
 
 }
 
+// GetClusterAgentsPage returns a page of the SPIFFE IDs of the agents assigned
+// to the cluster, in their order under the collation of the DB
+func (db *LocalSqliteDb) GetClusterAgentsPage(name string, opts types.ListOptions) (types.List[string], error) {
+	cmdCluster := `SELECT COUNT(*) FROM clusters WHERE name=?`
+	var count int
+	if err := db.database.QueryRow(cmdCluster, name).Scan(&count); err != nil {
+		return types.List[string]{}, SQLError{cmdCluster, err}
+	}
+	if count == 0 {
+		return types.List[string]{}, GetError{fmt.Sprintf("Cluster %v not registered", name)}
+	}
+	from := `clusters
+          JOIN cluster_memberships ON clusters.id=cluster_memberships.cluster_id
+          JOIN agents ON cluster_memberships.agent_id=agents.id
+          WHERE clusters.name=?`
+	return AgentsPage(context.Background(), db.database, from, []interface{}{name}, db.nameOrder, opts)
+}
+
 // GetAgentClusterName takes in string of spiffeid of agent and outputs the name of the cluster
 func (db *LocalSqliteDb) GetAgentClusterName(spiffeid string) (string, error) {
 	var clusterName sql.NullString
@@ -191,50 +560,150 @@ func (db *LocalSqliteDb) GetAgentClusterName(spiffeid string) (string, error) {
 	}
 }
 
+// maximum number of spiffeids bound in a single GetAgentClusterNames query
+const agentClusterNamesBatchSize = 500
+
+// GetAgentClusterNames takes in a list of agent spiffeids and outputs a map from spiffeid to cluster name
+// agents that are unknown or unassigned to a registered cluster are not included in the map
+func (db *LocalSqliteDb) GetAgentClusterNames(spiffeids []string) (map[string]string, error) {
+	clusterNames := make(map[string]string, len(spiffeids))
+	for start := 0; start < len(spiffeids); start += agentClusterNamesBatchSize {
+		end := start + agentClusterNamesBatchSize
+		if end > len(spiffeids) {
+			end = len(spiffeids)
+		}
+		batch := spiffeids[start:end]
+
+		cmd := `SELECT agents.spiffeid, clusters.name 
+          FROM agents 
+          JOIN cluster_memberships ON agents.id=cluster_memberships.agent_id
+          JOIN clusters ON cluster_memberships.cluster_id=clusters.id
+          WHERE agents.spiffeid IN (` + strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",") + `)`
+		vals := make([]interface{}, len(batch))
+		for i := range batch {
+			vals[i] = batch[i]
+		}
+		rows, err := db.database.Query(cmd, vals...)
+		if err != nil {
+			return nil, SQLError{cmd, err}
+		}
+
+		var spiffeid, clusterName string
+		for rows.Next() {
+			if err = rows.Scan(&spiffeid, &clusterName); err != nil {
+				rows.Close()
+				return nil, SQLError{cmd, err}
+			}
+			clusterNames[spiffeid] = clusterName
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, SQLError{cmd, err}
+		}
+	}
+	return clusterNames, nil
+}
+
 // GetAgentsMetadata takes a AgentMetadataRequest with a list of agent spiffeids
 // outputs list of agentinfo objects, where spiffeids must be in the input list
 // includes info on plugin and clustername
 func (db *LocalSqliteDb) GetAgentsMetadata(req types.AgentMetadataRequest) (types.AgentInfoList, error) {
 	spiffeids := req.Agents
-	cmd := `SELECT agents.spiffeid, agents.plugin, clusters.name 
+	cmd := `SELECT agents.spiffeid, plugin_types.name, clusters.name, agents.display_name, 
+          agents.created_at, agents.updated_at 
           FROM agents 
+          LEFT JOIN plugin_types ON agents.plugin_type_id = plugin_types.id
           LEFT JOIN cluster_memberships ON agents.id = cluster_memberships.agent_id
           LEFT JOIN clusters ON cluster_memberships.cluster_id = clusters.id`
-	var err error
-	var rows *sql.Rows
+	conds := []string{}
+	vals := []interface{}{}
 	if len(spiffeids) > 0 {
-		cmd += ` WHERE agents.spiffeid IN (`
-		vals := []interface{}{}
+		cond := `agents.spiffeid IN (`
 		for i := 0; i < len(spiffeids); i++ {
-			cmd += "?,"
+			cond += "?,"
 			vals = append(vals, spiffeids[i])
 		}
-		vals = append(vals, vals...)
-		cmd = strings.TrimSuffix(cmd, ",") + ")"
-		rows, err = db.database.Query(cmd, vals...)
-	} else {
-		rows, err = db.database.Query(cmd)
+		conds = append(conds, strings.TrimSuffix(cond, ",")+")")
+	}
+	if len(req.Plugin) > 0 {
+		pluginType, err := types.NormalizePluginType(req.Plugin)
+		if err != nil {
+			return types.AgentInfoList{}, GetError{err.Error()}
+		}
+		conds = append(conds, `agents.plugin_type_id IN (SELECT id FROM plugin_types WHERE name=?)`)
+		vals = append(vals, pluginType.Name)
+	}
+	if len(req.Search) > 0 {
+		conds = append(conds, `(agents.spiffeid LIKE ? OR agents.display_name LIKE ?)`)
+		pattern := "%" + req.Search + "%"
+		vals = append(vals, pattern, pattern)
+	}
+	for _, filter := range req.Compliance {
+		cond, err := complianceFilterCond(filter)
+		if err != nil {
+			return types.AgentInfoList{}, err
+		}
+		conds = append(conds, cond)
+		vals = append(vals, filter.Attribute, filter.Value)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = ` WHERE ` + strings.Join(conds, " AND ")
+	}
+
+	// SELECT the page of the matching agents, ahead of their details
+	offset, total := 0, 0
+	if req.Paginated() {
+		var page []string
+		var err error
+		page, offset, total, err = NamesPage(context.Background(), db.database, "agents.spiffeid", `agents`+where, vals, db.nameOrder, req.PageOptions())
+		if err != nil {
+			return types.AgentInfoList{}, err
+		}
+		if len(page) == 0 {
+			return types.AgentInfoList{Agents: []types.AgentInfo{}, Total: total}, nil
+		}
+		var cond string
+		cond, vals = inCond("agents.spiffeid", page)
+		where = ` WHERE ` + cond
 	}
 
+	cmd += where
+	rows, err := db.database.Query(cmd, vals...)
 	if err != nil {
 		return types.AgentInfoList{}, SQLError{cmd, err}
 	}
 
 	ainfos := []types.AgentInfo{}
 	var (
-		spiffeid string
-		plugin   sql.NullString
-		cluster  sql.NullString
+		spiffeid    string
+		plugin      sql.NullString
+		cluster     sql.NullString
+		displayName sql.NullString
+		createdAt   sql.NullString
+		updatedAt   sql.NullString
 	)
 	for rows.Next() {
-		if err = rows.Scan(&spiffeid, &plugin, &cluster); err != nil {
+		if err = rows.Scan(&spiffeid, &plugin, &cluster, &displayName, &createdAt, &updatedAt); err != nil {
 			return types.AgentInfoList{}, SQLError{cmd, err}
 		}
+		creationTime, err := ParseTimestamp(createdAt.String)
+		if err != nil {
+			return types.AgentInfoList{}, errors.Errorf("Invalid creation time of agent %s: %v", spiffeid, err)
+		}
+		changeTime, err := ParseTimestamp(updatedAt.String)
+		if err != nil {
+			return types.AgentInfoList{}, errors.Errorf("Invalid change time of agent %s: %v", spiffeid, err)
+		}
 
 		newAgent := types.AgentInfo{
-			Spiffeid: spiffeid,
-			Plugin:   "",
-			Cluster:  "",
+			Spiffeid:     spiffeid,
+			Plugin:       "",
+			Cluster:      "",
+			DisplayName:  displayName.String,
+			CreationTime: creationTime,
+			UpdatedAt:    changeTime,
 		}
 		if plugin.Valid {
 			newAgent.Plugin = plugin.String
@@ -246,41 +715,216 @@ func (db *LocalSqliteDb) GetAgentsMetadata(req types.AgentMetadataRequest) (type
 		ainfos = append(ainfos, newAgent)
 	}
 
+	// ADD current compliance attributes of the selected agents
+	cmdCompliance := `SELECT agents.spiffeid, agent_compliance.attribute, agent_compliance.value 
+          FROM agent_compliance 
+          JOIN agents ON agent_compliance.agent_id = agents.id` + where
+	complianceRows, err := db.database.Query(cmdCompliance, vals...)
+	if err != nil {
+		return types.AgentInfoList{}, SQLError{cmdCompliance, err}
+	}
+	defer complianceRows.Close()
+	compliance := make(map[string]map[string]string)
+	for complianceRows.Next() {
+		var attribute, value string
+		if err = complianceRows.Scan(&spiffeid, &attribute, &value); err != nil {
+			return types.AgentInfoList{}, SQLError{cmdCompliance, err}
+		}
+		if compliance[spiffeid] == nil {
+			compliance[spiffeid] = make(map[string]string)
+		}
+		compliance[spiffeid][attribute] = value
+	}
+	for i := range ainfos {
+		ainfos[i].Compliance = compliance[ainfos[i].Spiffeid]
+	}
+
+	// ADD labels of the selected agents
+	cmdLabels := `SELECT agents.spiffeid, agent_labels.label, agent_labels.value 
+          FROM agent_labels 
+          JOIN agents ON agent_labels.agent_id = agents.id` + where
+	labels, err := db.getLabels(cmdLabels, vals...)
+	if err != nil {
+		return types.AgentInfoList{}, err
+	}
+	for i := range ainfos {
+		ainfos[i].Labels = labels[ainfos[i].Spiffeid]
+	}
+
+	// ADD annotations of the selected agents
+	cmdAnnotations := `SELECT agents.spiffeid, agent_annotations.annotation, agent_annotations.value 
+          FROM agent_annotations 
+          JOIN agents ON agent_annotations.agent_id = agents.id` + where
+	annotations, err := db.getLabels(cmdAnnotations, vals...)
+	if err != nil {
+		return types.AgentInfoList{}, err
+	}
+	for i := range ainfos {
+		ainfos[i].Annotations = annotations[ainfos[i].Spiffeid]
+	}
+	collation.Sort(db.collation, ainfos, func(a types.AgentInfo) string { return a.Spiffeid })
+
+	if !req.Paginated() {
+		return types.AgentInfoList{
+			Agents: ainfos,
+		}, nil
+	}
 	return types.AgentInfoList{
-		Agents: ainfos,
+		Agents:     ainfos,
+		NextCursor: types.NewList(ainfos, offset, total).NextCursor,
+		Total:      total,
 	}, nil
 }
 
+// complianceFilterCond returns the condition on agents matching filter
+// takes the attribute and value as arguments, in this order
+func complianceFilterCond(filter types.ComplianceFilter) (string, error) {
+	if err := filter.Validate(); err != nil {
+		return "", GetError{err.Error()}
+	}
+	value := `agent_compliance.value`
+	arg := `?`
+	var op string
+	switch filter.Op {
+	case "", types.ComplianceOpEq:
+		op = "="
+	case types.ComplianceOpNe:
+		op = "!="
+	default:
+		value = `CAST(agent_compliance.value AS REAL)`
+		arg = `CAST(? AS REAL)`
+		op = map[string]string{
+			types.ComplianceOpLt: "<",
+			types.ComplianceOpLe: "<=",
+			types.ComplianceOpGt: ">",
+			types.ComplianceOpGe: ">=",
+		}[filter.Op]
+	}
+	return `EXISTS (SELECT 1 FROM agent_compliance WHERE agent_compliance.agent_id = agents.id 
+          AND agent_compliance.attribute = ? AND ` + value + ` ` + op + ` ` + arg + `)`, nil
+}
+
 // GetClusters outputs a list of ClusterInfo structs with information on currently registered clusters
 func (db *LocalSqliteDb) GetClusters() (types.ClusterInfoList, error) {
-	// BEGIN transaction
-	cmd := `SELECT clusters.name, clusters.created_at, clusters.domain_name, clusters.managed_by, 
-          clusters.platform_type, GROUP_CONCAT(agents.spiffeid) 
+	sinfos, err := db.getClusters("", nil)
+	if err != nil {
+		return types.ClusterInfoList{}, err
+	}
+	return types.ClusterInfoList{
+		Clusters: sinfos,
+	}, nil
+}
+
+// clusterColumns lists the fields clusters can be filtered on
+var clusterColumns = listColumns{
+	"uid":          "uid",
+	"domainName":   "domain_name",
+	"platformType": "platform_type",
+	"managedBy":    "managed_by",
+	"ownerTeam":    "owner_team",
+	"tenant":       "tenant",
+}
+
+// GetClustersPage returns a page of the registered clusters matching the
+// filters and label selector of opts, sorted on the sort fields of opts, by
+// default in the order of their names under the collation of the DB
+// only the names of the clusters are read to select the page
+func (db *LocalSqliteDb) GetClustersPage(opts types.ListOptions) (types.List[types.ClusterInfo], error) {
+	if err := opts.Validate(clusterColumns.fields(), ClusterSortFields); err != nil {
+		return types.List[types.ClusterInfo]{}, err
+	}
+	where, _, args, err := listClauses(types.ListOptions{Filters: opts.Filters}, clusterColumns, "name")
+	if err != nil {
+		return types.List[types.ClusterInfo]{}, err
+	}
+	labelConds, labelArgs, err := ClusterLabelConds(opts.LabelSelector, sqlitePlaceholder, len(args))
+	if err != nil {
+		return types.List[types.ClusterInfo]{}, err
+	}
+	if len(labelConds) > 0 {
+		if where == "" {
+			where = " WHERE "
+		} else {
+			where += " AND "
+		}
+		where += strings.Join(labelConds, " AND ")
+		args = append(args, labelArgs...)
+	}
+
+	// the page is sorted and cut by sqlite, names under the collation of the DB
+	offset, limit, err := opts.PageBounds()
+	if err != nil {
+		return types.List[types.ClusterInfo]{}, err
+	}
+	var total int
+	cmd := `SELECT COUNT(*) FROM clusters` + where
+	if err = db.database.QueryRow(cmd, args...).Scan(&total); err != nil {
+		return types.List[types.ClusterInfo]{}, SQLError{cmd, err}
+	}
+	page, err := db.getStrings(`SELECT clusters.name FROM clusters`+where+ClusterOrderBy(opts.Sort, db.nameOrder)+LimitOffset(offset, limit), args...)
+	if err != nil {
+		return types.List[types.ClusterInfo]{}, err
+	}
+	if len(page) == 0 {
+		return types.NewList([]types.ClusterInfo{}, offset, total), nil
+	}
+	pageWhere, vals := inCond("clusters.name", page)
+	sinfos, err := db.getClusters(" WHERE "+pageWhere, vals)
+	if err != nil {
+		return types.List[types.ClusterInfo]{}, err
+	}
+	return types.NewList(InOrder(sinfos, page, ClusterName), offset, total), nil
+}
+
+// getClusters returns the clusters selected by where, sorted by name
+// where is a WHERE clause on the clusters table, all clusters if empty
+func (db *LocalSqliteDb) getClusters(where string, vals []interface{}) ([]types.ClusterInfo, error) {
+	cmd := `SELECT clusters.name, clusters.uid, clusters.created_at, clusters.updated_at, clusters.domain_name, clusters.managed_by, 
+          clusters.platform_type, clusters.owner_email, clusters.owner_team, clusters.slack_channel, 
+          clusters.tenant, clusters.protected, clusters.metadata, GROUP_CONCAT(agents.spiffeid) 
           FROM clusters 
           LEFT JOIN cluster_memberships ON clusters.id=cluster_memberships.cluster_id
-          LEFT JOIN agents ON cluster_memberships.agent_id=agents.id
+          LEFT JOIN agents ON cluster_memberships.agent_id=agents.id` + where + `
           GROUP BY clusters.name`
 
-	rows, err := db.database.Query(cmd)
+	rows, err := db.database.Query(cmd, vals...)
 	if err != nil {
-		return types.ClusterInfoList{}, SQLError{cmd, err}
+		return nil, SQLError{cmd, err}
 	}
+	defer rows.Close()
 
 	sinfos := []types.ClusterInfo{}
 	var (
 		name                string
+		uid                 string
 		createdAt           string
+		updatedAt           sql.NullString
 		domainName          string
 		managedBy           string
 		platformType        string
+		ownerEmail          sql.NullString
+		ownerTeam           sql.NullString
+		slackChannel        sql.NullString
+		tenant              sql.NullString
+		protected           bool
+		metadata            sql.NullString
 		agentsListConcatted sql.NullString
 		agentsList          []string
 	)
 	for rows.Next() {
-		if err = rows.Scan(&name, &createdAt, &domainName, &managedBy, &platformType, &agentsListConcatted); err != nil {
-			return types.ClusterInfoList{}, SQLError{cmd, err}
+		if err = rows.Scan(&name, &uid, &createdAt, &updatedAt, &domainName, &managedBy, &platformType,
+			&ownerEmail, &ownerTeam, &slackChannel, &tenant, &protected, &metadata, &agentsListConcatted); err != nil {
+			return nil, SQLError{cmd, err}
 		}
 
+		creationTime, err := ParseTimestamp(createdAt)
+		if err != nil {
+			return nil, errors.Errorf("Invalid creation time of cluster %s: %v", name, err)
+		}
+		changeTime, err := ParseTimestamp(updatedAt.String)
+		if err != nil {
+			return nil, errors.Errorf("Invalid change time of cluster %s: %v", name, err)
+		}
 		if agentsListConcatted.Valid { // handle clusters with no assigned agents
 			agentsList = strings.Split(agentsListConcatted.String, ",")
 		} else {
@@ -288,72 +932,189 @@ func (db *LocalSqliteDb) GetClusters() (types.ClusterInfoList, error) {
 		}
 		sinfos = append(sinfos, types.ClusterInfo{
 			Name:         name,
-			CreationTime: createdAt,
+			UID:          uid,
+			CreationTime: creationTime,
+			UpdatedAt:    changeTime,
 			DomainName:   domainName,
 			ManagedBy:    managedBy,
 			PlatformType: platformType,
 			AgentsList:   agentsList,
+			OwnerEmail:   ownerEmail.String,
+			OwnerTeam:    ownerTeam.String,
+			SlackChannel: slackChannel.String,
+			Tenant:       tenant.String,
+			Protected:    protected,
+			Metadata:     ParseMetadata(metadata),
 		})
 	}
 
-	return types.ClusterInfoList{
-		Clusters: sinfos,
-	}, nil
+	extensions, err := db.getClusterExtensions(where, vals)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := db.getLabels(`SELECT clusters.name, cluster_labels.label, cluster_labels.value 
+          FROM cluster_labels 
+          JOIN clusters ON cluster_labels.cluster_id=clusters.id`+where, vals...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sinfos {
+		sinfos[i].Extensions = extensions[sinfos[i].Name]
+		sinfos[i].Labels = labels[sinfos[i].Name]
+		db.collation.Strings(sinfos[i].AgentsList)
+	}
+	collation.Sort(db.collation, sinfos, func(c types.ClusterInfo) string { return c.Name })
+	return sinfos, nil
 }
 
-// CreateClusterEntry takes in struct cinfo of type ClusterInfo.  If a cluster with cinfo.Name already registered, returns error.
-func (db *LocalSqliteDb) createClusterEntryOp(cinfo types.ClusterInfo) error {
+// getClusterExtensions returns the extension fields of the clusters selected by where by cluster name
+func (db *LocalSqliteDb) getClusterExtensions(where string, vals []interface{}) (map[string]map[string]interface{}, error) {
+	cmd := `SELECT clusters.name, cluster_extensions.field, cluster_extensions.value 
+          FROM cluster_extensions 
+          JOIN clusters ON cluster_extensions.cluster_id=clusters.id` + where
+	rows, err := db.database.Query(cmd, vals...)
+	if err != nil {
+		return nil, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	extensions := make(map[string]map[string]interface{})
+	for rows.Next() {
+		var name, field, encoded string
+		if err = rows.Scan(&name, &field, &encoded); err != nil {
+			return nil, SQLError{cmd, err}
+		}
+		var value interface{}
+		if err = json.Unmarshal([]byte(encoded), &value); err != nil {
+			return nil, errors.Errorf("Invalid value of extension field %s of cluster %s: %v", field, name, err)
+		}
+		if extensions[name] == nil {
+			extensions[name] = make(map[string]interface{})
+		}
+		extensions[name][field] = value
+	}
+	return extensions, nil
+}
+
+// getLabels returns the labels selected by cmd by object name
+// cmd selects the object name, label and value
+func (db *LocalSqliteDb) getLabels(cmd string, args ...interface{}) (map[string]map[string]string, error) {
+	rows, err := db.database.Query(cmd, args...)
+	if err != nil {
+		return nil, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	labels := make(map[string]map[string]string)
+	for rows.Next() {
+		var name, label, value string
+		if err = rows.Scan(&name, &label, &value); err != nil {
+			return nil, SQLError{cmd, err}
+		}
+		if labels[name] == nil {
+			labels[name] = make(map[string]string)
+		}
+		labels[name][label] = value
+	}
+	return labels, nil
+}
+
+// CreateClusterEntry takes in struct cinfo of type ClusterInfo.  If a cluster with cinfo.Name already registered, returns error.
+func (db *LocalSqliteDb) createClusterEntryOp(cinfo types.ClusterInfo) error {
 	// BEGIN transaction
 	ctx := context.Background()
 	tx, err := db.database.BeginTx(ctx, nil)
 	if err != nil {
 		return errors.Errorf("Error initializing context: %v", err)
 	}
-	txHelper := getTornjakTxHelper(ctx, tx)
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "createClusterEntry")
 
-	// INSERT cluster metadata
-	err = txHelper.insertClusterMetadata(cinfo)
+	// INSERT cluster with a new UID
+	err = txHelper.createCluster(cinfo, "")
 	if err != nil {
 		return backoff.Permanent(txHelper.rollbackHandler(err))
 	}
+	return txHelper.commit()
+}
+
+func (db *LocalSqliteDb) createClusterEntriesOp(cinfos []types.ClusterInfo) (types.BatchResult, error) {
+	// BEGIN transaction
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return types.BatchResult{}, errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "createClusterEntries")
 
-	// ADD agents to cluster
-	err = txHelper.addAgentBatchToCluster(cinfo.Name, cinfo.AgentsList)
+	// INSERT each cluster with a new UID
+	result, err := CreateClusterBatch(txHelper.clusterBatchTx(), cinfos)
 	if err != nil {
-		return backoff.Permanent(txHelper.rollbackHandler(err))
+		return types.BatchResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
 	}
-	return tx.Commit()
+	return result, txHelper.commit()
 }
 
 // EditClusterEntry takes in struct cinfo of type ClusterInfo.  If cluster with cinfo.Name does not exist, throws error.
-func (db *LocalSqliteDb) editClusterEntryOp(cinfo types.ClusterInfo) error {
+// Returns the fields changed from the stored cluster, read in the same transaction.
+func (db *LocalSqliteDb) editClusterEntryOp(cinfo types.ClusterInfo) (types.ClusterEditResult, error) {
 	// BEGIN transaction
 	ctx := context.Background()
 	tx, err := db.database.BeginTx(ctx, nil)
 	if err != nil {
-		return errors.Errorf("Error initializing context: %v", err)
+		return types.ClusterEditResult{}, errors.Errorf("Error initializing context: %v", err)
 	}
-	txHelper := getTornjakTxHelper(ctx, tx)
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "editClusterEntry")
 
-	// UPDATE cluster metadata
-	err = txHelper.updateClusterMetadata(cinfo)
+	// UPDATE cluster
+	result, err := txHelper.editCluster(cinfo)
 	if err != nil {
-		return backoff.Permanent(txHelper.rollbackHandler(err))
+		return types.ClusterEditResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
 	}
+	return result, txHelper.commit()
+}
 
-	// REMOVE all currently assigned cluster agents
-	err = txHelper.deleteClusterAgents(cinfo.EditedName)
+func (db *LocalSqliteDb) createOrUpdateClusterEntryOp(cinfo types.ClusterInfo) (types.ClusterUpsertResult, error) {
+	// BEGIN transaction
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
 	if err != nil {
-		return backoff.Permanent(txHelper.rollbackHandler(err))
+		return types.ClusterUpsertResult{}, errors.Errorf("Error initializing context: %v", err)
 	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "createOrUpdateClusterEntry")
 
-	// ADD agents to cluster
-	err = txHelper.addAgentBatchToCluster(cinfo.EditedName, cinfo.AgentsList)
+	// GET and lock the name of the cluster with the UID
+	name, found, err := txHelper.lockClusterByUID(cinfo.UID)
 	if err != nil {
-		return backoff.Permanent(txHelper.rollbackHandler(err))
+		return types.ClusterUpsertResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	if !found {
+		// CHECK the UID is not of a deleted cluster
+		deleted, err := txHelper.isDeletedCluster(cinfo.UID)
+		if err == nil && deleted {
+			err = DeletedClusterUIDFailure(cinfo.UID)
+		}
+		if err != nil {
+			return types.ClusterUpsertResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+		}
+
+		// INSERT cluster with the UID
+		err = txHelper.createCluster(cinfo, cinfo.UID)
+		if err != nil {
+			return types.ClusterUpsertResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+		}
+		result := types.ClusterUpsertResult{UID: cinfo.UID, Name: cinfo.Name, Created: true, Changes: []types.FieldChange{}}
+		return result, txHelper.commit()
 	}
 
-	return tx.Commit()
+	// UPDATE cluster, renaming it to cinfo.Name
+	edit := cinfo
+	edit.Name, edit.EditedName = name, cinfo.Name
+	edited, err := txHelper.editCluster(edit)
+	if err != nil {
+		return types.ClusterUpsertResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	result := types.ClusterUpsertResult{UID: cinfo.UID, Name: edited.Name, Changes: edited.Changes}
+	return result, txHelper.commit()
 }
 
 // DeleteClusterEntry takes in string name of cluster and removes cluster information and agent membership of cluster from the database.  If not all agents can be removed from the cluster, cluster information remains in the database.
@@ -364,25 +1125,159 @@ func (db *LocalSqliteDb) deleteClusterEntryOp(clusterName string) error {
 	if err != nil {
 		return errors.Errorf("Error initializing context: %v", err)
 	}
-	txHelper := getTornjakTxHelper(ctx, tx)
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "deleteClusterEntry")
 
-	// REMOVE all currently assigned cluster agents (requires metadata still entered)
-	err = txHelper.deleteClusterAgents(clusterName)
+	// CHECK cluster is not protected
+	err = txHelper.lockDeletableCluster(clusterName)
 	if err != nil {
 		return backoff.Permanent(txHelper.rollbackHandler(err))
 	}
 
-	// REMOVE cluster metadata
-	err = txHelper.deleteClusterMetadata(clusterName)
+	// REMOVE cluster with its memberships
+	err = txHelper.deleteCluster(clusterName, false)
 	if err != nil {
 		return backoff.Permanent(txHelper.rollbackHandler(err))
 	}
+	return txHelper.commit()
+}
+
+func (db *LocalSqliteDb) deleteClusterEntryInBackgroundOp(clusterName string, minMemberships int) (*types.ClusterDeletion, error) {
+	// BEGIN transaction
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "deleteClusterEntryInBackground")
+
+	// CHECK cluster is not protected
+	err = txHelper.lockDeletableCluster(clusterName)
+	if err != nil {
+		return nil, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+
+	// COUNT memberships of cluster
+	memberships, err := txHelper.countClusterMemberships(clusterName)
+	if err != nil {
+		return nil, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	deferred := memberships >= minMemberships
+
+	// REMOVE cluster, with its memberships unless deferred
+	err = txHelper.deleteCluster(clusterName, deferred)
+	if err != nil {
+		return nil, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	if !deferred {
+		return nil, txHelper.commit()
+	}
+	deletion, err := txHelper.getClusterDeletion(clusterName)
+	if err != nil {
+		return nil, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	return &deletion, txHelper.commit()
+}
+
+// DeleteClusterEntryInBackground deletes the cluster, leaving the memberships
+// of its agents to DeleteClusterMemberships if it has minMemberships or more
+// returns the deletion removing the memberships, nil if they are removed
+func (db *LocalSqliteDb) DeleteClusterEntryInBackground(name string, minMemberships int) (*types.ClusterDeletion, error) {
+	var deletion *types.ClusterDeletion
+	operation := func() error {
+		var err error
+		deletion, err = db.deleteClusterEntryInBackgroundOp(name, minMemberships)
+		return err
+	}
+	err := db.retryOp(operation)
+	return deletion, err
+}
+
+func (db *LocalSqliteDb) deleteClusterMembershipsOp(id int64, limit int) (int, error) {
+	// BEGIN transaction
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "deleteClusterMemberships")
+
+	// REMOVE batch of memberships
+	remaining, err := txHelper.deleteDeferredMemberships(id, limit)
+	if err != nil {
+		return 0, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	return remaining, txHelper.commit()
+}
+
+// DeleteClusterEntriesInBackground deletes the clusters with the given UIDs
+// as DeleteClusterEntries does, leaving the memberships of those with
+// minMemberships agents or more to DeleteClusterMemberships; with
+// minMemberships 0 all memberships are removed at once
+func (db *LocalSqliteDb) DeleteClusterEntriesInBackground(uids []string, minMemberships int) (types.BatchResult, error) {
+	var result types.BatchResult
+	operation := func() error {
+		var err error
+		result, err = db.deleteClusterEntriesOp(uids, minMemberships)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+// DeleteClusterMemberships removes up to limit memberships of the cluster
+// deletion with the given ID in one transaction, and ends the deletion once
+// none remain; deletions already ended have none remaining
+func (db *LocalSqliteDb) DeleteClusterMemberships(id int64, limit int) (int, error) {
+	var remaining int
+	operation := func() error {
+		var err error
+		remaining, err = db.deleteClusterMembershipsOp(id, limit)
+		return err
+	}
+	err := db.retryOp(operation)
+	return remaining, err
+}
+
+// GetClusterDeletions outputs the cluster deletions whose memberships are not
+// all removed, oldest first
+func (db *LocalSqliteDb) GetClusterDeletions() (types.ClusterDeletionList, error) {
+	cmd := `SELECT d.id, d.uid, d.name, d.memberships, d.deleted_at, 
+          (SELECT COUNT(*) FROM cluster_memberships m WHERE m.cluster_id=d.cluster_id) 
+          FROM cluster_deletions d ORDER BY d.id`
+	rows, err := db.database.Query(cmd)
+	if err != nil {
+		return types.ClusterDeletionList{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+	ret := types.ClusterDeletionList{Deletions: []types.ClusterDeletion{}}
+	for rows.Next() {
+		var d types.ClusterDeletion
+		var uid sql.NullString
+		if err = rows.Scan(&d.ID, &uid, &d.Name, &d.Memberships, &d.DeletedAt, &d.Remaining); err != nil {
+			return types.ClusterDeletionList{}, SQLError{cmd, err}
+		}
+		d.UID = uid.String
+		ret.Deletions = append(ret.Deletions, d)
+	}
+	if err = rows.Err(); err != nil {
+		return types.ClusterDeletionList{}, SQLError{cmd, err}
+	}
+	return ret, nil
+}
 
-	return tx.Commit()
+// opBackOff returns the backoff of a single operation
+// an ExponentialBackOff holds the state of one sequence of retries, so concurrent
+// operations each retry with their own copy
+func (db *LocalSqliteDb) opBackOff() backoff.BackOff {
+	if expBackoff, ok := (*db.expBackoff).(*backoff.ExponentialBackOff); ok {
+		opBackoff := *expBackoff
+		return &opBackoff
+	}
+	return *db.expBackoff
 }
 
 func (db *LocalSqliteDb) retryOp(operation func() error) error {
-	err := backoff.Retry(operation, *db.expBackoff)
+	err := backoff.Retry(operation, db.opBackOff())
 	if err != nil {
 		if serr, ok := err.(*backoff.PermanentError); ok {
 			return serr.Unwrap()
@@ -398,11 +1293,47 @@ func (db *LocalSqliteDb) CreateClusterEntry(cinfo types.ClusterInfo) error {
 	return db.retryOp(operation)
 }
 
-func (db *LocalSqliteDb) EditClusterEntry(cinfo types.ClusterInfo) error {
+// CreateClusterEntries creates the clusters of cinfos in one transaction,
+// leaving out those that cannot be created
+// returns the outcome of each cluster, skipped if a cluster with its name exists
+func (db *LocalSqliteDb) CreateClusterEntries(cinfos []types.ClusterInfo) (types.BatchResult, error) {
+	var result types.BatchResult
 	operation := func() error {
-		return db.editClusterEntryOp(cinfo)
+		var err error
+		result, err = db.createClusterEntriesOp(cinfos)
+		return err
 	}
-	return db.retryOp(operation)
+	err := db.retryOp(operation)
+	return result, err
+}
+
+func (db *LocalSqliteDb) EditClusterEntry(cinfo types.ClusterInfo) (types.ClusterEditResult, error) {
+	var result types.ClusterEditResult
+	operation := func() error {
+		var err error
+		result, err = db.editClusterEntryOp(cinfo)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+// CreateOrUpdateClusterEntry creates the cluster cinfo with UID cinfo.UID if no
+// cluster has it, and otherwise updates the cluster with the UID as
+// EditClusterEntry would, renaming it to cinfo.Name; cinfo.EditedName is ignored
+// returns PostFailure if the UID is invalid or of a deleted cluster
+func (db *LocalSqliteDb) CreateOrUpdateClusterEntry(cinfo types.ClusterInfo) (types.ClusterUpsertResult, error) {
+	if err := ValidateClusterUID(cinfo.UID); err != nil {
+		return types.ClusterUpsertResult{}, err
+	}
+	var result types.ClusterUpsertResult
+	operation := func() error {
+		var err error
+		result, err = db.createOrUpdateClusterEntryOp(cinfo)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
 }
 
 func (db *LocalSqliteDb) DeleteClusterEntry(clustername string) error {
@@ -411,3 +1342,1950 @@ func (db *LocalSqliteDb) DeleteClusterEntry(clustername string) error {
 	}
 	return db.retryOp(operation)
 }
+
+func (db *LocalSqliteDb) deleteClusterEntriesOp(uids []string, minMemberships int) (types.BatchResult, error) {
+	// BEGIN transaction
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return types.BatchResult{}, errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "deleteClusterEntries")
+
+	// REMOVE each cluster not protected
+	result, err := DeleteClusterBatch(txHelper.clusterDeleteBatchTx(minMemberships), uids)
+	if err != nil {
+		return types.BatchResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	return result, txHelper.commit()
+}
+
+// DeleteClusterEntries deletes the clusters with the given UIDs in one
+// transaction, leaving those that cannot be deleted
+// returns the outcome of each UID, failed if no cluster has it or the cluster
+// is protected
+func (db *LocalSqliteDb) DeleteClusterEntries(uids []string) (types.BatchResult, error) {
+	return db.DeleteClusterEntriesInBackground(uids, 0)
+}
+
+func (db *LocalSqliteDb) restoreClusterEntryOp(uid string) (types.ClusterRestoreResult, error) {
+	// BEGIN transaction
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return types.ClusterRestoreResult{}, errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "restoreClusterEntry")
+
+	// GET state of deleted cluster
+	cinfo, err := txHelper.unarchiveCluster(uid)
+	if err != nil {
+		return types.ClusterRestoreResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+
+	// INSERT cluster metadata with its UID
+	err = txHelper.restoreClusterMetadata(cinfo)
+	if err != nil {
+		return types.ClusterRestoreResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+
+	// ADD agents not assigned to another cluster since
+	conflicts, err := txHelper.getAgentConflicts(cinfo.AgentsList)
+	if err != nil {
+		return types.ClusterRestoreResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	assigned := make(map[string]string, len(conflicts))
+	for _, c := range conflicts {
+		assigned[c.spiffeid] = c.cluster
+	}
+	agents, skipped := RestorableAgents(cinfo.AgentsList, assigned)
+	err = txHelper.addAgentBatchToCluster(cinfo.Name, agents)
+	if err != nil {
+		return types.ClusterRestoreResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+
+	// ADD extension fields and labels of cluster
+	err = txHelper.setClusterExtensions(cinfo.Name, cinfo.Extensions)
+	if err != nil {
+		return types.ClusterRestoreResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	err = txHelper.setClusterLabels(cinfo.Name, cinfo.Labels)
+	if err != nil {
+		return types.ClusterRestoreResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+
+	// ADD restore to history
+	err = txHelper.recordClusterHistory(cinfo.Name, types.ClusterChangeRestored)
+	if err != nil {
+		return types.ClusterRestoreResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	result := types.ClusterRestoreResult{Name: cinfo.Name, UID: uid, SkippedAgents: skipped}
+	return result, txHelper.commit()
+}
+
+// RestoreClusterEntry restores the deleted cluster with the given UID, with its
+// agents, labels, extension fields and metadata, under the name it had
+// agents assigned to another cluster since stay there and are reported
+// cluster tokens revoked by the delete are not restored
+// returns PostFailure if no cluster with the UID is deleted or its name is taken
+func (db *LocalSqliteDb) RestoreClusterEntry(uid string) (types.ClusterRestoreResult, error) {
+	var result types.ClusterRestoreResult
+	operation := func() error {
+		var err error
+		result, err = db.restoreClusterEntryOp(uid)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+// ListDeletedClusters outputs the deleted clusters that can be restored,
+// most recently deleted first
+func (db *LocalSqliteDb) ListDeletedClusters() (types.DeletedClusterList, error) {
+	cmd := `SELECT snapshot, deleted_at FROM deleted_clusters ORDER BY id DESC`
+	rows, err := db.database.Query(cmd)
+	if err != nil {
+		return types.DeletedClusterList{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	deleted := []types.DeletedCluster{}
+	for rows.Next() {
+		var snapshot, deletedAt string
+		if err = rows.Scan(&snapshot, &deletedAt); err != nil {
+			return types.DeletedClusterList{}, SQLError{cmd, err}
+		}
+		d, err := ParseDeletedCluster(snapshot, deletedAt)
+		if err != nil {
+			return types.DeletedClusterList{}, err
+		}
+		deleted = append(deleted, d)
+	}
+	if err = rows.Err(); err != nil {
+		return types.DeletedClusterList{}, SQLError{cmd, err}
+	}
+	return types.DeletedClusterList{Clusters: deleted}, nil
+}
+
+func (db *LocalSqliteDb) setClusterProtectionOp(name string, protected bool) error {
+	// BEGIN transaction
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "setClusterProtection")
+
+	// UPDATE protection of cluster
+	changed, err := txHelper.updateClusterProtection(name, protected)
+	if err != nil {
+		return backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	if !changed {
+		return txHelper.commit()
+	}
+
+	// ADD changed cluster to history
+	err = txHelper.recordClusterHistory(name, types.ClusterChangeUpdated)
+	if err != nil {
+		return backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	return txHelper.commit()
+}
+
+// SetClusterProtection sets whether deletes of the cluster are rejected
+// setting the protection the cluster already has changes nothing
+func (db *LocalSqliteDb) SetClusterProtection(name string, protected bool) error {
+	operation := func() error {
+		return db.setClusterProtectionOp(name, protected)
+	}
+	return db.retryOp(operation)
+}
+
+func (db *LocalSqliteDb) renameClusterEntryOp(name string, newName string) (types.ClusterEditResult, error) {
+	// BEGIN transaction
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return types.ClusterEditResult{}, errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "renameClusterEntry")
+
+	// UPDATE cluster name
+	err = txHelper.renameClusterMetadata(name, newName)
+	if err != nil {
+		return types.ClusterEditResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	result := types.ClusterEditResult{Name: newName, Changes: []types.FieldChange{}}
+	if name == newName {
+		return result, txHelper.commit()
+	}
+
+	// ADD renamed cluster to history
+	err = txHelper.recordClusterHistory(newName, types.ClusterChangeUpdated)
+	if err != nil {
+		return types.ClusterEditResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	result.Changes = append(result.Changes, types.FieldChange{Field: "name", Before: name, After: newName})
+	return result, txHelper.commit()
+}
+
+// RenameClusterEntry changes the name of the cluster only, so it keeps its UID,
+// agent memberships, labels, extension fields and protection
+// returns PostFailure if the cluster does not exist or a cluster already has newName
+func (db *LocalSqliteDb) RenameClusterEntry(name string, newName string) (types.ClusterEditResult, error) {
+	var result types.ClusterEditResult
+	operation := func() error {
+		var err error
+		result, err = db.renameClusterEntryOp(name, newName)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+// GetClustersAsOf outputs the clusters with their agents as they were at the given
+// RFC 3339 UTC time, reconstructed from the history of clusters
+// clusters that existed before their history was recorded appear from the time they were first recorded
+func (db *LocalSqliteDb) GetClustersAsOf(asOf string) (types.ClusterInfoList, error) {
+	cmd := `SELECT change, snapshot FROM cluster_history 
+          WHERE id IN (SELECT MAX(id) FROM cluster_history WHERE changed_at<=? GROUP BY cluster_uid)`
+	rows, err := db.database.Query(cmd, asOf)
+	if err != nil {
+		return types.ClusterInfoList{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	sinfos := []types.ClusterInfo{}
+	for rows.Next() {
+		var change, snapshot string
+		if err = rows.Scan(&change, &snapshot); err != nil {
+			return types.ClusterInfoList{}, SQLError{cmd, err}
+		}
+		if change == types.ClusterChangeDeleted {
+			continue
+		}
+		cinfo := types.ClusterInfo{}
+		if err = json.Unmarshal([]byte(snapshot), &cinfo); err != nil {
+			return types.ClusterInfoList{}, errors.Errorf("Invalid cluster history record: %v", err)
+		}
+		if cinfo.AgentsList == nil {
+			cinfo.AgentsList = []string{}
+		}
+		db.collation.Strings(cinfo.AgentsList)
+		sinfos = append(sinfos, cinfo)
+	}
+	collation.Sort(db.collation, sinfos, func(c types.ClusterInfo) string { return c.Name })
+
+	return types.ClusterInfoList{
+		Clusters: sinfos,
+	}, nil
+}
+
+// GetClusterChanges outputs the most recent changes of clusters, most recent first
+// clusters recorded when their history started are not changes and are left out
+func (db *LocalSqliteDb) GetClusterChanges(limit int) ([]types.ClusterChange, error) {
+	cmd := `SELECT cluster_uid, name, change, changed_at FROM cluster_history 
+          WHERE change!=? ORDER BY id DESC LIMIT ?`
+	rows, err := db.database.Query(cmd, types.ClusterChangeRecorded, limit)
+	if err != nil {
+		return nil, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	changes := []types.ClusterChange{}
+	for rows.Next() {
+		var change types.ClusterChange
+		if err = rows.Scan(&change.ClusterUID, &change.Name, &change.Change, &change.ChangedAt); err != nil {
+			return nil, SQLError{cmd, err}
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// GetClusterChangeLog returns up to limit entries of the history of clusters
+// following the entry with ID after, oldest first, with the state of the
+// cluster after each change, and the ID of the latest entry
+// limit is capped at MaxChangeLogEntries; with 0 only the latest ID is returned
+func (db *LocalSqliteDb) GetClusterChangeLog(after int64, limit int) (types.ClusterChangeLog, error) {
+	ret := types.ClusterChangeLog{Entries: []types.ClusterChangeLogEntry{}}
+	cmdLatest := `SELECT COALESCE(MAX(id), 0) FROM cluster_history`
+	if err := db.database.QueryRow(cmdLatest).Scan(&ret.LatestID); err != nil {
+		return types.ClusterChangeLog{}, SQLError{cmdLatest, err}
+	}
+	limit = min(limit, MaxChangeLogEntries)
+	if limit <= 0 {
+		return ret, nil
+	}
+
+	cmd := `SELECT id, cluster_uid, name, change, changed_at, snapshot FROM cluster_history
+          WHERE id>? ORDER BY id LIMIT ?`
+	rows, err := db.database.Query(cmd, after, limit)
+	if err != nil {
+		return types.ClusterChangeLog{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry types.ClusterChangeLogEntry
+		var snapshot sql.NullString
+		if err = rows.Scan(&entry.ID, &entry.ClusterUID, &entry.Name, &entry.Change, &entry.ChangedAt, &snapshot); err != nil {
+			return types.ClusterChangeLog{}, SQLError{cmd, err}
+		}
+		if entry.Cluster, err = ChangeLogSnapshot(entry.Change, snapshot.String); err != nil {
+			return types.ClusterChangeLog{}, err
+		}
+		ret.Entries = append(ret.Entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return types.ClusterChangeLog{}, SQLError{cmd, err}
+	}
+	return ret, nil
+}
+
+// backfillClusterHistory records the state of the clusters without history,
+// created before the history of clusters was kept
+func (db *LocalSqliteDb) backfillClusterHistory() error {
+	// BEGIN transaction
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "backfillClusterHistory")
+
+	// SELECT clusters without history
+	cmd := `SELECT name FROM clusters WHERE uid NOT IN (SELECT cluster_uid FROM cluster_history)`
+	rows, err := tx.QueryContext(ctx, cmd)
+	if err != nil {
+		return txHelper.rollbackHandler(SQLError{cmd, err})
+	}
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return txHelper.rollbackHandler(SQLError{cmd, err})
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if len(names) == 0 {
+		return tx.Rollback()
+	}
+
+	// ADD their current state to history
+	for _, name := range names {
+		err = txHelper.recordClusterHistory(name, types.ClusterChangeRecorded)
+		if err != nil {
+			return txHelper.rollbackHandler(err)
+		}
+	}
+	return txHelper.commit()
+}
+
+// SPIRE QUERY LOG HANDLERS
+
+// AddSPIRECallRecord stores a record of a SPIRE API call and drops the oldest
+// records once more than queryLogSize are stored
+func (db *LocalSqliteDb) AddSPIRECallRecord(call types.SPIRECallInfo) error {
+	cmdInsert := `INSERT INTO spire_query_log (method, duration_ms, status, user, request_id, created_at) VALUES (?,?,?,?,?,?)`
+	res, err := db.database.Exec(cmdInsert, call.Method, call.DurationMs, call.Status, call.User, call.RequestID, call.Timestamp)
+	if err != nil {
+		return SQLError{cmdInsert, err}
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return SQLError{cmdInsert, err}
+	}
+
+	cmdTrim := `DELETE FROM spire_query_log WHERE id <= ?`
+	_, err = db.database.Exec(cmdTrim, id-int64(db.queryLogSize))
+	if err != nil {
+		return SQLError{cmdTrim, err}
+	}
+	return nil
+}
+
+// spireCallColumns lists the fields SPIRE API call records can be filtered and sorted on
+var spireCallColumns = listColumns{
+	"method":     "method",
+	"status":     "status",
+	"user":       "user",
+	"requestId":  "request_id",
+	"durationMs": "duration_ms",
+	"timestamp":  "id",
+}
+
+// GetSPIRECallRecords returns a page of the retained SPIRE API call records
+// records are returned most recent first unless opts specifies a sort
+func (db *LocalSqliteDb) GetSPIRECallRecords(opts types.ListOptions) (types.List[types.SPIRECallInfo], error) {
+	offset, limit, err := opts.PageBounds()
+	if err != nil {
+		return types.List[types.SPIRECallInfo]{}, err
+	}
+	where, order, args, err := listClauses(opts, spireCallColumns, "id DESC")
+	if err != nil {
+		return types.List[types.SPIRECallInfo]{}, err
+	}
+
+	cmdCount := `SELECT COUNT(*) FROM spire_query_log` + where
+	var total int
+	if err = db.database.QueryRow(cmdCount, args...).Scan(&total); err != nil {
+		return types.List[types.SPIRECallInfo]{}, SQLError{cmdCount, err}
+	}
+
+	cmd := `SELECT method, duration_ms, status, user, request_id, created_at 
+          FROM spire_query_log` + where + order + ` LIMIT ? OFFSET ?`
+	rows, err := db.database.Query(cmd, append(args, limit, offset)...)
+	if err != nil {
+		return types.List[types.SPIRECallInfo]{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	calls := []types.SPIRECallInfo{}
+	for rows.Next() {
+		call := types.SPIRECallInfo{}
+		if err = rows.Scan(&call.Method, &call.DurationMs, &call.Status, &call.User, &call.RequestID, &call.Timestamp); err != nil {
+			return types.List[types.SPIRECallInfo]{}, SQLError{cmd, err}
+		}
+		calls = append(calls, call)
+	}
+
+	return types.NewList(calls, offset, total), nil
+}
+
+// ENTRY LINEAGE HANDLERS
+
+// CreateEntryLineage records that entry lineage.EntryId was cloned from lineage.SourceEntryId
+func (db *LocalSqliteDb) CreateEntryLineage(lineage types.EntryLineage) error {
+	cmd := `INSERT INTO entry_lineage (entry_id, source_entry_id, created_by, created_at) VALUES (?,?,?,?)`
+	_, err := db.database.Exec(cmd, lineage.EntryId, lineage.SourceEntryId, lineage.CreatedBy, lineage.CreationTime)
+	if err != nil {
+		if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrConstraint {
+			return PostFailure{Message: fmt.Sprintf("Lineage of entry %v already recorded", lineage.EntryId)}
+		}
+		return SQLError{cmd, err}
+	}
+	return nil
+}
+
+// GetEntryLineage returns the entry the given entry was cloned from
+func (db *LocalSqliteDb) GetEntryLineage(entryId string) (types.EntryLineage, error) {
+	cmd := `SELECT entry_id, source_entry_id, created_by, created_at FROM entry_lineage WHERE entry_id=?`
+	row := db.database.QueryRow(cmd, entryId)
+
+	lineage := types.EntryLineage{}
+	err := row.Scan(&lineage.EntryId, &lineage.SourceEntryId, &lineage.CreatedBy, &lineage.CreationTime)
+	if err == sql.ErrNoRows {
+		return types.EntryLineage{}, GetError{fmt.Sprintf("Entry %v was not cloned from another entry", entryId)}
+	} else if err != nil {
+		return types.EntryLineage{}, SQLError{cmd, err}
+	}
+	return lineage, nil
+}
+
+// SERVICE ACCOUNT HANDLERS
+
+// CreateServiceAccount stores a service account with the hash of its API key
+// returns PostFailure if a service account with the same name exists
+func (db *LocalSqliteDb) CreateServiceAccount(account types.ServiceAccount, keyHash string) error {
+	cmd := `INSERT INTO service_accounts (name, description, roles, key_hash, created_by, created_at) VALUES (?,?,?,?,?,?)`
+	_, err := db.database.Exec(cmd, account.Name, account.Description, strings.Join(account.Roles, ","),
+		keyHash, account.CreatedBy, account.CreationTime)
+	if err != nil {
+		if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrConstraint {
+			return PostFailure{Message: fmt.Sprintf("Service account %v already exists", account.Name)}
+		}
+		return SQLError{cmd, err}
+	}
+	return nil
+}
+
+// GetServiceAccounts outputs the list of service accounts without their API key hashes
+func (db *LocalSqliteDb) GetServiceAccounts() (types.ServiceAccountList, error) {
+	cmd := `SELECT name, description, roles, created_by, created_at FROM service_accounts`
+	rows, err := db.database.Query(cmd)
+	if err != nil {
+		return types.ServiceAccountList{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	accounts := []types.ServiceAccount{}
+	for rows.Next() {
+		account, err := scanServiceAccount(rows)
+		if err != nil {
+			return types.ServiceAccountList{}, SQLError{cmd, err}
+		}
+		accounts = append(accounts, account)
+	}
+	collation.Sort(db.collation, accounts, func(a types.ServiceAccount) string { return a.Name })
+	return types.ServiceAccountList{
+		ServiceAccounts: accounts,
+	}, nil
+}
+
+// GetServiceAccountByKeyHash returns the service account whose API key has the given hash
+// returns GetError if there is none
+func (db *LocalSqliteDb) GetServiceAccountByKeyHash(keyHash string) (types.ServiceAccount, error) {
+	cmd := `SELECT name, description, roles, created_by, created_at FROM service_accounts WHERE key_hash=?`
+	row := db.database.QueryRow(cmd, keyHash)
+	account, err := scanServiceAccount(row)
+	if err == sql.ErrNoRows {
+		return types.ServiceAccount{}, GetError{"No service account with the given API key"}
+	} else if err != nil {
+		return types.ServiceAccount{}, SQLError{cmd, err}
+	}
+	return account, nil
+}
+
+// DeleteServiceAccount deletes the service account, revoking its API key
+// returns PostFailure if the service account does not exist
+func (db *LocalSqliteDb) DeleteServiceAccount(name string) error {
+	cmd := `DELETE FROM service_accounts WHERE name=?`
+	res, err := db.database.Exec(cmd, name)
+	if err != nil {
+		return SQLError{cmd, err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return SQLError{cmd, err}
+	}
+	if numRows != 1 {
+		return PostFailure{Message: fmt.Sprintf("Service account %v does not exist", name)}
+	}
+	return nil
+}
+
+func scanServiceAccount(row interface{ Scan(...interface{}) error }) (types.ServiceAccount, error) {
+	account := types.ServiceAccount{}
+	var roles string
+	if err := row.Scan(&account.Name, &account.Description, &roles, &account.CreatedBy, &account.CreationTime); err != nil {
+		return types.ServiceAccount{}, err
+	}
+	account.Roles = []string{}
+	if len(roles) > 0 {
+		account.Roles = strings.Split(roles, ",")
+	}
+	return account, nil
+}
+
+// CLUSTER TOKEN HANDLERS
+
+// GetClusterNameByUID returns the current name of the cluster with the given UID
+// returns GetError if there is none
+func (db *LocalSqliteDb) GetClusterNameByUID(uid string) (string, error) {
+	cmd := `SELECT name FROM clusters WHERE uid=?`
+	var name string
+	err := db.database.QueryRow(cmd, uid).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", GetError{fmt.Sprintf("Cluster with UID %v does not exist", uid)}
+	} else if err != nil {
+		return "", SQLError{cmd, err}
+	}
+	return name, nil
+}
+
+// CreateClusterToken stores a cluster token with the hash of its API key
+// returns PostFailure if a cluster token with the same name exists
+func (db *LocalSqliteDb) CreateClusterToken(token types.ClusterToken, keyHash string) error {
+	cmd := `INSERT INTO cluster_tokens (name, cluster_uid, access, description, key_hash, created_by, created_at) 
+          VALUES (?,?,?,?,?,?,?)`
+	_, err := db.database.Exec(cmd, token.Name, token.ClusterUID, token.Access, token.Description,
+		keyHash, token.CreatedBy, token.CreationTime)
+	if err != nil {
+		if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrConstraint {
+			return PostFailure{Message: fmt.Sprintf("Cluster token %v already exists", token.Name)}
+		}
+		return SQLError{cmd, err}
+	}
+	return nil
+}
+
+// GetClusterTokens outputs the list of cluster tokens without their API key hashes
+func (db *LocalSqliteDb) GetClusterTokens() (types.ClusterTokenList, error) {
+	cmd := `SELECT name, cluster_uid, access, description, created_by, created_at FROM cluster_tokens`
+	rows, err := db.database.Query(cmd)
+	if err != nil {
+		return types.ClusterTokenList{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	tokens := []types.ClusterToken{}
+	for rows.Next() {
+		token, err := scanClusterToken(rows)
+		if err != nil {
+			return types.ClusterTokenList{}, SQLError{cmd, err}
+		}
+		tokens = append(tokens, token)
+	}
+	collation.Sort(db.collation, tokens, func(t types.ClusterToken) string { return t.Name })
+	return types.ClusterTokenList{
+		ClusterTokens: tokens,
+	}, nil
+}
+
+// GetClusterTokenByKeyHash returns the cluster token whose API key has the given hash
+// returns GetError if there is none
+func (db *LocalSqliteDb) GetClusterTokenByKeyHash(keyHash string) (types.ClusterToken, error) {
+	cmd := `SELECT name, cluster_uid, access, description, created_by, created_at FROM cluster_tokens WHERE key_hash=?`
+	row := db.database.QueryRow(cmd, keyHash)
+	token, err := scanClusterToken(row)
+	if err == sql.ErrNoRows {
+		return types.ClusterToken{}, GetError{"No cluster token with the given API key"}
+	} else if err != nil {
+		return types.ClusterToken{}, SQLError{cmd, err}
+	}
+	return token, nil
+}
+
+// DeleteClusterToken deletes the cluster token, revoking its API key
+// returns PostFailure if the cluster token does not exist
+func (db *LocalSqliteDb) DeleteClusterToken(name string) error {
+	cmd := `DELETE FROM cluster_tokens WHERE name=?`
+	res, err := db.database.Exec(cmd, name)
+	if err != nil {
+		return SQLError{cmd, err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return SQLError{cmd, err}
+	}
+	if numRows != 1 {
+		return PostFailure{Message: fmt.Sprintf("Cluster token %v does not exist", name)}
+	}
+	return nil
+}
+
+func scanClusterToken(row interface{ Scan(...interface{}) error }) (types.ClusterToken, error) {
+	token := types.ClusterToken{}
+	err := row.Scan(&token.Name, &token.ClusterUID, &token.Access, &token.Description, &token.CreatedBy, &token.CreationTime)
+	return token, err
+}
+
+// AGENT COMPLIANCE HANDLERS
+
+func (db *LocalSqliteDb) addAgentComplianceReportOp(report types.AgentComplianceReport) error {
+	// BEGIN transaction
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "addAgentComplianceReport")
+
+	// ADD agent if not yet known
+	cmdAgent := `INSERT OR IGNORE INTO agents (spiffeid, created_at, updated_at) VALUES (?, ?, ?)`
+	now := txHelper.now()
+	if _, err = tx.ExecContext(ctx, cmdAgent, report.Spiffeid, now, now); err != nil {
+		return backoff.Permanent(txHelper.rollbackHandler(SQLError{cmdAgent, err}))
+	}
+
+	// UPDATE current attribute values and ADD them to history
+	cmdCurrent := `INSERT INTO agent_compliance (agent_id, attribute, value, source, reported_at) 
+          VALUES ((SELECT id FROM agents WHERE spiffeid=?),?,?,?,?) 
+          ON CONFLICT(agent_id, attribute) DO UPDATE SET value=excluded.value, source=excluded.source, reported_at=excluded.reported_at`
+	cmdHistory := `INSERT INTO agent_compliance_history (agent_id, attribute, value, source, reported_at) 
+          VALUES ((SELECT id FROM agents WHERE spiffeid=?),?,?,?,?)`
+	for attribute, value := range report.Attributes {
+		if _, err = tx.ExecContext(ctx, cmdCurrent, report.Spiffeid, attribute, value, report.Source, report.ReportedAt); err != nil {
+			return backoff.Permanent(txHelper.rollbackHandler(SQLError{cmdCurrent, err}))
+		}
+		if _, err = tx.ExecContext(ctx, cmdHistory, report.Spiffeid, attribute, value, report.Source, report.ReportedAt); err != nil {
+			return backoff.Permanent(txHelper.rollbackHandler(SQLError{cmdHistory, err}))
+		}
+	}
+	return txHelper.commit()
+}
+
+// AddAgentComplianceReport stores the reported compliance attributes of an agent
+// as its current values and in its history, adding the agent if not yet known
+func (db *LocalSqliteDb) AddAgentComplianceReport(report types.AgentComplianceReport) error {
+	operation := func() error {
+		return db.addAgentComplianceReportOp(report)
+	}
+	return db.retryOp(operation)
+}
+
+// GetAgentComplianceHistory returns up to limit reported compliance values of an agent, newest first
+// filtered to one attribute if attribute is not empty
+func (db *LocalSqliteDb) GetAgentComplianceHistory(spiffeid string, attribute string, limit int) (types.AgentComplianceHistory, error) {
+	cmd := `SELECT agent_compliance_history.attribute, agent_compliance_history.value, 
+          agent_compliance_history.source, agent_compliance_history.reported_at 
+          FROM agent_compliance_history 
+          JOIN agents ON agent_compliance_history.agent_id = agents.id 
+          WHERE agents.spiffeid=?`
+	vals := []interface{}{spiffeid}
+	if len(attribute) > 0 {
+		cmd += ` AND agent_compliance_history.attribute=?`
+		vals = append(vals, attribute)
+	}
+	cmd += ` ORDER BY agent_compliance_history.id DESC LIMIT ?`
+	vals = append(vals, limit)
+
+	rows, err := db.database.Query(cmd, vals...)
+	if err != nil {
+		return types.AgentComplianceHistory{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	records := []types.AgentComplianceRecord{}
+	for rows.Next() {
+		var record types.AgentComplianceRecord
+		var source sql.NullString
+		if err = rows.Scan(&record.Attribute, &record.Value, &source, &record.ReportedAt); err != nil {
+			return types.AgentComplianceHistory{}, SQLError{cmd, err}
+		}
+		record.Source = source.String
+		records = append(records, record)
+	}
+	return types.AgentComplianceHistory{
+		Spiffeid: spiffeid,
+		Records:  records,
+	}, nil
+}
+
+// OWNERSHIP HANDLERS
+
+// SetEntryOwner assigns an owner team and tenant to an entry without owner
+// returns PostFailure if the entry is owned already; owners are changed by TransferOwnership
+func (db *LocalSqliteDb) SetEntryOwner(owner types.EntryOwner) error {
+	cmd := `INSERT INTO entry_owners (entry_id, owner_team, tenant, updated_at) VALUES (?,?,?,?)`
+	_, err := db.database.Exec(cmd, owner.EntryId, owner.OwnerTeam, owner.Tenant, owner.UpdatedAt)
+	if err != nil {
+		if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrConstraint {
+			return PostFailure{Message: "Entry already has an owner; transfer its ownership"}
+		}
+		return SQLError{cmd, err}
+	}
+	return nil
+}
+
+// GetEntryOwners outputs the ownership metadata of entries owned by team
+// or of all entries with an owner if team is empty
+func (db *LocalSqliteDb) GetEntryOwners(team string) (types.EntryOwnerList, error) {
+	cmd := `SELECT entry_id, owner_team, tenant, updated_at FROM entry_owners`
+	vals := []interface{}{}
+	if len(team) > 0 {
+		cmd += ` WHERE owner_team=?`
+		vals = append(vals, team)
+	}
+	cmd += ` ORDER BY entry_id`
+	rows, err := db.database.Query(cmd, vals...)
+	if err != nil {
+		return types.EntryOwnerList{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	owners := []types.EntryOwner{}
+	for rows.Next() {
+		owner := types.EntryOwner{}
+		if err = rows.Scan(&owner.EntryId, &owner.OwnerTeam, &owner.Tenant, &owner.UpdatedAt); err != nil {
+			return types.EntryOwnerList{}, SQLError{cmd, err}
+		}
+		owners = append(owners, owner)
+	}
+	return types.EntryOwnerList{Entries: owners}, nil
+}
+
+func (db *LocalSqliteDb) transferOwnershipOp(transfer types.OwnershipTransfer) (types.OwnershipTransferResult, error) {
+	// BEGIN transaction
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return types.OwnershipTransferResult{}, errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "transferOwnership")
+
+	// SELECT objects owned by the team
+	all := len(transfer.Clusters) == 0 && len(transfer.Entries) == 0
+	clusters, err := txHelper.getOwnedObjects(`SELECT name, tenant FROM clusters WHERE owner_team=?`,
+		`SELECT owner_team, tenant FROM clusters WHERE name=?`, types.OwnedObjectCluster, transfer.FromTeam, transfer.Clusters, all)
+	if err != nil {
+		return types.OwnershipTransferResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	entries, err := txHelper.getOwnedObjects(`SELECT entry_id, tenant FROM entry_owners WHERE owner_team=?`,
+		`SELECT owner_team, tenant FROM entry_owners WHERE entry_id=?`, types.OwnedObjectEntry, transfer.FromTeam, transfer.Entries, all)
+	if err != nil {
+		return types.OwnershipTransferResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+
+	// UPDATE owners and ADD audit records
+	cmdCluster := `UPDATE clusters SET owner_team=?, tenant=?, updated_at=? WHERE name=?`
+	cmdEntry := `UPDATE entry_owners SET owner_team=?, tenant=?, updated_at=? WHERE entry_id=?`
+	cmdAudit := `INSERT INTO ownership_transfers (object_type, object_id, from_team, to_team, from_tenant, 
+          to_tenant, reason, transferred_by, transferred_at) VALUES (?,?,?,?,?,?,?,?,?)`
+	result := types.OwnershipTransferResult{Transferred: []types.OwnershipTransferRecord{}}
+	for _, object := range append(clusters, entries...) {
+		record := types.OwnershipTransferRecord{
+			ObjectType:    object.objectType,
+			ObjectId:      object.id,
+			FromTeam:      transfer.FromTeam,
+			ToTeam:        transfer.ToTeam,
+			FromTenant:    object.tenant,
+			ToTenant:      object.tenant,
+			Reason:        transfer.Reason,
+			TransferredBy: transfer.TransferredBy,
+			TransferTime:  transfer.TransferTime,
+		}
+		if len(transfer.ToTenant) > 0 {
+			record.ToTenant = transfer.ToTenant
+		}
+		if object.objectType == types.OwnedObjectCluster {
+			_, err = tx.ExecContext(ctx, cmdCluster, record.ToTeam, record.ToTenant, txHelper.now(), record.ObjectId)
+			if err != nil {
+				return types.OwnershipTransferResult{}, backoff.Permanent(txHelper.rollbackHandler(SQLError{cmdCluster, err}))
+			}
+			err = txHelper.recordClusterHistory(record.ObjectId, types.ClusterChangeUpdated)
+			if err != nil {
+				return types.OwnershipTransferResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+			}
+		} else {
+			_, err = tx.ExecContext(ctx, cmdEntry, record.ToTeam, record.ToTenant, record.TransferTime, record.ObjectId)
+			if err != nil {
+				return types.OwnershipTransferResult{}, backoff.Permanent(txHelper.rollbackHandler(SQLError{cmdEntry, err}))
+			}
+		}
+		_, err = tx.ExecContext(ctx, cmdAudit, record.ObjectType, record.ObjectId, record.FromTeam, record.ToTeam,
+			record.FromTenant, record.ToTenant, record.Reason, record.TransferredBy, record.TransferTime)
+		if err != nil {
+			return types.OwnershipTransferResult{}, backoff.Permanent(txHelper.rollbackHandler(SQLError{cmdAudit, err}))
+		}
+		result.Transferred = append(result.Transferred, record)
+	}
+	return result, txHelper.commit()
+}
+
+// TransferOwnership moves the given clusters and entries, or all clusters and
+// entries owned by transfer.FromTeam, to transfer.ToTeam and records the
+// transfer of each object; nothing is moved if any object is not owned by FromTeam
+func (db *LocalSqliteDb) TransferOwnership(transfer types.OwnershipTransfer) (types.OwnershipTransferResult, error) {
+	var result types.OwnershipTransferResult
+	operation := func() error {
+		var err error
+		result, err = db.transferOwnershipOp(transfer)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+// ownershipTransferColumns lists the fields ownership transfer records can be filtered and sorted on
+var ownershipTransferColumns = listColumns{
+	"objectType":    "object_type",
+	"objectId":      "object_id",
+	"fromTeam":      "from_team",
+	"toTeam":        "to_team",
+	"transferredBy": "transferred_by",
+	"timestamp":     "id",
+}
+
+// GetOwnershipTransfers returns a page of the ownership transfer records
+// records are returned most recent first unless opts specifies a sort
+func (db *LocalSqliteDb) GetOwnershipTransfers(opts types.ListOptions) (types.List[types.OwnershipTransferRecord], error) {
+	offset, limit, err := opts.PageBounds()
+	if err != nil {
+		return types.List[types.OwnershipTransferRecord]{}, err
+	}
+	where, order, args, err := listClauses(opts, ownershipTransferColumns, "id DESC")
+	if err != nil {
+		return types.List[types.OwnershipTransferRecord]{}, err
+	}
+
+	cmdCount := `SELECT COUNT(*) FROM ownership_transfers` + where
+	var total int
+	if err = db.database.QueryRow(cmdCount, args...).Scan(&total); err != nil {
+		return types.List[types.OwnershipTransferRecord]{}, SQLError{cmdCount, err}
+	}
+
+	cmd := `SELECT object_type, object_id, from_team, to_team, from_tenant, to_tenant, reason, 
+          transferred_by, transferred_at FROM ownership_transfers` + where + order + ` LIMIT ? OFFSET ?`
+	rows, err := db.database.Query(cmd, append(args, limit, offset)...)
+	if err != nil {
+		return types.List[types.OwnershipTransferRecord]{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	records := []types.OwnershipTransferRecord{}
+	for rows.Next() {
+		r := types.OwnershipTransferRecord{}
+		if err = rows.Scan(&r.ObjectType, &r.ObjectId, &r.FromTeam, &r.ToTeam, &r.FromTenant, &r.ToTenant,
+			&r.Reason, &r.TransferredBy, &r.TransferTime); err != nil {
+			return types.List[types.OwnershipTransferRecord]{}, SQLError{cmd, err}
+		}
+		records = append(records, r)
+	}
+
+	return types.NewList(records, offset, total), nil
+}
+
+// BUNDLE FRESHNESS HANDLERS
+
+// SetBundleFreshness stores the result of a check of the bundle of a federated
+// trust domain, replacing the previous result
+func (db *LocalSqliteDb) SetBundleFreshness(status types.BundleFreshness) error {
+	cmd := `INSERT INTO bundle_freshness (trust_domain, bundle_endpoint_url, profile, reachable, error, in_sync, 
+          local_sequence, endpoint_sequence, local_expires_at, monitored_since, checked_at, last_reachable_at, 
+          last_in_sync_at, stale, stale_reason) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?) 
+          ON CONFLICT(trust_domain) DO UPDATE SET bundle_endpoint_url=excluded.bundle_endpoint_url, 
+          profile=excluded.profile, reachable=excluded.reachable, error=excluded.error, in_sync=excluded.in_sync, 
+          local_sequence=excluded.local_sequence, endpoint_sequence=excluded.endpoint_sequence, 
+          local_expires_at=excluded.local_expires_at, monitored_since=excluded.monitored_since, 
+          checked_at=excluded.checked_at, last_reachable_at=excluded.last_reachable_at, 
+          last_in_sync_at=excluded.last_in_sync_at, stale=excluded.stale, stale_reason=excluded.stale_reason`
+	_, err := db.database.Exec(cmd, status.TrustDomain, status.BundleEndpointURL, status.Profile, status.Reachable,
+		status.Error, status.InSync, int64(status.LocalSequence), int64(status.EndpointSequence), status.LocalExpiresAt,
+		status.MonitoredSince, status.CheckedAt, status.LastReachableAt, status.LastInSyncAt, status.Stale, status.StaleReason)
+	if err != nil {
+		return SQLError{cmd, err}
+	}
+	return nil
+}
+
+// GetBundleFreshness outputs the last check results of all monitored trust domains
+func (db *LocalSqliteDb) GetBundleFreshness() (types.BundleFreshnessList, error) {
+	cmd := `SELECT trust_domain, bundle_endpoint_url, profile, reachable, error, in_sync, local_sequence, 
+          endpoint_sequence, local_expires_at, monitored_since, checked_at, last_reachable_at, last_in_sync_at, 
+          stale, stale_reason FROM bundle_freshness ORDER BY trust_domain`
+	rows, err := db.database.Query(cmd)
+	if err != nil {
+		return types.BundleFreshnessList{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	statuses := []types.BundleFreshness{}
+	for rows.Next() {
+		s := types.BundleFreshness{}
+		if err = rows.Scan(&s.TrustDomain, &s.BundleEndpointURL, &s.Profile, &s.Reachable, &s.Error, &s.InSync,
+			&s.LocalSequence, &s.EndpointSequence, &s.LocalExpiresAt, &s.MonitoredSince, &s.CheckedAt,
+			&s.LastReachableAt, &s.LastInSyncAt, &s.Stale, &s.StaleReason); err != nil {
+			return types.BundleFreshnessList{}, SQLError{cmd, err}
+		}
+		statuses = append(statuses, s)
+	}
+	return types.BundleFreshnessList{TrustDomains: statuses}, nil
+}
+
+// DeleteBundleFreshness removes the check result of a trust domain that is no longer federated
+func (db *LocalSqliteDb) DeleteBundleFreshness(trustDomain string) error {
+	cmd := `DELETE FROM bundle_freshness WHERE trust_domain=?`
+	if _, err := db.database.Exec(cmd, trustDomain); err != nil {
+		return SQLError{cmd, err}
+	}
+	return nil
+}
+
+// BOOTSTRAP TOKEN HANDLERS
+
+// CreateBootstrapToken stores the record of an issued join token with the hash of the token
+// returns PostFailure if a token with the same ID or hash exists
+func (db *LocalSqliteDb) CreateBootstrapToken(token types.BootstrapToken, tokenHash string) error {
+	cmd := `INSERT INTO bootstrap_tokens (token_id, token_hash, agent_id, description, state, issued_by, issued_at, 
+          expires_at, consumed_at, consumed_by) VALUES (?,?,?,?,?,?,?,?,?,?)`
+	_, err := db.database.Exec(cmd, token.ID, tokenHash, token.AgentID, token.Description, token.State,
+		token.IssuedBy, token.IssuedAt, token.ExpiresAt, token.ConsumedAt, token.ConsumedBy)
+	if err != nil {
+		if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrConstraint {
+			return PostFailure{Message: fmt.Sprintf("Bootstrap token %v already exists", token.ID)}
+		}
+		return SQLError{cmd, err}
+	}
+	return nil
+}
+
+// GetBootstrapTokens outputs the records of issued join tokens in the given state, all if empty,
+// most recently issued first
+func (db *LocalSqliteDb) GetBootstrapTokens(state string) (types.BootstrapTokenList, error) {
+	cmd := `SELECT token_id, agent_id, description, state, issued_by, issued_at, expires_at, consumed_at, 
+          consumed_by FROM bootstrap_tokens WHERE (?='' OR state=?) ORDER BY issued_at DESC, id DESC`
+	rows, err := db.database.Query(cmd, state, state)
+	if err != nil {
+		return types.BootstrapTokenList{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	tokens := []types.BootstrapToken{}
+	for rows.Next() {
+		t := types.BootstrapToken{}
+		if err = rows.Scan(&t.ID, &t.AgentID, &t.Description, &t.State, &t.IssuedBy, &t.IssuedAt, &t.ExpiresAt,
+			&t.ConsumedAt, &t.ConsumedBy); err != nil {
+			return types.BootstrapTokenList{}, SQLError{cmd, err}
+		}
+		tokens = append(tokens, t)
+	}
+	return types.BootstrapTokenList{Tokens: tokens}, nil
+}
+
+// ConsumeBootstrapToken marks the token with the given hash as consumed by the agent
+// returns whether a token with the hash was tracked and not consumed yet
+func (db *LocalSqliteDb) ConsumeBootstrapToken(tokenHash string, agentID string, consumedAt string) (bool, error) {
+	cmd := `UPDATE bootstrap_tokens SET state=?, consumed_at=?, consumed_by=? WHERE token_hash=? AND state!=?`
+	res, err := db.database.Exec(cmd, types.BootstrapTokenConsumed, consumedAt, agentID, tokenHash,
+		types.BootstrapTokenConsumed)
+	if err != nil {
+		return false, SQLError{cmd, err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return false, SQLError{cmd, err}
+	}
+	return numRows > 0, nil
+}
+
+// ExpireBootstrapTokens marks issued tokens expiring at or before now as expired
+// now is an RFC 3339 UTC time; returns the number of tokens expired
+func (db *LocalSqliteDb) ExpireBootstrapTokens(now string) (int64, error) {
+	cmd := `UPDATE bootstrap_tokens SET state=? WHERE state=? AND expires_at<=?`
+	res, err := db.database.Exec(cmd, types.BootstrapTokenExpired, types.BootstrapTokenIssued, now)
+	if err != nil {
+		return 0, SQLError{cmd, err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return 0, SQLError{cmd, err}
+	}
+	return numRows, nil
+}
+
+// ENTRY LIFECYCLE HANDLERS
+
+// SetEntryLifecycle sets the lifecycle state of an entry, replacing its previous state
+// owners are notified again of a removal scheduled anew
+func (db *LocalSqliteDb) SetEntryLifecycle(lifecycle types.EntryLifecycle) error {
+	cmd := `INSERT INTO entry_lifecycles (entry_id, state, remove_at, reason, updated_by, updated_at, notified_at) 
+          VALUES (?,?,?,?,?,?,'') ON CONFLICT (entry_id) DO UPDATE SET state=excluded.state, 
+          remove_at=excluded.remove_at, reason=excluded.reason, updated_by=excluded.updated_by, 
+          updated_at=excluded.updated_at, notified_at=''`
+	_, err := db.database.Exec(cmd, lifecycle.EntryId, lifecycle.State, lifecycle.RemoveAt, lifecycle.Reason,
+		lifecycle.UpdatedBy, lifecycle.UpdatedAt)
+	if err != nil {
+		return SQLError{cmd, err}
+	}
+	return nil
+}
+
+// GetEntryLifecycles outputs the lifecycle states of entries in the given state, all if empty,
+// scheduled removals first by removal time
+func (db *LocalSqliteDb) GetEntryLifecycles(state string) (types.EntryLifecycleList, error) {
+	cmd := `SELECT entry_id, state, remove_at, reason, updated_by, updated_at, notified_at FROM entry_lifecycles 
+          WHERE (?='' OR state=?) ORDER BY remove_at='', remove_at, entry_id`
+	rows, err := db.database.Query(cmd, state, state)
+	if err != nil {
+		return types.EntryLifecycleList{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	entries := []types.EntryLifecycle{}
+	for rows.Next() {
+		l := types.EntryLifecycle{}
+		if err = rows.Scan(&l.EntryId, &l.State, &l.RemoveAt, &l.Reason, &l.UpdatedBy, &l.UpdatedAt,
+			&l.NotifiedAt); err != nil {
+			return types.EntryLifecycleList{}, SQLError{cmd, err}
+		}
+		entries = append(entries, l)
+	}
+	return types.EntryLifecycleList{Entries: entries}, nil
+}
+
+// MarkEntryLifecycleNotified records the time the owners of an entry were told of its removal
+func (db *LocalSqliteDb) MarkEntryLifecycleNotified(entryId string, notifiedAt string) error {
+	cmd := `UPDATE entry_lifecycles SET notified_at=? WHERE entry_id=?`
+	if _, err := db.database.Exec(cmd, notifiedAt, entryId); err != nil {
+		return SQLError{cmd, err}
+	}
+	return nil
+}
+
+// MarkEntryRemoved marks an entry scheduled for removal as removed
+// returns whether the entry was still scheduled for removal
+func (db *LocalSqliteDb) MarkEntryRemoved(entryId string, removedAt string) (bool, error) {
+	cmd := `UPDATE entry_lifecycles SET state=?, updated_by='', updated_at=? WHERE entry_id=? AND state=?`
+	res, err := db.database.Exec(cmd, types.EntryLifecycleRemoved, removedAt, entryId,
+		types.EntryLifecycleScheduledForRemoval)
+	if err != nil {
+		return false, SQLError{cmd, err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return false, SQLError{cmd, err}
+	}
+	return numRows > 0, nil
+}
+
+// NOTE HANDLERS
+
+// CreateNote stores a note with its first revision, returning its ID
+func (db *LocalSqliteDb) CreateNote(note types.Note) (int64, error) {
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "createNote")
+
+	cmd := `INSERT INTO notes (object_type, object_id, body, author, created_at, updated_by, updated_at) 
+          VALUES (?,?,?,?,?,'','')`
+	res, err := tx.ExecContext(ctx, cmd, note.ObjectType, note.ObjectId, note.Body, note.Author, note.CreatedAt)
+	if err != nil {
+		return 0, txHelper.rollbackHandler(SQLError{cmd, err})
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, txHelper.rollbackHandler(SQLError{cmd, err})
+	}
+	if err = txHelper.addNoteRevision(id, note.Body, note.Author, note.CreatedAt); err != nil {
+		return 0, txHelper.rollbackHandler(err)
+	}
+	return id, txHelper.commit()
+}
+
+// EditNote replaces the text of a note, keeping the previous versions in its history
+func (db *LocalSqliteDb) EditNote(id int64, body string, editedBy string, editedAt string) error {
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "editNote")
+
+	cmd := `UPDATE notes SET body=?, updated_by=?, updated_at=? WHERE id=?`
+	res, err := tx.ExecContext(ctx, cmd, body, editedBy, editedAt, id)
+	if err != nil {
+		return txHelper.rollbackHandler(SQLError{cmd, err})
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return txHelper.rollbackHandler(SQLError{cmd, err})
+	}
+	if numRows != 1 {
+		return txHelper.rollbackHandler(PostFailure{Message: fmt.Sprintf("Note %v does not exist", id)})
+	}
+	if err = txHelper.addNoteRevision(id, body, editedBy, editedAt); err != nil {
+		return txHelper.rollbackHandler(err)
+	}
+	return txHelper.commit()
+}
+
+// DeleteNote deletes a note and its history
+func (db *LocalSqliteDb) DeleteNote(id int64) error {
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "deleteNote")
+
+	cmd := `DELETE FROM notes WHERE id=?`
+	res, err := tx.ExecContext(ctx, cmd, id)
+	if err != nil {
+		return txHelper.rollbackHandler(SQLError{cmd, err})
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return txHelper.rollbackHandler(SQLError{cmd, err})
+	}
+	if numRows != 1 {
+		return txHelper.rollbackHandler(PostFailure{Message: fmt.Sprintf("Note %v does not exist", id)})
+	}
+	cmd = `DELETE FROM note_revisions WHERE note_id=?`
+	if _, err = tx.ExecContext(ctx, cmd, id); err != nil {
+		return txHelper.rollbackHandler(SQLError{cmd, err})
+	}
+	return txHelper.commit()
+}
+
+// GetNotes outputs the notes of the objects of the given type, oldest first
+// objectType selects all notes if empty, and objectIds all objects of the type if empty
+func (db *LocalSqliteDb) GetNotes(objectType string, objectIds []string) (types.NoteList, error) {
+	cmd := `SELECT id, object_type, object_id, body, author, created_at, updated_by, updated_at FROM notes 
+          WHERE (?='' OR object_type=?)`
+	vals := []interface{}{objectType, objectType}
+	if len(objectIds) > 0 {
+		cmd += ` AND object_id IN (?` + strings.Repeat(",?", len(objectIds)-1) + `)`
+		for _, id := range objectIds {
+			vals = append(vals, id)
+		}
+	}
+	cmd += ` ORDER BY id`
+	rows, err := db.database.Query(cmd, vals...)
+	if err != nil {
+		return types.NoteList{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	notes := []types.Note{}
+	for rows.Next() {
+		n := types.Note{}
+		if err = rows.Scan(&n.ID, &n.ObjectType, &n.ObjectId, &n.Body, &n.Author, &n.CreatedAt,
+			&n.UpdatedBy, &n.UpdatedAt); err != nil {
+			return types.NoteList{}, SQLError{cmd, err}
+		}
+		notes = append(notes, n)
+	}
+	return types.NoteList{Notes: notes}, nil
+}
+
+// GetNoteRevisions outputs the versions of the text of a note, oldest first
+func (db *LocalSqliteDb) GetNoteRevisions(id int64) (types.NoteRevisionList, error) {
+	cmd := `SELECT note_id, body, edited_by, edited_at FROM note_revisions WHERE note_id=? ORDER BY id`
+	rows, err := db.database.Query(cmd, id)
+	if err != nil {
+		return types.NoteRevisionList{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	revisions := []types.NoteRevision{}
+	for rows.Next() {
+		r := types.NoteRevision{}
+		if err = rows.Scan(&r.NoteID, &r.Body, &r.EditedBy, &r.EditedAt); err != nil {
+			return types.NoteRevisionList{}, SQLError{cmd, err}
+		}
+		revisions = append(revisions, r)
+	}
+	if len(revisions) == 0 {
+		return types.NoteRevisionList{}, GetError{fmt.Sprintf("Note %v does not exist", id)}
+	}
+	return types.NoteRevisionList{Revisions: revisions}, nil
+}
+
+// AGENT ANNOTATION HANDLERS
+
+// SetAgentAnnotation sets the value of an annotation of an agent, storing the agent if unknown
+func (db *LocalSqliteDb) SetAgentAnnotation(annotation types.AgentAnnotation) error {
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "setAgentAnnotation")
+
+	now := FormatTimestamp(db.clock.Now())
+	cmd := `INSERT INTO agents (spiffeid, created_at, updated_at) VALUES (?, ?, ?) ON CONFLICT(spiffeid) DO NOTHING`
+	if _, err = tx.ExecContext(ctx, cmd, annotation.Spiffeid, now, now); err != nil {
+		return txHelper.rollbackHandler(SQLError{cmd, err})
+	}
+	cmd = `INSERT INTO agent_annotations (agent_id, annotation, value, updated_by, updated_at) 
+          SELECT id, ?, ?, ?, ? FROM agents WHERE spiffeid=? 
+          ON CONFLICT(agent_id, annotation) DO UPDATE SET value=excluded.value, 
+          updated_by=excluded.updated_by, updated_at=excluded.updated_at`
+	if _, err = tx.ExecContext(ctx, cmd, annotation.Key, annotation.Value, annotation.UpdatedBy, annotation.UpdatedAt,
+		annotation.Spiffeid); err != nil {
+		return txHelper.rollbackHandler(SQLError{cmd, err})
+	}
+	return txHelper.commit()
+}
+
+// DeleteAgentAnnotation deletes an annotation of an agent
+func (db *LocalSqliteDb) DeleteAgentAnnotation(spiffeid string, key string) error {
+	cmd := `DELETE FROM agent_annotations WHERE annotation=? AND agent_id=(SELECT id FROM agents WHERE spiffeid=?)`
+	res, err := db.database.Exec(cmd, key, spiffeid)
+	if err != nil {
+		return SQLError{cmd, err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return SQLError{cmd, err}
+	}
+	if numRows != 1 {
+		return PostFailure{Message: fmt.Sprintf("Annotation %s of agent %s does not exist", key, spiffeid)}
+	}
+	return nil
+}
+
+// GetAgentAnnotations outputs the annotations of the given agents, all agents if empty,
+// by SPIFFE ID and key
+func (db *LocalSqliteDb) GetAgentAnnotations(spiffeids []string) (types.AgentAnnotationList, error) {
+	cmd := `SELECT agents.spiffeid, agent_annotations.annotation, agent_annotations.value, 
+          agent_annotations.updated_by, agent_annotations.updated_at FROM agent_annotations 
+          JOIN agents ON agent_annotations.agent_id = agents.id`
+	vals := []interface{}{}
+	if len(spiffeids) > 0 {
+		cmd += ` WHERE agents.spiffeid IN (?` + strings.Repeat(",?", len(spiffeids)-1) + `)`
+		for _, id := range spiffeids {
+			vals = append(vals, id)
+		}
+	}
+	cmd += ` ORDER BY agents.spiffeid, agent_annotations.annotation`
+	rows, err := db.database.Query(cmd, vals...)
+	if err != nil {
+		return types.AgentAnnotationList{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	annotations := []types.AgentAnnotation{}
+	for rows.Next() {
+		a := types.AgentAnnotation{}
+		if err = rows.Scan(&a.Spiffeid, &a.Key, &a.Value, &a.UpdatedBy, &a.UpdatedAt); err != nil {
+			return types.AgentAnnotationList{}, SQLError{cmd, err}
+		}
+		annotations = append(annotations, a)
+	}
+	return types.AgentAnnotationList{Annotations: annotations}, nil
+}
+
+// FAILED OPERATION HANDLERS
+
+const selectFailedOperations = `SELECT id, operation, step, payload, state, attempts, last_error, created_by, 
+          created_at, updated_at, resolved_by, resolution_note FROM failed_operations`
+
+// AddFailedOperation stores a partially failed operation, returning its ID
+func (db *LocalSqliteDb) AddFailedOperation(op types.FailedOperation) (int64, error) {
+	cmd := `INSERT INTO failed_operations (operation, step, payload, state, attempts, last_error, created_by, 
+          created_at, updated_at, resolved_by, resolution_note) VALUES (?,?,?,?,?,?,?,?,?,?,?)`
+	res, err := db.database.Exec(cmd, op.Operation, op.Step, op.Payload, op.State, op.Attempts, op.LastError,
+		op.CreatedBy, op.CreatedAt, op.UpdatedAt, op.ResolvedBy, op.ResolutionNote)
+	if err != nil {
+		return 0, SQLError{cmd, err}
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, SQLError{cmd, err}
+	}
+	return id, nil
+}
+
+// GetFailedOperation outputs the failed operation with the given ID
+// returns GetError if there is none
+func (db *LocalSqliteDb) GetFailedOperation(id int64) (types.FailedOperation, error) {
+	cmd := selectFailedOperations + ` WHERE id=?`
+	row := db.database.QueryRow(cmd, id)
+	op, err := scanFailedOperation(row)
+	if err == sql.ErrNoRows {
+		return types.FailedOperation{}, GetError{fmt.Sprintf("Failed operation %v not found", id)}
+	} else if err != nil {
+		return types.FailedOperation{}, SQLError{cmd, err}
+	}
+	return op, nil
+}
+
+// GetFailedOperations outputs the failed operations in the given state, all if empty,
+// oldest first
+func (db *LocalSqliteDb) GetFailedOperations(state string) (types.FailedOperationList, error) {
+	cmd := selectFailedOperations + ` WHERE (?='' OR state=?) ORDER BY id`
+	rows, err := db.database.Query(cmd, state, state)
+	if err != nil {
+		return types.FailedOperationList{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	ops := []types.FailedOperation{}
+	for rows.Next() {
+		op, err := scanFailedOperation(rows)
+		if err != nil {
+			return types.FailedOperationList{}, SQLError{cmd, err}
+		}
+		ops = append(ops, op)
+	}
+	return types.FailedOperationList{Operations: ops}, nil
+}
+
+// UpdateFailedOperation stores the state, attempts, error and resolution of op
+// returns GetError if there is no failed operation with its ID
+func (db *LocalSqliteDb) UpdateFailedOperation(op types.FailedOperation) error {
+	cmd := `UPDATE failed_operations SET state=?, attempts=?, last_error=?, updated_at=?, resolved_by=?, 
+          resolution_note=? WHERE id=?`
+	res, err := db.database.Exec(cmd, op.State, op.Attempts, op.LastError, op.UpdatedAt, op.ResolvedBy,
+		op.ResolutionNote, op.ID)
+	if err != nil {
+		return SQLError{cmd, err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return SQLError{cmd, err}
+	}
+	if numRows == 0 {
+		return GetError{fmt.Sprintf("Failed operation %v not found", op.ID)}
+	}
+	return nil
+}
+
+func scanFailedOperation(row interface{ Scan(...interface{}) error }) (types.FailedOperation, error) {
+	op := types.FailedOperation{}
+	err := row.Scan(&op.ID, &op.Operation, &op.Step, &op.Payload, &op.State, &op.Attempts, &op.LastError,
+		&op.CreatedBy, &op.CreatedAt, &op.UpdatedAt, &op.ResolvedBy, &op.ResolutionNote)
+	return op, err
+}
+
+// AGENT ASSIGNMENT HANDLERS
+
+func (db *LocalSqliteDb) assignAgentsToClustersOp(assignments []types.AgentAssignment, dryRun bool, progress func(applied int, total int)) (types.AgentAssignmentResult, error) {
+	// BEGIN transaction
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return types.AgentAssignmentResult{}, errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "assignAgentsToClusters")
+
+	// SELECT clusters by UID and current clusters of agents
+	clusterNames, err := txHelper.getStringPairs(`SELECT uid, name FROM clusters`)
+	if err != nil {
+		return types.AgentAssignmentResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	agentClusters, err := txHelper.getStringPairs(`SELECT agents.spiffeid, clusters.name 
+          FROM cluster_memberships 
+          JOIN agents ON cluster_memberships.agent_id=agents.id 
+          JOIN clusters ON cluster_memberships.cluster_id=clusters.id`)
+	if err != nil {
+		return types.AgentAssignmentResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+
+	// SELECT the agents not yet in their cluster, a later row of an agent overrides an earlier one
+	result := types.AgentAssignmentResult{DryRun: dryRun, Rows: len(assignments), Errors: []types.AgentAssignmentError{}}
+	changed := make(map[string]bool)
+	targets := make(map[string]string)
+	order := []string{}
+	for _, a := range assignments {
+		name, ok := clusterNames[a.ClusterUID]
+		if !ok {
+			result.Errors = append(result.Errors, types.AgentAssignmentError{
+				Row: a.Row, Spiffeid: a.Spiffeid, ClusterUID: a.ClusterUID, Code: types.BatchCodeNotFound,
+				Error: "cluster does not exist",
+			})
+			continue
+		}
+		current, assigned := agentClusters[a.Spiffeid]
+		if assigned && current == name {
+			result.Unchanged++
+			continue
+		}
+		result.Assigned++
+		if _, listed := targets[a.Spiffeid]; !listed {
+			order = append(order, a.Spiffeid)
+		}
+		targets[a.Spiffeid] = name
+		changed[name] = true
+		if assigned {
+			changed[current] = true
+		}
+	}
+	if dryRun {
+		return result, tx.Rollback()
+	}
+
+	// UPDATE memberships in chunks, grouped by cluster
+	byCluster := make(map[string][]string)
+	targetNames := []string{}
+	for _, spiffeid := range order {
+		name := targets[spiffeid]
+		if _, ok := byCluster[name]; !ok {
+			targetNames = append(targetNames, name)
+		}
+		byCluster[name] = append(byCluster[name], spiffeid)
+	}
+	applied := 0
+	for _, name := range targetNames {
+		agents := byCluster[name]
+		report := func(written int) {
+			if progress != nil {
+				progress(applied+written, len(order))
+			}
+		}
+		if err = txHelper.insertAgentMemberships(name, agents, true, report); err != nil {
+			return types.AgentAssignmentResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+		}
+		applied += len(agents)
+	}
+
+	// ADD the clusters that gained or lost agents to history
+	names := make([]string, 0, len(changed))
+	for name := range changed {
+		names = append(names, name)
+	}
+	db.collation.Strings(names)
+	for _, name := range names {
+		err = txHelper.recordClusterHistory(name, types.ClusterChangeUpdated)
+		if err != nil {
+			return types.AgentAssignmentResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+		}
+	}
+	return result, txHelper.commit()
+}
+
+// AssignAgentsToClusters assigns each agent to the cluster with the given UID in one transaction,
+// moving agents assigned to another cluster
+// rows naming an unknown cluster are reported in the result and not applied
+// with dryRun set, the result is returned without applying the assignments
+func (db *LocalSqliteDb) AssignAgentsToClusters(assignments []types.AgentAssignment, dryRun bool) (types.AgentAssignmentResult, error) {
+	return db.AssignAgentsToClustersWithProgress(assignments, dryRun, nil)
+}
+
+// AssignAgentsToClustersWithProgress is AssignAgentsToClusters, calling progress after each chunk of
+// agents written with the number of agents applied and to apply
+// the count starts over if the transaction is retried
+func (db *LocalSqliteDb) AssignAgentsToClustersWithProgress(assignments []types.AgentAssignment, dryRun bool, progress func(applied int, total int)) (types.AgentAssignmentResult, error) {
+	var result types.AgentAssignmentResult
+	operation := func() error {
+		var err error
+		result, err = db.assignAgentsToClustersOp(assignments, dryRun, progress)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+func (db *LocalSqliteDb) moveAgentsBetweenClustersOp(fromUID string, toUID string, spiffeids []string) (types.AgentMoveResult, error) {
+	// BEGIN transaction
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return types.AgentMoveResult{}, errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "moveAgentsBetweenClusters")
+
+	// UPDATE memberships of agents from one cluster to the other
+	result, err := MoveAgents(txHelper.moveAgentsTx(), fromUID, toUID, spiffeids)
+	if err != nil {
+		return types.AgentMoveResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	return result, txHelper.commit()
+}
+
+// MoveAgentsBetweenClusters moves the agents of spiffeids from the cluster
+// with UID fromUID to the cluster with UID toUID in one transaction, so the
+// agents are never in neither or both clusters
+// returns PostFailure, and moves no agent, if a cluster does not exist or an
+// agent is not in the cluster with UID fromUID
+func (db *LocalSqliteDb) MoveAgentsBetweenClusters(fromUID string, toUID string, spiffeids []string) (types.AgentMoveResult, error) {
+	var result types.AgentMoveResult
+	operation := func() error {
+		var err error
+		result, err = db.moveAgentsBetweenClustersOp(fromUID, toUID, spiffeids)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+// LABEL HANDLERS
+
+func (db *LocalSqliteDb) applyLabelOperationOp(op types.LabelOperation) (types.LabelOperationResult, error) {
+	// BEGIN transaction
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return types.LabelOperationResult{}, errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "applyLabelOperation")
+
+	// SELECT all objects of the target with their labels
+	cmd := `SELECT clusters.name, cluster_labels.label, cluster_labels.value 
+          FROM clusters 
+          LEFT JOIN cluster_labels ON cluster_labels.cluster_id=clusters.id`
+	setLabels := txHelper.setClusterLabels
+	if op.Target == types.LabelTargetAgents {
+		cmd = `SELECT agents.spiffeid, agent_labels.label, agent_labels.value 
+          FROM agents 
+          LEFT JOIN agent_labels ON agent_labels.agent_id=agents.id`
+		setLabels = txHelper.setAgentLabels
+	}
+	names, labels, err := txHelper.getObjectLabels(cmd)
+	if err != nil {
+		return types.LabelOperationResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	db.collation.Strings(names)
+
+	// UPDATE labels of matching objects
+	result := types.LabelOperationResult{DryRun: op.DryRun, Changes: []types.LabelChange{}}
+	for _, name := range names {
+		if !op.Filter.Matches(name, labels[name]) {
+			continue
+		}
+		result.Matched++
+		after, changed := op.Apply(labels[name])
+		if !changed {
+			continue
+		}
+		result.Changes = append(result.Changes, types.LabelChange{Name: name, Before: labels[name], After: after})
+		if op.DryRun {
+			continue
+		}
+		err = setLabels(name, after)
+		if err != nil {
+			return types.LabelOperationResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+		}
+		if op.Target == types.LabelTargetClusters {
+			err = txHelper.recordClusterHistory(name, types.ClusterChangeUpdated)
+			if err != nil {
+				return types.LabelOperationResult{}, backoff.Permanent(txHelper.rollbackHandler(err))
+			}
+		}
+	}
+
+	if op.DryRun {
+		return result, tx.Rollback()
+	}
+	return result, txHelper.commit()
+}
+
+// ApplyLabelOperation adds, removes or renames a label on all clusters or
+// agents matching the filter of op in one transaction
+// with op.DryRun set, the changes are returned without being applied
+func (db *LocalSqliteDb) ApplyLabelOperation(op types.LabelOperation) (types.LabelOperationResult, error) {
+	var result types.LabelOperationResult
+	operation := func() error {
+		var err error
+		result, err = db.applyLabelOperationOp(op)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+// AUDIT HANDLERS
+
+// WithActor returns the DB recording actor as the user making the changes
+// made through it in the audit log; the DB itself records no actor
+func (db *LocalSqliteDb) WithActor(actor string) AgentDB {
+	view := *db
+	view.actor = actor
+	return &view
+}
+
+// GetAuditEvents outputs a page of the changes of clusters, agents and
+// memberships, most recent first, filtered on actor, action, objectType,
+// objectId and clusterUid
+func (db *LocalSqliteDb) GetAuditEvents(opts types.ListOptions) (types.List[types.AuditEvent], error) {
+	if len(opts.Sort) > 0 {
+		return types.List[types.AuditEvent]{}, errors.New("audit events are sorted by time only")
+	}
+	offset, limit, err := opts.PageBounds()
+	if err != nil {
+		return types.List[types.AuditEvent]{}, err
+	}
+	where, order, args, err := listClauses(opts, AuditEventColumns, "id DESC")
+	if err != nil {
+		return types.List[types.AuditEvent]{}, err
+	}
+
+	cmdCount := `SELECT COUNT(*) FROM audit_events` + where
+	var total int
+	if err = db.database.QueryRow(cmdCount, args...).Scan(&total); err != nil {
+		return types.List[types.AuditEvent]{}, SQLError{cmdCount, err}
+	}
+
+	cmd := `SELECT id, actor, action, object_type, object_id, cluster_uid, before_state, after_state, recorded_at 
+          FROM audit_events` + where + order + ` LIMIT ? OFFSET ?`
+	rows, err := db.database.Query(cmd, append(args, limit, offset)...)
+	if err != nil {
+		return types.List[types.AuditEvent]{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	events := []types.AuditEvent{}
+	for rows.Next() {
+		var e types.AuditEvent
+		var actor, clusterUID, before, after sql.NullString
+		if err = rows.Scan(&e.ID, &actor, &e.Action, &e.ObjectType, &e.ObjectId, &clusterUID, &before, &after, &e.Timestamp); err != nil {
+			return types.List[types.AuditEvent]{}, SQLError{cmd, err}
+		}
+		e.Actor, e.ClusterUID = actor.String, clusterUID.String
+		e.Before, e.After = AuditState(before.String), AuditState(after.String)
+		events = append(events, e)
+	}
+	if err = rows.Err(); err != nil {
+		return types.List[types.AuditEvent]{}, SQLError{cmd, err}
+	}
+	return types.NewList(events, offset, total), nil
+}
+
+// TRANSACTION METRICS HANDLERS
+
+// GetTxStats returns the commits and rollbacks by cause of each DB operation
+// since the DB was opened
+func (db *LocalSqliteDb) GetTxStats() types.TxStats {
+	return db.txMetrics.stats()
+}
+
+// HEALTH HANDLERS
+
+// Ping checks the database file can be queried
+func (db *LocalSqliteDb) Ping(ctx context.Context) error {
+	var one int
+	if err := db.database.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return SQLError{"SELECT 1", err}
+	}
+	return nil
+}
+
+// INTEGRITY HANDLERS
+
+// integrity references of the tables only the sqlite DB has
+var sqliteIntegrityReferences = []IntegrityReference{
+	{Table: "agent_compliance", Column: "agent_id", Parent: "agents"},
+	{Table: "agent_compliance_history", Column: "agent_id", Parent: "agents"},
+}
+
+// CheckIntegrity runs the integrity checks, storing the report in place of the previous one
+// the sqlite DB does not enforce foreign keys, so rows written by earlier
+// releases may reference deleted rows
+func (db *LocalSqliteDb) CheckIntegrity(ctx context.Context) (types.IntegrityReport, error) {
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return types.IntegrityReport{}, errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "checkIntegrity")
+
+	references := append(append([]IntegrityReference{}, IntegrityReferences...), sqliteIntegrityReferences...)
+	report, err := CheckIntegrity(ctx, tx, references, `SELECT cluster_id FROM cluster_deletions`, txHelper.now())
+	if err != nil {
+		return types.IntegrityReport{}, txHelper.rollbackHandler(err)
+	}
+	if err = StoreIntegrityReport(ctx, tx, sqlitePlaceholder, report); err != nil {
+		return types.IntegrityReport{}, txHelper.rollbackHandler(err)
+	}
+	return report, txHelper.commit()
+}
+
+// GetIntegrityReport returns the report of the last integrity check, empty if none ran
+func (db *LocalSqliteDb) GetIntegrityReport() (types.IntegrityReport, error) {
+	return LoadIntegrityReport(context.Background(), db.database)
+}
+
+// BACKUP HANDLERS
+
+// Backup writes a consistent copy of the DB to a new file at path
+// returns an error if the file exists
+func (db *LocalSqliteDb) Backup(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return errors.Errorf("backup file %s already exists", path)
+	}
+	cmd := `VACUUM INTO ?`
+	_, err := db.database.Exec(cmd, path)
+	if err != nil {
+		return SQLError{cmd, err}
+	}
+	return nil
+}
+
+// SNAPSHOT HANDLERS
+
+// tables not restored from snapshots: the list of snapshots itself, the log of SPIRE API calls,
+// the version of the schema and the secrets, so API keys hashed with the pepper stay valid
+// service accounts, cluster tokens and bootstrap tokens are kept too, so keys deleted or
+// tokens consumed after a snapshot are not valid again once it is restored
+// the audit log and the history of clusters only grow; the restore is recorded in them
+var snapshotExcludedTables = map[string]bool{"snapshots": true, "spire_query_log": true, "schema_version": true, "secrets": true,
+	"service_accounts": true, "cluster_tokens": true, "bootstrap_tokens": true, "audit_events": true, "cluster_history": true}
+
+// snapshotPath returns the path of the file of a snapshot
+func (db *LocalSqliteDb) snapshotPath(name string) string {
+	return filepath.Join(db.snapshotDir, name+".sqlite3")
+}
+
+// CreateSnapshot writes a backup of the DB to the snapshot directory under the given name
+// returns PostFailure if the name is invalid or taken
+func (db *LocalSqliteDb) CreateSnapshot(name string, createdBy string) (types.Snapshot, error) {
+	if err := types.ValidateSnapshotName(name); err != nil {
+		return types.Snapshot{}, PostFailure{Message: err.Error()}
+	}
+	cmdExists := `SELECT COUNT(*) FROM snapshots WHERE name=?`
+	var count int
+	if err := db.database.QueryRow(cmdExists, name).Scan(&count); err != nil {
+		return types.Snapshot{}, SQLError{cmdExists, err}
+	}
+	if count > 0 {
+		return types.Snapshot{}, PostFailure{Message: fmt.Sprintf("Snapshot %v already exists", name)}
+	}
+
+	if err := os.MkdirAll(db.snapshotDir, 0700); err != nil {
+		return types.Snapshot{}, errors.Errorf("could not create snapshot directory: %v", err)
+	}
+	path := db.snapshotPath(name)
+	if err := db.Backup(path); err != nil {
+		return types.Snapshot{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return types.Snapshot{}, errors.Errorf("could not read snapshot: %v", err)
+	}
+	snapshot := types.Snapshot{
+		Name:         name,
+		CreatedBy:    createdBy,
+		CreationTime: db.clock.Now().UTC().Format(time.RFC3339),
+		Bytes:        info.Size(),
+	}
+	cmd := `INSERT INTO snapshots (name, created_by, created_at, bytes) VALUES (?, ?, ?, ?)`
+	if _, err = db.database.Exec(cmd, snapshot.Name, snapshot.CreatedBy, snapshot.CreationTime, snapshot.Bytes); err != nil {
+		os.Remove(path)
+		if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrConstraint {
+			return types.Snapshot{}, PostFailure{Message: fmt.Sprintf("Snapshot %v already exists", name)}
+		}
+		return types.Snapshot{}, SQLError{cmd, err}
+	}
+	return snapshot, nil
+}
+
+// GetSnapshots returns the named snapshots, most recent first
+func (db *LocalSqliteDb) GetSnapshots() (types.SnapshotList, error) {
+	cmd := `SELECT name, created_by, created_at, bytes FROM snapshots ORDER BY id DESC`
+	rows, err := db.database.Query(cmd)
+	if err != nil {
+		return types.SnapshotList{}, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	snapshots := []types.Snapshot{}
+	for rows.Next() {
+		var snapshot types.Snapshot
+		if err = rows.Scan(&snapshot.Name, &snapshot.CreatedBy, &snapshot.CreationTime, &snapshot.Bytes); err != nil {
+			return types.SnapshotList{}, SQLError{cmd, err}
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return types.SnapshotList{Snapshots: snapshots}, nil
+}
+
+// DeleteSnapshot removes a snapshot and its file
+// returns PostFailure if the snapshot does not exist
+func (db *LocalSqliteDb) DeleteSnapshot(name string) error {
+	cmd := `DELETE FROM snapshots WHERE name=?`
+	res, err := db.database.Exec(cmd, name)
+	if err != nil {
+		return SQLError{cmd, err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return SQLError{cmd, err}
+	}
+	if numRows != 1 {
+		return PostFailure{Message: fmt.Sprintf("Snapshot %v does not exist", name)}
+	}
+	if err := os.Remove(db.snapshotPath(name)); err != nil && !os.IsNotExist(err) {
+		return errors.Errorf("could not remove snapshot file: %v", err)
+	}
+	return nil
+}
+
+// RestoreSnapshot replaces the data of the DB with the data of a snapshot in one transaction,
+// except for snapshotExcludedTables, and records the restore in the audit log
+// returns PostFailure if the snapshot does not exist
+func (db *LocalSqliteDb) RestoreSnapshot(name string) error {
+	cmdExists := `SELECT COUNT(*) FROM snapshots WHERE name=?`
+	var count int
+	if err := db.database.QueryRow(cmdExists, name).Scan(&count); err != nil {
+		return SQLError{cmdExists, err}
+	}
+	path := db.snapshotPath(name)
+	if count == 0 {
+		return PostFailure{Message: fmt.Sprintf("Snapshot %v does not exist", name)}
+	}
+	if _, err := os.Stat(path); err != nil {
+		return PostFailure{Message: fmt.Sprintf("Snapshot %v cannot be read: %v", name, err)}
+	}
+	operation := func() error {
+		return db.restoreSnapshotOp(name, path)
+	}
+	return db.retryOp(operation)
+}
+
+func (db *LocalSqliteDb) restoreSnapshotOp(name string, path string) error {
+	// ATTACH the snapshot to a dedicated connection, outside of the transaction
+	ctx := context.Background()
+	conn, err := db.database.Conn(ctx)
+	if err != nil {
+		return errors.Errorf("Error initializing context: %v", err)
+	}
+	defer conn.Close()
+	cmdAttach := `ATTACH DATABASE ? AS snapshot`
+	if _, err = conn.ExecContext(ctx, cmdAttach, path); err != nil {
+		return backoff.Permanent(SQLError{cmdAttach, err})
+	}
+	defer func() {
+		cmdDetach := `DETACH DATABASE snapshot`
+		if _, err := conn.ExecContext(ctx, cmdDetach); err != nil {
+			log.Printf("WARNING: could not detach snapshot %s: %v", path, err)
+		}
+	}()
+
+	// BEGIN transaction
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "restoreSnapshot")
+
+	tables, err := txHelper.getTableNames("main")
+	if err != nil {
+		return backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	snapshotTables, err := txHelper.getTableNames("snapshot")
+	if err != nil {
+		return backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	inSnapshot := make(map[string]bool, len(snapshotTables))
+	for _, table := range snapshotTables {
+		inSnapshot[table] = true
+	}
+
+	// REPLACE rows of each table; tables missing from older snapshots are emptied
+	// the full-text index of clusters is updated from the changes recorded by the triggers on clusters
+	for _, table := range tables {
+		if snapshotExcludedTables[table] || isClusterSearchTable(table) {
+			continue
+		}
+		cmdDelete := fmt.Sprintf(`DELETE FROM main.%s`, table)
+		if _, err = tx.ExecContext(ctx, cmdDelete); err != nil {
+			return backoff.Permanent(txHelper.rollbackHandler(SQLError{cmdDelete, err}))
+		}
+		if !inSnapshot[table] {
+			continue
+		}
+		columns, err := txHelper.getSharedColumns(table)
+		if err != nil {
+			return backoff.Permanent(txHelper.rollbackHandler(err))
+		}
+		columnList := strings.Join(columns, ", ")
+		cmdInsert := fmt.Sprintf(`INSERT INTO main.%s (%s) SELECT %s FROM snapshot.%s`, table, columnList, columnList, table)
+		if _, err = tx.ExecContext(ctx, cmdInsert); err != nil {
+			return backoff.Permanent(txHelper.rollbackHandler(SQLError{cmdInsert, err}))
+		}
+	}
+
+	// ADD restore and the changes of clusters to the audit log and the history of clusters
+	err = txHelper.recordAuditEvents([]types.AuditEvent{{Action: types.AuditActionRestore, ObjectType: types.AuditObjectSnapshot, ObjectId: name}})
+	if err != nil {
+		return backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+	if err = txHelper.recordRestoredClusters(); err != nil {
+		return backoff.Permanent(txHelper.rollbackHandler(err))
+	}
+
+	return txHelper.commit()
+}
+
+// SECRET HANDLERS
+
+// GetOrCreateSecret stores value under name unless a value is stored under
+// it, and returns the stored value, so replicas sharing the DB agree on it
+func (db *LocalSqliteDb) GetOrCreateSecret(name string, value []byte) ([]byte, error) {
+	cmdInsert := `INSERT INTO secrets (name, value, updated_at) VALUES (?, ?, ?) ON CONFLICT(name) DO NOTHING`
+	if _, err := db.database.Exec(cmdInsert, name, value, FormatTimestamp(db.clock.Now())); err != nil {
+		return nil, SQLError{cmdInsert, err}
+	}
+	cmd := `SELECT value FROM secrets WHERE name=?`
+	var stored []byte
+	if err := db.database.QueryRow(cmd, name).Scan(&stored); err != nil {
+		return nil, SQLError{cmd, err}
+	}
+	return stored, nil
+}
+
+// UpdateSecret replaces the value stored under name
+// returns PostFailure if no value is stored under name
+func (db *LocalSqliteDb) UpdateSecret(name string, value []byte) error {
+	cmd := `UPDATE secrets SET value=?, updated_at=? WHERE name=?`
+	res, err := db.database.Exec(cmd, value, FormatTimestamp(db.clock.Now()), name)
+	if err != nil {
+		return SQLError{cmd, err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return SQLError{cmd, err}
+	}
+	if numRows != 1 {
+		return PostFailure{Message: fmt.Sprintf("Secret %v does not exist", name)}
+	}
+	return nil
+}
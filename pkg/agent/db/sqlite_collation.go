@@ -0,0 +1,55 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+
+	"github.com/spiffe/tornjak/pkg/agent/collation"
+)
+
+// name of the collation of names registered on the connections of the DB, see sqliteDriver
+const sqliteNameCollation = "TORNJAK_NAMES"
+
+var (
+	sqliteDriversMu sync.Mutex
+	// names of the registered sqlite drivers by collation and locale
+	sqliteDrivers = map[string]string{}
+)
+
+// sqliteDriver returns the name of a sqlite3 driver registering c as
+// sqliteNameCollation on its connections, so pages of names are sorted and
+// cut by sqlite under the collation of the DB
+// database/sql cannot unregister drivers, so one is registered per collation
+// and locale for the life of the process
+func sqliteDriver(c *collation.Collation) string {
+	key := c.Name() + "/" + c.Locale()
+	sqliteDriversMu.Lock()
+	defer sqliteDriversMu.Unlock()
+	if name, ok := sqliteDrivers[key]; ok {
+		return name
+	}
+	name := fmt.Sprintf("sqlite3_tornjak_%d", len(sqliteDrivers))
+	sql.Register(name, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterCollation(sqliteNameCollation, c.Compare)
+		},
+	})
+	sqliteDrivers[key] = name
+	return name
+}
+
+// nameOrder sorts a column of names under the collation of the DB
+// the binary collation is the BINARY collation of sqlite, which uses the indexes of names
+func (db *LocalSqliteDb) nameOrder(column string, desc bool) string {
+	term := column
+	if db.collation.Name() != collation.Binary {
+		term += " COLLATE " + sqliteNameCollation
+	}
+	if desc {
+		term += " DESC"
+	}
+	return term
+}
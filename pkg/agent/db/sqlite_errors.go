@@ -2,6 +2,11 @@ package db
 
 import (
 	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
 )
 
 // SQLError is an error where the input appears correct but the database acts up
@@ -14,6 +19,10 @@ func (e SQLError) Error() string {
 	return fmt.Sprintf("Unable to execute SQL query %v: %v", e.Cmd, e.Err.Error())
 }
 
+func (e SQLError) Unwrap() error {
+	return e.Err
+}
+
 // GetError is an error intended to signify something wrong with a get request
 // For example, non-existence
 type GetError struct {
@@ -27,8 +36,58 @@ func (e GetError) Error() string {
 // PostFailure is meant to signify when the state of the database has not changed
 type PostFailure struct {
 	Message string
+	// Category is the kind of conflict with the stored data, one of
+	// types.PostFailure*, counted in the transaction stats; other if empty
+	Category string
 }
 
 func (e PostFailure) Error() string {
 	return e.Message
 }
+
+// UnsupportedError is returned by the methods of a capability the DataStore
+// does not store, see CapabilityChecker
+type UnsupportedError struct {
+	Capability string
+	DataStore  string
+}
+
+func (e UnsupportedError) Error() string {
+	return fmt.Sprintf("%s is not supported by the %s DataStore", e.Capability, e.DataStore)
+}
+
+// ClusterExistsFailure is the PostFailure of a cluster created or renamed to
+// the name of another cluster
+func ClusterExistsFailure(message string) PostFailure {
+	return PostFailure{Message: message, Category: types.PostFailureClusterExists}
+}
+
+// ClusterMissingFailure is the PostFailure of a change of a cluster that does
+// not exist
+func ClusterMissingFailure(message string) PostFailure {
+	return PostFailure{Message: message, Category: types.PostFailureClusterMissing}
+}
+
+// AgentsAssignedFailure is the PostFailure of agents added to a cluster while
+// assigned to one, naming the conflicting agents
+func AgentsAssignedFailure(agents []string) PostFailure {
+	return PostFailure{Message: "Agents already assigned to a cluster: " + strings.Join(agents, ", "), Category: types.PostFailureAgentAlreadyAssigned}
+}
+
+// PostFailureCategory returns the category of the PostFailure of err, and
+// false if err is not a PostFailure
+func PostFailureCategory(err error) (string, bool) {
+	var pf PostFailure
+	if !errors.As(err, &pf) {
+		return "", false
+	}
+	if pf.Category == "" {
+		return types.PostFailureOther, true
+	}
+	return pf.Category, true
+}
+
+// ProtectedClusterFailure is the PostFailure of a delete of a protected cluster
+func ProtectedClusterFailure(name string) PostFailure {
+	return PostFailure{Message: fmt.Sprintf("Cluster %v is protected; clear its protection to delete it", name)}
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// listColumns maps the field names accepted in list filters and sort
+// options to the table columns they select
+type listColumns map[string]string
+
+func (c listColumns) fields() []string {
+	fields := make([]string, 0, len(c))
+	for f := range c {
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// listClauses returns the WHERE and ORDER BY clauses and the query arguments
+// selected by opts. defaultOrder is used when opts does not specify a sort.
+func listClauses(opts types.ListOptions, columns listColumns, defaultOrder string) (string, string, []interface{}, error) {
+	if err := opts.Validate(columns.fields(), columns.fields()); err != nil {
+		return "", "", nil, err
+	}
+
+	where := ""
+	args := []interface{}{}
+	if len(opts.Filters) > 0 {
+		conds := make([]string, 0, len(opts.Filters))
+		for _, f := range opts.Filters {
+			conds = append(conds, columns[f.Field]+" = ?")
+			args = append(args, f.Value)
+		}
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	order := " ORDER BY " + defaultOrder
+	if len(opts.Sort) > 0 {
+		terms := make([]string, 0, len(opts.Sort))
+		for _, s := range opts.Sort {
+			term := columns[s.Field]
+			if s.Desc {
+				term += " DESC"
+			}
+			terms = append(terms, term)
+		}
+		order = " ORDER BY " + strings.Join(terms, ", ")
+	}
+	return where, order, args, nil
+}
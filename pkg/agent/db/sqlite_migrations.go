@@ -0,0 +1,300 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// migrations of the schema of the sqlite DB, in files named
+// <version>_<name>.up.sql and <version>_<name>.down.sql
+//
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrationFiles embed.FS
+
+// version of the schema of the DB, one row per applied migration
+const initSchemaVersionTable = `CREATE TABLE IF NOT EXISTS schema_version
+                            (version INTEGER PRIMARY KEY, name TEXT, applied_at TEXT)`
+
+// migration is one step of the schema
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+	// steps done in Go after the statements of the up and down files, nil if none
+	upFunc, downFunc func(tx *sql.Tx) error
+}
+
+// steps of the sqlite migrations that cannot be written in SQL, by version
+var sqliteMigrationFuncs = map[int]struct{ up, down func(tx *sql.Tx) error }{
+	2: {up: upgradeTimestamps, down: downgradeTimestamps},
+	6: {down: dropClusterSearchIndex},
+}
+
+// loadMigrations returns the migrations of a directory of fsys ordered by version
+// every version from 1 to the latest must have an up and a down file
+func loadMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	files, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.Errorf("could not read migrations: %v", err)
+	}
+	byVersion := make(map[int]*migration)
+	for _, file := range files {
+		base := file.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, errors.Errorf("invalid migration file name %q", base)
+		}
+		stem := strings.TrimSuffix(base, "."+direction+".sql")
+		versionText, name, ok := strings.Cut(stem, "_")
+		version, err := strconv.Atoi(versionText)
+		if !ok || err != nil || version <= 0 {
+			return nil, errors.Errorf("invalid migration file name %q", base)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, base))
+		if err != nil {
+			return nil, errors.Errorf("could not read migration %q: %v", base, err)
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: name}
+			byVersion[version] = m
+		}
+		if m.name != name {
+			return nil, errors.Errorf("migration %d has files of names %q and %q", version, m.name, name)
+		}
+		if direction == "up" {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, errors.Errorf("migration %d is missing", i+1)
+		}
+		if len(m.up) == 0 || len(m.down) == 0 {
+			return nil, errors.Errorf("migration %d must have an up and a down file", m.version)
+		}
+	}
+	return migrations, nil
+}
+
+// sqliteMigrations returns the migrations of the schema of the sqlite DB
+func sqliteMigrations() ([]migration, error) {
+	migrations, err := loadMigrations(sqliteMigrationFiles, "migrations/sqlite")
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range migrations {
+		if funcs, ok := sqliteMigrationFuncs[m.version]; ok {
+			migrations[i].upFunc, migrations[i].downFunc = funcs.up, funcs.down
+		}
+	}
+	return migrations, nil
+}
+
+// upgradeTimestamps converts the creation times of clusters stored in
+// LegacyTimestampLayout in local time to RFC3339 in UTC
+func upgradeTimestamps(tx *sql.Tx) error {
+	return ConvertTimestamps(context.Background(), tx, sqlitePlaceholder, func(value string) (string, bool) {
+		return UpgradeTimestamp(value, time.Local)
+	})
+}
+
+// downgradeTimestamps reverts upgradeTimestamps
+func downgradeTimestamps(tx *sql.Tx) error {
+	return ConvertTimestamps(context.Background(), tx, sqlitePlaceholder, func(value string) (string, bool) {
+		return DowngradeTimestamp(value, time.Local)
+	})
+}
+
+// sqlitePlaceholder returns the placeholder of the nth argument of a statement
+func sqlitePlaceholder(n int) string {
+	return "?"
+}
+
+// getSchemaVersion returns the version of the schema, 0 if no migration was applied
+func getSchemaVersion(database *sql.DB) (int, error) {
+	cmd := `SELECT COALESCE(MAX(version), 0) FROM schema_version`
+	var version int
+	if err := database.QueryRow(cmd).Scan(&version); err != nil {
+		return 0, SQLError{cmd, err}
+	}
+	return version, nil
+}
+
+// tableExists returns whether the DB has a table of the given name
+func tableExists(database *sql.DB, table string) (bool, error) {
+	cmd := `SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?`
+	var count int
+	if err := database.QueryRow(cmd, table).Scan(&count); err != nil {
+		return false, SQLError{cmd, err}
+	}
+	return count > 0, nil
+}
+
+// adoptLegacySchema adds the columns of later releases to the tables of a DB
+// created before versioned migrations, so the baseline migration applies to it
+func adoptLegacySchema(database *sql.DB) error {
+	// columns added after the initial release of their tables
+	addedColumns := [][3]string{
+		{"agents", "display_name", "TEXT"},
+		{"clusters", "owner_email", "TEXT"},
+		{"clusters", "owner_team", "TEXT"},
+		{"clusters", "slack_channel", "TEXT"},
+		{"clusters", "tenant", "TEXT"},
+		{"clusters", "uid", "TEXT"},
+		{"agents", "plugin_type_id", "INTEGER REFERENCES plugin_types(id)"},
+	}
+	for _, c := range addedColumns {
+		exists, err := tableExists(database, c[0])
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err = addDBColumn(database, c[0], c[1], c[2]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSchema applies the migrations above the version of the schema, each in its own transaction
+// returns an error if the schema is newer than the latest migration, so an older Tornjak
+// does not run on a schema it does not know
+func migrateSchema(database *sql.DB, migrations []migration, now string) error {
+	if err := createDBTable(database, initSchemaVersionTable); err != nil {
+		return err
+	}
+	current, err := getSchemaVersion(database)
+	if err != nil {
+		return err
+	}
+	latest := len(migrations)
+	if current > latest {
+		return errors.Errorf("DataStore schema version %d is newer than version %d of this Tornjak; upgrade Tornjak, or migrate the DataStore down with the newer Tornjak", current, latest)
+	}
+	if current == 0 {
+		if err = adoptLegacySchema(database); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range migrations[current:] {
+		err = runMigration(database, m.up, m.upFunc, func(tx *sql.Tx) error {
+			cmd := `INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`
+			if _, err := tx.Exec(cmd, m.version, m.name, now); err != nil {
+				return SQLError{cmd, err}
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Errorf("could not apply migration %d %s: %v", m.version, m.name, err)
+		}
+		if current > 0 {
+			log.Printf("DataStore schema migrated to version %d %s", m.version, m.name)
+		}
+	}
+	return nil
+}
+
+// migrateSchemaDown reverts the migrations above version, latest first, each in its own transaction
+func migrateSchemaDown(database *sql.DB, migrations []migration, version int) error {
+	current, err := getSchemaVersion(database)
+	if err != nil {
+		return err
+	}
+	if version < 0 || version > current {
+		return errors.Errorf("cannot migrate down to version %d from version %d", version, current)
+	}
+	if current > len(migrations) {
+		return errors.Errorf("DataStore schema version %d is newer than version %d of this Tornjak", current, len(migrations))
+	}
+	for i := current; i > version; i-- {
+		m := migrations[i-1]
+		err = runMigration(database, m.down, m.downFunc, func(tx *sql.Tx) error {
+			cmd := `DELETE FROM schema_version WHERE version=?`
+			if _, err := tx.Exec(cmd, m.version); err != nil {
+				return SQLError{cmd, err}
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Errorf("could not revert migration %d %s: %v", m.version, m.name, err)
+		}
+		log.Printf("DataStore schema migrated down to version %d", m.version-1)
+	}
+	return nil
+}
+
+// runMigration runs the statements of a migration file, its step in Go if not nil,
+// and record in one transaction
+func runMigration(database *sql.DB, statements string, step func(tx *sql.Tx) error, record func(tx *sql.Tx) error) error {
+	ctx := context.Background()
+	tx, err := database.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Errorf("Error initializing context: %v", err)
+	}
+	if _, err = tx.ExecContext(ctx, statements); err != nil {
+		_ = tx.Rollback()
+		return SQLError{"migration", err}
+	}
+	if step != nil {
+		if err = step(tx); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if err = record(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SchemaVersion returns the version of the schema of the DB and the latest version of this Tornjak
+func (db *LocalSqliteDb) SchemaVersion() (int, int, error) {
+	migrations, err := sqliteMigrations()
+	if err != nil {
+		return 0, 0, err
+	}
+	current, err := getSchemaVersion(db.database)
+	if err != nil {
+		return 0, 0, err
+	}
+	return current, len(migrations), nil
+}
+
+// MigrateDown reverts the migrations of the schema above version,
+// so the DB can be used by an older Tornjak
+func (db *LocalSqliteDb) MigrateDown(version int) error {
+	migrations, err := sqliteMigrations()
+	if err != nil {
+		return err
+	}
+	return migrateSchemaDown(db.database, migrations, version)
+}
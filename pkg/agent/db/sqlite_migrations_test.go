@@ -0,0 +1,184 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	backoff "github.com/cenkalti/backoff/v4"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// TestLoadMigrations checks the migrations files are validated
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := sqliteMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 || migrations[0].name != "baseline" {
+		t.Fatalf("First migration should be the baseline, got %+v", migrations)
+	}
+
+	tests := map[string]fstest.MapFS{
+		"missing down": {
+			"m/0001_a.up.sql": {Data: []byte("SELECT 1;")},
+		},
+		"missing version": {
+			"m/0001_a.up.sql": {Data: []byte("SELECT 1;")}, "m/0001_a.down.sql": {Data: []byte("SELECT 1;")},
+			"m/0003_c.up.sql": {Data: []byte("SELECT 1;")}, "m/0003_c.down.sql": {Data: []byte("SELECT 1;")},
+		},
+		"invalid name": {
+			"m/a.up.sql": {Data: []byte("SELECT 1;")},
+		},
+		"different names": {
+			"m/0001_a.up.sql": {Data: []byte("SELECT 1;")}, "m/0001_b.down.sql": {Data: []byte("SELECT 1;")},
+		},
+	}
+	for name, fsys := range tests {
+		if _, err := loadMigrations(fsys, "m"); err == nil {
+			t.Errorf("%s: loading migrations should fail", name)
+		}
+	}
+}
+
+// TestSchemaMigrations checks the schema is versioned, migrated up and down,
+// and that a schema newer than the migrations is refused
+func TestSchemaMigrations(t *testing.T) {
+	dbpath := filepath.Join(t.TempDir(), "tornjak.sqlite3")
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	agentDB, err := NewLocalSqliteDB("sqlite3", dbpath, expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := agentDB.(*LocalSqliteDb)
+	current, latest, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != latest || current == 0 {
+		t.Fatalf("New DB should have the latest schema version %d, got %d", latest, current)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster1", PlatformType: "VMs"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT reopening; should keep the data [NewLocalSqliteDB]
+	agentDB, err = NewLocalSqliteDB("sqlite3", dbpath, expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = agentDB.(*LocalSqliteDb)
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters.Clusters) != 1 {
+		t.Fatalf("Reopened DB should have 1 cluster, got %d", len(clusters.Clusters))
+	}
+
+	// ATTEMPT migrating down past the current version; should fail [MigrateDown]
+	if err = db.MigrateDown(latest + 1); err == nil {
+		t.Fatal("Migrating down to a version above the current one should fail")
+	}
+
+	// ATTEMPT migrating down to an empty schema [MigrateDown, SchemaVersion]
+	if err = db.MigrateDown(0); err != nil {
+		t.Fatal(err)
+	}
+	current, _, err = db.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != 0 {
+		t.Fatalf("Schema version should be 0 after migrating down, got %d", current)
+	}
+	exists, err := tableExists(db.database, "clusters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("Migrating down to version 0 should drop the clusters table")
+	}
+
+	// ATTEMPT opening a schema newer than the migrations; should fail [NewLocalSqliteDB]
+	database, err := sql.Open("sqlite3", dbpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = database.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'future', '')`, latest+1)
+	database.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewLocalSqliteDB("sqlite3", dbpath, expBackoff)
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("Opening a newer schema should fail, got %v", err)
+	}
+}
+
+// TestTimestampsMigration checks creation times of earlier releases are
+// converted to RFC3339 in UTC, in the clusters and in the cluster history
+func TestTimestampsMigration(t *testing.T) {
+	dbpath := filepath.Join(t.TempDir(), "tornjak.sqlite3")
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	agentDB, err := NewLocalSqliteDB("sqlite3", dbpath, expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := agentDB.(*LocalSqliteDb)
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster1", PlatformType: "VMs"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT migrating down to the baseline; should store the legacy layout [MigrateDown]
+	if err = db.MigrateDown(1); err != nil {
+		t.Fatal(err)
+	}
+	var createdAt, snapshot string
+	if err = db.database.QueryRow(`SELECT created_at FROM clusters`).Scan(&createdAt); err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := time.ParseInLocation(LegacyTimestampLayout, createdAt, time.Local)
+	if err != nil {
+		t.Fatalf("Migrating down should store the legacy layout, got %q", createdAt)
+	}
+	if err = db.database.QueryRow(`SELECT snapshot FROM cluster_history`).Scan(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(snapshot, `"creationTime":"`+createdAt+`"`) {
+		t.Fatalf("Migrating down should convert the cluster history, got %s", snapshot)
+	}
+
+	// ATTEMPT reopening; should migrate the legacy times to UTC [NewLocalSqliteDB]
+	agentDB, err = NewLocalSqliteDB("sqlite3", dbpath, expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = agentDB.(*LocalSqliteDb)
+	if err = db.database.QueryRow(`SELECT created_at FROM clusters`).Scan(&createdAt); err != nil {
+		t.Fatal(err)
+	}
+	if createdAt != FormatTimestamp(legacy) {
+		t.Fatalf("Expected creation time %s, got %q", FormatTimestamp(legacy), createdAt)
+	}
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !clusters.Clusters[0].CreationTime.Equal(legacy) || clusters.Clusters[0].CreationTime.Location() != time.UTC {
+		t.Fatalf("Expected creation time %v in UTC, got %v", legacy, clusters.Clusters[0].CreationTime)
+	}
+	history, err := db.GetClustersAsOf(FormatTimestamp(time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Clusters) != 1 || !history.Clusters[0].CreationTime.Equal(legacy) {
+		t.Fatalf("Expected cluster history with creation time %v, got %+v", legacy, history.Clusters)
+	}
+}
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// full-text index of the searchable fields of clusters
+// FTS5 is only compiled in with the sqlite_fts5 build tag, so the index is not
+// part of the versioned schema: it is created and rebuilt when the DB is opened
+// by a Tornjak with FTS5, and SearchClusters matches substrings without it.
+// The triggers of migration 6 record the clusters changed in
+// clusters_search_pending rather than writing the index, as writing an FTS5
+// table reads it first, so a write transaction starting with it fails when
+// another one holds the lock instead of waiting. SearchClusters updates the
+// index with the pending changes before searching it.
+const (
+	clusterSearchTable     = "clusters_search"
+	initClusterSearchTable = `CREATE VIRTUAL TABLE IF NOT EXISTS clusters_search USING fts5
+                            (name, domain_name, managed_by, platform_type)`
+	dropClusterSearchTable = `DROP TABLE IF EXISTS clusters_search`
+	// the columns of the index, from the clusters table
+	clusterSearchColumns = `name, domain_name, managed_by, platform_type`
+)
+
+// hasFTS5 returns whether the sqlite library has FTS5
+func hasFTS5(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}) (bool, error) {
+	cmd := `SELECT sqlite_compileoption_used('ENABLE_FTS5')`
+	var fts5 bool
+	if err := q.QueryRow(cmd).Scan(&fts5); err != nil {
+		return false, SQLError{cmd, err}
+	}
+	return fts5, nil
+}
+
+// applyClusterSearchIndex creates and rebuilds the full-text index of clusters
+// if the sqlite library has FTS5, and returns whether it has
+// without FTS5, the pending changes are dropped, as the index is rebuilt by
+// the next Tornjak with FTS5 that opens the DB
+func applyClusterSearchIndex(database *sql.DB) (bool, error) {
+	fts5, err := hasFTS5(database)
+	if err != nil {
+		return false, err
+	}
+	if !fts5 {
+		cmd := `DELETE FROM clusters_search_pending`
+		if _, err = database.Exec(cmd); err != nil {
+			return false, SQLError{cmd, err}
+		}
+		return false, nil
+	}
+	if _, err = database.Exec(initClusterSearchTable); err != nil {
+		return false, SQLError{initClusterSearchTable, err}
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return false, errors.Errorf("Error initializing context: %v", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // no-op once committed
+	for _, cmd := range []string{
+		// a write to a regular table first, see clusterSearchTable
+		`DELETE FROM clusters_search_pending`,
+		`DELETE FROM clusters_search`,
+		`INSERT INTO clusters_search (rowid, ` + clusterSearchColumns + `) SELECT id, ` + clusterSearchColumns + ` FROM clusters`,
+	} {
+		if _, err = tx.Exec(cmd); err != nil {
+			return false, SQLError{cmd, err}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return false, SQLError{"commit", err}
+	}
+	return true, nil
+}
+
+// dropClusterSearchIndex drops the full-text index of clusters when migration
+// 6 is reverted, as older Tornjaks would restore snapshots into it
+// the index can only be dropped with FTS5
+func dropClusterSearchIndex(tx *sql.Tx) error {
+	cmd := `SELECT COUNT(*) FROM sqlite_master WHERE name=?`
+	var count int
+	if err := tx.QueryRow(cmd, clusterSearchTable).Scan(&count); err != nil {
+		return SQLError{cmd, err}
+	}
+	if count == 0 {
+		return nil
+	}
+	fts5, err := hasFTS5(tx)
+	if err != nil {
+		return err
+	}
+	if !fts5 {
+		return errors.New("the full-text index of clusters can only be dropped by a Tornjak built with the sqlite_fts5 tag")
+	}
+	if _, err = tx.Exec(dropClusterSearchTable); err != nil {
+		return SQLError{dropClusterSearchTable, err}
+	}
+	return nil
+}
+
+// isClusterSearchTable returns whether table is the full-text index of
+// clusters, one of the tables FTS5 stores it in, or its pending changes
+// restored snapshots mark all their clusters pending through the triggers
+func isClusterSearchTable(table string) bool {
+	return table == clusterSearchTable || strings.HasPrefix(table, clusterSearchTable+"_")
+}
+
+// updateClusterSearchIndex writes the pending changes of clusters to the
+// full-text index
+func (db *LocalSqliteDb) updateClusterSearchIndex() error {
+	cmd := `SELECT COUNT(*) FROM clusters_search_pending`
+	var pending int
+	if err := db.database.QueryRow(cmd).Scan(&pending); err != nil {
+		return SQLError{cmd, err}
+	}
+	if pending == 0 {
+		return nil
+	}
+	return db.retryOp(db.updateClusterSearchIndexOp)
+}
+
+func (db *LocalSqliteDb) updateClusterSearchIndexOp() error {
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Errorf("Error initializing context: %v", err)
+	}
+	txHelper := getTornjakTxHelper(ctx, tx, db.txMetrics, db.clock, db.actor, "updateClusterSearchIndex")
+
+	// a write to a regular table first, see clusterSearchTable
+	cmd := `DELETE FROM clusters_search_pending RETURNING id`
+	rows, err := tx.QueryContext(ctx, cmd)
+	if err != nil {
+		return txHelper.rollbackHandler(SQLError{cmd, err})
+	}
+	ids := []interface{}{}
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return txHelper.rollbackHandler(SQLError{cmd, err})
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return txHelper.commit()
+	}
+	in := ` IN (?` + strings.Repeat(",?", len(ids)-1) + `)`
+	for _, cmd := range []string{
+		`DELETE FROM clusters_search WHERE rowid` + in,
+		`INSERT INTO clusters_search (rowid, ` + clusterSearchColumns + `)
+          SELECT id, ` + clusterSearchColumns + ` FROM clusters WHERE id` + in,
+	} {
+		if _, err = tx.ExecContext(ctx, cmd, ids...); err != nil {
+			return txHelper.rollbackHandler(SQLError{cmd, err})
+		}
+	}
+	return txHelper.commit()
+}
+
+// SearchClusters returns the clusters matching every word of query in their
+// name, domain name, manager or platform type, best match first
+// with FTS5, words match the start of words of the fields; without it, any
+// part of the fields
+func (db *LocalSqliteDb) SearchClusters(query string) (types.ClusterInfoList, error) {
+	terms, err := SearchTerms(query)
+	if err != nil {
+		return types.ClusterInfoList{}, err
+	}
+
+	var names []string
+	if db.clusterSearch {
+		if err = db.updateClusterSearchIndex(); err != nil {
+			return types.ClusterInfoList{}, err
+		}
+		// words only have letters and digits, so need no escaping in the phrases
+		phrases := make([]string, len(terms))
+		for i, term := range terms {
+			phrases[i] = fmt.Sprintf(`"%s"*`, term)
+		}
+		names, err = db.getStrings(`SELECT clusters.name FROM clusters_search
+          JOIN clusters ON clusters.id=clusters_search.rowid
+          WHERE clusters_search MATCH ? ORDER BY clusters_search.rank, clusters.name`, strings.Join(phrases, " "))
+	} else {
+		fields := `lower(COALESCE(name, '') || ' ' || COALESCE(domain_name, '') || ' ' ||
+          COALESCE(managed_by, '') || ' ' || COALESCE(platform_type, ''))`
+		conds := make([]string, len(terms))
+		args := make([]interface{}, len(terms))
+		for i, term := range terms {
+			conds[i] = fields + " LIKE ?"
+			args[i] = "%" + term + "%"
+		}
+		names, err = db.getStrings(`SELECT name FROM clusters WHERE `+strings.Join(conds, " AND ")+` ORDER BY name`, args...)
+	}
+	if err != nil {
+		return types.ClusterInfoList{}, err
+	}
+	if len(names) == 0 {
+		return types.ClusterInfoList{Clusters: []types.ClusterInfo{}}, nil
+	}
+	where, vals := inCond("clusters.name", names)
+	sinfos, err := db.getClusters(" WHERE "+where, vals)
+	if err != nil {
+		return types.ClusterInfoList{}, err
+	}
+	return types.ClusterInfoList{Clusters: InOrder(sinfos, names, ClusterName)}, nil
+}
@@ -1,14 +1,25 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	backoff "github.com/cenkalti/backoff/v4"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
+	"github.com/spiffe/tornjak/pkg/agent/clock"
 	"github.com/spiffe/tornjak/pkg/agent/types"
 )
 
@@ -44,14 +55,14 @@ func TestSelectorDB(t *testing.T) {
 	}
 	sinfoNew := types.AgentInfo{
 		Spiffeid: spiffeid,
-		Plugin:   "K8s",
+		Plugin:   "Kubernetes",
 	}
 	sinfoANull := types.AgentInfo{
 		Spiffeid: spiffeidA,
 	}
 	sinfoANotNull := types.AgentInfo{
 		Spiffeid: spiffeidA,
-		Plugin:   "K8s",
+		Plugin:   "Kubernetes",
 	}
 
 	// ATTEMPT registration of agent plugin [CreateAgentEntry]]
@@ -334,6 +345,18 @@ func TestClusterCreate(t *testing.T) {
 		t.Fatalf(fmt.Sprintf("Error on basic registration of agents to cluster: %v", err))
 	}
 
+	// CHECK page of agent memberships in descending order [GetClusterAgentsPage]
+	agentsPage, err := db.GetClusterAgentsPage(cluster1, types.ListOptions{Limit: 1, Sort: []types.SortField{{Field: "spiffeid", Desc: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agentsPage.Items) != 1 || agentsPage.Items[0] != agent2 || agentsPage.Total != 2 || agentsPage.NextCursor == "" {
+		t.Fatalf("Expected first page [%s] of 2 agents, got %+v", agent2, agentsPage)
+	}
+	if _, err = db.GetClusterAgentsPage(cluster2, types.ListOptions{}); err == nil {
+		t.Fatal("should not be able to page cluster agents of unsuccessfully assigned cluster")
+	}
+
 	// ATTEMPT editing registration of agent plugin [CreateAgentEntry]
 	err = db.CreateAgentEntry(sinfo)
 	if err != nil {
@@ -488,7 +511,7 @@ func TestClusterEdit(t *testing.T) {
 	}
 
 	// ATTEMPT normal EditClusterEntry [EditClusterEntry, GetClusters, GetClusterAgents]
-	err = db.EditClusterEntry(cinfo1New)
+	_, err = db.EditClusterEntry(cinfo1New)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -501,7 +524,7 @@ func TestClusterEdit(t *testing.T) {
 	}
 
 	// ATTEMPT EditClusterEntry on non-existent cluster; should fail [EditClusterEntry]
-	err = db.EditClusterEntry(cinfo2)
+	_, err = db.EditClusterEntry(cinfo2)
 	if err == nil {
 		t.Fatal("Failed to report edit of nonexisting cluster")
 	}
@@ -515,7 +538,7 @@ func TestClusterEdit(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = db.EditClusterEntry(cinfo1)
+	_, err = db.EditClusterEntry(cinfo1)
 	if err == nil {
 		t.Fatal("Failed to report failure of agent assignment already taken")
 	}
@@ -563,7 +586,7 @@ func TestClusterEdit(t *testing.T) {
 	}
 
 	// TEST Renaming of cluster that exists to cluster that does not exist; should succeed
-	err = db.EditClusterEntry(cinfo1to3)
+	_, err = db.EditClusterEntry(cinfo1to3)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -576,7 +599,7 @@ func TestClusterEdit(t *testing.T) {
 	}
 
 	// TEST Renaming of cluster that exists with conflicting new agents; should fail
-	err = db.EditClusterEntry(cinfo3to4)
+	_, err = db.EditClusterEntry(cinfo3to4)
 	if err == nil {
 		t.Fatal("Renamed edit cluster should throw error with conflicting new agents")
 	}
@@ -593,7 +616,7 @@ func TestClusterEdit(t *testing.T) {
 	}
 
 	// TEST Renaming of cluster that exists to cluster that exists; should fail
-	err = db.EditClusterEntry(cinfo3to2)
+	_, err = db.EditClusterEntry(cinfo3to2)
 	if err == nil {
 		t.Fatal("Renamed edit cluster should throw error when renaming to cluster that exists")
 	}
@@ -688,7 +711,7 @@ func TestClusterDelete(t *testing.T) {
 	}
 
 	// TEST Edit with Removing Entries [EditClusterEntry, GetClusterAgents]
-	err = db.EditClusterEntry(cinfo1New)
+	_, err = db.EditClusterEntry(cinfo1New)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -755,64 +778,4210 @@ func TestClusterDelete(t *testing.T) {
 
 }
 
-/**** HELPER SECTION ****/
+// TestSPIREQueryLog checks the SPIRE query log keeps only the most recent records
+// uses NewLocalSqliteDB, db.AddSPIRECallRecord, db.GetSPIRECallRecords
+func TestSPIREQueryLog(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	agentDB, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := agentDB.(*LocalSqliteDb)
+	db.queryLogSize = 3
 
-func agentInfoCmp(agentInfo1 types.AgentInfo, agentInfo2 types.AgentInfo) bool {
-	return agentInfo1.Spiffeid == agentInfo2.Spiffeid && agentInfo1.Plugin == agentInfo2.Plugin
-}
+	// CHECK initial emptiness [GetSPIRECallRecords]
+	calls, err := db.GetSPIRECallRecords(types.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls.Items) != 0 {
+		t.Fatal("SPIRE query log should initially be empty")
+	}
 
-func inList(elem string, list []string) bool {
-	for i := 0; i < len(list); i++ {
-		if elem == list[i] {
-			return true
+	// ATTEMPT recording more calls than the log retains [AddSPIRECallRecord]
+	methods := []string{"/m1", "/m2", "/m3", "/m4", "/m5"}
+	for _, method := range methods {
+		err = db.AddSPIRECallRecord(types.SPIRECallInfo{
+			Method: method,
+			Status: "OK",
+			User:   "admin",
+		})
+		if err != nil {
+			t.Fatal(err)
 		}
 	}
-	return false
+
+	// CHECK only most recent calls retained, newest first [GetSPIRECallRecords]
+	calls, err = db.GetSPIRECallRecords(types.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls.Items) != 3 {
+		t.Fatalf("Expected 3 retained calls, got %v", calls.Items)
+	}
+	if calls.Items[0].Method != "/m5" || calls.Items[2].Method != "/m3" {
+		t.Fatalf("Wrong calls retained: %v", calls.Items)
+	}
+
+	// CHECK limit [GetSPIRECallRecords]
+	calls, err = db.GetSPIRECallRecords(types.ListOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls.Items) != 1 || calls.Items[0].Method != "/m5" || calls.Items[0].User != "admin" {
+		t.Fatalf("Expected most recent call only, got %v", calls.Items)
+	}
+	if calls.Total != 3 || calls.NextCursor == "" {
+		t.Fatalf("Expected total 3 and a next cursor, got %v", calls)
+	}
+
+	// CHECK next page [GetSPIRECallRecords]
+	calls, err = db.GetSPIRECallRecords(types.ListOptions{Limit: 2, Cursor: calls.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls.Items) != 2 || calls.Items[0].Method != "/m4" || calls.NextCursor != "" {
+		t.Fatalf("Expected last page of two calls, got %v", calls)
+	}
+
+	// CHECK filter and sort [GetSPIRECallRecords]
+	calls, err = db.GetSPIRECallRecords(types.ListOptions{
+		Filters: []types.Filter{{Field: "method", Value: "/m4"}},
+		Sort:    []types.SortField{{Field: "timestamp"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls.Items) != 1 || calls.Items[0].Method != "/m4" || calls.Total != 1 {
+		t.Fatalf("Expected filtered call only, got %v", calls)
+	}
+
+	// CHECK unknown filter field rejected [GetSPIRECallRecords]
+	_, err = db.GetSPIRECallRecords(types.ListOptions{
+		Filters: []types.Filter{{Field: "unknown", Value: "x"}},
+	})
+	if err == nil {
+		t.Fatal("Expected error filtering on unknown field")
+	}
 }
 
-func agentListComp(correctList []string, resultList []string) error {
-	if len(resultList) != len(correctList) {
-		return errors.Errorf("Lists not the same: expected %v, got %v", correctList, resultList)
-	} else {
-		for i := 0; i < len(correctList); i++ {
-			if !inList(correctList[i], resultList) {
-				return errors.Errorf("Agent %v not in resulting agents list %v", correctList[i], resultList)
-			}
-		}
-		return nil
+// TestEntryLineage checks lineage of cloned entries is recorded once and can be retrieved
+// uses NewLocalSqliteDB, db.CreateEntryLineage, db.GetEntryLineage
+func TestEntryLineage(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK no lineage for unknown entry [GetEntryLineage]
+	_, err = db.GetEntryLineage("entry-2")
+	if _, ok := err.(GetError); !ok {
+		t.Fatalf("Expected GetError for entry without lineage, got %v", err)
+	}
+
+	// ATTEMPT recording lineage [CreateEntryLineage]
+	lineage := types.EntryLineage{
+		EntryId:       "entry-2",
+		SourceEntryId: "entry-1",
+		CreatedBy:     "admin",
+		CreationTime:  "2024-02-08T21:02:10Z",
+	}
+	err = db.CreateEntryLineage(lineage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK lineage retrieved [GetEntryLineage]
+	got, err := db.GetEntryLineage("entry-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != lineage {
+		t.Fatalf("Expected lineage %v, got %v", lineage, got)
+	}
+
+	// ATTEMPT recording lineage of the same entry twice [CreateEntryLineage]
+	err = db.CreateEntryLineage(types.EntryLineage{EntryId: "entry-2", SourceEntryId: "entry-3"})
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure for duplicate lineage, got %v", err)
 	}
 }
 
-func clusterEquality(c1 types.ClusterInfo, c2 types.ClusterInfo) bool {
-	if c1.Name != c2.Name || c1.DomainName != c2.DomainName ||
-		c1.ManagedBy != c2.ManagedBy || c1.PlatformType != c2.PlatformType {
-		return false
+// TestAgentDisplayName checks display names are stored, returned with agent metadata and searchable
+// uses NewLocalSqliteDB, db.CreateAgentEntry, db.SetAgentDisplayName, db.GetAgentPluginInfo, db.GetAgentsMetadata
+func TestAgentDisplayName(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+
+	// create agents table as in older versions without display_name column
+	database, err := sql.Open("sqlite3", "./local-agentstest-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = database.Exec(`CREATE TABLE agents (id INTEGER PRIMARY KEY AUTOINCREMENT, spiffeid TEXT, plugin TEXT, UNIQUE (spiffeid))`)
+	database.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spiffeid1 := "spiffe://example.org/spire/agent/join_token/1"
+	spiffeid2 := "spiffe://example.org/spire/agent/join_token/2"
+	err = db.CreateAgentEntry(types.AgentInfo{Spiffeid: spiffeid1, Plugin: "Docker"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT set display names of existing and new agent [SetAgentDisplayName]
+	err = db.SetAgentDisplayName(spiffeid1, "edge-node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.SetAgentDisplayName(spiffeid2, "core-node")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK display name returned with plugin [GetAgentPluginInfo]
+	sinfo, err := db.GetAgentPluginInfo(spiffeid1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sinfo.Plugin != "Docker" || sinfo.DisplayName != "edge-node" {
+		t.Fatalf("Expected plugin and display name, got %v", sinfo)
+	}
+
+	// CHECK search by display name [GetAgentsMetadata]
+	ainfos, err := db.GetAgentsMetadata(types.AgentMetadataRequest{Search: "core"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ainfos.Agents) != 1 || ainfos.Agents[0].Spiffeid != spiffeid2 || ainfos.Agents[0].DisplayName != "core-node" {
+		t.Fatalf("Expected only agent with matching display name, got %v", ainfos.Agents)
+	}
+
+	// CHECK search combined with spiffeid list [GetAgentsMetadata]
+	ainfos, err = db.GetAgentsMetadata(types.AgentMetadataRequest{Agents: []string{spiffeid1}, Search: "node"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ainfos.Agents) != 1 || ainfos.Agents[0].Spiffeid != spiffeid1 {
+		t.Fatalf("Expected only listed agent, got %v", ainfos.Agents)
+	}
+
+	// ATTEMPT remove display name [SetAgentDisplayName]
+	err = db.SetAgentDisplayName(spiffeid1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinfo, err = db.GetAgentPluginInfo(spiffeid1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sinfo.DisplayName != "" || sinfo.Plugin != "Docker" {
+		t.Fatalf("Expected display name removed and plugin kept, got %v", sinfo)
 	}
-	return agentListComp(c1.AgentsList, c2.AgentsList) == nil
 }
 
-func inClusterList(cluster types.ClusterInfo, list []types.ClusterInfo) bool {
-	for i := 0; i < len(list); i++ {
-		if clusterEquality(cluster, list[i]) {
-			return true
-		}
+// TestClusterContacts checks cluster contact fields are stored on create and edit
+// uses NewLocalSqliteDB, db.CreateClusterEntry, db.EditClusterEntry, db.GetClusters
+func TestClusterContacts(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT create cluster with contacts [CreateClusterEntry]
+	cinfo := types.ClusterInfo{
+		Name:         "cluster1",
+		PlatformType: "Kubernetes",
+		OwnerEmail:   "owner@example.org",
+		OwnerTeam:    "platform",
+		SlackChannel: "#platform",
+	}
+	err = db.CreateClusterEntry(cinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK contacts returned [GetClusters]
+	cList, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cList.Clusters) != 1 {
+		t.Fatalf("Expected one cluster, got %v", cList.Clusters)
+	}
+	c := cList.Clusters[0]
+	if c.OwnerEmail != cinfo.OwnerEmail || c.OwnerTeam != cinfo.OwnerTeam || c.SlackChannel != cinfo.SlackChannel {
+		t.Fatalf("Expected contacts of %v, got %v", cinfo, c)
+	}
+
+	// ATTEMPT edit contacts [EditClusterEntry]
+	cinfo.EditedName = cinfo.Name
+	cinfo.OwnerEmail = "other@example.org"
+	cinfo.SlackChannel = ""
+	_, err = db.EditClusterEntry(cinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK contacts updated [GetClusters]
+	cList, err = db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = cList.Clusters[0]
+	if c.OwnerEmail != "other@example.org" || c.OwnerTeam != "platform" || c.SlackChannel != "" {
+		t.Fatalf("Expected edited contacts, got %v", c)
 	}
-	return false
 }
 
-func clustersComp(c1 types.ClusterInfoList, c2 types.ClusterInfoList) error {
-	c1info := c1.Clusters
-	c2info := c2.Clusters
-	if len(c1info) != len(c2info) {
-		return errors.New("Number of clusters incorrect")
+// TestAgentClusterNames checks cluster names of many agents are looked up at once
+// uses NewLocalSqliteDB, db.CreateClusterEntry, db.CreateAgentEntry, db.GetAgentClusterNames
+func TestAgentClusterNames(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
 	}
-	for i := 0; i < len(c1info); i++ {
-		if !inClusterList(c1info[i], c2info) {
-			cname := c1info[i].Name
-			return errors.Errorf("Error: first list contains cluster %v not in second list", cname)
-		}
+
+	// more agents than fit in a single query batch
+	agents1 := []string{}
+	for i := 0; i < agentClusterNamesBatchSize+10; i++ {
+		agents1 = append(agents1, fmt.Sprintf("agent%v", i))
+	}
+	err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster1", PlatformType: "VMs", AgentsList: agents1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster2", PlatformType: "VMs", AgentsList: []string{"agentX"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.CreateAgentEntry(types.AgentInfo{Spiffeid: "agentUnassigned", Plugin: "Docker"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK empty input [GetAgentClusterNames]
+	names, err := db.GetAgentClusterNames([]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("Expected no cluster names, got %v", names)
+	}
+
+	// CHECK assigned, unassigned and unknown agents [GetAgentClusterNames]
+	query := append([]string{"agentX", "agentUnassigned", "agentUnknown"}, agents1...)
+	names, err = db.GetAgentClusterNames(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != len(agents1)+1 {
+		t.Fatalf("Expected %v cluster names, got %v", len(agents1)+1, len(names))
+	}
+	if names["agentX"] != "cluster2" || names[agents1[len(agents1)-1]] != "cluster1" {
+		t.Fatalf("Wrong cluster names: %v", names)
+	}
+	if _, ok := names["agentUnassigned"]; ok {
+		t.Fatal("Unassigned agent should not have a cluster name")
 	}
-	return nil
 }
 
-/**** END HELPER SECTION ****/
+// TestClusterNameUniqueness checks the case-insensitive cluster name policy
+// uses NewLocalSqliteDBWithOptions, db.CreateClusterEntry, db.EditClusterEntry
+func TestClusterNameUniqueness(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+
+	// ATTEMPT names differing by case with default policy [CreateClusterEntry]
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.CreateClusterEntry(types.ClusterInfo{Name: "Prod", PlatformType: "VMs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.CreateClusterEntry(types.ClusterInfo{Name: "prod", PlatformType: "VMs"})
+	if err != nil {
+		t.Fatalf("Default policy should allow names differing by case: %v", err)
+	}
+
+	// CHECK case-insensitive policy cannot be enforced on conflicting names [NewLocalSqliteDBWithOptions]
+	opts := SqliteOptions{ClusterNameUniqueness: ClusterNameCaseInsensitive}
+	_, err = NewLocalSqliteDBWithOptions("sqlite3", "./local-agentstest-db", expBackoff, opts)
+	if err == nil {
+		t.Fatal("Case-insensitive policy should fail with conflicting cluster names")
+	}
+	err = db.DeleteClusterEntry("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK invalid policy [NewLocalSqliteDBWithOptions]
+	_, err = NewLocalSqliteDBWithOptions("sqlite3", "./local-agentstest-db", expBackoff, SqliteOptions{ClusterNameUniqueness: "other"})
+	if err == nil {
+		t.Fatal("Invalid policy should fail")
+	}
+
+	// ATTEMPT names differing by case with case-insensitive policy [CreateClusterEntry]
+	db, err = NewLocalSqliteDBWithOptions("sqlite3", "./local-agentstest-db", expBackoff, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.CreateClusterEntry(types.ClusterInfo{Name: "PROD", PlatformType: "VMs"})
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure on case-insensitive conflict, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "case-insensitive") {
+		t.Fatalf("Expected case-insensitive conflict error, got %v", err)
+	}
+	err = db.CreateClusterEntry(types.ClusterInfo{Name: "Prod", PlatformType: "VMs"})
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure on exact conflict, got %v", err)
+	}
+
+	// ATTEMPT rename to name differing by case of other cluster [EditClusterEntry]
+	err = db.CreateClusterEntry(types.ClusterInfo{Name: "dev", PlatformType: "VMs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.EditClusterEntry(types.ClusterInfo{Name: "dev", EditedName: "prod", PlatformType: "VMs"})
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure on case-insensitive rename conflict, got %v", err)
+	}
+
+	// ATTEMPT change case of own name [EditClusterEntry]
+	_, err = db.EditClusterEntry(types.ClusterInfo{Name: "dev", EditedName: "DEV", PlatformType: "VMs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestServiceAccounts checks service accounts are stored with their roles, found by key hash and deleted
+// uses NewLocalSqliteDB, db.CreateServiceAccount, db.GetServiceAccounts, db.GetServiceAccountByKeyHash, db.DeleteServiceAccount
+func TestServiceAccounts(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT creating service account [CreateServiceAccount]
+	account := types.ServiceAccount{
+		Name:         "ci-pipeline",
+		Description:  "CI",
+		Roles:        []string{"admin", "viewer"},
+		CreatedBy:    "admin",
+		CreationTime: "2024-02-08T21:02:10Z",
+	}
+	err = db.CreateServiceAccount(account, "hash-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT creating service account with the same name [CreateServiceAccount]
+	err = db.CreateServiceAccount(account, "hash-2")
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure for duplicate service account, got %v", err)
+	}
+
+	// CHECK service account listed [GetServiceAccounts]
+	list, err := db.GetServiceAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.ServiceAccounts) != 1 || !reflect.DeepEqual(list.ServiceAccounts[0], account) {
+		t.Fatalf("Expected service accounts [%v], got %v", account, list.ServiceAccounts)
+	}
+
+	// CHECK service account found by key hash [GetServiceAccountByKeyHash]
+	got, err := db.GetServiceAccountByKeyHash("hash-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, account) {
+		t.Fatalf("Expected service account %v, got %v", account, got)
+	}
+	_, err = db.GetServiceAccountByKeyHash("hash-2")
+	if _, ok := err.(GetError); !ok {
+		t.Fatalf("Expected GetError for unknown key hash, got %v", err)
+	}
+
+	// ATTEMPT deleting service account [DeleteServiceAccount]
+	err = db.DeleteServiceAccount("ci-pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK key no longer valid after delete [GetServiceAccountByKeyHash]
+	_, err = db.GetServiceAccountByKeyHash("hash-1")
+	if _, ok := err.(GetError); !ok {
+		t.Fatalf("Expected GetError after delete, got %v", err)
+	}
+
+	// ATTEMPT deleting missing service account [DeleteServiceAccount]
+	err = db.DeleteServiceAccount("ci-pipeline")
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure for missing service account, got %v", err)
+	}
+}
+
+// TestSecrets checks the first stored value of a secret is kept, returned to
+// later writers and replaced only by updates
+// uses NewLocalSqliteDB, db.GetOrCreateSecret, db.UpdateSecret
+func TestSecrets(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	agentDB, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, ok := agentDB.(SecretStore)
+	if !ok {
+		t.Fatal("LocalSqliteDb should implement SecretStore")
+	}
+
+	// ATTEMPT store two values of the same secret [GetOrCreateSecret]
+	first, err := db.GetOrCreateSecret("pepper", []byte("value-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.GetOrCreateSecret("pepper", []byte("value-2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK the first value is kept
+	if string(first) != "value-1" || string(second) != "value-1" {
+		t.Fatalf("Expected value-1 stored, got %q and %q", first, second)
+	}
+
+	// ATTEMPT update the secret [UpdateSecret]
+	if err = db.UpdateSecret("pepper", []byte("value-3")); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK the update is returned
+	got, err := db.GetOrCreateSecret("pepper", []byte("value-4"))
+	if err != nil || string(got) != "value-3" {
+		t.Fatalf("Expected value-3 stored, got %q, %v", got, err)
+	}
+
+	// ATTEMPT update a missing secret [UpdateSecret]
+	err = db.UpdateSecret("missing", []byte("value"))
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure for missing secret, got %v", err)
+	}
+}
+
+// TestClusterExtensions checks extension fields are stored, replaced on edit, renamed with the cluster and removed on delete
+// uses NewLocalSqliteDB, db.CreateClusterEntry, db.EditClusterEntry, db.DeleteClusterEntry, db.GetClusters
+func TestClusterExtensions(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT create clusters with and without extensions [CreateClusterEntry]
+	cinfo := types.ClusterInfo{
+		Name:         "cluster1",
+		PlatformType: "Kubernetes",
+		Extensions:   map[string]interface{}{"version": "1.29", "nodes": float64(3), "managed": true},
+	}
+	err = db.CreateClusterEntry(cinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster2", PlatformType: "Docker"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK extensions returned with their types [GetClusters]
+	extensions := func() map[string]map[string]interface{} {
+		cList, err := db.GetClusters()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := make(map[string]map[string]interface{})
+		for _, c := range cList.Clusters {
+			ret[c.Name] = c.Extensions
+		}
+		return ret
+	}
+	got := extensions()
+	if !reflect.DeepEqual(got["cluster1"], cinfo.Extensions) || got["cluster2"] != nil {
+		t.Fatalf("Expected extensions %v for cluster1 only, got %v", cinfo.Extensions, got)
+	}
+
+	// ATTEMPT edit and rename cluster with new extensions [EditClusterEntry]
+	cinfo.EditedName = "cluster1-renamed"
+	cinfo.Extensions = map[string]interface{}{"version": "1.30"}
+	_, err = db.EditClusterEntry(cinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK extensions replaced [GetClusters]
+	got = extensions()
+	if !reflect.DeepEqual(got["cluster1-renamed"], cinfo.Extensions) {
+		t.Fatalf("Expected extensions %v, got %v", cinfo.Extensions, got)
+	}
+
+	// ATTEMPT delete cluster [DeleteClusterEntry]
+	err = db.DeleteClusterEntry("cluster1-renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK extensions removed with cluster
+	var count int
+	err = db.(*LocalSqliteDb).database.QueryRow("SELECT COUNT(*) FROM cluster_extensions").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("Expected no extension rows after delete, got %d", count)
+	}
+}
+
+// TestAgentCompliance checks compliance reports update current attributes, are kept in history and filter agent metadata
+// uses NewLocalSqliteDB, db.AddAgentComplianceReport, db.GetAgentComplianceHistory, db.GetAgentsMetadata
+func TestAgentCompliance(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT reporting attributes of unknown agents [AddAgentComplianceReport]
+	reports := []types.AgentComplianceReport{
+		{Spiffeid: "spiffe://a", Source: "scanner", ReportedAt: "2024-01-01T00:00:00Z",
+			Attributes: map[string]string{"kernel_version": "5.15", "cis_score": "70"}},
+		{Spiffeid: "spiffe://b", Source: "scanner", ReportedAt: "2024-01-01T00:00:00Z",
+			Attributes: map[string]string{"kernel_version": "6.1", "cis_score": "92"}},
+		{Spiffeid: "spiffe://a", Source: "patcher", ReportedAt: "2024-01-02T00:00:00Z",
+			Attributes: map[string]string{"kernel_version": "6.1"}},
+	}
+	for _, report := range reports {
+		if err = db.AddAgentComplianceReport(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// CHECK current attributes returned with agent metadata [GetAgentsMetadata]
+	aList, err := db.GetAgentsMetadata(types.AgentMetadataRequest{Agents: []string{"spiffe://a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"kernel_version": "6.1", "cis_score": "70"}
+	if len(aList.Agents) != 1 || !reflect.DeepEqual(aList.Agents[0].Compliance, expected) {
+		t.Fatalf("Expected agent with compliance %v, got %v", expected, aList.Agents)
+	}
+
+	// CHECK agents filtered by current attributes [GetAgentsMetadata]
+	filterTests := []struct {
+		filters  []types.ComplianceFilter
+		expected []string
+	}{
+		{[]types.ComplianceFilter{{Attribute: "kernel_version", Value: "6.1"}}, []string{"spiffe://a", "spiffe://b"}},
+		{[]types.ComplianceFilter{{Attribute: "cis_score", Op: types.ComplianceOpGe, Value: "80"}}, []string{"spiffe://b"}},
+		{[]types.ComplianceFilter{{Attribute: "cis_score", Op: types.ComplianceOpLt, Value: "80"},
+			{Attribute: "kernel_version", Op: types.ComplianceOpNe, Value: "5.15"}}, []string{"spiffe://a"}},
+		{[]types.ComplianceFilter{{Attribute: "kernel_version", Value: "5.15"}}, []string{}},
+	}
+	for _, tt := range filterTests {
+		aList, err = db.GetAgentsMetadata(types.AgentMetadataRequest{Compliance: tt.filters})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, agent := range aList.Agents {
+			got = append(got, agent.Spiffeid)
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Fatalf("Expected agents %v for filters %v, got %v", tt.expected, tt.filters, got)
+		}
+	}
+
+	// ATTEMPT filter with invalid operator [GetAgentsMetadata]
+	_, err = db.GetAgentsMetadata(types.AgentMetadataRequest{Compliance: []types.ComplianceFilter{{Attribute: "cis_score", Op: "like", Value: "8"}}})
+	if _, ok := err.(GetError); !ok {
+		t.Fatalf("Expected GetError for invalid filter, got %v", err)
+	}
+
+	// CHECK history kept newest first [GetAgentComplianceHistory]
+	history, err := db.GetAgentComplianceHistory("spiffe://a", "kernel_version", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedRecords := []types.AgentComplianceRecord{
+		{Attribute: "kernel_version", Value: "6.1", Source: "patcher", ReportedAt: "2024-01-02T00:00:00Z"},
+		{Attribute: "kernel_version", Value: "5.15", Source: "scanner", ReportedAt: "2024-01-01T00:00:00Z"},
+	}
+	if !reflect.DeepEqual(history.Records, expectedRecords) {
+		t.Fatalf("Expected history %v, got %v", expectedRecords, history.Records)
+	}
+	history, err = db.GetAgentComplianceHistory("spiffe://a", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Records) != 1 {
+		t.Fatalf("Expected history limited to one record, got %v", history.Records)
+	}
+}
+
+// TestBackup checks a backup holds the data of the DB and does not overwrite files
+// uses NewLocalSqliteDB, db.CreateClusterEntry, db.Backup, db.GetClusters
+func TestBackup(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster1", PlatformType: "VMs", AgentsList: []string{"spiffe://example.org/agent1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT backup [Backup]
+	backupPath := t.TempDir() + "/backup-db"
+	backupper, ok := db.(Backupper)
+	if !ok {
+		t.Fatal("LocalSqliteDb should implement Backupper")
+	}
+	err = backupper.Backup(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK backup holds the data [Backup]
+	restored, err := NewLocalSqliteDB("sqlite3", backupPath, expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := restored.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters.Clusters) != 1 || clusters.Clusters[0].Name != "cluster1" || len(clusters.Clusters[0].AgentsList) != 1 {
+		t.Fatalf("Unexpected clusters in backup: %+v", clusters.Clusters)
+	}
+
+	// CHECK existing files are not overwritten [Backup]
+	err = backupper.Backup(backupPath)
+	if err == nil {
+		t.Fatal("Backup should not overwrite existing file")
+	}
+}
+
+// TestOwnershipTransfer checks clusters and entries are moved between teams in bulk with audit records
+// uses NewLocalSqliteDB, db.CreateClusterEntry, db.SetEntryOwner, db.GetEntryOwners, db.TransferOwnership,
+// db.GetOwnershipTransfers, db.GetClusters
+func TestOwnershipTransfer(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cinfo := range []types.ClusterInfo{
+		{Name: "cluster1", PlatformType: "k8s", OwnerTeam: "payments", Tenant: "retail"},
+		{Name: "cluster2", PlatformType: "k8s", OwnerTeam: "payments"},
+		{Name: "cluster3", PlatformType: "k8s", OwnerTeam: "search"},
+	} {
+		if err = db.CreateClusterEntry(cinfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// ATTEMPT assigning entry owners [SetEntryOwner]
+	err = db.SetEntryOwner(types.EntryOwner{EntryId: "entry1", OwnerTeam: "payments", Tenant: "retail", UpdatedAt: "2024-02-08T21:02:10Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.SetEntryOwner(types.EntryOwner{EntryId: "entry2", OwnerTeam: "search", UpdatedAt: "2024-02-08T21:02:10Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.SetEntryOwner(types.EntryOwner{EntryId: "entry1", OwnerTeam: "search"})
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure for entry with owner, got %v", err)
+	}
+
+	// ATTEMPT transferring cluster not owned by team [TransferOwnership]
+	_, err = db.TransferOwnership(types.OwnershipTransfer{FromTeam: "payments", ToTeam: "checkout", Clusters: []string{"cluster1", "cluster3"}})
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure for cluster of other team, got %v", err)
+	}
+	// CHECK nothing moved [GetClusters]
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clusters.Clusters {
+		if c.OwnerTeam == "checkout" {
+			t.Fatalf("Expected failed transfer to move nothing, got %+v", c)
+		}
+	}
+
+	// ATTEMPT transferring all objects of team [TransferOwnership]
+	result, err := db.TransferOwnership(types.OwnershipTransfer{
+		FromTeam:      "payments",
+		ToTeam:        "checkout",
+		ToTenant:      "commerce",
+		Reason:        "reorg",
+		TransferredBy: "admin",
+		TransferTime:  "2024-03-01T10:00:00Z",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Transferred) != 3 {
+		t.Fatalf("Expected 2 clusters and 1 entry transferred, got %+v", result.Transferred)
+	}
+
+	// CHECK owners updated [GetClusters, GetEntryOwners]
+	clusters, err = db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clusters.Clusters {
+		expectedTeam, expectedTenant := "checkout", "commerce"
+		if c.Name == "cluster3" {
+			expectedTeam, expectedTenant = "search", ""
+		}
+		if c.OwnerTeam != expectedTeam || c.Tenant != expectedTenant {
+			t.Fatalf("Expected cluster %s owned by %s/%s, got %s/%s", c.Name, expectedTeam, expectedTenant, c.OwnerTeam, c.Tenant)
+		}
+	}
+	owners, err := db.GetEntryOwners("checkout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOwner := types.EntryOwner{EntryId: "entry1", OwnerTeam: "checkout", Tenant: "commerce", UpdatedAt: "2024-03-01T10:00:00Z"}
+	if len(owners.Entries) != 1 || owners.Entries[0] != expectedOwner {
+		t.Fatalf("Expected entry owners [%v], got %v", expectedOwner, owners.Entries)
+	}
+
+	// CHECK audit records [GetOwnershipTransfers]
+	records, err := db.GetOwnershipTransfers(types.ListOptions{
+		Filters: []types.Filter{{Field: "objectType", Value: types.OwnedObjectCluster}},
+		Sort:    []types.SortField{{Field: "objectId"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedRecord := types.OwnershipTransferRecord{
+		ObjectType:    types.OwnedObjectCluster,
+		ObjectId:      "cluster1",
+		FromTeam:      "payments",
+		ToTeam:        "checkout",
+		FromTenant:    "retail",
+		ToTenant:      "commerce",
+		Reason:        "reorg",
+		TransferredBy: "admin",
+		TransferTime:  "2024-03-01T10:00:00Z",
+	}
+	if records.Total != 2 || records.Items[0] != expectedRecord {
+		t.Fatalf("Expected 2 cluster records starting with %v, got %+v", expectedRecord, records)
+	}
+
+	// ATTEMPT transferring single entry [TransferOwnership]
+	result, err = db.TransferOwnership(types.OwnershipTransfer{FromTeam: "search", ToTeam: "checkout", Entries: []string{"entry2", "entry2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Transferred) != 1 || result.Transferred[0].ObjectId != "entry2" {
+		t.Fatalf("Expected entry2 transferred once, got %+v", result.Transferred)
+	}
+	// CHECK entry moved off team [GetEntryOwners]
+	owners, err = db.GetEntryOwners("search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owners.Entries) != 0 {
+		t.Fatalf("Expected no entries owned by search, got %v", owners.Entries)
+	}
+}
+
+// TestBundleFreshness checks bundle check results are replaced per trust domain and deleted
+// uses NewLocalSqliteDB, db.SetBundleFreshness, db.GetBundleFreshness, db.DeleteBundleFreshness
+func TestBundleFreshness(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT storing check results [SetBundleFreshness]
+	status := types.BundleFreshness{
+		TrustDomain:       "example.org",
+		BundleEndpointURL: "https://example.org:8443",
+		Profile:           types.BundleEndpointProfileHTTPSSPIFFE,
+		Error:             "connection refused",
+		LocalSequence:     3,
+		EndpointSequence:  4,
+		LocalExpiresAt:    "2024-04-01T00:00:00Z",
+		MonitoredSince:    "2024-03-01T10:00:00Z",
+		CheckedAt:         "2024-03-01T12:00:00Z",
+		LastReachableAt:   "2024-03-01T10:00:00Z",
+		LastInSyncAt:      "2024-03-01T10:00:00Z",
+		Stale:             true,
+		StaleReason:       "bundle endpoint unreachable since 2024-03-01T10:00:00Z",
+	}
+	if err = db.SetBundleFreshness(types.BundleFreshness{TrustDomain: "example.org", Reachable: true, InSync: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SetBundleFreshness(status); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SetBundleFreshness(types.BundleFreshness{TrustDomain: "other.org", Reachable: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK last result of each trust domain returned [GetBundleFreshness]
+	list, err := db.GetBundleFreshness()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.TrustDomains) != 2 || list.TrustDomains[0] != status {
+		t.Fatalf("Expected results starting with %+v, got %+v", status, list.TrustDomains)
+	}
+
+	// ATTEMPT deleting result [DeleteBundleFreshness]
+	if err = db.DeleteBundleFreshness("example.org"); err != nil {
+		t.Fatal(err)
+	}
+	list, err = db.GetBundleFreshness()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.TrustDomains) != 1 || list.TrustDomains[0].TrustDomain != "other.org" {
+		t.Fatalf("Expected only result of other.org, got %+v", list.TrustDomains)
+	}
+}
+
+// TestLabelOperations checks labels are stored with clusters and agents and
+// changed in bulk, with previews leaving them unchanged
+// uses CreateClusterEntry, CreateAgentEntry, ApplyLabelOperation, GetClusters, GetAgentsMetadata
+func TestLabelOperations(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cinfo := range []types.ClusterInfo{
+		{Name: "cluster1", PlatformType: "k8s", Labels: map[string]string{"env": "production", "region": "east"}},
+		{Name: "cluster2", PlatformType: "k8s", Labels: map[string]string{"env": "production"}},
+		{Name: "cluster3", PlatformType: "k8s", Labels: map[string]string{"env": "staging"}},
+	} {
+		if err = db.CreateClusterEntry(cinfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, spiffeid := range []string{"spiffe://example.org/agent1", "spiffe://example.org/agent2"} {
+		if err = db.CreateAgentEntry(types.AgentInfo{Spiffeid: spiffeid, Plugin: "Docker"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clusterLabels := func() map[string]map[string]string {
+		clusters, err := db.GetClusters()
+		if err != nil {
+			t.Fatal(err)
+		}
+		labels := map[string]map[string]string{}
+		for _, c := range clusters.Clusters {
+			labels[c.Name] = c.Labels
+		}
+		return labels
+	}
+	// CHECK labels stored with clusters [GetClusters]
+	if labels := clusterLabels(); labels["cluster1"]["region"] != "east" || labels["cluster3"]["env"] != "staging" {
+		t.Fatalf("Expected cluster labels to be stored, got %v", labels)
+	}
+
+	rename := types.LabelOperation{
+		Target:   types.LabelTargetClusters,
+		Action:   types.LabelActionRename,
+		Key:      "env",
+		Value:    "production",
+		NewValue: "prod",
+		DryRun:   true,
+	}
+	// ATTEMPT previewing rename of env:production to env:prod [ApplyLabelOperation]
+	result, err := db.ApplyLabelOperation(rename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK changes previewed but not applied [ApplyLabelOperation, GetClusters]
+	if !result.DryRun || result.Matched != 3 || len(result.Changes) != 2 || result.Changes[0].Name != "cluster1" ||
+		result.Changes[0].After["env"] != "prod" || result.Changes[0].After["region"] != "east" {
+		t.Fatalf("Expected preview of 2 changes, got %+v", result)
+	}
+	if labels := clusterLabels(); labels["cluster1"]["env"] != "production" {
+		t.Fatalf("Expected preview to leave labels unchanged, got %v", labels)
+	}
+
+	// ATTEMPT rename of env:production to env:prod [ApplyLabelOperation]
+	rename.DryRun = false
+	if _, err = db.ApplyLabelOperation(rename); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK labels renamed, other labels unchanged [GetClusters]
+	labels := clusterLabels()
+	if labels["cluster1"]["env"] != "prod" || labels["cluster1"]["region"] != "east" ||
+		labels["cluster2"]["env"] != "prod" || labels["cluster3"]["env"] != "staging" {
+		t.Fatalf("Expected env:production renamed to env:prod, got %v", labels)
+	}
+
+	// ATTEMPT removing label from clusters matching a filter [ApplyLabelOperation]
+	result, err = db.ApplyLabelOperation(types.LabelOperation{
+		Target: types.LabelTargetClusters,
+		Filter: types.LabelFilter{Labels: map[string]string{"region": "east"}},
+		Action: types.LabelActionRemove,
+		Key:    "env",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK only the matching cluster changed [GetClusters]
+	labels = clusterLabels()
+	if result.Matched != 1 || labels["cluster1"]["env"] != "" || labels["cluster2"]["env"] != "prod" {
+		t.Fatalf("Expected env removed from cluster1 only, got %+v, %v", result, labels)
+	}
+
+	// ATTEMPT adding label to named agents [ApplyLabelOperation]
+	result, err = db.ApplyLabelOperation(types.LabelOperation{
+		Target: types.LabelTargetAgents,
+		Filter: types.LabelFilter{Names: []string{"spiffe://example.org/agent2"}},
+		Action: types.LabelActionAdd,
+		Key:    "env",
+		Value:  "prod",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK label returned with agent metadata [GetAgentsMetadata]
+	agents, err := db.GetAgentsMetadata(types.AgentMetadataRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, agent := range agents.Agents {
+		if want := agent.Spiffeid == "spiffe://example.org/agent2"; (agent.Labels["env"] == "prod") != want {
+			t.Fatalf("Expected env:prod on agent2 only, got %+v", agents.Agents)
+		}
+	}
+	if len(result.Changes) != 1 {
+		t.Fatalf("Expected one agent changed, got %+v", result)
+	}
+
+	// ATTEMPT deleting cluster with labels [DeleteClusterEntry]
+	if err = db.DeleteClusterEntry("cluster2"); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK recreated cluster has no labels [CreateClusterEntry, GetClusters]
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster2", PlatformType: "k8s"}); err != nil {
+		t.Fatal(err)
+	}
+	if labels := clusterLabels(); len(labels["cluster2"]) != 0 {
+		t.Fatalf("Expected labels to be deleted with cluster, got %v", labels["cluster2"])
+	}
+}
+
+// TestClusterEditDiff checks cluster edits return the fields changed from the stored cluster
+// uses NewLocalSqliteDB, db.CreateClusterEntry, db.EditClusterEntry
+func TestClusterEditDiff(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cinfo := types.ClusterInfo{
+		Name:         "cluster1",
+		PlatformType: "k8s",
+		OwnerTeam:    "platform",
+		AgentsList:   []string{"spiffe://example.org/agent1", "spiffe://example.org/agent2"},
+		Labels:       map[string]string{"env": "dev"},
+	}
+	if err = db.CreateClusterEntry(cinfo); err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT edit without changes [EditClusterEntry]
+	cinfo.EditedName = cinfo.Name
+	cinfo.AgentsList = []string{"spiffe://example.org/agent2", "spiffe://example.org/agent1"}
+	result, err := db.EditClusterEntry(cinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK no changes are returned
+	if result.Name != "cluster1" || len(result.Changes) != 0 {
+		t.Fatalf("Expected no changes of cluster1, got %+v", result)
+	}
+
+	// ATTEMPT rename with changed owner, agents and labels [EditClusterEntry]
+	cinfo.EditedName = "cluster2"
+	cinfo.OwnerTeam = "payments"
+	cinfo.AgentsList = []string{"spiffe://example.org/agent1"}
+	cinfo.Labels = map[string]string{"env": "prod"}
+	result, err = db.EditClusterEntry(cinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK changed fields are returned with their values before and after the edit
+	expected := []types.FieldChange{
+		{Field: "name", Before: "cluster1", After: "cluster2"},
+		{Field: "ownerTeam", Before: "platform", After: "payments"},
+		{Field: "agentsList", Before: []string{"spiffe://example.org/agent1", "spiffe://example.org/agent2"}, After: []string{"spiffe://example.org/agent1"}},
+		{Field: "labels", Before: map[string]string{"env": "dev"}, After: map[string]string{"env": "prod"}},
+	}
+	if result.Name != "cluster2" || !reflect.DeepEqual(result.Changes, expected) {
+		t.Fatalf("Expected changes %+v of cluster2, got %+v", expected, result)
+	}
+
+	// ATTEMPT edit of missing cluster [EditClusterEntry]
+	_, err = db.EditClusterEntry(cinfo)
+	// CHECK PostFailure is returned
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure on edit of missing cluster, got %v", err)
+	}
+}
+
+// TestCollation checks cluster and agent lists are ordered by the configured collation
+// uses NewLocalSqliteDBWithOptions, db.CreateClusterEntry, db.GetClusters, db.GetAgentsMetadata
+func TestCollation(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+
+	// ATTEMPT opening DB with invalid collation [NewLocalSqliteDBWithOptions]
+	_, err := NewLocalSqliteDBWithOptions("sqlite3", "./local-agentstest-db", expBackoff, SqliteOptions{Collation: "icu"})
+	if err == nil {
+		t.Fatal("Expected error on invalid collation")
+	}
+
+	db, err := NewLocalSqliteDBWithOptions("sqlite3", "./local-agentstest-db", expBackoff, SqliteOptions{Collation: "nocase"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"beta", "Alpha", "alpha", "Gamma"} {
+		cinfo := types.ClusterInfo{
+			Name:         name,
+			PlatformType: "k8s",
+			AgentsList:   []string{"spiffe://example.org/" + name + "/b", "spiffe://example.org/" + name + "/A"},
+		}
+		if err = db.CreateClusterEntry(cinfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// CHECK clusters are ordered ignoring case, ties in byte order [GetClusters]
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, c := range clusters.Clusters {
+		names = append(names, c.Name)
+	}
+	if strings.Join(names, ",") != "Alpha,alpha,beta,Gamma" {
+		t.Fatalf("Expected clusters ordered ignoring case, got %v", names)
+	}
+	if agents := clusters.Clusters[0].AgentsList; agents[0] != "spiffe://example.org/Alpha/A" {
+		t.Fatalf("Expected cluster agents ordered ignoring case, got %v", agents)
+	}
+
+	// CHECK agents are ordered ignoring case [GetAgentsMetadata]
+	agents, err := db.GetAgentsMetadata(types.AgentMetadataRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, a := range agents.Agents {
+		ids = append(ids, a.Spiffeid)
+	}
+	if ids[0] != "spiffe://example.org/Alpha/A" || ids[len(ids)-1] != "spiffe://example.org/Gamma/b" {
+		t.Fatalf("Expected agents ordered ignoring case, got %v", ids)
+	}
+
+	// CHECK pages of clusters are sorted and cut by sqlite under the collation [GetClustersPage]
+	for _, tc := range []struct {
+		sort     []types.SortField
+		expected []string
+	}{
+		{nil, []string{"alpha", "beta"}},
+		{[]types.SortField{{Field: "name", Desc: true}}, []string{"beta", "alpha"}},
+		{[]types.SortField{{Field: "platformType"}}, []string{"alpha", "beta"}},
+	} {
+		page, err := db.GetClustersPage(types.ListOptions{Limit: 2, Cursor: types.EncodeCursor(1), Sort: tc.sort})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = []string{}
+		for _, c := range page.Items {
+			names = append(names, c.Name)
+		}
+		if !reflect.DeepEqual(names, tc.expected) || page.Total != 4 {
+			t.Fatalf("Expected clusters %v of 4 sorted on %v, got %v of %d", tc.expected, tc.sort, names, page.Total)
+		}
+	}
+
+	// CHECK pages of agents are sorted and cut under the collation [GetAgentsMetadata, GetClusterAgentsPage, FindAgentsByPattern]
+	agents, err = db.GetAgentsMetadata(types.AgentMetadataRequest{Limit: 2, Cursor: types.EncodeCursor(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents.Agents) != 2 || agents.Agents[0].Spiffeid != "spiffe://example.org/alpha/A" || agents.Total != 8 {
+		t.Fatalf("Expected second page of agents ordered ignoring case, got %+v", agents)
+	}
+	clusterAgents, err := db.GetClusterAgentsPage("beta", types.ListOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(clusterAgents.Items, []string{"spiffe://example.org/beta/A"}) || clusterAgents.Total != 2 {
+		t.Fatalf("Expected first agent of beta ordered ignoring case, got %+v", clusterAgents)
+	}
+	if _, err = db.GetClusterAgentsPage("missing", types.ListOptions{}); err == nil {
+		t.Fatal("Expected error on agents of a missing cluster")
+	}
+	found, err := db.FindAgentsByPattern("spiffe://example.org/*", types.ListOptions{Limit: 3, Sort: []types.SortField{{Field: "spiffeid", Desc: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"spiffe://example.org/Gamma/b", "spiffe://example.org/Gamma/A", "spiffe://example.org/beta/b"}
+	if !reflect.DeepEqual(found.Items, expected) || found.Total != 8 {
+		t.Fatalf("Expected agents %v, got %+v", expected, found)
+	}
+
+	// CHECK the unicode collation sorts by the rules of its locale [GetClustersPage]
+	cleanup()
+	db, err = NewLocalSqliteDBWithOptions("sqlite3", "./local-agentstest-db", expBackoff, SqliteOptions{Collation: "unicode", Locale: "sv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"öst", "zeta", "oslo"} {
+		if err = db.CreateClusterEntry(types.ClusterInfo{Name: name, PlatformType: "k8s"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page, err := db.GetClustersPage(types.ListOptions{Limit: 2, Cursor: types.EncodeCursor(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 2 || page.Items[0].Name != "zeta" || page.Items[1].Name != "öst" {
+		t.Fatalf("Expected ö after z in Swedish, got %+v", page.Items)
+	}
+}
+
+// TestTxStats checks commits and rollbacks are counted by operation, rollback cause and PostFailure category
+// uses NewLocalSqliteDB, db.CreateClusterEntry, db.EditClusterEntry, db.GetTxStats
+func TestTxStats(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT committed and rolled back transactions [CreateClusterEntry, EditClusterEntry]
+	cinfo := types.ClusterInfo{Name: "cluster1", PlatformType: "k8s", AgentsList: []string{"agent1"}}
+	if err = db.CreateClusterEntry(cinfo); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterEntry(cinfo); err == nil {
+		t.Fatal("Expected error on duplicate cluster")
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster2", PlatformType: "k8s", AgentsList: []string{"agent1"}}); err == nil {
+		t.Fatal("Expected error on agent of another cluster")
+	}
+	if _, err = db.EditClusterEntry(types.ClusterInfo{Name: "missing", EditedName: "missing", PlatformType: "k8s"}); err == nil {
+		t.Fatal("Expected error on edit of missing cluster")
+	}
+
+	// CHECK outcomes are counted by operation [GetTxStats]
+	stats := db.GetTxStats()
+	expected := []types.TxOperationStats{
+		{Operation: "createClusterEntry", Commits: 1, Rollbacks: map[string]int64{types.RollbackCauseConstraint: 2},
+			PostFailures: map[string]int64{types.PostFailureClusterExists: 1, types.PostFailureAgentAlreadyAssigned: 1}},
+		{Operation: "editClusterEntry", Rollbacks: map[string]int64{types.RollbackCauseConstraint: 1},
+			PostFailures: map[string]int64{types.PostFailureClusterMissing: 1}},
+	}
+	if !reflect.DeepEqual(stats.Operations, expected) {
+		t.Fatalf("Expected transaction stats %+v, got %+v", expected, stats.Operations)
+	}
+	// CHECK PostFailures are totaled by category [GetTxStats]
+	expectedPostFailures := map[string]int64{types.PostFailureClusterExists: 1, types.PostFailureClusterMissing: 1, types.PostFailureAgentAlreadyAssigned: 1}
+	if !reflect.DeepEqual(stats.PostFailures, expectedPostFailures) {
+		t.Fatalf("Expected PostFailures %v, got %v", expectedPostFailures, stats.PostFailures)
+	}
+	// CHECK PostFailures without category are other [PostFailureCategory]
+	if category, ok := PostFailureCategory(errors.Wrap(PostFailure{Message: "No agents to move"}, "move")); !ok || category != types.PostFailureOther {
+		t.Fatalf("Expected category %s, got %q", types.PostFailureOther, category)
+	}
+	if _, ok := PostFailureCategory(errors.New("Invalid value")); ok {
+		t.Fatal("Expected no category of an error that is not a PostFailure")
+	}
+
+	// CHECK rollback causes are classified [classifyRollback]
+	causes := map[string]error{
+		types.RollbackCauseCanceled:   SQLError{"SELECT 1", context.Canceled},
+		types.RollbackCauseBusy:       SQLError{"SELECT 1", sqlite3.Error{Code: sqlite3.ErrBusy}},
+		types.RollbackCauseDependency: errors.Wrap(status.Error(codes.Unavailable, "SPIRE server unavailable"), "could not list entries"),
+		types.RollbackCauseOther:      errors.New("Invalid value"),
+	}
+	for cause, err := range causes {
+		if got := classifyRollback(err); got != cause {
+			t.Fatalf("Expected cause %s of %v, got %s", cause, err, got)
+		}
+	}
+}
+
+// TestBootstrapTokens checks issued join tokens are tracked by hash until consumed or expired
+// uses NewLocalSqliteDB, db.CreateBootstrapToken, db.ConsumeBootstrapToken, db.ExpireBootstrapTokens, db.GetBootstrapTokens
+func TestBootstrapTokens(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT store issued tokens [CreateBootstrapToken]
+	tokenA := types.BootstrapToken{ID: "a", AgentID: "spiffe://example.org/node/a", State: types.BootstrapTokenIssued,
+		IssuedBy: "provisioner", IssuedAt: "2024-03-01T12:00:00Z", ExpiresAt: "2024-03-01T12:10:00Z"}
+	tokenB := types.BootstrapToken{ID: "b", State: types.BootstrapTokenIssued,
+		IssuedAt: "2024-03-01T12:01:00Z", ExpiresAt: "2024-03-01T12:30:00Z"}
+	if err = db.CreateBootstrapToken(tokenA, "hash-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateBootstrapToken(tokenB, "hash-b"); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK duplicates are rejected
+	if err = db.CreateBootstrapToken(tokenB, "hash-b"); err == nil {
+		t.Fatal("Expected error on duplicate token")
+	} else if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure, got %v", err)
+	}
+
+	// ATTEMPT consume tokens [ConsumeBootstrapToken]
+	consumed, err := db.ConsumeBootstrapToken("hash-a", "spiffe://example.org/spire/agent/join_token/a", "2024-03-01T12:05:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK only tracked tokens not consumed yet are reported
+	if !consumed {
+		t.Fatal("Expected token a to be consumed")
+	}
+	for _, hash := range []string{"hash-a", "hash-unknown"} {
+		consumed, err = db.ConsumeBootstrapToken(hash, "spiffe://example.org/spire/agent/join_token/x", "2024-03-01T12:06:00Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if consumed {
+			t.Fatalf("Expected %s not to be consumed again", hash)
+		}
+	}
+
+	// ATTEMPT expire tokens past the expiry of both [ExpireBootstrapTokens]
+	expired, err := db.ExpireBootstrapTokens("2024-03-01T12:30:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK only the unused token expired
+	if expired != 1 {
+		t.Fatalf("Expected 1 expired token, got %d", expired)
+	}
+
+	// CHECK records, most recent first [GetBootstrapTokens]
+	tokenA.State, tokenA.ConsumedAt, tokenA.ConsumedBy = types.BootstrapTokenConsumed, "2024-03-01T12:05:00Z", "spiffe://example.org/spire/agent/join_token/a"
+	tokenB.State = types.BootstrapTokenExpired
+	list, err := db.GetBootstrapTokens("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list.Tokens, []types.BootstrapToken{tokenB, tokenA}) {
+		t.Fatalf("Unexpected tokens %+v", list.Tokens)
+	}
+	list, err = db.GetBootstrapTokens(types.BootstrapTokenConsumed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list.Tokens, []types.BootstrapToken{tokenA}) {
+		t.Fatalf("Unexpected consumed tokens %+v", list.Tokens)
+	}
+}
+
+// TestEntryLifecycles checks lifecycle states are replaced, listed by state with
+// scheduled removals first, and that only scheduled entries are marked removed
+// uses NewLocalSqliteDB, db.SetEntryLifecycle, db.GetEntryLifecycles,
+// db.MarkEntryLifecycleNotified, db.MarkEntryRemoved
+func TestEntryLifecycles(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT set lifecycle states [SetEntryLifecycle]
+	deprecated := types.EntryLifecycle{EntryId: "a", State: types.EntryLifecycleDeprecated, Reason: "replaced by b",
+		UpdatedBy: "alice", UpdatedAt: "2024-03-01T12:00:00Z"}
+	scheduled := types.EntryLifecycle{EntryId: "b", State: types.EntryLifecycleScheduledForRemoval,
+		RemoveAt: "2024-04-01T00:00:00Z", UpdatedBy: "bob", UpdatedAt: "2024-03-01T12:01:00Z"}
+	for _, l := range []types.EntryLifecycle{deprecated, scheduled} {
+		if err = db.SetEntryLifecycle(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// CHECK scheduled removals are listed first [GetEntryLifecycles]
+	list, err := db.GetEntryLifecycles("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list.Entries, []types.EntryLifecycle{scheduled, deprecated}) {
+		t.Fatalf("Unexpected lifecycles %+v", list.Entries)
+	}
+
+	// ATTEMPT record the notice, then reschedule [MarkEntryLifecycleNotified, SetEntryLifecycle]
+	if err = db.MarkEntryLifecycleNotified("b", "2024-03-25T00:00:00Z"); err != nil {
+		t.Fatal(err)
+	}
+	list, err = db.GetEntryLifecycles(types.EntryLifecycleScheduledForRemoval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Entries) != 1 || list.Entries[0].NotifiedAt != "2024-03-25T00:00:00Z" {
+		t.Fatalf("Expected the notice time of entry b, got %+v", list.Entries)
+	}
+	scheduled.RemoveAt = "2024-05-01T00:00:00Z"
+	if err = db.SetEntryLifecycle(scheduled); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK rescheduled removals are notified again
+	list, err = db.GetEntryLifecycles(types.EntryLifecycleScheduledForRemoval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list.Entries, []types.EntryLifecycle{scheduled}) {
+		t.Fatalf("Unexpected scheduled lifecycles %+v", list.Entries)
+	}
+
+	// ATTEMPT mark entries removed [MarkEntryRemoved]
+	for entryId, expected := range map[string]bool{"a": false, "b": true, "unknown": false} {
+		removed, err := db.MarkEntryRemoved(entryId, "2024-05-01T00:01:00Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed != expected {
+			t.Fatalf("Expected entry %s removed %v, got %v", entryId, expected, removed)
+		}
+	}
+	list, err = db.GetEntryLifecycles(types.EntryLifecycleRemoved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Entries) != 1 || list.Entries[0].EntryId != "b" || list.Entries[0].UpdatedAt != "2024-05-01T00:01:00Z" {
+		t.Fatalf("Expected entry b removed, got %+v", list.Entries)
+	}
+}
+
+// TestClusterTokens checks clusters keep their UID across renames and cluster tokens are revoked with their cluster
+// uses NewLocalSqliteDB, db.CreateClusterEntry, db.EditClusterEntry, db.GetClusterNameByUID, db.CreateClusterToken,
+// db.GetClusterTokenByKeyHash, db.GetClusterTokens, db.DeleteClusterToken, db.DeleteClusterEntry
+func TestClusterTokens(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+
+	// create a cluster as in older versions without uid column
+	database, err := sql.Open("sqlite3", "./local-agentstest-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = database.Exec(`CREATE TABLE clusters (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, created_at TEXT, 
+                            domain_name TEXT, platform_type TEXT, managed_by TEXT, UNIQUE (name))`)
+	if err == nil {
+		_, err = database.Exec(`INSERT INTO clusters (name, created_at, domain_name, platform_type, managed_by) 
+                            VALUES ('cluster1', '', '', 'k8s', '')`)
+	}
+	database.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT create and rename clusters [CreateClusterEntry, EditClusterEntry]
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster2", PlatformType: "k8s"}); err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK existing and new clusters have distinct UIDs
+	uid := clusters.Clusters[0].UID
+	if len(uid) != 32 || len(clusters.Clusters[1].UID) != 32 || uid == clusters.Clusters[1].UID {
+		t.Fatalf("Expected distinct cluster UIDs, got %q and %q", uid, clusters.Clusters[1].UID)
+	}
+	if _, err = db.EditClusterEntry(types.ClusterInfo{Name: "cluster1", EditedName: "renamed", PlatformType: "k8s"}); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK the UID follows the rename [GetClusterNameByUID]
+	name, err := db.GetClusterNameByUID(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "renamed" {
+		t.Fatalf("Expected cluster renamed, got %s", name)
+	}
+	if _, err = db.GetClusterNameByUID("missing"); err == nil {
+		t.Fatal("Expected error on missing cluster UID")
+	} else if _, ok := err.(GetError); !ok {
+		t.Fatalf("Expected GetError, got %v", err)
+	}
+
+	// ATTEMPT store cluster tokens [CreateClusterToken]
+	token1 := types.ClusterToken{Name: "operator1", ClusterUID: uid, Access: types.ClusterTokenWrite, CreationTime: "2024-02-08T21:02:10Z"}
+	token2 := types.ClusterToken{Name: "operator2", ClusterUID: clusters.Clusters[1].UID, Access: types.ClusterTokenRead}
+	if err = db.CreateClusterToken(token1, "hash1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterToken(token2, "hash2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterToken(token1, "hash3"); err == nil {
+		t.Fatal("Expected error on duplicate cluster token")
+	}
+	// CHECK tokens are found by key hash [GetClusterTokenByKeyHash, GetClusterTokens]
+	got, err := db.GetClusterTokenByKeyHash("hash1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != token1 {
+		t.Fatalf("Expected token %+v, got %+v", token1, got)
+	}
+	list, err := db.GetClusterTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list.ClusterTokens, []types.ClusterToken{token1, token2}) {
+		t.Fatalf("Unexpected cluster tokens %+v", list.ClusterTokens)
+	}
+
+	// ATTEMPT revoke tokens [DeleteClusterToken, DeleteClusterEntry]
+	if err = db.DeleteClusterToken("operator2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteClusterToken("operator2"); err == nil {
+		t.Fatal("Expected error on deleting missing cluster token")
+	}
+	if err = db.DeleteClusterEntry("renamed"); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK no token is left
+	if _, err = db.GetClusterTokenByKeyHash("hash1"); err == nil {
+		t.Fatal("Expected token of deleted cluster to be revoked")
+	}
+	list, err = db.GetClusterTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.ClusterTokens) != 0 {
+		t.Fatalf("Expected no cluster tokens, got %+v", list.ClusterTokens)
+	}
+}
+
+// TestFailedOperations checks partially failed operations are stored and updated by ID
+// uses NewLocalSqliteDB, db.AddFailedOperation, db.GetFailedOperation, db.GetFailedOperations, db.UpdateFailedOperation
+func TestFailedOperations(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT store failed operations [AddFailedOperation]
+	op1 := types.FailedOperation{Operation: "clone_entry", Step: "record_entry_lineage", Payload: `{"entryId":"e1"}`,
+		State: types.FailedOperationPending, Attempts: 1, LastError: "database is locked", CreatedBy: "admin",
+		CreatedAt: "2024-03-01T12:00:00Z", UpdatedAt: "2024-03-01T12:00:00Z"}
+	op2 := op1
+	op2.Payload = `{"entryId":"e2"}`
+	op1.ID, err = db.AddFailedOperation(op1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op2.ID, err = db.AddFailedOperation(op2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK operations get increasing IDs
+	if op1.ID == 0 || op2.ID <= op1.ID {
+		t.Fatalf("Unexpected IDs %d and %d", op1.ID, op2.ID)
+	}
+
+	// ATTEMPT update an operation [UpdateFailedOperation]
+	op2.State, op2.Attempts, op2.UpdatedAt = types.FailedOperationStuck, 10, "2024-03-01T12:10:00Z"
+	if err = db.UpdateFailedOperation(op2); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.UpdateFailedOperation(types.FailedOperation{ID: 1000}); err == nil {
+		t.Fatal("Expected error on updating missing operation")
+	} else if _, ok := err.(GetError); !ok {
+		t.Fatalf("Expected GetError, got %v", err)
+	}
+
+	// CHECK operations are returned by ID and state [GetFailedOperation, GetFailedOperations]
+	got, err := db.GetFailedOperation(op2.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != op2 {
+		t.Fatalf("Expected operation %+v, got %+v", op2, got)
+	}
+	if _, err = db.GetFailedOperation(1000); err == nil {
+		t.Fatal("Expected error on getting missing operation")
+	} else if _, ok := err.(GetError); !ok {
+		t.Fatalf("Expected GetError, got %v", err)
+	}
+	for state, expected := range map[string][]types.FailedOperation{
+		"":                            {op1, op2},
+		types.FailedOperationPending:  {op1},
+		types.FailedOperationStuck:    {op2},
+		types.FailedOperationResolved: {},
+	} {
+		list, err := db.GetFailedOperations(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(list.Operations, expected) {
+			t.Fatalf("Expected operations %+v in state %q, got %+v", expected, state, list.Operations)
+		}
+	}
+}
+
+// TestClusterHistory checks past states of clusters are reconstructed from their history,
+// including clusters created before the history was kept
+// uses NewLocalSqliteDBWithOptions, db.CreateClusterEntry, db.EditClusterEntry, db.DeleteClusterEntry,
+// db.ApplyLabelOperation, db.GetClustersAsOf, db.GetClusterChanges
+func TestClusterHistory(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	// history times have a resolution of a second, changes are dated by a fake clock
+	fake := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	agentDB, err := NewLocalSqliteDBWithOptions("sqlite3", "./local-agentstest-db", expBackoff, SqliteOptions{Clock: fake})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := agentDB.(*LocalSqliteDb)
+	setNow := func(now string) {
+		nowTime, err := time.Parse(time.RFC3339, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fake.Set(nowTime)
+	}
+	names := func(asOf string) []string {
+		list, err := db.GetClustersAsOf(asOf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := []string{}
+		for _, c := range list.Clusters {
+			ret = append(ret, c.Name+":"+strings.Join(c.AgentsList, ","))
+		}
+		return ret
+	}
+
+	// ATTEMPT create, edit and delete clusters [CreateClusterEntry, EditClusterEntry, DeleteClusterEntry]
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster1", PlatformType: "k8s", AgentsList: []string{"agent1"}}); err != nil {
+		t.Fatal(err)
+	}
+	setNow("2024-01-02T00:00:00Z")
+	if _, err = db.EditClusterEntry(types.ClusterInfo{Name: "cluster1", EditedName: "renamed", PlatformType: "k8s",
+		AgentsList: []string{"agent1", "agent2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster2", PlatformType: "vm"}); err != nil {
+		t.Fatal(err)
+	}
+	setNow("2024-01-03T00:00:00Z")
+	if err = db.DeleteClusterEntry("cluster2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK clusters and agents as of each time [GetClustersAsOf]
+	for asOf, expected := range map[string][]string{
+		"2023-12-31T00:00:00Z": {},
+		"2024-01-01T00:00:00Z": {"cluster1:agent1"},
+		"2024-01-02T12:00:00Z": {"cluster2:", "renamed:agent1,agent2"},
+		"2024-01-03T00:00:00Z": {"renamed:agent1,agent2"},
+	} {
+		if got := names(asOf); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("Expected clusters %v as of %s, got %v", expected, asOf, got)
+		}
+	}
+	// CHECK the renamed cluster keeps its UID
+	before, err := db.GetClustersAsOf("2024-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := db.GetClustersAsOf("2024-01-03T00:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Clusters[0].UID == "" || before.Clusters[0].UID != after.Clusters[0].UID {
+		t.Fatalf("Expected same UID across rename, got %q and %q", before.Clusters[0].UID, after.Clusters[0].UID)
+	}
+	// CHECK the creation time is stamped by the clock
+	if !after.Clusters[0].CreationTime.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected creation time %v", after.Clusters[0].CreationTime)
+	}
+
+	// ATTEMPT change labels in bulk [ApplyLabelOperation]
+	setNow("2024-01-04T00:00:00Z")
+	if _, err = db.ApplyLabelOperation(types.LabelOperation{Target: types.LabelTargetClusters, Action: types.LabelActionAdd,
+		Key: "env", Value: "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK labels are part of the history
+	list, err := db.GetClustersAsOf("2024-01-04T00:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Clusters) != 1 || list.Clusters[0].Labels["env"] != "prod" {
+		t.Fatalf("Expected labeled cluster, got %+v", list.Clusters)
+	}
+	if got := names("2024-01-03T00:00:00Z"); !reflect.DeepEqual(got, []string{"renamed:agent1,agent2"}) {
+		t.Fatalf("Unexpected clusters before labeling %v", got)
+	}
+
+	// CHECK recent changes, most recent first [GetClusterChanges]
+	changes, err := db.GetClusterChanges(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotChanges := []string{}
+	for _, c := range changes {
+		gotChanges = append(gotChanges, c.Name+":"+c.Change+":"+c.ChangedAt)
+	}
+	expectedChanges := []string{"renamed:updated:2024-01-04T00:00:00Z", "cluster2:deleted:2024-01-03T00:00:00Z", "cluster2:created:2024-01-02T00:00:00Z"}
+	if !reflect.DeepEqual(gotChanges, expectedChanges) {
+		t.Fatalf("Expected changes %v, got %v", expectedChanges, gotChanges)
+	}
+
+	// ATTEMPT reopen the DB with clusters without history [NewLocalSqliteDB]
+	if _, err = db.database.Exec(`DELETE FROM cluster_history`); err != nil {
+		t.Fatal(err)
+	}
+	setNow("2024-01-05T00:00:00Z")
+	agentDB, err = NewLocalSqliteDBWithOptions("sqlite3", "./local-agentstest-db", expBackoff, SqliteOptions{Clock: fake})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = agentDB.(*LocalSqliteDb)
+	// CHECK the current state is recorded from then on
+	var change string
+	if err = db.database.QueryRow(`SELECT change FROM cluster_history`).Scan(&change); err != nil {
+		t.Fatal(err)
+	}
+	if change != types.ClusterChangeRecorded {
+		t.Fatalf("Expected recorded cluster, got %q", change)
+	}
+	if got := names("2024-01-05T00:00:00Z"); !reflect.DeepEqual(got, []string{"renamed:agent1,agent2"}) {
+		t.Fatalf("Unexpected clusters after backfill %v", got)
+	}
+	if got := names("2024-01-03T00:00:00Z"); len(got) != 0 {
+		t.Fatalf("Expected no history before backfill, got %v", got)
+	}
+	if changes, err = db.GetClusterChanges(10); err != nil || len(changes) != 0 {
+		t.Fatalf("Expected recorded clusters not to be changes, got %v, %v", changes, err)
+	}
+}
+
+// TestPluginTypes checks plugin types are normalized, migrated from older versions and filterable
+// uses NewLocalSqliteDB, db.CreateAgentEntry, db.GetAgentPluginInfo, db.GetAgentsMetadata, db.GetPluginTypes
+func TestPluginTypes(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+
+	// create agents with free-form plugins as in older versions
+	database, err := sql.Open("sqlite3", "./local-agentstest-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = database.Exec(`CREATE TABLE agents (id INTEGER PRIMARY KEY AUTOINCREMENT, spiffeid TEXT, plugin TEXT, UNIQUE (spiffeid))`)
+	if err == nil {
+		_, err = database.Exec(`INSERT INTO agents (spiffeid, plugin) VALUES ('agent1', 'docker'), ('agent2', 'K8s'), 
+			('agent3', 'tpm'), ('agent4', NULL), ('agent5', ' ')`)
+	}
+	database.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT migration of plugins [NewLocalSqliteDB]
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK plugins are normalized
+	for spiffeid, expected := range map[string]string{"agent1": "Docker", "agent2": "Kubernetes", "agent3": "tpm"} {
+		sinfo, err := db.GetAgentPluginInfo(spiffeid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sinfo.Plugin != expected {
+			t.Fatalf("Expected plugin %q of %s, got %q", expected, spiffeid, sinfo.Plugin)
+		}
+	}
+	// CHECK agents without valid plugin have none
+	for _, spiffeid := range []string{"agent4", "agent5"} {
+		if _, err = db.GetAgentPluginInfo(spiffeid); err == nil {
+			t.Fatalf("Expected no plugin for %s", spiffeid)
+		}
+	}
+
+	// ATTEMPT register plugins in other spellings [CreateAgentEntry]
+	if err = db.CreateAgentEntry(types.AgentInfo{Spiffeid: "agent4", Plugin: "kubernetes"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateAgentEntry(types.AgentInfo{Spiffeid: "agent5", Plugin: "TPM"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateAgentEntry(types.AgentInfo{Spiffeid: "agent6", Plugin: strings.Repeat("a", types.MaxPluginTypeLength+1)}); err == nil {
+		t.Fatal("Expected error on too long plugin")
+	} else if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure, got %v", err)
+	}
+
+	// CHECK filter by plugin in any spelling [GetAgentsMetadata]
+	tests := map[string][]string{"k8s": {"agent2", "agent4"}, "Tpm": {"agent3", "agent5"}, "unix": {}}
+	for plugin, expected := range tests {
+		agents, err := db.GetAgentsMetadata(types.AgentMetadataRequest{Plugin: plugin})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, agent := range agents.Agents {
+			got = append(got, agent.Spiffeid)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("Expected agents %v with plugin %q, got %v", expected, plugin, got)
+		}
+	}
+
+	// CHECK known and assigned custom plugin types are listed [GetPluginTypes]
+	pluginTypes, err := db.GetPluginTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []types.PluginType{{Name: "Docker"}, {Name: "Kubernetes"}, {Name: "Systemd"}, {Name: "Unix"}, {Name: "Windows"},
+		{Name: "tpm", Custom: true}}
+	if !reflect.DeepEqual(pluginTypes.PluginTypes, expected) {
+		t.Fatalf("Expected plugin types %+v, got %+v", expected, pluginTypes.PluginTypes)
+	}
+}
+
+// TestAssignAgentsToClusters checks agents are assigned and moved by cluster UID in one transaction
+// uses NewLocalSqliteDB, db.CreateClusterEntry, db.GetClusters, db.AssignAgentsToClusters, db.GetAgentClusterName
+func TestAssignAgentsToClusters(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	agentDB, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := agentDB.(*LocalSqliteDb)
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster1", PlatformType: "k8s", AgentsList: []string{"agent1", "agent2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster2", PlatformType: "k8s"}); err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uids := make(map[string]string)
+	for _, c := range clusters.Clusters {
+		uids[c.Name] = c.UID
+	}
+	assignments := []types.AgentAssignment{
+		{Row: 2, Spiffeid: "agent1", ClusterUID: uids["cluster1"]},
+		{Row: 3, Spiffeid: "agent2", ClusterUID: uids["cluster2"]},
+		{Row: 4, Spiffeid: "agent3", ClusterUID: uids["cluster2"]},
+		{Row: 5, Spiffeid: "agent4", ClusterUID: "unknown"},
+	}
+	clusterNames := func() map[string]string {
+		names, err := db.GetAgentClusterNames([]string{"agent1", "agent2", "agent3", "agent4"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+	historyCount := func() int {
+		var count int
+		if err := db.database.QueryRow(`SELECT COUNT(*) FROM cluster_history`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	history := historyCount()
+
+	// ATTEMPT dry run [AssignAgentsToClusters]
+	result, err := db.AssignAgentsToClusters(assignments, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := types.AgentAssignmentResult{DryRun: true, Rows: 4, Assigned: 2, Unchanged: 1, Errors: []types.AgentAssignmentError{
+		{Row: 5, Spiffeid: "agent4", ClusterUID: "unknown", Code: types.BatchCodeNotFound, Error: "cluster does not exist"},
+	}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected result %+v, got %+v", expected, result)
+	}
+	// CHECK nothing is applied
+	if names := clusterNames(); !reflect.DeepEqual(names, map[string]string{"agent1": "cluster1", "agent2": "cluster1"}) {
+		t.Fatalf("Expected unchanged memberships, got %v", names)
+	}
+
+	// ATTEMPT assignments [AssignAgentsToClusters]
+	result, err = db.AssignAgentsToClusters(assignments, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected.DryRun = false
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected result %+v, got %+v", expected, result)
+	}
+	// CHECK agents are moved and added, rows with errors not applied
+	if names := clusterNames(); !reflect.DeepEqual(names, map[string]string{"agent1": "cluster1", "agent2": "cluster2", "agent3": "cluster2"}) {
+		t.Fatalf("Unexpected memberships %v", names)
+	}
+	// CHECK both clusters are recorded in history
+	if count := historyCount(); count != history+2 {
+		t.Fatalf("Expected 2 history records, got %d", count-history)
+	}
+}
+
+// TestMoveAgentsBetweenClusters checks agents are moved between clusters by UID all or none
+// uses NewLocalSqliteDB, db.CreateClusterEntry, db.GetClusters, db.MoveAgentsBetweenClusters, db.GetAgentClusterNames
+func TestMoveAgentsBetweenClusters(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	agentDB, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := agentDB.(*LocalSqliteDb)
+	for _, cinfo := range []types.ClusterInfo{
+		{Name: "cluster1", PlatformType: "k8s", AgentsList: []string{"agent1", "agent2", "agent3"}},
+		{Name: "cluster2", PlatformType: "k8s", AgentsList: []string{"agent4"}},
+		{Name: "cluster3", PlatformType: "k8s", AgentsList: []string{"agent5"}},
+	} {
+		if err = db.CreateClusterEntry(cinfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uids := make(map[string]string)
+	for _, c := range clusters.Clusters {
+		uids[c.Name] = c.UID
+	}
+	clusterNames := func() map[string]string {
+		names, err := db.GetAgentClusterNames([]string{"agent1", "agent2", "agent3", "agent4", "agent5"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+	initial := clusterNames()
+	historyCount := func() int {
+		var count int
+		if err := db.database.QueryRow(`SELECT COUNT(*) FROM cluster_history`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	history := historyCount()
+
+	// ATTEMPT moves that must fail as a whole [MoveAgentsBetweenClusters]
+	for _, tc := range []struct {
+		from, to string
+		agents   []string
+		message  string
+	}{
+		{uids["cluster1"], uids["cluster1"], []string{"agent1"}, "another cluster"},
+		{uids["cluster1"], uids["cluster2"], nil, "No agents"},
+		{uids["cluster1"], "unknown", []string{"agent1"}, "Cluster with UID unknown does not exist"},
+		{uids["cluster1"], uids["cluster2"], []string{"agent1", "agent5", "agent6"}, "Agents not in cluster cluster1: agent5, agent6"},
+	} {
+		_, err = db.MoveAgentsBetweenClusters(tc.from, tc.to, tc.agents)
+		var pf PostFailure
+		if !errors.As(err, &pf) || !strings.Contains(err.Error(), tc.message) {
+			t.Fatalf("Expected PostFailure %q, got %v", tc.message, err)
+		}
+	}
+	// CHECK nothing is applied
+	if names := clusterNames(); !reflect.DeepEqual(names, initial) {
+		t.Fatalf("Expected unchanged memberships %v, got %v", initial, names)
+	}
+	if count := historyCount(); count != history {
+		t.Fatalf("Expected no history records, got %d", count-history)
+	}
+
+	// ATTEMPT move of agents listed twice [MoveAgentsBetweenClusters]
+	result, err := db.MoveAgentsBetweenClusters(uids["cluster1"], uids["cluster2"], []string{"agent1", "agent2", "agent1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := types.AgentMoveResult{FromCluster: "cluster1", ToCluster: "cluster2", Moved: 2}
+	if result != expected {
+		t.Fatalf("Expected result %+v, got %+v", expected, result)
+	}
+	// CHECK only the agents moved changed clusters
+	expectedNames := map[string]string{"agent1": "cluster2", "agent2": "cluster2", "agent3": "cluster1", "agent4": "cluster2", "agent5": "cluster3"}
+	if names := clusterNames(); !reflect.DeepEqual(names, expectedNames) {
+		t.Fatalf("Expected memberships %v, got %v", expectedNames, names)
+	}
+	// CHECK both clusters are recorded in history
+	if count := historyCount(); count != history+2 {
+		t.Fatalf("Expected 2 history records, got %d", count-history)
+	}
+}
+
+// TestClusterCreateAgentConflicts checks a cluster is created with all of its agents or not at all,
+// naming the agents that conflict, also when clusters are created concurrently
+// uses NewLocalSqliteDB, db.CreateClusterEntry, db.GetClusters, db.GetAgentClusterName
+func TestClusterCreateAgentConflicts(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster1", PlatformType: "k8s", AgentsList: []string{"agent1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT create with an assigned and a repeated agent [CreateClusterEntry]
+	err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster2", PlatformType: "k8s", AgentsList: []string{"agent2", "agent1", "agent2"}})
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure, got %v", err)
+	}
+	// CHECK the conflicting agents are named
+	if !strings.Contains(err.Error(), "agent2 (listed more than once), agent1 (assigned to cluster cluster1)") {
+		t.Fatalf("Expected conflicting agents in error, got %v", err)
+	}
+	// CHECK neither the cluster nor its agents are stored
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters.Clusters) != 1 {
+		t.Fatalf("Expected only cluster1, got %+v", clusters.Clusters)
+	}
+	if _, err = db.GetAgentClusterName("agent2"); err == nil {
+		t.Fatal("Expected agent2 not assigned")
+	}
+
+	// ATTEMPT concurrent creates with a shared agent [CreateClusterEntry]
+	const creates = 8
+	errs := make([]error, creates)
+	var wg sync.WaitGroup
+	for i := 0; i < creates; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = db.CreateClusterEntry(types.ClusterInfo{
+				Name:         fmt.Sprintf("concurrent%d", i),
+				PlatformType: "k8s",
+				AgentsList:   []string{fmt.Sprintf("own%d", i), "shared"},
+			})
+		}(i)
+	}
+	wg.Wait()
+	// CHECK exactly one create succeeds with all of its agents, the others name the shared agent
+	winner := -1
+	for i, err := range errs {
+		if err == nil {
+			if winner >= 0 {
+				t.Fatalf("Expected one create to succeed, got %d and %d", winner, i)
+			}
+			winner = i
+			continue
+		}
+		if _, ok := err.(PostFailure); !ok || !strings.Contains(err.Error(), "shared (assigned to cluster concurrent") {
+			t.Fatalf("Expected conflict on shared agent, got %v", err)
+		}
+	}
+	if winner < 0 {
+		t.Fatal("Expected one create to succeed")
+	}
+	for i := 0; i < creates; i++ {
+		name, err := db.GetAgentClusterName(fmt.Sprintf("own%d", i))
+		if i == winner && (err != nil || name != fmt.Sprintf("concurrent%d", i)) {
+			t.Fatalf("Expected own%d in cluster concurrent%d, got %q, %v", i, i, name, err)
+		}
+		if i != winner && err == nil {
+			t.Fatalf("Expected own%d not assigned, got cluster %q", i, name)
+		}
+	}
+	clusters, err = db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters.Clusters) != 2 {
+		t.Fatalf("Expected cluster1 and one concurrent cluster, got %d clusters", len(clusters.Clusters))
+	}
+}
+
+// TestSnapshots checks named snapshots are kept and restored in place, keeping the list of snapshots
+// uses NewLocalSqliteDBWithOptions, db.CreateClusterEntry, db.DeleteClusterEntry, db.GetClusters,
+// db.CreateSnapshot, db.GetSnapshots, db.RestoreSnapshot, db.DeleteSnapshot
+func TestSnapshots(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	snapshotDir := t.TempDir()
+	agentDB, err := NewLocalSqliteDBWithOptions("sqlite3", "./local-agentstest-db", expBackoff, SqliteOptions{SnapshotDir: snapshotDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, ok := agentDB.(Snapshotter)
+	if !ok {
+		t.Fatal("LocalSqliteDb should implement Snapshotter")
+	}
+	err = agentDB.CreateClusterEntry(types.ClusterInfo{Name: "cluster1", PlatformType: "VMs", AgentsList: []string{"spiffe://example.org/agent1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT create snapshot [CreateSnapshot]
+	snapshot, err := db.CreateSnapshot("pre-bulk", "admin1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Name != "pre-bulk" || snapshot.CreatedBy != "admin1" || snapshot.Bytes == 0 {
+		t.Fatalf("Unexpected snapshot: %+v", snapshot)
+	}
+	if _, err = os.Stat(snapshotDir + "/pre-bulk.sqlite3"); err != nil {
+		t.Fatalf("Expected snapshot file: %v", err)
+	}
+
+	// CHECK names are unique and valid [CreateSnapshot]
+	_, err = db.CreateSnapshot("pre-bulk", "admin1")
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure for duplicate snapshot, got %v", err)
+	}
+	_, err = db.CreateSnapshot("../pre-bulk", "admin1")
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure for invalid snapshot name, got %v", err)
+	}
+
+	// ATTEMPT change data and restore [RestoreSnapshot]
+	err = agentDB.CreateClusterEntry(types.ClusterInfo{Name: "cluster2", PlatformType: "VMs", AgentsList: []string{"spiffe://example.org/agent2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = agentDB.DeleteClusterEntry("cluster1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.CreateSnapshot("post-bulk", "admin2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.RestoreSnapshot("pre-bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK data of the snapshot is restored [RestoreSnapshot]
+	clusters, err := agentDB.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters.Clusters) != 1 || clusters.Clusters[0].Name != "cluster1" || len(clusters.Clusters[0].AgentsList) != 1 {
+		t.Fatalf("Unexpected clusters after restore: %+v", clusters.Clusters)
+	}
+
+	// CHECK snapshots taken after the restored snapshot are kept [GetSnapshots]
+	snapshots, err := db.GetSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots.Snapshots) != 2 || snapshots.Snapshots[0].Name != "post-bulk" || snapshots.Snapshots[1].Name != "pre-bulk" {
+		t.Fatalf("Unexpected snapshots: %+v", snapshots.Snapshots)
+	}
+	err = db.RestoreSnapshot("post-bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters, err = agentDB.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters.Clusters) != 1 || clusters.Clusters[0].Name != "cluster2" {
+		t.Fatalf("Unexpected clusters after second restore: %+v", clusters.Clusters)
+	}
+
+	// ATTEMPT delete snapshot [DeleteSnapshot]
+	err = db.DeleteSnapshot("pre-bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK file is removed and snapshot cannot be restored [DeleteSnapshot]
+	if _, err = os.Stat(snapshotDir + "/pre-bulk.sqlite3"); !os.IsNotExist(err) {
+		t.Fatalf("Expected snapshot file to be removed, got %v", err)
+	}
+	err = db.RestoreSnapshot("pre-bulk")
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure restoring deleted snapshot, got %v", err)
+	}
+	err = db.DeleteSnapshot("pre-bulk")
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Expected PostFailure deleting deleted snapshot, got %v", err)
+	}
+}
+
+// TestSnapshotRevocations checks API keys deleted and tokens revoked or consumed after a
+// snapshot stay invalid once it is restored
+// uses NewLocalSqliteDBWithOptions, db.CreateServiceAccount, db.CreateClusterToken, db.CreateBootstrapToken,
+// db.CreateSnapshot, db.DeleteServiceAccount, db.DeleteClusterEntry, db.ConsumeBootstrapToken, db.RestoreSnapshot
+func TestSnapshotRevocations(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	agentDB, err := NewLocalSqliteDBWithOptions("sqlite3", "./local-agentstest-db", expBackoff, SqliteOptions{SnapshotDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := agentDB.(Snapshotter)
+	if err = agentDB.CreateClusterEntry(types.ClusterInfo{Name: "cluster1", PlatformType: "VMs"}); err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := agentDB.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = agentDB.CreateServiceAccount(types.ServiceAccount{Name: "ci-pipeline", Roles: []string{"admin"}}, "account-hash"); err != nil {
+		t.Fatal(err)
+	}
+	token := types.ClusterToken{Name: "operator1", ClusterUID: clusters.Clusters[0].UID, Access: types.ClusterTokenRead}
+	if err = agentDB.CreateClusterToken(token, "token-hash"); err != nil {
+		t.Fatal(err)
+	}
+	bootstrap := types.BootstrapToken{ID: "a", State: types.BootstrapTokenIssued, IssuedAt: "2024-03-01T12:00:00Z", ExpiresAt: "2124-03-01T12:00:00Z"}
+	if err = agentDB.CreateBootstrapToken(bootstrap, "bootstrap-hash"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.CreateSnapshot("with-keys", "admin1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT revoke the keys and tokens, then restore the snapshot [RestoreSnapshot]
+	if err = agentDB.DeleteServiceAccount("ci-pipeline"); err != nil {
+		t.Fatal(err)
+	}
+	if err = agentDB.DeleteClusterEntry("cluster1"); err != nil {
+		t.Fatal(err)
+	}
+	consumed, err := agentDB.ConsumeBootstrapToken("bootstrap-hash", "spiffe://example.org/spire/agent/join_token/a", "2024-03-01T12:05:00Z")
+	if err != nil || !consumed {
+		t.Fatalf("Expected bootstrap token consumed, got %v %v", consumed, err)
+	}
+	if err = db.RestoreSnapshot("with-keys"); err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK the cluster is restored but the keys and tokens stay revoked [RestoreSnapshot]
+	if clusters, err = agentDB.GetClusters(); err != nil || len(clusters.Clusters) != 1 {
+		t.Fatalf("Expected cluster1 restored, got %+v %v", clusters.Clusters, err)
+	}
+	if _, err = agentDB.GetServiceAccountByKeyHash("account-hash"); err == nil {
+		t.Fatal("Expected the key of the deleted service account to be rejected")
+	} else if _, ok := err.(GetError); !ok {
+		t.Fatalf("Expected GetError, got %v", err)
+	}
+	if _, err = agentDB.GetClusterTokenByKeyHash("token-hash"); err == nil {
+		t.Fatal("Expected the revoked cluster token to be rejected")
+	} else if _, ok := err.(GetError); !ok {
+		t.Fatalf("Expected GetError, got %v", err)
+	}
+	consumed, err = agentDB.ConsumeBootstrapToken("bootstrap-hash", "spiffe://example.org/spire/agent/join_token/b", "2024-03-01T12:06:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if consumed {
+		t.Fatal("Expected the consumed bootstrap token not to be consumed again")
+	}
+}
+
+// TestSnapshotAuditLog checks restoring a snapshot keeps the audit log and the history of clusters,
+// and records the restore and the clusters it changes in them
+// uses NewLocalSqliteDBWithOptions, db.CreateClusterEntry, db.DeleteClusterEntry, db.CreateSnapshot,
+// db.RestoreSnapshot, db.GetAuditEvents, db.GetClusterChangeLog
+func TestSnapshotAuditLog(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	agentDB, err := NewLocalSqliteDBWithOptions("sqlite3", "./local-agentstest-db", expBackoff, SqliteOptions{SnapshotDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = agentDB.CreateClusterEntry(types.ClusterInfo{Name: "cluster1", PlatformType: "VMs"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = agentDB.(Snapshotter).CreateSnapshot("one-cluster", "admin1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = agentDB.CreateClusterEntry(types.ClusterInfo{Name: "cluster2", PlatformType: "VMs"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = agentDB.DeleteClusterEntry("cluster1"); err != nil {
+		t.Fatal(err)
+	}
+	before, err := agentDB.GetAuditEvents(types.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT restore the snapshot as a user [RestoreSnapshot]
+	if err = agentDB.WithActor("alice").(Snapshotter).RestoreSnapshot("one-cluster"); err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK events recorded after the snapshot are kept, and the restore is recorded [GetAuditEvents]
+	events, err := agentDB.GetAuditEvents(types.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events.Total != before.Total+3 {
+		t.Fatalf("Expected the %d events and 3 of the restore, got %+v", before.Total, events.Items)
+	}
+	for i, event := range before.Items {
+		if !reflect.DeepEqual(events.Items[i+3], event) {
+			t.Fatalf("Expected event %+v kept, got %+v", event, events.Items[i+3])
+		}
+	}
+	restore := events.Items[2]
+	if restore.Actor != "alice" || restore.Action != types.AuditActionRestore || restore.ObjectType != types.AuditObjectSnapshot ||
+		restore.ObjectId != "one-cluster" {
+		t.Fatalf("Expected the restore of one-cluster by alice, got %+v", restore)
+	}
+	for _, event := range events.Items[:2] {
+		if event.Actor != "alice" || event.ObjectType != types.AuditObjectCluster {
+			t.Fatalf("Expected the clusters changed by the restore, got %+v", event)
+		}
+	}
+
+	// CHECK the history of clusters is kept, and records the clusters changed by the restore [GetClusterChangeLog]
+	changes, err := agentDB.GetClusterChangeLog(0, MaxChangeLogEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, entry := range changes.Entries {
+		got = append(got, entry.Name+" "+entry.Change)
+	}
+	expected := []string{"cluster1 created", "cluster2 created", "cluster1 deleted", "cluster1 restored", "cluster2 deleted"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected history %v, got %v", expected, got)
+	}
+
+	// CHECK restoring the same state records only the restore [RestoreSnapshot]
+	if err = agentDB.(Snapshotter).RestoreSnapshot("one-cluster"); err != nil {
+		t.Fatal(err)
+	}
+	if events, err = agentDB.GetAuditEvents(types.ListOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if events.Total != before.Total+4 || events.Items[0].ObjectType != types.AuditObjectSnapshot {
+		t.Fatalf("Expected only the second restore recorded, got %+v", events.Items)
+	}
+}
+
+/**** HELPER SECTION ****/
+
+func agentInfoCmp(agentInfo1 types.AgentInfo, agentInfo2 types.AgentInfo) bool {
+	return agentInfo1.Spiffeid == agentInfo2.Spiffeid && agentInfo1.Plugin == agentInfo2.Plugin
+}
+
+func inList(elem string, list []string) bool {
+	for i := 0; i < len(list); i++ {
+		if elem == list[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func agentListComp(correctList []string, resultList []string) error {
+	if len(resultList) != len(correctList) {
+		return errors.Errorf("Lists not the same: expected %v, got %v", correctList, resultList)
+	} else {
+		for i := 0; i < len(correctList); i++ {
+			if !inList(correctList[i], resultList) {
+				return errors.Errorf("Agent %v not in resulting agents list %v", correctList[i], resultList)
+			}
+		}
+		return nil
+	}
+}
+
+func clusterEquality(c1 types.ClusterInfo, c2 types.ClusterInfo) bool {
+	if c1.Name != c2.Name || c1.DomainName != c2.DomainName ||
+		c1.ManagedBy != c2.ManagedBy || c1.PlatformType != c2.PlatformType {
+		return false
+	}
+	return agentListComp(c1.AgentsList, c2.AgentsList) == nil
+}
+
+func inClusterList(cluster types.ClusterInfo, list []types.ClusterInfo) bool {
+	for i := 0; i < len(list); i++ {
+		if clusterEquality(cluster, list[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func clustersComp(c1 types.ClusterInfoList, c2 types.ClusterInfoList) error {
+	c1info := c1.Clusters
+	c2info := c2.Clusters
+	if len(c1info) != len(c2info) {
+		return errors.New("Number of clusters incorrect")
+	}
+	for i := 0; i < len(c1info); i++ {
+		if !inClusterList(c1info[i], c2info) {
+			cname := c1info[i].Name
+			return errors.Errorf("Error: first list contains cluster %v not in second list", cname)
+		}
+	}
+	return nil
+}
+
+/**** END HELPER SECTION ****/
+
+// TestLargeAgentBatch checks clusters and assignments of more agents than fit a single statement
+// uses CreateClusterEntry, GetClusterAgents, AssignAgentsToClustersWithProgress
+func TestLargeAgentBatch(t *testing.T) {
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	agentDB, err := NewInMemoryDB(expBackoff, SqliteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := agentDB.(*LocalSqliteDb)
+
+	agents := make([]string, 5*agentBatchChunkSize+1)
+	for i := range agents {
+		agents[i] = fmt.Sprintf("spiffe://example.org/agent/%05d", i)
+	}
+
+	// ATTEMPT cluster of more agents than the parameter limit of SQLite [CreateClusterEntry]
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster1", PlatformType: "VMs", AgentsList: agents}); err != nil {
+		t.Fatal(err)
+	}
+	clusterAgents, err := db.GetClusterAgents("cluster1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusterAgents) != len(agents) {
+		t.Fatalf("cluster1 should have %d agents, got %d", len(agents), len(clusterAgents))
+	}
+
+	// ATTEMPT cluster with an agent of another cluster in its last chunk; should fail [CreateClusterEntry]
+	err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster2", PlatformType: "VMs", AgentsList: []string{"agentA", agents[len(agents)-1]}})
+	if _, ok := err.(PostFailure); !ok {
+		t.Fatalf("Create of cluster with an assigned agent should fail with PostFailure, got %v", err)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster2", PlatformType: "VMs"}); err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uids := make(map[string]string)
+	for _, c := range clusters.Clusters {
+		uids[c.Name] = c.UID
+	}
+
+	// ATTEMPT moving all agents with progress reports [AssignAgentsToClustersWithProgress]
+	assignments := make([]types.AgentAssignment, len(agents))
+	for i, spiffeid := range agents {
+		assignments[i] = types.AgentAssignment{Row: i + 2, Spiffeid: spiffeid, ClusterUID: uids["cluster2"]}
+	}
+	reports := [][2]int{}
+	result, err := db.AssignAgentsToClustersWithProgress(assignments, false, func(applied int, total int) {
+		reports = append(reports, [2]int{applied, total})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Assigned != len(agents) {
+		t.Fatalf("All %d agents should be assigned, got %d", len(agents), result.Assigned)
+	}
+	if len(reports) != 6 || reports[0] != [2]int{agentBatchChunkSize, len(agents)} || reports[5] != [2]int{len(agents), len(agents)} {
+		t.Fatalf("Progress should be reported after each chunk, got %v", reports)
+	}
+	clusterAgents, err = db.GetClusterAgents("cluster2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusterAgents) != len(agents) {
+		t.Fatalf("cluster2 should have %d agents, got %d", len(agents), len(clusterAgents))
+	}
+}
+
+// TestChangeTimes checks clusters and agents record when they were created and last changed
+// uses NewInMemoryDB, db.CreateClusterEntry, db.EditClusterEntry, db.SetAgentDisplayName, db.CreateAgentEntry,
+// db.AssignAgentsToClusters, db.GetClusters, db.GetAgentsMetadata
+func TestChangeTimes(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(created)
+	agentDB, err := NewInMemoryDB(backoff.NewExponentialBackOff(), SqliteOptions{Clock: fake})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := agentDB.(*LocalSqliteDb)
+	cluster := func(name string) types.ClusterInfo {
+		clusters, err := db.GetClusters()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range clusters.Clusters {
+			if c.Name == name {
+				return c
+			}
+		}
+		t.Fatalf("Cluster %s not found", name)
+		return types.ClusterInfo{}
+	}
+	agent := func(spiffeid string) types.AgentInfo {
+		agents, err := db.GetAgentsMetadata(types.AgentMetadataRequest{Agents: []string{spiffeid}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(agents.Agents) != 1 {
+			t.Fatalf("Agent %s not found", spiffeid)
+		}
+		return agents.Agents[0]
+	}
+	expectTimes := func(what string, creationTime, updatedAt, expCreated, expUpdated time.Time) {
+		t.Helper()
+		if !creationTime.Equal(expCreated) || !updatedAt.Equal(expUpdated) {
+			t.Fatalf("Expected %s created at %v and changed at %v, got %v and %v", what, expCreated, expUpdated, creationTime, updatedAt)
+		}
+	}
+
+	// CHECK new clusters and agents are created and changed now [CreateClusterEntry]
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster1", PlatformType: "VMs", AgentsList: []string{"agent1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster2", PlatformType: "VMs"}); err != nil {
+		t.Fatal(err)
+	}
+	c := cluster("cluster1")
+	expectTimes("cluster1", c.CreationTime, c.UpdatedAt, created, created)
+	a := agent("agent1")
+	expectTimes("agent1", a.CreationTime, a.UpdatedAt, created, created)
+
+	// CHECK edits change the cluster and not its creation time [EditClusterEntry]
+	edited := created.Add(time.Hour)
+	fake.Set(edited)
+	if _, err = db.EditClusterEntry(types.ClusterInfo{Name: "cluster1", EditedName: "cluster1", PlatformType: "k8s",
+		AgentsList: []string{"agent1"}}); err != nil {
+		t.Fatal(err)
+	}
+	c = cluster("cluster1")
+	expectTimes("cluster1", c.CreationTime, c.UpdatedAt, created, edited)
+
+	// CHECK agents change with their display name, unless it is unchanged [SetAgentDisplayName]
+	renamed := edited.Add(time.Hour)
+	fake.Set(renamed)
+	if err = db.SetAgentDisplayName("agent1", "node 1"); err != nil {
+		t.Fatal(err)
+	}
+	fake.Set(renamed.Add(time.Hour))
+	if err = db.SetAgentDisplayName("agent1", "node 1"); err != nil {
+		t.Fatal(err)
+	}
+	a = agent("agent1")
+	expectTimes("agent1", a.CreationTime, a.UpdatedAt, created, renamed)
+
+	// CHECK agents change with their plugin type [CreateAgentEntry]
+	typed := renamed.Add(2 * time.Hour)
+	fake.Set(typed)
+	if err = db.CreateAgentEntry(types.AgentInfo{Spiffeid: "agent1", Plugin: "K8S"}); err != nil {
+		t.Fatal(err)
+	}
+	a = agent("agent1")
+	expectTimes("agent1", a.CreationTime, a.UpdatedAt, created, typed)
+
+	// CHECK moving an agent changes it and both clusters [AssignAgentsToClusters]
+	moved := typed.Add(time.Hour)
+	fake.Set(moved)
+	uid := cluster("cluster2").UID
+	if _, err = db.AssignAgentsToClusters([]types.AgentAssignment{{Row: 1, Spiffeid: "agent1", ClusterUID: uid}}, false); err != nil {
+		t.Fatal(err)
+	}
+	a = agent("agent1")
+	expectTimes("agent1", a.CreationTime, a.UpdatedAt, created, moved)
+	c = cluster("cluster1")
+	expectTimes("cluster1", c.CreationTime, c.UpdatedAt, created, moved)
+	c = cluster("cluster2")
+	expectTimes("cluster2", c.CreationTime, c.UpdatedAt, created, moved)
+}
+
+func TestGetClustersPage(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"cluster3", "cluster1", "cluster5", "cluster2", "cluster4"} {
+		platform := "k8s"
+		if i%2 == 1 {
+			platform = "VMs"
+		}
+		cinfo := types.ClusterInfo{Name: name, PlatformType: platform, ManagedBy: "team-" + platform, DomainName: name + ".example.org",
+			AgentsList: []string{"agent-" + name}, Labels: map[string]string{"env": name}}
+		if err = db.CreateClusterEntry(cinfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// ATTEMPT page through clusters 2 at a time [GetClustersPage]
+	names := []string{}
+	opts := types.ListOptions{Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatal("Expected 3 pages of clusters")
+		}
+		page, err := db.GetClustersPage(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 5 {
+			t.Fatalf("Expected 5 clusters in total, got %d", page.Total)
+		}
+		for _, c := range page.Items {
+			// CHECK details of the clusters of the page are read
+			if len(c.AgentsList) != 1 || c.AgentsList[0] != "agent-"+c.Name || c.Labels["env"] != c.Name {
+				t.Fatalf("Incomplete cluster in page: %+v", c)
+			}
+			names = append(names, c.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	// CHECK pages follow the order of the names
+	if !reflect.DeepEqual(names, []string{"cluster1", "cluster2", "cluster3", "cluster4", "cluster5"}) {
+		t.Fatalf("Unexpected clusters across pages: %v", names)
+	}
+
+	// ATTEMPT page with a filter [GetClustersPage]
+	page, err := db.GetClustersPage(types.ListOptions{Filters: []types.Filter{{Field: "platformType", Value: "VMs"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Items) != 2 || page.Items[0].Name != "cluster1" || page.Items[1].Name != "cluster2" {
+		t.Fatalf("Expected clusters cluster1 and cluster2, got %+v", page)
+	}
+
+	// ATTEMPT page with filters on several fields [GetClustersPage]
+	page, err = db.GetClustersPage(types.ListOptions{Filters: []types.Filter{
+		{Field: "managedBy", Value: "team-k8s"}, {Field: "domainName", Value: "cluster5.example.org"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || len(page.Items) != 1 || page.Items[0].Name != "cluster5" {
+		t.Fatalf("Expected cluster cluster5, got %+v", page)
+	}
+
+	// ATTEMPT page past the last cluster [GetClustersPage]
+	page, err = db.GetClustersPage(types.ListOptions{Cursor: types.EncodeCursor(10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 0 || page.NextCursor != "" {
+		t.Fatalf("Expected an empty last page, got %+v", page)
+	}
+
+	// ATTEMPT invalid options [GetClustersPage]
+	if _, err = db.GetClustersPage(types.ListOptions{Cursor: "not a cursor"}); err == nil {
+		t.Fatal("Expected an invalid cursor to fail")
+	}
+	if _, err = db.GetClustersPage(types.ListOptions{Filters: []types.Filter{{Field: "slackChannel", Value: "x"}}}); err == nil {
+		t.Fatal("Expected filtering on an unknown field to fail")
+	}
+}
+
+// TestGetClustersPageLabelSelector checks the clusters selected by each
+// operator of label selectors, and their combination with filters and sorts
+func TestGetClustersPageLabelSelector(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters := map[string]map[string]string{
+		"cluster1": {"env": "prod", "region": "us-east"},
+		"cluster2": {"env": "prod", "region": "us-west"},
+		"cluster3": {"env": "staging", "region": "us-east"},
+		"cluster4": {"env": "dev"},
+		"cluster5": nil,
+	}
+	for name, labels := range clusters {
+		platform := "k8s"
+		if name == "cluster2" {
+			platform = "VMs"
+		}
+		if err = db.CreateClusterEntry(types.ClusterInfo{Name: name, PlatformType: platform, Labels: labels}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		selector string
+		expected []string
+	}{
+		{"env=prod,region=us-east", []string{"cluster1"}},
+		{"env==prod", []string{"cluster1", "cluster2"}},
+		{"env!=prod", []string{"cluster3", "cluster4", "cluster5"}},
+		{"env in (staging, dev)", []string{"cluster3", "cluster4"}},
+		{"region notin (us-east)", []string{"cluster2", "cluster4", "cluster5"}},
+		{"region", []string{"cluster1", "cluster2", "cluster3"}},
+		{"!env", []string{"cluster5"}},
+		{"env=qa", []string{}},
+	}
+	for _, test := range tests {
+		sel, err := types.ParseLabelSelector(test.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// ATTEMPT page with a label selector [GetClustersPage]
+		page, err := db.GetClustersPage(types.ListOptions{LabelSelector: sel})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// CHECK clusters meeting the requirements, as selected in memory
+		names := []string{}
+		for _, c := range page.Items {
+			if !sel.Matches(c.Labels) {
+				t.Fatalf("Cluster %s does not match %q: %v", c.Name, test.selector, c.Labels)
+			}
+			names = append(names, c.Name)
+		}
+		if page.Total != len(test.expected) || !reflect.DeepEqual(names, test.expected) {
+			t.Fatalf("Expected clusters %v for %q, got %v of %d", test.expected, test.selector, names, page.Total)
+		}
+	}
+
+	// ATTEMPT label selector with a filter and a sort [GetClustersPage]
+	sel, err := types.ParseLabelSelector("env=prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := db.GetClustersPage(types.ListOptions{LabelSelector: sel, Filters: []types.Filter{{Field: "platformType", Value: "VMs"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || len(page.Items) != 1 || page.Items[0].Name != "cluster2" {
+		t.Fatalf("Expected cluster cluster2, got %+v", page)
+	}
+	page, err = db.GetClustersPage(types.ListOptions{LabelSelector: sel, Sort: []types.SortField{{Field: "platformType"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Items) != 2 || page.Items[0].Name != "cluster2" || page.Items[1].Name != "cluster1" {
+		t.Fatalf("Expected clusters cluster2 and cluster1, got %+v", page)
+	}
+
+	// CHECK invalid requirements are rejected [GetClustersPage]
+	if _, err = db.GetClustersPage(types.ListOptions{LabelSelector: types.LabelSelector{{Key: "env", Operator: "gt", Values: []string{"1"}}}}); err == nil {
+		t.Fatal("Expected an invalid operator to fail")
+	}
+}
+
+func TestGetClustersPageSorted(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cinfo := range []types.ClusterInfo{
+		{Name: "cluster1", PlatformType: "VMs", AgentsList: []string{"agent1"}},
+		{Name: "cluster2", PlatformType: "k8s", AgentsList: []string{"agent2", "agent3", "agent4"}},
+		{Name: "cluster3", PlatformType: "k8s"},
+		{Name: "cluster4", PlatformType: "docker", AgentsList: []string{"agent5", "agent6"}},
+	} {
+		if err = db.CreateClusterEntry(cinfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pageNames := func(opts types.ListOptions) ([]string, types.List[types.ClusterInfo]) {
+		page, err := db.GetClustersPage(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, c := range page.Items {
+			names = append(names, c.Name)
+		}
+		return names, page
+	}
+
+	// ATTEMPT sort by agent count, descending, 2 at a time [GetClustersPage]
+	opts := types.ListOptions{Limit: 2, Sort: []types.SortField{{Field: "agentCount", Desc: true}}}
+	names, page := pageNames(opts)
+	if !reflect.DeepEqual(names, []string{"cluster2", "cluster4"}) || page.Total != 4 || page.NextCursor == "" {
+		t.Fatalf("Expected first page [cluster2 cluster4] of 4 clusters, got %v, %+v", names, page)
+	}
+	// CHECK details of the clusters of the page are read
+	if len(page.Items[0].AgentsList) != 3 {
+		t.Fatalf("Incomplete cluster in page: %+v", page.Items[0])
+	}
+	opts.Cursor = page.NextCursor
+	names, page = pageNames(opts)
+	if !reflect.DeepEqual(names, []string{"cluster1", "cluster3"}) || page.NextCursor != "" {
+		t.Fatalf("Expected last page [cluster1 cluster3], got %v, %+v", names, page)
+	}
+
+	// ATTEMPT sort by platform type with a filter, ties broken by name [GetClustersPage]
+	names, _ = pageNames(types.ListOptions{Sort: []types.SortField{{Field: "platformType"}}})
+	if !reflect.DeepEqual(names, []string{"cluster1", "cluster4", "cluster2", "cluster3"}) {
+		t.Fatalf("Unexpected clusters sorted by platform type: %v", names)
+	}
+	names, page = pageNames(types.ListOptions{Filters: []types.Filter{{Field: "platformType", Value: "k8s"}},
+		Sort: []types.SortField{{Field: "agentCount"}}})
+	if !reflect.DeepEqual(names, []string{"cluster3", "cluster2"}) || page.Total != 2 {
+		t.Fatalf("Expected clusters [cluster3 cluster2], got %v, %+v", names, page)
+	}
+
+	// ATTEMPT sort by name, descending [GetClustersPage]
+	names, _ = pageNames(types.ListOptions{Sort: []types.SortField{{Field: "name", Desc: true}}})
+	if !reflect.DeepEqual(names, []string{"cluster4", "cluster3", "cluster2", "cluster1"}) {
+		t.Fatalf("Unexpected clusters sorted by descending name: %v", names)
+	}
+
+	// ATTEMPT sort on an unknown field [GetClustersPage]
+	if _, err = db.GetClustersPage(types.ListOptions{Sort: []types.SortField{{Field: "ownerTeam"}}}); err == nil {
+		t.Fatal("Expected sorting on an unknown field to fail")
+	}
+}
+
+func TestAgentsPage(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, spiffeid := range []string{"agent3", "agent1", "agent5", "agent2", "agent4"} {
+		plugin := types.PluginTypeKubernetes
+		if i%2 == 1 {
+			plugin = types.PluginTypeDocker
+		}
+		if err = db.CreateAgentEntry(types.AgentInfo{Spiffeid: spiffeid, Plugin: plugin}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "cluster1", PlatformType: "k8s", AgentsList: []string{"agent2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT page through agent metadata 2 at a time [GetAgentsMetadata]
+	spiffeids := []string{}
+	req := types.AgentMetadataRequest{Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatal("Expected 3 pages of agents")
+		}
+		page, err := db.GetAgentsMetadata(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 5 || len(page.Agents) > 2 {
+			t.Fatalf("Expected pages of 2 out of 5 agents, got %+v", page)
+		}
+		for _, a := range page.Agents {
+			// CHECK details of the agents of the page are read
+			if a.Plugin == "" || (a.Spiffeid == "agent2" && a.Cluster != "cluster1") {
+				t.Fatalf("Incomplete agent in page: %+v", a)
+			}
+			spiffeids = append(spiffeids, a.Spiffeid)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		req.Cursor = page.NextCursor
+	}
+	// CHECK pages follow the order of the SPIFFE IDs
+	if !reflect.DeepEqual(spiffeids, []string{"agent1", "agent2", "agent3", "agent4", "agent5"}) {
+		t.Fatalf("Unexpected agents across pages: %v", spiffeids)
+	}
+
+	// ATTEMPT page of the agents matching a plugin [GetAgentsMetadata]
+	page, err := db.GetAgentsMetadata(types.AgentMetadataRequest{Plugin: "docker", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Agents) != 1 || page.Agents[0].Spiffeid != "agent1" || page.NextCursor == "" {
+		t.Fatalf("Expected first of 2 Docker agents, got %+v", page)
+	}
+
+	// ATTEMPT list without pagination; should return all agents [GetAgentsMetadata]
+	all, err := db.GetAgentsMetadata(types.AgentMetadataRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Agents) != 5 || all.NextCursor != "" || all.Total != 0 {
+		t.Fatalf("Expected all 5 agents without a cursor, got %+v", all)
+	}
+
+	// ATTEMPT page past the last agent [GetAgentsMetadata]
+	page, err = db.GetAgentsMetadata(types.AgentMetadataRequest{Cursor: types.EncodeCursor(10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Agents) != 0 || page.NextCursor != "" || page.Total != 5 {
+		t.Fatalf("Expected an empty last page, got %+v", page)
+	}
+
+	// ATTEMPT page through agent selectors of a plugin [GetAgentSelectorsPage]
+	selectors, err := db.GetAgentSelectorsPage(types.ListOptions{Limit: 2, Filters: []types.Filter{{Field: "plugin", Value: types.PluginTypeKubernetes}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selectors.Total != 3 || len(selectors.Items) != 2 || selectors.Items[0].Spiffeid != "agent3" ||
+		selectors.Items[1].Spiffeid != "agent4" || selectors.NextCursor == "" {
+		t.Fatalf("Expected first 2 of 3 Kubernetes agents, got %+v", selectors)
+	}
+	selectors, err = db.GetAgentSelectorsPage(types.ListOptions{Limit: 2, Cursor: selectors.NextCursor,
+		Filters: []types.Filter{{Field: "plugin", Value: types.PluginTypeKubernetes}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selectors.Items) != 1 || selectors.Items[0].Spiffeid != "agent5" || selectors.NextCursor != "" {
+		t.Fatalf("Expected last Kubernetes agent, got %+v", selectors)
+	}
+
+	// ATTEMPT invalid options [GetAgentsMetadata, GetAgentSelectorsPage]
+	if _, err = db.GetAgentsMetadata(types.AgentMetadataRequest{Cursor: "not a cursor"}); err == nil {
+		t.Fatal("Expected an invalid cursor to fail")
+	}
+	if _, err = db.GetAgentSelectorsPage(types.ListOptions{Sort: []types.SortField{{Field: "plugin"}}}); err == nil {
+		t.Fatal("Expected sorting agent selectors to fail")
+	}
+}
+
+func TestNotes(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT create notes of a cluster and an agent [CreateNote]
+	id, err := db.CreateNote(types.Note{ObjectType: types.NoteObjectCluster, ObjectId: "uid1",
+		Body: "Being **migrated**, do not touch", Author: "alice", CreatedAt: "2024-03-01T12:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.CreateNote(types.Note{ObjectType: types.NoteObjectAgent, ObjectId: "spiffe://example.org/a",
+		Body: "Rebuilt", Author: "bob", CreatedAt: "2024-03-01T13:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT edit the note of the cluster [EditNote]
+	if err = db.EditNote(id, "Migration done", "bob", "2024-03-02T12:00:00Z"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.EditNote(id+100, "Unknown", "bob", "2024-03-02T12:00:00Z"); err == nil {
+		t.Fatal("Expected editing an unknown note to fail")
+	}
+
+	// CHECK notes of the cluster [GetNotes]
+	notes, err := db.GetNotes(types.NoteObjectCluster, []string{"uid1", "uid2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []types.Note{{ID: id, ObjectType: types.NoteObjectCluster, ObjectId: "uid1", Body: "Migration done",
+		Author: "alice", CreatedAt: "2024-03-01T12:00:00Z", UpdatedBy: "bob", UpdatedAt: "2024-03-02T12:00:00Z"}}
+	if !reflect.DeepEqual(notes.Notes, expected) {
+		t.Fatalf("Expected notes %+v, got %+v", expected, notes.Notes)
+	}
+	notes, err = db.GetNotes("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes.Notes) != 2 {
+		t.Fatalf("Expected 2 notes, got %+v", notes.Notes)
+	}
+
+	// CHECK history of the note [GetNoteRevisions]
+	revisions, err := db.GetNoteRevisions(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions.Revisions) != 2 || revisions.Revisions[0].Body != "Being **migrated**, do not touch" ||
+		revisions.Revisions[1].Body != "Migration done" || revisions.Revisions[1].EditedBy != "bob" {
+		t.Fatalf("Unexpected revisions %+v", revisions.Revisions)
+	}
+
+	// ATTEMPT delete the note [DeleteNote]
+	if err = db.DeleteNote(id); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteNote(id); err == nil {
+		t.Fatal("Expected deleting a deleted note to fail")
+	}
+	if _, err = db.GetNoteRevisions(id); err == nil {
+		t.Fatal("Expected the history of a deleted note to be deleted")
+	}
+}
+
+// TestSearchClusters checks clusters are found by the words of their searchable
+// fields as they are created, edited, deleted and restored from a snapshot
+// the results match with and without FTS5, built with the sqlite_fts5 tag
+// uses NewLocalSqliteDBWithOptions, db.SearchClusters, db.EditClusterEntry,
+// db.DeleteClusterEntry, db.CreateSnapshot, db.RestoreSnapshot
+func TestSearchClusters(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	agentDB, err := NewLocalSqliteDBWithOptions("sqlite3", "./local-agentstest-db", expBackoff, SqliteOptions{SnapshotDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := agentDB.(*LocalSqliteDb)
+	for _, cinfo := range []types.ClusterInfo{
+		{Name: "prod-east", DomainName: "example.org", ManagedBy: "team-a", PlatformType: "k8s", AgentsList: []string{"agent1"}},
+		{Name: "prod-west", DomainName: "example.com", ManagedBy: "team-b", PlatformType: "VMs"},
+		{Name: "staging", DomainName: "example.org", ManagedBy: "team-a", PlatformType: "k8s"},
+	} {
+		if err = db.CreateClusterEntry(cinfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	search := func(query string) []string {
+		found, err := db.SearchClusters(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, c := range found.Clusters {
+			names = append(names, c.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// ATTEMPT search by words of the fields, in any case [SearchClusters]
+	for query, want := range map[string][]string{
+		"prod":               {"prod-east", "prod-west"},
+		"PROD k8s":           {"prod-east"},
+		"example.org team-a": {"prod-east", "staging"},
+		"exam":               {"prod-east", "prod-west", "staging"},
+		"production":         {},
+	} {
+		if names := search(query); !reflect.DeepEqual(names, want) {
+			t.Fatalf("Expected %v for %q, got %v", want, query, names)
+		}
+	}
+	// CHECK details of the clusters found are read
+	found, err := db.SearchClusters("east")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found.Clusters) != 1 || found.Clusters[0].DomainName != "example.org" || len(found.Clusters[0].AgentsList) != 1 {
+		t.Fatalf("Incomplete cluster found: %+v", found.Clusters)
+	}
+	// CHECK queries without words
+	for _, query := range []string{"", " ... "} {
+		if _, err = db.SearchClusters(query); err == nil {
+			t.Fatalf("Expected query %q to fail", query)
+		}
+	}
+
+	// ATTEMPT search after edits and deletes [EditClusterEntry, DeleteClusterEntry]
+	if _, err = db.EditClusterEntry(types.ClusterInfo{Name: "staging", EditedName: "qa", DomainName: "example.org", ManagedBy: "team-a", PlatformType: "VMs"}); err != nil {
+		t.Fatal(err)
+	}
+	if names := search("vms"); !reflect.DeepEqual(names, []string{"prod-west", "qa"}) {
+		t.Fatalf("Expected edited cluster to be found, got %v", names)
+	}
+	if names := search("staging"); len(names) != 0 {
+		t.Fatalf("Expected old name not to be found, got %v", names)
+	}
+	if _, err = db.CreateSnapshot("search", "admin1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteClusterEntry("prod-west"); err != nil {
+		t.Fatal(err)
+	}
+	if names := search("vms"); !reflect.DeepEqual(names, []string{"qa"}) {
+		t.Fatalf("Expected deleted cluster not to be found, got %v", names)
+	}
+
+	// ATTEMPT search after restoring a snapshot [RestoreSnapshot]
+	if err = db.RestoreSnapshot("search"); err != nil {
+		t.Fatal(err)
+	}
+	if names := search("vms"); !reflect.DeepEqual(names, []string{"prod-west", "qa"}) {
+		t.Fatalf("Expected restored cluster to be found, got %v", names)
+	}
+}
+
+// TestFindAgentsByPattern checks SPIFFE ID patterns match case-sensitively,
+// with the characters special to LIKE and GLOB matched as themselves
+// uses db.FindAgentsByPattern, db.CreateAgentEntry
+func TestFindAgentsByPattern(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, spiffeid := range []string{
+		"spiffe://example.org/agent/node-1",
+		"spiffe://example.org/agent/node-2",
+		"spiffe://example.org/agent/Node-3",
+		"spiffe://example.org/agent/node_[4]",
+		"spiffe://example.org/agent/node-10",
+		"spiffe://example.com/agent/node-1",
+	} {
+		if err = db.CreateAgentEntry(types.AgentInfo{Spiffeid: spiffeid, Plugin: "k8s"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	find := func(pattern string, opts types.ListOptions) types.List[string] {
+		found, err := db.FindAgentsByPattern(pattern, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return found
+	}
+
+	// CHECK prefix, case-sensitively [FindAgentsByPattern]
+	found := find("spiffe://example.org/agent/node-*", types.ListOptions{})
+	expected := []string{"spiffe://example.org/agent/node-1", "spiffe://example.org/agent/node-10", "spiffe://example.org/agent/node-2"}
+	if !reflect.DeepEqual(found.Items, expected) {
+		t.Fatalf("Expected agents %v, got %v", expected, found.Items)
+	}
+
+	// CHECK single-character wildcards and wildcards before the path [FindAgentsByPattern]
+	found = find("spiffe://example.*/agent/node-?", types.ListOptions{})
+	expected = []string{"spiffe://example.com/agent/node-1", "spiffe://example.org/agent/node-1", "spiffe://example.org/agent/node-2"}
+	if !reflect.DeepEqual(found.Items, expected) {
+		t.Fatalf("Expected agents %v, got %v", expected, found.Items)
+	}
+
+	// CHECK special characters match themselves only [FindAgentsByPattern]
+	found = find("spiffe://example.org/agent/node_[4]", types.ListOptions{})
+	if !reflect.DeepEqual(found.Items, []string{"spiffe://example.org/agent/node_[4]"}) {
+		t.Fatalf("Unexpected agents %v", found.Items)
+	}
+	if found = find("spiffe://example.org/agent/node_*", types.ListOptions{}); found.Total != 1 {
+		t.Fatalf("Expected _ to match itself only, got %v", found.Items)
+	}
+
+	// CHECK pages of matches [FindAgentsByPattern]
+	found = find("spiffe://example.org/*", types.ListOptions{Limit: 2, Sort: []types.SortField{{Field: "spiffeid", Desc: true}}})
+	if found.Total != 5 || !reflect.DeepEqual(found.Items, []string{"spiffe://example.org/agent/node_[4]", "spiffe://example.org/agent/node-2"}) || found.NextCursor == "" {
+		t.Fatalf("Unexpected first page %+v", found)
+	}
+
+	// CHECK invalid patterns and sorts [FindAgentsByPattern]
+	if _, err = db.FindAgentsByPattern("", types.ListOptions{}); err == nil {
+		t.Fatal("Expected an empty pattern to fail")
+	}
+	if _, err = db.FindAgentsByPattern("spiffe://*", types.ListOptions{Sort: []types.SortField{{Field: "name"}}}); err == nil {
+		t.Fatal("Expected sort on name to fail")
+	}
+}
+
+// TestClusterProtection checks protected clusters are not deleted until their
+// protection is cleared, and that edits and renames keep the protection
+// uses db.CreateClusterEntry, db.SetClusterProtection, db.EditClusterEntry,
+// db.DeleteClusterEntry, db.GetClusterChanges
+func TestClusterProtection(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "prod", PlatformType: "k8s", Protected: true, AgentsList: []string{"agent1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "staging", PlatformType: "k8s"}); err != nil {
+		t.Fatal(err)
+	}
+	protected := func(name string) bool {
+		clusters, err := db.GetClusters()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range clusters.Clusters {
+			if c.Name == name {
+				return c.Protected
+			}
+		}
+		t.Fatalf("Cluster %s not found", name)
+		return false
+	}
+
+	// ATTEMPT delete of a cluster protected at creation; should fail [DeleteClusterEntry]
+	var pf PostFailure
+	if err = db.DeleteClusterEntry("prod"); !errors.As(err, &pf) || !strings.Contains(err.Error(), "protected") {
+		t.Fatalf("Expected PostFailure on protected cluster, got %v", err)
+	}
+	if agents, err := db.GetClusterAgents("prod"); err != nil || len(agents) != 1 {
+		t.Fatalf("Expected protected cluster to keep its agents, got %v, %v", agents, err)
+	}
+
+	// ATTEMPT edit and rename without the flag; should keep the protection [EditClusterEntry]
+	if _, err = db.EditClusterEntry(types.ClusterInfo{Name: "prod", EditedName: "prod-east", PlatformType: "VMs"}); err != nil {
+		t.Fatal(err)
+	}
+	if !protected("prod-east") {
+		t.Fatal("Expected edited cluster to stay protected")
+	}
+
+	// ATTEMPT protect and clear; only changes are recorded [SetClusterProtection]
+	if err = db.SetClusterProtection("staging", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SetClusterProtection("staging", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteClusterEntry("staging"); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on protected cluster, got %v", err)
+	}
+	if err = db.SetClusterProtection("staging", false); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := db.GetClusterChanges(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 5 || changes[0].Name != "staging" || changes[0].Change != types.ClusterChangeUpdated {
+		t.Fatalf("Unexpected changes %+v", changes)
+	}
+
+	// CHECK cleared clusters are deleted [DeleteClusterEntry]
+	if err = db.DeleteClusterEntry("staging"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SetClusterProtection("prod-east", false); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteClusterEntry("prod-east"); err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK unknown clusters [SetClusterProtection, DeleteClusterEntry]
+	if err = db.SetClusterProtection("prod-east", true); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on unknown cluster, got %v", err)
+	}
+	if err = db.DeleteClusterEntry("prod-east"); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on unknown cluster, got %v", err)
+	}
+}
+
+// TestClusterMetadata checks the metadata of clusters is stored compacted,
+// replaced by edits and reported as a change
+func TestClusterMetadata(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := func(name string) string {
+		clusters, err := db.GetClusters()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range clusters.Clusters {
+			if c.Name == name {
+				return string(c.Metadata)
+			}
+		}
+		t.Fatalf("Cluster %s not found", name)
+		return ""
+	}
+
+	// ATTEMPT create clusters with and without metadata [CreateClusterEntry]
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "prod", PlatformType: "k8s",
+		Metadata: json.RawMessage(`{ "costCenter": "CC-1", "tickets": ["OPS-1"] }`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "staging", PlatformType: "k8s", Metadata: json.RawMessage("null")}); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK metadata is returned compacted, and none is stored for null
+	if got := metadata("prod"); got != `{"costCenter":"CC-1","tickets":["OPS-1"]}` {
+		t.Fatalf("Unexpected metadata %s", got)
+	}
+	if got := metadata("staging"); got != "" {
+		t.Fatalf("Expected no metadata, got %s", got)
+	}
+
+	// ATTEMPT edit with the same metadata formatted differently; no change [EditClusterEntry]
+	result, err := db.EditClusterEntry(types.ClusterInfo{Name: "prod", EditedName: "prod", PlatformType: "k8s",
+		Metadata: json.RawMessage(`{"costCenter":"CC-1",  "tickets":["OPS-1"]}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Changes) != 0 {
+		t.Fatalf("Expected no changes, got %+v", result.Changes)
+	}
+	// ATTEMPT edit without metadata; clears it [EditClusterEntry]
+	result, err = db.EditClusterEntry(types.ClusterInfo{Name: "prod", EditedName: "prod", PlatformType: "k8s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Changes) != 1 || result.Changes[0].Field != "metadata" || metadata("prod") != "" {
+		t.Fatalf("Expected metadata to be cleared, got %+v", result.Changes)
+	}
+
+	// CHECK invalid JSON is rejected [EditClusterEntry]
+	var pf PostFailure
+	if _, err = db.EditClusterEntry(types.ClusterInfo{Name: "staging", EditedName: "staging", PlatformType: "k8s",
+		Metadata: json.RawMessage(`{"a":`)}); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on invalid metadata, got %v", err)
+	}
+}
+
+// TestDeletedClusters checks deleted clusters are listed and restored with
+// their UID, agents, labels and metadata, leaving agents assigned since
+func TestDeletedClusters(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cinfo := types.ClusterInfo{Name: "prod", PlatformType: "k8s", Labels: map[string]string{"env": "prod"},
+		Metadata: json.RawMessage(`{"costCenter":"CC-1"}`), AgentsList: []string{"agent1", "agent2"}}
+	if err = db.CreateClusterEntry(cinfo); err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := db.GetClusters()
+	if err != nil || len(clusters.Clusters) != 1 {
+		t.Fatalf("Unexpected clusters %+v: %v", clusters.Clusters, err)
+	}
+	uid := clusters.Clusters[0].UID
+
+	// ATTEMPT delete cluster; should be listed as deleted [DeleteClusterEntry, ListDeletedClusters]
+	if err = db.DeleteClusterEntry("prod"); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := db.ListDeletedClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted.Clusters) != 1 || deleted.Clusters[0].Cluster.UID != uid || deleted.Clusters[0].DeletedAt == "" ||
+		len(deleted.Clusters[0].Cluster.AgentsList) != 2 {
+		t.Fatalf("Unexpected deleted clusters %+v", deleted.Clusters)
+	}
+
+	// ATTEMPT restore after an agent is assigned elsewhere; agent is skipped [RestoreClusterEntry]
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "staging", PlatformType: "k8s", AgentsList: []string{"agent2"}}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := db.RestoreClusterEntry(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := types.ClusterRestoreResult{Name: "prod", UID: uid, SkippedAgents: []string{"agent2"}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected result %+v, got %+v", expected, result)
+	}
+
+	// CHECK cluster is back with its UID, labels and metadata [GetClusters]
+	clusters, err = db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored types.ClusterInfo
+	for _, c := range clusters.Clusters {
+		if c.Name == "prod" {
+			restored = c
+		}
+	}
+	if restored.UID != uid || restored.Labels["env"] != "prod" || string(restored.Metadata) != `{"costCenter":"CC-1"}` ||
+		!reflect.DeepEqual(restored.AgentsList, []string{"agent1"}) {
+		t.Fatalf("Unexpected restored cluster %+v", restored)
+	}
+	changes, err := db.GetClusterChanges(1)
+	if err != nil || len(changes) != 1 || changes[0].Change != types.ClusterChangeRestored {
+		t.Fatalf("Unexpected changes %+v: %v", changes, err)
+	}
+	if deleted, err = db.ListDeletedClusters(); err != nil || len(deleted.Clusters) != 0 {
+		t.Fatalf("Expected no deleted clusters, got %+v: %v", deleted.Clusters, err)
+	}
+
+	// CHECK restores of unknown UIDs and taken names fail [RestoreClusterEntry]
+	var pf PostFailure
+	if _, err = db.RestoreClusterEntry(uid); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on restored cluster, got %v", err)
+	}
+	if err = db.DeleteClusterEntry("prod"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "prod", PlatformType: "VMs"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.RestoreClusterEntry(uid); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on taken name, got %v", err)
+	}
+	if deleted, err = db.ListDeletedClusters(); err != nil || len(deleted.Clusters) != 1 {
+		t.Fatalf("Expected cluster to stay deleted, got %+v: %v", deleted.Clusters, err)
+	}
+}
+
+// TestClusterUpsert checks clusters are created by UID if new and updated
+// otherwise, including renames, and that UIDs of deleted clusters are rejected
+func TestClusterUpsert(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := "0123456789abcdef0123456789abcdef"
+
+	// ATTEMPT upsert of a new UID; should create the cluster with the UID [CreateOrUpdateClusterEntry]
+	cinfo := types.ClusterInfo{UID: uid, Name: "prod", PlatformType: "k8s", AgentsList: []string{"agent1"}}
+	result, err := db.CreateOrUpdateClusterEntry(cinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := types.ClusterUpsertResult{UID: uid, Name: "prod", Created: true, Changes: []types.FieldChange{}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected result %+v, got %+v", expected, result)
+	}
+	clusters, err := db.GetClusters()
+	if err != nil || len(clusters.Clusters) != 1 || clusters.Clusters[0].UID != uid ||
+		!reflect.DeepEqual(clusters.Clusters[0].AgentsList, []string{"agent1"}) {
+		t.Fatalf("Unexpected clusters %+v: %v", clusters.Clusters, err)
+	}
+
+	// ATTEMPT same upsert again; should change nothing [CreateOrUpdateClusterEntry]
+	result, err = db.CreateOrUpdateClusterEntry(cinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Created || len(result.Changes) != 0 {
+		t.Fatalf("Expected unchanged cluster, got %+v", result)
+	}
+
+	// ATTEMPT upsert with another name and agents; should rename the cluster [CreateOrUpdateClusterEntry]
+	cinfo.Name, cinfo.AgentsList = "production", []string{"agent1", "agent2"}
+	result, err = db.CreateOrUpdateClusterEntry(cinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := []string{}
+	for _, c := range result.Changes {
+		fields = append(fields, c.Field)
+	}
+	if result.Created || result.Name != "production" || !reflect.DeepEqual(fields, []string{"name", "agentsList"}) {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	clusters, err = db.GetClusters()
+	if err != nil || len(clusters.Clusters) != 1 || clusters.Clusters[0].Name != "production" || clusters.Clusters[0].UID != uid {
+		t.Fatalf("Unexpected clusters %+v: %v", clusters.Clusters, err)
+	}
+	changes, err := db.GetClusterChanges(3)
+	if err != nil || len(changes) != 3 || changes[0].Change != types.ClusterChangeUpdated ||
+		changes[2].Change != types.ClusterChangeCreated {
+		t.Fatalf("Unexpected changes %+v: %v", changes, err)
+	}
+
+	// CHECK invalid UIDs, UIDs of deleted clusters and taken names fail [CreateOrUpdateClusterEntry]
+	var pf PostFailure
+	for _, invalid := range []string{"", "prod", strings.ToUpper(uid)} {
+		if _, err = db.CreateOrUpdateClusterEntry(types.ClusterInfo{UID: invalid, Name: "other", PlatformType: "k8s"}); !errors.As(err, &pf) {
+			t.Fatalf("Expected PostFailure on UID %q, got %v", invalid, err)
+		}
+	}
+	if err = db.DeleteClusterEntry("production"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.CreateOrUpdateClusterEntry(cinfo); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on deleted UID, got %v", err)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "staging", PlatformType: "k8s"}); err != nil {
+		t.Fatal(err)
+	}
+	taken := types.ClusterInfo{UID: "fedcba9876543210fedcba9876543210", Name: "staging", PlatformType: "k8s"}
+	if _, err = db.CreateOrUpdateClusterEntry(taken); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on taken name, got %v", err)
+	}
+	if clusters, err = db.GetClusters(); err != nil || len(clusters.Clusters) != 1 || clusters.Clusters[0].UID == taken.UID {
+		t.Fatalf("Unexpected clusters %+v: %v", clusters.Clusters, err)
+	}
+}
+
+// TestClusterRename checks renames change only the name of the cluster, keeping
+// its UID and the rows of its agent memberships
+func TestClusterRename(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cinfo := types.ClusterInfo{Name: "prod", PlatformType: "k8s", Labels: map[string]string{"env": "prod"},
+		AgentsList: []string{"agent1", "agent2"}}
+	if err = db.CreateClusterEntry(cinfo); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "staging", PlatformType: "k8s"}); err != nil {
+		t.Fatal(err)
+	}
+	database := db.(*LocalSqliteDb).database
+	memberships := func() string {
+		rows, err := database.Query(`SELECT cluster_id, agent_id FROM cluster_memberships ORDER BY agent_id`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		ids := []string{}
+		for rows.Next() {
+			var clusterID, agentID int64
+			if err = rows.Scan(&clusterID, &agentID); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, fmt.Sprintf("%d:%d", clusterID, agentID))
+		}
+		return strings.Join(ids, ",")
+	}
+	before := memberships()
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := clusters.Clusters[0].UID
+
+	// ATTEMPT rename cluster; should keep UID, agents and membership rows [RenameClusterEntry]
+	result, err := db.RenameClusterEntry("prod", "production")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := types.ClusterEditResult{Name: "production",
+		Changes: []types.FieldChange{{Field: "name", Before: "prod", After: "production"}}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected result %+v, got %+v", expected, result)
+	}
+	if after := memberships(); after != before {
+		t.Fatalf("Expected memberships %s to be kept, got %s", before, after)
+	}
+	clusters, err = db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := clusters.Clusters[0]
+	if renamed.Name != "production" || renamed.UID != uid || renamed.Labels["env"] != "prod" ||
+		!reflect.DeepEqual(renamed.AgentsList, []string{"agent1", "agent2"}) {
+		t.Fatalf("Unexpected renamed cluster %+v", renamed)
+	}
+	changes, err := db.GetClusterChanges(1)
+	if err != nil || len(changes) != 1 || changes[0].Change != types.ClusterChangeUpdated || changes[0].Name != "production" {
+		t.Fatalf("Unexpected changes %+v: %v", changes, err)
+	}
+
+	// ATTEMPT rename to the same name; should change nothing [RenameClusterEntry]
+	result, err = db.RenameClusterEntry("production", "production")
+	if err != nil || len(result.Changes) != 0 {
+		t.Fatalf("Expected no changes, got %+v: %v", result, err)
+	}
+
+	// CHECK renames to taken names and of unknown clusters fail [RenameClusterEntry]
+	var pf PostFailure
+	if _, err = db.RenameClusterEntry("production", "staging"); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on taken name, got %v", err)
+	}
+	if _, err = db.RenameClusterEntry("prod", "other"); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on unknown cluster, got %v", err)
+	}
+	if after := memberships(); after != before {
+		t.Fatalf("Expected memberships %s to be kept, got %s", before, after)
+	}
+}
+
+// TestClusterBulkCreate checks a bulk creation creates the clusters it can in
+// one transaction, and reports the others with the reason they were left out
+func TestClusterBulkCreate(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "existing", AgentsList: []string{"agent1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT bulk creation of new, existing, repeated, conflicting and invalid clusters [CreateClusterEntries]
+	result, err := db.CreateClusterEntries([]types.ClusterInfo{
+		{Name: "cluster1", PlatformType: "k8s", Labels: map[string]string{"env": "prod"}, AgentsList: []string{"agent2"}},
+		{Name: "existing"},
+		{Name: "cluster1"},
+		{Name: "conflict", AgentsList: []string{"agent3", "agent1"}},
+		{Name: "invalid", Metadata: json.RawMessage(`{"a":`)},
+		{Name: "cluster2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct{ status, code string }{
+		{types.BatchItemSucceeded, ""},
+		{types.BatchItemSkipped, types.BatchCodeAlreadyExists},
+		{types.BatchItemFailed, types.BatchCodeDuplicate},
+		{types.BatchItemFailed, types.BatchCodeFailedPrecondition},
+		{types.BatchItemFailed, types.BatchCodeInvalidArgument},
+		{types.BatchItemSucceeded, ""},
+	}
+	if len(result.Items) != len(expected) || result.Succeeded != 2 || result.Skipped != 1 || result.Failed != 3 {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	for i, e := range expected {
+		item := result.Items[i]
+		if item.Index != i || item.Status != e.status || item.Code != e.code {
+			t.Fatalf("Expected item %d %s %s, got %+v", i, e.status, e.code, item)
+		}
+	}
+
+	// CHECK only the created clusters are stored, without the agents of the conflicting one [GetClusters]
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, c := range clusters.Clusters {
+		names = append(names, c.Name)
+		if c.Name == "cluster1" && (c.Labels["env"] != "prod" || !reflect.DeepEqual(c.AgentsList, []string{"agent2"})) {
+			t.Fatalf("Unexpected created cluster %+v", c)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"cluster1", "cluster2", "existing"}) {
+		t.Fatalf("Expected clusters cluster1, cluster2 and existing, got %v", names)
+	}
+	if name, err := db.GetAgentClusterName("agent3"); err == nil {
+		t.Fatalf("Expected agent3 in no cluster, got %q", name)
+	}
+	changes, err := db.GetClusterChanges(10)
+	if err != nil || len(changes) != 3 {
+		t.Fatalf("Expected 3 cluster changes, got %+v: %v", changes, err)
+	}
+}
+
+// TestClusterDeleteInBackground checks deletes of clusters with many agents
+// remove the cluster at once and its memberships in batches, and that their
+// agents are unassigned from the start
+func TestClusterDeleteInBackground(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleter := db.(BackgroundClusterDeleter)
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "small", AgentsList: []string{"agent0"}}); err != nil {
+		t.Fatal(err)
+	}
+	large := []string{"agent1", "agent2", "agent3", "agent4", "agent5"}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "large", Labels: map[string]string{"env": "prod"}, AgentsList: large}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT delete of a cluster below the threshold; should delete its memberships too [DeleteClusterEntryInBackground]
+	deletion, err := deleter.DeleteClusterEntryInBackground("small", 3)
+	if err != nil || deletion != nil {
+		t.Fatalf("Expected no deletion in background, got %+v: %v", deletion, err)
+	}
+	if name, err := db.GetAgentClusterName("agent0"); err == nil {
+		t.Fatalf("Expected agent0 in no cluster, got %q", name)
+	}
+
+	// ATTEMPT delete of a cluster at the threshold; should keep its memberships [DeleteClusterEntryInBackground]
+	deletion, err = deleter.DeleteClusterEntryInBackground("large", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deletion == nil || deletion.Name != "large" || deletion.UID == "" || deletion.Memberships != 5 || deletion.Remaining != 5 {
+		t.Fatalf("Unexpected deletion %+v", deletion)
+	}
+	clusters, err := db.GetClusters()
+	if err != nil || len(clusters.Clusters) != 0 {
+		t.Fatalf("Expected no clusters, got %+v: %v", clusters.Clusters, err)
+	}
+	deleted, err := db.ListDeletedClusters()
+	if err != nil || len(deleted.Clusters) != 2 || !reflect.DeepEqual(deleted.Clusters[0].Cluster.AgentsList, large) {
+		t.Fatalf("Expected deleted cluster large with its agents, got %+v: %v", deleted.Clusters, err)
+	}
+	if name, err := db.GetAgentClusterName("agent1"); err == nil {
+		t.Fatalf("Expected agent1 in no cluster, got %q", name)
+	}
+
+	// CHECK the memberships being removed are not orphaned, and the cluster cannot be restored yet [CheckIntegrity, RestoreClusterEntry]
+	report, err := db.(IntegrityChecker).CheckIntegrity(context.Background())
+	if err != nil || report.Errors != 0 {
+		t.Fatalf("Expected no integrity errors, got %+v: %v", report, err)
+	}
+	var pf PostFailure
+	if _, err = db.RestoreClusterEntry(deletion.UID); !errors.As(err, &pf) {
+		t.Fatalf("Expected PostFailure on restore, got %v", err)
+	}
+
+	// ATTEMPT assign an agent of the deleted cluster to another cluster [CreateClusterEntry]
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "other", AgentsList: []string{"agent1"}}); err != nil {
+		t.Fatal(err)
+	}
+	deletions, err := deleter.GetClusterDeletions()
+	if err != nil || len(deletions.Deletions) != 1 || deletions.Deletions[0].Remaining != 4 {
+		t.Fatalf("Expected 4 memberships remaining, got %+v: %v", deletions, err)
+	}
+
+	// ATTEMPT remove the memberships in batches [DeleteClusterMemberships]
+	for _, expected := range []int{1, 0, 0} {
+		remaining, err := deleter.DeleteClusterMemberships(deletion.ID, 3)
+		if err != nil || remaining != expected {
+			t.Fatalf("Expected %d memberships remaining, got %d: %v", expected, remaining, err)
+		}
+	}
+	deletions, err = deleter.GetClusterDeletions()
+	if err != nil || len(deletions.Deletions) != 0 {
+		t.Fatalf("Expected no deletions, got %+v: %v", deletions, err)
+	}
+	if name, err := db.GetAgentClusterName("agent1"); err != nil || name != "other" {
+		t.Fatalf("Expected agent1 in cluster other, got %q: %v", name, err)
+	}
+
+	// CHECK the cluster is restored once its memberships are removed [RestoreClusterEntry]
+	restored, err := db.RestoreClusterEntry(deletion.UID)
+	if err != nil || !reflect.DeepEqual(restored.SkippedAgents, []string{"agent1"}) {
+		t.Fatalf("Unexpected restore %+v: %v", restored, err)
+	}
+	agents, err := db.GetClusterAgents("large")
+	if err != nil || !reflect.DeepEqual(agents, large[1:]) {
+		t.Fatalf("Unexpected agents of restored cluster %v: %v", agents, err)
+	}
+}
+
+// TestClusterBulkDelete checks a bulk deletion deletes the clusters it can in
+// one transaction, and reports the others with the reason they were left
+func TestClusterBulkDelete(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uids := map[string]string{}
+	for _, cinfo := range []types.ClusterInfo{
+		{Name: "cluster1", Labels: map[string]string{"env": "prod"}, AgentsList: []string{"agent1"}},
+		{Name: "protected", Protected: true, AgentsList: []string{"agent2"}},
+		{Name: "large", AgentsList: []string{"agent3", "agent4"}},
+	} {
+		if err = db.CreateClusterEntry(cinfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clusters.Clusters {
+		uids[c.Name] = c.UID
+	}
+
+	// ATTEMPT bulk deletion of existing, protected, unknown, repeated and missing UIDs [DeleteClusterEntriesInBackground]
+	deleter := db.(BackgroundClusterDeleter)
+	result, err := deleter.DeleteClusterEntriesInBackground([]string{
+		uids["cluster1"], uids["protected"], "0123456789abcdef0123456789abcdef", uids["cluster1"], "", uids["large"],
+	}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct{ status, code string }{
+		{types.BatchItemSucceeded, ""},
+		{types.BatchItemFailed, types.BatchCodePermissionDenied},
+		{types.BatchItemFailed, types.BatchCodeNotFound},
+		{types.BatchItemFailed, types.BatchCodeDuplicate},
+		{types.BatchItemFailed, types.BatchCodeInvalidArgument},
+		{types.BatchItemSucceeded, ""},
+	}
+	if len(result.Items) != len(expected) || result.Succeeded != 2 || result.Failed != 4 {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	for i, e := range expected {
+		item := result.Items[i]
+		if item.Index != i || item.Status != e.status || item.Code != e.code {
+			t.Fatalf("Expected item %d %s %s, got %+v", i, e.status, e.code, item)
+		}
+	}
+
+	// CHECK only the protected cluster remains, with its agent [GetClusters]
+	clusters, err = db.GetClusters()
+	if err != nil || len(clusters.Clusters) != 1 || clusters.Clusters[0].Name != "protected" ||
+		!reflect.DeepEqual(clusters.Clusters[0].AgentsList, []string{"agent2"}) {
+		t.Fatalf("Expected the protected cluster only, got %+v: %v", clusters.Clusters, err)
+	}
+	if name, err := db.GetAgentClusterName("agent1"); err == nil {
+		t.Fatalf("Expected agent1 in no cluster, got %q", name)
+	}
+	deleted, err := db.ListDeletedClusters()
+	if err != nil || len(deleted.Clusters) != 2 {
+		t.Fatalf("Expected 2 deleted clusters, got %+v: %v", deleted, err)
+	}
+
+	// CHECK the memberships of the large cluster are left to a deletion [GetClusterDeletions]
+	deletions, err := deleter.GetClusterDeletions()
+	if err != nil || len(deletions.Deletions) != 1 || deletions.Deletions[0].Name != "large" || deletions.Deletions[0].Remaining != 2 {
+		t.Fatalf("Expected the deletion of large, got %+v: %v", deletions, err)
+	}
+}
+
+// TestAuditEvents checks changes of clusters, memberships and agents are
+// recorded with their actors, and listed most recent first
+func TestAuditEvents(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cinfo := types.ClusterInfo{Name: "prod", PlatformType: "k8s", AgentsList: []string{"agent1"}}
+	// ATTEMPT create cluster as a user; cluster and membership recorded [WithActor, CreateClusterEntry, GetAuditEvents]
+	if err = db.WithActor("alice").CreateClusterEntry(cinfo); err != nil {
+		t.Fatal(err)
+	}
+	events, err := db.GetAuditEvents(types.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events.Total != 2 || events.Items[0].ObjectType != types.AuditObjectMembership ||
+		events.Items[1].ObjectType != types.AuditObjectCluster {
+		t.Fatalf("Unexpected events %+v", events.Items)
+	}
+	uid := events.Items[1].ObjectId
+	for _, e := range events.Items {
+		if e.Actor != "alice" || e.Action != types.AuditActionCreate || e.Before != nil || e.Timestamp == "" {
+			t.Fatalf("Unexpected event %+v", e)
+		}
+	}
+
+	// ATTEMPT move cluster to another agent; only memberships recorded [EditClusterEntry]
+	cinfo.EditedName, cinfo.AgentsList = "prod", []string{"agent2"}
+	if _, err = db.WithActor("alice").EditClusterEntry(cinfo); err != nil {
+		t.Fatal(err)
+	}
+	events, err = db.GetAuditEvents(types.ListOptions{Filters: []types.Filter{{Field: "objectType", Value: types.AuditObjectMembership}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events.Total != 3 || events.Items[0].ObjectId != "agent2" || events.Items[0].Action != types.AuditActionCreate ||
+		events.Items[1].ObjectId != "agent1" || events.Items[1].Action != types.AuditActionDelete {
+		t.Fatalf("Unexpected membership events %+v", events.Items)
+	}
+	if string(events.Items[1].Before) != `{"clusterUid":"`+uid+`","cluster":"prod"}` {
+		t.Fatalf("Unexpected membership state %s", events.Items[1].Before)
+	}
+
+	// ATTEMPT set display name twice; recorded once [SetAgentDisplayName]
+	for i := 0; i < 2; i++ {
+		if err = db.WithActor("bob").SetAgentDisplayName("agent2", "Agent Two"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, err = db.GetAuditEvents(types.ListOptions{Filters: []types.Filter{{Field: "actor", Value: "bob"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events.Total != 1 || events.Items[0].ObjectType != types.AuditObjectAgent || events.Items[0].ObjectId != "agent2" ||
+		string(events.Items[0].After) != `{"spiffeid":"agent2","displayName":"Agent Two"}` {
+		t.Fatalf("Unexpected agent events %+v", events.Items)
+	}
+
+	// ATTEMPT delete cluster without an actor [DeleteClusterEntry]
+	if err = db.DeleteClusterEntry("prod"); err != nil {
+		t.Fatal(err)
+	}
+	events, err = db.GetAuditEvents(types.ListOptions{Filters: []types.Filter{
+		{Field: "objectType", Value: types.AuditObjectCluster}, {Field: "objectId", Value: uid}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events.Total != 2 || events.Items[0].Action != types.AuditActionDelete || events.Items[0].Actor != "" ||
+		events.Items[0].Before == nil || events.Items[0].After != nil {
+		t.Fatalf("Unexpected cluster events %+v", events.Items)
+	}
+
+	// CHECK pages, sorts and unknown fields [GetAuditEvents]
+	events, err = db.GetAuditEvents(types.ListOptions{Limit: 2})
+	if err != nil || events.Total != 7 || len(events.Items) != 2 || events.NextCursor == "" {
+		t.Fatalf("Unexpected page %+v: %v", events, err)
+	}
+	if _, err = db.GetAuditEvents(types.ListOptions{Sort: []types.SortField{{Field: "actor"}}}); err == nil {
+		t.Fatal("Expected error on sorted audit events")
+	}
+	if _, err = db.GetAuditEvents(types.ListOptions{Filters: []types.Filter{{Field: "before", Value: "x"}}}); err == nil {
+		t.Fatal("Expected error on unknown filter field")
+	}
+}
+
+// TestClusterAuditHistory checks the events of a cluster and of its
+// memberships are listed with the fields changed by each edit
+func TestClusterAuditHistory(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cinfo := types.ClusterInfo{Name: "prod", PlatformType: "k8s", AgentsList: []string{"agent1"}}
+	// ATTEMPT create, rename and extend a cluster, and change one of its agents [CreateClusterEntry, EditClusterEntry]
+	if err = db.WithActor("alice").CreateClusterEntry(cinfo); err != nil {
+		t.Fatal(err)
+	}
+	cinfo.EditedName, cinfo.AgentsList = "production", []string{"agent1", "agent2"}
+	if _, err = db.WithActor("bob").EditClusterEntry(cinfo); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SetAgentDisplayName("agent1", "Agent One"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "dev", AgentsList: []string{"agent3"}}); err != nil {
+		t.Fatal(err)
+	}
+	created, err := db.GetAuditEvents(types.ListOptions{Filters: []types.Filter{
+		{Field: "objectType", Value: types.AuditObjectCluster}, {Field: "action", Value: types.AuditActionCreate}}})
+	if err != nil || created.Total != 2 {
+		t.Fatalf("Unexpected cluster events %+v: %v", created, err)
+	}
+	uid := created.Items[1].ObjectId
+
+	// CHECK history of the cluster, without agent events and other clusters [GetAuditEvents, ClusterHistory]
+	events, err := db.GetAuditEvents(types.ListOptions{Filters: []types.Filter{{Field: "clusterUid", Value: uid}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := ClusterHistory(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Total != 4 {
+		t.Fatalf("Unexpected history %+v", history.Items)
+	}
+	for _, e := range history.Items {
+		if e.ClusterUID != uid || (e.ObjectType == types.AuditObjectMembership) != (len(e.Changes) == 0) {
+			t.Fatalf("Unexpected history event %+v", e)
+		}
+	}
+	membership, edit := history.Items[0], history.Items[1]
+	if membership.ObjectId != "agent2" || membership.Action != types.AuditActionCreate || membership.Actor != "bob" {
+		t.Fatalf("Unexpected membership event %+v", membership)
+	}
+	expected := []types.FieldChange{{Field: "name", Before: "prod", After: "production"},
+		{Field: "agentsList", Before: []string{"agent1"}, After: []string{"agent1", "agent2"}}}
+	if edit.Action != types.AuditActionEdit || edit.Actor != "bob" || !reflect.DeepEqual(edit.Changes, expected) {
+		t.Fatalf("Unexpected edit event %+v", edit)
+	}
+	if history.Items[3].Action != types.AuditActionCreate || history.Items[3].Changes[0].Before != "" {
+		t.Fatalf("Unexpected create event %+v", history.Items[3])
+	}
+}
+
+// TestAgentAnnotations checks annotations are set on known and unknown agents,
+// overwritten, listed with the agents and deleted
+func TestAgentAnnotations(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	db, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spiffeid1 := "spiffe://example.org/spire/agent/join_token/1"
+	spiffeid2 := "spiffe://example.org/spire/agent/join_token/2"
+	if err = db.CreateAgentEntry(types.AgentInfo{Spiffeid: spiffeid1, Plugin: "Docker"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT annotate a stored agent and an agent not stored yet [SetAgentAnnotation]
+	for _, a := range []types.AgentAnnotation{
+		{Spiffeid: spiffeid1, Key: "status", Value: "in service", UpdatedBy: "alice", UpdatedAt: "2024-03-01T12:00:00Z"},
+		{Spiffeid: spiffeid1, Key: "hardware", Value: "2x Xeon Gold 6338", UpdatedBy: "alice", UpdatedAt: "2024-03-01T12:00:00Z"},
+		{Spiffeid: spiffeid2, Key: "status", Value: "in service", UpdatedBy: "alice", UpdatedAt: "2024-03-01T12:00:00Z"},
+		{Spiffeid: spiffeid1, Key: "status", Value: "pending decommission", UpdatedBy: "bob", UpdatedAt: "2024-03-02T12:00:00Z"},
+	} {
+		if err = db.SetAgentAnnotation(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// CHECK annotations of an agent, the last value kept [GetAgentAnnotations]
+	annotations, err := db.GetAgentAnnotations([]string{spiffeid1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []types.AgentAnnotation{
+		{Spiffeid: spiffeid1, Key: "hardware", Value: "2x Xeon Gold 6338", UpdatedBy: "alice", UpdatedAt: "2024-03-01T12:00:00Z"},
+		{Spiffeid: spiffeid1, Key: "status", Value: "pending decommission", UpdatedBy: "bob", UpdatedAt: "2024-03-02T12:00:00Z"},
+	}
+	if !reflect.DeepEqual(annotations.Annotations, expected) {
+		t.Fatalf("Expected annotations %+v, got %+v", expected, annotations.Annotations)
+	}
+	annotations, err = db.GetAgentAnnotations(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annotations.Annotations) != 3 {
+		t.Fatalf("Expected 3 annotations, got %+v", annotations.Annotations)
+	}
+
+	// CHECK annotations listed with the agents [GetAgentsMetadata]
+	agents, err := db.GetAgentsMetadata(types.AgentMetadataRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents.Agents) != 2 || agents.Agents[0].Annotations["status"] != "pending decommission" ||
+		agents.Agents[1].Annotations["status"] != "in service" {
+		t.Fatalf("Unexpected agents %+v", agents.Agents)
+	}
+
+	// ATTEMPT delete an annotation [DeleteAgentAnnotation]
+	if err = db.DeleteAgentAnnotation(spiffeid1, "status"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteAgentAnnotation(spiffeid1, "status"); err == nil {
+		t.Fatal("Expected deleting a deleted annotation to fail")
+	}
+	annotations, err = db.GetAgentAnnotations([]string{spiffeid1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annotations.Annotations) != 1 || annotations.Annotations[0].Key != "hardware" {
+		t.Fatalf("Unexpected annotations %+v", annotations.Annotations)
+	}
+}
+
+// TestIntegrityCheck checks rows left inconsistent are reported with fixes
+// restoring the integrity of the DB
+func TestIntegrityCheck(t *testing.T) {
+	cleanup()
+	defer cleanup()
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = time.Second
+	agentDB, err := NewLocalSqliteDB("sqlite3", "./local-agentstest-db", expBackoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := agentDB.(*LocalSqliteDb)
+	ctx := context.Background()
+
+	// CHECK the DB was never checked [GetIntegrityReport]
+	report, err := db.GetIntegrityReport()
+	if err != nil || report.CheckedAt != "" || len(report.Findings) != 0 {
+		t.Fatalf("Unexpected report before the first check %+v: %v", report, err)
+	}
+
+	// ATTEMPT check a consistent DB [CheckIntegrity]
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "prod", AgentsList: []string{"agent1", "agent2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateClusterEntry(types.ClusterInfo{Name: "dev", AgentsList: []string{"agent3"}}); err != nil {
+		t.Fatal(err)
+	}
+	report, err = db.CheckIntegrity(ctx)
+	if err != nil || report.CheckedAt == "" || len(report.Findings) != 0 {
+		t.Fatalf("Unexpected report of a consistent DB %+v: %v", report, err)
+	}
+
+	// ATTEMPT check rows written without foreign keys [CheckIntegrity]
+	for _, cmd := range []string{
+		`DELETE FROM agents WHERE spiffeid='agent1'`,
+		`DELETE FROM clusters WHERE name='dev'`,
+		`UPDATE agents SET spiffeid='' WHERE spiffeid='agent2'`,
+		`UPDATE clusters SET uid=NULL WHERE name='prod'`,
+		`INSERT INTO agent_labels (agent_id, label, value) VALUES (999, 'env', 'prod')`,
+		`INSERT INTO agent_compliance (agent_id, attribute, value) VALUES (999, 'os', 'linux')`,
+	} {
+		if _, err = db.database.Exec(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err = db.CheckIntegrity(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := map[string]int{}
+	for _, f := range report.Findings {
+		checks[f.Check]++
+	}
+	expected := map[string]int{types.IntegrityOrphanedMembership: 2, types.IntegrityAgentMissingSpiffeid: 1,
+		types.IntegrityClusterMissingUID: 1, types.IntegrityDanglingReference: 2}
+	if !reflect.DeepEqual(checks, expected) || report.Errors != 4 || report.Warnings != 2 {
+		t.Fatalf("Unexpected findings %+v", report)
+	}
+
+	// CHECK the report is stored [GetIntegrityReport]
+	stored, err := db.GetIntegrityReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored, report) {
+		t.Fatalf("Stored report %+v differs from the report of the check %+v", stored, report)
+	}
+
+	// ATTEMPT apply the suggested fixes until none is left [CheckIntegrity]
+	for i := 0; len(report.Findings) > 0; i++ {
+		if i == 3 {
+			t.Fatalf("Findings left after applying the fixes %+v", report.Findings)
+		}
+		for _, f := range report.Findings {
+			cmd := f.Fix[strings.Index(f.Fix, ": ")+2:]
+			if _, err = db.database.Exec(cmd); err != nil {
+				t.Fatalf("Fix %q of %+v failed: %v", cmd, f, err)
+			}
+		}
+		if report, err = db.CheckIntegrity(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -3,23 +3,79 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	sqlite3 "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 
+	"github.com/spiffe/tornjak/pkg/agent/clock"
 	"github.com/spiffe/tornjak/pkg/agent/types"
 )
 
 type tornjakTxHelper struct {
 	ctx context.Context
 	tx  *sql.Tx
+
+	// name of the DB operation, outcomes are counted in metrics under it
+	operation string
+	metrics   *txMetrics
+
+	// source of the creation and change times written in the transaction
+	clock clock.Clock
+
+	// user or component making the changes, recorded in the audit log
+	actor string
+}
+
+func getTornjakTxHelper(ctx context.Context, tx *sql.Tx, metrics *txMetrics, c clock.Clock, actor string, operation string) *tornjakTxHelper {
+	return &tornjakTxHelper{ctx, tx, operation, metrics, c, actor}
 }
 
-func getTornjakTxHelper(ctx context.Context, tx *sql.Tx) *tornjakTxHelper {
-	return &tornjakTxHelper{ctx, tx}
+// now returns the time of the changes made in the transaction, as stored
+func (t *tornjakTxHelper) now() string {
+	return FormatTimestamp(t.clock.Now())
+}
+
+// touchCluster sets the time of the last change of the cluster to now
+// returns SQLError on failure
+func (t *tornjakTxHelper) touchCluster(clustername string) error {
+	cmd := `UPDATE clusters SET updated_at=? WHERE name=?`
+	if _, err := t.tx.ExecContext(t.ctx, cmd, t.now(), clustername); err != nil {
+		return SQLError{cmd, err}
+	}
+	return nil
+}
+
+// touchAgents sets the time of the last change of the agents of the given spiffeids to now
+// returns SQLError on failure
+func (t *tornjakTxHelper) touchAgents(spiffeids []string) error {
+	if len(spiffeids) == 0 {
+		return nil
+	}
+	cmd := `UPDATE agents SET updated_at=? WHERE spiffeid IN (` + strings.TrimSuffix(strings.Repeat("?,", len(spiffeids)), ",") + `)`
+	vals := make([]interface{}, 0, len(spiffeids)+1)
+	vals = append(vals, t.now())
+	for _, spiffeid := range spiffeids {
+		vals = append(vals, spiffeid)
+	}
+	if _, err := t.tx.ExecContext(t.ctx, cmd, vals...); err != nil {
+		return SQLError{cmd, err}
+	}
+	return nil
+}
+
+// commit commits the transaction and counts the outcome
+func (t *tornjakTxHelper) commit() error {
+	err := t.tx.Commit()
+	t.metrics.commit(t.operation, err)
+	if err != nil {
+		logTx(txLogRecord{Operation: t.operation, Outcome: "commit_failed", Error: err.Error()})
+	}
+	return err
 }
 
 func (t *tornjakTxHelper) rollbackHandler(err error) error {
@@ -27,6 +83,16 @@ func (t *tornjakTxHelper) rollbackHandler(err error) error {
 		return errors.New("Rollback handler called upon no error")
 	} else {
 		rollbackErr := t.tx.Rollback()
+		cause := classifyRollback(err)
+		t.metrics.rollback(t.operation, cause)
+		if category, ok := PostFailureCategory(err); ok {
+			t.metrics.postFailure(t.operation, category)
+		}
+		record := txLogRecord{Operation: t.operation, Outcome: "rollback", Cause: cause, Error: err.Error()}
+		if rollbackErr != nil {
+			record.RollbackError = rollbackErr.Error()
+		}
+		logTx(record)
 		var rollbackStatus string
 		if rollbackErr != nil {
 			rollbackStatus = fmt.Sprintf("[Unsuccessful rollback [%v] upon error]", rollbackErr.Error())
@@ -38,26 +104,216 @@ func (t *tornjakTxHelper) rollbackHandler(err error) error {
 		} else if serr, ok := err.(GetError); ok {
 			return GetError{fmt.Sprintf("%v: %v", serr.Message, rollbackStatus)}
 		} else if serr, ok := err.(PostFailure); ok {
-			return PostFailure{fmt.Sprintf("%v: %v", serr.Message, rollbackStatus)}
+			return PostFailure{Message: fmt.Sprintf("%v: %v", serr.Message, rollbackStatus)}
 		} else {
 			return errors.Errorf("%v: %v", err.Error(), rollbackStatus)
 		}
 	}
 }
 
-// insertClusterMetadata attempts insert into table clusters
+// isClusterNameCaseConflict returns whether a constraint error was raised by the case-insensitive cluster name index
+func isClusterNameCaseConflict(serr sqlite3.Error) bool {
+	return strings.Contains(serr.Error(), "clusters_name_nocase")
+}
+
+// newClusterUID is the SQL expression generating the UID of a new cluster
+const newClusterUID = `lower(hex(randomblob(16)))`
+
+// createCluster inserts the cluster with its agents, extension fields and
+// labels, and records it in the history
+// the cluster gets UID uid, or a new UID if empty
+func (t *tornjakTxHelper) createCluster(cinfo types.ClusterInfo, uid string) error {
+	// INSERT cluster metadata
+	err := t.insertClusterMetadata(cinfo, uid)
+	if err != nil {
+		return err
+	}
+	return t.addClusterContents(cinfo)
+}
+
+// addClusterContents adds the agents, extension fields and labels of the
+// cluster cinfo.Name just inserted, and records it in the history
+func (t *tornjakTxHelper) addClusterContents(cinfo types.ClusterInfo) error {
+	// ADD agents to cluster
+	err := t.addAgentBatchToCluster(cinfo.Name, cinfo.AgentsList)
+	if err != nil {
+		return err
+	}
+
+	// ADD extension fields of cluster
+	err = t.setClusterExtensions(cinfo.Name, cinfo.Extensions)
+	if err != nil {
+		return err
+	}
+
+	// ADD labels of cluster
+	err = t.setClusterLabels(cinfo.Name, cinfo.Labels)
+	if err != nil {
+		return err
+	}
+
+	// ADD cluster to history
+	return t.recordClusterHistory(cinfo.Name, types.ClusterChangeCreated)
+}
+
+// savepoint runs fn in a savepoint of the transaction, so a failure of fn
+// rolls back the changes of fn only
+// returns the error of fn, or SQLError if the savepoint fails
+func (t *tornjakTxHelper) savepoint(fn func() error) error {
+	cmd := `SAVEPOINT tornjak_item`
+	if _, err := t.tx.ExecContext(t.ctx, cmd); err != nil {
+		return SQLError{cmd, err}
+	}
+	fnErr := fn()
+	cmds := []string{`RELEASE SAVEPOINT tornjak_item`}
+	if fnErr != nil {
+		cmds = []string{`ROLLBACK TO SAVEPOINT tornjak_item`, `RELEASE SAVEPOINT tornjak_item`}
+	}
+	for _, cmd := range cmds {
+		if _, err := t.tx.ExecContext(t.ctx, cmd); err != nil {
+			return SQLError{cmd, err}
+		}
+	}
+	return fnErr
+}
+
+// clusterBatchTx returns the transaction of a bulk creation of clusters
+func (t *tornjakTxHelper) clusterBatchTx() ClusterBatchTx {
+	return ClusterBatchTx{
+		Savepoint: t.savepoint,
+		InsertCluster: func(cinfo types.ClusterInfo) error {
+			return t.insertClusterMetadata(cinfo, "")
+		},
+		AddClusterContents: t.addClusterContents,
+	}
+}
+
+// clusterDeleteBatchTx returns the transaction of a bulk deletion of
+// clusters, leaving the memberships of clusters with minMemberships agents or
+// more to deletions unless minMemberships is 0
+func (t *tornjakTxHelper) clusterDeleteBatchTx(minMemberships int) ClusterDeleteBatchTx {
+	return ClusterDeleteBatchTx{
+		Savepoint:            t.savepoint,
+		LockCluster:          t.lockClusterByUID,
+		LockDeletableCluster: t.lockDeletableCluster,
+		DeleteCluster: func(name string) error {
+			if minMemberships == 0 {
+				return t.deleteCluster(name, false)
+			}
+			memberships, err := t.countClusterMemberships(name)
+			if err != nil {
+				return err
+			}
+			return t.deleteCluster(name, memberships >= minMemberships)
+		},
+	}
+}
+
+// editCluster replaces the cluster cinfo.Name by cinfo, renamed to
+// cinfo.EditedName, and records it in the history
+// returns the fields changed from the stored cluster
+func (t *tornjakTxHelper) editCluster(cinfo types.ClusterInfo) (types.ClusterEditResult, error) {
+	// GET current cluster
+	before, err := t.getClusterForUpdate(cinfo.Name)
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	// UPDATE cluster metadata
+	err = t.updateClusterMetadata(cinfo)
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	// REMOVE all currently assigned cluster agents
+	err = t.deleteClusterAgents(cinfo.EditedName)
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	// ADD agents to cluster
+	err = t.addAgentBatchToCluster(cinfo.EditedName, cinfo.AgentsList)
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	// REPLACE extension fields of cluster
+	err = t.setClusterExtensions(cinfo.EditedName, cinfo.Extensions)
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	// REPLACE labels of cluster
+	err = t.setClusterLabels(cinfo.EditedName, cinfo.Labels)
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	// ADD edited cluster to history
+	err = t.recordClusterHistory(cinfo.EditedName, types.ClusterChangeUpdated)
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	after := cinfo
+	after.Name = cinfo.EditedName
+	return types.ClusterEditResult{Name: cinfo.EditedName, Changes: types.DiffClusters(before, after)}, nil
+}
+
+// lockClusterByUID returns the name of the cluster with the given UID, and
+// takes the write lock so the cluster cannot be created or changed by another
+// transaction before this one ends
+// returns SQLError on failure
+func (t *tornjakTxHelper) lockClusterByUID(uid string) (string, bool, error) {
+	cmdLock := `UPDATE clusters SET uid=uid WHERE uid=?`
+	if _, err := t.tx.ExecContext(t.ctx, cmdLock, uid); err != nil {
+		return "", false, SQLError{cmdLock, err}
+	}
+	var name string
+	cmd := `SELECT name FROM clusters WHERE uid=?`
+	err := t.tx.QueryRowContext(t.ctx, cmd, uid).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, SQLError{cmd, err}
+	}
+	return name, true, nil
+}
+
+// isDeletedCluster returns whether a deleted cluster with the given UID can be restored
+// returns SQLError on failure
+func (t *tornjakTxHelper) isDeletedCluster(uid string) (bool, error) {
+	var count int
+	cmd := `SELECT COUNT(*) FROM deleted_clusters WHERE uid=?`
+	if err := t.tx.QueryRowContext(t.ctx, cmd, uid).Scan(&count); err != nil {
+		return false, SQLError{cmd, err}
+	}
+	return count > 0, nil
+}
+
+// insertClusterMetadata attempts insert into table clusters with UID uid, or a new UID if empty
 // returns SQLError upon failure and PostFailure on cluster existence
-func (t *tornjakTxHelper) insertClusterMetadata(cinfo types.ClusterInfo) error {
-	cmdInsert := `INSERT INTO clusters (name, created_at, domain_name, managed_by, platform_type) VALUES (?,?,?,?,?)`
+func (t *tornjakTxHelper) insertClusterMetadata(cinfo types.ClusterInfo, uid string) error {
+	metadata, err := MetadataValue(cinfo.Metadata)
+	if err != nil {
+		return err
+	}
+	cmdInsert := `INSERT INTO clusters (name, created_at, updated_at, domain_name, managed_by, platform_type, 
+                owner_email, owner_team, slack_channel, tenant, protected, metadata, uid) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,COALESCE(NULLIF(?, ''), ` + newClusterUID + `))`
 	statement, err := t.tx.PrepareContext(t.ctx, cmdInsert)
 	if err != nil {
 		return SQLError{cmdInsert, err}
 	}
 	defer statement.Close()
-	_, err = statement.ExecContext(t.ctx, cinfo.Name, time.Now().Format("Jan 02 2006 15:04:05"), cinfo.DomainName, cinfo.ManagedBy, cinfo.PlatformType)
+	now := t.now()
+	_, err = statement.ExecContext(t.ctx, cinfo.Name, now, now, cinfo.DomainName, cinfo.ManagedBy, cinfo.PlatformType,
+		cinfo.OwnerEmail, cinfo.OwnerTeam, cinfo.SlackChannel, cinfo.Tenant, cinfo.Protected, metadata, uid)
 	if err != nil {
 		if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrConstraint {
-			return PostFailure{"Cluster already exists; use Edit Cluster"}
+			if isClusterNameCaseConflict(serr) {
+				return ClusterExistsFailure("Cluster already exists (cluster names are case-insensitive); use Edit Cluster")
+			}
+			return ClusterExistsFailure("Cluster already exists; use Edit Cluster")
 		}
 		return SQLError{cmdInsert, err}
 	}
@@ -67,16 +323,25 @@ func (t *tornjakTxHelper) insertClusterMetadata(cinfo types.ClusterInfo) error {
 // updateClusterMetadata attempts update of entry in table clusters
 // returns SQLError on failure and PostFailure on cluster non-existence
 func (t *tornjakTxHelper) updateClusterMetadata(cinfo types.ClusterInfo) error {
-	cmdUpdate := `UPDATE clusters SET name=?, domain_name=?, managed_by=?, platform_type=? WHERE name=?`
+	metadata, err := MetadataValue(cinfo.Metadata)
+	if err != nil {
+		return err
+	}
+	cmdUpdate := `UPDATE clusters SET name=?, domain_name=?, managed_by=?, platform_type=?, 
+                owner_email=?, owner_team=?, slack_channel=?, tenant=?, metadata=?, updated_at=? WHERE name=?`
 	statement, err := t.tx.PrepareContext(t.ctx, cmdUpdate)
 	if err != nil {
 		return SQLError{cmdUpdate, err}
 	}
 	defer statement.Close()
-	res, err := statement.ExecContext(t.ctx, cinfo.EditedName, cinfo.DomainName, cinfo.ManagedBy, cinfo.PlatformType, cinfo.Name)
+	res, err := statement.ExecContext(t.ctx, cinfo.EditedName, cinfo.DomainName, cinfo.ManagedBy, cinfo.PlatformType,
+		cinfo.OwnerEmail, cinfo.OwnerTeam, cinfo.SlackChannel, cinfo.Tenant, metadata, t.now(), cinfo.Name)
 	if err != nil {
 		if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrConstraint {
-			return PostFailure{"Cluster already exists; use Edit Cluster"}
+			if isClusterNameCaseConflict(serr) {
+				return ClusterExistsFailure("Cluster already exists (cluster names are case-insensitive)")
+			}
+			return ClusterExistsFailure("Cluster already exists; use Edit Cluster")
 		}
 		return SQLError{cmdUpdate, err}
 	}
@@ -87,12 +352,188 @@ func (t *tornjakTxHelper) updateClusterMetadata(cinfo types.ClusterInfo) error {
 		return SQLError{cmdUpdate, err}
 	}
 	if numRows != 1 {
-		return PostFailure{"Cluster does not exist; use Create Cluster"}
+		return ClusterMissingFailure("Cluster does not exist; use Create Cluster")
 	}
 
 	return nil
 }
 
+// renameClusterMetadata changes the name of the cluster in table clusters,
+// leaving the rows referencing it by ID, such as its agent memberships, unchanged
+// returns SQLError on failure and PostFailure on cluster non-existence or if newName is taken
+func (t *tornjakTxHelper) renameClusterMetadata(name string, newName string) error {
+	cmdUpdate := `UPDATE clusters SET name=?, updated_at=? WHERE name=?`
+	res, err := t.tx.ExecContext(t.ctx, cmdUpdate, newName, t.now(), name)
+	if err != nil {
+		if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrConstraint {
+			if isClusterNameCaseConflict(serr) {
+				return ClusterExistsFailure(fmt.Sprintf("Cluster %s already exists (cluster names are case-insensitive)", newName))
+			}
+			return ClusterExistsFailure(fmt.Sprintf("Cluster %s already exists", newName))
+		}
+		return SQLError{cmdUpdate, err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return SQLError{cmdUpdate, err}
+	}
+	if numRows != 1 {
+		return ClusterMissingFailure("Cluster does not exist")
+	}
+	return nil
+}
+
+// getClusterForUpdate returns the stored cluster with its agents, labels and extensions
+// sqlite has no SELECT ... FOR UPDATE, so a no-op update first takes the write
+// lock and the cluster cannot change between the read and the edit
+// returns SQLError on failure and PostFailure on cluster non-existence
+func (t *tornjakTxHelper) getClusterForUpdate(name string) (types.ClusterInfo, error) {
+	cmdLock := `UPDATE clusters SET name=name WHERE name=?`
+	res, err := t.tx.ExecContext(t.ctx, cmdLock, name)
+	if err != nil {
+		return types.ClusterInfo{}, SQLError{cmdLock, err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return types.ClusterInfo{}, SQLError{cmdLock, err}
+	}
+	if numRows != 1 {
+		return types.ClusterInfo{}, ClusterMissingFailure("Cluster does not exist; use Create Cluster")
+	}
+
+	cmd := `SELECT name, created_at, updated_at, domain_name, managed_by, platform_type, 
+          owner_email, owner_team, slack_channel, tenant, protected, metadata FROM clusters WHERE name=?`
+	cinfo := types.ClusterInfo{AgentsList: []string{}}
+	var createdAt string
+	var updatedAt, ownerEmail, ownerTeam, slackChannel, tenant, metadata sql.NullString
+	err = t.tx.QueryRowContext(t.ctx, cmd, name).Scan(&cinfo.Name, &createdAt, &updatedAt, &cinfo.DomainName, &cinfo.ManagedBy,
+		&cinfo.PlatformType, &ownerEmail, &ownerTeam, &slackChannel, &tenant, &cinfo.Protected, &metadata)
+	if err != nil {
+		return types.ClusterInfo{}, SQLError{cmd, err}
+	}
+	if cinfo.CreationTime, err = ParseTimestamp(createdAt); err != nil {
+		return types.ClusterInfo{}, errors.Errorf("Invalid creation time of cluster %s: %v", name, err)
+	}
+	if cinfo.UpdatedAt, err = ParseTimestamp(updatedAt.String); err != nil {
+		return types.ClusterInfo{}, errors.Errorf("Invalid change time of cluster %s: %v", name, err)
+	}
+	cinfo.OwnerEmail, cinfo.OwnerTeam = ownerEmail.String, ownerTeam.String
+	cinfo.SlackChannel, cinfo.Tenant = slackChannel.String, tenant.String
+	cinfo.Metadata = ParseMetadata(metadata)
+
+	cmdAgents := `SELECT agents.spiffeid FROM cluster_memberships 
+          JOIN agents ON cluster_memberships.agent_id=agents.id 
+          WHERE cluster_memberships.cluster_id=(SELECT id FROM clusters WHERE name=?)`
+	rows, err := t.tx.QueryContext(t.ctx, cmdAgents, name)
+	if err != nil {
+		return types.ClusterInfo{}, SQLError{cmdAgents, err}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var spiffeid string
+		if err = rows.Scan(&spiffeid); err != nil {
+			return types.ClusterInfo{}, SQLError{cmdAgents, err}
+		}
+		cinfo.AgentsList = append(cinfo.AgentsList, spiffeid)
+	}
+
+	cmdExtensions := `SELECT field, value FROM cluster_extensions 
+          WHERE cluster_id=(SELECT id FROM clusters WHERE name=?)`
+	extRows, err := t.tx.QueryContext(t.ctx, cmdExtensions, name)
+	if err != nil {
+		return types.ClusterInfo{}, SQLError{cmdExtensions, err}
+	}
+	defer extRows.Close()
+	for extRows.Next() {
+		var field, encoded string
+		if err = extRows.Scan(&field, &encoded); err != nil {
+			return types.ClusterInfo{}, SQLError{cmdExtensions, err}
+		}
+		var value interface{}
+		if err = json.Unmarshal([]byte(encoded), &value); err != nil {
+			return types.ClusterInfo{}, errors.Errorf("Invalid value of extension field %s of cluster %s: %v", field, name, err)
+		}
+		if cinfo.Extensions == nil {
+			cinfo.Extensions = make(map[string]interface{})
+		}
+		cinfo.Extensions[field] = value
+	}
+
+	cmdLabels := `SELECT label, value FROM cluster_labels 
+          WHERE cluster_id=(SELECT id FROM clusters WHERE name=?)`
+	labelRows, err := t.tx.QueryContext(t.ctx, cmdLabels, name)
+	if err != nil {
+		return types.ClusterInfo{}, SQLError{cmdLabels, err}
+	}
+	defer labelRows.Close()
+	for labelRows.Next() {
+		var label, value string
+		if err = labelRows.Scan(&label, &value); err != nil {
+			return types.ClusterInfo{}, SQLError{cmdLabels, err}
+		}
+		if cinfo.Labels == nil {
+			cinfo.Labels = make(map[string]string)
+		}
+		cinfo.Labels[label] = value
+	}
+
+	return cinfo, nil
+}
+
+// lockDeletableCluster takes the write lock like getClusterForUpdate if the
+// cluster is not protected
+// returns SQLError on failure and PostFailure on cluster non-existence or protection
+func (t *tornjakTxHelper) lockDeletableCluster(name string) error {
+	cmdLock := `UPDATE clusters SET name=name WHERE name=? AND protected=0`
+	res, err := t.tx.ExecContext(t.ctx, cmdLock, name)
+	if err != nil {
+		return SQLError{cmdLock, err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return SQLError{cmdLock, err}
+	}
+	if numRows == 1 {
+		return nil
+	}
+	cmd := `SELECT COUNT(*) FROM clusters WHERE name=?`
+	var count int
+	if err = t.tx.QueryRowContext(t.ctx, cmd, name).Scan(&count); err != nil {
+		return SQLError{cmd, err}
+	}
+	if count == 0 {
+		return ClusterMissingFailure("Cluster does not exist")
+	}
+	return ProtectedClusterFailure(name)
+}
+
+// updateClusterProtection sets the protection of the cluster and returns
+// whether it changed
+// returns SQLError on failure and PostFailure on cluster non-existence
+func (t *tornjakTxHelper) updateClusterProtection(name string, protected bool) (bool, error) {
+	cmdUpdate := `UPDATE clusters SET protected=?, updated_at=? WHERE name=? AND protected!=?`
+	res, err := t.tx.ExecContext(t.ctx, cmdUpdate, protected, t.now(), name, protected)
+	if err != nil {
+		return false, SQLError{cmdUpdate, err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return false, SQLError{cmdUpdate, err}
+	}
+	if numRows == 1 {
+		return true, nil
+	}
+	cmd := `SELECT COUNT(*) FROM clusters WHERE name=?`
+	var count int
+	if err = t.tx.QueryRowContext(t.ctx, cmd, name).Scan(&count); err != nil {
+		return false, SQLError{cmd, err}
+	}
+	if count == 0 {
+		return false, ClusterMissingFailure("Cluster does not exist")
+	}
+	return false, nil
+}
+
 // deleteClusterMetadata attemps delete of entry in table clusters
 // returns SQLError on failure and PostFailure on cluster non-existence
 func (t *tornjakTxHelper) deleteClusterMetadata(name string) error {
@@ -110,65 +551,721 @@ func (t *tornjakTxHelper) deleteClusterMetadata(name string) error {
 		return SQLError{cmdDelete, err}
 	}
 	if numRows != 1 {
-		return PostFailure{"Cluster does not exist"}
+		return ClusterMissingFailure("Cluster does not exist")
 	}
 	return nil
 }
 
-// addAgentBatchToCluster adds entries in clusterMemberships table
-// takes in cluster name and list of agent spiffeids
-// returns SQLError on failure and PostFailure on conflict (an agent is already assigned)
-func (t *tornjakTxHelper) addAgentBatchToCluster(clustername string, agentsList []string) error {
-	if len(agentsList) == 0 {
-		return nil
+// deleteCluster removes the cluster with its extension fields, labels and
+// tokens, keeping its state for restores and recording the deletion in the
+// history
+// with deferred set, the memberships of its agents are kept for
+// deleteDeferredMemberships, otherwise they are removed with the cluster
+// returns SQLError on failure and PostFailure on cluster non-existence
+func (t *tornjakTxHelper) deleteCluster(clusterName string, deferred bool) error {
+	// KEEP state of cluster for restores (requires metadata still entered)
+	err := t.archiveCluster(clusterName)
+	if err != nil {
+		return err
 	}
-	// Add into agents table
-	cmdAgents := "INSERT OR IGNORE INTO agents (spiffeid, plugin) VALUES "
-	agents := []interface{}{}
-	for i := 0; i < len(agentsList); i++ {
-		cmdAgents += "(?, NULL),"
-		agents = append(agents, agentsList[i])
+
+	if deferred {
+		// KEEP memberships for removal in batches (requires metadata still entered)
+		err = t.insertClusterDeletion(clusterName)
+	} else {
+		// REMOVE all currently assigned cluster agents (requires metadata still entered)
+		err = t.deleteClusterAgents(clusterName)
 	}
-	cmdAgents = strings.TrimSuffix(cmdAgents, ",")
-	statementAgentInsert, err := t.tx.PrepareContext(t.ctx, cmdAgents)
 	if err != nil {
-		return SQLError{cmdAgents, err}
+		return err
 	}
-	_, err = statementAgentInsert.ExecContext(t.ctx, agents...)
+
+	// REMOVE extension fields of cluster (requires metadata still entered)
+	err = t.setClusterExtensions(clusterName, nil)
 	if err != nil {
-		return SQLError{cmdAgents, err}
+		return err
 	}
 
-	// generate single statement
-	cmdBatch := "INSERT OR ABORT INTO cluster_memberships (agent_id, cluster_id) VALUES "
-	vals := []interface{}{}
-	for i := 0; i < len(agentsList); i++ {
-		cmdBatch += "((SELECT id FROM agents WHERE spiffeid=?), (SELECT id FROM clusters WHERE name=?)),"
-		vals = append(vals, agentsList[i], clustername)
+	// REMOVE labels of cluster (requires metadata still entered)
+	err = t.setClusterLabels(clusterName, nil)
+	if err != nil {
+		return err
 	}
-	cmdBatch = strings.TrimSuffix(cmdBatch, ",")
 
-	// prepare statement
-	statementInsert, err := t.tx.PrepareContext(t.ctx, cmdBatch)
+	// REVOKE tokens of cluster (requires metadata still entered)
+	err = t.deleteClusterTokens(clusterName)
 	if err != nil {
-		return SQLError{cmdBatch, err}
+		return err
 	}
-	// execute single statement and check error
-	_, err = statementInsert.ExecContext(t.ctx, vals...)
+
+	// ADD deletion to history (requires metadata still entered)
+	err = t.recordClusterHistory(clusterName, types.ClusterChangeDeleted)
+	if err != nil {
+		return err
+	}
+
+	// REMOVE cluster metadata
+	return t.deleteClusterMetadata(clusterName)
+}
+
+// countClusterMemberships returns the number of agents of the cluster
+// returns SQLError on failure
+func (t *tornjakTxHelper) countClusterMemberships(clusterName string) (int, error) {
+	cmd := `SELECT COUNT(*) FROM cluster_memberships WHERE cluster_id=(SELECT id FROM clusters WHERE name=?)`
+	var count int
+	if err := t.tx.QueryRowContext(t.ctx, cmd, clusterName).Scan(&count); err != nil {
+		return 0, SQLError{cmd, err}
+	}
+	return count, nil
+}
+
+// insertClusterDeletion records the deletion of the cluster in
+// cluster_deletions, before it is deleted, so the memberships of its agents
+// are removed by deleteDeferredMemberships
+// returns SQLError on failure
+func (t *tornjakTxHelper) insertClusterDeletion(clusterName string) error {
+	cmd := `INSERT INTO cluster_deletions (cluster_id, uid, name, memberships, deleted_at) 
+          SELECT id, uid, name, (SELECT COUNT(*) FROM cluster_memberships WHERE cluster_id=clusters.id), ? 
+          FROM clusters WHERE name=?`
+	if _, err := t.tx.ExecContext(t.ctx, cmd, t.now(), clusterName); err != nil {
+		return SQLError{cmd, err}
+	}
+	return nil
+}
+
+// getClusterDeletion returns the deletion of the cluster of the given name
+// recorded in the transaction
+// returns SQLError on failure
+func (t *tornjakTxHelper) getClusterDeletion(clusterName string) (types.ClusterDeletion, error) {
+	cmd := `SELECT id, uid, name, memberships, deleted_at FROM cluster_deletions WHERE name=? ORDER BY id DESC LIMIT 1`
+	var d types.ClusterDeletion
+	var uid sql.NullString
+	if err := t.tx.QueryRowContext(t.ctx, cmd, clusterName).Scan(&d.ID, &uid, &d.Name, &d.Memberships, &d.DeletedAt); err != nil {
+		return types.ClusterDeletion{}, SQLError{cmd, err}
+	}
+	d.UID = uid.String
+	d.Remaining = d.Memberships
+	return d, nil
+}
+
+// deleteDeferredMemberships removes up to limit memberships of the cluster
+// deletion with the given ID, marking their agents changed, and removes the
+// deletion once none remain
+// returns the number of memberships remaining, SQLError on failure
+func (t *tornjakTxHelper) deleteDeferredMemberships(id int64, limit int) (int, error) {
+	var clusterID int64
+	cmd := `SELECT cluster_id FROM cluster_deletions WHERE id=?`
+	err := t.tx.QueryRowContext(t.ctx, cmd, id).Scan(&clusterID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, SQLError{cmd, err}
+	}
+
+	batch := `SELECT id FROM cluster_memberships WHERE cluster_id=? ORDER BY id LIMIT ?`
+	cmdTouch := `UPDATE agents SET updated_at=? WHERE id IN (SELECT agent_id FROM cluster_memberships WHERE id IN (` + batch + `))`
+	if _, err = t.tx.ExecContext(t.ctx, cmdTouch, t.now(), clusterID, limit); err != nil {
+		return 0, SQLError{cmdTouch, err}
+	}
+	cmdDelete := `DELETE FROM cluster_memberships WHERE id IN (` + batch + `)`
+	if _, err = t.tx.ExecContext(t.ctx, cmdDelete, clusterID, limit); err != nil {
+		return 0, SQLError{cmdDelete, err}
+	}
+
+	var remaining int
+	cmd = `SELECT COUNT(*) FROM cluster_memberships WHERE cluster_id=?`
+	if err = t.tx.QueryRowContext(t.ctx, cmd, clusterID).Scan(&remaining); err != nil {
+		return 0, SQLError{cmd, err}
+	}
+	if remaining == 0 {
+		cmd = `DELETE FROM cluster_deletions WHERE id=?`
+		if _, err = t.tx.ExecContext(t.ctx, cmd, id); err != nil {
+			return 0, SQLError{cmd, err}
+		}
+	}
+	return remaining, nil
+}
+
+// archiveCluster keeps the state of the cluster in deleted_clusters, before it is deleted
+// returns SQLError on failure and PostFailure on cluster non-existence
+func (t *tornjakTxHelper) archiveCluster(name string) error {
+	var uid sql.NullString
+	cmdUID := `SELECT uid FROM clusters WHERE name=?`
+	err := t.tx.QueryRowContext(t.ctx, cmdUID, name).Scan(&uid)
+	if err == sql.ErrNoRows {
+		return ClusterMissingFailure("Cluster does not exist")
+	} else if err != nil {
+		return SQLError{cmdUID, err}
+	}
+	cinfo, err := t.getClusterForUpdate(name)
+	if err != nil {
+		return err
+	}
+	cinfo.UID = uid.String
+	snapshot, err := DeletedClusterSnapshot(cinfo)
+	if err != nil {
+		return err
+	}
+	cmdInsert := `INSERT INTO deleted_clusters (uid, name, snapshot, deleted_at) VALUES (?,?,?,?)
+          ON CONFLICT(uid) DO UPDATE SET name=excluded.name, snapshot=excluded.snapshot, deleted_at=excluded.deleted_at`
+	if _, err = t.tx.ExecContext(t.ctx, cmdInsert, cinfo.UID, name, snapshot, t.now()); err != nil {
+		return SQLError{cmdInsert, err}
+	}
+	return nil
+}
+
+// unarchiveCluster returns the state of the deleted cluster with the given UID
+// and removes it from deleted_clusters
+// returns SQLError on failure and PostFailure if no cluster with the UID is deleted
+func (t *tornjakTxHelper) unarchiveCluster(uid string) (types.ClusterInfo, error) {
+	var deleting int
+	cmdDeleting := `SELECT COUNT(*) FROM cluster_deletions WHERE uid=?`
+	if err := t.tx.QueryRowContext(t.ctx, cmdDeleting, uid).Scan(&deleting); err != nil {
+		return types.ClusterInfo{}, SQLError{cmdDeleting, err}
+	}
+	if deleting > 0 {
+		return types.ClusterInfo{}, PostFailure{Message: "Memberships of the deleted cluster with UID " + uid + " are still being removed; restore it once they are"}
+	}
+
+	var snapshot, deletedAt string
+	cmd := `SELECT snapshot, deleted_at FROM deleted_clusters WHERE uid=?`
+	err := t.tx.QueryRowContext(t.ctx, cmd, uid).Scan(&snapshot, &deletedAt)
+	if err == sql.ErrNoRows {
+		return types.ClusterInfo{}, DeletedClusterNotFound(uid)
+	} else if err != nil {
+		return types.ClusterInfo{}, SQLError{cmd, err}
+	}
+	deleted, err := ParseDeletedCluster(snapshot, deletedAt)
+	if err != nil {
+		return types.ClusterInfo{}, err
+	}
+	cmdDelete := `DELETE FROM deleted_clusters WHERE uid=?`
+	if _, err = t.tx.ExecContext(t.ctx, cmdDelete, uid); err != nil {
+		return types.ClusterInfo{}, SQLError{cmdDelete, err}
+	}
+	return deleted.Cluster, nil
+}
+
+// restoreClusterMetadata inserts a deleted cluster into table clusters with
+// its UID and creation time
+// returns SQLError upon failure and PostFailure on cluster existence
+func (t *tornjakTxHelper) restoreClusterMetadata(cinfo types.ClusterInfo) error {
+	metadata, err := MetadataValue(cinfo.Metadata)
+	if err != nil {
+		return err
+	}
+	cmdInsert := `INSERT INTO clusters (name, created_at, updated_at, domain_name, managed_by, platform_type, 
+                owner_email, owner_team, slack_channel, tenant, protected, metadata, uid) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)`
+	_, err = t.tx.ExecContext(t.ctx, cmdInsert, cinfo.Name, FormatTimestamp(cinfo.CreationTime), t.now(), cinfo.DomainName,
+		cinfo.ManagedBy, cinfo.PlatformType, cinfo.OwnerEmail, cinfo.OwnerTeam, cinfo.SlackChannel, cinfo.Tenant,
+		cinfo.Protected, metadata, cinfo.UID)
 	if err != nil {
 		if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrConstraint {
-			// TODO add more details of agent conflict?
-			return PostFailure{serr.Error()}
+			return ClusterExistsFailure(fmt.Sprintf("Cluster %s already exists; rename it to restore the deleted cluster", cinfo.Name))
+		}
+		return SQLError{cmdInsert, err}
+	}
+	return nil
+}
+
+// recordClusterHistory adds the state of the cluster as of the transaction to cluster_history
+// a deletion is recorded without state, before the cluster metadata is removed
+// returns SQLError on failure and PostFailure on cluster non-existence
+func (t *tornjakTxHelper) recordClusterHistory(name string, change string) error {
+	var uid sql.NullString
+	cmdUID := `SELECT uid FROM clusters WHERE name=?`
+	err := t.tx.QueryRowContext(t.ctx, cmdUID, name).Scan(&uid)
+	if err == sql.ErrNoRows {
+		return ClusterMissingFailure("Cluster does not exist")
+	} else if err != nil {
+		return SQLError{cmdUID, err}
+	}
+
+	snapshot := ""
+	if change != types.ClusterChangeDeleted {
+		if snapshot, err = t.getClusterSnapshot(name, uid.String); err != nil {
+			return err
+		}
+	}
+	return t.insertClusterHistory(uid.String, name, change, snapshot)
+}
+
+// getClusterSnapshot returns the state of the cluster as of the transaction, as stored in cluster_history
+// returns SQLError on failure and PostFailure on cluster non-existence
+func (t *tornjakTxHelper) getClusterSnapshot(name string, uid string) (string, error) {
+	cinfo, err := t.getClusterForUpdate(name)
+	if err != nil {
+		return "", err
+	}
+	cinfo.UID = uid
+	data, err := json.Marshal(cinfo)
+	if err != nil {
+		return "", errors.Errorf("Invalid state of cluster %s: %v", name, err)
+	}
+	return string(data), nil
+}
+
+// insertClusterHistory adds a change of the cluster with the given UID to cluster_history,
+// with the state of the cluster after the change, and its events to the audit log
+// returns SQLError on failure
+func (t *tornjakTxHelper) insertClusterHistory(uid string, name string, change string, snapshot string) error {
+	// ADD change to audit log, from the state of the last change
+	var previousChange, previous sql.NullString
+	cmdPrevious := `SELECT change, snapshot FROM cluster_history WHERE cluster_uid=? ORDER BY id DESC LIMIT 1`
+	err := t.tx.QueryRowContext(t.ctx, cmdPrevious, uid).Scan(&previousChange, &previous)
+	if err != nil && err != sql.ErrNoRows {
+		return SQLError{cmdPrevious, err}
+	}
+	before := previous.String
+	if previousChange.String == types.ClusterChangeDeleted {
+		before = ""
+	}
+	events, err := ClusterAuditEvents(change, uid, before, snapshot)
+	if err != nil {
+		return err
+	}
+	if err = t.recordAuditEvents(events); err != nil {
+		return err
+	}
+
+	cmdInsert := `INSERT INTO cluster_history (cluster_uid, name, change, snapshot, changed_at) VALUES (?,?,?,?,?)`
+	_, err = t.tx.ExecContext(t.ctx, cmdInsert, uid, name, change, snapshot, t.clock.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return SQLError{cmdInsert, err}
+	}
+	return nil
+}
+
+// recordAuditEvents adds the events to the audit log, made by the actor of the transaction
+// returns SQLError on failure
+func (t *tornjakTxHelper) recordAuditEvents(events []types.AuditEvent) error {
+	cmd := `INSERT INTO audit_events (actor, action, object_type, object_id, cluster_uid, before_state, after_state, recorded_at) 
+          VALUES (?,?,?,?,?,?,?,?)`
+	for _, event := range events {
+		_, err := t.tx.ExecContext(t.ctx, cmd, t.actor, event.Action, event.ObjectType, event.ObjectId, event.ClusterUID,
+			string(event.Before), string(event.After), t.now())
+		if err != nil {
+			return SQLError{cmd, err}
 		}
-		return SQLError{cmdBatch, err}
 	}
 	return nil
+}
+
+// recordRestoredClusters adds the changes of clusters made by restoring a snapshot to
+// cluster_history, which is not restored: clusters whose state differs from the last state
+// recorded are recorded as updated, or restored if they were deleted, and recorded clusters
+// missing from the snapshot as deleted
+// returns SQLError on failure
+func (t *tornjakTxHelper) recordRestoredClusters() error {
+	type historyState struct {
+		name, change, snapshot string
+	}
+	cmdLatest := `SELECT cluster_uid, name, change, snapshot FROM cluster_history 
+          WHERE id IN (SELECT MAX(id) FROM cluster_history GROUP BY cluster_uid)`
+	rows, err := t.tx.QueryContext(t.ctx, cmdLatest)
+	if err != nil {
+		return SQLError{cmdLatest, err}
+	}
+	latest := map[string]historyState{}
+	for rows.Next() {
+		var uid, name, change, snapshot sql.NullString
+		if err = rows.Scan(&uid, &name, &change, &snapshot); err != nil {
+			rows.Close()
+			return SQLError{cmdLatest, err}
+		}
+		latest[uid.String] = historyState{name.String, change.String, snapshot.String}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return SQLError{cmdLatest, err}
+	}
+
+	clusters, err := t.getStringPairs(`SELECT uid, name FROM clusters WHERE uid IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	uids := make([]string, 0, len(clusters))
+	for uid := range clusters {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	for _, uid := range uids {
+		name := clusters[uid]
+		snapshot, err := t.getClusterSnapshot(name, uid)
+		if err != nil {
+			return err
+		}
+		previous, recorded := latest[uid]
+		change := types.ClusterChangeUpdated
+		if !recorded {
+			change = types.ClusterChangeCreated
+		} else if previous.change == types.ClusterChangeDeleted {
+			change = types.ClusterChangeRestored
+		} else if previous.snapshot == snapshot {
+			continue
+		}
+		if err = t.insertClusterHistory(uid, name, change, snapshot); err != nil {
+			return err
+		}
+	}
+	uids = uids[:0]
+	for uid, previous := range latest {
+		if _, exists := clusters[uid]; !exists && previous.change != types.ClusterChangeDeleted {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Strings(uids)
+	for _, uid := range uids {
+		previous := latest[uid]
+		if err = t.insertClusterHistory(uid, previous.name, types.ClusterChangeDeleted, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAuditAgent returns the state of the agent recorded in the audit log, nil if it is not stored
+// returns SQLError on failure
+func (t *tornjakTxHelper) getAuditAgent(spiffeid string) (*types.AuditAgent, error) {
+	var plugin, displayName sql.NullString
+	cmd := `SELECT plugin_types.name, agents.display_name FROM agents 
+          LEFT JOIN plugin_types ON agents.plugin_type_id=plugin_types.id WHERE agents.spiffeid=?`
+	err := t.tx.QueryRowContext(t.ctx, cmd, spiffeid).Scan(&plugin, &displayName)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, SQLError{cmd, err}
+	}
+	agent := &types.AuditAgent{Spiffeid: spiffeid, Plugin: plugin.String, DisplayName: displayName.String}
+
+	cmdLabels := `SELECT agent_labels.label, agent_labels.value FROM agent_labels 
+          JOIN agents ON agent_labels.agent_id=agents.id WHERE agents.spiffeid=?`
+	rows, err := t.tx.QueryContext(t.ctx, cmdLabels, spiffeid)
+	if err != nil {
+		return nil, SQLError{cmdLabels, err}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var label, value string
+		if err = rows.Scan(&label, &value); err != nil {
+			return nil, SQLError{cmdLabels, err}
+		}
+		if agent.Labels == nil {
+			agent.Labels = make(map[string]string)
+		}
+		agent.Labels[label] = value
+	}
+	if err = rows.Err(); err != nil {
+		return nil, SQLError{cmdLabels, err}
+	}
+	return agent, nil
+}
+
+// recordAgentChange adds the change of an agent to the audit log, given its
+// state before the change, if it changed
+// returns SQLError on failure
+func (t *tornjakTxHelper) recordAgentChange(spiffeid string, before *types.AuditAgent) error {
+	after, err := t.getAuditAgent(spiffeid)
+	if err != nil {
+		return err
+	}
+	event, changed, err := AgentAuditEvent(before, after)
+	if err != nil || !changed {
+		return err
+	}
+	return t.recordAuditEvents([]types.AuditEvent{event})
+}
+
+// getStringPairs returns the rows of a query of two text columns as a map from the first to the second
+// returns SQLError on failure
+func (t *tornjakTxHelper) getStringPairs(cmd string) (map[string]string, error) {
+	rows, err := t.tx.QueryContext(t.ctx, cmd)
+	if err != nil {
+		return nil, SQLError{cmd, err}
+	}
+	defer rows.Close()
+	pairs := make(map[string]string)
+	for rows.Next() {
+		var key, value sql.NullString
+		if err = rows.Scan(&key, &value); err != nil {
+			return nil, SQLError{cmd, err}
+		}
+		pairs[key.String] = value.String
+	}
+	if err = rows.Err(); err != nil {
+		return nil, SQLError{cmd, err}
+	}
+	return pairs, nil
+}
+
+// agentConflict is an agent that cannot be added to a cluster
+type agentConflict struct {
+	spiffeid string
+	// cluster the agent is assigned to, empty if it is listed more than once
+	cluster string
+}
+
+// agentConflictFailure returns the PostFailure naming the conflicting agents
+func agentConflictFailure(conflicts []agentConflict) PostFailure {
+	agents := make([]string, 0, len(conflicts))
+	for _, c := range conflicts {
+		if len(c.cluster) == 0 {
+			agents = append(agents, c.spiffeid+" (listed more than once)")
+		} else {
+			agents = append(agents, fmt.Sprintf("%s (assigned to cluster %s)", c.spiffeid, c.cluster))
+		}
+	}
+	return AgentsAssignedFailure(agents)
+}
+
+// getAgentConflicts returns the agents of agentsList that are listed more than once or
+// assigned to a cluster, in the order of agentsList
+// returns SQLError on failure
+func (t *tornjakTxHelper) getAgentConflicts(agentsList []string) ([]agentConflict, error) {
+	conflicts := []agentConflict{}
+	listed := make(map[string]bool, len(agentsList))
+	unique := make([]string, 0, len(agentsList))
+	for _, spiffeid := range agentsList {
+		if listed[spiffeid] {
+			conflicts = append(conflicts, agentConflict{spiffeid: spiffeid})
+			continue
+		}
+		listed[spiffeid] = true
+		unique = append(unique, spiffeid)
+	}
+
+	clusterNames := make(map[string]string)
+	for start := 0; start < len(unique); start += agentClusterNamesBatchSize {
+		end := start + agentClusterNamesBatchSize
+		if end > len(unique) {
+			end = len(unique)
+		}
+		batch := unique[start:end]
+		cmd := `SELECT agents.spiffeid, clusters.name 
+          FROM agents 
+          JOIN cluster_memberships ON agents.id=cluster_memberships.agent_id
+          JOIN clusters ON cluster_memberships.cluster_id=clusters.id
+          WHERE agents.spiffeid IN (` + strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",") + `)`
+		vals := make([]interface{}, len(batch))
+		for i := range batch {
+			vals[i] = batch[i]
+		}
+		rows, err := t.tx.QueryContext(t.ctx, cmd, vals...)
+		if err != nil {
+			return nil, SQLError{cmd, err}
+		}
+		for rows.Next() {
+			var spiffeid, clusterName string
+			if err = rows.Scan(&spiffeid, &clusterName); err != nil {
+				rows.Close()
+				return nil, SQLError{cmd, err}
+			}
+			clusterNames[spiffeid] = clusterName
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, SQLError{cmd, err}
+		}
+	}
+	for _, spiffeid := range unique {
+		if clusterName, ok := clusterNames[spiffeid]; ok {
+			conflicts = append(conflicts, agentConflict{spiffeid: spiffeid, cluster: clusterName})
+		}
+	}
+	return conflicts, nil
+}
+
+// maximum number of agents written by a single statement; the membership
+// statements bind one parameter per agent and the cluster name, so a chunk
+// stays below the limit of 999 parameters of SQLite
+const agentBatchChunkSize = 500
+
+// addAgentBatchToCluster adds entries in clusterMemberships table
+// agents are written in chunks of agentBatchChunkSize, so large lists do not need one enormous statement
+// returns PostFailure naming the agents listed more than once or already assigned to a cluster
+func (t *tornjakTxHelper) addAgentBatchToCluster(clustername string, agentsList []string) error {
+	if len(agentsList) == 0 {
+		return nil
+	}
+	// CHECK agents are not assigned yet
+	conflicts, err := t.getAgentConflicts(agentsList)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return agentConflictFailure(conflicts)
+	}
+	return t.insertAgentMemberships(clustername, agentsList, false, nil)
+}
+
+// insertAgentMemberships adds the agents to the agents table if missing and assigns them to the cluster,
+// one chunk of agentBatchChunkSize agents at a time
+// with move set, agents assigned to another cluster are moved, otherwise their membership is a constraint failure
+// progress, if not nil, is called with the number of agents written after each chunk
+// the agents, the cluster and the clusters agents are moved from are marked changed
+// returns PostFailure on a constraint failure, SQLError otherwise
+func (t *tornjakTxHelper) insertAgentMemberships(clustername string, agentsList []string, move bool, progress func(written int)) error {
+	for start := 0; start < len(agentsList); start += agentBatchChunkSize {
+		end := start + agentBatchChunkSize
+		if end > len(agentsList) {
+			end = len(agentsList)
+		}
+		chunk := agentsList[start:end]
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(chunk)), ",")
+		vals := make([]interface{}, 0, len(chunk)+1)
+		vals = append(vals, clustername)
+		for _, spiffeid := range chunk {
+			vals = append(vals, spiffeid)
+		}
+
+		// Add into agents table
+		now := t.now()
+		cmdAgents := "INSERT OR IGNORE INTO agents (spiffeid, created_at, updated_at) VALUES " +
+			strings.TrimSuffix(strings.Repeat("(?,?,?),", len(chunk)), ",")
+		agentVals := make([]interface{}, 0, 3*len(chunk))
+		for _, spiffeid := range chunk {
+			agentVals = append(agentVals, spiffeid, now, now)
+		}
+		if _, err := t.tx.ExecContext(t.ctx, cmdAgents, agentVals...); err != nil {
+			return SQLError{cmdAgents, err}
+		}
+
+		// Release the agents from deleted clusters whose memberships are removed in the background
+		cmdRelease := `DELETE FROM cluster_memberships WHERE cluster_id IN (SELECT cluster_id FROM cluster_deletions) 
+          AND agent_id IN (SELECT id FROM agents WHERE spiffeid IN (` + placeholders + `))`
+		if _, err := t.tx.ExecContext(t.ctx, cmdRelease, vals[1:]...); err != nil {
+			return SQLError{cmdRelease, err}
+		}
+
+		// Touch the clusters the agents are moved from
+		if move {
+			cmdFrom := `UPDATE clusters SET updated_at=? WHERE id IN (SELECT cluster_memberships.cluster_id 
+          FROM cluster_memberships JOIN agents ON cluster_memberships.agent_id=agents.id 
+          WHERE agents.spiffeid IN (` + placeholders + `))`
+			if _, err := t.tx.ExecContext(t.ctx, cmdFrom, append([]interface{}{now}, vals[1:]...)...); err != nil {
+				return SQLError{cmdFrom, err}
+			}
+		}
+
+		// Add into cluster_memberships table
+		cmdBatch := `INSERT OR ABORT INTO cluster_memberships (agent_id, cluster_id) 
+          SELECT agents.id, (SELECT id FROM clusters WHERE name=?) FROM agents 
+          WHERE agents.spiffeid IN (` + placeholders + `)`
+		if move {
+			cmdBatch += ` ON CONFLICT(agent_id) DO UPDATE SET cluster_id=excluded.cluster_id`
+		}
+		if _, err := t.tx.ExecContext(t.ctx, cmdBatch, vals...); err != nil {
+			if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrConstraint {
+				return PostFailure{Message: serr.Error()}
+			}
+			return SQLError{cmdBatch, err}
+		}
+		if err := t.touchAgents(chunk); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(end)
+		}
+	}
+	return t.touchCluster(clustername)
+}
+
+// moveAgentsTx returns the transaction of a move of agents between clusters
+func (t *tornjakTxHelper) moveAgentsTx() MoveAgentsTx {
+	return MoveAgentsTx{
+		LockCluster:          t.lockClusterByUID,
+		ClusterAgents:        t.getClusterAgents,
+		MoveMemberships:      t.moveAgentMemberships,
+		RecordClusterHistory: t.recordClusterHistory,
+	}
+}
+
+// getClusterAgents returns the agents of agentsList that are members of the
+// cluster, one chunk of agentBatchChunkSize agents at a time
+// returns SQLError on failure
+func (t *tornjakTxHelper) getClusterAgents(clustername string, agentsList []string) ([]string, error) {
+	members := []string{}
+	for start := 0; start < len(agentsList); start += agentBatchChunkSize {
+		end := start + agentBatchChunkSize
+		if end > len(agentsList) {
+			end = len(agentsList)
+		}
+		chunk := agentsList[start:end]
+		vals := make([]interface{}, 0, len(chunk)+1)
+		vals = append(vals, clustername)
+		for _, spiffeid := range chunk {
+			vals = append(vals, spiffeid)
+		}
+		cmd := `SELECT agents.spiffeid FROM cluster_memberships 
+          JOIN agents ON cluster_memberships.agent_id=agents.id 
+          WHERE cluster_memberships.cluster_id=(SELECT id FROM clusters WHERE name=?) 
+          AND agents.spiffeid IN (` + strings.TrimSuffix(strings.Repeat("?,", len(chunk)), ",") + `)`
+		chunkMembers, err := t.getStrings(cmd, vals...)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, chunkMembers...)
+	}
+	return members, nil
+}
+
+// moveAgentMemberships re-points the memberships of the agents of agentsList
+// in cluster from to cluster to, one chunk of agentBatchChunkSize agents at a
+// time, leaving the memberships of other clusters unchanged
+// the agents and both clusters are marked changed
+// returns SQLError on failure
+func (t *tornjakTxHelper) moveAgentMemberships(from string, to string, agentsList []string) error {
+	for start := 0; start < len(agentsList); start += agentBatchChunkSize {
+		end := start + agentBatchChunkSize
+		if end > len(agentsList) {
+			end = len(agentsList)
+		}
+		chunk := agentsList[start:end]
+		vals := make([]interface{}, 0, len(chunk)+2)
+		vals = append(vals, to, from)
+		for _, spiffeid := range chunk {
+			vals = append(vals, spiffeid)
+		}
+		cmd := `UPDATE cluster_memberships SET cluster_id=(SELECT id FROM clusters WHERE name=?) 
+          WHERE cluster_id=(SELECT id FROM clusters WHERE name=?) 
+          AND agent_id IN (SELECT id FROM agents WHERE spiffeid IN (` + strings.TrimSuffix(strings.Repeat("?,", len(chunk)), ",") + `))`
+		if _, err := t.tx.ExecContext(t.ctx, cmd, vals...); err != nil {
+			return SQLError{cmd, err}
+		}
+		if err := t.touchAgents(chunk); err != nil {
+			return err
+		}
+	}
+	if err := t.touchCluster(from); err != nil {
+		return err
+	}
+	return t.touchCluster(to)
+}
 
+// deleteClusterTokens revokes the cluster tokens of the cluster
+// returns SQLError on failure
+func (t *tornjakTxHelper) deleteClusterTokens(clustername string) error {
+	cmdDelete := "DELETE FROM cluster_tokens WHERE cluster_uid=(SELECT uid FROM clusters WHERE name=?)"
+	if _, err := t.tx.ExecContext(t.ctx, cmdDelete, clustername); err != nil {
+		return SQLError{cmdDelete, err}
+	}
+	return nil
 }
 
 // deleteClusterAgents attempts removal of all agent-cluster pairs in clusterMemberships table
+// the cluster and its agents are marked changed
 // returns SQLError on failure
 func (t *tornjakTxHelper) deleteClusterAgents(clustername string) error {
+	cmdTouch := `UPDATE agents SET updated_at=? WHERE id IN (SELECT agent_id FROM cluster_memberships 
+          WHERE cluster_id=(SELECT id FROM clusters WHERE name=?))`
+	if _, err := t.tx.ExecContext(t.ctx, cmdTouch, t.now(), clustername); err != nil {
+		return SQLError{cmdTouch, err}
+	}
+	if err := t.touchCluster(clustername); err != nil {
+		return err
+	}
+
 	cmdDelete := "DELETE FROM cluster_memberships WHERE cluster_id=(SELECT id FROM clusters WHERE name=?)"
 	statementDelete, err := t.tx.PrepareContext(t.ctx, cmdDelete)
 	if err != nil {
@@ -180,3 +1277,207 @@ func (t *tornjakTxHelper) deleteClusterAgents(clustername string) error {
 	}
 	return nil
 }
+
+// setClusterExtensions replaces the extension fields of a cluster in cluster_extensions table
+// and marks the cluster changed
+// values are stored JSON-encoded; returns SQLError on failure
+func (t *tornjakTxHelper) setClusterExtensions(clustername string, extensions map[string]interface{}) error {
+	if err := t.touchCluster(clustername); err != nil {
+		return err
+	}
+	cmdDelete := "DELETE FROM cluster_extensions WHERE cluster_id=(SELECT id FROM clusters WHERE name=?)"
+	if _, err := t.tx.ExecContext(t.ctx, cmdDelete, clustername); err != nil {
+		return SQLError{cmdDelete, err}
+	}
+	if len(extensions) == 0 {
+		return nil
+	}
+
+	cmdBatch := "INSERT INTO cluster_extensions (cluster_id, field, value) VALUES "
+	vals := []interface{}{}
+	for field, value := range extensions {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return errors.Errorf("Invalid value of extension field %s: %v", field, err)
+		}
+		cmdBatch += "((SELECT id FROM clusters WHERE name=?), ?, ?),"
+		vals = append(vals, clustername, field, string(encoded))
+	}
+	cmdBatch = strings.TrimSuffix(cmdBatch, ",")
+	if _, err := t.tx.ExecContext(t.ctx, cmdBatch, vals...); err != nil {
+		return SQLError{cmdBatch, err}
+	}
+	return nil
+}
+
+// setLabels replaces the labels in table of the object whose id idQuery selects by name
+// returns SQLError on failure
+func (t *tornjakTxHelper) setLabels(table, idColumn, idQuery, name string, labels map[string]string) error {
+	cmdDelete := fmt.Sprintf("DELETE FROM %s WHERE %s=(%s)", table, idColumn, idQuery)
+	if _, err := t.tx.ExecContext(t.ctx, cmdDelete, name); err != nil {
+		return SQLError{cmdDelete, err}
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+
+	cmdBatch := fmt.Sprintf("INSERT INTO %s (%s, label, value) VALUES ", table, idColumn)
+	vals := []interface{}{}
+	for label, value := range labels {
+		cmdBatch += "((" + idQuery + "), ?, ?),"
+		vals = append(vals, name, label, value)
+	}
+	cmdBatch = strings.TrimSuffix(cmdBatch, ",")
+	if _, err := t.tx.ExecContext(t.ctx, cmdBatch, vals...); err != nil {
+		return SQLError{cmdBatch, err}
+	}
+	return nil
+}
+
+// setClusterLabels replaces the labels of a cluster in cluster_labels table and marks the cluster changed
+func (t *tornjakTxHelper) setClusterLabels(clustername string, labels map[string]string) error {
+	if err := t.touchCluster(clustername); err != nil {
+		return err
+	}
+	return t.setLabels("cluster_labels", "cluster_id", "SELECT id FROM clusters WHERE name=?", clustername, labels)
+}
+
+// setAgentLabels replaces the labels of an agent in agent_labels table and marks the agent changed
+func (t *tornjakTxHelper) setAgentLabels(spiffeid string, labels map[string]string) error {
+	before, err := t.getAuditAgent(spiffeid)
+	if err != nil {
+		return err
+	}
+	if err = t.touchAgents([]string{spiffeid}); err != nil {
+		return err
+	}
+	err = t.setLabels("agent_labels", "agent_id", "SELECT id FROM agents WHERE spiffeid=?", spiffeid, labels)
+	if err != nil {
+		return err
+	}
+	return t.recordAgentChange(spiffeid, before)
+}
+
+// getObjectLabels returns the names of the objects selected by cmd and their labels
+// cmd selects the object name, label and value, with NULL labels for objects without labels
+func (t *tornjakTxHelper) getObjectLabels(cmd string) ([]string, map[string]map[string]string, error) {
+	rows, err := t.tx.QueryContext(t.ctx, cmd)
+	if err != nil {
+		return nil, nil, SQLError{cmd, err}
+	}
+	defer rows.Close()
+
+	names := []string{}
+	labels := make(map[string]map[string]string)
+	for rows.Next() {
+		var name string
+		var label, value sql.NullString
+		if err = rows.Scan(&name, &label, &value); err != nil {
+			return nil, nil, SQLError{cmd, err}
+		}
+		if _, ok := labels[name]; !ok {
+			names = append(names, name)
+			labels[name] = make(map[string]string)
+		}
+		if label.Valid {
+			labels[name][label.String] = value.String
+		}
+	}
+	return names, labels, nil
+}
+
+// ownedObject is a cluster or entry selected for an ownership transfer
+type ownedObject struct {
+	objectType string
+	id         string
+	tenant     string
+}
+
+// getOwnedObjects returns the objects of objectType owned by team
+// cmdAll selects the id and tenant of all objects of team, used if all is set,
+// otherwise cmdOne selects the owner team and tenant of each of ids
+// returns PostFailure if one of ids does not exist or is not owned by team
+func (t *tornjakTxHelper) getOwnedObjects(cmdAll, cmdOne, objectType, team string, ids []string, all bool) ([]ownedObject, error) {
+	objects := []ownedObject{}
+	if all {
+		rows, err := t.tx.QueryContext(t.ctx, cmdAll, team)
+		if err != nil {
+			return nil, SQLError{cmdAll, err}
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			var tenant sql.NullString
+			if err = rows.Scan(&id, &tenant); err != nil {
+				return nil, SQLError{cmdAll, err}
+			}
+			objects = append(objects, ownedObject{objectType, id, tenant.String})
+		}
+		return objects, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		var owner, tenant sql.NullString
+		err := t.tx.QueryRowContext(t.ctx, cmdOne, id).Scan(&owner, &tenant)
+		if err == sql.ErrNoRows {
+			return nil, PostFailure{Message: fmt.Sprintf("%s %s does not exist or has no owner", objectType, id)}
+		}
+		if err != nil {
+			return nil, SQLError{cmdOne, err}
+		}
+		if owner.String != team {
+			return nil, PostFailure{Message: fmt.Sprintf("%s %s is not owned by team %s", objectType, id, team)}
+		}
+		objects = append(objects, ownedObject{objectType, id, tenant.String})
+	}
+	return objects, nil
+}
+
+// getStrings returns the first column of the rows of cmd
+func (t *tornjakTxHelper) getStrings(cmd string, args ...interface{}) ([]string, error) {
+	rows, err := t.tx.QueryContext(t.ctx, cmd, args...)
+	if err != nil {
+		return nil, SQLError{cmd, err}
+	}
+	defer rows.Close()
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err = rows.Scan(&value); err != nil {
+			return nil, SQLError{cmd, err}
+		}
+		values = append(values, value)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, SQLError{cmd, err}
+	}
+	return values, nil
+}
+
+// getTableNames returns the tables of an attached DB schema, e.g. main, without internal sqlite tables
+func (t *tornjakTxHelper) getTableNames(schema string) ([]string, error) {
+	cmd := fmt.Sprintf(`SELECT name FROM %s.sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%%'`, schema)
+	return t.getStrings(cmd)
+}
+
+// getSharedColumns returns the columns of a table present both in the DB and the attached snapshot,
+// as snapshots of older versions lack the columns added since
+func (t *tornjakTxHelper) getSharedColumns(table string) ([]string, error) {
+	cmd := `SELECT name FROM pragma_table_info(?, 'main') 
+          WHERE name IN (SELECT name FROM pragma_table_info(?, 'snapshot'))`
+	return t.getStrings(cmd, table, table)
+}
+
+// addNoteRevision records a version of the text of a note
+func (t *tornjakTxHelper) addNoteRevision(id int64, body string, editedBy string, editedAt string) error {
+	cmd := `INSERT INTO note_revisions (note_id, body, edited_by, edited_at) VALUES (?,?,?,?)`
+	if _, err := t.tx.ExecContext(t.ctx, cmd, id, body, editedBy, editedAt); err != nil {
+		return SQLError{cmd, err}
+	}
+	return nil
+}
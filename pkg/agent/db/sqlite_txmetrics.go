@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/status"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// txMetrics counts the transactions of the DB by operation and outcome
+type txMetrics struct {
+	mu    sync.Mutex
+	since time.Time
+	ops   map[string]*types.TxOperationStats
+}
+
+func newTxMetrics() *txMetrics {
+	return &txMetrics{
+		since: time.Now().UTC(),
+		ops:   make(map[string]*types.TxOperationStats),
+	}
+}
+
+// op returns the counters of operation, m.mu must be held
+func (m *txMetrics) op(operation string) *types.TxOperationStats {
+	stats, ok := m.ops[operation]
+	if !ok {
+		stats = &types.TxOperationStats{Operation: operation, Rollbacks: make(map[string]int64), PostFailures: make(map[string]int64)}
+		m.ops[operation] = stats
+	}
+	return stats
+}
+
+func (m *txMetrics) commit(operation string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.op(operation).CommitFailures++
+	} else {
+		m.op(operation).Commits++
+	}
+}
+
+func (m *txMetrics) rollback(operation string, cause string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.op(operation).Rollbacks[cause]++
+}
+
+// postFailure counts a rollback of operation upon a PostFailure of category
+func (m *txMetrics) postFailure(operation string, category string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.op(operation).PostFailures[category]++
+}
+
+// stats returns a copy of the counters, sorted by operation
+func (m *txMetrics) stats() types.TxStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := types.TxStats{
+		Since:        m.since.Format(time.RFC3339),
+		Operations:   make([]types.TxOperationStats, 0, len(m.ops)),
+		PostFailures: make(map[string]int64),
+	}
+	for _, stats := range m.ops {
+		rollbacks := make(map[string]int64, len(stats.Rollbacks))
+		for cause, n := range stats.Rollbacks {
+			rollbacks[cause] = n
+		}
+		postFailures := make(map[string]int64, len(stats.PostFailures))
+		for category, n := range stats.PostFailures {
+			postFailures[category] = n
+			ret.PostFailures[category] += n
+		}
+		op := *stats
+		op.Rollbacks = rollbacks
+		op.PostFailures = postFailures
+		ret.Operations = append(ret.Operations, op)
+	}
+	sort.Slice(ret.Operations, func(i, j int) bool {
+		return ret.Operations[i].Operation < ret.Operations[j].Operation
+	})
+	return ret
+}
+
+// classifyRollback returns the cause of the error a transaction is rolled back upon
+func classifyRollback(err error) string {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return types.RollbackCauseCanceled
+	}
+	var serr sqlite3.Error
+	if errors.As(err, &serr) {
+		switch serr.Code {
+		case sqlite3.ErrConstraint:
+			return types.RollbackCauseConstraint
+		case sqlite3.ErrBusy, sqlite3.ErrLocked:
+			return types.RollbackCauseBusy
+		case sqlite3.ErrInterrupt:
+			return types.RollbackCauseCanceled
+		}
+		return types.RollbackCauseOther
+	}
+	// PostFailure is raised on conflicts with the stored data
+	var pf PostFailure
+	if errors.As(err, &pf) {
+		return types.RollbackCauseConstraint
+	}
+	if _, ok := status.FromError(err); ok {
+		return types.RollbackCauseDependency
+	}
+	return types.RollbackCauseOther
+}
+
+type txLogRecord struct {
+	Time          string `json:"time"`
+	Operation     string `json:"operation"`
+	Outcome       string `json:"outcome"`
+	Cause         string `json:"cause,omitempty"`
+	Error         string `json:"error,omitempty"`
+	RollbackError string `json:"rollback_error,omitempty"`
+}
+
+// logTx writes a structured log line for a transaction that was not committed
+func logTx(record txLogRecord) {
+	record.Time = time.Now().UTC().Format(time.RFC3339)
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("WARNING: could not log transaction %s: %v", record.Operation, err)
+		return
+	}
+	log.Printf("transaction: %s", line)
+}
@@ -0,0 +1,622 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	backoff "github.com/cenkalti/backoff/v4"
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/collation"
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// AGENT - SELECTOR/PLUGIN HANDLERS
+
+// addKnownPluginTypes adds the plugin types of the attestors shipped with SPIRE
+func (db *Store) addKnownPluginTypes() error {
+	cmd := `INSERT INTO plugin_types (name, custom) VALUES (?, ?) ` + db.dialect.Upsert("name")
+	for _, name := range types.KnownPluginTypes {
+		if _, err := db.exec(cmd, name, false); err != nil {
+			return agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+	}
+	return nil
+}
+
+// CreateAgentEntry assigns the plugin type of sinfo, normalized with types.NormalizePluginType, to the agent
+// an empty plugin removes the agent's plugin type
+// returns PostFailure if the plugin type is invalid
+func (db *Store) CreateAgentEntry(sinfo types.AgentInfo) error {
+	var pluginType interface{}
+	var normalized types.PluginType
+	if len(sinfo.Plugin) > 0 {
+		var err error
+		normalized, err = types.NormalizePluginType(sinfo.Plugin)
+		if err != nil {
+			return agentdb.PostFailure{Message: fmt.Sprintf("Invalid plugin of agent %v: %v", sinfo.Spiffeid, err)}
+		}
+		pluginType = normalized.Name
+	}
+	operation := func() error {
+		t, err := db.begin(context.Background(), "createAgentEntry")
+		if err != nil {
+			return err
+		}
+		before, err := t.getAuditAgent(sinfo.Spiffeid)
+		if err != nil {
+			return t.rollbackHandler(err)
+		}
+		if pluginType != nil {
+			cmdType := `INSERT INTO plugin_types (name, custom) VALUES (?, ?) ` + t.dialect.Upsert("name")
+			if _, err = t.exec(cmdType, normalized.Name, normalized.Custom); err != nil {
+				return t.rollbackHandler(agentdb.SQLError{Cmd: cmdType, Err: err})
+			}
+		}
+		// plugin type names are unique regardless of case
+		now := t.now()
+		cmd := `INSERT INTO agents (spiffeid, plugin_type_id, created_at, updated_at)
+          VALUES (?, (SELECT id FROM plugin_types WHERE lower(name)=lower(?)), ?, ?)
+          ` + t.dialect.UpsertChanged("agents", "spiffeid", "plugin_type_id")
+		if _, err = t.exec(cmd, sinfo.Spiffeid, pluginType, now, now); err != nil {
+			return t.rollbackHandler(agentdb.SQLError{Cmd: cmd, Err: err})
+		}
+		if err = t.recordAgentChange(sinfo.Spiffeid, before); err != nil {
+			return t.rollbackHandler(err)
+		}
+		return t.commit()
+	}
+	return db.retryOp(operation)
+}
+
+// GetPluginTypes returns the known plugin types and the custom plugin types assigned to agents
+func (db *Store) GetPluginTypes() (types.PluginTypeList, error) {
+	cmd := `SELECT name, custom FROM plugin_types
+          WHERE NOT custom OR id IN (SELECT plugin_type_id FROM agents WHERE plugin_type_id IS NOT NULL)`
+	rows, err := db.query(cmd)
+	if err != nil {
+		return types.PluginTypeList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	pluginTypes := []types.PluginType{}
+	for rows.Next() {
+		var pluginType types.PluginType
+		if err = rows.Scan(&pluginType.Name, &pluginType.Custom); err != nil {
+			return types.PluginTypeList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		pluginTypes = append(pluginTypes, pluginType)
+	}
+	collation.Sort(db.collation, pluginTypes, func(a types.PluginType) string { return a.Name })
+	return types.PluginTypeList{PluginTypes: pluginTypes}, nil
+}
+
+// SetAgentDisplayName assigns a display name to the agent with the given spiffeid
+// an empty display name removes the agent's display name
+func (db *Store) SetAgentDisplayName(spiffeid string, displayName string) error {
+	var name interface{}
+	if len(displayName) > 0 {
+		name = displayName
+	}
+	operation := func() error {
+		t, err := db.begin(context.Background(), "setAgentDisplayName")
+		if err != nil {
+			return err
+		}
+		before, err := t.getAuditAgent(spiffeid)
+		if err != nil {
+			return t.rollbackHandler(err)
+		}
+		cmd := `INSERT INTO agents (spiffeid, display_name, created_at, updated_at) VALUES (?, ?, ?, ?)
+          ` + t.dialect.UpsertChanged("agents", "spiffeid", "display_name")
+		now := t.now()
+		if _, err = t.exec(cmd, spiffeid, name, now, now); err != nil {
+			return t.rollbackHandler(agentdb.SQLError{Cmd: cmd, Err: err})
+		}
+		if err = t.recordAgentChange(spiffeid, before); err != nil {
+			return t.rollbackHandler(err)
+		}
+		return t.commit()
+	}
+	return db.retryOp(operation)
+}
+
+func (db *Store) GetAgentSelectors() (types.AgentInfoList, error) {
+	sinfos, err := db.getAgentSelectors("", nil)
+	if err != nil {
+		return types.AgentInfoList{}, err
+	}
+	return types.AgentInfoList{Agents: sinfos}, nil
+}
+
+// agentSelectorColumns lists the fields agent selectors can be filtered on
+var agentSelectorColumns = map[string]string{
+	"plugin": "plugin_types.name",
+}
+
+// GetAgentSelectorsPage returns a page of the agents with a plugin type, in
+// the order of their SPIFFE IDs under the collation of the DB
+func (db *Store) GetAgentSelectorsPage(opts types.ListOptions) (types.List[types.AgentInfo], error) {
+	fields := make([]string, 0, len(agentSelectorColumns))
+	for f := range agentSelectorColumns {
+		fields = append(fields, f)
+	}
+	if len(opts.Sort) > 0 {
+		return types.List[types.AgentInfo]{}, errors.New("agents are sorted by SPIFFE ID only")
+	}
+	if err := opts.Validate(fields, nil); err != nil {
+		return types.List[types.AgentInfo]{}, err
+	}
+
+	conds := []string{}
+	args := []interface{}{}
+	for _, f := range opts.Filters {
+		conds = append(conds, agentSelectorColumns[f.Field]+" = ?")
+		args = append(args, f.Value)
+	}
+	from := `agents JOIN plugin_types ON agents.plugin_type_id = plugin_types.id`
+	if len(conds) > 0 {
+		from += " WHERE " + strings.Join(conds, " AND ")
+	}
+	page, offset, total, err := agentdb.NamesPage(context.Background(), db.database, "agents.spiffeid", bind(db.dialect, from), args, db.dialect.NameOrder, opts)
+	if err != nil {
+		return types.List[types.AgentInfo]{}, err
+	}
+	if len(page) == 0 {
+		return types.NewList([]types.AgentInfo{}, offset, total), nil
+	}
+	sinfos, err := db.getAgentSelectors(" WHERE agents.spiffeid IN ("+placeholders(len(page))+")", stringArgs(page))
+	if err != nil {
+		return types.List[types.AgentInfo]{}, err
+	}
+	return types.NewList(sinfos, offset, total), nil
+}
+
+// getAgentSelectors returns the agents with a plugin type selected by where,
+// sorted by SPIFFE ID
+func (db *Store) getAgentSelectors(where string, args []interface{}) ([]types.AgentInfo, error) {
+	cmd := `SELECT agents.spiffeid, plugin_types.name, agents.display_name
+          FROM agents
+          JOIN plugin_types ON agents.plugin_type_id = plugin_types.id` + where
+	rows, err := db.query(cmd, args...)
+	if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	sinfos := []types.AgentInfo{}
+	for rows.Next() {
+		var sinfo types.AgentInfo
+		var displayName sql.NullString
+		if err = rows.Scan(&sinfo.Spiffeid, &sinfo.Plugin, &displayName); err != nil {
+			return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		sinfo.DisplayName = displayName.String
+		sinfos = append(sinfos, sinfo)
+	}
+	collation.Sort(db.collation, sinfos, func(a types.AgentInfo) string { return a.Spiffeid })
+	return sinfos, nil
+}
+
+func (db *Store) GetAgentPluginInfo(spiffeid string) (types.AgentInfo, error) {
+	cmd := `SELECT agents.spiffeid, plugin_types.name, agents.display_name
+          FROM agents
+          LEFT JOIN plugin_types ON agents.plugin_type_id = plugin_types.id
+          WHERE agents.spiffeid=?`
+	sinfo := types.AgentInfo{}
+	var plugin, displayName sql.NullString
+	err := db.queryRow(cmd, spiffeid).Scan(&sinfo.Spiffeid, &plugin, &displayName)
+	if err == sql.ErrNoRows || (err == nil && !plugin.Valid) {
+		return types.AgentInfo{}, agentdb.GetError{Message: fmt.Sprintf("Agent %v has no assigned plugin", spiffeid)}
+	} else if err != nil {
+		return types.AgentInfo{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	sinfo.Plugin = plugin.String
+	sinfo.DisplayName = displayName.String
+	return sinfo, nil
+}
+
+// AGENT - CLUSTER HANDLERS
+
+// GetClusterAgents takes in string cluster name and outputs array of spiffeids of agents assigned to the cluster
+func (db *Store) GetClusterAgents(name string) ([]string, error) {
+	var exists bool
+	cmdCluster := `SELECT EXISTS (SELECT 1 FROM clusters WHERE name=?)`
+	if err := db.queryRow(cmdCluster, name).Scan(&exists); err != nil {
+		return nil, agentdb.SQLError{Cmd: cmdCluster, Err: err}
+	}
+	if !exists {
+		return nil, agentdb.GetError{Message: fmt.Sprintf("Cluster %v not registered", name)}
+	}
+
+	cmd := `SELECT agents.spiffeid
+          FROM clusters
+          JOIN cluster_memberships ON clusters.id=cluster_memberships.cluster_id
+          JOIN agents ON cluster_memberships.agent_id=agents.id
+          WHERE clusters.name=?`
+	spiffeids, err := db.getStrings(cmd, name)
+	if err != nil {
+		return nil, err
+	}
+	db.collation.Strings(spiffeids)
+	return spiffeids, nil
+}
+
+// FindAgentsByPattern returns a page of the SPIFFE IDs of the agents matching
+// the pattern, see agentdb.AgentPattern
+// LIKE is case-sensitive, the DataStores index the SPIFFE IDs so LIKE finds
+// those starting with the characters before the first wildcard
+func (db *Store) FindAgentsByPattern(pattern string, opts types.ListOptions) (types.List[string], error) {
+	p, err := agentdb.ParseAgentPattern(pattern)
+	if err != nil {
+		return types.List[string]{}, err
+	}
+	return agentdb.AgentsPage(context.Background(), db.database, bind(db.dialect, `agents WHERE agents.spiffeid LIKE ? ESCAPE '!'`), []interface{}{p.Like}, db.dialect.NameOrder, opts)
+}
+
+// GetClusterAgentsPage returns a page of the SPIFFE IDs of the agents assigned
+// to the cluster, in their order under the collation of the DB
+func (db *Store) GetClusterAgentsPage(name string, opts types.ListOptions) (types.List[string], error) {
+	var exists bool
+	cmdCluster := `SELECT EXISTS (SELECT 1 FROM clusters WHERE name=?)`
+	if err := db.queryRow(cmdCluster, name).Scan(&exists); err != nil {
+		return types.List[string]{}, agentdb.SQLError{Cmd: cmdCluster, Err: err}
+	}
+	if !exists {
+		return types.List[string]{}, agentdb.GetError{Message: fmt.Sprintf("Cluster %v not registered", name)}
+	}
+	from := `clusters
+          JOIN cluster_memberships ON clusters.id=cluster_memberships.cluster_id
+          JOIN agents ON cluster_memberships.agent_id=agents.id
+          WHERE clusters.name=?`
+	return agentdb.AgentsPage(context.Background(), db.database, bind(db.dialect, from), []interface{}{name}, db.dialect.NameOrder, opts)
+}
+
+// GetAgentClusterName takes in string of spiffeid of agent and outputs the name of the cluster
+func (db *Store) GetAgentClusterName(spiffeid string) (string, error) {
+	var clusterName sql.NullString
+	cmd := `SELECT clusters.name
+          FROM agents
+          LEFT JOIN cluster_memberships ON agents.id=cluster_memberships.agent_id
+          LEFT JOIN clusters ON cluster_memberships.cluster_id=clusters.id
+          WHERE agents.spiffeid=?`
+	err := db.queryRow(cmd, spiffeid).Scan(&clusterName)
+	if err == sql.ErrNoRows {
+		return "", agentdb.GetError{Message: fmt.Sprintf("Agent %v unassigned to any cluster", spiffeid)}
+	} else if err != nil {
+		return "", agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	if !clusterName.Valid {
+		return "", agentdb.GetError{Message: fmt.Sprintf("Agent %v assinged to unregistered cluster", spiffeid)}
+	}
+	return clusterName.String, nil
+}
+
+// GetAgentClusterNames takes in a list of agent spiffeids and outputs a map from spiffeid to cluster name
+// agents that are unknown or unassigned to a registered cluster are not included in the map
+func (db *Store) GetAgentClusterNames(spiffeids []string) (map[string]string, error) {
+	clusterNames := make(map[string]string, len(spiffeids))
+	if len(spiffeids) == 0 {
+		return clusterNames, nil
+	}
+	cmd := `SELECT agents.spiffeid, clusters.name
+          FROM agents
+          JOIN cluster_memberships ON agents.id=cluster_memberships.agent_id
+          JOIN clusters ON cluster_memberships.cluster_id=clusters.id
+          WHERE agents.spiffeid IN (` + placeholders(len(spiffeids)) + `)`
+	rows, err := db.query(cmd, stringArgs(spiffeids)...)
+	if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var spiffeid, clusterName string
+		if err = rows.Scan(&spiffeid, &clusterName); err != nil {
+			return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		clusterNames[spiffeid] = clusterName
+	}
+	if err = rows.Err(); err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return clusterNames, nil
+}
+
+// GetAgentsMetadata takes a AgentMetadataRequest with a list of agent spiffeids
+// outputs list of agentinfo objects, where spiffeids must be in the input list
+// includes info on plugin, clustername and labels
+// returns GetError on compliance filters, compliance reports are not stored
+func (db *Store) GetAgentsMetadata(req types.AgentMetadataRequest) (types.AgentInfoList, error) {
+	if len(req.Compliance) > 0 {
+		return types.AgentInfoList{}, agentdb.GetError{Message: db.unsupported(agentdb.CapabilityAgentCompliance).Error()}
+	}
+	conds := []string{}
+	vals := []interface{}{}
+	if len(req.Agents) > 0 {
+		vals = append(vals, stringArgs(req.Agents)...)
+		conds = append(conds, `agents.spiffeid IN (`+placeholders(len(req.Agents))+`)`)
+	}
+	if len(req.Plugin) > 0 {
+		pluginType, err := types.NormalizePluginType(req.Plugin)
+		if err != nil {
+			return types.AgentInfoList{}, agentdb.GetError{Message: err.Error()}
+		}
+		vals = append(vals, pluginType.Name)
+		conds = append(conds, `agents.plugin_type_id IN (SELECT id FROM plugin_types WHERE lower(name)=lower(?))`)
+	}
+	if len(req.Search) > 0 {
+		// matches regardless of case, as LIKE does in sqlite
+		vals = append(vals, "%"+req.Search+"%", "%"+req.Search+"%")
+		conds = append(conds, `(lower(agents.spiffeid) LIKE lower(?) OR lower(agents.display_name) LIKE lower(?))`)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = ` WHERE ` + strings.Join(conds, " AND ")
+	}
+
+	// SELECT the page of the matching agents, ahead of their details
+	offset, total := 0, 0
+	if req.Paginated() {
+		var page []string
+		var err error
+		page, offset, total, err = agentdb.NamesPage(context.Background(), db.database, "agents.spiffeid", bind(db.dialect, `agents`+where), vals, db.dialect.NameOrder, req.PageOptions())
+		if err != nil {
+			return types.AgentInfoList{}, err
+		}
+		if len(page) == 0 {
+			return types.AgentInfoList{Agents: []types.AgentInfo{}, Total: total}, nil
+		}
+		where, vals = ` WHERE agents.spiffeid IN (`+placeholders(len(page))+`)`, stringArgs(page)
+	}
+
+	cmd := `SELECT agents.spiffeid, plugin_types.name, clusters.name, agents.display_name,
+          agents.created_at, agents.updated_at
+          FROM agents
+          LEFT JOIN plugin_types ON agents.plugin_type_id = plugin_types.id
+          LEFT JOIN cluster_memberships ON agents.id = cluster_memberships.agent_id
+          LEFT JOIN clusters ON cluster_memberships.cluster_id = clusters.id` + where
+	rows, err := db.query(cmd, vals...)
+	if err != nil {
+		return types.AgentInfoList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	ainfos := []types.AgentInfo{}
+	for rows.Next() {
+		var spiffeid string
+		var plugin, cluster, displayName, createdAt, updatedAt sql.NullString
+		if err = rows.Scan(&spiffeid, &plugin, &cluster, &displayName, &createdAt, &updatedAt); err != nil {
+			return types.AgentInfoList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		creationTime, err := agentdb.ParseTimestamp(createdAt.String)
+		if err != nil {
+			return types.AgentInfoList{}, errors.Errorf("Invalid creation time of agent %s: %v", spiffeid, err)
+		}
+		changeTime, err := agentdb.ParseTimestamp(updatedAt.String)
+		if err != nil {
+			return types.AgentInfoList{}, errors.Errorf("Invalid change time of agent %s: %v", spiffeid, err)
+		}
+		ainfos = append(ainfos, types.AgentInfo{
+			Spiffeid:     spiffeid,
+			Plugin:       plugin.String,
+			Cluster:      cluster.String,
+			DisplayName:  displayName.String,
+			CreationTime: creationTime,
+			UpdatedAt:    changeTime,
+		})
+	}
+	if err = rows.Err(); err != nil {
+		return types.AgentInfoList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+
+	// ADD labels of the selected agents
+	cmdLabels := `SELECT agents.spiffeid, agent_labels.label, agent_labels.value
+          FROM agent_labels
+          JOIN agents ON agent_labels.agent_id = agents.id` + where
+	labels, err := db.getLabels(cmdLabels, vals...)
+	if err != nil {
+		return types.AgentInfoList{}, err
+	}
+	for i := range ainfos {
+		ainfos[i].Labels = labels[ainfos[i].Spiffeid]
+	}
+
+	// ADD annotations of the selected agents
+	cmdAnnotations := `SELECT agents.spiffeid, agent_annotations.annotation, agent_annotations.value
+          FROM agent_annotations
+          JOIN agents ON agent_annotations.agent_id = agents.id` + where
+	annotations, err := db.getLabels(cmdAnnotations, vals...)
+	if err != nil {
+		return types.AgentInfoList{}, err
+	}
+	for i := range ainfos {
+		ainfos[i].Annotations = annotations[ainfos[i].Spiffeid]
+	}
+	collation.Sort(db.collation, ainfos, func(a types.AgentInfo) string { return a.Spiffeid })
+
+	if !req.Paginated() {
+		return types.AgentInfoList{Agents: ainfos}, nil
+	}
+	return types.AgentInfoList{
+		Agents:     ainfos,
+		NextCursor: types.NewList(ainfos, offset, total).NextCursor,
+		Total:      total,
+	}, nil
+}
+
+// AGENT ASSIGNMENT HANDLERS
+
+func (db *Store) assignAgentsToClustersOp(assignments []types.AgentAssignment, dryRun bool) (types.AgentAssignmentResult, error) {
+	// BEGIN transaction
+	txHelper, err := db.begin(context.Background(), "assignAgentsToClusters")
+	if err != nil {
+		return types.AgentAssignmentResult{}, backoff.Permanent(err)
+	}
+
+	// SELECT clusters by UID and current clusters of agents
+	// the memberships are locked so concurrent assignments of the same agents wait
+	clusterNames, err := txHelper.getStringPairs(`SELECT uid, name FROM clusters`)
+	if err != nil {
+		return types.AgentAssignmentResult{}, txHelper.rollbackHandler(err)
+	}
+	agentClusters, err := txHelper.getStringPairs(`SELECT agents.spiffeid, clusters.name
+          FROM cluster_memberships
+          JOIN agents ON cluster_memberships.agent_id=agents.id
+          JOIN clusters ON cluster_memberships.cluster_id=clusters.id ` + txHelper.dialect.ForUpdateOf("cluster_memberships"))
+	if err != nil {
+		return types.AgentAssignmentResult{}, txHelper.rollbackHandler(err)
+	}
+
+	// UPDATE memberships of agents not yet in their cluster
+	cmdAgent := `INSERT INTO agents (spiffeid, created_at, updated_at) VALUES (?, ?, ?)
+          ` + txHelper.dialect.Upsert("spiffeid", "updated_at")
+	cmdMembership := `INSERT INTO cluster_memberships (agent_id, cluster_id)
+          VALUES ((SELECT id FROM agents WHERE spiffeid=?), (SELECT id FROM clusters WHERE name=?))
+          ` + txHelper.dialect.Upsert("agent_id", "cluster_id")
+	result := types.AgentAssignmentResult{DryRun: dryRun, Rows: len(assignments), Errors: []types.AgentAssignmentError{}}
+	changed := make(map[string]bool)
+	for _, a := range assignments {
+		name, ok := clusterNames[a.ClusterUID]
+		if !ok {
+			result.Errors = append(result.Errors, types.AgentAssignmentError{
+				Row: a.Row, Spiffeid: a.Spiffeid, ClusterUID: a.ClusterUID, Code: types.BatchCodeNotFound,
+				Error: "cluster does not exist",
+			})
+			continue
+		}
+		current, assigned := agentClusters[a.Spiffeid]
+		if assigned && current == name {
+			result.Unchanged++
+			continue
+		}
+		result.Assigned++
+		if dryRun {
+			continue
+		}
+		now := txHelper.now()
+		if _, err = txHelper.exec(cmdAgent, a.Spiffeid, now, now); err != nil {
+			return types.AgentAssignmentResult{}, txHelper.rollbackHandler(agentdb.SQLError{Cmd: cmdAgent, Err: err})
+		}
+		if _, err = txHelper.exec(cmdMembership, a.Spiffeid, name); err != nil {
+			return types.AgentAssignmentResult{}, txHelper.rollbackHandler(agentdb.SQLError{Cmd: cmdMembership, Err: err})
+		}
+		agentClusters[a.Spiffeid] = name
+		changed[name] = true
+		if assigned {
+			changed[current] = true
+		}
+	}
+	if dryRun {
+		return result, txHelper.tx.Rollback()
+	}
+
+	// ADD the clusters that gained or lost agents to history
+	names := make([]string, 0, len(changed))
+	for name := range changed {
+		names = append(names, name)
+	}
+	db.collation.Strings(names)
+	if err = txHelper.touchClusters(names); err != nil {
+		return types.AgentAssignmentResult{}, txHelper.rollbackHandler(err)
+	}
+	for _, name := range names {
+		err = txHelper.recordClusterHistory(name, types.ClusterChangeUpdated)
+		if err != nil {
+			return types.AgentAssignmentResult{}, txHelper.rollbackHandler(err)
+		}
+	}
+	return result, txHelper.commit()
+}
+
+// AssignAgentsToClusters assigns each agent to the cluster with the given UID in one transaction,
+// moving agents assigned to another cluster
+// rows naming an unknown cluster are reported in the result and not applied
+// with dryRun set, the result is returned without applying the assignments
+func (db *Store) AssignAgentsToClusters(assignments []types.AgentAssignment, dryRun bool) (types.AgentAssignmentResult, error) {
+	var result types.AgentAssignmentResult
+	operation := func() error {
+		var err error
+		result, err = db.assignAgentsToClustersOp(assignments, dryRun)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+func (db *Store) moveAgentsBetweenClustersOp(fromUID string, toUID string, spiffeids []string) (types.AgentMoveResult, error) {
+	// BEGIN transaction
+	txHelper, err := db.begin(context.Background(), "moveAgentsBetweenClusters")
+	if err != nil {
+		return types.AgentMoveResult{}, backoff.Permanent(err)
+	}
+
+	// UPDATE memberships of agents from one cluster to the other
+	result, err := agentdb.MoveAgents(txHelper.moveAgentsTx(), fromUID, toUID, spiffeids)
+	if err != nil {
+		return types.AgentMoveResult{}, txHelper.rollbackHandler(err)
+	}
+	return result, txHelper.commit()
+}
+
+// MoveAgentsBetweenClusters moves the agents of spiffeids from the cluster with UID
+// fromUID to the cluster with UID toUID in one transaction
+// returns PostFailure, and moves no agent, if a cluster does not exist or an agent is
+// not in the cluster with UID fromUID
+func (db *Store) MoveAgentsBetweenClusters(fromUID string, toUID string, spiffeids []string) (types.AgentMoveResult, error) {
+	var result types.AgentMoveResult
+	operation := func() error {
+		var err error
+		result, err = db.moveAgentsBetweenClustersOp(fromUID, toUID, spiffeids)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+// getStrings returns the first column of the rows of cmd
+func (db *Store) getStrings(cmd string, args ...interface{}) ([]string, error) {
+	rows, err := db.query(cmd, args...)
+	if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err = rows.Scan(&value); err != nil {
+			return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		values = append(values, value)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return values, nil
+}
+
+// getLabels returns the labels selected by cmd by object name
+// cmd selects the object name, label and value
+func (db *Store) getLabels(cmd string, args ...interface{}) (map[string]map[string]string, error) {
+	rows, err := db.query(cmd, args...)
+	if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	labels := make(map[string]map[string]string)
+	for rows.Next() {
+		var name, label, value string
+		if err = rows.Scan(&name, &label, &value); err != nil {
+			return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		if labels[name] == nil {
+			labels[name] = make(map[string]string)
+		}
+		labels[name][label] = value
+	}
+	return labels, rows.Err()
+}
@@ -0,0 +1,82 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// AGENT ANNOTATION HANDLERS
+
+// SetAgentAnnotation sets the value of an annotation of an agent, storing the agent if unknown
+func (db *Store) SetAgentAnnotation(annotation types.AgentAnnotation) error {
+	operation := func() error {
+		t, err := db.begin(context.Background(), "setAgentAnnotation")
+		if err != nil {
+			return err
+		}
+		now := t.now()
+		cmd := `INSERT INTO agents (spiffeid, created_at, updated_at) VALUES (?, ?, ?) ` + t.dialect.Upsert("spiffeid")
+		if _, err = t.exec(cmd, annotation.Spiffeid, now, now); err != nil {
+			return t.rollbackHandler(agentdb.SQLError{Cmd: cmd, Err: err})
+		}
+		cmd = `INSERT INTO agent_annotations (agent_id, annotation, value, updated_by, updated_at)
+          SELECT id, ?, ?, ?, ? FROM agents WHERE spiffeid=?
+          ` + t.dialect.Upsert("agent_id, annotation", "value", "updated_by", "updated_at")
+		if _, err = t.exec(cmd, annotation.Key, annotation.Value, annotation.UpdatedBy,
+			annotation.UpdatedAt, annotation.Spiffeid); err != nil {
+			return t.rollbackHandler(agentdb.SQLError{Cmd: cmd, Err: err})
+		}
+		return t.commit()
+	}
+	return db.retryOp(operation)
+}
+
+// DeleteAgentAnnotation deletes an annotation of an agent
+func (db *Store) DeleteAgentAnnotation(spiffeid string, key string) error {
+	cmd := `DELETE FROM agent_annotations WHERE annotation=? AND agent_id=(SELECT id FROM agents WHERE spiffeid=?)`
+	res, err := db.exec(cmd, key, spiffeid)
+	if err != nil {
+		return agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	if numRows != 1 {
+		return agentdb.PostFailure{Message: fmt.Sprintf("Annotation %s of agent %s does not exist", key, spiffeid)}
+	}
+	return nil
+}
+
+// GetAgentAnnotations outputs the annotations of the given agents, all agents if empty,
+// by SPIFFE ID and key in binary order, whatever the collation of the database
+func (db *Store) GetAgentAnnotations(spiffeids []string) (types.AgentAnnotationList, error) {
+	cmd := `SELECT agents.spiffeid, agent_annotations.annotation, agent_annotations.value,
+          agent_annotations.updated_by, agent_annotations.updated_at FROM agent_annotations
+          JOIN agents ON agent_annotations.agent_id = agents.id`
+	if len(spiffeids) > 0 {
+		cmd += ` WHERE agents.spiffeid IN (` + placeholders(len(spiffeids)) + `)`
+	}
+	rows, err := db.query(cmd, stringArgs(spiffeids)...)
+	if err != nil {
+		return types.AgentAnnotationList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	annotations := []types.AgentAnnotation{}
+	for rows.Next() {
+		a := types.AgentAnnotation{}
+		if err = rows.Scan(&a.Spiffeid, &a.Key, &a.Value, &a.UpdatedBy, &a.UpdatedAt); err != nil {
+			return types.AgentAnnotationList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		annotations = append(annotations, a)
+	}
+	if err = rows.Err(); err != nil {
+		return types.AgentAnnotationList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	agentdb.SortAgentAnnotations(annotations)
+	return types.AgentAnnotationList{Annotations: annotations}, nil
+}
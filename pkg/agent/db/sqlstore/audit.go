@@ -0,0 +1,143 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// AUDIT HANDLERS
+
+// WithActor returns the DB recording actor as the user making the changes
+// made through it in the audit log; the DB itself records no actor
+func (db *Store) WithActor(actor string) agentdb.AgentDB {
+	return db.ForActor(actor)
+}
+
+// ForActor returns the Store of WithActor, for the DataStores embedding the Store
+func (db *Store) ForActor(actor string) *Store {
+	view := *db
+	view.actor = actor
+	return &view
+}
+
+// GetAuditEvents outputs a page of the changes of clusters, agents and
+// memberships, most recent first, filtered on actor, action, objectType,
+// objectId and clusterUid
+func (db *Store) GetAuditEvents(opts types.ListOptions) (types.List[types.AuditEvent], error) {
+	fields := make([]string, 0, len(agentdb.AuditEventColumns))
+	for f := range agentdb.AuditEventColumns {
+		fields = append(fields, f)
+	}
+	if len(opts.Sort) > 0 {
+		return types.List[types.AuditEvent]{}, errors.New("audit events are sorted by time only")
+	}
+	if err := opts.Validate(fields, nil); err != nil {
+		return types.List[types.AuditEvent]{}, err
+	}
+	offset, limit, err := opts.PageBounds()
+	if err != nil {
+		return types.List[types.AuditEvent]{}, err
+	}
+
+	conds := []string{}
+	args := []interface{}{}
+	for _, f := range opts.Filters {
+		args = append(args, f.Value)
+		conds = append(conds, agentdb.AuditEventColumns[f.Field]+" = ?")
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	cmdCount := `SELECT COUNT(*) FROM audit_events` + where
+	var total int
+	if err = db.queryRow(cmdCount, args...).Scan(&total); err != nil {
+		return types.List[types.AuditEvent]{}, agentdb.SQLError{Cmd: cmdCount, Err: err}
+	}
+
+	cmd := `SELECT id, actor, action, object_type, object_id, cluster_uid, before_state, after_state, recorded_at
+          FROM audit_events` + where + ` ORDER BY id DESC LIMIT ? OFFSET ?`
+	rows, err := db.query(cmd, append(args, limit, offset)...)
+	if err != nil {
+		return types.List[types.AuditEvent]{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	events := []types.AuditEvent{}
+	for rows.Next() {
+		var e types.AuditEvent
+		var actor, clusterUID, before, after sql.NullString
+		if err = rows.Scan(&e.ID, &actor, &e.Action, &e.ObjectType, &e.ObjectId, &clusterUID, &before, &after, &e.Timestamp); err != nil {
+			return types.List[types.AuditEvent]{}, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		e.Actor, e.ClusterUID = actor.String, clusterUID.String
+		e.Before, e.After = agentdb.AuditState(before.String), agentdb.AuditState(after.String)
+		events = append(events, e)
+	}
+	if err = rows.Err(); err != nil {
+		return types.List[types.AuditEvent]{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return types.NewList(events, offset, total), nil
+}
+
+// recordAuditEvents adds the events to the audit log, made by the actor of the transaction
+// returns SQLError on failure
+func (t *txHelper) recordAuditEvents(events []types.AuditEvent) error {
+	cmd := `INSERT INTO audit_events (actor, action, object_type, object_id, cluster_uid, before_state, after_state, recorded_at)
+          VALUES (?,?,?,?,?,?,?,?)`
+	for _, event := range events {
+		_, err := t.exec(cmd, t.actor, event.Action, event.ObjectType, event.ObjectId, event.ClusterUID,
+			string(event.Before), string(event.After), t.now())
+		if err != nil {
+			return agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+	}
+	return nil
+}
+
+// getAuditAgent returns the state of the agent recorded in the audit log, nil if it is not stored
+// the agent is locked until the end of the transaction
+// returns SQLError on failure
+func (t *txHelper) getAuditAgent(spiffeid string) (*types.AuditAgent, error) {
+	var plugin, displayName sql.NullString
+	cmd := `SELECT plugin_types.name, agents.display_name FROM agents
+          LEFT JOIN plugin_types ON agents.plugin_type_id=plugin_types.id WHERE agents.spiffeid=? ` + t.dialect.ForUpdateOf("agents")
+	err := t.queryRow(cmd, spiffeid).Scan(&plugin, &displayName)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	agent := &types.AuditAgent{Spiffeid: spiffeid, Plugin: plugin.String, DisplayName: displayName.String}
+
+	_, labels, err := t.getObjectLabels(`SELECT agents.spiffeid, agent_labels.label, agent_labels.value
+          FROM agent_labels
+          JOIN agents ON agent_labels.agent_id=agents.id
+          WHERE agents.spiffeid=?`, spiffeid)
+	if err != nil {
+		return nil, err
+	}
+	agent.Labels = labels[spiffeid]
+	return agent, nil
+}
+
+// recordAgentChange adds the change of an agent to the audit log, given its
+// state before the change, if it changed
+// returns SQLError on failure
+func (t *txHelper) recordAgentChange(spiffeid string, before *types.AuditAgent) error {
+	after, err := t.getAuditAgent(spiffeid)
+	if err != nil {
+		return err
+	}
+	event, changed, err := agentdb.AgentAuditEvent(before, after)
+	if err != nil || !changed {
+		return err
+	}
+	return t.recordAuditEvents([]types.AuditEvent{event})
+}
@@ -0,0 +1,1621 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/collation"
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// CLUSTER HANDLERS
+
+// readOnlyTx are the options of transactions reading several tables, so they
+// see a single state of the database while other replicas write
+var readOnlyTx = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+
+// GetClusters outputs a list of ClusterInfo structs with information on currently registered clusters
+func (db *Store) GetClusters() (types.ClusterInfoList, error) {
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, readOnlyTx)
+	if err != nil {
+		return types.ClusterInfoList{}, errors.Errorf("Error initializing context: %v", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // read-only
+	t := &txHelper{ctx: ctx, tx: tx, dialect: db.dialect}
+
+	sinfos, err := db.getClusters(t, "", nil)
+	if err != nil {
+		return types.ClusterInfoList{}, err
+	}
+	return types.ClusterInfoList{
+		Clusters: sinfos,
+	}, nil
+}
+
+// clusterColumns lists the fields clusters can be filtered on
+var clusterColumns = map[string]string{
+	"uid":          "uid",
+	"domainName":   "domain_name",
+	"platformType": "platform_type",
+	"managedBy":    "managed_by",
+	"ownerTeam":    "owner_team",
+	"tenant":       "tenant",
+}
+
+// GetClustersPage returns a page of the registered clusters matching the
+// filters and label selector of opts, sorted on the sort fields of opts, by
+// default in the order of their names under the collation of the DB
+// only the names of the clusters are read to select the page
+func (db *Store) GetClustersPage(opts types.ListOptions) (types.List[types.ClusterInfo], error) {
+	fields := make([]string, 0, len(clusterColumns))
+	for f := range clusterColumns {
+		fields = append(fields, f)
+	}
+	if err := opts.Validate(fields, agentdb.ClusterSortFields); err != nil {
+		return types.List[types.ClusterInfo]{}, err
+	}
+
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, readOnlyTx)
+	if err != nil {
+		return types.List[types.ClusterInfo]{}, errors.Errorf("Error initializing context: %v", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // read-only
+	t := &txHelper{ctx: ctx, tx: tx, dialect: db.dialect}
+
+	conds := []string{}
+	args := []interface{}{}
+	for _, f := range opts.Filters {
+		column := clusterColumns[f.Field]
+		conds = append(conds, column+" = ?")
+		args = append(args, f.Value)
+	}
+	labelConds, labelArgs, err := agentdb.ClusterLabelConds(opts.LabelSelector, anyPlaceholder, len(args))
+	if err != nil {
+		return types.List[types.ClusterInfo]{}, err
+	}
+	conds = append(conds, labelConds...)
+	args = append(args, labelArgs...)
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	// the page is sorted and cut by the SQL database, names under the
+	// collation of the DB, see Dialect.NameOrder
+	offset, limit, err := opts.PageBounds()
+	if err != nil {
+		return types.List[types.ClusterInfo]{}, err
+	}
+	var total int
+	cmd := `SELECT COUNT(*) FROM clusters` + where
+	if err = t.queryRow(cmd, args...).Scan(&total); err != nil {
+		return types.List[types.ClusterInfo]{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	page, err := t.getStrings(`SELECT clusters.name FROM clusters`+where+agentdb.ClusterOrderBy(opts.Sort, db.dialect.NameOrder)+agentdb.LimitOffset(offset, limit), args...)
+	if err != nil {
+		return types.List[types.ClusterInfo]{}, err
+	}
+	if len(page) == 0 {
+		return types.NewList([]types.ClusterInfo{}, offset, total), nil
+	}
+	sinfos, err := db.getClusters(t, " WHERE clusters.name IN ("+placeholders(len(page))+")", stringArgs(page))
+	if err != nil {
+		return types.List[types.ClusterInfo]{}, err
+	}
+	return types.NewList(agentdb.InOrder(sinfos, page, agentdb.ClusterName), offset, total), nil
+}
+
+// SearchClusters returns the clusters matching every word of query at the
+// start of a word of their name, domain name, manager or platform type,
+// best match first
+// see Dialect.SearchClusters
+func (db *Store) SearchClusters(query string) (types.ClusterInfoList, error) {
+	terms, err := agentdb.SearchTerms(query)
+	if err != nil {
+		return types.ClusterInfoList{}, err
+	}
+	ctx := context.Background()
+	tx, err := db.database.BeginTx(ctx, readOnlyTx)
+	if err != nil {
+		return types.ClusterInfoList{}, errors.Errorf("Error initializing context: %v", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // read-only
+	t := &txHelper{ctx: ctx, tx: tx, dialect: db.dialect}
+
+	cmd, args := db.dialect.SearchClusters(terms)
+	names, err := t.getStrings(cmd, args...)
+	if err != nil {
+		return types.ClusterInfoList{}, err
+	}
+	if len(names) == 0 {
+		return types.ClusterInfoList{Clusters: []types.ClusterInfo{}}, nil
+	}
+	sinfos, err := db.getClusters(t, " WHERE clusters.name IN ("+placeholders(len(names))+")", stringArgs(names))
+	if err != nil {
+		return types.ClusterInfoList{}, err
+	}
+	return types.ClusterInfoList{Clusters: agentdb.InOrder(sinfos, names, agentdb.ClusterName)}, nil
+}
+
+// getClusters returns the clusters selected by where, sorted by name
+// where is a WHERE clause on the clusters table, all clusters if empty
+func (db *Store) getClusters(t *txHelper, where string, args []interface{}) ([]types.ClusterInfo, error) {
+	cmd := `SELECT name, uid, created_at, updated_at, domain_name, managed_by, platform_type,
+          owner_email, owner_team, slack_channel, tenant, protected, metadata FROM clusters` + where
+	sinfos, err := t.getClusters(cmd, args...)
+	if err != nil {
+		return nil, err
+	}
+	agents, err := t.getStringLists(`SELECT clusters.name, agents.spiffeid
+          FROM cluster_memberships
+          JOIN clusters ON cluster_memberships.cluster_id=clusters.id
+          JOIN agents ON cluster_memberships.agent_id=agents.id`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	extensions, err := t.getClusterExtensions(`SELECT clusters.name, cluster_extensions.field, cluster_extensions.value
+          FROM cluster_extensions
+          JOIN clusters ON cluster_extensions.cluster_id=clusters.id`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	_, labels, err := t.getObjectLabels(`SELECT clusters.name, cluster_labels.label, cluster_labels.value
+          FROM cluster_labels
+          JOIN clusters ON cluster_labels.cluster_id=clusters.id`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sinfos {
+		name := sinfos[i].Name
+		sinfos[i].AgentsList = agents[name]
+		if sinfos[i].AgentsList == nil {
+			sinfos[i].AgentsList = []string{}
+		}
+		db.collation.Strings(sinfos[i].AgentsList)
+		sinfos[i].Extensions = extensions[name]
+		sinfos[i].Labels = labels[name]
+	}
+	collation.Sort(db.collation, sinfos, func(c types.ClusterInfo) string { return c.Name })
+	return sinfos, nil
+}
+
+func (db *Store) createClusterEntryOp(cinfo types.ClusterInfo) error {
+	// BEGIN transaction
+	txHelper, err := db.begin(context.Background(), "createClusterEntry")
+	if err != nil {
+		return err
+	}
+
+	// INSERT cluster with a new UID
+	err = txHelper.createCluster(cinfo, "")
+	if err != nil {
+		return txHelper.rollbackHandler(err)
+	}
+	return txHelper.commit()
+}
+
+func (db *Store) createClusterEntriesOp(cinfos []types.ClusterInfo) (types.BatchResult, error) {
+	// BEGIN transaction
+	txHelper, err := db.begin(context.Background(), "createClusterEntries")
+	if err != nil {
+		return types.BatchResult{}, err
+	}
+
+	// INSERT each cluster with a new UID
+	result, err := agentdb.CreateClusterBatch(txHelper.clusterBatchTx(), cinfos)
+	if err != nil {
+		return types.BatchResult{}, txHelper.rollbackHandler(err)
+	}
+	return result, txHelper.commit()
+}
+
+func (db *Store) editClusterEntryOp(cinfo types.ClusterInfo) (types.ClusterEditResult, error) {
+	// BEGIN transaction
+	txHelper, err := db.begin(context.Background(), "editClusterEntry")
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	// UPDATE cluster
+	result, err := txHelper.editCluster(cinfo)
+	if err != nil {
+		return types.ClusterEditResult{}, txHelper.rollbackHandler(err)
+	}
+	return result, txHelper.commit()
+}
+
+func (db *Store) createOrUpdateClusterEntryOp(cinfo types.ClusterInfo) (types.ClusterUpsertResult, error) {
+	// BEGIN transaction
+	txHelper, err := db.begin(context.Background(), "createOrUpdateClusterEntry")
+	if err != nil {
+		return types.ClusterUpsertResult{}, err
+	}
+
+	// GET and lock the name of the cluster with the UID
+	name, found, err := txHelper.lockClusterByUID(cinfo.UID)
+	if err != nil {
+		return types.ClusterUpsertResult{}, txHelper.rollbackHandler(err)
+	}
+	if !found {
+		// CHECK the UID is not of a deleted cluster
+		deleted, err := txHelper.isDeletedCluster(cinfo.UID)
+		if err == nil && deleted {
+			err = agentdb.DeletedClusterUIDFailure(cinfo.UID)
+		}
+		if err != nil {
+			return types.ClusterUpsertResult{}, txHelper.rollbackHandler(err)
+		}
+
+		// INSERT cluster with the UID
+		err = txHelper.createCluster(cinfo, cinfo.UID)
+		if err != nil {
+			return types.ClusterUpsertResult{}, txHelper.rollbackHandler(err)
+		}
+		result := types.ClusterUpsertResult{UID: cinfo.UID, Name: cinfo.Name, Created: true, Changes: []types.FieldChange{}}
+		return result, txHelper.commit()
+	}
+
+	// UPDATE cluster, renaming it to cinfo.Name
+	edit := cinfo
+	edit.Name, edit.EditedName = name, cinfo.Name
+	edited, err := txHelper.editCluster(edit)
+	if err != nil {
+		return types.ClusterUpsertResult{}, txHelper.rollbackHandler(err)
+	}
+	result := types.ClusterUpsertResult{UID: cinfo.UID, Name: edited.Name, Changes: edited.Changes}
+	return result, txHelper.commit()
+}
+
+// deleteClusterEntryOp removes the cluster with its agent memberships, labels and extension fields,
+// which are deleted with the cluster metadata
+func (db *Store) deleteClusterEntryOp(clusterName string) error {
+	// BEGIN transaction
+	txHelper, err := db.begin(context.Background(), "deleteClusterEntry")
+	if err != nil {
+		return err
+	}
+
+	// CHECK cluster is not protected
+	err = txHelper.lockDeletableCluster(clusterName)
+	if err != nil {
+		return txHelper.rollbackHandler(err)
+	}
+
+	// REMOVE cluster with its memberships
+	err = txHelper.deleteCluster(clusterName)
+	if err != nil {
+		return txHelper.rollbackHandler(err)
+	}
+	return txHelper.commit()
+}
+
+func (db *Store) deleteClusterEntriesOp(uids []string) (types.BatchResult, error) {
+	// BEGIN transaction
+	txHelper, err := db.begin(context.Background(), "deleteClusterEntries")
+	if err != nil {
+		return types.BatchResult{}, err
+	}
+
+	// REMOVE each cluster not protected
+	result, err := agentdb.DeleteClusterBatch(txHelper.clusterDeleteBatchTx(), uids)
+	if err != nil {
+		return types.BatchResult{}, txHelper.rollbackHandler(err)
+	}
+	return result, txHelper.commit()
+}
+
+// CreateClusterEntry takes in struct cinfo of type ClusterInfo.  If a cluster with cinfo.Name already registered, returns error.
+func (db *Store) CreateClusterEntry(cinfo types.ClusterInfo) error {
+	operation := func() error {
+		return db.createClusterEntryOp(cinfo)
+	}
+	return db.retryOp(operation)
+}
+
+// CreateClusterEntries creates the clusters of cinfos in one transaction,
+// leaving out those that cannot be created
+// returns the outcome of each cluster, skipped if a cluster with its name exists
+func (db *Store) CreateClusterEntries(cinfos []types.ClusterInfo) (types.BatchResult, error) {
+	var result types.BatchResult
+	operation := func() error {
+		var err error
+		result, err = db.createClusterEntriesOp(cinfos)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+// EditClusterEntry takes in struct cinfo of type ClusterInfo.  If cluster with cinfo.Name does not exist, throws error.
+// Returns the fields changed from the stored cluster, read in the same transaction.
+func (db *Store) EditClusterEntry(cinfo types.ClusterInfo) (types.ClusterEditResult, error) {
+	var result types.ClusterEditResult
+	operation := func() error {
+		var err error
+		result, err = db.editClusterEntryOp(cinfo)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+// CreateOrUpdateClusterEntry creates the cluster cinfo with UID cinfo.UID if no
+// cluster has it, and otherwise updates the cluster with the UID as
+// EditClusterEntry would, renaming it to cinfo.Name; cinfo.EditedName is ignored
+// returns PostFailure if the UID is invalid or of a deleted cluster
+func (db *Store) CreateOrUpdateClusterEntry(cinfo types.ClusterInfo) (types.ClusterUpsertResult, error) {
+	if err := agentdb.ValidateClusterUID(cinfo.UID); err != nil {
+		return types.ClusterUpsertResult{}, err
+	}
+	var result types.ClusterUpsertResult
+	operation := func() error {
+		var err error
+		result, err = db.createOrUpdateClusterEntryOp(cinfo)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+// DeleteClusterEntry takes in string name of cluster and removes cluster information and agent membership of cluster from the database.
+func (db *Store) DeleteClusterEntry(clustername string) error {
+	operation := func() error {
+		return db.deleteClusterEntryOp(clustername)
+	}
+	return db.retryOp(operation)
+}
+
+// DeleteClusterEntries deletes the clusters with the given UIDs in one
+// transaction, leaving those that cannot be deleted
+// returns the outcome of each UID, failed if no cluster has it or the cluster
+// is protected
+func (db *Store) DeleteClusterEntries(uids []string) (types.BatchResult, error) {
+	var result types.BatchResult
+	operation := func() error {
+		var err error
+		result, err = db.deleteClusterEntriesOp(uids)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+func (db *Store) setClusterProtectionOp(name string, protected bool) error {
+	// BEGIN transaction
+	txHelper, err := db.begin(context.Background(), "setClusterProtection")
+	if err != nil {
+		return err
+	}
+
+	// UPDATE protection of cluster
+	changed, err := txHelper.updateClusterProtection(name, protected)
+	if err != nil {
+		return txHelper.rollbackHandler(err)
+	}
+	if !changed {
+		return txHelper.commit()
+	}
+
+	// ADD changed cluster to history
+	err = txHelper.recordClusterHistory(name, types.ClusterChangeUpdated)
+	if err != nil {
+		return txHelper.rollbackHandler(err)
+	}
+	return txHelper.commit()
+}
+
+// SetClusterProtection sets whether deletes of the cluster are rejected
+// setting the protection the cluster already has changes nothing
+func (db *Store) SetClusterProtection(name string, protected bool) error {
+	operation := func() error {
+		return db.setClusterProtectionOp(name, protected)
+	}
+	return db.retryOp(operation)
+}
+
+func (db *Store) renameClusterEntryOp(name string, newName string) (types.ClusterEditResult, error) {
+	// BEGIN transaction
+	txHelper, err := db.begin(context.Background(), "renameClusterEntry")
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	// UPDATE cluster name
+	err = txHelper.renameClusterMetadata(name, newName)
+	if err != nil {
+		return types.ClusterEditResult{}, txHelper.rollbackHandler(err)
+	}
+	result := types.ClusterEditResult{Name: newName, Changes: []types.FieldChange{}}
+	if name == newName {
+		return result, txHelper.commit()
+	}
+
+	// ADD renamed cluster to history
+	err = txHelper.recordClusterHistory(newName, types.ClusterChangeUpdated)
+	if err != nil {
+		return types.ClusterEditResult{}, txHelper.rollbackHandler(err)
+	}
+	result.Changes = append(result.Changes, types.FieldChange{Field: "name", Before: name, After: newName})
+	return result, txHelper.commit()
+}
+
+// RenameClusterEntry changes the name of the cluster only, so it keeps its UID,
+// agent memberships, labels, extension fields and protection
+// returns PostFailure if the cluster does not exist or a cluster already has newName
+func (db *Store) RenameClusterEntry(name string, newName string) (types.ClusterEditResult, error) {
+	var result types.ClusterEditResult
+	operation := func() error {
+		var err error
+		result, err = db.renameClusterEntryOp(name, newName)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+func (db *Store) restoreClusterEntryOp(uid string) (types.ClusterRestoreResult, error) {
+	// BEGIN transaction
+	txHelper, err := db.begin(context.Background(), "restoreClusterEntry")
+	if err != nil {
+		return types.ClusterRestoreResult{}, err
+	}
+
+	// GET state of deleted cluster
+	cinfo, err := txHelper.unarchiveCluster(uid)
+	if err != nil {
+		return types.ClusterRestoreResult{}, txHelper.rollbackHandler(err)
+	}
+
+	// INSERT cluster metadata with its UID
+	err = txHelper.restoreClusterMetadata(cinfo)
+	if err != nil {
+		return types.ClusterRestoreResult{}, txHelper.rollbackHandler(err)
+	}
+
+	// ADD agents not assigned to another cluster since
+	assigned := map[string]string{}
+	if len(cinfo.AgentsList) > 0 {
+		cmdAssigned := `SELECT agents.spiffeid, clusters.name
+          FROM agents
+          JOIN cluster_memberships ON agents.id=cluster_memberships.agent_id
+          JOIN clusters ON cluster_memberships.cluster_id=clusters.id
+          WHERE agents.spiffeid IN (` + placeholders(len(cinfo.AgentsList)) + `)`
+		assigned, err = txHelper.getStringPairs(cmdAssigned, stringArgs(cinfo.AgentsList)...)
+		if err != nil {
+			return types.ClusterRestoreResult{}, txHelper.rollbackHandler(err)
+		}
+	}
+	agents, skipped := agentdb.RestorableAgents(cinfo.AgentsList, assigned)
+	err = txHelper.addAgentBatchToCluster(cinfo.Name, agents)
+	if err != nil {
+		return types.ClusterRestoreResult{}, txHelper.rollbackHandler(err)
+	}
+
+	// ADD extension fields and labels of cluster
+	err = txHelper.setClusterExtensions(cinfo.Name, cinfo.Extensions)
+	if err != nil {
+		return types.ClusterRestoreResult{}, txHelper.rollbackHandler(err)
+	}
+	err = txHelper.setClusterLabels(cinfo.Name, cinfo.Labels)
+	if err != nil {
+		return types.ClusterRestoreResult{}, txHelper.rollbackHandler(err)
+	}
+
+	// ADD restore to history
+	err = txHelper.recordClusterHistory(cinfo.Name, types.ClusterChangeRestored)
+	if err != nil {
+		return types.ClusterRestoreResult{}, txHelper.rollbackHandler(err)
+	}
+	result := types.ClusterRestoreResult{Name: cinfo.Name, UID: uid, SkippedAgents: skipped}
+	return result, txHelper.commit()
+}
+
+// RestoreClusterEntry restores the deleted cluster with the given UID, with its
+// agents, labels, extension fields and metadata, under the name it had
+// agents assigned to another cluster since stay there and are reported
+// returns PostFailure if no cluster with the UID is deleted or its name is taken
+func (db *Store) RestoreClusterEntry(uid string) (types.ClusterRestoreResult, error) {
+	var result types.ClusterRestoreResult
+	operation := func() error {
+		var err error
+		result, err = db.restoreClusterEntryOp(uid)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+// ListDeletedClusters outputs the deleted clusters that can be restored,
+// most recently deleted first
+func (db *Store) ListDeletedClusters() (types.DeletedClusterList, error) {
+	cmd := `SELECT snapshot, deleted_at FROM deleted_clusters ORDER BY id DESC`
+	rows, err := db.query(cmd)
+	if err != nil {
+		return types.DeletedClusterList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	deleted := []types.DeletedCluster{}
+	for rows.Next() {
+		var snapshot, deletedAt string
+		if err = rows.Scan(&snapshot, &deletedAt); err != nil {
+			return types.DeletedClusterList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		d, err := agentdb.ParseDeletedCluster(snapshot, deletedAt)
+		if err != nil {
+			return types.DeletedClusterList{}, err
+		}
+		deleted = append(deleted, d)
+	}
+	if err = rows.Err(); err != nil {
+		return types.DeletedClusterList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return types.DeletedClusterList{Clusters: deleted}, nil
+}
+
+// GetClustersAsOf outputs the clusters with their agents as they were at the given
+// RFC 3339 UTC time, reconstructed from the history of clusters
+func (db *Store) GetClustersAsOf(asOf string) (types.ClusterInfoList, error) {
+	cmd := `SELECT ` + db.dialect.HistoryChangeColumn + `, snapshot FROM cluster_history
+          WHERE id IN (SELECT MAX(id) FROM cluster_history WHERE changed_at<=? GROUP BY cluster_uid)`
+	rows, err := db.query(cmd, asOf)
+	if err != nil {
+		return types.ClusterInfoList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	sinfos := []types.ClusterInfo{}
+	for rows.Next() {
+		var change, snapshot string
+		if err = rows.Scan(&change, &snapshot); err != nil {
+			return types.ClusterInfoList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		if change == types.ClusterChangeDeleted {
+			continue
+		}
+		cinfo := types.ClusterInfo{}
+		if err = json.Unmarshal([]byte(snapshot), &cinfo); err != nil {
+			return types.ClusterInfoList{}, errors.Errorf("Invalid cluster history record: %v", err)
+		}
+		if cinfo.AgentsList == nil {
+			cinfo.AgentsList = []string{}
+		}
+		db.collation.Strings(cinfo.AgentsList)
+		sinfos = append(sinfos, cinfo)
+	}
+	collation.Sort(db.collation, sinfos, func(c types.ClusterInfo) string { return c.Name })
+
+	return types.ClusterInfoList{
+		Clusters: sinfos,
+	}, nil
+}
+
+// GetClusterChanges outputs the most recent changes of clusters, most recent first
+func (db *Store) GetClusterChanges(limit int) ([]types.ClusterChange, error) {
+	cmd := `SELECT cluster_uid, name, ` + db.dialect.HistoryChangeColumn + `, changed_at FROM cluster_history
+          WHERE ` + db.dialect.HistoryChangeColumn + `!=? ORDER BY id DESC LIMIT ?`
+	rows, err := db.query(cmd, types.ClusterChangeRecorded, limit)
+	if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	changes := []types.ClusterChange{}
+	for rows.Next() {
+		var change types.ClusterChange
+		if err = rows.Scan(&change.ClusterUID, &change.Name, &change.Change, &change.ChangedAt); err != nil {
+			return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// GetClusterChangeLog returns up to limit entries of the history of clusters
+// following the entry with ID after, oldest first, with the state of the
+// cluster after each change, and the ID of the latest entry
+// limit is capped at MaxChangeLogEntries; with 0 only the latest ID is returned
+func (db *Store) GetClusterChangeLog(after int64, limit int) (types.ClusterChangeLog, error) {
+	ret := types.ClusterChangeLog{Entries: []types.ClusterChangeLogEntry{}}
+	cmdLatest := `SELECT COALESCE(MAX(id), 0) FROM cluster_history`
+	if err := db.queryRow(cmdLatest).Scan(&ret.LatestID); err != nil {
+		return types.ClusterChangeLog{}, agentdb.SQLError{Cmd: cmdLatest, Err: err}
+	}
+	limit = min(limit, agentdb.MaxChangeLogEntries)
+	if limit <= 0 {
+		return ret, nil
+	}
+
+	cmd := `SELECT id, cluster_uid, name, ` + db.dialect.HistoryChangeColumn + `, changed_at, snapshot FROM cluster_history
+          WHERE id>? ORDER BY id LIMIT ?`
+	rows, err := db.query(cmd, after, limit)
+	if err != nil {
+		return types.ClusterChangeLog{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry types.ClusterChangeLogEntry
+		var snapshot sql.NullString
+		if err = rows.Scan(&entry.ID, &entry.ClusterUID, &entry.Name, &entry.Change, &entry.ChangedAt, &snapshot); err != nil {
+			return types.ClusterChangeLog{}, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		if entry.Cluster, err = agentdb.ChangeLogSnapshot(entry.Change, snapshot.String); err != nil {
+			return types.ClusterChangeLog{}, err
+		}
+		ret.Entries = append(ret.Entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return types.ClusterChangeLog{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return ret, nil
+}
+
+// GetClusterNameByUID returns the current name of the cluster with the given UID
+// returns GetError if there is none
+func (db *Store) GetClusterNameByUID(uid string) (string, error) {
+	cmd := `SELECT name FROM clusters WHERE uid=?`
+	var name string
+	err := db.queryRow(cmd, uid).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", agentdb.GetError{Message: fmt.Sprintf("Cluster with UID %v does not exist", uid)}
+	} else if err != nil {
+		return "", agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return name, nil
+}
+
+// LABEL HANDLERS
+
+func (db *Store) applyLabelOperationOp(op types.LabelOperation) (types.LabelOperationResult, error) {
+	// BEGIN transaction
+	txHelper, err := db.begin(context.Background(), "applyLabelOperation")
+	if err != nil {
+		return types.LabelOperationResult{}, err
+	}
+
+	// SELECT and lock all objects of the target with their labels
+	cmd := `SELECT clusters.name, cluster_labels.label, cluster_labels.value
+          FROM clusters
+          LEFT JOIN cluster_labels ON cluster_labels.cluster_id=clusters.id ` + db.dialect.ForUpdateOf("clusters")
+	setLabels := txHelper.setClusterLabels
+	if op.Target == types.LabelTargetAgents {
+		cmd = `SELECT agents.spiffeid, agent_labels.label, agent_labels.value
+          FROM agents
+          LEFT JOIN agent_labels ON agent_labels.agent_id=agents.id ` + db.dialect.ForUpdateOf("agents")
+		setLabels = txHelper.setAgentLabels
+	}
+	names, labels, err := txHelper.getObjectLabels(cmd)
+	if err != nil {
+		return types.LabelOperationResult{}, txHelper.rollbackHandler(err)
+	}
+	db.collation.Strings(names)
+
+	// UPDATE labels of matching objects
+	result := types.LabelOperationResult{DryRun: op.DryRun, Changes: []types.LabelChange{}}
+	for _, name := range names {
+		if !op.Filter.Matches(name, labels[name]) {
+			continue
+		}
+		result.Matched++
+		after, changed := op.Apply(labels[name])
+		if !changed {
+			continue
+		}
+		result.Changes = append(result.Changes, types.LabelChange{Name: name, Before: labels[name], After: after})
+		if op.DryRun {
+			continue
+		}
+		err = setLabels(name, after)
+		if err != nil {
+			return types.LabelOperationResult{}, txHelper.rollbackHandler(err)
+		}
+		if op.Target == types.LabelTargetClusters {
+			err = txHelper.recordClusterHistory(name, types.ClusterChangeUpdated)
+			if err != nil {
+				return types.LabelOperationResult{}, txHelper.rollbackHandler(err)
+			}
+		}
+	}
+
+	if op.DryRun {
+		return result, txHelper.tx.Rollback()
+	}
+	return result, txHelper.commit()
+}
+
+// ApplyLabelOperation adds, removes or renames a label on all clusters or
+// agents matching the filter of op in one transaction
+// with op.DryRun set, the changes are returned without being applied
+func (db *Store) ApplyLabelOperation(op types.LabelOperation) (types.LabelOperationResult, error) {
+	var result types.LabelOperationResult
+	operation := func() error {
+		var err error
+		result, err = db.applyLabelOperationOp(op)
+		return err
+	}
+	err := db.retryOp(operation)
+	return result, err
+}
+
+// CLUSTER TRANSACTION HELPERS
+
+// name of the unique index of the case-insensitive cluster names, created
+// by the DataStores with agentdb.ClusterNameCaseInsensitive
+const clusterNameNocaseIndex = "clusters_name_nocase"
+
+// clusterExistsFailure returns the PostFailure of a unique violation on the name of a cluster
+func clusterExistsFailure(dialect *Dialect, err error, hint string) agentdb.PostFailure {
+	if dialect.UniqueViolation(err, clusterNameNocaseIndex) {
+		return agentdb.ClusterExistsFailure("Cluster already exists (cluster names are case-insensitive)" + hint)
+	}
+	return agentdb.ClusterExistsFailure("Cluster already exists" + hint)
+}
+
+// createCluster inserts the cluster with its agents, extension fields and
+// labels, and records it in the history
+// the cluster gets UID uid, or a new UID if empty
+func (t *txHelper) createCluster(cinfo types.ClusterInfo, uid string) error {
+	// INSERT cluster metadata
+	err := t.insertClusterMetadata(cinfo, uid)
+	if err != nil {
+		return err
+	}
+	return t.addClusterContents(cinfo)
+}
+
+// addClusterContents adds the agents, extension fields and labels of the
+// cluster cinfo.Name just inserted, and records it in the history
+func (t *txHelper) addClusterContents(cinfo types.ClusterInfo) error {
+	// ADD agents to cluster
+	err := t.addAgentBatchToCluster(cinfo.Name, cinfo.AgentsList)
+	if err != nil {
+		return err
+	}
+
+	// ADD extension fields of cluster
+	err = t.setClusterExtensions(cinfo.Name, cinfo.Extensions)
+	if err != nil {
+		return err
+	}
+
+	// ADD labels of cluster
+	err = t.setClusterLabels(cinfo.Name, cinfo.Labels)
+	if err != nil {
+		return err
+	}
+
+	// ADD cluster to history
+	return t.recordClusterHistory(cinfo.Name, types.ClusterChangeCreated)
+}
+
+// savepoint runs fn in a savepoint of the transaction, so a failure of fn
+// rolls back the changes of fn only
+// returns the error of fn, or SQLError if the savepoint fails
+func (t *txHelper) savepoint(fn func() error) error {
+	cmd := `SAVEPOINT tornjak_item`
+	if _, err := t.exec(cmd); err != nil {
+		return agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	fnErr := fn()
+	cmds := []string{`RELEASE SAVEPOINT tornjak_item`}
+	if fnErr != nil {
+		cmds = []string{`ROLLBACK TO SAVEPOINT tornjak_item`, `RELEASE SAVEPOINT tornjak_item`}
+	}
+	for _, cmd := range cmds {
+		if _, err := t.exec(cmd); err != nil {
+			return agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+	}
+	return fnErr
+}
+
+// clusterBatchTx returns the transaction of a bulk creation of clusters
+func (t *txHelper) clusterBatchTx() agentdb.ClusterBatchTx {
+	return agentdb.ClusterBatchTx{
+		Savepoint: t.savepoint,
+		InsertCluster: func(cinfo types.ClusterInfo) error {
+			return t.insertClusterMetadata(cinfo, "")
+		},
+		AddClusterContents: t.addClusterContents,
+	}
+}
+
+// clusterDeleteBatchTx returns the transaction of a bulk deletion of clusters
+func (t *txHelper) clusterDeleteBatchTx() agentdb.ClusterDeleteBatchTx {
+	return agentdb.ClusterDeleteBatchTx{
+		Savepoint:            t.savepoint,
+		LockCluster:          t.lockClusterByUID,
+		LockDeletableCluster: t.lockDeletableCluster,
+		DeleteCluster:        t.deleteCluster,
+	}
+}
+
+// moveAgentsTx returns the transaction of a move of agents between clusters
+func (t *txHelper) moveAgentsTx() agentdb.MoveAgentsTx {
+	return agentdb.MoveAgentsTx{
+		LockCluster:          t.lockClusterByUID,
+		ClusterAgents:        t.getClusterAgents,
+		MoveMemberships:      t.moveAgentMemberships,
+		RecordClusterHistory: t.recordClusterHistory,
+	}
+}
+
+// editCluster replaces the cluster cinfo.Name by cinfo, renamed to
+// cinfo.EditedName, and records it in the history
+// returns the fields changed from the stored cluster
+func (t *txHelper) editCluster(cinfo types.ClusterInfo) (types.ClusterEditResult, error) {
+	// GET and lock current cluster
+	before, err := t.getClusterForUpdate(cinfo.Name)
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	// UPDATE cluster metadata
+	err = t.updateClusterMetadata(cinfo)
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	// REMOVE all currently assigned cluster agents
+	err = t.deleteClusterAgents(cinfo.EditedName)
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	// ADD agents to cluster
+	err = t.addAgentBatchToCluster(cinfo.EditedName, cinfo.AgentsList)
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	// REPLACE extension fields of cluster
+	err = t.setClusterExtensions(cinfo.EditedName, cinfo.Extensions)
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	// REPLACE labels of cluster
+	err = t.setClusterLabels(cinfo.EditedName, cinfo.Labels)
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	// ADD edited cluster to history
+	err = t.recordClusterHistory(cinfo.EditedName, types.ClusterChangeUpdated)
+	if err != nil {
+		return types.ClusterEditResult{}, err
+	}
+
+	after := cinfo
+	after.Name = cinfo.EditedName
+	return types.ClusterEditResult{Name: cinfo.EditedName, Changes: types.DiffClusters(before, after)}, nil
+}
+
+// lockClusterByUID returns the name of the cluster with the given UID and
+// locks it until the end of the transaction
+// returns SQLError on failure
+func (t *txHelper) lockClusterByUID(uid string) (string, bool, error) {
+	var name string
+	cmd := `SELECT name FROM clusters WHERE uid=? FOR UPDATE`
+	err := t.queryRow(cmd, uid).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return name, true, nil
+}
+
+// isDeletedCluster returns whether a deleted cluster with the given UID can be restored
+// returns SQLError on failure
+func (t *txHelper) isDeletedCluster(uid string) (bool, error) {
+	var count int
+	cmd := `SELECT COUNT(*) FROM deleted_clusters WHERE uid=?`
+	if err := t.queryRow(cmd, uid).Scan(&count); err != nil {
+		return false, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return count > 0, nil
+}
+
+// insertClusterMetadata attempts insert into table clusters with UID uid, or a new UID if empty
+// returns SQLError upon failure and PostFailure on cluster existence
+func (t *txHelper) insertClusterMetadata(cinfo types.ClusterInfo, uid string) error {
+	if uid == "" {
+		var err error
+		if uid, err = newClusterUID(); err != nil {
+			return err
+		}
+	}
+	metadata, err := agentdb.MetadataValue(cinfo.Metadata)
+	if err != nil {
+		return err
+	}
+	cmdInsert := `INSERT INTO clusters (name, created_at, updated_at, domain_name, managed_by, platform_type,
+                owner_email, owner_team, slack_channel, tenant, uid, protected, metadata) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)`
+	_, err = t.exec(cmdInsert, cinfo.Name, t.now(), t.now(), cinfo.DomainName,
+		cinfo.ManagedBy, cinfo.PlatformType, cinfo.OwnerEmail, cinfo.OwnerTeam, cinfo.SlackChannel, cinfo.Tenant, uid, cinfo.Protected, metadata)
+	if err != nil {
+		if t.dialect.UniqueViolation(err, "") {
+			return clusterExistsFailure(t.dialect, err, "; use Edit Cluster")
+		}
+		return agentdb.SQLError{Cmd: cmdInsert, Err: err}
+	}
+	return nil
+}
+
+// updateClusterMetadata attempts update of entry in table clusters
+// returns SQLError on failure and PostFailure on cluster non-existence
+// RowsAffected counts the matched rows, see Dialect
+func (t *txHelper) updateClusterMetadata(cinfo types.ClusterInfo) error {
+	metadata, err := agentdb.MetadataValue(cinfo.Metadata)
+	if err != nil {
+		return err
+	}
+	cmdUpdate := `UPDATE clusters SET name=?, domain_name=?, managed_by=?, platform_type=?,
+                owner_email=?, owner_team=?, slack_channel=?, tenant=?, metadata=?, updated_at=? WHERE name=?`
+	res, err := t.exec(cmdUpdate, cinfo.EditedName, cinfo.DomainName, cinfo.ManagedBy, cinfo.PlatformType,
+		cinfo.OwnerEmail, cinfo.OwnerTeam, cinfo.SlackChannel, cinfo.Tenant, metadata, t.now(), cinfo.Name)
+	if err != nil {
+		if t.dialect.UniqueViolation(err, "") {
+			return clusterExistsFailure(t.dialect, err, "")
+		}
+		return agentdb.SQLError{Cmd: cmdUpdate, Err: err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return agentdb.SQLError{Cmd: cmdUpdate, Err: err}
+	}
+	if numRows != 1 {
+		return agentdb.ClusterMissingFailure("Cluster does not exist; use Create Cluster")
+	}
+	return nil
+}
+
+// renameClusterMetadata changes the name of the cluster in table clusters,
+// leaving the rows referencing it by ID, such as its agent memberships, unchanged
+// returns SQLError on failure and PostFailure on cluster non-existence or if newName is taken
+func (t *txHelper) renameClusterMetadata(name string, newName string) error {
+	cmdUpdate := `UPDATE clusters SET name=?, updated_at=? WHERE name=?`
+	res, err := t.exec(cmdUpdate, newName, t.now(), name)
+	if err != nil {
+		if t.dialect.UniqueViolation(err, "") {
+			return clusterExistsFailure(t.dialect, err, "")
+		}
+		return agentdb.SQLError{Cmd: cmdUpdate, Err: err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return agentdb.SQLError{Cmd: cmdUpdate, Err: err}
+	}
+	if numRows != 1 {
+		return agentdb.ClusterMissingFailure("Cluster does not exist")
+	}
+	return nil
+}
+
+// getClusterForUpdate returns the stored cluster with its agents, labels and extensions
+// and locks it until the end of the transaction
+// returns SQLError on failure and PostFailure on cluster non-existence
+func (t *txHelper) getClusterForUpdate(name string) (types.ClusterInfo, error) {
+	cmd := `SELECT name, uid, created_at, updated_at, domain_name, managed_by, platform_type,
+          owner_email, owner_team, slack_channel, tenant, protected, metadata FROM clusters WHERE name=? FOR UPDATE`
+	clusters, err := t.getClusters(cmd, name)
+	if err != nil {
+		return types.ClusterInfo{}, err
+	}
+	if len(clusters) != 1 {
+		return types.ClusterInfo{}, agentdb.ClusterMissingFailure("Cluster does not exist; use Create Cluster")
+	}
+	cinfo := clusters[0]
+
+	agents, err := t.getStringLists(`SELECT clusters.name, agents.spiffeid
+          FROM cluster_memberships
+          JOIN clusters ON cluster_memberships.cluster_id=clusters.id
+          JOIN agents ON cluster_memberships.agent_id=agents.id
+          WHERE clusters.name=?`, name)
+	if err != nil {
+		return types.ClusterInfo{}, err
+	}
+	cinfo.AgentsList = agents[name]
+	if cinfo.AgentsList == nil {
+		cinfo.AgentsList = []string{}
+	}
+
+	extensions, err := t.getClusterExtensions(`SELECT clusters.name, cluster_extensions.field, cluster_extensions.value
+          FROM cluster_extensions
+          JOIN clusters ON cluster_extensions.cluster_id=clusters.id
+          WHERE clusters.name=?`, name)
+	if err != nil {
+		return types.ClusterInfo{}, err
+	}
+	cinfo.Extensions = extensions[name]
+
+	_, labels, err := t.getObjectLabels(`SELECT clusters.name, cluster_labels.label, cluster_labels.value
+          FROM cluster_labels
+          JOIN clusters ON cluster_labels.cluster_id=clusters.id
+          WHERE clusters.name=?`, name)
+	if err != nil {
+		return types.ClusterInfo{}, err
+	}
+	cinfo.Labels = labels[name]
+	return cinfo, nil
+}
+
+// lockDeletableCluster locks the cluster until the end of the transaction
+// returns SQLError on failure and PostFailure on cluster non-existence or protection
+func (t *txHelper) lockDeletableCluster(name string) error {
+	cmd := `SELECT protected FROM clusters WHERE name=? FOR UPDATE`
+	var protected bool
+	err := t.queryRow(cmd, name).Scan(&protected)
+	if err == sql.ErrNoRows {
+		return agentdb.ClusterMissingFailure("Cluster does not exist")
+	} else if err != nil {
+		return agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	if protected {
+		return agentdb.ProtectedClusterFailure(name)
+	}
+	return nil
+}
+
+// updateClusterProtection sets the protection of the cluster and returns
+// whether it changed
+// returns SQLError on failure and PostFailure on cluster non-existence
+func (t *txHelper) updateClusterProtection(name string, protected bool) (bool, error) {
+	cmdUpdate := `UPDATE clusters SET protected=?, updated_at=? WHERE name=? AND protected<>?`
+	res, err := t.exec(cmdUpdate, protected, t.now(), name, protected)
+	if err != nil {
+		return false, agentdb.SQLError{Cmd: cmdUpdate, Err: err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return false, agentdb.SQLError{Cmd: cmdUpdate, Err: err}
+	}
+	if numRows == 1 {
+		return true, nil
+	}
+	var exists bool
+	cmd := `SELECT EXISTS (SELECT 1 FROM clusters WHERE name=?)`
+	if err = t.queryRow(cmd, name).Scan(&exists); err != nil {
+		return false, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	if !exists {
+		return false, agentdb.ClusterMissingFailure("Cluster does not exist")
+	}
+	return false, nil
+}
+
+// deleteClusterMetadata attemps delete of entry in table clusters, with its memberships,
+// labels and extension fields
+// returns SQLError on failure and PostFailure on cluster non-existence
+func (t *txHelper) deleteClusterMetadata(name string) error {
+	cmdDelete := `DELETE FROM clusters WHERE name=?`
+	res, err := t.exec(cmdDelete, name)
+	if err != nil {
+		return agentdb.SQLError{Cmd: cmdDelete, Err: err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return agentdb.SQLError{Cmd: cmdDelete, Err: err}
+	}
+	if numRows != 1 {
+		return agentdb.ClusterMissingFailure("Cluster does not exist")
+	}
+	return nil
+}
+
+// deleteCluster deletes the cluster, keeping its state for restores, and
+// records the deletion in the history
+// returns SQLError on failure and PostFailure on cluster non-existence
+func (t *txHelper) deleteCluster(name string) error {
+	// KEEP state of cluster for restores (requires metadata still entered)
+	err := t.archiveCluster(name)
+	if err != nil {
+		return err
+	}
+
+	// ADD deletion to history (requires metadata still entered)
+	err = t.recordClusterHistory(name, types.ClusterChangeDeleted)
+	if err != nil {
+		return err
+	}
+
+	// REMOVE cluster metadata, with its memberships, labels and extension fields
+	return t.deleteClusterMetadata(name)
+}
+
+// archiveCluster keeps the state of the cluster in deleted_clusters, before it is deleted
+// returns SQLError on failure and PostFailure on cluster non-existence
+func (t *txHelper) archiveCluster(name string) error {
+	cinfo, err := t.getClusterForUpdate(name)
+	if err != nil {
+		return err
+	}
+	snapshot, err := agentdb.DeletedClusterSnapshot(cinfo)
+	if err != nil {
+		return err
+	}
+	cmdInsert := `INSERT INTO deleted_clusters (uid, name, snapshot, deleted_at) VALUES (?,?,?,?)
+          ` + t.dialect.Upsert("uid", "name", "snapshot", "deleted_at")
+	if _, err = t.exec(cmdInsert, cinfo.UID, name, snapshot, t.now()); err != nil {
+		return agentdb.SQLError{Cmd: cmdInsert, Err: err}
+	}
+	return nil
+}
+
+// unarchiveCluster returns the state of the deleted cluster with the given UID
+// and removes it from deleted_clusters
+// returns SQLError on failure and PostFailure if no cluster with the UID is deleted
+func (t *txHelper) unarchiveCluster(uid string) (types.ClusterInfo, error) {
+	var snapshot, deletedAt string
+	cmd := `SELECT snapshot, deleted_at FROM deleted_clusters WHERE uid=? FOR UPDATE`
+	err := t.queryRow(cmd, uid).Scan(&snapshot, &deletedAt)
+	if err == sql.ErrNoRows {
+		return types.ClusterInfo{}, agentdb.DeletedClusterNotFound(uid)
+	} else if err != nil {
+		return types.ClusterInfo{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	deleted, err := agentdb.ParseDeletedCluster(snapshot, deletedAt)
+	if err != nil {
+		return types.ClusterInfo{}, err
+	}
+	cmdDelete := `DELETE FROM deleted_clusters WHERE uid=?`
+	if _, err = t.exec(cmdDelete, uid); err != nil {
+		return types.ClusterInfo{}, agentdb.SQLError{Cmd: cmdDelete, Err: err}
+	}
+	return deleted.Cluster, nil
+}
+
+// restoreClusterMetadata inserts a deleted cluster into table clusters with
+// its UID and creation time
+// returns SQLError upon failure and PostFailure on cluster existence
+func (t *txHelper) restoreClusterMetadata(cinfo types.ClusterInfo) error {
+	metadata, err := agentdb.MetadataValue(cinfo.Metadata)
+	if err != nil {
+		return err
+	}
+	cmdInsert := `INSERT INTO clusters (name, created_at, updated_at, domain_name, managed_by, platform_type,
+                owner_email, owner_team, slack_channel, tenant, uid, protected, metadata) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)`
+	_, err = t.exec(cmdInsert, cinfo.Name, agentdb.FormatTimestamp(cinfo.CreationTime), t.now(),
+		cinfo.DomainName, cinfo.ManagedBy, cinfo.PlatformType, cinfo.OwnerEmail, cinfo.OwnerTeam, cinfo.SlackChannel,
+		cinfo.Tenant, cinfo.UID, cinfo.Protected, metadata)
+	if err != nil {
+		if t.dialect.UniqueViolation(err, "") {
+			return clusterExistsFailure(t.dialect, err, "; rename it to restore the deleted cluster")
+		}
+		return agentdb.SQLError{Cmd: cmdInsert, Err: err}
+	}
+	return nil
+}
+
+// recordClusterHistory adds the state of the cluster as of the transaction to cluster_history
+// a deletion is recorded without state, before the cluster metadata is removed
+// returns SQLError on failure and PostFailure on cluster non-existence
+func (t *txHelper) recordClusterHistory(name string, change string) error {
+	cinfo, err := t.getClusterForUpdate(name)
+	if err != nil {
+		if _, ok := err.(agentdb.PostFailure); ok {
+			return agentdb.ClusterMissingFailure("Cluster does not exist")
+		}
+		return err
+	}
+
+	snapshot := ""
+	if change != types.ClusterChangeDeleted {
+		data, err := json.Marshal(cinfo)
+		if err != nil {
+			return errors.Errorf("Invalid state of cluster %s: %v", name, err)
+		}
+		snapshot = string(data)
+	}
+
+	// ADD change to audit log, from the state of the last change
+	var previousChange, previous sql.NullString
+	cmdPrevious := `SELECT ` + t.dialect.HistoryChangeColumn + `, snapshot FROM cluster_history WHERE cluster_uid=? ORDER BY id DESC LIMIT 1`
+	err = t.queryRow(cmdPrevious, cinfo.UID).Scan(&previousChange, &previous)
+	if err != nil && err != sql.ErrNoRows {
+		return agentdb.SQLError{Cmd: cmdPrevious, Err: err}
+	}
+	before := previous.String
+	if previousChange.String == types.ClusterChangeDeleted {
+		before = ""
+	}
+	events, err := agentdb.ClusterAuditEvents(change, cinfo.UID, before, snapshot)
+	if err != nil {
+		return err
+	}
+	if err = t.recordAuditEvents(events); err != nil {
+		return err
+	}
+
+	cmdInsert := `INSERT INTO cluster_history (cluster_uid, name, ` + t.dialect.HistoryChangeColumn + `, snapshot, changed_at) VALUES (?,?,?,?,?)`
+	_, err = t.exec(cmdInsert, cinfo.UID, name, change, snapshot, t.clock.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return agentdb.SQLError{Cmd: cmdInsert, Err: err}
+	}
+	return nil
+}
+
+// now returns the time of the changes made in the transaction, as stored
+func (t *txHelper) now() string {
+	return agentdb.FormatTimestamp(t.clock.Now())
+}
+
+// touchClusters sets the time of the last change of the clusters of the given names to now
+// returns SQLError on failure
+func (t *txHelper) touchClusters(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	cmd := `UPDATE clusters SET updated_at=? WHERE name IN (` + placeholders(len(names)) + `)`
+	if _, err := t.exec(cmd, append([]interface{}{t.now()}, stringArgs(names)...)...); err != nil {
+		return agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return nil
+}
+
+// touchAgents sets the time of the last change of the agents of the given spiffeids to now
+// returns SQLError on failure
+func (t *txHelper) touchAgents(spiffeids []string) error {
+	if len(spiffeids) == 0 {
+		return nil
+	}
+	cmd := `UPDATE agents SET updated_at=? WHERE spiffeid IN (` + placeholders(len(spiffeids)) + `)`
+	if _, err := t.exec(cmd, append([]interface{}{t.now()}, stringArgs(spiffeids)...)...); err != nil {
+		return agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return nil
+}
+
+// addAgentBatchToCluster adds the agents to the cluster in cluster_memberships
+// the agents and the cluster are marked changed
+// returns SQLError on failure and PostFailure on conflict (an agent is already assigned,
+// listed more than once or assigned concurrently), naming the conflicting agents
+func (t *txHelper) addAgentBatchToCluster(clustername string, agentsList []string) error {
+	if len(agentsList) == 0 {
+		return nil
+	}
+	// CHECK agents are not assigned yet
+	listed := make(map[string]bool, len(agentsList))
+	conflicts := []string{}
+	for _, spiffeid := range agentsList {
+		if listed[spiffeid] {
+			conflicts = append(conflicts, spiffeid+" (listed more than once)")
+		}
+		listed[spiffeid] = true
+	}
+	cmdAssigned := `SELECT agents.spiffeid, clusters.name
+          FROM agents
+          JOIN cluster_memberships ON agents.id=cluster_memberships.agent_id
+          JOIN clusters ON cluster_memberships.cluster_id=clusters.id
+          WHERE agents.spiffeid IN (` + placeholders(len(agentsList)) + `)`
+	assigned, err := t.getStringPairs(cmdAssigned, stringArgs(agentsList)...)
+	if err != nil {
+		return err
+	}
+	for _, spiffeid := range agentsList {
+		if clusterName, ok := assigned[spiffeid]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("%s (assigned to cluster %s)", spiffeid, clusterName))
+			delete(assigned, spiffeid)
+		}
+	}
+	if len(conflicts) > 0 {
+		return agentdb.AgentsAssignedFailure(conflicts)
+	}
+
+	// ADD agents and memberships
+	cmdAgents := `INSERT INTO agents (spiffeid, created_at, updated_at) VALUES ` +
+		strings.TrimSuffix(strings.Repeat("(?,?,?),", len(agentsList)), ",") + ` ` + t.dialect.Upsert("spiffeid", "updated_at")
+	now := t.now()
+	agentVals := make([]interface{}, 0, 3*len(agentsList))
+	for _, spiffeid := range agentsList {
+		agentVals = append(agentVals, spiffeid, now, now)
+	}
+	if _, err = t.exec(cmdAgents, agentVals...); err != nil {
+		return agentdb.SQLError{Cmd: cmdAgents, Err: err}
+	}
+	cmdMemberships := `INSERT INTO cluster_memberships (agent_id, cluster_id)
+          SELECT agents.id, (SELECT id FROM clusters WHERE name=?) FROM agents
+          WHERE agents.spiffeid IN (` + placeholders(len(agentsList)) + `)`
+	if _, err = t.exec(cmdMemberships, append([]interface{}{clustername}, stringArgs(agentsList)...)...); err != nil {
+		if t.dialect.UniqueViolation(err, "") {
+			// another replica assigned one of the agents since the check
+			return agentdb.AgentsAssignedFailure(agentsList)
+		}
+		return agentdb.SQLError{Cmd: cmdMemberships, Err: err}
+	}
+	return t.touchClusters([]string{clustername})
+}
+
+// getClusterAgents returns the agents of agentsList that are members of the cluster
+// returns SQLError on failure
+func (t *txHelper) getClusterAgents(clustername string, agentsList []string) ([]string, error) {
+	if len(agentsList) == 0 {
+		return []string{}, nil
+	}
+	cmd := `SELECT agents.spiffeid FROM cluster_memberships
+          JOIN agents ON cluster_memberships.agent_id=agents.id
+          WHERE cluster_memberships.cluster_id=(SELECT id FROM clusters WHERE name=?)
+          AND agents.spiffeid IN (` + placeholders(len(agentsList)) + `)`
+	return t.getStrings(cmd, append([]interface{}{clustername}, stringArgs(agentsList)...)...)
+}
+
+// moveAgentMemberships re-points the memberships of the agents of agentsList in
+// cluster from to cluster to, leaving the memberships of other clusters unchanged
+// the agents and both clusters are marked changed
+// returns SQLError on failure
+func (t *txHelper) moveAgentMemberships(from string, to string, agentsList []string) error {
+	if len(agentsList) == 0 {
+		return nil
+	}
+	cmd := `UPDATE cluster_memberships SET cluster_id=(SELECT id FROM clusters WHERE name=?)
+          WHERE cluster_id=(SELECT id FROM clusters WHERE name=?)
+          AND agent_id IN (SELECT id FROM agents WHERE spiffeid IN (` + placeholders(len(agentsList)) + `))`
+	if _, err := t.exec(cmd, append([]interface{}{to, from}, stringArgs(agentsList)...)...); err != nil {
+		return agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	if err := t.touchAgents(agentsList); err != nil {
+		return err
+	}
+	return t.touchClusters([]string{from, to})
+}
+
+// deleteClusterAgents removes all agents of the cluster from cluster_memberships
+// the cluster and its agents are marked changed
+// returns SQLError on failure
+func (t *txHelper) deleteClusterAgents(clustername string) error {
+	cmdTouch := `UPDATE agents SET updated_at=? WHERE id IN (SELECT agent_id FROM cluster_memberships
+          WHERE cluster_id=(SELECT id FROM clusters WHERE name=?))`
+	if _, err := t.exec(cmdTouch, t.now(), clustername); err != nil {
+		return agentdb.SQLError{Cmd: cmdTouch, Err: err}
+	}
+	if err := t.touchClusters([]string{clustername}); err != nil {
+		return err
+	}
+	cmdDelete := `DELETE FROM cluster_memberships WHERE cluster_id=(SELECT id FROM clusters WHERE name=?)`
+	if _, err := t.exec(cmdDelete, clustername); err != nil {
+		return agentdb.SQLError{Cmd: cmdDelete, Err: err}
+	}
+	return nil
+}
+
+// setClusterExtensions replaces the extension fields of a cluster in cluster_extensions table
+// and marks the cluster changed
+// values are stored JSON-encoded; returns SQLError on failure
+func (t *txHelper) setClusterExtensions(clustername string, extensions map[string]interface{}) error {
+	if err := t.touchClusters([]string{clustername}); err != nil {
+		return err
+	}
+	cmdDelete := `DELETE FROM cluster_extensions WHERE cluster_id=(SELECT id FROM clusters WHERE name=?)`
+	if _, err := t.exec(cmdDelete, clustername); err != nil {
+		return agentdb.SQLError{Cmd: cmdDelete, Err: err}
+	}
+	cmdInsert := `INSERT INTO cluster_extensions (cluster_id, field, value)
+          VALUES ((SELECT id FROM clusters WHERE name=?), ?, ?)`
+	for field, value := range extensions {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return errors.Errorf("Invalid value of extension field %s: %v", field, err)
+		}
+		if _, err = t.exec(cmdInsert, clustername, field, string(encoded)); err != nil {
+			return agentdb.SQLError{Cmd: cmdInsert, Err: err}
+		}
+	}
+	return nil
+}
+
+// setLabels replaces the labels in table of the object whose id idQuery selects by name
+// returns SQLError on failure
+func (t *txHelper) setLabels(table, idColumn, idQuery, name string, labels map[string]string) error {
+	cmdDelete := fmt.Sprintf(`DELETE FROM %s WHERE %s=(%s)`, table, idColumn, idQuery)
+	if _, err := t.exec(cmdDelete, name); err != nil {
+		return agentdb.SQLError{Cmd: cmdDelete, Err: err}
+	}
+	cmdInsert := fmt.Sprintf(`INSERT INTO %s (%s, label, value) VALUES ((%s), ?, ?)`, table, idColumn, idQuery)
+	for label, value := range labels {
+		if _, err := t.exec(cmdInsert, name, label, value); err != nil {
+			return agentdb.SQLError{Cmd: cmdInsert, Err: err}
+		}
+	}
+	return nil
+}
+
+// setClusterLabels replaces the labels of a cluster in cluster_labels table and marks the cluster changed
+func (t *txHelper) setClusterLabels(clustername string, labels map[string]string) error {
+	if err := t.touchClusters([]string{clustername}); err != nil {
+		return err
+	}
+	return t.setLabels("cluster_labels", "cluster_id", "SELECT id FROM clusters WHERE name=?", clustername, labels)
+}
+
+// setAgentLabels replaces the labels of an agent in agent_labels table and marks the agent changed
+func (t *txHelper) setAgentLabels(spiffeid string, labels map[string]string) error {
+	before, err := t.getAuditAgent(spiffeid)
+	if err != nil {
+		return err
+	}
+	if err = t.touchAgents([]string{spiffeid}); err != nil {
+		return err
+	}
+	err = t.setLabels("agent_labels", "agent_id", "SELECT id FROM agents WHERE spiffeid=?", spiffeid, labels)
+	if err != nil {
+		return err
+	}
+	return t.recordAgentChange(spiffeid, before)
+}
+
+// getClusters returns the clusters selected by cmd without agents, labels and extensions
+// cmd selects the columns name, uid, created_at, updated_at, domain_name, managed_by, platform_type,
+// owner_email, owner_team, slack_channel, tenant, protected and metadata
+func (t *txHelper) getClusters(cmd string, args ...interface{}) ([]types.ClusterInfo, error) {
+	rows, err := t.query(cmd, args...)
+	if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	clusters := []types.ClusterInfo{}
+	for rows.Next() {
+		var cinfo types.ClusterInfo
+		var createdAt, updatedAt, domainName, managedBy, platformType sql.NullString
+		var ownerEmail, ownerTeam, slackChannel, tenant, metadata sql.NullString
+		if err = rows.Scan(&cinfo.Name, &cinfo.UID, &createdAt, &updatedAt, &domainName, &managedBy, &platformType,
+			&ownerEmail, &ownerTeam, &slackChannel, &tenant, &cinfo.Protected, &metadata); err != nil {
+			return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		if cinfo.CreationTime, err = agentdb.ParseTimestamp(createdAt.String); err != nil {
+			return nil, errors.Errorf("Invalid creation time of cluster %s: %v", cinfo.Name, err)
+		}
+		if cinfo.UpdatedAt, err = agentdb.ParseTimestamp(updatedAt.String); err != nil {
+			return nil, errors.Errorf("Invalid change time of cluster %s: %v", cinfo.Name, err)
+		}
+		cinfo.DomainName = domainName.String
+		cinfo.ManagedBy, cinfo.PlatformType = managedBy.String, platformType.String
+		cinfo.OwnerEmail, cinfo.OwnerTeam = ownerEmail.String, ownerTeam.String
+		cinfo.SlackChannel, cinfo.Tenant = slackChannel.String, tenant.String
+		cinfo.Metadata = agentdb.ParseMetadata(metadata)
+		clusters = append(clusters, cinfo)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return clusters, nil
+}
+
+// getStrings returns the first column of the rows of cmd
+// returns SQLError on failure
+func (t *txHelper) getStrings(cmd string, args ...interface{}) ([]string, error) {
+	rows, err := t.query(cmd, args...)
+	if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err = rows.Scan(&value); err != nil {
+			return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		values = append(values, value)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return values, nil
+}
+
+// getStringPairs returns the rows of a query of two text columns as a map from the first to the second
+// returns SQLError on failure
+func (t *txHelper) getStringPairs(cmd string, args ...interface{}) (map[string]string, error) {
+	rows, err := t.query(cmd, args...)
+	if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+	pairs := make(map[string]string)
+	for rows.Next() {
+		var key, value sql.NullString
+		if err = rows.Scan(&key, &value); err != nil {
+			return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		pairs[key.String] = value.String
+	}
+	if err = rows.Err(); err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return pairs, nil
+}
+
+// getStringLists returns the rows of a query of two text columns as a map from the first
+// to the list of values of the second
+// returns SQLError on failure
+func (t *txHelper) getStringLists(cmd string, args ...interface{}) (map[string][]string, error) {
+	rows, err := t.query(cmd, args...)
+	if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+	lists := make(map[string][]string)
+	for rows.Next() {
+		var key, value string
+		if err = rows.Scan(&key, &value); err != nil {
+			return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		lists[key] = append(lists[key], value)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return lists, nil
+}
+
+// getClusterExtensions returns the extension fields selected by cmd by cluster name
+// cmd selects the cluster name, field and JSON-encoded value
+func (t *txHelper) getClusterExtensions(cmd string, args ...interface{}) (map[string]map[string]interface{}, error) {
+	rows, err := t.query(cmd, args...)
+	if err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	extensions := make(map[string]map[string]interface{})
+	for rows.Next() {
+		var name, field, encoded string
+		if err = rows.Scan(&name, &field, &encoded); err != nil {
+			return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		var value interface{}
+		if err = json.Unmarshal([]byte(encoded), &value); err != nil {
+			return nil, errors.Errorf("Invalid value of extension field %s of cluster %s: %v", field, name, err)
+		}
+		if extensions[name] == nil {
+			extensions[name] = make(map[string]interface{})
+		}
+		extensions[name][field] = value
+	}
+	if err = rows.Err(); err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return extensions, nil
+}
+
+// getObjectLabels returns the names of the objects selected by cmd and their labels
+// cmd selects the object name, label and value, with NULL labels for objects without labels
+func (t *txHelper) getObjectLabels(cmd string, args ...interface{}) ([]string, map[string]map[string]string, error) {
+	rows, err := t.query(cmd, args...)
+	if err != nil {
+		return nil, nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	names := []string{}
+	labels := make(map[string]map[string]string)
+	for rows.Next() {
+		var name string
+		var label, value sql.NullString
+		if err = rows.Scan(&name, &label, &value); err != nil {
+			return nil, nil, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		if _, ok := labels[name]; !ok {
+			names = append(names, name)
+			labels[name] = make(map[string]string)
+		}
+		if label.Valid {
+			labels[name][label.String] = value.String
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return names, labels, nil
+}
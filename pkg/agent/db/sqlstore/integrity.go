@@ -0,0 +1,43 @@
+package sqlstore
+
+import (
+	"context"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// INTEGRITY HANDLERS
+
+// CheckIntegrity runs the integrity checks, storing the report in place of the previous one
+// foreign keys are enforced, so findings are only expected in data imported
+// from other DataStores or changed outside Tornjak
+func (db *Store) CheckIntegrity(ctx context.Context) (types.IntegrityReport, error) {
+	var report types.IntegrityReport
+	operation := func() error {
+		t, err := db.begin(ctx, "checkIntegrity")
+		if err != nil {
+			return err
+		}
+		if cmdLock := t.dialect.LockIntegrityReport; cmdLock != "" {
+			if _, err = t.exec(cmdLock); err != nil {
+				return t.rollbackHandler(agentdb.SQLError{Cmd: cmdLock, Err: err})
+			}
+		}
+		report, err = agentdb.CheckIntegrity(t.ctx, t.tx, agentdb.IntegrityReferences, "", t.now())
+		if err != nil {
+			return t.rollbackHandler(err)
+		}
+		if err = agentdb.StoreIntegrityReport(t.ctx, t.tx, t.dialect.Placeholder, report); err != nil {
+			return t.rollbackHandler(err)
+		}
+		return t.commit()
+	}
+	err := db.retryOp(operation)
+	return report, err
+}
+
+// GetIntegrityReport returns the report of the last integrity check, empty if none ran
+func (db *Store) GetIntegrityReport() (types.IntegrityReport, error) {
+	return agentdb.LoadIntegrityReport(context.Background(), db.database)
+}
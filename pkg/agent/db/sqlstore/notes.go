@@ -0,0 +1,144 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// NOTE HANDLERS
+
+// CreateNote stores a note with its first revision, returning its ID
+func (db *Store) CreateNote(note types.Note) (int64, error) {
+	var id int64
+	operation := func() error {
+		t, err := db.begin(context.Background(), "createNote")
+		if err != nil {
+			return err
+		}
+		cmd := `INSERT INTO notes (object_type, object_id, body, author, created_at, updated_by, updated_at)
+          VALUES (?, ?, ?, ?, ?, '', '')`
+		id, err = t.dialect.InsertID(t.ctx, t.tx, bind(t.dialect, cmd), note.ObjectType, note.ObjectId, note.Body, note.Author, note.CreatedAt)
+		if err != nil {
+			return t.rollbackHandler(agentdb.SQLError{Cmd: cmd, Err: err})
+		}
+		if err = t.addNoteRevision(id, note.Body, note.Author, note.CreatedAt); err != nil {
+			return t.rollbackHandler(err)
+		}
+		return t.commit()
+	}
+	err := db.retryOp(operation)
+	return id, err
+}
+
+// EditNote replaces the text of a note, keeping the previous versions in its history
+func (db *Store) EditNote(id int64, body string, editedBy string, editedAt string) error {
+	operation := func() error {
+		t, err := db.begin(context.Background(), "editNote")
+		if err != nil {
+			return err
+		}
+		cmd := `UPDATE notes SET body=?, updated_by=?, updated_at=? WHERE id=?`
+		res, err := t.exec(cmd, body, editedBy, editedAt, id)
+		if err != nil {
+			return t.rollbackHandler(agentdb.SQLError{Cmd: cmd, Err: err})
+		}
+		if numRows, err := res.RowsAffected(); err != nil || numRows != 1 {
+			if err != nil {
+				return t.rollbackHandler(agentdb.SQLError{Cmd: cmd, Err: err})
+			}
+			return t.rollbackHandler(agentdb.PostFailure{Message: fmt.Sprintf("Note %v does not exist", id)})
+		}
+		if err = t.addNoteRevision(id, body, editedBy, editedAt); err != nil {
+			return t.rollbackHandler(err)
+		}
+		return t.commit()
+	}
+	return db.retryOp(operation)
+}
+
+// DeleteNote deletes a note and its history
+func (db *Store) DeleteNote(id int64) error {
+	operation := func() error {
+		t, err := db.begin(context.Background(), "deleteNote")
+		if err != nil {
+			return err
+		}
+		cmd := `DELETE FROM notes WHERE id=?`
+		res, err := t.exec(cmd, id)
+		if err != nil {
+			return t.rollbackHandler(agentdb.SQLError{Cmd: cmd, Err: err})
+		}
+		if numRows, err := res.RowsAffected(); err != nil || numRows != 1 {
+			if err != nil {
+				return t.rollbackHandler(agentdb.SQLError{Cmd: cmd, Err: err})
+			}
+			return t.rollbackHandler(agentdb.PostFailure{Message: fmt.Sprintf("Note %v does not exist", id)})
+		}
+		return t.commit()
+	}
+	return db.retryOp(operation)
+}
+
+// GetNotes outputs the notes of the objects of the given type, oldest first
+// objectType selects all notes if empty, and objectIds all objects of the type if empty
+func (db *Store) GetNotes(objectType string, objectIds []string) (types.NoteList, error) {
+	cmd := `SELECT id, object_type, object_id, body, author, created_at, updated_by, updated_at FROM notes
+          WHERE (?='' OR object_type=?)`
+	args := []interface{}{objectType, objectType}
+	if len(objectIds) > 0 {
+		cmd += ` AND object_id IN (` + placeholders(len(objectIds)) + `)`
+		args = append(args, stringArgs(objectIds)...)
+	}
+	cmd += ` ORDER BY id`
+	rows, err := db.query(cmd, args...)
+	if err != nil {
+		return types.NoteList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	notes := []types.Note{}
+	for rows.Next() {
+		n := types.Note{}
+		if err = rows.Scan(&n.ID, &n.ObjectType, &n.ObjectId, &n.Body, &n.Author, &n.CreatedAt,
+			&n.UpdatedBy, &n.UpdatedAt); err != nil {
+			return types.NoteList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		notes = append(notes, n)
+	}
+	return types.NoteList{Notes: notes}, nil
+}
+
+// GetNoteRevisions outputs the versions of the text of a note, oldest first
+func (db *Store) GetNoteRevisions(id int64) (types.NoteRevisionList, error) {
+	cmd := `SELECT note_id, body, edited_by, edited_at FROM note_revisions WHERE note_id=? ORDER BY id`
+	rows, err := db.query(cmd, id)
+	if err != nil {
+		return types.NoteRevisionList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	defer rows.Close()
+
+	revisions := []types.NoteRevision{}
+	for rows.Next() {
+		r := types.NoteRevision{}
+		if err = rows.Scan(&r.NoteID, &r.Body, &r.EditedBy, &r.EditedAt); err != nil {
+			return types.NoteRevisionList{}, agentdb.SQLError{Cmd: cmd, Err: err}
+		}
+		revisions = append(revisions, r)
+	}
+	if len(revisions) == 0 {
+		return types.NoteRevisionList{}, agentdb.GetError{Message: fmt.Sprintf("Note %v does not exist", id)}
+	}
+	return types.NoteRevisionList{Revisions: revisions}, nil
+}
+
+// addNoteRevision records a version of the text of a note
+func (t *txHelper) addNoteRevision(id int64, body string, editedBy string, editedAt string) error {
+	cmd := `INSERT INTO note_revisions (note_id, body, edited_by, edited_at) VALUES (?, ?, ?, ?)`
+	if _, err := t.exec(cmd, id, body, editedBy, editedAt); err != nil {
+		return agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package sqlstore
+
+import (
+	"fmt"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+)
+
+// SECRET HANDLERS
+
+// GetOrCreateSecret stores value under name unless a value is stored under
+// it, and returns the stored value, so replicas sharing the database agree on it
+func (db *Store) GetOrCreateSecret(name string, value []byte) ([]byte, error) {
+	cmdInsert := `INSERT INTO secrets (name, value, updated_at) VALUES (?, ?, ?) ` + db.dialect.Upsert("name")
+	if _, err := db.exec(cmdInsert, name, value, agentdb.FormatTimestamp(db.clock.Now())); err != nil {
+		return nil, agentdb.SQLError{Cmd: cmdInsert, Err: err}
+	}
+	cmd := `SELECT value FROM secrets WHERE name=?`
+	var stored []byte
+	if err := db.queryRow(cmd, name).Scan(&stored); err != nil {
+		return nil, agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	return stored, nil
+}
+
+// UpdateSecret replaces the value stored under name
+// returns PostFailure if no value is stored under name
+func (db *Store) UpdateSecret(name string, value []byte) error {
+	cmd := `UPDATE secrets SET value=?, updated_at=? WHERE name=?`
+	res, err := db.exec(cmd, value, agentdb.FormatTimestamp(db.clock.Now()), name)
+	if err != nil {
+		return agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return agentdb.SQLError{Cmd: cmd, Err: err}
+	}
+	if numRows != 1 {
+		return agentdb.PostFailure{Message: fmt.Sprintf("Secret %v does not exist", name)}
+	}
+	return nil
+}
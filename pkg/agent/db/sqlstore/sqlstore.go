@@ -0,0 +1,194 @@
+// Package sqlstore implements the Tornjak DataStore on a SQL database shared by
+// several Tornjak replicas, for the postgres and mysql DataStores
+//
+// it stores agents, clusters and the memberships of agents in clusters, with
+// their labels, extension fields and history, in the tables created by the
+// DataStore; the SQL differing between databases is given by its Dialect
+// see unsupported.go for the capabilities only the sqlite DataStore has
+package sqlstore
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+
+	backoff "github.com/cenkalti/backoff/v4"
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/collation"
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+)
+
+// Dialect is the SQL of a database the Store runs on
+// statements of the Store are written with ? placeholders, and only use ?
+// as placeholders, so they are bound with Placeholder before they are run
+type Dialect struct {
+	// Name is the name of the DataStore, e.g. in the errors of unsupported capabilities
+	Name string
+	// Placeholder returns the placeholder of the nth argument of a statement,
+	// from 1, as in agentdb.ConvertTimestamps
+	Placeholder func(n int) string
+	// NameOrder sorts a column of names under the collation of the DB
+	NameOrder agentdb.NameOrder
+
+	// HistoryChangeColumn is the column of cluster_history with the change of each state
+	HistoryChangeColumn string
+	// ForUpdateOf returns the clause of a SELECT locking the selected rows of table,
+	// not those of the tables it is joined with
+	ForUpdateOf func(table string) string
+	// Upsert returns the clause of an INSERT updating columns of the row
+	// conflicting on the unique key, a list of columns; without columns the
+	// row is left as is
+	Upsert func(key string, columns ...string) string
+	// UpsertChanged returns the clause of an INSERT updating column and
+	// updated_at of the row of table conflicting on key, updated_at only if
+	// the value of column changes
+	UpsertChanged func(table, key, column string) string
+	// InsertID runs an INSERT of a row into a table with a generated id column,
+	// and returns the id of the row
+	InsertID func(ctx context.Context, tx *sql.Tx, cmd string, args ...interface{}) (int64, error)
+	// LockIntegrityReport is the statement locking the report of integrity checks,
+	// so replicas checking together do not both insert it; none if they deadlock
+	// and are retried
+	LockIntegrityReport string
+	// SearchClusters returns the query of the names of the clusters matching
+	// every search term at the start of a word of their name, domain name,
+	// manager or platform type, best match first, see agentdb.SearchTerms
+	SearchClusters func(terms []string) (string, []interface{})
+
+	// UniqueViolation returns whether err is the violation of a unique index
+	// of the database, of the index named index unless empty
+	UniqueViolation func(err error, index string) bool
+	// Retryable returns whether the transaction failed on a conflict with a
+	// concurrent transaction and can be retried as is
+	Retryable func(err error) bool
+	// RollbackCause returns the cause of an error raised by the database a
+	// transaction is rolled back upon, false if err is not raised by it
+	RollbackCause func(err error) (string, bool)
+}
+
+// Options contains the settings of a Store
+type Options struct {
+	// Collation orders cluster and agent names in lists, it must match the NameOrder of the Dialect
+	Collation *collation.Collation
+	// Clock stamps creation and change times, the clock of the system if nil
+	Clock clock.Clock
+}
+
+// Store is the DataStore on the tables of a database
+// the postgres and mysql DataStores embed it with the operations only their database has
+type Store struct {
+	database   *sql.DB
+	dialect    *Dialect
+	expBackoff backoff.BackOff
+
+	// orders names in lists; pages of names are sorted with ORDER BY under
+	// the matching collation of the database, see Dialect.NameOrder
+	collation *collation.Collation
+
+	// counts commits and rollbacks of transactions by operation
+	txMetrics *txMetrics
+
+	clock clock.Clock
+
+	// user or component making the changes, recorded in the audit log, see WithActor
+	actor string
+}
+
+// New returns the Store on the Tornjak tables of database, created by the
+// DataStore, and adds the known plugin types missing from them
+// transactions that fail on a conflict with a concurrent transaction, e.g. of
+// another replica, are retried with backOffParams
+func New(database *sql.DB, dialect *Dialect, backOffParams backoff.BackOff, opts Options) (*Store, error) {
+	db := &Store{
+		database:   database,
+		dialect:    dialect,
+		expBackoff: backOffParams,
+		collation:  opts.Collation,
+		txMetrics:  newTxMetrics(),
+		clock:      clock.OrNew(opts.Clock),
+	}
+	if db.collation == nil {
+		db.collation, _ = collation.New(collation.Binary, "")
+	}
+	if err := db.addKnownPluginTypes(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Close closes the connections to the database
+func (db *Store) Close() error {
+	return db.database.Close()
+}
+
+// Ping checks a connection to the database can be established
+func (db *Store) Ping(ctx context.Context) error {
+	return db.database.PingContext(ctx)
+}
+
+// bind returns cmd with its ? placeholders replaced by those of the dialect
+func bind(dialect *Dialect, cmd string) string {
+	if !strings.Contains(cmd, "?") || dialect.Placeholder(1) == "?" {
+		return cmd
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range cmd {
+		if r == '?' {
+			n++
+			b.WriteString(dialect.Placeholder(n))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// query runs the SELECT cmd outside of a transaction
+func (db *Store) query(cmd string, args ...interface{}) (*sql.Rows, error) {
+	return db.database.Query(bind(db.dialect, cmd), args...)
+}
+
+// queryRow runs the SELECT cmd of one row outside of a transaction
+func (db *Store) queryRow(cmd string, args ...interface{}) *sql.Row {
+	return db.database.QueryRow(bind(db.dialect, cmd), args...)
+}
+
+// exec runs the statement cmd outside of a transaction
+func (db *Store) exec(cmd string, args ...interface{}) (sql.Result, error) {
+	return db.database.Exec(bind(db.dialect, cmd), args...)
+}
+
+// newClusterUID returns the UID of a new cluster, in the format of the sqlite DataStore
+func newClusterUID() (string, error) {
+	uid := make([]byte, 16)
+	if _, err := rand.Read(uid); err != nil {
+		return "", errors.Errorf("Unable to generate cluster UID: %v", err)
+	}
+	return hex.EncodeToString(uid), nil
+}
+
+// placeholders returns n comma-separated placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// stringArgs returns values as arguments of a statement
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
+
+// anyPlaceholder is the placeholder of the statements of the Store, bound when they are run
+func anyPlaceholder(n int) string {
+	return "?"
+}
+
+var _ agentdb.AgentDB = (*Store)(nil)
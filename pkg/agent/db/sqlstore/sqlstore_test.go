@@ -0,0 +1,19 @@
+package sqlstore
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestBind checks the ? placeholders of statements are replaced by those of the dialect
+func TestBind(t *testing.T) {
+	const cmd = "SELECT id FROM agents WHERE spiffeid=? AND plugin_type_id IN (?,?)"
+	numbered := &Dialect{Placeholder: func(n int) string { return fmt.Sprintf("$%d", n) }}
+	expected := "SELECT id FROM agents WHERE spiffeid=$1 AND plugin_type_id IN ($2,$3)"
+	if got := bind(numbered, "SELECT id FROM agents WHERE spiffeid=? AND plugin_type_id IN ("+placeholders(2)+")"); got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+	if got := bind(&Dialect{Placeholder: anyPlaceholder}, cmd); got != cmd {
+		t.Fatalf("Expected %q unchanged, got %q", cmd, got)
+	}
+}
@@ -0,0 +1,231 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	backoff "github.com/cenkalti/backoff/v4"
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// txHelper runs the statements of one transaction of a DB operation
+type txHelper struct {
+	ctx context.Context
+	tx  *sql.Tx
+
+	// name of the DB operation, outcomes are counted in metrics under it
+	operation string
+	metrics   *txMetrics
+
+	// source of the creation and change times written in the transaction
+	clock clock.Clock
+
+	// user or component making the changes, recorded in the audit log
+	actor string
+
+	dialect *Dialect
+}
+
+// begin starts the transaction of operation
+func (db *Store) begin(ctx context.Context, operation string) (*txHelper, error) {
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Errorf("Error initializing context: %v", err)
+	}
+	return &txHelper{ctx, tx, operation, db.txMetrics, db.clock, db.actor, db.dialect}, nil
+}
+
+// exec runs the statement cmd in the transaction
+func (t *txHelper) exec(cmd string, args ...interface{}) (sql.Result, error) {
+	return t.tx.ExecContext(t.ctx, bind(t.dialect, cmd), args...)
+}
+
+// query runs the SELECT cmd in the transaction
+func (t *txHelper) query(cmd string, args ...interface{}) (*sql.Rows, error) {
+	return t.tx.QueryContext(t.ctx, bind(t.dialect, cmd), args...)
+}
+
+// queryRow runs the SELECT cmd of one row in the transaction
+func (t *txHelper) queryRow(cmd string, args ...interface{}) *sql.Row {
+	return t.tx.QueryRowContext(t.ctx, bind(t.dialect, cmd), args...)
+}
+
+// commit commits the transaction and counts the outcome
+func (t *txHelper) commit() error {
+	err := t.tx.Commit()
+	t.metrics.commit(t.operation, err)
+	if err != nil {
+		log.Printf("transaction %s: commit failed: %v", t.operation, err)
+	}
+	return err
+}
+
+// rollbackHandler rolls back the transaction upon err and counts the rollback
+// returns err with the outcome of the rollback, or a permanent error unless
+// err is a conflict with a concurrent transaction
+func (t *txHelper) rollbackHandler(err error) error {
+	if err == nil { // THIS SHOULD NOT HAPPEN
+		return backoff.Permanent(errors.New("Rollback handler called upon no error"))
+	}
+	rollbackErr := t.tx.Rollback()
+	cause := classifyRollback(t.dialect, err)
+	t.metrics.rollback(t.operation, cause)
+	if category, ok := agentdb.PostFailureCategory(err); ok {
+		t.metrics.postFailure(t.operation, category)
+	}
+	log.Printf("transaction %s: rollback (%s): %v", t.operation, cause, err)
+
+	var rollbackStatus string
+	if rollbackErr != nil {
+		rollbackStatus = fmt.Sprintf("[Unsuccessful rollback [%v] upon error]", rollbackErr.Error())
+	} else {
+		rollbackStatus = "[Successful rollback upon error]"
+	}
+	var ret error
+	if serr, ok := err.(agentdb.SQLError); ok {
+		ret = agentdb.SQLError{Cmd: serr.Cmd, Err: errors.Errorf("%v: %v", serr.Err, rollbackStatus)}
+	} else if serr, ok := err.(agentdb.GetError); ok {
+		ret = agentdb.GetError{Message: fmt.Sprintf("%v: %v", serr.Message, rollbackStatus)}
+	} else if serr, ok := err.(agentdb.PostFailure); ok {
+		ret = agentdb.PostFailure{Message: fmt.Sprintf("%v: %v", serr.Message, rollbackStatus)}
+	} else {
+		ret = errors.Errorf("%v: %v", err.Error(), rollbackStatus)
+	}
+	if t.dialect.Retryable(err) {
+		return ret
+	}
+	return backoff.Permanent(ret)
+}
+
+// opBackOff returns the backoff of a single operation
+// an ExponentialBackOff holds the state of one sequence of retries, so concurrent
+// operations each retry with their own copy
+func (db *Store) opBackOff() backoff.BackOff {
+	if expBackoff, ok := db.expBackoff.(*backoff.ExponentialBackOff); ok {
+		opBackoff := *expBackoff
+		return &opBackoff
+	}
+	return db.expBackoff
+}
+
+// retryOp runs operation until it succeeds, fails with a permanent error or the backoff stops
+func (db *Store) retryOp(operation func() error) error {
+	err := backoff.Retry(operation, db.opBackOff())
+	if err != nil {
+		if serr, ok := err.(*backoff.PermanentError); ok {
+			return serr.Unwrap()
+		}
+	}
+	return err
+}
+
+// txMetrics counts the transactions of the DB by operation and outcome
+type txMetrics struct {
+	mu    sync.Mutex
+	since time.Time
+	ops   map[string]*types.TxOperationStats
+}
+
+func newTxMetrics() *txMetrics {
+	return &txMetrics{
+		since: time.Now().UTC(),
+		ops:   make(map[string]*types.TxOperationStats),
+	}
+}
+
+// op returns the counters of operation, m.mu must be held
+func (m *txMetrics) op(operation string) *types.TxOperationStats {
+	stats, ok := m.ops[operation]
+	if !ok {
+		stats = &types.TxOperationStats{Operation: operation, Rollbacks: make(map[string]int64), PostFailures: make(map[string]int64)}
+		m.ops[operation] = stats
+	}
+	return stats
+}
+
+func (m *txMetrics) commit(operation string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.op(operation).CommitFailures++
+	} else {
+		m.op(operation).Commits++
+	}
+}
+
+func (m *txMetrics) rollback(operation string, cause string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.op(operation).Rollbacks[cause]++
+}
+
+// postFailure counts a rollback of operation upon a PostFailure of category
+func (m *txMetrics) postFailure(operation string, category string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.op(operation).PostFailures[category]++
+}
+
+// stats returns a copy of the counters, sorted by operation
+func (m *txMetrics) stats() types.TxStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := types.TxStats{
+		Since:        m.since.Format(time.RFC3339),
+		Operations:   make([]types.TxOperationStats, 0, len(m.ops)),
+		PostFailures: make(map[string]int64),
+	}
+	for _, stats := range m.ops {
+		rollbacks := make(map[string]int64, len(stats.Rollbacks))
+		for cause, n := range stats.Rollbacks {
+			rollbacks[cause] = n
+		}
+		postFailures := make(map[string]int64, len(stats.PostFailures))
+		for category, n := range stats.PostFailures {
+			postFailures[category] = n
+			ret.PostFailures[category] += n
+		}
+		op := *stats
+		op.Rollbacks = rollbacks
+		op.PostFailures = postFailures
+		ret.Operations = append(ret.Operations, op)
+	}
+	sort.Slice(ret.Operations, func(i, j int) bool {
+		return ret.Operations[i].Operation < ret.Operations[j].Operation
+	})
+	return ret
+}
+
+// classifyRollback returns the cause of the error a transaction is rolled back upon,
+// with the causes of the sqlite DataStore
+func classifyRollback(dialect *Dialect, err error) string {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return types.RollbackCauseCanceled
+	}
+	if cause, ok := dialect.RollbackCause(err); ok {
+		return cause
+	}
+	// PostFailure is raised on conflicts with the stored data
+	var pf agentdb.PostFailure
+	if errors.As(err, &pf) {
+		return types.RollbackCauseConstraint
+	}
+	return types.RollbackCauseOther
+}
+
+// TRANSACTION METRICS HANDLERS
+
+// GetTxStats returns the commits and rollbacks by cause of each DB operation
+// since the DB was opened
+func (db *Store) GetTxStats() types.TxStats {
+	return db.txMetrics.stats()
+}
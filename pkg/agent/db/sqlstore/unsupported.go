@@ -0,0 +1,190 @@
+package sqlstore
+
+import (
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// The Store stores agents, clusters and their memberships. The
+// capabilities below are only stored by the sqlite DataStore; their methods
+// return the error of unsupported, and Supports reports them, so the server
+// refuses the config blocks relying on them and disables their routes.
+
+// unsupportedCapabilities lists the capabilities the Store does not store
+var unsupportedCapabilities = map[string]bool{
+	agentdb.CapabilitySPIREQueryLog:      true,
+	agentdb.CapabilityEntryLineage:       true,
+	agentdb.CapabilityAgentCompliance:    true,
+	agentdb.CapabilityServiceAccounts:    true,
+	agentdb.CapabilityClusterTokens:      true,
+	agentdb.CapabilityEntryOwnership:     true,
+	agentdb.CapabilityOwnershipTransfers: true,
+	agentdb.CapabilityBundleFreshness:    true,
+	agentdb.CapabilityBootstrapTokens:    true,
+	agentdb.CapabilityEntryLifecycles:    true,
+	agentdb.CapabilityRetryQueue:         true,
+}
+
+// Supports returns whether the Store stores the capability, see agentdb.CapabilityChecker
+func (db *Store) Supports(capability string) bool {
+	return !unsupportedCapabilities[capability]
+}
+
+// unsupported returns the error of a capability the Store does not store
+func (db *Store) unsupported(capability string) error {
+	return agentdb.UnsupportedError{Capability: capability, DataStore: db.dialect.Name}
+}
+
+// SPIRE QUERY LOG HANDLERS
+
+// AddSPIRECallRecord does not record the call; the server does not call it
+// as Supports reports the query log unsupported
+func (db *Store) AddSPIRECallRecord(call types.SPIRECallInfo) error {
+	return db.unsupported(agentdb.CapabilitySPIREQueryLog)
+}
+
+func (db *Store) GetSPIRECallRecords(opts types.ListOptions) (types.List[types.SPIRECallInfo], error) {
+	return types.List[types.SPIRECallInfo]{}, db.unsupported(agentdb.CapabilitySPIREQueryLog)
+}
+
+// ENTRY LINEAGE HANDLERS
+
+func (db *Store) CreateEntryLineage(lineage types.EntryLineage) error {
+	return db.unsupported(agentdb.CapabilityEntryLineage)
+}
+
+func (db *Store) GetEntryLineage(entryId string) (types.EntryLineage, error) {
+	return types.EntryLineage{}, db.unsupported(agentdb.CapabilityEntryLineage)
+}
+
+// AGENT COMPLIANCE HANDLERS
+
+func (db *Store) AddAgentComplianceReport(report types.AgentComplianceReport) error {
+	return db.unsupported(agentdb.CapabilityAgentCompliance)
+}
+
+func (db *Store) GetAgentComplianceHistory(spiffeid string, attribute string, limit int) (types.AgentComplianceHistory, error) {
+	return types.AgentComplianceHistory{}, db.unsupported(agentdb.CapabilityAgentCompliance)
+}
+
+// SERVICE ACCOUNT HANDLERS
+
+func (db *Store) CreateServiceAccount(account types.ServiceAccount, keyHash string) error {
+	return db.unsupported(agentdb.CapabilityServiceAccounts)
+}
+
+func (db *Store) GetServiceAccounts() (types.ServiceAccountList, error) {
+	return types.ServiceAccountList{}, db.unsupported(agentdb.CapabilityServiceAccounts)
+}
+
+func (db *Store) GetServiceAccountByKeyHash(keyHash string) (types.ServiceAccount, error) {
+	return types.ServiceAccount{}, db.unsupported(agentdb.CapabilityServiceAccounts)
+}
+
+func (db *Store) DeleteServiceAccount(name string) error {
+	return db.unsupported(agentdb.CapabilityServiceAccounts)
+}
+
+// CLUSTER TOKEN HANDLERS
+
+func (db *Store) CreateClusterToken(token types.ClusterToken, keyHash string) error {
+	return db.unsupported(agentdb.CapabilityClusterTokens)
+}
+
+func (db *Store) GetClusterTokens() (types.ClusterTokenList, error) {
+	return types.ClusterTokenList{}, db.unsupported(agentdb.CapabilityClusterTokens)
+}
+
+func (db *Store) GetClusterTokenByKeyHash(keyHash string) (types.ClusterToken, error) {
+	return types.ClusterToken{}, db.unsupported(agentdb.CapabilityClusterTokens)
+}
+
+func (db *Store) DeleteClusterToken(name string) error {
+	return db.unsupported(agentdb.CapabilityClusterTokens)
+}
+
+// OWNERSHIP HANDLERS
+
+func (db *Store) SetEntryOwner(owner types.EntryOwner) error {
+	return db.unsupported(agentdb.CapabilityEntryOwnership)
+}
+
+func (db *Store) GetEntryOwners(team string) (types.EntryOwnerList, error) {
+	return types.EntryOwnerList{}, db.unsupported(agentdb.CapabilityEntryOwnership)
+}
+
+func (db *Store) TransferOwnership(transfer types.OwnershipTransfer) (types.OwnershipTransferResult, error) {
+	return types.OwnershipTransferResult{}, db.unsupported(agentdb.CapabilityOwnershipTransfers)
+}
+
+func (db *Store) GetOwnershipTransfers(opts types.ListOptions) (types.List[types.OwnershipTransferRecord], error) {
+	return types.List[types.OwnershipTransferRecord]{}, db.unsupported(agentdb.CapabilityOwnershipTransfers)
+}
+
+// BUNDLE FRESHNESS HANDLERS
+
+func (db *Store) SetBundleFreshness(status types.BundleFreshness) error {
+	return db.unsupported(agentdb.CapabilityBundleFreshness)
+}
+
+func (db *Store) GetBundleFreshness() (types.BundleFreshnessList, error) {
+	return types.BundleFreshnessList{}, db.unsupported(agentdb.CapabilityBundleFreshness)
+}
+
+func (db *Store) DeleteBundleFreshness(trustDomain string) error {
+	return db.unsupported(agentdb.CapabilityBundleFreshness)
+}
+
+// BOOTSTRAP TOKEN HANDLERS
+
+func (db *Store) CreateBootstrapToken(token types.BootstrapToken, tokenHash string) error {
+	return db.unsupported(agentdb.CapabilityBootstrapTokens)
+}
+
+func (db *Store) GetBootstrapTokens(state string) (types.BootstrapTokenList, error) {
+	return types.BootstrapTokenList{}, db.unsupported(agentdb.CapabilityBootstrapTokens)
+}
+
+func (db *Store) ConsumeBootstrapToken(tokenHash string, agentID string, consumedAt string) (bool, error) {
+	return false, db.unsupported(agentdb.CapabilityBootstrapTokens)
+}
+
+func (db *Store) ExpireBootstrapTokens(now string) (int64, error) {
+	return 0, db.unsupported(agentdb.CapabilityBootstrapTokens)
+}
+
+// ENTRY LIFECYCLE HANDLERS
+
+func (db *Store) SetEntryLifecycle(lifecycle types.EntryLifecycle) error {
+	return db.unsupported(agentdb.CapabilityEntryLifecycles)
+}
+
+func (db *Store) GetEntryLifecycles(state string) (types.EntryLifecycleList, error) {
+	return types.EntryLifecycleList{}, db.unsupported(agentdb.CapabilityEntryLifecycles)
+}
+
+func (db *Store) MarkEntryLifecycleNotified(entryId string, notifiedAt string) error {
+	return db.unsupported(agentdb.CapabilityEntryLifecycles)
+}
+
+func (db *Store) MarkEntryRemoved(entryId string, removedAt string) (bool, error) {
+	return false, db.unsupported(agentdb.CapabilityEntryLifecycles)
+}
+
+// FAILED OPERATION HANDLERS
+
+func (db *Store) AddFailedOperation(op types.FailedOperation) (int64, error) {
+	return 0, db.unsupported(agentdb.CapabilityRetryQueue)
+}
+
+func (db *Store) GetFailedOperation(id int64) (types.FailedOperation, error) {
+	return types.FailedOperation{}, db.unsupported(agentdb.CapabilityRetryQueue)
+}
+
+func (db *Store) GetFailedOperations(state string) (types.FailedOperationList, error) {
+	return types.FailedOperationList{}, db.unsupported(agentdb.CapabilityRetryQueue)
+}
+
+func (db *Store) UpdateFailedOperation(op types.FailedOperation) error {
+	return db.unsupported(agentdb.CapabilityRetryQueue)
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LegacyTimestampLayout is the layout of the creation times of clusters
+// stored by earlier releases, in the local time of the Tornjak server
+const LegacyTimestampLayout = "Jan 02 2006 15:04:05"
+
+// FormatTimestamp formats a time as stored by the DataStores, RFC3339 in UTC,
+// so stored times sort as text
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ParseTimestamp parses a time stored by the DataStores, the zero time if empty
+// times in LegacyTimestampLayout not migrated yet are taken in local time
+func ParseTimestamp(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.ParseInLocation(LegacyTimestampLayout, value, time.Local)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid timestamp %q", value)
+	}
+	return t.UTC(), nil
+}
+
+// UpgradeTimestamp returns a time in LegacyTimestampLayout, taken in loc, as
+// stored by this release
+// ok is false if value is not in LegacyTimestampLayout
+func UpgradeTimestamp(value string, loc *time.Location) (upgraded string, ok bool) {
+	t, err := time.ParseInLocation(LegacyTimestampLayout, value, loc)
+	if err != nil {
+		return "", false
+	}
+	return FormatTimestamp(t), true
+}
+
+// DowngradeTimestamp returns a time stored by this release in LegacyTimestampLayout
+// in loc, as stored by earlier releases
+// ok is false if value is not in RFC3339
+func DowngradeTimestamp(value string, loc *time.Location) (downgraded string, ok bool) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", false
+	}
+	return t.In(loc).Format(LegacyTimestampLayout), true
+}
+
+// ConvertClusterSnapshot applies convert to the creation time of a JSON-encoded
+// cluster of the cluster history
+// ok is false if the snapshot is empty or convert leaves its creation time unchanged
+func ConvertClusterSnapshot(snapshot string, convert func(value string) (string, bool)) (converted string, ok bool, err error) {
+	if len(snapshot) == 0 {
+		return "", false, nil
+	}
+	fields := map[string]json.RawMessage{}
+	if err = json.Unmarshal([]byte(snapshot), &fields); err != nil {
+		return "", false, errors.Errorf("invalid cluster snapshot: %v", err)
+	}
+	var creationTime string
+	if err = json.Unmarshal(fields["creationTime"], &creationTime); err != nil {
+		return "", false, nil
+	}
+	creationTime, ok = convert(creationTime)
+	if !ok {
+		return "", false, nil
+	}
+	fields["creationTime"], err = json.Marshal(creationTime)
+	if err != nil {
+		return "", false, errors.Errorf("invalid cluster snapshot: %v", err)
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", false, errors.Errorf("invalid cluster snapshot: %v", err)
+	}
+	return string(data), true, nil
+}
+
+// Queryer runs statements, such as *sql.Tx and *sql.Conn
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// ConvertTimestamps applies convert to the creation times of the clusters and
+// of the snapshots of the cluster history, for migrations of the stored times
+// placeholder returns the placeholder of the nth argument of a statement, from 1
+func ConvertTimestamps(ctx context.Context, q Queryer, placeholder func(n int) string, convert func(value string) (string, bool)) error {
+	cmd := `SELECT id, created_at FROM clusters WHERE created_at IS NOT NULL`
+	updates, err := selectConverted(ctx, q, cmd, func(value string) (string, bool, error) {
+		converted, ok := convert(value)
+		return converted, ok, nil
+	})
+	if err != nil {
+		return err
+	}
+	cmdUpdate := `UPDATE clusters SET created_at=` + placeholder(1) + ` WHERE id=` + placeholder(2)
+	for id, value := range updates {
+		if _, err = q.ExecContext(ctx, cmdUpdate, value, id); err != nil {
+			return SQLError{cmdUpdate, err}
+		}
+	}
+
+	cmd = `SELECT id, snapshot FROM cluster_history WHERE snapshot IS NOT NULL`
+	updates, err = selectConverted(ctx, q, cmd, func(snapshot string) (string, bool, error) {
+		return ConvertClusterSnapshot(snapshot, convert)
+	})
+	if err != nil {
+		return err
+	}
+	cmdUpdate = `UPDATE cluster_history SET snapshot=` + placeholder(1) + ` WHERE id=` + placeholder(2)
+	for id, value := range updates {
+		if _, err = q.ExecContext(ctx, cmdUpdate, value, id); err != nil {
+			return SQLError{cmdUpdate, err}
+		}
+	}
+	return nil
+}
+
+// selectConverted returns the values changed by convert of the rows of id and value selected by cmd, by id
+func selectConverted(ctx context.Context, q Queryer, cmd string, convert func(value string) (string, bool, error)) (map[int64]string, error) {
+	rows, err := q.QueryContext(ctx, cmd)
+	if err != nil {
+		return nil, SQLError{cmd, err}
+	}
+	defer rows.Close()
+	converted := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var value string
+		if err = rows.Scan(&id, &value); err != nil {
+			return nil, SQLError{cmd, err}
+		}
+		newValue, ok, err := convert(value)
+		if err != nil {
+			return nil, errors.Errorf("row %d: %v", id, err)
+		}
+		if ok {
+			converted[id] = newValue
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, SQLError{cmd, err}
+	}
+	return converted, nil
+}
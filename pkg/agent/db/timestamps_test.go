@@ -0,0 +1,54 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTimestamps checks stored times round trip, and legacy times are converted in their location
+func TestTimestamps(t *testing.T) {
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	now := time.Date(2024, 2, 8, 16, 2, 10, 0, loc)
+
+	// CHECK times are stored in UTC [FormatTimestamp, ParseTimestamp]
+	stored := FormatTimestamp(now)
+	if stored != "2024-02-08T21:02:10Z" {
+		t.Fatalf("Expected time in UTC, got %q", stored)
+	}
+	parsed, err := ParseTimestamp(stored)
+	if err != nil || !parsed.Equal(now) || parsed.Location() != time.UTC {
+		t.Fatalf("Expected %v in UTC, got %v, %v", now, parsed, err)
+	}
+	if parsed, err = ParseTimestamp(""); err != nil || !parsed.IsZero() {
+		t.Fatalf("Empty time should be the zero time, got %v, %v", parsed, err)
+	}
+	if _, err = ParseTimestamp("yesterday"); err == nil {
+		t.Fatal("Invalid time should fail")
+	}
+
+	// CHECK legacy times are converted from and to their location [UpgradeTimestamp, DowngradeTimestamp]
+	upgraded, ok := UpgradeTimestamp("Feb 08 2024 16:02:10", loc)
+	if !ok || upgraded != stored {
+		t.Fatalf("Expected legacy time upgraded to %q, got %q", stored, upgraded)
+	}
+	if _, ok = UpgradeTimestamp(stored, loc); ok {
+		t.Fatal("Upgraded time should not be upgraded again")
+	}
+	downgraded, ok := DowngradeTimestamp(stored, loc)
+	if !ok || downgraded != "Feb 08 2024 16:02:10" {
+		t.Fatalf("Expected time downgraded to the legacy layout, got %q", downgraded)
+	}
+
+	// CHECK only the creation time of snapshots is converted [ConvertClusterSnapshot]
+	upgrade := func(value string) (string, bool) { return UpgradeTimestamp(value, loc) }
+	snapshot, ok, err := ConvertClusterSnapshot(`{"name":"dev","creationTime":"Feb 08 2024 16:02:10"}`, upgrade)
+	if err != nil || !ok || snapshot != `{"creationTime":"2024-02-08T21:02:10Z","name":"dev"}` {
+		t.Fatalf("Unexpected converted snapshot %s, %v, %v", snapshot, ok, err)
+	}
+	if _, ok, err = ConvertClusterSnapshot(snapshot, upgrade); ok || err != nil {
+		t.Fatalf("Converted snapshot should be left unchanged, got %v, %v", ok, err)
+	}
+	if _, ok, err = ConvertClusterSnapshot("", upgrade); ok || err != nil {
+		t.Fatalf("Empty snapshot should be left unchanged, got %v, %v", ok, err)
+	}
+}
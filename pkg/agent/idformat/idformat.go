@@ -0,0 +1,146 @@
+package idformat
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// options of the display query flag
+const (
+	TrimTrustDomain = "trimTrustDomain"
+	ShortUUID       = "shortUUID"
+)
+
+// length of the prefix UUIDs are shortened to
+const shortUUIDLength = 8
+
+var uuidPattern = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+// Options format SPIFFE IDs for display
+// IDs are only shortened for display; responses keep the canonical ID alongside
+type Options struct {
+	// drop the spiffe://<trust domain> prefix, leaving the path
+	TrimTrustDomain bool
+	// shorten UUIDs in the path to their first segment
+	ShortUUID bool
+}
+
+// ParseOptions parses a comma-separated list of display options, e.g. "trimTrustDomain,shortUUID"
+func ParseOptions(flag string) (Options, error) {
+	var o Options
+	for _, option := range strings.Split(flag, ",") {
+		switch strings.TrimSpace(option) {
+		case "":
+		case TrimTrustDomain:
+			o.TrimTrustDomain = true
+		case ShortUUID:
+			o.ShortUUID = true
+		default:
+			return Options{}, errors.Errorf("invalid display option %q: must be one of %s, %s", option, TrimTrustDomain, ShortUUID)
+		}
+	}
+	return o, nil
+}
+
+// Enabled returns whether any formatting is configured
+func (o Options) Enabled() bool {
+	return o.TrimTrustDomain || o.ShortUUID
+}
+
+// Format returns the display form of a SPIFFE ID
+func (o Options) Format(id string) string {
+	if o.TrimTrustDomain {
+		if rest, ok := strings.CutPrefix(id, "spiffe://"); ok {
+			if i := strings.Index(rest, "/"); i >= 0 {
+				id = rest[i:]
+			} else {
+				id = "/"
+			}
+		}
+	}
+	if o.ShortUUID {
+		id = uuidPattern.ReplaceAllStringFunc(id, func(uuid string) string {
+			return uuid[:shortUUIDLength]
+		})
+	}
+	return id
+}
+
+// Annotate adds the display form of the SPIFFE IDs in a decoded JSON value
+//   - a string or list of strings of SPIFFE IDs under key k gets a sibling
+//     kDisplay, keeping the canonical ID in k
+//   - a SPIRE SPIFFE ID object of trust_domain and path gets the fields
+//     display and canonical
+func (o Options) Annotate(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if td, path, ok := spiffeIDObject(v); ok {
+			canonical := "spiffe://" + td + path
+			v["canonical"] = canonical
+			v["display"] = o.Format(canonical)
+			return v
+		}
+		display := map[string]interface{}{}
+		for key, child := range v {
+			switch c := child.(type) {
+			case string:
+				if isSPIFFEID(c) {
+					display[key+"Display"] = o.Format(c)
+				}
+			case []interface{}:
+				if isSPIFFEIDList(c) {
+					display[key+"Display"] = o.formatList(c)
+					continue
+				}
+				v[key] = o.Annotate(c)
+			default:
+				v[key] = o.Annotate(c)
+			}
+		}
+		for key, child := range display {
+			if _, exists := v[key]; !exists {
+				v[key] = child
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = o.Annotate(child)
+		}
+	}
+	return value
+}
+
+func isSPIFFEID(s string) bool {
+	return strings.HasPrefix(s, "spiffe://")
+}
+
+// spiffeIDObject returns the trust domain and path of a SPIRE SPIFFE ID object
+func spiffeIDObject(v map[string]interface{}) (string, string, bool) {
+	if len(v) != 2 {
+		return "", "", false
+	}
+	td, tdOk := v["trust_domain"].(string)
+	path, pathOk := v["path"].(string)
+	return td, path, tdOk && pathOk && td != ""
+}
+
+// isSPIFFEIDList returns whether list is a non-empty list of SPIFFE IDs
+func isSPIFFEIDList(list []interface{}) bool {
+	for _, item := range list {
+		if s, ok := item.(string); !ok || !isSPIFFEID(s) {
+			return false
+		}
+	}
+	return len(list) > 0
+}
+
+// formatList returns the display forms of a list of SPIFFE IDs
+func (o Options) formatList(list []interface{}) []interface{} {
+	display := make([]interface{}, len(list))
+	for i, item := range list {
+		display[i] = o.Format(item.(string))
+	}
+	return display
+}
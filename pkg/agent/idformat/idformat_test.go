@@ -0,0 +1,86 @@
+package idformat
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseOptions(t *testing.T) {
+	o, err := ParseOptions("trimTrustDomain, shortUUID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.TrimTrustDomain || !o.ShortUUID {
+		t.Errorf("got %+v", o)
+	}
+	if o, err := ParseOptions(""); err != nil || o.Enabled() {
+		t.Errorf("got %+v, %v for empty flag", o, err)
+	}
+	if _, err := ParseOptions("short"); err == nil {
+		t.Error("expected an error for an unknown option")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	id := "spiffe://example.org/spire/agent/join_token/0c9d6d4e-2b8a-4f4c-9d3e-7a1b2c3d4e5f"
+	tests := []struct {
+		name string
+		opts Options
+		id   string
+		want string
+	}{
+		{"none", Options{}, id, id},
+		{"trim trust domain", Options{TrimTrustDomain: true}, id, "/spire/agent/join_token/0c9d6d4e-2b8a-4f4c-9d3e-7a1b2c3d4e5f"},
+		{"short uuid", Options{ShortUUID: true}, id, "spiffe://example.org/spire/agent/join_token/0c9d6d4e"},
+		{"both", Options{TrimTrustDomain: true, ShortUUID: true}, id, "/spire/agent/join_token/0c9d6d4e"},
+		{"trust domain only", Options{TrimTrustDomain: true}, "spiffe://example.org", "/"},
+		{"uuid within a segment", Options{ShortUUID: true}, "spiffe://td/ns/pod-0c9d6d4e-2b8a-4f4c-9d3e-7a1b2c3d4e5f", "spiffe://td/ns/pod-0c9d6d4e"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.Format(tt.id); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	o := Options{TrimTrustDomain: true}
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "string and list fields",
+			body: `{"agents":[{"spiffeid":"spiffe://td/a","plugin":"k8s"}],"agentsList":["spiffe://td/a","spiffe://td/b"]}`,
+			want: `{"agents":[{"plugin":"k8s","spiffeid":"spiffe://td/a","spiffeidDisplay":"/a"}],"agentsList":["spiffe://td/a","spiffe://td/b"],"agentsListDisplay":["/a","/b"]}`,
+		},
+		{
+			name: "SPIRE SPIFFE ID objects",
+			body: `{"entries":[{"id":"1","spiffe_id":{"trust_domain":"td","path":"/w"}}]}`,
+			want: `{"entries":[{"id":"1","spiffe_id":{"canonical":"spiffe://td/w","display":"/w","path":"/w","trust_domain":"td"}}]}`,
+		},
+		{
+			name: "other values unchanged",
+			body: `{"name":"cluster","labels":["a"],"count":1}`,
+			want: `{"count":1,"labels":["a"],"name":"cluster"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.body), &value); err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(o.Annotate(value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
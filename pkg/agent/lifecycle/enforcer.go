@@ -0,0 +1,152 @@
+package lifecycle
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// Store is the part of the Tornjak DB lifecycle states and owners are kept in
+type Store interface {
+	GetEntryLifecycles(state string) (types.EntryLifecycleList, error)
+	GetEntryOwners(team string) (types.EntryOwnerList, error)
+	MarkEntryLifecycleNotified(entryId string, notifiedAt string) error
+	MarkEntryRemoved(entryId string, removedAt string) (bool, error)
+}
+
+type Config struct {
+	// deletes entries from SPIRE, returning the error of each entry not deleted by entry id
+	// entries SPIRE does not know are deleted already
+	DeleteEntries func(ctx context.Context, ids []string) (map[string]string, error)
+	Store         Store
+	// tells the owners of an entry of its removal
+	Notify func(notice types.EntryLifecycleNotice)
+	// time before their removal owners are notified
+	NoticePeriod time.Duration
+	// time between two sweeps of the scheduled removals
+	SweepInterval time.Duration
+	// source of time, the clock of the system if nil
+	Clock clock.Clock
+}
+
+// Enforcer deletes the entries scheduled for removal from SPIRE once their
+// removal time has passed, and notifies their owners beforehand
+type Enforcer struct {
+	config Config
+	clock  clock.Clock
+}
+
+func New(config Config) *Enforcer {
+	return &Enforcer{config: config, clock: clock.OrNew(config.Clock)}
+}
+
+// Run sweeps the scheduled removals every interval until ctx is done
+func (e *Enforcer) Run(ctx context.Context) {
+	ticker := e.clock.NewTicker(e.config.SweepInterval)
+	defer ticker.Stop()
+	for {
+		if err := e.Sweep(ctx); err != nil {
+			log.Printf("WARNING: could not sweep scheduled entry removals: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// Sweep notifies the owners of the entries removed within the notice period,
+// then deletes the entries past their removal time
+// an entry is only deleted once its owners were notified, at a sweep after the
+// notice if the removal was due already, and entries not deleted by SPIRE are
+// retried at the next sweep
+func (e *Enforcer) Sweep(ctx context.Context) error {
+	scheduled, err := e.config.Store.GetEntryLifecycles(types.EntryLifecycleScheduledForRemoval)
+	if err != nil {
+		return err
+	}
+	if len(scheduled.Entries) == 0 {
+		return nil
+	}
+	owners, err := e.config.Store.GetEntryOwners("")
+	if err != nil {
+		return err
+	}
+	ownerByEntry := make(map[string]types.EntryOwner)
+	for _, owner := range owners.Entries {
+		ownerByEntry[owner.EntryId] = owner
+	}
+
+	now := e.clock.Now().UTC()
+	due := []types.EntryLifecycle{}
+	for _, l := range scheduled.Entries {
+		removeAt, err := time.Parse(time.RFC3339, l.RemoveAt)
+		if err != nil {
+			log.Printf("WARNING: entry %s has an invalid removal time %q", l.EntryId, l.RemoveAt)
+			continue
+		}
+		if l.NotifiedAt == "" && removeAt.Sub(now) <= e.config.NoticePeriod {
+			e.notify(types.EntryRemovalUpcoming, l, ownerByEntry[l.EntryId])
+			if err := e.config.Store.MarkEntryLifecycleNotified(l.EntryId, formatTime(now)); err != nil {
+				return err
+			}
+			continue
+		}
+		if l.NotifiedAt != "" && !removeAt.After(now) {
+			due = append(due, l)
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(due))
+	for _, l := range due {
+		ids = append(ids, l.EntryId)
+	}
+	failures, err := e.config.DeleteEntries(ctx, ids)
+	if err != nil {
+		return errors.Errorf("could not delete entries: %v", err)
+	}
+	for _, l := range due {
+		if msg, failed := failures[l.EntryId]; failed {
+			log.Printf("WARNING: could not delete entry %s scheduled for removal: %s", l.EntryId, msg)
+			continue
+		}
+		removed, err := e.config.Store.MarkEntryRemoved(l.EntryId, formatTime(now))
+		if err != nil {
+			return err
+		}
+		if removed {
+			log.Printf("entry %s removed as scheduled for %s", l.EntryId, l.RemoveAt)
+			e.notify(types.EntryRemoved, l, ownerByEntry[l.EntryId])
+		}
+	}
+	return nil
+}
+
+// notify sends the notice of an event to the owners of the entry
+func (e *Enforcer) notify(event string, l types.EntryLifecycle, owner types.EntryOwner) {
+	if e.config.Notify == nil {
+		return
+	}
+	e.config.Notify(types.EntryLifecycleNotice{
+		Event:     event,
+		EntryId:   l.EntryId,
+		RemoveAt:  l.RemoveAt,
+		Reason:    l.Reason,
+		OwnerTeam: owner.OwnerTeam,
+		Tenant:    owner.Tenant,
+	})
+}
+
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,150 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+type memoryStore struct {
+	lifecycles map[string]types.EntryLifecycle
+	owners     []types.EntryOwner
+}
+
+func (s *memoryStore) GetEntryLifecycles(state string) (types.EntryLifecycleList, error) {
+	list := types.EntryLifecycleList{}
+	for _, l := range s.lifecycles {
+		if state == "" || l.State == state {
+			list.Entries = append(list.Entries, l)
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryStore) GetEntryOwners(team string) (types.EntryOwnerList, error) {
+	return types.EntryOwnerList{Entries: s.owners}, nil
+}
+
+func (s *memoryStore) MarkEntryLifecycleNotified(entryId string, notifiedAt string) error {
+	l := s.lifecycles[entryId]
+	l.NotifiedAt = notifiedAt
+	s.lifecycles[entryId] = l
+	return nil
+}
+
+func (s *memoryStore) MarkEntryRemoved(entryId string, removedAt string) (bool, error) {
+	l, ok := s.lifecycles[entryId]
+	if !ok || l.State != types.EntryLifecycleScheduledForRemoval {
+		return false, nil
+	}
+	l.State, l.UpdatedAt = types.EntryLifecycleRemoved, removedAt
+	s.lifecycles[entryId] = l
+	return true, nil
+}
+
+// TestSweep checks owners are notified within the notice period and entries
+// are deleted once due and notified
+func TestSweep(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(now)
+	store := &memoryStore{
+		lifecycles: map[string]types.EntryLifecycle{
+			// due in 2 days, within the notice period
+			"soon": {EntryId: "soon", State: types.EntryLifecycleScheduledForRemoval, RemoveAt: "2024-03-03T12:00:00Z"},
+			// due in 30 days
+			"later": {EntryId: "later", State: types.EntryLifecycleScheduledForRemoval, RemoveAt: "2024-03-31T12:00:00Z"},
+			// due already but not notified yet
+			"overdue": {EntryId: "overdue", State: types.EntryLifecycleScheduledForRemoval, RemoveAt: "2024-02-01T12:00:00Z"},
+			"kept":    {EntryId: "kept", State: types.EntryLifecycleDeprecated},
+		},
+		owners: []types.EntryOwner{{EntryId: "soon", OwnerTeam: "payments", Tenant: "acme"}},
+	}
+	notices := []types.EntryLifecycleNotice{}
+	deleted := []string{}
+	var deleteErr error
+	failures := map[string]string{}
+	enforcer := New(Config{
+		DeleteEntries: func(ctx context.Context, ids []string) (map[string]string, error) {
+			if deleteErr != nil {
+				return nil, deleteErr
+			}
+			deleted = append(deleted, ids...)
+			return failures, nil
+		},
+		Store:         store,
+		Notify:        func(notice types.EntryLifecycleNotice) { notices = append(notices, notice) },
+		NoticePeriod:  7 * 24 * time.Hour,
+		SweepInterval: time.Hour,
+		Clock:         fake,
+	})
+
+	// ATTEMPT first sweep; should notify the owners of the entries due within the notice period [Sweep]
+	if err := enforcer.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(notices) != 2 || len(deleted) != 0 {
+		t.Fatalf("Expected 2 notices and no deletion, got %+v and %v", notices, deleted)
+	}
+	for _, notice := range notices {
+		if notice.Event != types.EntryRemovalUpcoming {
+			t.Fatalf("Expected upcoming removal notices, got %+v", notice)
+		}
+		if notice.EntryId == "soon" && (notice.OwnerTeam != "payments" || notice.Tenant != "acme") {
+			t.Fatalf("Notice should name the owners of the entry, got %+v", notice)
+		}
+	}
+	if store.lifecycles["later"].NotifiedAt != "" {
+		t.Fatal("Entries due after the notice period should not be notified yet")
+	}
+
+	// ATTEMPT sweep while SPIRE fails; should keep the entries scheduled [Sweep]
+	deleteErr = errors.New("connection refused")
+	fake.Add(time.Hour)
+	if err := enforcer.Sweep(context.Background()); err == nil {
+		t.Fatal("Sweep should fail when entries cannot be deleted")
+	}
+	if store.lifecycles["overdue"].State != types.EntryLifecycleScheduledForRemoval {
+		t.Fatal("Entry should stay scheduled when SPIRE fails")
+	}
+
+	// ATTEMPT next sweep; should delete the notified entry that is due [Sweep]
+	deleteErr = nil
+	notices = notices[:0]
+	if err := enforcer.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "overdue" {
+		t.Fatalf("Expected entry overdue to be deleted, got %v", deleted)
+	}
+	if store.lifecycles["overdue"].State != types.EntryLifecycleRemoved {
+		t.Fatalf("Expected entry overdue to be removed, got %+v", store.lifecycles["overdue"])
+	}
+	if len(notices) != 1 || notices[0].Event != types.EntryRemoved || notices[0].EntryId != "overdue" {
+		t.Fatalf("Expected a removal notice of entry overdue, got %+v", notices)
+	}
+
+	// ATTEMPT sweep past the removal with a failed deletion; should retry later [Sweep]
+	fake.Set(time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC))
+	deleted = deleted[:0]
+	failures = map[string]string{"soon": "permission denied"}
+	if err := enforcer.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || store.lifecycles["soon"].State != types.EntryLifecycleScheduledForRemoval {
+		t.Fatalf("Entry soon should stay scheduled after a failed deletion, got %+v", store.lifecycles["soon"])
+	}
+	failures = map[string]string{}
+	if err := enforcer.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if store.lifecycles["soon"].State != types.EntryLifecycleRemoved {
+		t.Fatalf("Entry soon should be removed on retry, got %+v", store.lifecycles["soon"])
+	}
+	if store.lifecycles["kept"].State != types.EntryLifecycleDeprecated {
+		t.Fatal("Entries not scheduled for removal should be kept")
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+)
+
+// Stage is the point of the handling of API requests at which middleware runs
+type Stage int
+
+const (
+	// PreAuth middleware runs before the request is authenticated and
+	// authorized, e.g. to enrich its headers; it also sees the preflight
+	// requests answered by the server
+	PreAuth Stage = iota
+	// PostAuth middleware runs once the request is authorized, with the user
+	// in its context
+	PostAuth
+	// PreResponse middleware runs once the request is validated, right before
+	// its handler, e.g. to wrap the ResponseWriter and record the response
+	PreResponse
+)
+
+func (s Stage) String() string {
+	switch s {
+	case PreAuth:
+		return "pre-auth"
+	case PostAuth:
+		return "post-auth"
+	case PreResponse:
+		return "pre-response"
+	}
+	return "unknown"
+}
+
+// Func wraps the handling of API requests, as the middleware of gorilla/mux
+type Func func(next http.Handler) http.Handler
+
+// Middleware is a registered middleware
+type Middleware struct {
+	Name  string
+	Stage Stage
+	Func  Func
+}
+
+var (
+	registeredMu sync.RWMutex
+	registered   []Middleware
+)
+
+// Register adds custom middleware to the API of the Tornjak server at the
+// stage, after the middleware registered earlier at the stage. It is meant to
+// be called from the init function of the package implementing the middleware,
+// so downstream builds compile it in with a blank import.
+// Middleware registered once the router is set up is not applied.
+// It panics if the name is registered twice, the stage is unknown or fn is nil.
+func Register(name string, stage Stage, fn Func) {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	if fn == nil {
+		panic("middleware: Register middleware is nil")
+	}
+	if stage < PreAuth || stage > PreResponse {
+		panic("middleware: Register called with an unknown stage for middleware " + name)
+	}
+	for _, m := range registered {
+		if m.Name == name {
+			panic("middleware: Register called twice for middleware " + name)
+		}
+	}
+	registered = append(registered, Middleware{Name: name, Stage: stage, Func: fn})
+}
+
+// Registered returns the middleware registered at the stage, in the order
+// they were registered, the outermost first
+func Registered(stage Stage) []Middleware {
+	registeredMu.RLock()
+	defer registeredMu.RUnlock()
+	ret := []Middleware{}
+	for _, m := range registered {
+		if m.Stage == stage {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func passThrough(next http.Handler) http.Handler {
+	return next
+}
+
+// TestRegister checks middleware is listed by stage in the order registered,
+// and that duplicate names and unknown stages are rejected
+func TestRegister(t *testing.T) {
+	Register("test-enrich-headers", PreAuth, passThrough)
+	Register("test-audit-sink", PostAuth, passThrough)
+	Register("test-audit-response", PostAuth, passThrough)
+
+	// CHECK middleware is listed by stage in the order registered [Registered]
+	names := func(stage Stage) []string {
+		ret := []string{}
+		for _, m := range Registered(stage) {
+			ret = append(ret, m.Name)
+		}
+		return ret
+	}
+	if got := names(PostAuth); !reflect.DeepEqual(got, []string{"test-audit-sink", "test-audit-response"}) {
+		t.Fatalf("Unexpected post-auth middleware %v", got)
+	}
+	if got := names(PreAuth); !reflect.DeepEqual(got, []string{"test-enrich-headers"}) {
+		t.Fatalf("Unexpected pre-auth middleware %v", got)
+	}
+	if got := names(PreResponse); len(got) != 0 {
+		t.Fatalf("Expected no pre-response middleware, got %v", got)
+	}
+
+	// CHECK invalid registrations panic [Register]
+	for name, register := range map[string]func(){
+		"duplicate name": func() { Register("test-audit-sink", PreResponse, passThrough) },
+		"unknown stage":  func() { Register("test-unknown-stage", Stage(7), passThrough) },
+		"nil middleware": func() { Register("test-nil", PreAuth, nil) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Expected panic on %s", name)
+				}
+			}()
+			register()
+		}()
+	}
+	if got := names(PreResponse); len(got) != 0 {
+		t.Fatalf("Expected no pre-response middleware, got %v", got)
+	}
+}
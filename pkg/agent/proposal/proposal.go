@@ -0,0 +1,148 @@
+package proposal
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/reconciler"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// default prefix of the branches proposals are committed to
+const defaultBranchPrefix = "tornjak/proposal-"
+
+type Config struct {
+	// path of a git clone the proposals are committed in
+	RepoPath string
+	// path of the desired-state document within the repository
+	File string
+	// branch proposals are based on
+	BaseBranch string
+	// prefix of the branch name of each proposal
+	BranchPrefix string
+	// remote proposal branches are pushed to, not pushed if empty
+	Remote string
+	// source of the time branches are named after, the clock of the system if nil
+	Clock clock.Clock
+}
+
+// Proposer commits changes as desired-state documents to git branches,
+// so they are reviewed before they are applied by the desired-state reconciler
+type Proposer struct {
+	config Config
+	clock  clock.Clock
+
+	// git operations share the working tree of the clone
+	mu sync.Mutex
+}
+
+func New(config Config) (*Proposer, error) {
+	if config.RepoPath == "" || config.File == "" || config.BaseBranch == "" {
+		return nil, errors.New("repository path, file and base branch must be set")
+	}
+	if filepath.IsAbs(config.File) || strings.HasPrefix(filepath.Clean(config.File), "..") {
+		return nil, errors.Errorf("file %q must be relative to the repository", config.File)
+	}
+	if config.BranchPrefix == "" {
+		config.BranchPrefix = defaultBranchPrefix
+	}
+	p := &Proposer{config: config, clock: clock.OrNew(config.Clock)}
+	if _, err := p.git(context.Background(), "rev-parse", "--git-dir"); err != nil {
+		return nil, errors.Errorf("%s is not a git repository: %v", config.RepoPath, err)
+	}
+	return p, nil
+}
+
+// Propose commits the desired state to a new branch created from the base
+// branch and pushes it if a remote is configured
+func (p *Proposer) Propose(ctx context.Context, state types.DesiredState, summary string, author string) (types.ChangeProposal, error) {
+	data, err := reconciler.RenderDesiredState(state)
+	if err != nil {
+		return types.ChangeProposal{}, err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return types.ChangeProposal{}, errors.Errorf("could not name proposal branch: %v", err)
+	}
+	now := p.clock.Now().UTC()
+	proposal := types.ChangeProposal{
+		Branch:       p.config.BranchPrefix + now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix),
+		File:         p.config.File,
+		Summary:      summary,
+		CreatedBy:    author,
+		CreationTime: now.Format(time.RFC3339),
+	}
+	if author == "" {
+		author = "tornjak"
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	base := p.config.BaseBranch
+	if p.config.Remote != "" {
+		if _, err := p.git(ctx, "fetch", p.config.Remote, base); err != nil {
+			return types.ChangeProposal{}, err
+		}
+		base = p.config.Remote + "/" + base
+	}
+	if _, err := p.git(ctx, "checkout", "-q", "-f", "-B", proposal.Branch, base); err != nil {
+		return types.ChangeProposal{}, err
+	}
+	// leave the clone on the base branch for the next proposal
+	defer p.git(context.Background(), "checkout", "-q", "-f", "--detach", base) //nolint:errcheck
+
+	file := filepath.Join(p.config.RepoPath, p.config.File)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return types.ChangeProposal{}, errors.Errorf("could not write %s: %v", p.config.File, err)
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return types.ChangeProposal{}, errors.Errorf("could not write %s: %v", p.config.File, err)
+	}
+	if _, err := p.git(ctx, "add", "--", p.config.File); err != nil {
+		return types.ChangeProposal{}, err
+	}
+	if _, err := p.git(ctx, "diff", "--cached", "--quiet"); err == nil {
+		return types.ChangeProposal{}, errors.New("proposal does not change the desired state")
+	}
+	_, err = p.git(ctx, "-c", "user.name="+author, "-c", "user.email=tornjak@localhost",
+		"commit", "-q", "-m", summary)
+	if err != nil {
+		return types.ChangeProposal{}, err
+	}
+	proposal.Commit, err = p.git(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return types.ChangeProposal{}, err
+	}
+
+	if p.config.Remote != "" {
+		if _, err := p.git(ctx, "push", "-q", p.config.Remote, proposal.Branch); err != nil {
+			return types.ChangeProposal{}, err
+		}
+		proposal.Pushed = true
+	}
+	return proposal, nil
+}
+
+// git runs a git command in the repository and returns its trimmed output
+func (p *Proposer) git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", p.config.RepoPath}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,101 @@
+package proposal
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spiffe/tornjak/pkg/agent/reconciler"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// runGit runs git in dir and fails the test on error
+func runGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@localhost"}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestPropose(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	ctx := context.Background()
+
+	// remote repository with a base branch, and a clone of it
+	remote := filepath.Join(t.TempDir(), "remote")
+	runGit(t, t.TempDir(), "init", "-q", "--bare", "-b", "main", remote)
+	clone := filepath.Join(t.TempDir(), "clone")
+	runGit(t, t.TempDir(), "clone", "-q", remote, clone)
+	runGit(t, clone, "commit", "-q", "--allow-empty", "-m", "initial")
+	runGit(t, clone, "push", "-q", "origin", "main")
+
+	// CHECK invalid configuration [New]
+	if _, err := New(Config{RepoPath: t.TempDir(), File: "state.yaml", BaseBranch: "main"}); err == nil {
+		t.Fatal("Expected error for directory that is not a git repository")
+	}
+	if _, err := New(Config{RepoPath: clone, File: "../state.yaml", BaseBranch: "main"}); err == nil {
+		t.Fatal("Expected error for file outside of the repository")
+	}
+
+	p, err := New(Config{RepoPath: clone, File: "tornjak/state.yaml", BaseBranch: "main", Remote: "origin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := types.DesiredState{
+		Prune: true,
+		Clusters: []types.ClusterInfo{
+			{Name: "prod-east", PlatformType: "Kubernetes", OwnerTeam: "platform"},
+		},
+	}
+
+	// ATTEMPT propose change [Propose]
+	proposal, err := p.Propose(ctx, state, "Create cluster prod-east", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK branch pushed with rendered document [Propose]
+	if !proposal.Pushed || !strings.HasPrefix(proposal.Branch, defaultBranchPrefix) || proposal.CreatedBy != "alice" {
+		t.Fatalf("Unexpected proposal %+v", proposal)
+	}
+	if got := runGit(t, remote, "rev-parse", proposal.Branch); got != proposal.Commit {
+		t.Fatalf("Expected remote branch at %s, got %s", proposal.Commit, got)
+	}
+	doc := runGit(t, remote, "show", proposal.Branch+":tornjak/state.yaml")
+	parsed, err := reconciler.ParseDesiredState([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Prune || len(parsed.Clusters) != 1 || parsed.Clusters[0].OwnerTeam != "platform" {
+		t.Fatalf("Unexpected proposed state %+v", parsed)
+	}
+	if strings.Contains(doc, "editedName") {
+		t.Fatalf("Unset fields should not be rendered:\n%s", doc)
+	}
+	if got := runGit(t, remote, "log", "-1", "--format=%an %s", proposal.Branch); got != "alice Create cluster prod-east" {
+		t.Fatalf("Unexpected commit %q", got)
+	}
+
+	// CHECK base branch unchanged [Propose]
+	if _, err := os.Stat(filepath.Join(clone, "tornjak/state.yaml")); !os.IsNotExist(err) {
+		t.Fatal("Clone should be left on the base branch")
+	}
+
+	// CHECK proposals are based on the base branch [Propose]
+	second, err := p.Propose(ctx, state, "Create cluster prod-east again", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Branch == proposal.Branch {
+		t.Fatal("Expected a new branch per proposal")
+	}
+	if got := runGit(t, remote, "rev-parse", second.Branch+"^"); got != runGit(t, remote, "rev-parse", "main") {
+		t.Fatal("Expected proposal based on main")
+	}
+}
@@ -0,0 +1,423 @@
+package reconciler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"path"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/invopop/yaml"
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// Store is the part of the Tornjak DB the reconciler writes to
+type Store interface {
+	GetClusters() (types.ClusterInfoList, error)
+	CreateClusterEntry(cinfo types.ClusterInfo) error
+	EditClusterEntry(cinfo types.ClusterInfo) (types.ClusterEditResult, error)
+	DeleteClusterEntry(name string) error
+}
+
+// AgentLister returns the SPIFFE IDs of the agents of the SPIRE server
+type AgentLister func(ctx context.Context) ([]string, error)
+
+type Config struct {
+	Source Source
+	Store  Store
+	// lists the agents classified by agent rules
+	ListAgents AgentLister
+	// checks a cluster before it is written, may be nil
+	Validate func(types.ClusterInfo) error
+	// time between two reconciliations
+	Interval time.Duration
+	// only report drift without writing to the DB
+	DryRun bool
+	// source of time, the clock of the system if nil
+	Clock clock.Clock
+}
+
+// Reconciler periodically reconciles the clusters in the Tornjak DB
+// towards a desired-state document
+type Reconciler struct {
+	config Config
+	clock  clock.Clock
+
+	mu     sync.Mutex
+	report *types.DesiredStateReport
+}
+
+func New(config Config) *Reconciler {
+	return &Reconciler{config: config, clock: clock.OrNew(config.Clock)}
+}
+
+// ParseDesiredState parses a desired-state document in YAML or JSON
+func ParseDesiredState(data []byte) (types.DesiredState, error) {
+	var state types.DesiredState
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return state, errors.Errorf("could not parse desired state: %v", err)
+	}
+	if err := json.Unmarshal(jsonData, &state); err != nil {
+		return state, errors.Errorf("could not parse desired state: %v", err)
+	}
+
+	clusters := map[string]bool{}
+	agents := map[string]string{}
+	for _, cluster := range state.Clusters {
+		if len(cluster.Name) == 0 || len(cluster.PlatformType) == 0 {
+			return state, errors.New("desired cluster missing mandatory field - Name or PlatformType")
+		}
+		if clusters[cluster.Name] {
+			return state, errors.Errorf("duplicate desired cluster %q", cluster.Name)
+		}
+		clusters[cluster.Name] = true
+		for _, agent := range cluster.AgentsList {
+			if other, ok := agents[agent]; ok {
+				return state, errors.Errorf("agent %q listed in clusters %q and %q", agent, other, cluster.Name)
+			}
+			agents[agent] = cluster.Name
+		}
+	}
+	for _, rule := range state.AgentRules {
+		if _, err := path.Match(rule.Match, ""); err != nil || len(rule.Match) == 0 {
+			return state, errors.Errorf("invalid agent rule pattern %q", rule.Match)
+		}
+		if !clusters[rule.Cluster] {
+			return state, errors.Errorf("agent rule %q assigns to unknown cluster %q", rule.Match, rule.Cluster)
+		}
+	}
+	return state, nil
+}
+
+// DesiredState loads and parses the current desired-state document
+func (r *Reconciler) DesiredState(ctx context.Context) (types.DesiredState, error) {
+	data, err := r.config.Source.Load(ctx)
+	if err != nil {
+		return types.DesiredState{}, err
+	}
+	return ParseDesiredState(data)
+}
+
+// Run reconciles every interval until ctx is done
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := r.clock.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+	for {
+		report := r.Reconcile(ctx)
+		for _, emsg := range report.Errors {
+			log.Printf("WARNING: desired state %s: %s", report.Source, emsg)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// Report returns the report of the last reconciliation
+// returns false if there was none yet
+func (r *Reconciler) Report() (types.DesiredStateReport, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.report == nil {
+		return types.DesiredStateReport{}, false
+	}
+	return *r.report, true
+}
+
+// Reconcile compares the DB with the desired state once and, unless
+// configured for dry runs, writes the differences to the DB
+func (r *Reconciler) Reconcile(ctx context.Context) types.DesiredStateReport {
+	report := types.DesiredStateReport{
+		Source:    r.config.Source.String(),
+		DryRun:    r.config.DryRun,
+		CheckedAt: r.clock.Now().UTC().Format(time.RFC3339),
+		Drift:     []types.DesiredStateDrift{},
+	}
+	if err := r.reconcile(ctx, &report); err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	}
+	report.InSync = len(report.Drift) == 0 && len(report.Errors) == 0
+
+	r.mu.Lock()
+	r.report = &report
+	r.mu.Unlock()
+	return report
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, report *types.DesiredStateReport) error {
+	state, err := r.DesiredState(ctx)
+	if err != nil {
+		return err
+	}
+	desired, err := r.desiredClusters(ctx, state)
+	if err != nil {
+		return err
+	}
+	currentList, err := r.config.Store.GetClusters()
+	if err != nil {
+		return errors.Errorf("could not get clusters: %v", err)
+	}
+	current := map[string]types.ClusterInfo{}
+	for _, cluster := range currentList.Clusters {
+		current[cluster.Name] = cluster
+	}
+
+	// compute drift in the order of the desired state, then pruned clusters
+	for _, cluster := range desired {
+		existing, ok := current[cluster.Name]
+		if !ok {
+			report.Drift = append(report.Drift, types.DesiredStateDrift{Cluster: cluster.Name, Action: "create"})
+		} else if fields := clusterDiff(existing, cluster); len(fields) > 0 {
+			report.Drift = append(report.Drift, types.DesiredStateDrift{Cluster: cluster.Name, Action: "update", Fields: fields})
+		}
+	}
+	if state.Prune {
+		for _, cluster := range currentList.Clusters {
+			if _, ok := desiredByName(desired, cluster.Name); !ok {
+				report.Drift = append(report.Drift, types.DesiredStateDrift{Cluster: cluster.Name, Action: "delete"})
+			}
+		}
+	}
+	if r.config.DryRun {
+		return nil
+	}
+
+	// invalid clusters are not written at all
+	failed := map[string]bool{}
+	if r.config.Validate != nil {
+		for _, drift := range report.Drift {
+			if drift.Action == "delete" {
+				continue
+			}
+			cluster, _ := desiredByName(desired, drift.Cluster)
+			if err := r.config.Validate(cluster); err != nil {
+				failed[drift.Cluster] = true
+				report.Errors = append(report.Errors, errors.Errorf("could not %s cluster %q: %v", drift.Action, drift.Cluster, err).Error())
+			}
+		}
+	}
+
+	// first remove clusters and agents that leave a cluster, so agents moved
+	// between clusters are free when they are added to their new cluster
+	for _, drift := range report.Drift {
+		if failed[drift.Cluster] {
+			continue
+		}
+		var err error
+		switch drift.Action {
+		case "delete":
+			err = r.config.Store.DeleteClusterEntry(drift.Cluster)
+		case "update":
+			cluster, _ := desiredByName(desired, drift.Cluster)
+			existing := current[drift.Cluster]
+			kept := intersect(existing.AgentsList, cluster.AgentsList)
+			if len(kept) != len(existing.AgentsList) {
+				existing.EditedName = existing.Name
+				existing.AgentsList = kept
+				_, err = r.config.Store.EditClusterEntry(existing)
+			}
+		}
+		if err != nil {
+			failed[drift.Cluster] = true
+			report.Errors = append(report.Errors, errors.Errorf("could not %s cluster %q: %v", drift.Action, drift.Cluster, err).Error())
+		}
+	}
+
+	for i, drift := range report.Drift {
+		if failed[drift.Cluster] {
+			continue
+		}
+		var err error
+		switch drift.Action {
+		case "delete":
+			// deleted above
+		case "create", "update":
+			cluster, _ := desiredByName(desired, drift.Cluster)
+			if drift.Action == "create" {
+				err = r.config.Store.CreateClusterEntry(cluster)
+			} else {
+				cluster.EditedName = cluster.Name
+				_, err = r.config.Store.EditClusterEntry(cluster)
+			}
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, errors.Errorf("could not %s cluster %q: %v", drift.Action, drift.Cluster, err).Error())
+			continue
+		}
+		report.Drift[i].Applied = true
+	}
+	return nil
+}
+
+// desiredClusters returns the desired clusters with the agents assigned by
+// the agent rules added to their agent lists
+func (r *Reconciler) desiredClusters(ctx context.Context, state types.DesiredState) ([]types.ClusterInfo, error) {
+	clusters := make([]types.ClusterInfo, len(state.Clusters))
+	index := map[string]int{}
+	listed := map[string]bool{}
+	for i, cluster := range state.Clusters {
+		cluster.AgentsList = append([]string{}, cluster.AgentsList...)
+		clusters[i] = cluster
+		index[cluster.Name] = i
+		for _, agent := range cluster.AgentsList {
+			listed[agent] = true
+		}
+	}
+	if len(state.AgentRules) == 0 {
+		return clusters, nil
+	}
+	if r.config.ListAgents == nil {
+		return nil, errors.New("agent rules given but agents cannot be listed")
+	}
+	agents, err := r.config.ListAgents(ctx)
+	if err != nil {
+		return nil, errors.Errorf("could not list agents: %v", err)
+	}
+	for _, agent := range agents {
+		// explicitly listed agents are not classified
+		if listed[agent] {
+			continue
+		}
+		for _, rule := range state.AgentRules {
+			if ok, _ := path.Match(rule.Match, agent); ok {
+				i := index[rule.Cluster]
+				clusters[i].AgentsList = append(clusters[i].AgentsList, agent)
+				break
+			}
+		}
+	}
+	return clusters, nil
+}
+
+// clusterDiff returns the names of the fields in which the clusters differ
+func clusterDiff(current, desired types.ClusterInfo) []string {
+	fields := []string{}
+	if current.DomainName != desired.DomainName {
+		fields = append(fields, "domainName")
+	}
+	if current.ManagedBy != desired.ManagedBy {
+		fields = append(fields, "managedBy")
+	}
+	if current.PlatformType != desired.PlatformType {
+		fields = append(fields, "platformType")
+	}
+	if current.OwnerEmail != desired.OwnerEmail {
+		fields = append(fields, "ownerEmail")
+	}
+	if current.OwnerTeam != desired.OwnerTeam {
+		fields = append(fields, "ownerTeam")
+	}
+	if current.SlackChannel != desired.SlackChannel {
+		fields = append(fields, "slackChannel")
+	}
+	if current.Tenant != desired.Tenant {
+		fields = append(fields, "tenant")
+	}
+	if (len(current.Extensions) > 0 || len(desired.Extensions) > 0) && !reflect.DeepEqual(current.Extensions, desired.Extensions) {
+		fields = append(fields, "extensions")
+	}
+	if !types.MetadataEqual(current.Metadata, desired.Metadata) {
+		fields = append(fields, "metadata")
+	}
+	if (len(current.Labels) > 0 || len(desired.Labels) > 0) && !reflect.DeepEqual(current.Labels, desired.Labels) {
+		fields = append(fields, "labels")
+	}
+	if !sameAgents(current.AgentsList, desired.AgentsList) {
+		fields = append(fields, "agentsList")
+	}
+	return fields
+}
+
+func sameAgents(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return reflect.DeepEqual(a, b)
+}
+
+// intersect returns the elements of a that are also in b
+func intersect(a, b []string) []string {
+	inB := map[string]bool{}
+	for _, s := range b {
+		inB[s] = true
+	}
+	ret := []string{}
+	for _, s := range a {
+		if inB[s] {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+func desiredByName(clusters []types.ClusterInfo, name string) (types.ClusterInfo, bool) {
+	for _, cluster := range clusters {
+		if cluster.Name == name {
+			return cluster, true
+		}
+	}
+	return types.ClusterInfo{}, false
+}
+
+// RenderDesiredState renders a desired-state document as YAML
+// fields that are not set are left out
+func RenderDesiredState(state types.DesiredState) ([]byte, error) {
+	clusters := []map[string]interface{}{}
+	for _, cluster := range state.Clusters {
+		// names are given by name, creation and change times and UIDs are set by the DB
+		cluster.EditedName = ""
+		cluster.UID = ""
+		data, err := json.Marshal(cluster)
+		if err != nil {
+			return nil, errors.Errorf("could not render desired state: %v", err)
+		}
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, errors.Errorf("could not render desired state: %v", err)
+		}
+		delete(fields, "creationTime")
+		delete(fields, "updatedAt")
+		for name, value := range fields {
+			switch v := value.(type) {
+			case nil:
+				delete(fields, name)
+			case string:
+				if v == "" {
+					delete(fields, name)
+				}
+			case []interface{}:
+				if len(v) == 0 {
+					delete(fields, name)
+				}
+			}
+		}
+		clusters = append(clusters, fields)
+	}
+
+	doc := map[string]interface{}{
+		"prune":    state.Prune,
+		"clusters": clusters,
+	}
+	if len(state.AgentRules) > 0 {
+		doc["agentRules"] = state.AgentRules
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, errors.Errorf("could not render desired state: %v", err)
+	}
+	return data, nil
+}
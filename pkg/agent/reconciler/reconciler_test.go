@@ -0,0 +1,184 @@
+package reconciler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	backoff "github.com/cenkalti/backoff/v4"
+
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+const desiredDoc = `
+prune: true
+clusters:
+  - name: prod-east
+    platformType: Kubernetes
+    ownerTeam: platform
+    agentsList: ["spiffe://example.org/agent/static"]
+  - name: prod-west
+    platformType: Kubernetes
+agentRules:
+  - match: "spiffe://example.org/agent/east-*"
+    cluster: prod-east
+  - match: "spiffe://example.org/agent/*"
+    cluster: prod-west
+`
+
+func writeDoc(t *testing.T, file string, doc string) {
+	if err := os.WriteFile(file, []byte(doc), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getCluster(t *testing.T, db agentdb.AgentDB, name string) (types.ClusterInfo, bool) {
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cluster := range clusters.Clusters {
+		if cluster.Name == name {
+			return cluster, true
+		}
+	}
+	return types.ClusterInfo{}, false
+}
+
+func TestReconciler(t *testing.T) {
+	db, err := agentdb.NewInMemoryDB(backoff.NewExponentialBackOff(), agentdb.SqliteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	docFile := filepath.Join(t.TempDir(), "desired.yaml")
+	writeDoc(t, docFile, desiredDoc)
+
+	agents := []string{
+		"spiffe://example.org/agent/east-1",
+		"spiffe://example.org/agent/west-1",
+	}
+	listAgents := func(ctx context.Context) ([]string, error) {
+		return agents, nil
+	}
+
+	// unmanaged cluster holding an agent classified into another cluster
+	err = db.CreateClusterEntry(types.ClusterInfo{
+		Name:         "legacy",
+		PlatformType: "VMs",
+		AgentsList:   []string{"spiffe://example.org/agent/east-1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT dry run [Reconcile]
+	dryRun := New(Config{Source: NewFileSource(docFile), Store: db, ListAgents: listAgents, DryRun: true, Interval: time.Minute})
+	report := dryRun.Reconcile(context.Background())
+	if len(report.Errors) > 0 {
+		t.Fatalf("Unexpected errors %v", report.Errors)
+	}
+	// CHECK drift reported but not applied [Reconcile]
+	if report.InSync || len(report.Drift) != 3 {
+		t.Fatalf("Expected drift for 3 clusters, got %+v", report.Drift)
+	}
+	for _, drift := range report.Drift {
+		if drift.Applied {
+			t.Fatalf("Dry run should not apply drift %+v", drift)
+		}
+	}
+	if _, ok := getCluster(t, db, "prod-east"); ok {
+		t.Fatal("Dry run should not create clusters")
+	}
+
+	// ATTEMPT reconcile [Reconcile]
+	r := New(Config{Source: NewFileSource(docFile), Store: db, ListAgents: listAgents, Interval: time.Minute})
+	report = r.Reconcile(context.Background())
+	if len(report.Errors) > 0 {
+		t.Fatalf("Unexpected errors %v", report.Errors)
+	}
+	// CHECK clusters created, agents classified and unmanaged cluster pruned [Reconcile]
+	east, ok := getCluster(t, db, "prod-east")
+	if !ok || east.OwnerTeam != "platform" || !sameAgents(east.AgentsList, []string{"spiffe://example.org/agent/static", "spiffe://example.org/agent/east-1"}) {
+		t.Fatalf("Unexpected cluster prod-east %+v", east)
+	}
+	west, ok := getCluster(t, db, "prod-west")
+	if !ok || !sameAgents(west.AgentsList, []string{"spiffe://example.org/agent/west-1"}) {
+		t.Fatalf("Unexpected cluster prod-west %+v", west)
+	}
+	if _, ok := getCluster(t, db, "legacy"); ok {
+		t.Fatal("Cluster not in desired state should be pruned")
+	}
+	stored, ok := r.Report()
+	if !ok || stored.CheckedAt != report.CheckedAt {
+		t.Fatal("Expected last report to be stored")
+	}
+
+	// CHECK no drift once reconciled [Reconcile]
+	report = r.Reconcile(context.Background())
+	if !report.InSync {
+		t.Fatalf("Expected desired state in sync, got %+v", report)
+	}
+
+	// ATTEMPT move agent between clusters [Reconcile]
+	writeDoc(t, docFile, strings.Replace(desiredDoc, "east-*", "none-*", 1))
+	report = r.Reconcile(context.Background())
+	if len(report.Errors) > 0 {
+		t.Fatalf("Unexpected errors %v", report.Errors)
+	}
+	if len(report.Drift) != 2 || report.Drift[0].Fields[0] != "agentsList" {
+		t.Fatalf("Expected agentsList drift for 2 clusters, got %+v", report.Drift)
+	}
+	west, _ = getCluster(t, db, "prod-west")
+	if !sameAgents(west.AgentsList, agents) {
+		t.Fatalf("Expected agents moved to prod-west, got %v", west.AgentsList)
+	}
+
+	// CHECK invalid clusters are not written [Reconcile]
+	writeDoc(t, docFile, strings.Replace(desiredDoc, "ownerTeam: platform", "ownerTeam: other", 1))
+	r = New(Config{
+		Source:     NewFileSource(docFile),
+		Store:      db,
+		ListAgents: listAgents,
+		Validate: func(c types.ClusterInfo) error {
+			if c.OwnerTeam == "other" {
+				return os.ErrInvalid
+			}
+			return nil
+		},
+		Interval: time.Minute,
+	})
+	report = r.Reconcile(context.Background())
+	if len(report.Errors) != 1 || report.InSync {
+		t.Fatalf("Expected one validation error, got %v", report.Errors)
+	}
+	east, _ = getCluster(t, db, "prod-east")
+	if east.OwnerTeam != "platform" || len(east.AgentsList) != 1 {
+		t.Fatalf("Invalid cluster should be unchanged, got %+v", east)
+	}
+}
+
+func TestParseDesiredState(t *testing.T) {
+	// CHECK JSON documents [ParseDesiredState]
+	state, err := ParseDesiredState([]byte(`{"clusters": [{"name": "a", "platformType": "VMs"}]}`))
+	if err != nil || len(state.Clusters) != 1 {
+		t.Fatalf("Unexpected result %+v, %v", state, err)
+	}
+
+	invalid := []string{
+		"clusters: [{name: a}]",
+		"clusters: [{name: a, platformType: VMs}, {name: a, platformType: VMs}]",
+		"clusters: [{name: a, platformType: VMs, agentsList: [x]}, {name: b, platformType: VMs, agentsList: [x]}]",
+		"clusters: [{name: a, platformType: VMs}]\nagentRules: [{match: '[', cluster: a}]",
+		"clusters: [{name: a, platformType: VMs}]\nagentRules: [{match: '*', cluster: b}]",
+		"clusters: {",
+	}
+	for _, doc := range invalid {
+		if _, err := ParseDesiredState([]byte(doc)); err == nil {
+			t.Fatalf("Expected error for document %q", doc)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package reconciler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// maximum size of a desired-state document
+const maxDocumentBytes = 10 << 20
+
+// Source provides the desired-state document
+type Source interface {
+	// Load returns the current content of the document
+	Load(ctx context.Context) ([]byte, error)
+	// String describes the source in reports
+	String() string
+}
+
+type fileSource struct {
+	path string
+}
+
+// NewFileSource returns a Source reading the document from a file,
+// such as a mounted ConfigMap
+func NewFileSource(path string) Source {
+	return fileSource{path: path}
+}
+
+func (f fileSource) Load(_ context.Context) ([]byte, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, errors.Errorf("could not read desired state: %v", err)
+	}
+	return data, nil
+}
+
+func (f fileSource) String() string {
+	return "file:" + f.path
+}
+
+type urlSource struct {
+	url    string
+	client *http.Client
+}
+
+// NewURLSource returns a Source fetching the document over HTTP(S),
+// such as the raw file URL of a git repository
+func NewURLSource(url string, client *http.Client) Source {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return urlSource{url: url, client: client}
+}
+
+func (u urlSource) Load(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.url, nil)
+	if err != nil {
+		return nil, errors.Errorf("could not fetch desired state: %v", err)
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, errors.Errorf("could not fetch desired state: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("could not fetch desired state: status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentBytes+1))
+	if err != nil {
+		return nil, errors.Errorf("could not fetch desired state: %v", err)
+	}
+	if len(data) > maxDocumentBytes {
+		return nil, errors.Errorf("desired state larger than %d bytes", maxDocumentBytes)
+	}
+	return data, nil
+}
+
+func (u urlSource) String() string {
+	return u.url
+}
@@ -0,0 +1,107 @@
+package redaction
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Mask replaces redacted values
+const Mask = "[REDACTED]"
+
+// builtinPaths are redacted in addition to configured paths, covering
+// credentials accepted or returned by the Tornjak API; configuration cannot
+// remove them
+var builtinPaths = []string{
+	"**.apiKey",
+	"**.token",
+	"**.password",
+	"**.secret",
+}
+
+// Redactor masks the values at configured JSON paths
+//
+// a path is a dot-separated list of object keys, where "*" matches any key
+// or array element and a leading "**" matches at any depth, e.g.
+// "entries.*.selectors" or "**.apiKey"
+type Redactor struct {
+	paths [][]string
+}
+
+// NewRedactor returns a Redactor for the built-in paths and the given paths
+func NewRedactor(paths []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, path := range append(append([]string{}, builtinPaths...), paths...) {
+		segments := strings.Split(path, ".")
+		for i, segment := range segments {
+			if segment == "" || (segment == "**" && i != 0) {
+				return nil, errors.Errorf("invalid redaction path %q", path)
+			}
+		}
+		if len(segments) == 1 && segments[0] == "**" {
+			return nil, errors.Errorf("invalid redaction path %q", path)
+		}
+		r.paths = append(r.paths, segments)
+	}
+	return r, nil
+}
+
+// Redact returns body with all values at redacted paths masked
+// bodies that are not JSON are returned unchanged
+func (r *Redactor) Redact(body []byte) []byte {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return body
+	}
+	for _, path := range r.paths {
+		if path[0] == "**" {
+			value = redactAnyDepth(value, path[1:])
+		} else {
+			value = redactPath(value, path)
+		}
+	}
+	redacted, err := json.Marshal(value)
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+// redactPath masks the values at path relative to value
+func redactPath(value interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return Mask
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if path[0] == "*" || path[0] == key {
+				v[key] = redactPath(child, path[1:])
+			}
+		}
+	case []interface{}:
+		if path[0] == "*" {
+			for i, child := range v {
+				v[i] = redactPath(child, path[1:])
+			}
+		}
+	}
+	return value
+}
+
+// redactAnyDepth masks the values at path relative to value or any value nested in it
+func redactAnyDepth(value interface{}, path []string) interface{} {
+	value = redactPath(value, path)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = redactAnyDepth(child, path)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactAnyDepth(child, path)
+		}
+	}
+	return value
+}
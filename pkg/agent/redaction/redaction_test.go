@@ -0,0 +1,55 @@
+package redaction
+
+import (
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	r, err := NewRedactor([]string{"entries.*.selectors", "cluster.ownerEmail"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "default paths at any depth",
+			body: `{"serviceAccount":{"name":"ci"},"apiKey":"tjk_1","nested":[{"token":"t"}]}`,
+			want: `{"apiKey":"[REDACTED]","nested":[{"token":"[REDACTED]"}],"serviceAccount":{"name":"ci"}}`,
+		},
+		{
+			name: "wildcard over array elements",
+			body: `{"entries":[{"id":"1","selectors":[{"type":"unix","value":"uid:0"}]},{"id":"2"}]}`,
+			want: `{"entries":[{"id":"1","selectors":"[REDACTED]"},{"id":"2"}]}`,
+		},
+		{
+			name: "exact path only at root",
+			body: `{"cluster":{"name":"c","ownerEmail":"a@b.c"},"other":{"cluster":{"ownerEmail":"x"}}}`,
+			want: `{"cluster":{"name":"c","ownerEmail":"[REDACTED]"},"other":{"cluster":{"ownerEmail":"x"}}}`,
+		},
+		{
+			name: "non-JSON unchanged",
+			body: `SUCCESS`,
+			want: `SUCCESS`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(r.Redact([]byte(tt.body)))
+			if got != tt.want {
+				t.Fatalf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewRedactorInvalidPath(t *testing.T) {
+	for _, path := range []string{"", "a..b", "a.**.b", "**"} {
+		if _, err := NewRedactor([]string{path}); err == nil {
+			t.Fatalf("Expected error for path %q", path)
+		}
+	}
+}
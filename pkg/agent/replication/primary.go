@@ -0,0 +1,99 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/authentication/authenticator"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// maximum size of a response of the primary
+const maxResponseBytes = 64 << 20
+
+// Primary is the Tornjak instance whose clusters are replicated
+type Primary interface {
+	// Snapshot returns the clusters of the primary and the ID of the latest
+	// entry of its history of clusters
+	Snapshot(ctx context.Context) (types.ReplicationSnapshot, error)
+	// Changes returns up to limit entries of the history of clusters of the
+	// primary following the entry with ID after, oldest first
+	Changes(ctx context.Context, after int64, limit int) (types.ClusterChangeLog, error)
+	// String describes the primary in the status
+	String() string
+}
+
+type httpPrimary struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// NewHTTPPrimary returns the Primary served by the Tornjak backend at url,
+// e.g. https://tornjak-primary.example.org:10443
+// apiKey is sent as the API key of a service account if not empty
+func NewHTTPPrimary(url string, apiKey string, client *http.Client) Primary {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return httpPrimary{url: strings.TrimSuffix(url, "/"), apiKey: apiKey, client: client}
+}
+
+func (p httpPrimary) Snapshot(ctx context.Context) (types.ReplicationSnapshot, error) {
+	var snapshot types.ReplicationSnapshot
+	err := p.get(ctx, "/api/v1/tornjak/replication/snapshot", nil, &snapshot)
+	return snapshot, err
+}
+
+func (p httpPrimary) Changes(ctx context.Context, after int64, limit int) (types.ClusterChangeLog, error) {
+	var log types.ClusterChangeLog
+	query := url.Values{}
+	query.Set("after", fmt.Sprint(after))
+	query.Set("limit", fmt.Sprint(limit))
+	err := p.get(ctx, "/api/v1/tornjak/replication/changes", query, &log)
+	return log, err
+}
+
+func (p httpPrimary) get(ctx context.Context, path string, query url.Values, ret interface{}) error {
+	target := p.url + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return errors.Errorf("could not reach primary: %v", err)
+	}
+	if p.apiKey != "" {
+		req.Header.Set(authenticator.APIKeyHeader, p.apiKey)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Errorf("could not reach primary: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes+1))
+	if err != nil {
+		return errors.Errorf("could not read response of primary: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("primary returned status %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	if len(data) > maxResponseBytes {
+		return errors.Errorf("response of primary larger than %d bytes", maxResponseBytes)
+	}
+	if err = json.Unmarshal(data, ret); err != nil {
+		return errors.Errorf("invalid response of primary: %v", err)
+	}
+	return nil
+}
+
+func (p httpPrimary) String() string {
+	return p.url
+}
@@ -0,0 +1,385 @@
+package replication
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// number of conflicts kept in the status
+const maxRecentConflicts = 20
+
+// Store is the part of the Tornjak DB of the standby the replicator writes to
+type Store interface {
+	GetClusters() (types.ClusterInfoList, error)
+	CreateClusterEntry(cinfo types.ClusterInfo) error
+	EditClusterEntry(cinfo types.ClusterInfo) (types.ClusterEditResult, error)
+	DeleteClusterEntry(name string) error
+	SetClusterProtection(name string, protected bool) error
+}
+
+type Config struct {
+	Primary Primary
+	Store   Store
+	// time between two polls of the primary
+	Interval time.Duration
+	// entries of the history read per request
+	BatchSize int
+	// one of types.ReplicationPrimaryWins or types.ReplicationStandbyWins
+	ConflictPolicy string
+	// source of time, the clock of the system if nil
+	Clock clock.Clock
+}
+
+// Replicator copies the changes of clusters of a primary Tornjak to the DB of
+// a standby, asynchronously, by polling the history of clusters of the primary
+// clusters are matched by name, as their UIDs differ between the two DBs
+type Replicator struct {
+	config Config
+	clock  clock.Clock
+
+	// serializes syncs, and guards state and replicated
+	syncMu sync.Mutex
+	state  types.ReplicationStatus
+	// state of each cluster as last replicated, by UID on the primary, to
+	// detect changes made on the standby; nil until bootstrapped
+	replicated map[string]types.ClusterInfo
+
+	// copy of state published for Status, so it does not wait for syncs
+	mu     sync.Mutex
+	status types.ReplicationStatus
+}
+
+func New(config Config) *Replicator {
+	if config.ConflictPolicy == "" {
+		config.ConflictPolicy = types.ReplicationPrimaryWins
+	}
+	r := &Replicator{
+		config: config,
+		clock:  clock.OrNew(config.Clock),
+		state: types.ReplicationStatus{
+			Primary:         config.Primary.String(),
+			ConflictPolicy:  config.ConflictPolicy,
+			RecentConflicts: []types.ReplicationConflict{},
+		},
+	}
+	r.publish()
+	return r
+}
+
+// Run replicates every interval until ctx is done
+func (r *Replicator) Run(ctx context.Context) {
+	ticker := r.clock.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+	for {
+		if err := r.Sync(ctx); err != nil {
+			log.Printf("WARNING: replication from %s: %v", r.config.Primary, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// Status returns the progress of the replication as of the last applied batch
+func (r *Replicator) Status() types.ReplicationStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// publish makes the current state the status
+func (r *Replicator) publish() {
+	status := r.state
+	status.RecentConflicts = append([]types.ReplicationConflict{}, r.state.RecentConflicts...)
+	r.mu.Lock()
+	r.status = status
+	r.mu.Unlock()
+}
+
+// Sync applies the changes of the primary not applied yet, copying all its
+// clusters first if the standby was not bootstrapped
+// a change that cannot be applied stops the sync, and is retried by the next
+func (r *Replicator) Sync(ctx context.Context) error {
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+	defer r.publish()
+	err := r.sync(ctx)
+	if err != nil {
+		r.state.LastError = err.Error()
+		return err
+	}
+	r.state.LastError = ""
+	r.state.LastSyncAt = r.clock.Now().UTC().Format(time.RFC3339)
+	return nil
+}
+
+func (r *Replicator) sync(ctx context.Context) error {
+	if r.replicated == nil {
+		if err := r.bootstrap(ctx); err != nil {
+			return err
+		}
+	}
+	local, err := r.localClusters()
+	if err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		changes, err := r.config.Primary.Changes(ctx, r.state.AppliedID, r.config.BatchSize)
+		if err != nil {
+			return err
+		}
+		r.state.PrimaryLatestID = changes.LatestID
+		// the history of the primary restarted, e.g. from a restored snapshot
+		if changes.LatestID < r.state.AppliedID {
+			r.replicated = nil
+			return r.bootstrap(ctx)
+		}
+		for _, entry := range changes.Entries {
+			r.setLag(changes.LatestID, entry.ChangedAt)
+			if err = r.apply(entry, local); err != nil {
+				return errors.Errorf("could not apply change %d of cluster %q: %v", entry.ID, entry.Name, err)
+			}
+			r.state.AppliedID = entry.ID
+			r.state.AppliedEntries++
+		}
+		r.publish()
+		if len(changes.Entries) < r.config.BatchSize {
+			break
+		}
+	}
+	r.setLag(r.state.PrimaryLatestID, "")
+	return ctx.Err()
+}
+
+// setLag records the lag given the oldest change not applied yet, if any
+func (r *Replicator) setLag(latestID int64, pendingAt string) {
+	r.state.LagEntries = max(latestID-r.state.AppliedID, 0)
+	r.state.LagSeconds = 0
+	if r.state.LagEntries == 0 {
+		return
+	}
+	if t, err := time.Parse(time.RFC3339, pendingAt); err == nil {
+		r.state.LagSeconds = max(r.clock.Now().Sub(t).Seconds(), 0)
+	}
+}
+
+// bootstrap copies the clusters of the primary, overwriting the clusters of
+// the standby whatever the conflict policy, as changes made on the standby
+// cannot be told from changes of the primary missed before
+// clusters only on the standby are deleted unless the standby wins conflicts
+func (r *Replicator) bootstrap(ctx context.Context) error {
+	snapshot, err := r.config.Primary.Snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	local, err := r.localClusters()
+	if err != nil {
+		return err
+	}
+	replicated := map[string]types.ClusterInfo{}
+	primary := map[string]bool{}
+	for _, cluster := range snapshot.Clusters {
+		primary[cluster.Name] = true
+	}
+	for name, cluster := range local {
+		if primary[name] {
+			continue
+		}
+		if r.config.ConflictPolicy == types.ReplicationStandbyWins {
+			r.conflict(0, name, types.ClusterChangeRecorded, false)
+			continue
+		}
+		r.conflict(0, name, types.ClusterChangeRecorded, true)
+		if err = r.deleteCluster(cluster); err != nil {
+			return errors.Errorf("could not delete cluster %q: %v", name, err)
+		}
+		delete(local, name)
+	}
+	for _, cluster := range snapshot.Clusters {
+		if err = r.write(cluster, "", local); err != nil {
+			return errors.Errorf("could not copy cluster %q: %v", cluster.Name, err)
+		}
+		replicated[cluster.UID] = cluster
+	}
+
+	r.replicated = replicated
+	r.state.Bootstrapped = true
+	r.state.Bootstraps++
+	r.state.AppliedID = snapshot.LatestID
+	r.state.PrimaryLatestID = snapshot.LatestID
+	return nil
+}
+
+// apply writes an entry of the history of the primary to the standby
+// local holds the clusters of the standby by name, and is kept up to date
+func (r *Replicator) apply(entry types.ClusterChangeLogEntry, local map[string]types.ClusterInfo) error {
+	previous, known := r.replicated[entry.ClusterUID]
+	name := entry.Name
+	if known {
+		name = previous.Name
+	}
+	current, exists := local[name]
+
+	if entry.Cluster == nil {
+		delete(r.replicated, entry.ClusterUID)
+		if !exists {
+			return nil
+		}
+		if (!known || changed(previous, current)) && !r.resolve(entry, name) {
+			return nil
+		}
+		delete(local, name)
+		return r.deleteCluster(current)
+	}
+
+	// a change of a cluster changed, deleted or created on the standby
+	if (known && (!exists || changed(previous, current))) || (!known && exists) {
+		if !r.resolve(entry, name) {
+			return nil
+		}
+	}
+	if !exists {
+		name = ""
+	}
+	if err := r.write(*entry.Cluster, name, local); err != nil {
+		return err
+	}
+	r.replicated[entry.ClusterUID] = *entry.Cluster
+	return nil
+}
+
+// resolve records a conflict of the entry and returns whether the change of
+// the primary is applied
+func (r *Replicator) resolve(entry types.ClusterChangeLogEntry, name string) bool {
+	applied := r.config.ConflictPolicy != types.ReplicationStandbyWins
+	r.conflict(entry.ID, name, entry.Change, applied)
+	return applied
+}
+
+func (r *Replicator) conflict(entryID int64, name string, change string, applied bool) {
+	log.Printf("WARNING: replication conflict on cluster %q, change of primary applied: %t", name, applied)
+	r.state.Conflicts++
+	conflicts := append([]types.ReplicationConflict{{
+		EntryID:    entryID,
+		Cluster:    name,
+		Change:     change,
+		Applied:    applied,
+		DetectedAt: r.clock.Now().UTC().Format(time.RFC3339),
+	}}, r.state.RecentConflicts...)
+	r.state.RecentConflicts = conflicts[:min(len(conflicts), maxRecentConflicts)]
+}
+
+// write creates or edits the cluster of the standby named name, empty if the
+// standby has none, to the state of the primary
+func (r *Replicator) write(state types.ClusterInfo, name string, local map[string]types.ClusterInfo) error {
+	if name == "" {
+		if _, ok := local[state.Name]; ok {
+			name = state.Name
+		}
+	}
+	if err := r.releaseAgents(state, name, local); err != nil {
+		return err
+	}
+	if name == "" {
+		if err := r.config.Store.CreateClusterEntry(state); err != nil {
+			return err
+		}
+		local[state.Name] = state
+		return nil
+	}
+
+	current := local[name]
+	if changed(current, state) {
+		edit := state
+		edit.Name = name
+		edit.EditedName = state.Name
+		if _, err := r.config.Store.EditClusterEntry(edit); err != nil {
+			return err
+		}
+	}
+	if current.Protected != state.Protected {
+		if err := r.config.Store.SetClusterProtection(state.Name, state.Protected); err != nil {
+			return err
+		}
+	}
+	delete(local, name)
+	local[state.Name] = state
+	return nil
+}
+
+// releaseAgents removes the agents of state from the other clusters of the
+// standby, as the primary may record an agent moving between two clusters as
+// the change of its new cluster first
+func (r *Replicator) releaseAgents(state types.ClusterInfo, name string, local map[string]types.ClusterInfo) error {
+	agents := map[string]bool{}
+	for _, agent := range state.AgentsList {
+		agents[agent] = true
+	}
+	for other, cluster := range local {
+		if other == name {
+			continue
+		}
+		kept := []string{}
+		for _, agent := range cluster.AgentsList {
+			if !agents[agent] {
+				kept = append(kept, agent)
+			}
+		}
+		if len(kept) == len(cluster.AgentsList) {
+			continue
+		}
+		cluster.EditedName = cluster.Name
+		cluster.AgentsList = kept
+		if _, err := r.config.Store.EditClusterEntry(cluster); err != nil {
+			return errors.Errorf("could not release agents of cluster %q: %v", other, err)
+		}
+		cluster.EditedName = ""
+		local[other] = cluster
+		// the release is not a change made on the standby
+		for uid, replicated := range r.replicated {
+			if replicated.Name == other {
+				replicated.AgentsList = kept
+				r.replicated[uid] = replicated
+			}
+		}
+	}
+	return nil
+}
+
+// deleteCluster deletes a cluster of the standby, clearing its protection first
+func (r *Replicator) deleteCluster(cluster types.ClusterInfo) error {
+	if cluster.Protected {
+		if err := r.config.Store.SetClusterProtection(cluster.Name, false); err != nil {
+			return err
+		}
+	}
+	return r.config.Store.DeleteClusterEntry(cluster.Name)
+}
+
+// localClusters returns the clusters of the standby by name
+func (r *Replicator) localClusters() (map[string]types.ClusterInfo, error) {
+	clusters, err := r.config.Store.GetClusters()
+	if err != nil {
+		return nil, errors.Errorf("could not get clusters: %v", err)
+	}
+	local := map[string]types.ClusterInfo{}
+	for _, cluster := range clusters.Clusters {
+		local[cluster.Name] = cluster
+	}
+	return local, nil
+}
+
+// changed returns whether the fields replicated differ between two states
+func changed(a, b types.ClusterInfo) bool {
+	return len(types.DiffClusters(a, b)) > 0 || a.Protected != b.Protected
+}
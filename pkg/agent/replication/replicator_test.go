@@ -0,0 +1,261 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	backoff "github.com/cenkalti/backoff/v4"
+
+	"github.com/spiffe/tornjak/pkg/agent/authentication/authenticator"
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	agentdb "github.com/spiffe/tornjak/pkg/agent/db"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// dbPrimary serves the history of a DB as the primary does
+type dbPrimary struct {
+	db agentdb.AgentDB
+}
+
+func (p dbPrimary) Snapshot(ctx context.Context) (types.ReplicationSnapshot, error) {
+	changes, err := p.db.GetClusterChangeLog(0, 0)
+	if err != nil {
+		return types.ReplicationSnapshot{}, err
+	}
+	clusters, err := p.db.GetClusters()
+	if err != nil {
+		return types.ReplicationSnapshot{}, err
+	}
+	return types.ReplicationSnapshot{LatestID: changes.LatestID, Clusters: clusters.Clusters}, nil
+}
+
+func (p dbPrimary) Changes(ctx context.Context, after int64, limit int) (types.ClusterChangeLog, error) {
+	return p.db.GetClusterChangeLog(after, limit)
+}
+
+func (p dbPrimary) String() string {
+	return "primary"
+}
+
+func newTestDB(t *testing.T) agentdb.AgentDB {
+	db, err := agentdb.NewInMemoryDB(backoff.NewExponentialBackOff(), agentdb.SqliteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func getCluster(t *testing.T, db agentdb.AgentDB, name string) (types.ClusterInfo, bool) {
+	clusters, err := db.GetClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cluster := range clusters.Clusters {
+		if cluster.Name == name {
+			return cluster, true
+		}
+	}
+	return types.ClusterInfo{}, false
+}
+
+func syncStandby(t *testing.T, r *Replicator) types.ReplicationStatus {
+	if err := r.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	status := r.Status()
+	if status.LagEntries != 0 || status.AppliedID != status.PrimaryLatestID {
+		t.Fatalf("Expected standby to catch up, got %+v", status)
+	}
+	return status
+}
+
+// TestReplicator checks a standby bootstraps from the clusters of the primary,
+// then follows creates, edits, renames, agent moves, protection and deletes
+func TestReplicator(t *testing.T) {
+	primary, standby := newTestDB(t), newTestDB(t)
+	agent1, agent2 := "spiffe://example.org/agent1", "spiffe://example.org/agent2"
+	if err := primary.CreateClusterEntry(types.ClusterInfo{Name: "prod", PlatformType: "k8s", AgentsList: []string{agent1},
+		Metadata: json.RawMessage(`{"costCenter":"CC-1"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	// cluster only on the standby
+	if err := standby.CreateClusterEntry(types.ClusterInfo{Name: "local", PlatformType: "VMs"}); err != nil {
+		t.Fatal(err)
+	}
+	r := New(Config{Primary: dbPrimary{primary}, Store: standby, BatchSize: 2})
+
+	// ATTEMPT bootstrap [Sync]
+	status := syncStandby(t, r)
+	// CHECK clusters of the primary copied and clusters only on the standby deleted
+	if cluster, ok := getCluster(t, standby, "prod"); !ok || len(cluster.AgentsList) != 1 || string(cluster.Metadata) != `{"costCenter":"CC-1"}` {
+		t.Fatalf("Expected prod copied, got %+v", cluster)
+	}
+	if _, ok := getCluster(t, standby, "local"); ok {
+		t.Fatal("Expected cluster only on the standby to be deleted")
+	}
+	if !status.Bootstrapped || status.Bootstraps != 1 || status.Conflicts != 1 {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+
+	// ATTEMPT changes on the primary, in more entries than a batch [Sync]
+	if err := primary.CreateClusterEntry(types.ClusterInfo{Name: "staging", PlatformType: "k8s"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := primary.EditClusterEntry(types.ClusterInfo{Name: "prod", EditedName: "prod-east", PlatformType: "k8s",
+		AgentsList: []string{agent2}}); err != nil {
+		t.Fatal(err)
+	}
+	// agent1 moves from prod-east to staging
+	if _, err := primary.EditClusterEntry(types.ClusterInfo{Name: "staging", EditedName: "staging", PlatformType: "k8s",
+		AgentsList: []string{agent1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.SetClusterProtection("staging", true); err != nil {
+		t.Fatal(err)
+	}
+	before := status.AppliedEntries
+	status = syncStandby(t, r)
+
+	// CHECK rename, agents and protection replicated
+	if _, ok := getCluster(t, standby, "prod"); ok {
+		t.Fatal("Expected prod to be renamed")
+	}
+	if cluster, ok := getCluster(t, standby, "prod-east"); !ok || len(cluster.AgentsList) != 1 || cluster.AgentsList[0] != agent2 || cluster.Metadata != nil {
+		t.Fatalf("Unexpected prod-east %+v", cluster)
+	}
+	if cluster, ok := getCluster(t, standby, "staging"); !ok || len(cluster.AgentsList) != 1 || !cluster.Protected {
+		t.Fatalf("Unexpected staging %+v", cluster)
+	}
+	if status.AppliedEntries-before != 4 || status.Conflicts != 1 {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+
+	// ATTEMPT delete of a protected cluster on the primary [Sync]
+	if err := primary.SetClusterProtection("staging", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.DeleteClusterEntry("staging"); err != nil {
+		t.Fatal(err)
+	}
+	syncStandby(t, r)
+	if _, ok := getCluster(t, standby, "staging"); ok {
+		t.Fatal("Expected staging to be deleted")
+	}
+}
+
+// TestReplicatorConflicts checks clusters changed on the standby are
+// overwritten or kept by the conflict policy
+func TestReplicatorConflicts(t *testing.T) {
+	for _, policy := range []string{types.ReplicationPrimaryWins, types.ReplicationStandbyWins} {
+		primary, standby := newTestDB(t), newTestDB(t)
+		if err := primary.CreateClusterEntry(types.ClusterInfo{Name: "prod", PlatformType: "k8s", OwnerTeam: "platform"}); err != nil {
+			t.Fatal(err)
+		}
+		r := New(Config{Primary: dbPrimary{primary}, Store: standby, BatchSize: 10, ConflictPolicy: policy})
+		syncStandby(t, r)
+
+		// ATTEMPT edit of the same cluster on both sides [Sync]
+		if _, err := standby.EditClusterEntry(types.ClusterInfo{Name: "prod", EditedName: "prod", PlatformType: "k8s", OwnerTeam: "standby"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := primary.EditClusterEntry(types.ClusterInfo{Name: "prod", EditedName: "prod", PlatformType: "k8s", OwnerTeam: "primary"}); err != nil {
+			t.Fatal(err)
+		}
+		status := syncStandby(t, r)
+
+		// CHECK the policy decides, and the conflict is reported
+		cluster, _ := getCluster(t, standby, "prod")
+		if expected := map[string]string{types.ReplicationPrimaryWins: "primary", types.ReplicationStandbyWins: "standby"}[policy]; cluster.OwnerTeam != expected {
+			t.Fatalf("Expected owner team %s with %s, got %s", expected, policy, cluster.OwnerTeam)
+		}
+		if status.Conflicts != 1 || len(status.RecentConflicts) != 1 || status.RecentConflicts[0].Cluster != "prod" ||
+			status.RecentConflicts[0].Applied != (policy == types.ReplicationPrimaryWins) {
+			t.Fatalf("Unexpected conflicts with %s: %+v", policy, status)
+		}
+	}
+}
+
+// TestReplicatorLag checks the lag is reported while changes cannot be applied
+func TestReplicatorLag(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	primary, standby := newTestDB(t), newTestDB(t)
+	r := New(Config{Primary: dbPrimary{primary}, Store: standby, BatchSize: 10, Clock: fake})
+	syncStandby(t, r)
+
+	// ATTEMPT change the standby cannot apply, an edit of a cluster it also has [Sync]
+	if err := primary.CreateClusterEntry(types.ClusterInfo{Name: "prod", PlatformType: "k8s"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := standby.CreateClusterEntry(types.ClusterInfo{Name: "prod", PlatformType: "VMs"}); err != nil {
+		t.Fatal(err)
+	}
+	r.config.Store = failingStore{standby}
+	fake.Add(time.Minute)
+	if err := r.Sync(context.Background()); err == nil {
+		t.Fatal("Expected sync to fail")
+	}
+	// CHECK lag and error in the status
+	status := r.Status()
+	if status.LagEntries != 1 || status.LagSeconds < 59 || status.LastError == "" {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+
+	// CHECK the change is applied once the standby accepts it
+	r.config.Store = standby
+	status = syncStandby(t, r)
+	if cluster, _ := getCluster(t, standby, "prod"); cluster.PlatformType != "k8s" || status.LastError != "" || status.LagSeconds != 0 {
+		t.Fatalf("Unexpected cluster %+v, status %+v", cluster, status)
+	}
+}
+
+// failingStore rejects edits
+type failingStore struct {
+	agentdb.AgentDB
+}
+
+func (s failingStore) EditClusterEntry(cinfo types.ClusterInfo) (types.ClusterEditResult, error) {
+	return types.ClusterEditResult{}, agentdb.PostFailure{Message: "read-only"}
+}
+
+// TestHTTPPrimary checks the requests of the standby to the primary
+func TestHTTPPrimary(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(authenticator.APIKeyHeader) != "tjk_key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/tornjak/replication/changes":
+			if r.URL.Query().Get("after") != "3" || r.URL.Query().Get("limit") != "10" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"entries": [{"id": 4, "clusterUid": "u", "name": "prod", "change": "deleted"}], "latestId": 4}`))
+		case "/api/v1/tornjak/replication/snapshot":
+			_, _ = w.Write([]byte(`{"latestId": 3, "clusters": [{"name": "prod"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	// ATTEMPT read the primary [Snapshot, Changes]
+	p := NewHTTPPrimary(server.URL+"/", "tjk_key", nil)
+	snapshot, err := p.Snapshot(context.Background())
+	if err != nil || snapshot.LatestID != 3 || len(snapshot.Clusters) != 1 {
+		t.Fatalf("Unexpected snapshot %+v: %v", snapshot, err)
+	}
+	changes, err := p.Changes(context.Background(), 3, 10)
+	if err != nil || changes.LatestID != 4 || len(changes.Entries) != 1 || changes.Entries[0].Cluster != nil || changes.Entries[0].Name != "prod" {
+		t.Fatalf("Unexpected changes %+v: %v", changes, err)
+	}
+
+	// CHECK errors of the primary are returned
+	if _, err = NewHTTPPrimary(server.URL, "", nil).Snapshot(context.Background()); err == nil {
+		t.Fatal("Expected error without API key")
+	}
+}
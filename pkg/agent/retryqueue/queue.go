@@ -0,0 +1,183 @@
+package retryqueue
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// Store is the part of the Tornjak DB failed operations are kept in
+type Store interface {
+	AddFailedOperation(op types.FailedOperation) (int64, error)
+	GetFailedOperation(id int64) (types.FailedOperation, error)
+	GetFailedOperations(state string) (types.FailedOperationList, error)
+	UpdateFailedOperation(op types.FailedOperation) error
+}
+
+// Step completes the step of an operation from its JSON payload
+// steps are retried, so they must be idempotent
+type Step func(ctx context.Context, payload []byte) error
+
+type Config struct {
+	Store Store
+	// steps that can be queued, by name
+	Steps map[string]Step
+	// time between two retries of the pending operations
+	Interval time.Duration
+	// attempts after which an operation is left for manual resolution
+	MaxAttempts int
+	// source of time, the clock of the system if nil
+	Clock clock.Clock
+}
+
+// Queue keeps the incomplete steps of partially failed operations and
+// retries them until they succeed or are resolved by an operator
+type Queue struct {
+	config Config
+	clock  clock.Clock
+}
+
+func New(config Config) *Queue {
+	return &Queue{config: config, clock: clock.OrNew(config.Clock)}
+}
+
+// Enqueue records that step of operation failed with cause, to be retried with payload
+// returns the ID of the failed operation
+func (q *Queue) Enqueue(operation string, step string, payload interface{}, cause error, user string) (int64, error) {
+	if _, ok := q.config.Steps[step]; !ok {
+		return 0, errors.Errorf("unknown step %q", step)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, errors.Errorf("could not encode payload of step %q: %v", step, err)
+	}
+	now := formatTime(q.clock.Now().UTC())
+	op := types.FailedOperation{
+		Operation: operation,
+		Step:      step,
+		Payload:   string(data),
+		State:     types.FailedOperationPending,
+		Attempts:  1,
+		LastError: cause.Error(),
+		CreatedBy: user,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	id, err := q.config.Store.AddFailedOperation(op)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("step %s of operation %s failed, queued for retry as %d: %v", step, operation, id, cause)
+	return id, nil
+}
+
+// Run retries the pending operations every interval until ctx is done
+func (q *Queue) Run(ctx context.Context) {
+	ticker := q.clock.NewTicker(q.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		if err := q.RetryPending(ctx); err != nil {
+			log.Printf("WARNING: could not retry failed operations: %v", err)
+		}
+	}
+}
+
+// RetryPending attempts the step of each pending operation once
+// operations reaching the maximum attempts are marked as stuck
+func (q *Queue) RetryPending(ctx context.Context) error {
+	pending, err := q.config.Store.GetFailedOperations(types.FailedOperationPending)
+	if err != nil {
+		return err
+	}
+	for _, op := range pending.Operations {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		op, err = q.attempt(ctx, op)
+		if err != nil {
+			return err
+		}
+		if op.State == types.FailedOperationStuck {
+			log.Printf("WARNING: failed operation %d is stuck after %d attempts: %s", op.ID, op.Attempts, op.LastError)
+		}
+	}
+	return nil
+}
+
+// Retry attempts the step of a pending or stuck operation now
+// a stuck operation stays stuck if the step fails again
+func (q *Queue) Retry(ctx context.Context, id int64) (types.FailedOperation, error) {
+	op, err := q.config.Store.GetFailedOperation(id)
+	if err != nil {
+		return types.FailedOperation{}, err
+	}
+	if op.State == types.FailedOperationResolved {
+		return types.FailedOperation{}, errors.Errorf("failed operation %d is already resolved", id)
+	}
+	return q.attempt(ctx, op)
+}
+
+// Resolve marks a pending or stuck operation as resolved by user, after the
+// inconsistency was fixed by hand
+func (q *Queue) Resolve(id int64, user string, note string) (types.FailedOperation, error) {
+	op, err := q.config.Store.GetFailedOperation(id)
+	if err != nil {
+		return types.FailedOperation{}, err
+	}
+	if op.State == types.FailedOperationResolved {
+		return types.FailedOperation{}, errors.Errorf("failed operation %d is already resolved", id)
+	}
+	op.State = types.FailedOperationResolved
+	op.ResolvedBy = user
+	op.ResolutionNote = note
+	op.UpdatedAt = formatTime(q.clock.Now().UTC())
+	if err = q.config.Store.UpdateFailedOperation(op); err != nil {
+		return types.FailedOperation{}, err
+	}
+	log.Printf("failed operation %d resolved by %q", id, user)
+	return op, nil
+}
+
+// attempt runs the step of op and stores the outcome
+func (q *Queue) attempt(ctx context.Context, op types.FailedOperation) (types.FailedOperation, error) {
+	step, ok := q.config.Steps[op.Step]
+	if !ok {
+		// queued by a version of Tornjak with other steps
+		op.LastError = "unknown step " + op.Step
+		op.State = types.FailedOperationStuck
+	} else {
+		op.Attempts++
+		if err := step(ctx, []byte(op.Payload)); err != nil {
+			op.LastError = err.Error()
+			if op.Attempts >= q.config.MaxAttempts {
+				op.State = types.FailedOperationStuck
+			}
+		} else {
+			op.State = types.FailedOperationResolved
+		}
+	}
+	op.UpdatedAt = formatTime(q.clock.Now().UTC())
+	if err := q.config.Store.UpdateFailedOperation(op); err != nil {
+		return types.FailedOperation{}, err
+	}
+	if op.State == types.FailedOperationResolved {
+		log.Printf("failed operation %d completed after %d attempts", op.ID, op.Attempts)
+	}
+	return op, nil
+}
+
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
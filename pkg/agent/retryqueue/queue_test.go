@@ -0,0 +1,163 @@
+package retryqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+type memoryStore struct {
+	ops []types.FailedOperation
+}
+
+func (s *memoryStore) AddFailedOperation(op types.FailedOperation) (int64, error) {
+	op.ID = int64(len(s.ops) + 1)
+	s.ops = append(s.ops, op)
+	return op.ID, nil
+}
+
+func (s *memoryStore) GetFailedOperation(id int64) (types.FailedOperation, error) {
+	if id < 1 || id > int64(len(s.ops)) {
+		return types.FailedOperation{}, errors.New("not found")
+	}
+	return s.ops[id-1], nil
+}
+
+func (s *memoryStore) GetFailedOperations(state string) (types.FailedOperationList, error) {
+	ops := []types.FailedOperation{}
+	for _, op := range s.ops {
+		if state == "" || op.State == state {
+			ops = append(ops, op)
+		}
+	}
+	return types.FailedOperationList{Operations: ops}, nil
+}
+
+func (s *memoryStore) UpdateFailedOperation(op types.FailedOperation) error {
+	if op.ID < 1 || op.ID > int64(len(s.ops)) {
+		return errors.New("not found")
+	}
+	s.ops[op.ID-1] = op
+	return nil
+}
+
+// TestQueue checks queued steps are retried until they succeed or get stuck
+func TestQueue(t *testing.T) {
+	store := &memoryStore{}
+	recorded := map[string]bool{}
+	failing := map[string]bool{"b": true, "c": true}
+	q := New(Config{
+		Store: store,
+		Steps: map[string]Step{
+			"record": func(ctx context.Context, payload []byte) error {
+				var name string
+				if err := json.Unmarshal(payload, &name); err != nil {
+					return err
+				}
+				if failing[name] {
+					return errors.New("db is locked")
+				}
+				recorded[name] = true
+				return nil
+			},
+		},
+		MaxAttempts: 3,
+		Clock:       clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)),
+	})
+	ctx := context.Background()
+	cause := errors.New("db is locked")
+
+	// ATTEMPT queue an unknown step [Enqueue]
+	if _, err := q.Enqueue("clone", "unknown", "a", cause, "admin"); err == nil {
+		t.Fatal("Expected error on unknown step")
+	}
+
+	// ATTEMPT queue failed steps [Enqueue]
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := q.Enqueue("clone", "record", name, cause, "admin"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// CHECK operations are pending after their first attempt
+	pending, _ := store.GetFailedOperations(types.FailedOperationPending)
+	if len(pending.Operations) != 3 || pending.Operations[0].Attempts != 1 || pending.Operations[0].Payload != `"a"` ||
+		pending.Operations[0].CreatedBy != "admin" || pending.Operations[0].LastError != "db is locked" {
+		t.Fatalf("Unexpected pending operations %+v", pending.Operations)
+	}
+
+	// ATTEMPT retry pending operations [RetryPending]
+	if err := q.RetryPending(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK the successful step is resolved, the others pending
+	if !recorded["a"] || store.ops[0].State != types.FailedOperationResolved || store.ops[0].Attempts != 2 {
+		t.Fatalf("Expected operation 1 resolved, got %+v", store.ops[0])
+	}
+	if store.ops[1].State != types.FailedOperationPending || store.ops[1].Attempts != 2 {
+		t.Fatalf("Expected operation 2 pending, got %+v", store.ops[1])
+	}
+
+	// ATTEMPT retry until the maximum attempts [RetryPending]
+	failing["c"] = false
+	if err := q.RetryPending(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK the still failing step is stuck and not retried further
+	if store.ops[1].State != types.FailedOperationStuck || store.ops[1].Attempts != 3 {
+		t.Fatalf("Expected operation 2 stuck, got %+v", store.ops[1])
+	}
+	if !recorded["c"] || store.ops[2].State != types.FailedOperationResolved {
+		t.Fatalf("Expected operation 3 resolved, got %+v", store.ops[2])
+	}
+	if err := q.RetryPending(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if store.ops[1].Attempts != 3 {
+		t.Fatalf("Expected stuck operation not retried, got %+v", store.ops[1])
+	}
+
+	// ATTEMPT manual retry of a stuck operation [Retry]
+	op, err := q.Retry(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.State != types.FailedOperationStuck || op.Attempts != 4 {
+		t.Fatalf("Expected operation 2 still stuck, got %+v", op)
+	}
+	failing["b"] = false
+	op, err = q.Retry(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.State != types.FailedOperationResolved || !recorded["b"] {
+		t.Fatalf("Expected operation 2 resolved, got %+v", op)
+	}
+
+	// ATTEMPT retry and resolve a resolved operation [Retry, Resolve]
+	if _, err = q.Retry(ctx, 2); err == nil {
+		t.Fatal("Expected error retrying a resolved operation")
+	}
+	if _, err = q.Resolve(2, "admin", ""); err == nil {
+		t.Fatal("Expected error resolving a resolved operation")
+	}
+
+	// ATTEMPT resolve a pending operation by hand [Resolve]
+	id, err := q.Enqueue("clone", "record", "d", cause, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, err = q.Resolve(id, "operator", "recorded by hand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK the resolution is stored and the step not run
+	if op.State != types.FailedOperationResolved || op.ResolvedBy != "operator" || op.ResolutionNote != "recorded by hand" ||
+		store.ops[id-1] != op || recorded["d"] {
+		t.Fatalf("Unexpected resolved operation %+v", op)
+	}
+}
@@ -0,0 +1,97 @@
+package spireerror
+
+import (
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Tornjak error codes of SPIRE server errors
+const (
+	CodePermissionDenied    = "SPIRE_PERMISSION_DENIED"
+	CodeTrustDomainMismatch = "SPIRE_TRUST_DOMAIN_MISMATCH"
+	CodeEntryNotFound       = "SPIRE_ENTRY_NOT_FOUND"
+	CodeNotFound            = "SPIRE_NOT_FOUND"
+	CodeUnavailable         = "SPIRE_UNAVAILABLE"
+	CodeInvalidArgument     = "SPIRE_INVALID_ARGUMENT"
+	CodeAlreadyExists       = "SPIRE_ALREADY_EXISTS"
+	CodeUnknown             = "SPIRE_ERROR"
+)
+
+// Error is the payload returned for an error of the SPIRE server
+type Error struct {
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	Hint     string `json:"hint,omitempty"`
+	GRPCCode string `json:"grpcCode"`
+	// HTTP status the error is returned with
+	Status int `json:"-"`
+}
+
+// FromError classifies a gRPC error returned by the SPIRE server
+// returns false if err does not carry a gRPC status, e.g. validation errors of Tornjak
+func FromError(err error) (Error, bool) {
+	if err == nil {
+		return Error{}, false
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return Error{}, false
+	}
+	return FromStatus(st.Code(), st.Message()), true
+}
+
+// FromStatus classifies a gRPC status code and message of the SPIRE server,
+// such as the per-entry statuses of batch responses
+func FromStatus(code codes.Code, message string) Error {
+	e := Error{
+		Code:     CodeUnknown,
+		Message:  message,
+		GRPCCode: code.String(),
+		Status:   http.StatusInternalServerError,
+	}
+	lower := strings.ToLower(message)
+	switch {
+	case code == codes.PermissionDenied:
+		e.Code = CodePermissionDenied
+		e.Status = http.StatusForbidden
+		e.Hint = "The SPIRE server denied the call. Tornjak must reach the SPIRE server admin API through the server's local socket " +
+			"(spire_socket_path), or its SPIFFE ID must be registered as an admin in SPIRE."
+	case isTrustDomainMismatch(code, lower):
+		e.Code = CodeTrustDomainMismatch
+		e.Status = http.StatusBadRequest
+		e.Hint = "The SPIFFE ID is not a member of the trust domain of the SPIRE server. Check the trust domain of the ID, " +
+			"or federate with the foreign trust domain instead of registering its IDs."
+	case code == codes.NotFound && strings.Contains(lower, "entry"):
+		e.Code = CodeEntryNotFound
+		e.Status = http.StatusNotFound
+		e.Hint = "The entry does not exist on the SPIRE server. It may have been deleted; refresh the entry list."
+	case code == codes.NotFound:
+		e.Code = CodeNotFound
+		e.Status = http.StatusNotFound
+	case code == codes.Unavailable:
+		e.Code = CodeUnavailable
+		e.Status = http.StatusBadGateway
+		e.Hint = "Tornjak could not reach the SPIRE server. Check that the SPIRE server is running and that spire_socket_path points to its API socket."
+	case code == codes.AlreadyExists:
+		e.Code = CodeAlreadyExists
+		e.Status = http.StatusConflict
+	case code == codes.InvalidArgument:
+		e.Code = CodeInvalidArgument
+		e.Status = http.StatusBadRequest
+	}
+	return e
+}
+
+// isTrustDomainMismatch returns whether the SPIRE server rejected an ID of another trust domain
+// SPIRE reports these as invalid arguments, e.g. `"spiffe://b/x" is not a member of trust domain "a"`
+func isTrustDomainMismatch(code codes.Code, lowerMessage string) bool {
+	if code != codes.InvalidArgument && code != codes.FailedPrecondition {
+		return false
+	}
+	return strings.Contains(lowerMessage, "not a member of trust domain") ||
+		strings.Contains(lowerMessage, "trust domain mismatch") ||
+		strings.Contains(lowerMessage, "does not belong to trust domain")
+}
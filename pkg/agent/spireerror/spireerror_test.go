@@ -0,0 +1,93 @@
+package spireerror
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFromError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   string
+		wantStatus int
+		wantHint   bool
+	}{
+		{
+			name:       "permission denied on admin API",
+			err:        status.Error(codes.PermissionDenied, "authorization denied for method /spire.api.server.entry.v1.Entry/BatchCreateEntry"),
+			wantCode:   CodePermissionDenied,
+			wantStatus: http.StatusForbidden,
+			wantHint:   true,
+		},
+		{
+			name:       "trust domain mismatch",
+			err:        status.Error(codes.InvalidArgument, `failed to convert entry: invalid spiffe ID: "spiffe://other.org/w" is not a member of trust domain "example.org"`),
+			wantCode:   CodeTrustDomainMismatch,
+			wantStatus: http.StatusBadRequest,
+			wantHint:   true,
+		},
+		{
+			name:       "entry not found",
+			err:        status.Error(codes.NotFound, "entry not found"),
+			wantCode:   CodeEntryNotFound,
+			wantStatus: http.StatusNotFound,
+			wantHint:   true,
+		},
+		{
+			name:       "agent not found",
+			err:        status.Error(codes.NotFound, "agent not found"),
+			wantCode:   CodeNotFound,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "other invalid argument",
+			err:        status.Error(codes.InvalidArgument, "missing selector"),
+			wantCode:   CodeInvalidArgument,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "wrapped status",
+			err:        errors.Wrap(status.Error(codes.Unavailable, "connection refused"), "dial"),
+			wantCode:   CodeUnavailable,
+			wantStatus: http.StatusBadGateway,
+			wantHint:   true,
+		},
+		{
+			name:       "unmapped code",
+			err:        status.Error(codes.Internal, "datastore failure"),
+			wantCode:   CodeUnknown,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := FromError(tt.err)
+			if !ok {
+				t.Fatal("expected a SPIRE error")
+			}
+			if got.Code != tt.wantCode || got.Status != tt.wantStatus {
+				t.Errorf("got %s %d, want %s %d", got.Code, got.Status, tt.wantCode, tt.wantStatus)
+			}
+			if (got.Hint != "") != tt.wantHint {
+				t.Errorf("got hint %q", got.Hint)
+			}
+			if got.Message == "" || got.GRPCCode == "" {
+				t.Errorf("missing message or gRPC code: %+v", got)
+			}
+		})
+	}
+}
+
+func TestFromErrorNotGRPC(t *testing.T) {
+	if _, ok := FromError(errors.New("invalid request")); ok {
+		t.Error("expected plain errors not to be classified")
+	}
+	if _, ok := FromError(nil); ok {
+		t.Error("expected nil not to be classified")
+	}
+}
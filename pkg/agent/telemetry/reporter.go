@@ -0,0 +1,208 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// Fleet returns the number of clusters and of agents in clusters
+type Fleet func(ctx context.Context) (clusters int, agents int, err error)
+
+type Config struct {
+	// set if the operator opted in to sending reports
+	Enabled bool
+	// URL reports are POSTed to as JSON
+	Endpoint string
+	// time between two reports
+	Interval time.Duration
+	// timeout of sending a report
+	Timeout time.Duration
+
+	Version  string
+	Backend  string
+	Features []string
+	// counts the fleet, its size is reported as zero if nil
+	Fleet Fleet
+	// source of time, the clock of the system if nil
+	Clock clock.Clock
+}
+
+// Reporter counts the use of the API and periodically sends the anonymous
+// aggregates to the telemetry endpoint, if enabled
+// usage is only counted in memory, so counts since the last report are lost on restart
+type Reporter struct {
+	config Config
+	client *http.Client
+	clock  clock.Clock
+
+	mu         sync.Mutex
+	usage      map[string]int64
+	lastSentAt time.Time
+	lastError  string
+}
+
+func New(config Config) *Reporter {
+	features := append([]string{}, config.Features...)
+	sort.Strings(features)
+	config.Features = features
+	return &Reporter{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		clock:  clock.OrNew(config.Clock),
+		usage:  make(map[string]int64),
+	}
+}
+
+// Count records a call of an API route, e.g. GET /api/v1/tornjak/clusters
+// callers pass route templates, never paths with values in them
+func (r *Reporter) Count(route string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.usage[route]++
+}
+
+// Report returns the report that would be sent now
+func (r *Reporter) Report(ctx context.Context) (types.TelemetryReport, error) {
+	report := types.TelemetryReport{
+		SchemaVersion: types.TelemetrySchemaVersion,
+		Version:       r.config.Version,
+		Backend:       r.config.Backend,
+		Features:      r.config.Features,
+		Clusters:      SizeBucket(0),
+		Agents:        SizeBucket(0),
+	}
+	if r.config.Fleet != nil {
+		clusters, agents, err := r.config.Fleet(ctx)
+		if err != nil {
+			return types.TelemetryReport{}, errors.Errorf("could not count fleet: %v", err)
+		}
+		report.Clusters = SizeBucket(clusters)
+		report.Agents = SizeBucket(agents)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report.Usage = make(map[string]int64, len(r.usage))
+	for route, count := range r.usage {
+		report.Usage[route] = count
+	}
+	return report, nil
+}
+
+// Preview returns the report that would be sent next with the status of telemetry
+func (r *Reporter) Preview(ctx context.Context) (types.TelemetryPreview, error) {
+	report, err := r.Report(ctx)
+	if err != nil {
+		return types.TelemetryPreview{}, err
+	}
+	preview := types.TelemetryPreview{Enabled: r.config.Enabled, Report: report}
+	if r.config.Enabled {
+		preview.Endpoint = r.config.Endpoint
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.lastSentAt.IsZero() {
+		preview.LastSentAt = r.lastSentAt.UTC().Format(time.RFC3339)
+	}
+	preview.LastError = r.lastError
+	return preview, nil
+}
+
+// Send sends a report to the endpoint
+// the usage it contains is no longer counted once it was accepted
+func (r *Reporter) Send(ctx context.Context) error {
+	if !r.config.Enabled {
+		return errors.New("telemetry is not enabled")
+	}
+	report, err := r.Report(ctx)
+	if err == nil {
+		err = r.post(ctx, report)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.lastError = err.Error()
+		return err
+	}
+	for route, count := range report.Usage {
+		r.usage[route] -= count
+		if r.usage[route] <= 0 {
+			delete(r.usage, route)
+		}
+	}
+	r.lastSentAt = r.clock.Now()
+	r.lastError = ""
+	return nil
+}
+
+func (r *Reporter) post(ctx context.Context, report types.TelemetryReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return errors.Errorf("could not encode report: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Errorf("invalid telemetry endpoint: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return errors.Errorf("could not send report: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("telemetry endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Run sends a report every interval until ctx is done, if telemetry is enabled
+func (r *Reporter) Run(ctx context.Context) {
+	if !r.config.Enabled {
+		return
+	}
+	ticker := r.clock.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		if err := r.Send(ctx); err != nil {
+			log.Printf("WARNING: could not send telemetry report: %v", err)
+		}
+	}
+}
+
+// SizeBucket returns the power-of-ten range n falls in, e.g. 11-100
+// so reports do not reveal the exact size of a fleet
+func SizeBucket(n int) string {
+	if n <= 0 {
+		return "0"
+	}
+	if n == 1 {
+		return "1"
+	}
+	lower := 2
+	for upper := 10; upper <= 100000; upper *= 10 {
+		if n <= upper {
+			return fmt.Sprintf("%d-%d", lower, upper)
+		}
+		lower = upper + 1
+	}
+	return fmt.Sprintf("%d+", lower)
+}
@@ -0,0 +1,119 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+func TestSizeBucket(t *testing.T) {
+	for n, expected := range map[int]string{0: "0", 1: "1", 2: "2-10", 10: "2-10", 11: "11-100", 1000: "101-1000", 100001: "100001+"} {
+		if got := SizeBucket(n); got != expected {
+			t.Fatalf("Expected bucket %q of %d, got %q", expected, n, got)
+		}
+	}
+}
+
+// TestReporter checks the preview equals the report sent and sent usage is no longer counted
+func TestReporter(t *testing.T) {
+	received := make(chan types.TelemetryReport, 1)
+	status := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report types.TelemetryReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Errorf("Invalid report: %v", err)
+		}
+		received <- report
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	reporter := New(Config{
+		Enabled:  true,
+		Endpoint: server.URL,
+		Interval: time.Hour,
+		Timeout:  time.Second,
+		Version:  "v1.9.0",
+		Backend:  "sql",
+		Features: []string{"spire_mirror", "bundle_monitor"},
+		Fleet: func(ctx context.Context) (int, int, error) {
+			return 12, 340, nil
+		},
+		Clock: clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)),
+	})
+	reporter.Count("GET /api/v1/tornjak/clusters")
+	reporter.Count("GET /api/v1/tornjak/clusters")
+	reporter.Count("POST /api/v1/tornjak/clusters")
+
+	// CHECK preview shows the report
+	preview, err := reporter.Preview(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := types.TelemetryReport{
+		SchemaVersion: types.TelemetrySchemaVersion,
+		Version:       "v1.9.0",
+		Backend:       "sql",
+		Features:      []string{"bundle_monitor", "spire_mirror"},
+		Usage:         map[string]int64{"GET /api/v1/tornjak/clusters": 2, "POST /api/v1/tornjak/clusters": 1},
+		Clusters:      "11-100",
+		Agents:        "101-1000",
+	}
+	if !preview.Enabled || preview.Endpoint != server.URL || !reflect.DeepEqual(preview.Report, expected) {
+		t.Fatalf("Unexpected preview: %+v", preview)
+	}
+
+	// ATTEMPT send a report after the preview
+	if err = reporter.Send(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK the report sent is the one previewed and usage restarts
+	if got := <-received; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected report %+v, got %+v", expected, got)
+	}
+	reporter.Count("POST /api/v1/tornjak/clusters")
+	preview, err = reporter.Preview(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(preview.Report.Usage, map[string]int64{"POST /api/v1/tornjak/clusters": 1}) || preview.LastSentAt != "2024-03-01T12:00:00Z" {
+		t.Fatalf("Unexpected preview after send: %+v", preview)
+	}
+
+	// CHECK usage of a rejected report is kept
+	status = http.StatusInternalServerError
+	if err = reporter.Send(context.Background()); err == nil {
+		t.Fatal("Expected error from rejected report")
+	}
+	<-received
+	preview, err = reporter.Preview(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Report.Usage["POST /api/v1/tornjak/clusters"] != 1 || preview.LastError == "" {
+		t.Fatalf("Unexpected preview after rejected report: %+v", preview)
+	}
+}
+
+// TestReporterDisabled checks nothing is sent unless enabled, while the report can be previewed
+func TestReporterDisabled(t *testing.T) {
+	reporter := New(Config{Endpoint: "http://127.0.0.1:1", Version: "unknown", Backend: "none"})
+	reporter.Count("GET /api/v1/tornjak/serverinfo")
+	if err := reporter.Send(context.Background()); err == nil {
+		t.Fatal("Expected disabled telemetry not to send")
+	}
+	preview, err := reporter.Preview(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Enabled || preview.Endpoint != "" || preview.Report.Usage["GET /api/v1/tornjak/serverinfo"] != 1 || preview.Report.Clusters != "0" {
+		t.Fatalf("Unexpected preview: %+v", preview)
+	}
+}
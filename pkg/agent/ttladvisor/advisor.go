@@ -0,0 +1,83 @@
+package ttladvisor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// Policy is the band SVID TTLs of entries must be in
+// zero bounds are not checked
+type Policy struct {
+	X509SVIDMin time.Duration
+	X509SVIDMax time.Duration
+	JWTSVIDMin  time.Duration
+	JWTSVIDMax  time.Duration
+	// TTL of agent SVIDs; X.509 SVIDs of workload entries should not outlive
+	// the SVID of the agent they are issued through
+	AgentTTL time.Duration
+}
+
+// Entry contains the TTLs of a SPIRE entry in seconds
+// a TTL of 0 uses the default of the SPIRE server and is not checked
+type Entry struct {
+	Id          string
+	SpiffeId    string
+	ParentId    string
+	X509SVIDTTL int32
+	JWTSVIDTTL  int32
+}
+
+// isWorkload returns whether the entry is issued through an agent rather
+// than to an agent by the SPIRE server
+func (e Entry) isWorkload() bool {
+	return !strings.HasSuffix(e.ParentId, "/spire/server")
+}
+
+// Advise returns the TTLs of entries outside the policy with suggested values
+func (p Policy) Advise(entries []Entry) types.TTLAdvice {
+	advice := types.TTLAdvice{CheckedEntries: len(entries), Findings: []types.TTLFinding{}}
+	for _, entry := range entries {
+		x509Max, x509MaxReason := p.X509SVIDMax, "longer than the policy maximum"
+		if entry.isWorkload() && p.AgentTTL > 0 && (x509Max == 0 || p.AgentTTL < x509Max) {
+			x509Max, x509MaxReason = p.AgentTTL, "longer than the TTL of the parent agent"
+		}
+		checks := []struct {
+			field    string
+			ttl      int32
+			min, max time.Duration
+			reason   string
+		}{
+			{types.TTLFieldX509SVID, entry.X509SVIDTTL, p.X509SVIDMin, x509Max, x509MaxReason},
+			{types.TTLFieldJWTSVID, entry.JWTSVIDTTL, p.JWTSVIDMin, p.JWTSVIDMax, "longer than the policy maximum"},
+		}
+		for _, check := range checks {
+			if check.ttl == 0 {
+				continue
+			}
+			ttl := time.Duration(check.ttl) * time.Second
+			var suggested time.Duration
+			var reason string
+			switch {
+			case check.max > 0 && ttl > check.max:
+				suggested, reason = check.max, check.reason
+			case check.min > 0 && ttl < check.min:
+				suggested, reason = check.min, "shorter than the policy minimum"
+			default:
+				continue
+			}
+			advice.Findings = append(advice.Findings, types.TTLFinding{
+				EntryId:   entry.Id,
+				SpiffeId:  entry.SpiffeId,
+				ParentId:  entry.ParentId,
+				Field:     check.field,
+				Current:   check.ttl,
+				Suggested: int32(suggested / time.Second),
+				Reason:    fmt.Sprintf("TTL %v %s %v", ttl, reason, suggested),
+			})
+		}
+	}
+	return advice
+}
@@ -0,0 +1,51 @@
+package ttladvisor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spiffe/tornjak/pkg/agent/types"
+)
+
+// TestAdvise checks TTLs outside the policy bands are flagged with suggested values
+func TestAdvise(t *testing.T) {
+	policy := Policy{
+		X509SVIDMin: 10 * time.Minute,
+		X509SVIDMax: 24 * time.Hour,
+		JWTSVIDMax:  time.Hour,
+		AgentTTL:    12 * time.Hour,
+	}
+	server := "spiffe://example.org/spire/server"
+	agent := "spiffe://example.org/spire/agent/join_token/abc"
+	entries := []Entry{
+		{Id: "ok", ParentId: agent, X509SVIDTTL: 3600, JWTSVIDTTL: 300},
+		{Id: "defaults", ParentId: agent},
+		{Id: "short", ParentId: agent, X509SVIDTTL: 60},
+		{Id: "outlives-agent", ParentId: agent, X509SVIDTTL: 86400},
+		{Id: "node", ParentId: server, X509SVIDTTL: 86400},
+		{Id: "long-node", ParentId: server, X509SVIDTTL: 172800, JWTSVIDTTL: 7200},
+	}
+	advice := policy.Advise(entries)
+	if advice.CheckedEntries != len(entries) {
+		t.Fatalf("Expected %d checked entries, got %d", len(entries), advice.CheckedEntries)
+	}
+
+	expected := []struct {
+		entry, field string
+		suggested    int32
+	}{
+		{"short", types.TTLFieldX509SVID, 600},
+		{"outlives-agent", types.TTLFieldX509SVID, 43200},
+		{"long-node", types.TTLFieldX509SVID, 86400},
+		{"long-node", types.TTLFieldJWTSVID, 3600},
+	}
+	if len(advice.Findings) != len(expected) {
+		t.Fatalf("Expected %d findings, got %+v", len(expected), advice.Findings)
+	}
+	for i, e := range expected {
+		f := advice.Findings[i]
+		if f.EntryId != e.entry || f.Field != e.field || f.Suggested != e.suggested || f.Reason == "" {
+			t.Fatalf("Expected %s %s suggested %d, got %+v", e.entry, e.field, e.suggested, f)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package types
+
+import (
+	"github.com/pkg/errors"
+)
+
+// MaxAgentAnnotationLength is the maximum length of the value of an annotation
+const MaxAgentAnnotationLength = 4096
+
+// AgentAnnotation is a key-value annotation of an operator on an agent, e.g.
+// status: pending decommission or hardware: 2x Xeon Gold 6338
+// keys have the format of label keys, values are free-form text
+type AgentAnnotation struct {
+	Spiffeid string `json:"spiffeid"`
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	// user and time of the last change of the value
+	UpdatedBy string `json:"updatedBy,omitempty"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// ValidateAgentAnnotationKey checks the format of the key of an annotation
+func ValidateAgentAnnotationKey(key string) error {
+	if !labelKeyRegexp.MatchString(key) {
+		return errors.Errorf("invalid annotation key %q", key)
+	}
+	return nil
+}
+
+// Validate checks the agent, key and value of the annotation
+func (a AgentAnnotation) Validate() error {
+	if len(a.Spiffeid) == 0 {
+		return errors.New("input missing mandatory field - Spiffeid")
+	}
+	if err := ValidateAgentAnnotationKey(a.Key); err != nil {
+		return err
+	}
+	if len(a.Value) > MaxAgentAnnotationLength {
+		return errors.Errorf("value of annotation %s longer than %d characters", a.Key, MaxAgentAnnotationLength)
+	}
+	return nil
+}
+
+// AgentAnnotationList contains a list of agent annotations
+type AgentAnnotationList struct {
+	Annotations []AgentAnnotation `json:"annotations"`
+}
@@ -0,0 +1,30 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAgentAnnotationValidate(t *testing.T) {
+	valid := []AgentAnnotation{
+		{Spiffeid: "spiffe://example.org/agent1", Key: "status", Value: "pending decommission"},
+		{Spiffeid: "spiffe://example.org/agent1", Key: "example.org/hardware", Value: "2x Xeon Gold 6338, 512 GiB"},
+		{Spiffeid: "spiffe://example.org/agent1", Key: "reviewed"},
+	}
+	for _, annotation := range valid {
+		if err := annotation.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	invalid := map[string]AgentAnnotation{
+		"missing agent": {Key: "status", Value: "x"},
+		"empty key":     {Spiffeid: "spiffe://example.org/agent1", Value: "x"},
+		"invalid key":   {Spiffeid: "spiffe://example.org/agent1", Key: "rack location", Value: "x"},
+		"long value":    {Spiffeid: "spiffe://example.org/agent1", Key: "status", Value: strings.Repeat("x", MaxAgentAnnotationLength+1)},
+	}
+	for name, annotation := range invalid {
+		if err := annotation.Validate(); err == nil {
+			t.Fatalf("Expected %s to be invalid", name)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package types
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+)
+
+// formats of agent assignment uploads
+const (
+	AgentAssignmentCSV    = "csv"
+	AgentAssignmentNDJSON = "ndjson"
+)
+
+// maximum number of rows of an agent assignment upload
+const MaxAgentAssignments = 50000
+
+// AgentAssignment assigns an agent to the cluster with the given UID
+// an agent assigned to another cluster is moved
+type AgentAssignment struct {
+	// line of the row in the upload, starting at 1
+	Row        int    `json:"row"`
+	Spiffeid   string `json:"spiffeid"`
+	ClusterUID string `json:"cluster_uid"`
+}
+
+// AgentAssignmentError is the reason a row of an upload was not applied
+type AgentAssignmentError struct {
+	Row        int    `json:"row"`
+	Spiffeid   string `json:"spiffeid,omitempty"`
+	ClusterUID string `json:"cluster_uid,omitempty"`
+	// one of the BatchCode constants
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// AgentAssignmentResult summarizes an applied upload; rows with errors are not applied
+type AgentAssignmentResult struct {
+	DryRun bool `json:"dryRun"`
+	Rows   int  `json:"rows"`
+	// agents added to or moved to their cluster
+	Assigned int `json:"assigned"`
+	// agents already in their cluster
+	Unchanged int                    `json:"unchanged"`
+	Errors    []AgentAssignmentError `json:"errors"`
+	// outcome of each row, see AgentAssignmentBatch
+	Batch BatchResult `json:"batch"`
+}
+
+// AgentAssignmentBatch returns the outcome of each row of an upload, given its
+// valid rows and the errors of the rows rejected by parsing or by the DB
+// valid rows without errors are succeeded, also in dry runs where they would be
+func AgentAssignmentBatch(assignments []AgentAssignment, rowErrors []AgentAssignmentError) BatchResult {
+	items := []BatchItem{}
+	failed := map[int]bool{}
+	for _, e := range rowErrors {
+		failed[e.Row] = true
+		items = append(items, BatchItem{Index: e.Row, ID: e.Spiffeid, Status: BatchItemFailed, Code: e.Code, Error: e.Error})
+	}
+	for _, a := range assignments {
+		if !failed[a.Row] {
+			items = append(items, BatchItem{Index: a.Row, ID: a.Spiffeid, Status: BatchItemSucceeded})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Index < items[j].Index })
+	batch := NewBatchResult()
+	for _, item := range items {
+		batch.Add(item)
+	}
+	return batch
+}
+
+// ParseAgentAssignments reads the rows of an upload in CSV or NDJSON format
+// CSV uploads start with the header spiffeid,cluster_uid; NDJSON uploads have one
+// object with the fields spiffeid and cluster_uid per line
+// returns the valid rows and the errors of the others, and an error if the upload
+// cannot be read at all
+func ParseAgentAssignments(r io.Reader, format string) ([]AgentAssignment, []AgentAssignmentError, error) {
+	var assignments []AgentAssignment
+	var rowErrors []AgentAssignmentError
+	var err error
+	switch format {
+	case AgentAssignmentCSV:
+		assignments, rowErrors, err = parseAgentAssignmentsCSV(r)
+	case AgentAssignmentNDJSON:
+		assignments, rowErrors, err = parseAgentAssignmentsNDJSON(r)
+	default:
+		return nil, nil, errors.Errorf("invalid format %q, expected %q or %q", format, AgentAssignmentCSV, AgentAssignmentNDJSON)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// CHECK rows, an agent is assigned at most once per upload
+	valid := []AgentAssignment{}
+	seen := make(map[string]int)
+	for _, a := range assignments {
+		if err := a.validate(); err != nil {
+			rowErrors = append(rowErrors, a.error(BatchCodeInvalidArgument, err.Error()))
+			continue
+		}
+		if row, ok := seen[a.Spiffeid]; ok {
+			rowErrors = append(rowErrors, a.error(BatchCodeDuplicate, fmt.Sprintf("agent already assigned in row %d", row)))
+			continue
+		}
+		seen[a.Spiffeid] = a.Row
+		valid = append(valid, a)
+	}
+	sort.SliceStable(rowErrors, func(i, j int) bool { return rowErrors[i].Row < rowErrors[j].Row })
+	return valid, rowErrors, nil
+}
+
+func parseAgentAssignmentsCSV(r io.Reader) ([]AgentAssignment, []AgentAssignmentError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("empty upload, expected header spiffeid,cluster_uid")
+	} else if err != nil {
+		return nil, nil, errors.Errorf("could not read header: %v", err)
+	}
+	columns := map[string]int{"spiffeid": -1, "cluster_uid": -1}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := columns[name]; ok {
+			columns[name] = i
+		}
+	}
+	if columns["spiffeid"] < 0 || columns["cluster_uid"] < 0 {
+		return nil, nil, errors.Errorf("invalid header %q, expected columns spiffeid and cluster_uid", strings.Join(header, ","))
+	}
+
+	assignments := []AgentAssignment{}
+	rowErrors := []AgentAssignmentError{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		row, _ := reader.FieldPos(0)
+		if err != nil {
+			if perr, ok := err.(*csv.ParseError); ok {
+				// the rest of the upload cannot be split into rows reliably
+				rowErrors = append(rowErrors, AgentAssignmentError{Row: perr.StartLine, Code: BatchCodeInvalidArgument, Error: perr.Err.Error()})
+				break
+			}
+			return nil, nil, errors.Errorf("could not read upload: %v", err)
+		}
+		if len(assignments)+len(rowErrors) >= MaxAgentAssignments {
+			return nil, nil, errors.Errorf("upload has more than %d rows", MaxAgentAssignments)
+		}
+		a := AgentAssignment{Row: row}
+		if columns["spiffeid"] < len(record) {
+			a.Spiffeid = strings.TrimSpace(record[columns["spiffeid"]])
+		}
+		if columns["cluster_uid"] < len(record) {
+			a.ClusterUID = strings.TrimSpace(record[columns["cluster_uid"]])
+		}
+		assignments = append(assignments, a)
+	}
+	return assignments, rowErrors, nil
+}
+
+func parseAgentAssignmentsNDJSON(r io.Reader) ([]AgentAssignment, []AgentAssignmentError, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	assignments := []AgentAssignment{}
+	rowErrors := []AgentAssignmentError{}
+	row := 0
+	for scanner.Scan() {
+		row++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		if len(assignments)+len(rowErrors) >= MaxAgentAssignments {
+			return nil, nil, errors.Errorf("upload has more than %d rows", MaxAgentAssignments)
+		}
+		var a AgentAssignment
+		if err := json.Unmarshal([]byte(line), &a); err != nil {
+			rowErrors = append(rowErrors, AgentAssignmentError{Row: row, Code: BatchCodeInvalidArgument, Error: fmt.Sprintf("invalid JSON: %v", err)})
+			continue
+		}
+		a.Row = row
+		a.Spiffeid = strings.TrimSpace(a.Spiffeid)
+		a.ClusterUID = strings.TrimSpace(a.ClusterUID)
+		assignments = append(assignments, a)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, errors.Errorf("could not read upload: %v", err)
+	}
+	return assignments, rowErrors, nil
+}
+
+// validate checks the SPIFFE ID of the agent and the presence of the cluster UID
+func (a AgentAssignment) validate() error {
+	if len(a.Spiffeid) == 0 {
+		return errors.New("missing spiffeid")
+	}
+	if _, err := spiffeid.FromString(a.Spiffeid); err != nil {
+		return errors.Errorf("invalid spiffeid: %v", err)
+	}
+	if len(a.ClusterUID) == 0 {
+		return errors.New("missing cluster_uid")
+	}
+	return nil
+}
+
+func (a AgentAssignment) error(code string, msg string) AgentAssignmentError {
+	return AgentAssignmentError{Row: a.Row, Spiffeid: a.Spiffeid, ClusterUID: a.ClusterUID, Code: code, Error: msg}
+}
+
+// states of asynchronous agent assignment jobs
+const (
+	AgentAssignmentJobRunning   = "running"
+	AgentAssignmentJobSucceeded = "succeeded"
+	AgentAssignmentJobFailed    = "failed"
+)
+
+// AgentAssignmentJob is an upload applied in the background
+type AgentAssignmentJob struct {
+	ID          string `json:"id"`
+	State       string `json:"state"`
+	SubmittedBy string `json:"submittedBy"`
+	SubmittedAt string `json:"submittedAt"`
+	FinishedAt  string `json:"finishedAt,omitempty"`
+	// agents written so far, set while a job runs on a DataStore reporting progress
+	Progress *AgentAssignmentProgress `json:"progress,omitempty"`
+	// set once the job succeeded
+	Result *AgentAssignmentResult `json:"result,omitempty"`
+	// set if the job failed, no row was applied
+	Error string `json:"error,omitempty"`
+}
+
+// AgentAssignmentProgress counts the agents an assignment job has written so far
+type AgentAssignmentProgress struct {
+	Applied int `json:"applied"`
+	Total   int `json:"total"`
+}
+
+// AgentAssignmentJobList contains the asynchronous agent assignment jobs, most recent first
+type AgentAssignmentJobList struct {
+	Jobs []AgentAssignmentJob `json:"jobs"`
+}
+
+// AgentMoveResult is the outcome of a move of agents from a cluster to another
+type AgentMoveResult struct {
+	FromCluster string `json:"fromCluster"`
+	ToCluster   string `json:"toCluster"`
+	Moved       int    `json:"moved"`
+}
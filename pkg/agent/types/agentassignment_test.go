@@ -0,0 +1,88 @@
+package types
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseAgentAssignments checks rows of CSV and NDJSON uploads are read and
+// invalid rows reported with their line
+func TestParseAgentAssignments(t *testing.T) {
+	csvUpload := "Cluster_UID, spiffeid\n" +
+		"uid1,spiffe://example.org/agent/1\n" +
+		"uid2,agent2\n" +
+		"uid1\n" +
+		"uid2,spiffe://example.org/agent/1\n" +
+		"\"uid3\",\"spiffe://example.org/agent/3\"\n"
+	ndjsonUpload := `{"spiffeid": "spiffe://example.org/agent/1", "cluster_uid": "uid1"}
+{"spiffeid": "agent2", "cluster_uid": "uid2"}
+{"cluster_uid": "uid1"}
+
+{"spiffeid": "spiffe://example.org/agent/1", "cluster_uid": "uid2"}
+{"spiffeid": "spiffe://example.org/agent/3", "cluster_uid": "uid3"}
+{"spiffeid": 5}
+`
+	tests := []struct {
+		format    string
+		upload    string
+		valid     []AgentAssignment
+		errorRows []int
+	}{
+		{AgentAssignmentCSV, csvUpload, []AgentAssignment{
+			{Row: 2, Spiffeid: "spiffe://example.org/agent/1", ClusterUID: "uid1"},
+			{Row: 6, Spiffeid: "spiffe://example.org/agent/3", ClusterUID: "uid3"},
+		}, []int{3, 4, 5}},
+		{AgentAssignmentNDJSON, ndjsonUpload, []AgentAssignment{
+			{Row: 1, Spiffeid: "spiffe://example.org/agent/1", ClusterUID: "uid1"},
+			{Row: 6, Spiffeid: "spiffe://example.org/agent/3", ClusterUID: "uid3"},
+		}, []int{2, 3, 5, 7}},
+	}
+	for _, test := range tests {
+		valid, rowErrors, err := ParseAgentAssignments(strings.NewReader(test.upload), test.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(valid, test.valid) {
+			t.Fatalf("Expected %s rows %+v, got %+v", test.format, test.valid, valid)
+		}
+		errorRows := []int{}
+		for _, rowError := range rowErrors {
+			errorRows = append(errorRows, rowError.Row)
+		}
+		if !reflect.DeepEqual(errorRows, test.errorRows) {
+			t.Fatalf("Expected %s errors in rows %v, got %+v", test.format, test.errorRows, rowErrors)
+		}
+	}
+
+	// CHECK uploads that cannot be read are rejected
+	for _, upload := range []string{"", "spiffeid,cluster\nspiffe://example.org/agent/1,uid1\n"} {
+		if _, _, err := ParseAgentAssignments(strings.NewReader(upload), AgentAssignmentCSV); err == nil {
+			t.Fatalf("Expected error on upload %q", upload)
+		}
+	}
+	if _, _, err := ParseAgentAssignments(strings.NewReader(csvUpload), "xlsx"); err == nil {
+		t.Fatal("Expected error on unknown format")
+	}
+}
+
+func TestAgentAssignmentBatch(t *testing.T) {
+	assignments := []AgentAssignment{
+		{Row: 2, Spiffeid: "spiffe://example.org/agent/1", ClusterUID: "uid1"},
+		{Row: 4, Spiffeid: "spiffe://example.org/agent/2", ClusterUID: "unknown"},
+	}
+	rowErrors := []AgentAssignmentError{
+		{Row: 3, Code: BatchCodeInvalidArgument, Error: "missing spiffeid"},
+		{Row: 4, Spiffeid: "spiffe://example.org/agent/2", ClusterUID: "unknown", Code: BatchCodeNotFound, Error: "cluster does not exist"},
+	}
+	// CHECK rows are listed in order, the rows of the DB errors failed
+	batch := AgentAssignmentBatch(assignments, rowErrors)
+	expected := BatchResult{Succeeded: 1, Failed: 2, Items: []BatchItem{
+		{Index: 2, ID: "spiffe://example.org/agent/1", Status: BatchItemSucceeded},
+		{Index: 3, Status: BatchItemFailed, Code: BatchCodeInvalidArgument, Error: "missing spiffeid"},
+		{Index: 4, ID: "spiffe://example.org/agent/2", Status: BatchItemFailed, Code: BatchCodeNotFound, Error: "cluster does not exist"},
+	}}
+	if !reflect.DeepEqual(batch, expected) {
+		t.Fatalf("Expected batch %+v, got %+v", expected, batch)
+	}
+}
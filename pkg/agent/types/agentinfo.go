@@ -1,15 +1,41 @@
 package types
 
+import "time"
+
 // AgentInfo contains the information about agents workload attestor plugin
 type AgentInfo struct {
-	Spiffeid string `json:"spiffeid"`
-	Plugin   string `json:"plugin"`
-	Cluster  string `json:"cluster"`
+	Spiffeid    string `json:"spiffeid"`
+	Plugin      string `json:"plugin"`
+	Cluster     string `json:"cluster"`
+	DisplayName string `json:"displayName"`
+	// time the agent was first stored, set by the DB in UTC
+	// zero for agents stored by releases that did not record it
+	CreationTime time.Time `json:"creationTime"`
+	// time of the last change of the plugin type, display name, labels or cluster of the agent,
+	// set by the DB in UTC
+	UpdatedAt time.Time `json:"updatedAt"`
+	// current compliance attributes reported for the agent's node
+	Compliance map[string]string `json:"compliance,omitempty"`
+	// labels such as env:prod, for grouping agents
+	Labels map[string]string `json:"labels,omitempty"`
+	// annotations of operators by key, see AgentAnnotation
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 // AgentInfoList contains the information about agents workload attestor plugin
 type AgentInfoList struct {
 	Agents []AgentInfo `json:"agents"`
+	// cursor of the next page and number of agents across all pages, set when paginated
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      int    `json:"total,omitempty"`
+}
+
+// SpireAgentDetails contains the details of an agent read live from SPIRE
+type SpireAgentDetails struct {
+	AttestationType string `json:"attestationType"`
+	// RFC 3339 expiry of the X509-SVID of the agent
+	ExpiresAt string `json:"expiresAt,omitempty"`
+	Banned    bool   `json:"banned"`
 }
 
 // AgentEntries contains agent spiffeid and list of spiffeids of Entries
@@ -23,7 +49,35 @@ type AllAgentEntries struct {
 	Agents []AgentEntries `json:"agents"`
 }
 
-// AgentMetadataRequest contains a list of spiffeids
+// AgentMetadataRequest contains a list of spiffeids, an optional search string
+// matched against spiffeids and display names, an optional plugin type and optional compliance filters
 type AgentMetadataRequest struct {
 	Agents []string `json:"agents"`
+	Search string   `json:"search,omitempty"`
+	// any spelling of the plugin type, e.g. k8s for Kubernetes
+	Plugin     string             `json:"plugin,omitempty"`
+	Compliance []ComplianceFilter `json:"compliance,omitempty"`
+	// number of agents of a page, and cursor of the page returned by the
+	// previous call; all matching agents are returned if neither is set
+	Limit  int    `json:"limit,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// Paginated returns whether a page of the matching agents is requested
+func (r AgentMetadataRequest) Paginated() bool {
+	return r.Limit != 0 || r.Cursor != ""
+}
+
+// PageOptions returns the pagination options of the request
+func (r AgentMetadataRequest) PageOptions() ListOptions {
+	return ListOptions{Limit: r.Limit, Cursor: r.Cursor}
+}
+
+// maximum length of an agent display name
+const MaxAgentDisplayNameLength = 128
+
+// AgentDisplayName assigns a human-friendly display name to an agent
+type AgentDisplayName struct {
+	Spiffeid    string `json:"spiffeid"`
+	DisplayName string `json:"displayName"`
 }
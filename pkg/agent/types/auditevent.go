@@ -0,0 +1,68 @@
+package types
+
+import "encoding/json"
+
+// actions recorded in the audit log
+const (
+	AuditActionCreate = "create"
+	AuditActionEdit   = "edit"
+	AuditActionDelete = "delete"
+	// deleted cluster restored with its UID, see RestoreClusterEntry
+	AuditActionRestore = "restore"
+)
+
+// types of the objects whose changes are recorded in the audit log
+const (
+	// cluster, by UID
+	AuditObjectCluster = "cluster"
+	// plugin type, display name and labels of an agent, by SPIFFE ID
+	AuditObjectAgent = "agent"
+	// assignment of an agent to a cluster, by SPIFFE ID of the agent
+	AuditObjectMembership = "membership"
+	// named snapshot of the DB restored, by name
+	AuditObjectSnapshot = "snapshot"
+)
+
+// AuditEvent is the record of a change of a cluster, an agent or the cluster
+// of an agent, written by the DB in the transaction of the change
+type AuditEvent struct {
+	ID int64 `json:"id"`
+	// user or Tornjak component making the change, empty if unknown
+	Actor      string `json:"actor"`
+	Action     string `json:"action"`
+	ObjectType string `json:"objectType"`
+	ObjectId   string `json:"objectId"`
+	// UID of the cluster of cluster and membership events, see ClusterHistoryEvent
+	ClusterUID string `json:"clusterUid,omitempty"`
+	// state of the object before and after the change, absent before creates
+	// and after deletes
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+	// RFC 3339 UTC time of the change
+	Timestamp string `json:"timestamp"`
+}
+
+// ClusterHistoryEvent is a change of a cluster or of its agents, from the
+// audit log
+type ClusterHistoryEvent struct {
+	AuditEvent
+	// fields of the cluster changed by cluster events, with their previous
+	// values; empty for membership events, whose objectId is the agent
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// AuditMembership is the state of the assignment of an agent to a cluster
+// recorded in the audit log
+type AuditMembership struct {
+	ClusterUID string `json:"clusterUid"`
+	// name of the cluster at the time of the change
+	Cluster string `json:"cluster"`
+}
+
+// AuditAgent is the state of an agent recorded in the audit log
+type AuditAgent struct {
+	Spiffeid    string            `json:"spiffeid"`
+	Plugin      string            `json:"plugin,omitempty"`
+	DisplayName string            `json:"displayName,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
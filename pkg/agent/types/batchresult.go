@@ -0,0 +1,70 @@
+package types
+
+// statuses of the items of bulk requests
+const (
+	BatchItemSucceeded = "succeeded"
+	BatchItemFailed    = "failed"
+	// not applied, as the request was a dry run or stopped early, or the item
+	// was already applied
+	BatchItemSkipped = "skipped"
+)
+
+// codes of the items of bulk requests rejected by Tornjak; items rejected by
+// SPIRE carry the codes of package spireerror instead
+const (
+	BatchCodeInvalidArgument = "INVALID_ARGUMENT"
+	BatchCodeNotFound        = "NOT_FOUND"
+	// item repeating an earlier item of the same request
+	BatchCodeDuplicate = "DUPLICATE"
+	// item not attempted as the request stopped before it
+	BatchCodeAborted = "ABORTED"
+	// item creating an object that already exists
+	BatchCodeAlreadyExists = "ALREADY_EXISTS"
+	// item conflicting with the stored objects, e.g. assigning an agent of
+	// another cluster
+	BatchCodeFailedPrecondition = "FAILED_PRECONDITION"
+	// item blocked by a policy, e.g. deleting a protected cluster
+	BatchCodePermissionDenied = "PERMISSION_DENIED"
+)
+
+// BatchItem is the outcome of one item of a bulk request
+type BatchItem struct {
+	// position of the item in the request, starting at 0, or row of the item
+	// in uploads, starting at 1
+	Index int `json:"index"`
+	// ID of the entry, SPIFFE ID of the agent or name or UID of the cluster
+	// of the item, if known
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	// code and message of the error of failed items, or of the reason skipped
+	// items were not applied if any
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// BatchResult is the outcome of each item of a bulk request, returned by all
+// bulk endpoints so clients retry only the items that were not applied rather
+// than the whole request
+type BatchResult struct {
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+	Skipped   int         `json:"skipped"`
+	Items     []BatchItem `json:"items"`
+}
+
+func NewBatchResult() BatchResult {
+	return BatchResult{Items: []BatchItem{}}
+}
+
+// Add records the outcome of the next item
+func (b *BatchResult) Add(item BatchItem) {
+	switch item.Status {
+	case BatchItemSucceeded:
+		b.Succeeded++
+	case BatchItemFailed:
+		b.Failed++
+	case BatchItemSkipped:
+		b.Skipped++
+	}
+	b.Items = append(b.Items, item)
+}
@@ -0,0 +1,39 @@
+package types
+
+// states of bootstrap tokens issued by the broker
+const (
+	// the token was issued and no agent attested with it yet
+	BootstrapTokenIssued = "issued"
+	// an agent attested with the token
+	BootstrapTokenConsumed = "consumed"
+	// the token expired unused
+	BootstrapTokenExpired = "expired"
+)
+
+// BootstrapToken contains the tracking record of a join token issued to a
+// provisioning system, the token itself is only returned on issuance
+type BootstrapToken struct {
+	// identifies the token without revealing it
+	ID string `json:"id"`
+	// SPIFFE ID the agent is pre-registered under, empty if none
+	AgentID     string `json:"agentId"`
+	Description string `json:"description"`
+	State       string `json:"state"`
+	IssuedBy    string `json:"issuedBy"`
+	IssuedAt    string `json:"issuedAt"`
+	ExpiresAt   string `json:"expiresAt"`
+	ConsumedAt  string `json:"consumedAt"`
+	// SPIFFE ID of the agent that attested with the token
+	ConsumedBy string `json:"consumedBy"`
+}
+
+// BootstrapTokenList contains a list of bootstrap token records
+type BootstrapTokenList struct {
+	Tokens []BootstrapToken `json:"tokens"`
+}
+
+// BootstrapCredential contains a newly issued bootstrap token
+type BootstrapCredential struct {
+	BootstrapToken BootstrapToken `json:"bootstrapToken"`
+	Token          string         `json:"token"`
+}
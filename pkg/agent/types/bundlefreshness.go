@@ -0,0 +1,37 @@
+package types
+
+// bundle endpoint profiles of federation relationships
+const (
+	BundleEndpointProfileHTTPSWeb    = "https_web"
+	BundleEndpointProfileHTTPSSPIFFE = "https_spiffe"
+)
+
+// BundleFreshness is the result of the last check of the bundle of a federated trust domain
+type BundleFreshness struct {
+	TrustDomain       string `json:"trustDomain"`
+	BundleEndpointURL string `json:"bundleEndpointUrl"`
+	Profile           string `json:"profile"`
+	// set if the bundle endpoint served a bundle on the last check
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+	// set if the bundle held by SPIRE has the authorities served by the endpoint
+	InSync bool `json:"inSync"`
+	// sequence numbers of the bundle held by SPIRE and of the bundle served by the endpoint
+	LocalSequence    uint64 `json:"localSequence"`
+	EndpointSequence uint64 `json:"endpointSequence"`
+	// time the last X.509 authority of the bundle held by SPIRE expires
+	LocalExpiresAt string `json:"localExpiresAt,omitempty"`
+
+	MonitoredSince  string `json:"monitoredSince"`
+	CheckedAt       string `json:"checkedAt"`
+	LastReachableAt string `json:"lastReachableAt,omitempty"`
+	LastInSyncAt    string `json:"lastInSyncAt,omitempty"`
+
+	Stale       bool   `json:"stale"`
+	StaleReason string `json:"staleReason,omitempty"`
+}
+
+// BundleFreshnessList contains the bundle check results of the federated trust domains
+type BundleFreshnessList struct {
+	TrustDomains []BundleFreshness `json:"trustDomains"`
+}
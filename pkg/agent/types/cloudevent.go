@@ -0,0 +1,39 @@
+package types
+
+import "encoding/json"
+
+// CloudEvent is an event of Tornjak in the structured JSON format of
+// CloudEvents 1.0, see https://github.com/cloudevents/spec
+type CloudEvent struct {
+	SpecVersion string `json:"specversion"`
+	// unique for the source
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	// type of the event, versioned by its data schema, see EventSchema
+	Type string `json:"type"`
+	// object of the event, e.g. the UID of a cluster
+	Subject string `json:"subject,omitempty"`
+	// RFC 3339 UTC time of the event
+	Time            string          `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	DataSchema      string          `json:"dataschema"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// EventSchema describes the data of a type of events, served by the schema
+// registry so consumers can validate the events they receive
+type EventSchema struct {
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+	// identifier of the schema, set as dataschema of the events
+	DataSchema  string `json:"dataschema"`
+	Description string `json:"description"`
+	// schema of the data of the events, with the keywords of OpenAPI 3
+	// schema objects as cluster metadata schemas
+	Schema json.RawMessage `json:"schema"`
+}
+
+// EventSchemaList is the schema registry of the event types of Tornjak
+type EventSchemaList struct {
+	Schemas []EventSchema `json:"schemas"`
+}
@@ -0,0 +1,73 @@
+package types
+
+import (
+	"reflect"
+	"sort"
+)
+
+// FieldChange is the value of one field before and after an edit
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// ClusterEditResult lists the fields changed by the edit of a cluster
+type ClusterEditResult struct {
+	Name    string        `json:"name"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// ClusterUpsertResult describes a cluster created or updated by UID
+type ClusterUpsertResult struct {
+	UID  string `json:"uid"`
+	Name string `json:"name"`
+	// whether no cluster had the UID, so the cluster was created
+	Created bool `json:"created"`
+	// fields changed from the stored cluster, empty if created
+	Changes []FieldChange `json:"changes"`
+}
+
+// DiffClusters returns the fields changed from before to after
+// the creation time is not compared and agent lists are compared sorted
+func DiffClusters(before, after ClusterInfo) []FieldChange {
+	changes := []FieldChange{}
+	strs := []struct {
+		field         string
+		before, after string
+	}{
+		{"name", before.Name, after.Name},
+		{"domainName", before.DomainName, after.DomainName},
+		{"managedBy", before.ManagedBy, after.ManagedBy},
+		{"platformType", before.PlatformType, after.PlatformType},
+		{"ownerEmail", before.OwnerEmail, after.OwnerEmail},
+		{"ownerTeam", before.OwnerTeam, after.OwnerTeam},
+		{"slackChannel", before.SlackChannel, after.SlackChannel},
+		{"tenant", before.Tenant, after.Tenant},
+	}
+	for _, s := range strs {
+		if s.before != s.after {
+			changes = append(changes, FieldChange{Field: s.field, Before: s.before, After: s.after})
+		}
+	}
+	beforeAgents, afterAgents := sortedCopy(before.AgentsList), sortedCopy(after.AgentsList)
+	if !reflect.DeepEqual(beforeAgents, afterAgents) {
+		changes = append(changes, FieldChange{Field: "agentsList", Before: beforeAgents, After: afterAgents})
+	}
+	if (len(before.Labels) > 0 || len(after.Labels) > 0) && !reflect.DeepEqual(before.Labels, after.Labels) {
+		changes = append(changes, FieldChange{Field: "labels", Before: before.Labels, After: after.Labels})
+	}
+	if (len(before.Extensions) > 0 || len(after.Extensions) > 0) && !reflect.DeepEqual(before.Extensions, after.Extensions) {
+		changes = append(changes, FieldChange{Field: "extensions", Before: before.Extensions, After: after.Extensions})
+	}
+	if !MetadataEqual(before.Metadata, after.Metadata) {
+		changes = append(changes, FieldChange{Field: "metadata", Before: before.Metadata, After: after.Metadata})
+	}
+	return changes
+}
+
+func sortedCopy(s []string) []string {
+	c := append([]string{}, s...)
+	sort.Strings(c)
+	return c
+}
@@ -0,0 +1,129 @@
+package types
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// types of cluster extension fields
+const (
+	ExtensionFieldString = "string"
+	ExtensionFieldNumber = "number"
+	ExtensionFieldBool   = "bool"
+)
+
+// ClusterExtensionField defines a structured field of clusters of a platform type
+type ClusterExtensionField struct {
+	Name     string
+	Type     string
+	Required bool
+	// allowed values of string fields, any value if empty
+	Enum []string
+}
+
+// ClusterExtensionSchema defines the extension fields of clusters of a platform type
+type ClusterExtensionSchema struct {
+	PlatformType string
+	Fields       []ClusterExtensionField
+}
+
+// ClusterExtensionSchemas maps platform types to their extension schema
+type ClusterExtensionSchemas map[string]ClusterExtensionSchema
+
+// NewClusterExtensionSchemas checks the given schemas and indexes them by platform type
+func NewClusterExtensionSchemas(schemas []ClusterExtensionSchema) (ClusterExtensionSchemas, error) {
+	ret := make(ClusterExtensionSchemas, len(schemas))
+	for _, schema := range schemas {
+		if len(schema.PlatformType) == 0 {
+			return nil, errors.New("cluster extension schema missing platform type")
+		}
+		if _, ok := ret[schema.PlatformType]; ok {
+			return nil, errors.Errorf("duplicate cluster extension schema for platform type %q", schema.PlatformType)
+		}
+		names := make(map[string]bool)
+		for _, field := range schema.Fields {
+			if len(field.Name) == 0 || names[field.Name] {
+				return nil, errors.Errorf("invalid or duplicate extension field name %q for platform type %q", field.Name, schema.PlatformType)
+			}
+			names[field.Name] = true
+			switch field.Type {
+			case ExtensionFieldString:
+			case ExtensionFieldNumber, ExtensionFieldBool:
+				if len(field.Enum) > 0 {
+					return nil, errors.Errorf("extension field %q: enum only allowed for string fields", field.Name)
+				}
+			default:
+				return nil, errors.Errorf("extension field %q: invalid type %q", field.Name, field.Type)
+			}
+		}
+		ret[schema.PlatformType] = schema
+	}
+	return ret, nil
+}
+
+// Validate checks the extensions of c against the schema of its platform type
+// clusters of platform types without a schema must not have extensions
+func (s ClusterExtensionSchemas) Validate(c ClusterInfo) error {
+	schema, ok := s[c.PlatformType]
+	if !ok {
+		if len(c.Extensions) > 0 {
+			return errors.Errorf("platform type %q has no extension fields", c.PlatformType)
+		}
+		return nil
+	}
+
+	fields := make(map[string]ClusterExtensionField, len(schema.Fields))
+	for _, field := range schema.Fields {
+		fields[field.Name] = field
+		if _, ok := c.Extensions[field.Name]; field.Required && !ok {
+			return errors.Errorf("missing required extension field %q for platform type %q", field.Name, c.PlatformType)
+		}
+	}
+
+	// check in a stable order so errors are reproducible
+	names := make([]string, 0, len(c.Extensions))
+	for name := range c.Extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field, ok := fields[name]
+		if !ok {
+			return errors.Errorf("unknown extension field %q for platform type %q", name, c.PlatformType)
+		}
+		if err := field.validate(c.Extensions[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f ClusterExtensionField) validate(value interface{}) error {
+	switch f.Type {
+	case ExtensionFieldString:
+		str, ok := value.(string)
+		if !ok {
+			return errors.Errorf("extension field %q must be a string", f.Name)
+		}
+		if len(f.Enum) == 0 {
+			return nil
+		}
+		for _, allowed := range f.Enum {
+			if str == allowed {
+				return nil
+			}
+		}
+		return errors.Errorf("extension field %q must be one of %s", f.Name, strings.Join(f.Enum, ", "))
+	case ExtensionFieldNumber:
+		if _, ok := value.(float64); !ok {
+			return errors.Errorf("extension field %q must be a number", f.Name)
+		}
+	case ExtensionFieldBool:
+		if _, ok := value.(bool); !ok {
+			return errors.Errorf("extension field %q must be a boolean", f.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package types
+
+import (
+	"testing"
+)
+
+// TestClusterExtensionSchemas checks extension fields are validated against the schema of the platform type
+func TestClusterExtensionSchemas(t *testing.T) {
+	schemas, err := NewClusterExtensionSchemas([]ClusterExtensionSchema{
+		{
+			PlatformType: "Kubernetes",
+			Fields: []ClusterExtensionField{
+				{Name: "version", Type: ExtensionFieldString, Required: true},
+				{Name: "cni", Type: ExtensionFieldString, Enum: []string{"calico", "cilium"}},
+				{Name: "nodes", Type: ExtensionFieldNumber},
+			},
+		},
+		{
+			PlatformType: "VM",
+			Fields: []ClusterExtensionField{
+				{Name: "hypervisor", Type: ExtensionFieldString},
+				{Name: "confidential", Type: ExtensionFieldBool},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := []ClusterInfo{
+		{PlatformType: "Docker"},
+		{PlatformType: "Kubernetes", Extensions: map[string]interface{}{"version": "1.29"}},
+		{PlatformType: "Kubernetes", Extensions: map[string]interface{}{"version": "1.29", "cni": "cilium", "nodes": float64(3)}},
+		{PlatformType: "VM", Extensions: map[string]interface{}{"confidential": true}},
+	}
+	for _, c := range valid {
+		if err := schemas.Validate(c); err != nil {
+			t.Fatalf("Expected %v to be valid: %v", c, err)
+		}
+	}
+
+	invalid := []ClusterInfo{
+		{PlatformType: "Docker", Extensions: map[string]interface{}{"version": "1"}},
+		{PlatformType: "Kubernetes"},
+		{PlatformType: "Kubernetes", Extensions: map[string]interface{}{"version": 1.29}},
+		{PlatformType: "Kubernetes", Extensions: map[string]interface{}{"version": "1.29", "cni": "flannel"}},
+		{PlatformType: "Kubernetes", Extensions: map[string]interface{}{"version": "1.29", "hypervisor": "kvm"}},
+		{PlatformType: "VM", Extensions: map[string]interface{}{"confidential": "yes"}},
+	}
+	for _, c := range invalid {
+		if err := schemas.Validate(c); err == nil {
+			t.Fatalf("Expected %v to be invalid", c)
+		}
+	}
+}
+
+// TestNewClusterExtensionSchemasInvalid checks invalid schemas are rejected
+func TestNewClusterExtensionSchemasInvalid(t *testing.T) {
+	invalid := [][]ClusterExtensionSchema{
+		{{PlatformType: ""}},
+		{{PlatformType: "VM"}, {PlatformType: "VM"}},
+		{{PlatformType: "VM", Fields: []ClusterExtensionField{{Name: "a", Type: "int"}}}},
+		{{PlatformType: "VM", Fields: []ClusterExtensionField{{Name: "a", Type: ExtensionFieldString}, {Name: "a", Type: ExtensionFieldString}}}},
+		{{PlatformType: "VM", Fields: []ClusterExtensionField{{Name: "a", Type: ExtensionFieldBool, Enum: []string{"x"}}}}},
+	}
+	for _, schemas := range invalid {
+		if _, err := NewClusterExtensionSchemas(schemas); err == nil {
+			t.Fatalf("Expected %v to be invalid", schemas)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package types
+
+// changes recorded in the history of clusters
+const (
+	ClusterChangeCreated = "created"
+	ClusterChangeUpdated = "updated"
+	ClusterChangeDeleted = "deleted"
+	// deleted cluster restored with its UID, see RestoreClusterEntry
+	ClusterChangeRestored = "restored"
+	// state of a cluster that existed before its history was recorded
+	ClusterChangeRecorded = "recorded"
+)
+
+// ClusterChange is an entry of the history of clusters
+type ClusterChange struct {
+	ClusterUID string `json:"clusterUid"`
+	// name of the cluster after the change, or before it was deleted
+	Name      string `json:"name"`
+	Change    string `json:"change"`
+	ChangedAt string `json:"changedAt"`
+}
@@ -1,15 +1,68 @@
 package types
 
+import (
+	"encoding/json"
+	"net/mail"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
 // ClusterInfo contains the meta-information about clusters
-// TODO include details field for extra info/tags in json format (probably a byte array)
 type ClusterInfo struct {
-	Name         string   `json:"name"`
-	EditedName   string   `json:"editedName"`
-	CreationTime string   `json:"creationTime"`
-	DomainName   string   `json:"domainName"`
-	ManagedBy    string   `json:"managedBy"`
-	PlatformType string   `json:"platformType"`
-	AgentsList   []string `json:"agentsList"`
+	Name string `json:"name"`
+	// identifies the cluster across renames, set by the DB
+	UID        string `json:"uid,omitempty"`
+	EditedName string `json:"editedName"`
+	// set by the DB, in UTC
+	CreationTime time.Time `json:"creationTime"`
+	// time of the last change of the cluster, its agents, labels or extension fields, set by the DB in UTC
+	UpdatedAt    time.Time `json:"updatedAt"`
+	DomainName   string    `json:"domainName"`
+	ManagedBy    string    `json:"managedBy"`
+	PlatformType string    `json:"platformType"`
+	AgentsList   []string  `json:"agentsList"`
+	OwnerEmail   string    `json:"ownerEmail"`
+	OwnerTeam    string    `json:"ownerTeam"`
+	SlackChannel string    `json:"slackChannel"`
+	Tenant       string    `json:"tenant"`
+	// labels such as env:prod, for grouping clusters
+	Labels map[string]string `json:"labels,omitempty"`
+	// platform-specific fields, validated against the schema of the platform type
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+	// structured data of integrators, such as cost centers or ticket links,
+	// a JSON object validated against the schema configured on the server
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	// whether deletes of the cluster are rejected; set at creation or with
+	// SetClusterProtection, and kept by edits
+	Protected bool `json:"protected"`
+}
+
+// slack channel names are lowercase, up to 80 characters, with a leading #
+var slackChannelRegexp = regexp.MustCompile(`^#[a-z0-9][a-z0-9._-]{0,79}$`)
+
+// maximum length of a cluster owner team name
+const maxOwnerTeamLength = 128
+
+// ValidateContacts checks the format of the cluster contact and ownership fields that are set
+func (c ClusterInfo) ValidateContacts() error {
+	if len(c.OwnerEmail) > 0 {
+		addr, err := mail.ParseAddress(c.OwnerEmail)
+		if err != nil || addr.Address != c.OwnerEmail {
+			return errors.Errorf("invalid owner email %q", c.OwnerEmail)
+		}
+	}
+	if len(c.OwnerTeam) > maxOwnerTeamLength {
+		return errors.Errorf("owner team longer than %d characters", maxOwnerTeamLength)
+	}
+	if len(c.SlackChannel) > 0 && !slackChannelRegexp.MatchString(c.SlackChannel) {
+		return errors.Errorf("invalid slack channel %q", c.SlackChannel)
+	}
+	if len(c.Tenant) > maxTenantLength {
+		return errors.Errorf("tenant longer than %d characters", maxTenantLength)
+	}
+	return nil
 }
 
 type ClusterInput struct {
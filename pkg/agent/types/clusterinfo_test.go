@@ -0,0 +1,66 @@
+package types
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestValidateContacts checks the format checks of cluster contact fields
+func TestValidateContacts(t *testing.T) {
+	valid := []ClusterInfo{
+		{},
+		{OwnerEmail: "owner@example.org", OwnerTeam: "platform", SlackChannel: "#platform-alerts", Tenant: "payments"},
+	}
+	for _, c := range valid {
+		if err := c.ValidateContacts(); err != nil {
+			t.Fatalf("Expected %v to be valid: %v", c, err)
+		}
+	}
+
+	invalid := []ClusterInfo{
+		{OwnerEmail: "owner"},
+		{OwnerEmail: "Owner <owner@example.org>"},
+		{SlackChannel: "platform-alerts"},
+		{SlackChannel: "#Platform Alerts"},
+		{Tenant: strings.Repeat("t", maxTenantLength+1)},
+	}
+	for _, c := range invalid {
+		if err := c.ValidateContacts(); err == nil {
+			t.Fatalf("Expected %v to be invalid", c)
+		}
+	}
+}
+
+// TestDiffClusters checks only changed cluster fields are listed with their values
+func TestDiffClusters(t *testing.T) {
+	before := ClusterInfo{
+		Name:         "dev",
+		CreationTime: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+		PlatformType: "VMs",
+		AgentsList:   []string{"spiffe://example.org/b", "spiffe://example.org/a"},
+		OwnerTeam:    "platform",
+		Labels:       map[string]string{"env": "dev"},
+	}
+	after := before
+	after.CreationTime = time.Time{}
+	after.AgentsList = []string{"spiffe://example.org/a", "spiffe://example.org/b"}
+	if changes := DiffClusters(before, after); len(changes) != 0 {
+		t.Fatalf("Expected no changes, got %+v", changes)
+	}
+
+	after.Name = "prod"
+	after.AgentsList = []string{"spiffe://example.org/a"}
+	after.Labels = map[string]string{"env": "prod"}
+	changes := DiffClusters(before, after)
+	fields := []string{}
+	for _, c := range changes {
+		fields = append(fields, c.Field)
+	}
+	if strings.Join(fields, ",") != "name,agentsList,labels" {
+		t.Fatalf("Expected changes of name, agentsList and labels, got %+v", changes)
+	}
+	if changes[0].Before != "dev" || changes[0].After != "prod" {
+		t.Fatalf("Expected name change from dev to prod, got %+v", changes[0])
+	}
+}
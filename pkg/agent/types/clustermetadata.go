@@ -0,0 +1,124 @@
+package types
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/pkg/errors"
+)
+
+// MaxClusterMetadataSize is the maximum size in bytes of the metadata of a cluster
+const MaxClusterMetadataSize = 64 << 10
+
+// CompactMetadata returns the metadata of a cluster without insignificant
+// whitespace, as stored by the DataStores, nil if empty or null
+func CompactMetadata(metadata json.RawMessage) (json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(metadata)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, trimmed); err != nil {
+		return nil, errors.Errorf("invalid cluster metadata: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ValidateMetadata checks the metadata of the cluster is a JSON object of up
+// to MaxClusterMetadataSize bytes, if set
+func (c ClusterInfo) ValidateMetadata() error {
+	metadata, err := CompactMetadata(c.Metadata)
+	if err != nil || metadata == nil {
+		return err
+	}
+	if len(metadata) > MaxClusterMetadataSize {
+		return errors.Errorf("cluster metadata larger than %d bytes", MaxClusterMetadataSize)
+	}
+	if metadata[0] != '{' {
+		return errors.New("cluster metadata must be a JSON object")
+	}
+	return nil
+}
+
+// MetadataEqual returns whether two metadata are the same JSON, ignoring
+// insignificant whitespace
+func MetadataEqual(a, b json.RawMessage) bool {
+	ca, errA := CompactMetadata(a)
+	cb, errB := CompactMetadata(b)
+	if errA != nil || errB != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(ca, cb)
+}
+
+// ClusterMetadataSchema validates the metadata of clusters against a JSON
+// schema configured on the server
+// the zero value accepts any metadata
+type ClusterMetadataSchema struct {
+	raw    json.RawMessage
+	schema *openapi3.Schema
+	// whether clusters must have metadata
+	required bool
+}
+
+// NewClusterMetadataSchema parses a JSON schema of cluster metadata, with the
+// keywords of OpenAPI 3 schema objects: type, properties, required, enum,
+// pattern, format, minimum, items, additionalProperties, oneOf, ...
+// if required, clusters without metadata are rejected
+func NewClusterMetadataSchema(data []byte, required bool) (ClusterMetadataSchema, error) {
+	schema := &openapi3.Schema{}
+	if err := json.Unmarshal(data, schema); err != nil {
+		return ClusterMetadataSchema{}, errors.Errorf("invalid JSON schema: %v", err)
+	}
+	if err := schema.Validate(context.Background()); err != nil {
+		return ClusterMetadataSchema{}, errors.Errorf("invalid JSON schema: %v", err)
+	}
+	raw, err := CompactMetadata(data)
+	if err != nil {
+		return ClusterMetadataSchema{}, err
+	}
+	return ClusterMetadataSchema{raw: raw, schema: schema, required: required}, nil
+}
+
+// JSON returns the schema, nil if none is configured
+func (s ClusterMetadataSchema) JSON() json.RawMessage {
+	return s.raw
+}
+
+// Required returns whether clusters must have metadata
+func (s ClusterMetadataSchema) Required() bool {
+	return s.required
+}
+
+// Validate checks the format of the metadata of the cluster, then that it
+// matches the schema
+func (s ClusterMetadataSchema) Validate(c ClusterInfo) error {
+	if err := c.ValidateMetadata(); err != nil {
+		return err
+	}
+	metadata, _ := CompactMetadata(c.Metadata)
+	if metadata == nil {
+		if s.required {
+			return errors.Errorf("cluster %s has no metadata", c.Name)
+		}
+		return nil
+	}
+	if s.schema == nil {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(metadata, &value); err != nil {
+		return errors.Errorf("invalid cluster metadata: %v", err)
+	}
+	if err := s.schema.VisitJSON(value); err != nil {
+		var schemaErr *openapi3.SchemaError
+		if errors.As(err, &schemaErr) {
+			return errors.Errorf("cluster metadata field \"/%s\": %s", strings.Join(schemaErr.JSONPointer(), "/"), schemaErr.Reason)
+		}
+		return errors.Errorf("invalid cluster metadata: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package types
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestClusterMetadataSchema checks metadata must be a JSON object matching the
+// configured schema, and is only required when configured so
+func TestClusterMetadataSchema(t *testing.T) {
+	// CHECK any object is accepted without a schema, and no metadata too
+	for _, metadata := range []string{"", "null", `{}`, `{"costCenter": 42, "tickets": ["OPS-1"]}`} {
+		if err := (ClusterMetadataSchema{}).Validate(ClusterInfo{Name: "cluster1", Metadata: json.RawMessage(metadata)}); err != nil {
+			t.Fatalf("Expected metadata %q to be valid: %v", metadata, err)
+		}
+	}
+	// CHECK metadata other than JSON objects is rejected
+	for _, metadata := range []string{`[]`, `"a"`, `42`, `{"a":`, `{"a": "` + strings.Repeat("a", MaxClusterMetadataSize) + `"}`} {
+		if err := (ClusterMetadataSchema{}).Validate(ClusterInfo{Name: "cluster1", Metadata: json.RawMessage(metadata)}); err == nil {
+			t.Fatalf("Expected metadata %.20q to be invalid", metadata)
+		}
+	}
+
+	// ATTEMPT configure a schema [NewClusterMetadataSchema]
+	schema, err := NewClusterMetadataSchema([]byte(`{
+		"type": "object",
+		"required": ["costCenter"],
+		"properties": {
+			"costCenter": {"type": "string", "pattern": "^CC-[0-9]+$"},
+			"tickets": {"type": "array", "items": {"type": "string"}}
+		}
+	}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK schema is returned compacted, and metadata is required
+	if got := string(schema.JSON()); !strings.HasPrefix(got, `{"type":"object","required":["costCenter"]`) || !schema.Required() {
+		t.Fatalf("Unexpected schema %s", got)
+	}
+
+	// CHECK metadata matching the schema is accepted
+	if err := schema.Validate(ClusterInfo{Name: "cluster1", Metadata: json.RawMessage(`{"costCenter": "CC-1", "tickets": ["OPS-1"]}`)}); err != nil {
+		t.Fatal(err)
+	}
+	// CHECK metadata not matching the schema is rejected with the offending field
+	err = schema.Validate(ClusterInfo{Name: "cluster1", Metadata: json.RawMessage(`{"costCenter": "CC-1", "tickets": [1]}`)})
+	if err == nil || !strings.Contains(err.Error(), `"/tickets/0"`) {
+		t.Fatalf("Expected invalid tickets, got %v", err)
+	}
+	for _, metadata := range []string{"", `{"tickets": []}`, `{"costCenter": "1"}`} {
+		if err := schema.Validate(ClusterInfo{Name: "cluster1", Metadata: json.RawMessage(metadata)}); err == nil {
+			t.Fatalf("Expected metadata %q to be invalid", metadata)
+		}
+	}
+
+	// CHECK invalid schemas are rejected [NewClusterMetadataSchema]
+	for _, data := range []string{``, `[]`, `{"type": "object"`, `{"type": "unknown"}`} {
+		if _, err := NewClusterMetadataSchema([]byte(data), false); err == nil {
+			t.Fatalf("Expected schema %q to be invalid", data)
+		}
+	}
+}
+
+// TestDiffClustersMetadata checks metadata differing only in whitespace is unchanged
+func TestDiffClustersMetadata(t *testing.T) {
+	before := ClusterInfo{Name: "cluster1", Metadata: json.RawMessage(`{"a": 1}`)}
+	if changes := DiffClusters(before, ClusterInfo{Name: "cluster1", Metadata: json.RawMessage(`{"a":1}`)}); len(changes) != 0 {
+		t.Fatalf("Expected no changes, got %+v", changes)
+	}
+	changes := DiffClusters(before, ClusterInfo{Name: "cluster1"})
+	if len(changes) != 1 || changes[0].Field != "metadata" {
+		t.Fatalf("Expected metadata change, got %+v", changes)
+	}
+}
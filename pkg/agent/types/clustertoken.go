@@ -0,0 +1,32 @@
+package types
+
+// access levels of cluster tokens
+const (
+	// the token can read its cluster
+	ClusterTokenRead = "read"
+	// the token can read and edit its cluster, including its agent membership
+	ClusterTokenWrite = "write"
+)
+
+// ClusterToken contains the information about an API key restricted to a
+// single cluster, for controllers running in that cluster
+// the API key of the token is only returned on creation
+type ClusterToken struct {
+	Name         string `json:"name"`
+	ClusterUID   string `json:"clusterUid"`
+	Access       string `json:"access"`
+	Description  string `json:"description"`
+	CreatedBy    string `json:"createdBy"`
+	CreationTime string `json:"creationTime"`
+}
+
+// ClusterTokenList contains a list of cluster tokens
+type ClusterTokenList struct {
+	ClusterTokens []ClusterToken `json:"clusterTokens"`
+}
+
+// ClusterTokenCredential contains a newly issued cluster token API key
+type ClusterTokenCredential struct {
+	ClusterToken ClusterToken `json:"clusterToken"`
+	APIKey       string       `json:"apiKey"`
+}
@@ -0,0 +1,96 @@
+package types
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// AgentComplianceReport contains compliance attributes of a node, such as kernel
+// version or CIS score, reported by an external agent or scanner
+type AgentComplianceReport struct {
+	Spiffeid   string            `json:"spiffeid"`
+	Source     string            `json:"source"`
+	Attributes map[string]string `json:"attributes"`
+	ReportedAt string            `json:"reportedAt"`
+}
+
+// AgentComplianceRecord contains a reported value of a compliance attribute
+type AgentComplianceRecord struct {
+	Attribute  string `json:"attribute"`
+	Value      string `json:"value"`
+	Source     string `json:"source"`
+	ReportedAt string `json:"reportedAt"`
+}
+
+// AgentComplianceHistory contains the reported compliance attribute values of an agent, newest first
+type AgentComplianceHistory struct {
+	Spiffeid string                  `json:"spiffeid"`
+	Records  []AgentComplianceRecord `json:"records"`
+}
+
+// operators of compliance attribute filters
+// lt, le, gt and ge compare values as numbers
+const (
+	ComplianceOpEq = "eq"
+	ComplianceOpNe = "ne"
+	ComplianceOpLt = "lt"
+	ComplianceOpLe = "le"
+	ComplianceOpGt = "gt"
+	ComplianceOpGe = "ge"
+)
+
+// ComplianceFilter matches agents whose current value of a compliance attribute
+// compares to Value with Op, eq if empty
+type ComplianceFilter struct {
+	Attribute string `json:"attribute"`
+	Op        string `json:"op,omitempty"`
+	Value     string `json:"value"`
+}
+
+// compliance attribute names are lowercase, e.g. kernel_version or cis.score
+var complianceAttributeRegexp = regexp.MustCompile(`^[a-z][a-z0-9_.-]{0,63}$`)
+
+const (
+	maxComplianceAttributes   = 64
+	maxComplianceValueLength  = 256
+	maxComplianceSourceLength = 128
+)
+
+// Validate checks the report has a spiffeid and well-formed attributes
+func (r AgentComplianceReport) Validate() error {
+	if len(r.Spiffeid) == 0 {
+		return errors.New("input missing mandatory field - Spiffeid")
+	}
+	if len(r.Attributes) == 0 {
+		return errors.New("input missing mandatory field - Attributes")
+	}
+	if len(r.Attributes) > maxComplianceAttributes {
+		return errors.Errorf("more than %d attributes in report", maxComplianceAttributes)
+	}
+	if len(r.Source) > maxComplianceSourceLength {
+		return errors.Errorf("source longer than %d characters", maxComplianceSourceLength)
+	}
+	for name, value := range r.Attributes {
+		if !complianceAttributeRegexp.MatchString(name) {
+			return errors.Errorf("invalid attribute name %q", name)
+		}
+		if len(value) > maxComplianceValueLength {
+			return errors.Errorf("value of attribute %q longer than %d characters", name, maxComplianceValueLength)
+		}
+	}
+	return nil
+}
+
+// Validate checks the filter names an attribute and a known operator
+func (f ComplianceFilter) Validate() error {
+	if !complianceAttributeRegexp.MatchString(f.Attribute) {
+		return errors.Errorf("invalid attribute name %q", f.Attribute)
+	}
+	switch f.Op {
+	case "", ComplianceOpEq, ComplianceOpNe, ComplianceOpLt, ComplianceOpLe, ComplianceOpGt, ComplianceOpGe:
+		return nil
+	default:
+		return errors.Errorf("invalid compliance filter operator %q", f.Op)
+	}
+}
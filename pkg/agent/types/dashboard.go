@@ -0,0 +1,89 @@
+package types
+
+import (
+	"sort"
+	"time"
+)
+
+// maximum number of expiring agent SVIDs listed on the dashboard, soonest first
+const MaxDashboardExpiringSVIDs = 20
+
+// DashboardAgent is the part of a SPIRE agent aggregated on the dashboard
+type DashboardAgent struct {
+	Spiffeid string
+	// expiry of the agent's X509-SVID, in seconds since the epoch
+	X509SvidExpiresAt int64
+	Banned            bool
+}
+
+// ExpiringSVID is an agent whose X509-SVID expires soon
+type ExpiringSVID struct {
+	Spiffeid  string `json:"spiffeid"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+// FleetDashboard contains the aggregates shown on the home page
+type FleetDashboard struct {
+	Clusters int `json:"clusters"`
+	// agents of the SPIRE server, including banned agents
+	Agents int `json:"agents"`
+	// agents of the SPIRE server not assigned to a cluster
+	UnassignedAgents int `json:"unassignedAgents"`
+	// window of expiring SVIDs, e.g. 24h0m0s
+	ExpiringWithin string `json:"expiringWithin"`
+	// number of agents not banned whose X509-SVID expires within ExpiringWithin,
+	// or has expired
+	ExpiringSVIDCount int `json:"expiringSvidCount"`
+	// the first MaxDashboardExpiringSVIDs of them, soonest first
+	ExpiringSVIDs []ExpiringSVID  `json:"expiringSvids"`
+	RecentChanges []ClusterChange `json:"recentChanges"`
+	// time the aggregates were computed
+	ComputedAt string `json:"computedAt"`
+	// error of the last refresh, the aggregates are those of the last successful refresh
+	LastRefreshError string `json:"lastRefreshError,omitempty"`
+}
+
+// NewFleetDashboard aggregates the clusters and agents at time now
+func NewFleetDashboard(clusters []ClusterInfo, agents []DashboardAgent, changes []ClusterChange, now time.Time, expiringWithin time.Duration) FleetDashboard {
+	assigned := make(map[string]bool)
+	for _, cluster := range clusters {
+		for _, agent := range cluster.AgentsList {
+			assigned[agent] = true
+		}
+	}
+
+	dashboard := FleetDashboard{
+		Clusters:       len(clusters),
+		Agents:         len(agents),
+		ExpiringWithin: expiringWithin.String(),
+		ExpiringSVIDs:  []ExpiringSVID{},
+		RecentChanges:  changes,
+		ComputedAt:     now.UTC().Format(time.RFC3339),
+	}
+	if dashboard.RecentChanges == nil {
+		dashboard.RecentChanges = []ClusterChange{}
+	}
+	deadline := now.Add(expiringWithin).Unix()
+	expiring := []DashboardAgent{}
+	for _, agent := range agents {
+		if !assigned[agent.Spiffeid] {
+			dashboard.UnassignedAgents++
+		}
+		// agents without SVID expiry have not attested
+		if !agent.Banned && agent.X509SvidExpiresAt > 0 && agent.X509SvidExpiresAt <= deadline {
+			expiring = append(expiring, agent)
+		}
+	}
+	sort.SliceStable(expiring, func(i, j int) bool { return expiring[i].X509SvidExpiresAt < expiring[j].X509SvidExpiresAt })
+	dashboard.ExpiringSVIDCount = len(expiring)
+	for i, agent := range expiring {
+		if i == MaxDashboardExpiringSVIDs {
+			break
+		}
+		dashboard.ExpiringSVIDs = append(dashboard.ExpiringSVIDs, ExpiringSVID{
+			Spiffeid:  agent.Spiffeid,
+			ExpiresAt: time.Unix(agent.X509SvidExpiresAt, 0).UTC().Format(time.RFC3339),
+		})
+	}
+	return dashboard
+}
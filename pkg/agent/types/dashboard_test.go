@@ -0,0 +1,47 @@
+package types
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestNewFleetDashboard checks agents are counted as unassigned and expiring
+func TestNewFleetDashboard(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clusters := []ClusterInfo{
+		{Name: "cluster1", AgentsList: []string{"spiffe://example.org/agent1", "spiffe://example.org/agent2"}},
+		{Name: "cluster2", AgentsList: []string{}},
+	}
+	agents := []DashboardAgent{
+		{Spiffeid: "spiffe://example.org/agent1", X509SvidExpiresAt: now.Add(48 * time.Hour).Unix()},
+		{Spiffeid: "spiffe://example.org/agent2", X509SvidExpiresAt: now.Add(2 * time.Hour).Unix()},
+		{Spiffeid: "spiffe://example.org/agent3", X509SvidExpiresAt: now.Add(-time.Hour).Unix()},
+		{Spiffeid: "spiffe://example.org/agent4", X509SvidExpiresAt: now.Add(time.Hour).Unix(), Banned: true},
+		{Spiffeid: "spiffe://example.org/agent5"},
+	}
+
+	dashboard := NewFleetDashboard(clusters, agents, nil, now, 24*time.Hour)
+	if dashboard.Clusters != 2 || dashboard.Agents != 5 || dashboard.UnassignedAgents != 3 {
+		t.Fatalf("Unexpected counts: %+v", dashboard)
+	}
+	if dashboard.ExpiringSVIDCount != 2 || len(dashboard.ExpiringSVIDs) != 2 ||
+		dashboard.ExpiringSVIDs[0].Spiffeid != "spiffe://example.org/agent3" ||
+		dashboard.ExpiringSVIDs[1].ExpiresAt != "2024-05-01T14:00:00Z" {
+		t.Fatalf("Unexpected expiring SVIDs: %+v", dashboard.ExpiringSVIDs)
+	}
+	if dashboard.RecentChanges == nil || dashboard.ComputedAt != "2024-05-01T12:00:00Z" || dashboard.ExpiringWithin != "24h0m0s" {
+		t.Fatalf("Unexpected dashboard: %+v", dashboard)
+	}
+
+	// CHECK the list of expiring SVIDs is capped, not the count
+	agents = []DashboardAgent{}
+	for i := 0; i < MaxDashboardExpiringSVIDs+5; i++ {
+		agents = append(agents, DashboardAgent{Spiffeid: fmt.Sprintf("spiffe://example.org/agent%d", i), X509SvidExpiresAt: now.Unix()})
+	}
+	dashboard = NewFleetDashboard(nil, agents, nil, now, time.Hour)
+	if dashboard.ExpiringSVIDCount != MaxDashboardExpiringSVIDs+5 || len(dashboard.ExpiringSVIDs) != MaxDashboardExpiringSVIDs {
+		t.Fatalf("Expected %d of %d expiring SVIDs, got %d of %d", MaxDashboardExpiringSVIDs, MaxDashboardExpiringSVIDs+5,
+			len(dashboard.ExpiringSVIDs), dashboard.ExpiringSVIDCount)
+	}
+}
@@ -0,0 +1,44 @@
+package types
+
+// DeletedCluster is a cluster kept after its deletion, so it can be restored
+type DeletedCluster struct {
+	// state of the cluster when it was deleted, with its UID and agents
+	Cluster   ClusterInfo `json:"cluster"`
+	DeletedAt string      `json:"deletedAt"`
+}
+
+// DeletedClusterList contains the deleted clusters, most recently deleted first
+type DeletedClusterList struct {
+	Clusters []DeletedCluster `json:"clusters"`
+}
+
+// ClusterRestoreResult describes a restored cluster
+type ClusterRestoreResult struct {
+	Name string `json:"name"`
+	UID  string `json:"uid"`
+	// agents of the deleted cluster that were assigned to another cluster
+	// since, and stay there
+	SkippedAgents []string `json:"skippedAgents"`
+}
+
+// ClusterDeletion is a deleted cluster whose agent memberships are removed in
+// the background, in batches, so deleting a cluster with many agents does not
+// lock the DataStore; the cluster is no longer listed and its agents are
+// unassigned from the start
+type ClusterDeletion struct {
+	ID   int64  `json:"id"`
+	UID  string `json:"uid"`
+	Name string `json:"name"`
+	// memberships of the cluster when deleted, and those not removed yet
+	Memberships int    `json:"memberships"`
+	Remaining   int    `json:"remaining"`
+	DeletedAt   string `json:"deletedAt"`
+	// error of the last batch of memberships, which is retried
+	Error string `json:"error,omitempty"`
+}
+
+// ClusterDeletionList contains the clusters whose memberships are being
+// removed, oldest deletion first
+type ClusterDeletionList struct {
+	Deletions []ClusterDeletion `json:"deletions"`
+}
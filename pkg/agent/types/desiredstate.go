@@ -0,0 +1,51 @@
+package types
+
+// DesiredState describes the cluster metadata Tornjak is reconciled towards
+type DesiredState struct {
+	Clusters []ClusterInfo `json:"clusters"`
+	// rules assigning agents to clusters, the first matching rule wins
+	AgentRules []AgentClassificationRule `json:"agentRules"`
+	// delete clusters that are not part of the desired state
+	Prune bool `json:"prune"`
+}
+
+// AgentClassificationRule assigns the agents with SPIFFE IDs matching a glob
+// pattern to a cluster of the desired state
+type AgentClassificationRule struct {
+	Match   string `json:"match"`
+	Cluster string `json:"cluster"`
+}
+
+// DesiredStateDrift describes a cluster that differs from its desired state
+type DesiredStateDrift struct {
+	Cluster string `json:"cluster"`
+	// one of create, update or delete
+	Action string `json:"action"`
+	// fields that differ for updates
+	Fields []string `json:"fields,omitempty"`
+	// set if the change was written to the DB
+	Applied bool `json:"applied"`
+}
+
+// DesiredStateReport is the result of a reconciliation of the desired state
+type DesiredStateReport struct {
+	Source    string              `json:"source"`
+	DryRun    bool                `json:"dryRun"`
+	CheckedAt string              `json:"checkedAt"`
+	InSync    bool                `json:"inSync"`
+	Drift     []DesiredStateDrift `json:"drift"`
+	Errors    []string            `json:"errors,omitempty"`
+}
+
+// ChangeProposal describes a change committed to a git branch for review
+// instead of being applied to the DB
+type ChangeProposal struct {
+	Branch  string `json:"branch"`
+	Commit  string `json:"commit"`
+	File    string `json:"file"`
+	Summary string `json:"summary"`
+	// set if the branch was pushed to the remote
+	Pushed       bool   `json:"pushed"`
+	CreatedBy    string `json:"createdBy"`
+	CreationTime string `json:"creationTime"`
+}
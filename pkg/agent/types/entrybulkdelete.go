@@ -0,0 +1,42 @@
+package types
+
+// EntryFilter selects SPIRE entries for bulk deletion
+// an entry matches if it matches every criterion that is set, at least one must be set
+type EntryFilter struct {
+	// SPIFFE ID of the parent of the entries
+	ParentId string `json:"parentId,omitempty"`
+	// selectors as type:value, e.g. k8s:ns:dev; entries match if they have all of them
+	Selectors []string `json:"selectors,omitempty"`
+	// prefix of the SPIFFE IDs of the entries, e.g. spiffe://example.org/ns/dev/
+	SpiffeIdPrefix string `json:"spiffeIdPrefix,omitempty"`
+}
+
+// EntryBulkDeleteFailure is an entry SPIRE did not delete
+type EntryBulkDeleteFailure struct {
+	EntryId string `json:"entryId"`
+	// code of the SPIRE error, see package spireerror
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error"`
+}
+
+// EntryBulkDelete is the outcome of deleting the entries matching a filter
+type EntryBulkDelete struct {
+	DryRun bool `json:"dryRun"`
+	// IDs of the matching entries
+	EntryIds []string `json:"entryIds"`
+	// set if more entries match than the confirmation threshold and the request
+	// did not carry the confirmation token; nothing is deleted then
+	ConfirmationRequired bool `json:"confirmationRequired,omitempty"`
+	// token to send back to delete the matching entries, valid as long as the
+	// filter matches the same entries
+	ConfirmationToken string                   `json:"confirmationToken,omitempty"`
+	Deleted           int                      `json:"deleted"`
+	Failures          []EntryBulkDeleteFailure `json:"failures"`
+	// matching entries not attempted because the deletion stopped
+	Remaining int `json:"remaining"`
+	// reason the deletion stopped before all batches were sent
+	Error string `json:"error,omitempty"`
+	// outcome of each matching entry, by its index in EntryIds; entries are
+	// skipped in dry runs, without confirmation and once the deletion stopped
+	Batch BatchResult `json:"batch"`
+}
@@ -0,0 +1,85 @@
+package types
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// lifecycle states of entries tracked by Tornjak
+const (
+	// the entry is under review and not relied on yet
+	EntryLifecycleProposed = "proposed"
+	// the entry is in use
+	EntryLifecycleActive = "active"
+	// the entry is still in use but should be replaced
+	EntryLifecycleDeprecated = "deprecated"
+	// the entry is deleted from SPIRE at RemoveAt
+	EntryLifecycleScheduledForRemoval = "scheduled_for_removal"
+	// the entry was deleted from SPIRE at RemoveAt, set by Tornjak only
+	EntryLifecycleRemoved = "removed"
+)
+
+// maximum length of the reason of a lifecycle change
+const maxLifecycleReasonLength = 1024
+
+// EntryLifecycle contains the lifecycle state of a SPIRE entry tracked by Tornjak
+type EntryLifecycle struct {
+	EntryId string `json:"entryId"`
+	State   string `json:"state"`
+	// RFC 3339 time the entry is deleted at, set in the scheduled_for_removal and removed states only
+	RemoveAt  string `json:"removeAt,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	UpdatedBy string `json:"updatedBy"`
+	UpdatedAt string `json:"updatedAt"`
+	// time the owners were told of the upcoming removal, empty if not yet
+	NotifiedAt string `json:"notifiedAt,omitempty"`
+}
+
+// Validate checks the entry id and state are set, and that the removal time is
+// set in the scheduled_for_removal state only
+// the removed state is refused, it is only set when the entry is deleted
+func (l EntryLifecycle) Validate() error {
+	if len(l.EntryId) == 0 || len(l.State) == 0 {
+		return errors.New("input missing mandatory field - EntryId or State")
+	}
+	switch l.State {
+	case EntryLifecycleProposed, EntryLifecycleActive, EntryLifecycleDeprecated:
+		if len(l.RemoveAt) > 0 {
+			return errors.Errorf("removal time is only set in the %s state", EntryLifecycleScheduledForRemoval)
+		}
+	case EntryLifecycleScheduledForRemoval:
+		if _, err := time.Parse(time.RFC3339, l.RemoveAt); err != nil {
+			return errors.Errorf("invalid removal time %q, expected RFC 3339", l.RemoveAt)
+		}
+	default:
+		return errors.Errorf("invalid lifecycle state %q", l.State)
+	}
+	if len(l.Reason) > maxLifecycleReasonLength {
+		return errors.Errorf("reason longer than %d characters", maxLifecycleReasonLength)
+	}
+	return nil
+}
+
+// EntryLifecycleList contains the lifecycle states of entries
+type EntryLifecycleList struct {
+	Entries []EntryLifecycle `json:"entries"`
+}
+
+// events owners of entries are notified of
+const (
+	// the entry is removed within the notice period
+	EntryRemovalUpcoming = "removal_upcoming"
+	// the entry was removed
+	EntryRemoved = "removed"
+)
+
+// EntryLifecycleNotice tells the owners of an entry of its removal
+type EntryLifecycleNotice struct {
+	Event     string `json:"event"`
+	EntryId   string `json:"entryId"`
+	RemoveAt  string `json:"removeAt"`
+	Reason    string `json:"reason,omitempty"`
+	OwnerTeam string `json:"ownerTeam,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+}
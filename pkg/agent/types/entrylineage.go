@@ -0,0 +1,9 @@
+package types
+
+// EntryLineage records the SPIRE entry another entry was cloned from
+type EntryLineage struct {
+	EntryId       string `json:"entryId"`
+	SourceEntryId string `json:"sourceEntryId"`
+	CreatedBy     string `json:"createdBy"`
+	CreationTime  string `json:"creationTime"`
+}
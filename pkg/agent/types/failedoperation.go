@@ -0,0 +1,37 @@
+package types
+
+// states of failed operations in the retry queue
+const (
+	// the incomplete step is retried by the reconciliation worker
+	FailedOperationPending = "pending"
+	// the step failed too many times and awaits manual resolution
+	FailedOperationStuck = "stuck"
+	// the step was completed by a retry, or resolved by an operator
+	FailedOperationResolved = "resolved"
+)
+
+// FailedOperation records the incomplete step of a composite operation that
+// partially failed, e.g. a SPIRE entry created without its record in the local DB
+type FailedOperation struct {
+	ID int64 `json:"id"`
+	// composite operation that partially failed, e.g. "clone_entry"
+	Operation string `json:"operation"`
+	// step left incomplete, e.g. "record_entry_lineage"
+	Step string `json:"step"`
+	// JSON input of the step
+	Payload   string `json:"payload"`
+	State     string `json:"state"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"lastError"`
+	CreatedBy string `json:"createdBy"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+	// operator that resolved the operation, empty if resolved by a retry
+	ResolvedBy     string `json:"resolvedBy"`
+	ResolutionNote string `json:"resolutionNote"`
+}
+
+// FailedOperationList contains a list of failed operations
+type FailedOperationList struct {
+	Operations []FailedOperation `json:"operations"`
+}
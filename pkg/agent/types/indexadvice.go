@@ -0,0 +1,45 @@
+package types
+
+// SlowQuery is a statement the SQL database recorded as slow, with its
+// executions since the statistics of the database were last reset
+type SlowQuery struct {
+	// normalized text of the statement, with its constants replaced
+	Query       string  `json:"query"`
+	Calls       int64   `json:"calls"`
+	MeanMillis  float64 `json:"meanMillis"`
+	TotalMillis float64 `json:"totalMillis"`
+}
+
+// TableIndex is an index of a table of the Tornjak DB
+type TableIndex struct {
+	Table string `json:"table"`
+	Name  string `json:"name"`
+	// indexed columns in order, "(expression)" for expressions
+	Columns []string `json:"columns"`
+}
+
+// IndexSuggestion is a missing index serving an access path of Tornjak
+type IndexSuggestion struct {
+	Table   string   `json:"table"`
+	Columns []string `json:"columns"`
+	// the queries of Tornjak the index serves
+	Purpose string `json:"purpose"`
+	// statement creating the index on the backend
+	DDL string `json:"ddl"`
+	// slow queries of the log on the table and columns, and their total time
+	SlowQueries int     `json:"slowQueries"`
+	SlowMillis  float64 `json:"slowMillis"`
+}
+
+// IndexReport is the advice on the indexes of the Tornjak DB
+type IndexReport struct {
+	// SQL backend, e.g. postgres or mysql
+	Backend string `json:"backend"`
+	// where the slow queries were read from, and why they could not be read
+	SlowQuerySource string       `json:"slowQuerySource"`
+	SlowQueryError  string       `json:"slowQueryError,omitempty"`
+	SlowQueries     []SlowQuery  `json:"slowQueries"`
+	Indexes         []TableIndex `json:"indexes"`
+	// missing indexes, those serving the slowest queries first
+	Suggestions []IndexSuggestion `json:"suggestions"`
+}
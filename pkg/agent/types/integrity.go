@@ -0,0 +1,49 @@
+package types
+
+// integrity checks of the DataStore
+const (
+	// membership of an agent or in a cluster that does not exist
+	IntegrityOrphanedMembership = "orphaned_membership"
+	// agent stored without SPIFFE ID, which cannot be listed or changed
+	IntegrityAgentMissingSpiffeid = "agent_missing_spiffeid"
+	// cluster stored without UID, which has no history and cannot be restored once deleted
+	IntegrityClusterMissingUID = "cluster_missing_uid"
+	// rows referencing a row that does not exist, written before foreign keys were enforced
+	IntegrityDanglingReference = "dangling_reference"
+)
+
+// severities of integrity findings
+const (
+	// data returned or changed wrongly by Tornjak
+	IntegritySeverityError = "error"
+	// unreachable rows, ignored by Tornjak
+	IntegritySeverityWarning = "warning"
+)
+
+// MaxIntegrityFindings is the number of findings of each check listed in an
+// integrity report; further findings are only counted
+const MaxIntegrityFindings = 100
+
+// IntegrityFinding is a row of the DataStore failing an integrity check
+type IntegrityFinding struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Table    string `json:"table"`
+	// condition selecting the rows of the finding, e.g. agent_id=12
+	Row     string `json:"row"`
+	Message string `json:"message"`
+	// suggested fix, ending with the SQL statement applying it
+	Fix string `json:"fix"`
+}
+
+// IntegrityReport is the outcome of the last integrity check of the DataStore
+type IntegrityReport struct {
+	// RFC 3339 UTC time of the check, empty if the DataStore was never checked
+	CheckedAt string `json:"checkedAt"`
+	// findings by severity, including those not listed
+	Errors   int `json:"errors"`
+	Warnings int `json:"warnings"`
+	// findings beyond MaxIntegrityFindings of a check, not listed
+	Omitted  int                `json:"omitted"`
+	Findings []IntegrityFinding `json:"findings"`
+}
@@ -0,0 +1,175 @@
+package types
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// targets of bulk label operations
+const (
+	LabelTargetClusters = "clusters"
+	LabelTargetAgents   = "agents"
+)
+
+// actions of bulk label operations
+const (
+	LabelActionAdd    = "add"
+	LabelActionRemove = "remove"
+	LabelActionRename = "rename"
+)
+
+// label keys and values are short identifiers such as env or prod
+// keys may be prefixed with a domain, e.g. example.org/team
+var (
+	labelKeyRegexp   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]{0,62})$`)
+	labelValueRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]{0,63}$`)
+)
+
+// ValidateLabel checks the format of a label key and value
+func ValidateLabel(key, value string) error {
+	if !labelKeyRegexp.MatchString(key) {
+		return errors.Errorf("invalid label key %q", key)
+	}
+	if !labelValueRegexp.MatchString(value) {
+		return errors.Errorf("invalid value %q of label %s", value, key)
+	}
+	return nil
+}
+
+// ValidateLabels checks the format of each label
+func ValidateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if err := ValidateLabel(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LabelFilter selects the clusters or agents of a bulk label operation
+type LabelFilter struct {
+	// cluster names or agent SPIFFE IDs, all objects if empty
+	Names []string `json:"names,omitempty"`
+	// labels the objects must all have
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Matches returns whether an object with the name and labels is selected
+func (f LabelFilter) Matches(name string, labels map[string]string) bool {
+	if len(f.Names) > 0 {
+		found := false
+		for _, n := range f.Names {
+			if n == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for key, value := range f.Labels {
+		if v, ok := labels[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// LabelOperation adds, removes or renames a label across the clusters or
+// agents matching a filter
+type LabelOperation struct {
+	Target string      `json:"target"`
+	Filter LabelFilter `json:"filter"`
+	Action string      `json:"action"`
+	Key    string      `json:"key"`
+	// value to add; for remove and rename, only labels with this value if set
+	Value string `json:"value,omitempty"`
+	// new key and value of renamed labels, unchanged if empty
+	NewKey   string `json:"newKey,omitempty"`
+	NewValue string `json:"newValue,omitempty"`
+	// only report the changes without applying them
+	DryRun bool `json:"dryRun"`
+}
+
+// Validate checks the target, action and labels of the operation
+func (o LabelOperation) Validate() error {
+	if o.Target != LabelTargetClusters && o.Target != LabelTargetAgents {
+		return errors.Errorf("invalid label target %q", o.Target)
+	}
+	if err := ValidateLabels(o.Filter.Labels); err != nil {
+		return err
+	}
+	if err := ValidateLabel(o.Key, o.Value); err != nil {
+		return err
+	}
+	switch o.Action {
+	case LabelActionAdd, LabelActionRemove:
+		return nil
+	case LabelActionRename:
+		if len(o.NewKey) == 0 && len(o.NewValue) == 0 {
+			return errors.New("input missing mandatory field - NewKey or NewValue")
+		}
+		newKey := o.NewKey
+		if len(newKey) == 0 {
+			newKey = o.Key
+		}
+		return ValidateLabel(newKey, o.NewValue)
+	default:
+		return errors.Errorf("invalid label action %q", o.Action)
+	}
+}
+
+// Apply returns the labels after the operation, and whether they changed
+func (o LabelOperation) Apply(labels map[string]string) (map[string]string, bool) {
+	current, has := labels[o.Key]
+	after := make(map[string]string, len(labels)+1)
+	for key, value := range labels {
+		after[key] = value
+	}
+	switch o.Action {
+	case LabelActionAdd:
+		if has && current == o.Value {
+			return labels, false
+		}
+		after[o.Key] = o.Value
+	case LabelActionRemove:
+		if !has || (len(o.Value) > 0 && current != o.Value) {
+			return labels, false
+		}
+		delete(after, o.Key)
+	case LabelActionRename:
+		if !has || (len(o.Value) > 0 && current != o.Value) {
+			return labels, false
+		}
+		newKey, newValue := o.NewKey, o.NewValue
+		if len(newKey) == 0 {
+			newKey = o.Key
+		}
+		if len(newValue) == 0 {
+			newValue = current
+		}
+		if newKey == o.Key && newValue == current {
+			return labels, false
+		}
+		delete(after, o.Key)
+		after[newKey] = newValue
+	}
+	return after, true
+}
+
+// LabelChange is the change of the labels of one cluster or agent
+type LabelChange struct {
+	// cluster name or agent SPIFFE ID
+	Name   string            `json:"name"`
+	Before map[string]string `json:"before"`
+	After  map[string]string `json:"after"`
+}
+
+// LabelOperationResult lists the objects matched and changed by a label operation
+type LabelOperationResult struct {
+	DryRun  bool          `json:"dryRun"`
+	Matched int           `json:"matched"`
+	Changes []LabelChange `json:"changes"`
+}
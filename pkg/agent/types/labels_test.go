@@ -0,0 +1,64 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestLabelOperationValidate checks the checks of targets, actions and labels
+func TestLabelOperationValidate(t *testing.T) {
+	valid := []LabelOperation{
+		{Target: LabelTargetClusters, Action: LabelActionAdd, Key: "env", Value: "prod"},
+		{Target: LabelTargetAgents, Action: LabelActionRemove, Key: "example.org/team"},
+		{Target: LabelTargetClusters, Action: LabelActionRename, Key: "env", Value: "production", NewValue: "prod"},
+	}
+	for _, op := range valid {
+		if err := op.Validate(); err != nil {
+			t.Fatalf("Expected %+v to be valid: %v", op, err)
+		}
+	}
+
+	invalid := []LabelOperation{
+		{Target: "entries", Action: LabelActionAdd, Key: "env"},
+		{Target: LabelTargetClusters, Action: "replace", Key: "env"},
+		{Target: LabelTargetClusters, Action: LabelActionAdd, Key: "env:prod"},
+		{Target: LabelTargetClusters, Action: LabelActionAdd, Key: "env", Value: "prod east"},
+		{Target: LabelTargetClusters, Action: LabelActionRename, Key: "env"},
+		{Target: LabelTargetClusters, Action: LabelActionAdd, Key: "env", Filter: LabelFilter{Labels: map[string]string{"": "x"}}},
+	}
+	for _, op := range invalid {
+		if err := op.Validate(); err == nil {
+			t.Fatalf("Expected %+v to be invalid", op)
+		}
+	}
+}
+
+// TestLabelOperationApply checks the labels resulting from each action
+func TestLabelOperationApply(t *testing.T) {
+	labels := map[string]string{"env": "production", "region": "east"}
+	tests := []struct {
+		op      LabelOperation
+		after   map[string]string
+		changed bool
+	}{
+		{LabelOperation{Action: LabelActionAdd, Key: "tier", Value: "1"},
+			map[string]string{"env": "production", "region": "east", "tier": "1"}, true},
+		{LabelOperation{Action: LabelActionAdd, Key: "env", Value: "production"}, labels, false},
+		{LabelOperation{Action: LabelActionRemove, Key: "env", Value: "staging"}, labels, false},
+		{LabelOperation{Action: LabelActionRemove, Key: "env"}, map[string]string{"region": "east"}, true},
+		{LabelOperation{Action: LabelActionRename, Key: "env", Value: "production", NewValue: "prod"},
+			map[string]string{"env": "prod", "region": "east"}, true},
+		{LabelOperation{Action: LabelActionRename, Key: "env", NewKey: "environment"},
+			map[string]string{"environment": "production", "region": "east"}, true},
+		{LabelOperation{Action: LabelActionRename, Key: "tier", NewValue: "2"}, labels, false},
+	}
+	for _, tt := range tests {
+		after, changed := tt.op.Apply(labels)
+		if changed != tt.changed || !reflect.DeepEqual(after, tt.after) {
+			t.Fatalf("%+v: expected %v (changed %v), got %v (changed %v)", tt.op, tt.after, tt.changed, after, changed)
+		}
+	}
+	if labels["env"] != "production" || len(labels) != 2 {
+		t.Fatalf("Expected Apply to leave its input unchanged, got %v", labels)
+	}
+}
@@ -0,0 +1,167 @@
+package types
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MaxLabelRequirements is the maximum number of requirements of a label selector
+const MaxLabelRequirements = 16
+
+// operators of label selector requirements
+const (
+	// the label has one of the values
+	LabelOpIn = "in"
+	// the label is missing or has none of the values
+	LabelOpNotIn = "notin"
+	// the label is set, whatever its value
+	LabelOpExists = "exists"
+	// the label is missing
+	LabelOpDoesNotExist = "!"
+)
+
+// LabelRequirement is a condition on one label of an object
+// env=prod is the operator in with the value prod, and env!=prod the
+// operator notin with the value prod
+type LabelRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values,omitempty"`
+}
+
+// LabelSelector selects the objects meeting all its requirements
+type LabelSelector []LabelRequirement
+
+// set-based requirements, such as env in (prod, staging)
+var labelSetRequirementRegexp = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+
+// ParseLabelSelector parses a label selector in the syntax of Kubernetes:
+// requirements separated by commas, each one of key=value, key==value,
+// key!=value, key in (v1, v2), key notin (v1, v2), key or !key
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	parts, err := splitLabelSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) > MaxLabelRequirements {
+		return nil, errors.Errorf("label selector has more than %d requirements", MaxLabelRequirements)
+	}
+	ret := make(LabelSelector, 0, len(parts))
+	for _, part := range parts {
+		req, err := parseLabelRequirement(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, req)
+	}
+	return ret, nil
+}
+
+// splitLabelSelector splits a selector on the commas outside of value sets
+func splitLabelSelector(selector string) ([]string, error) {
+	if strings.TrimSpace(selector) == "" {
+		return nil, errors.New("empty label selector")
+	}
+	parts := []string{}
+	start, depth := 0, 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, selector[start:i])
+				start = i + 1
+			}
+		}
+		if depth < 0 || depth > 1 {
+			return nil, errors.Errorf("unbalanced parentheses in label selector %q", selector)
+		}
+	}
+	if depth != 0 {
+		return nil, errors.Errorf("unbalanced parentheses in label selector %q", selector)
+	}
+	return append(parts, selector[start:]), nil
+}
+
+func parseLabelRequirement(part string) (LabelRequirement, error) {
+	if part == "" {
+		return LabelRequirement{}, errors.New("empty requirement in label selector")
+	}
+	var req LabelRequirement
+	if m := labelSetRequirementRegexp.FindStringSubmatch(part); m != nil {
+		req = LabelRequirement{Key: m[1], Operator: m[2]}
+		for _, v := range strings.Split(m[3], ",") {
+			req.Values = append(req.Values, strings.TrimSpace(v))
+		}
+	} else if key, value, ok := strings.Cut(part, "!="); ok {
+		req = LabelRequirement{Key: strings.TrimSpace(key), Operator: LabelOpNotIn, Values: []string{strings.TrimSpace(value)}}
+	} else if key, value, ok := strings.Cut(part, "="); ok {
+		value = strings.TrimPrefix(value, "=")
+		req = LabelRequirement{Key: strings.TrimSpace(key), Operator: LabelOpIn, Values: []string{strings.TrimSpace(value)}}
+	} else if key, ok := strings.CutPrefix(part, "!"); ok {
+		req = LabelRequirement{Key: strings.TrimSpace(key), Operator: LabelOpDoesNotExist}
+	} else {
+		req = LabelRequirement{Key: part, Operator: LabelOpExists}
+	}
+	if err := req.Validate(); err != nil {
+		return LabelRequirement{}, err
+	}
+	return req, nil
+}
+
+// Validate checks the key, operator and values of the requirement
+func (r LabelRequirement) Validate() error {
+	switch r.Operator {
+	case LabelOpIn, LabelOpNotIn:
+		if len(r.Values) == 0 {
+			return errors.Errorf("label requirement %s %s has no values", r.Key, r.Operator)
+		}
+	case LabelOpExists, LabelOpDoesNotExist:
+		if len(r.Values) > 0 {
+			return errors.Errorf("label requirement %s %s takes no values", r.Key, r.Operator)
+		}
+	default:
+		return errors.Errorf("invalid operator %q of label requirement %s", r.Operator, r.Key)
+	}
+	if err := ValidateLabel(r.Key, ""); err != nil {
+		return err
+	}
+	for _, v := range r.Values {
+		if err := ValidateLabel(r.Key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches returns whether an object with the labels meets all requirements
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		value, ok := labels[r.Key]
+		found := ok && containsString(r.Values, value)
+		switch r.Operator {
+		case LabelOpIn:
+			if !found {
+				return false
+			}
+		case LabelOpNotIn:
+			if found {
+				return false
+			}
+		case LabelOpExists:
+			if !ok {
+				return false
+			}
+		case LabelOpDoesNotExist:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
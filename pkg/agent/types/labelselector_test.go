@@ -0,0 +1,76 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseLabelSelector checks the requirements parsed from each syntax and
+// the rejection of malformed selectors
+func TestParseLabelSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		expected LabelSelector
+	}{
+		{"env=prod", LabelSelector{{Key: "env", Operator: LabelOpIn, Values: []string{"prod"}}}},
+		{"env==prod, region != us-east", LabelSelector{
+			{Key: "env", Operator: LabelOpIn, Values: []string{"prod"}},
+			{Key: "region", Operator: LabelOpNotIn, Values: []string{"us-east"}}}},
+		{"env in (prod, staging),example.org/team notin (a)", LabelSelector{
+			{Key: "env", Operator: LabelOpIn, Values: []string{"prod", "staging"}},
+			{Key: "example.org/team", Operator: LabelOpNotIn, Values: []string{"a"}}}},
+		{"env,!tier", LabelSelector{{Key: "env", Operator: LabelOpExists}, {Key: "tier", Operator: LabelOpDoesNotExist}}},
+		{"env=", LabelSelector{{Key: "env", Operator: LabelOpIn, Values: []string{""}}}},
+	}
+	for _, test := range tests {
+		// ATTEMPT parse a valid selector [ParseLabelSelector]
+		sel, err := ParseLabelSelector(test.selector)
+		if err != nil {
+			t.Fatalf("Expected %q to be valid: %v", test.selector, err)
+		}
+		// CHECK requirements of the selector
+		if !reflect.DeepEqual(sel, test.expected) {
+			t.Fatalf("Expected %+v for %q, got %+v", test.expected, test.selector, sel)
+		}
+	}
+
+	for _, selector := range []string{"", " ", "env=prod,", "env:prod", "env=prod east", "env in (prod", "env in prod)",
+		"env in ((prod))", "!", "in (prod)", "a,a,a,a,a,a,a,a,a,a,a,a,a,a,a,a,a"} {
+		// CHECK malformed selectors are rejected [ParseLabelSelector]
+		if _, err := ParseLabelSelector(selector); err == nil {
+			t.Fatalf("Expected %q to be invalid", selector)
+		}
+	}
+}
+
+// TestLabelSelectorMatches checks the labels each operator selects
+func TestLabelSelectorMatches(t *testing.T) {
+	labels := map[string]string{"env": "prod", "region": "us-east"}
+	tests := []struct {
+		selector string
+		matches  bool
+	}{
+		{"env=prod,region=us-east", true},
+		{"env=staging", false},
+		{"env!=staging,tier!=web", true},
+		{"env!=prod", false},
+		{"env in (staging, prod)", true},
+		{"tier in (web)", false},
+		{"region notin (us-west)", true},
+		{"region notin (us-east)", false},
+		{"env", true},
+		{"tier", false},
+		{"!tier", true},
+		{"!env", false},
+	}
+	for _, test := range tests {
+		sel, err := ParseLabelSelector(test.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// CHECK selection of the labels [Matches]
+		if sel.Matches(labels) != test.matches {
+			t.Fatalf("Expected %q to match %v: %v", test.selector, labels, test.matches)
+		}
+	}
+}
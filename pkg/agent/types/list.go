@@ -0,0 +1,125 @@
+package types
+
+import (
+	"encoding/base64"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultPageSize is the number of items returned by a list call when no limit is given
+	DefaultPageSize = 100
+	// MaxPageSize is the maximum number of items returned by a single list call
+	MaxPageSize = 1000
+)
+
+// List is the envelope returned by paginated list APIs
+// NextCursor is empty when there are no further items
+type List[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      int    `json:"total"`
+}
+
+// Filter restricts a list to items whose field equals value
+type Filter struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+// SortField orders a list by field, ascending unless Desc is set
+type SortField struct {
+	Field string `json:"field"`
+	Desc  bool   `json:"desc"`
+}
+
+// ListOptions contains the pagination, filter and sort options shared by list APIs
+type ListOptions struct {
+	Limit   int         `json:"limit"`
+	Cursor  string      `json:"cursor"`
+	Filters []Filter    `json:"filters,omitempty"`
+	Sort    []SortField `json:"sort,omitempty"`
+	// requirements on the labels of the items, only supported by cluster lists
+	LabelSelector LabelSelector `json:"labelSelector,omitempty"`
+}
+
+// EncodeCursor returns the opaque cursor pointing at the item with the given offset
+func EncodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+// DecodeCursor returns the offset encoded by EncodeCursor
+// the empty cursor points at the first item
+func DecodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errors.Errorf("invalid cursor %q", cursor)
+	}
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil || offset < 0 {
+		return 0, errors.Errorf("invalid cursor %q", cursor)
+	}
+	return offset, nil
+}
+
+// PageBounds returns the offset and page size selected by the options
+func (o ListOptions) PageBounds() (int, int, error) {
+	if o.Limit < 0 {
+		return 0, 0, errors.New("limit must not be negative")
+	}
+	limit := o.Limit
+	if limit == 0 {
+		limit = DefaultPageSize
+	} else if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	offset, err := DecodeCursor(o.Cursor)
+	if err != nil {
+		return 0, 0, err
+	}
+	return offset, limit, nil
+}
+
+// Validate checks that filters and sort fields only name the given fields
+func (o ListOptions) Validate(filterFields []string, sortFields []string) error {
+	for _, f := range o.Filters {
+		if !containsString(filterFields, f.Field) {
+			return errors.Errorf("cannot filter on field %q", f.Field)
+		}
+	}
+	for _, f := range o.Sort {
+		if !containsString(sortFields, f.Field) {
+			return errors.Errorf("cannot sort on field %q", f.Field)
+		}
+	}
+	return nil
+}
+
+// NewList returns the page of items starting at offset, given the total
+// number of items matching the request
+func NewList[T any](items []T, offset int, total int) List[T] {
+	if items == nil {
+		items = []T{}
+	}
+	list := List[T]{
+		Items: items,
+		Total: total,
+	}
+	if next := offset + len(items); len(items) > 0 && next < total {
+		list.NextCursor = EncodeCursor(next)
+	}
+	return list
+}
+
+func containsString(list []string, elem string) bool {
+	for _, s := range list {
+		if s == elem {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package types
+
+import (
+	"testing"
+)
+
+// TestCursor checks cursors round trip and invalid cursors are rejected
+func TestCursor(t *testing.T) {
+	for _, offset := range []int{0, 1, 250} {
+		got, err := DecodeCursor(EncodeCursor(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != offset {
+			t.Fatalf("Expected offset %v, got %v", offset, got)
+		}
+	}
+
+	for _, cursor := range []string{"!!", EncodeCursor(-1), "YWJj"} {
+		if _, err := DecodeCursor(cursor); err == nil {
+			t.Fatalf("Expected error decoding cursor %q", cursor)
+		}
+	}
+}
+
+// TestPageBounds checks default and maximum page sizes
+func TestPageBounds(t *testing.T) {
+	_, limit, err := ListOptions{}.PageBounds()
+	if err != nil || limit != DefaultPageSize {
+		t.Fatalf("Expected default page size, got %v, %v", limit, err)
+	}
+	_, limit, err = ListOptions{Limit: MaxPageSize + 1}.PageBounds()
+	if err != nil || limit != MaxPageSize {
+		t.Fatalf("Expected maximum page size, got %v, %v", limit, err)
+	}
+	offset, _, err := ListOptions{Cursor: EncodeCursor(7)}.PageBounds()
+	if err != nil || offset != 7 {
+		t.Fatalf("Expected offset 7, got %v, %v", offset, err)
+	}
+	if _, _, err = (ListOptions{Limit: -1}).PageBounds(); err == nil {
+		t.Fatal("Expected error for negative limit")
+	}
+}
+
+// TestNewList checks the next cursor is only set when items remain
+func TestNewList(t *testing.T) {
+	list := NewList([]string{"a", "b"}, 0, 3)
+	if list.NextCursor != EncodeCursor(2) || list.Total != 3 {
+		t.Fatalf("Expected cursor to third item, got %v", list)
+	}
+	list = NewList([]string{"c"}, 2, 3)
+	if list.NextCursor != "" {
+		t.Fatalf("Expected no cursor on last page, got %v", list)
+	}
+	empty := NewList[string](nil, 0, 0)
+	if empty.Items == nil {
+		t.Fatal("Expected empty, non-nil items")
+	}
+}
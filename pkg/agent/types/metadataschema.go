@@ -0,0 +1,107 @@
+package types
+
+import "encoding/json"
+
+// types of metadata fields, in addition to the extension field types
+const (
+	MetadataFieldStringList = "string_list"
+	MetadataFieldStringMap  = "string_map"
+	// extension fields, described per platform type
+	MetadataFieldExtensions = "extensions"
+	// JSON object, described by the JSON schema of cluster metadata
+	MetadataFieldJSON = "json"
+)
+
+// MetadataField describes a field of clusters or agents, for rendering forms
+type MetadataField struct {
+	// JSON name of the field
+	Name string `json:"name"`
+	// one of string, number, bool, string_list, string_map, extensions or json
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+	// set by the server, not editable
+	ReadOnly bool `json:"readOnly"`
+	// allowed values, any value if empty
+	Enum []string `json:"enum,omitempty"`
+	// regular expression values must match, any value if empty
+	Pattern string `json:"pattern,omitempty"`
+	// format of values, e.g. email
+	Format    string `json:"format,omitempty"`
+	MaxLength int    `json:"maxLength,omitempty"`
+}
+
+// LabelSchema describes the format of labels
+type LabelSchema struct {
+	KeyPattern   string `json:"keyPattern"`
+	ValuePattern string `json:"valuePattern"`
+}
+
+// MetadataSchema describes the metadata of clusters and agents as validated by the server
+type MetadataSchema struct {
+	Cluster []MetadataField `json:"cluster"`
+	// extension fields of clusters by platform type
+	ClusterExtensions map[string][]MetadataField `json:"clusterExtensions"`
+	// JSON schema of the metadata field of clusters, if configured
+	ClusterMetadata json.RawMessage `json:"clusterMetadata,omitempty"`
+	Agent           []MetadataField `json:"agent"`
+	Labels          LabelSchema     `json:"labels"`
+}
+
+// NewMetadataSchema returns the schema of cluster and agent metadata with the
+// given extensions and schema of the metadata field of clusters
+func NewMetadataSchema(extensions ClusterExtensionSchemas, metadata ClusterMetadataSchema) MetadataSchema {
+	schema := MetadataSchema{
+		Cluster: []MetadataField{
+			{Name: "name", Type: ExtensionFieldString, Required: true},
+			{Name: "uid", Type: ExtensionFieldString, ReadOnly: true},
+			{Name: "editedName", Type: ExtensionFieldString},
+			{Name: "creationTime", Type: ExtensionFieldString, Format: "date-time", ReadOnly: true},
+			{Name: "updatedAt", Type: ExtensionFieldString, Format: "date-time", ReadOnly: true},
+			{Name: "domainName", Type: ExtensionFieldString},
+			{Name: "managedBy", Type: ExtensionFieldString},
+			{Name: "platformType", Type: ExtensionFieldString, Required: true},
+			{Name: "agentsList", Type: MetadataFieldStringList},
+			{Name: "ownerEmail", Type: ExtensionFieldString, Format: "email"},
+			{Name: "ownerTeam", Type: ExtensionFieldString, MaxLength: maxOwnerTeamLength},
+			{Name: "slackChannel", Type: ExtensionFieldString, Pattern: slackChannelRegexp.String()},
+			{Name: "tenant", Type: ExtensionFieldString, MaxLength: maxTenantLength},
+			{Name: "labels", Type: MetadataFieldStringMap},
+			{Name: "extensions", Type: MetadataFieldExtensions},
+			{Name: "metadata", Type: MetadataFieldJSON, Required: metadata.Required()},
+			// changed with SetClusterProtection rather than edits
+			{Name: "protected", Type: ExtensionFieldBool, ReadOnly: true},
+		},
+		ClusterExtensions: make(map[string][]MetadataField, len(extensions)),
+		ClusterMetadata:   metadata.JSON(),
+		Agent: []MetadataField{
+			{Name: "spiffeid", Type: ExtensionFieldString, Required: true},
+			{Name: "plugin", Type: ExtensionFieldString, MaxLength: MaxPluginTypeLength},
+			{Name: "cluster", Type: ExtensionFieldString, ReadOnly: true},
+			{Name: "displayName", Type: ExtensionFieldString, MaxLength: MaxAgentDisplayNameLength},
+			{Name: "creationTime", Type: ExtensionFieldString, Format: "date-time", ReadOnly: true},
+			{Name: "updatedAt", Type: ExtensionFieldString, Format: "date-time", ReadOnly: true},
+			{Name: "compliance", Type: MetadataFieldStringMap, ReadOnly: true},
+			{Name: "labels", Type: MetadataFieldStringMap},
+			// changed with SetAgentAnnotation rather than edits
+			{Name: "annotations", Type: MetadataFieldStringMap, ReadOnly: true},
+		},
+		Labels: LabelSchema{
+			KeyPattern:   labelKeyRegexp.String(),
+			ValuePattern: labelValueRegexp.String(),
+		},
+	}
+	for platformType, extension := range extensions {
+		fields := make([]MetadataField, 0, len(extension.Fields))
+		for _, field := range extension.Fields {
+			fields = append(fields, MetadataField{
+				Name:     field.Name,
+				Type:     field.Type,
+				Required: field.Required,
+				Enum:     field.Enum,
+			})
+		}
+		// fields are listed in the order they were configured in
+		schema.ClusterExtensions[platformType] = fields
+	}
+	return schema
+}
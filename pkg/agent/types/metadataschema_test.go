@@ -0,0 +1,82 @@
+package types
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// jsonFields returns the JSON names of the fields of the struct v
+func jsonFields(v interface{}) []string {
+	ret := []string{}
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+func fieldNames(fields []MetadataField) []string {
+	ret := []string{}
+	for _, f := range fields {
+		ret = append(ret, f.Name)
+	}
+	return ret
+}
+
+// TestMetadataSchema checks the schema describes every field of clusters and agents
+// and the extension fields of each platform type
+func TestMetadataSchema(t *testing.T) {
+	extensions, err := NewClusterExtensionSchemas([]ClusterExtensionSchema{
+		{
+			PlatformType: "Kubernetes",
+			Fields: []ClusterExtensionField{
+				{Name: "version", Type: ExtensionFieldString, Required: true},
+				{Name: "cni", Type: ExtensionFieldString, Enum: []string{"calico", "cilium"}},
+				{Name: "nodes", Type: ExtensionFieldNumber},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := NewMetadataSchema(extensions, ClusterMetadataSchema{})
+
+	// CHECK fields match the JSON fields, so forms do not drift from the types
+	if got, expected := fieldNames(schema.Cluster), jsonFields(ClusterInfo{}); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected cluster fields %v, got %v", expected, got)
+	}
+	if got, expected := fieldNames(schema.Agent), jsonFields(AgentInfo{}); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected agent fields %v, got %v", expected, got)
+	}
+
+	// CHECK extension fields are listed in configuration order
+	expected := []MetadataField{
+		{Name: "version", Type: ExtensionFieldString, Required: true},
+		{Name: "cni", Type: ExtensionFieldString, Enum: []string{"calico", "cilium"}},
+		{Name: "nodes", Type: ExtensionFieldNumber},
+	}
+	if len(schema.ClusterExtensions) != 1 || !reflect.DeepEqual(schema.ClusterExtensions["Kubernetes"], expected) {
+		t.Fatalf("Unexpected extension fields %+v", schema.ClusterExtensions)
+	}
+
+	// CHECK patterns accept what the validators accept
+	for _, f := range schema.Cluster {
+		if f.Name != "slackChannel" {
+			continue
+		}
+		if !regexp.MustCompile(f.Pattern).MatchString("#team-alerts") || regexp.MustCompile(f.Pattern).MatchString("team") {
+			t.Fatalf("Unexpected slack channel pattern %q", f.Pattern)
+		}
+	}
+	if !regexp.MustCompile(schema.Labels.KeyPattern).MatchString("example.org/team") || ValidateLabel("example.org/team", "a") != nil {
+		t.Fatalf("Unexpected label key pattern %q", schema.Labels.KeyPattern)
+	}
+	if regexp.MustCompile(schema.Labels.ValuePattern).MatchString("a b") || ValidateLabel("env", "a b") == nil {
+		t.Fatalf("Unexpected label value pattern %q", schema.Labels.ValuePattern)
+	}
+}
@@ -0,0 +1,82 @@
+package types
+
+import (
+	"github.com/pkg/errors"
+)
+
+// types of the objects notes are attached to
+const (
+	// notes of a Tornjak cluster, by cluster UID so they follow renames
+	NoteObjectCluster = "cluster"
+	// notes of an agent, by SPIFFE ID
+	NoteObjectAgent = "agent"
+	// notes of a SPIRE entry, by entry ID
+	NoteObjectEntry = "entry"
+)
+
+// MaxNoteLength is the maximum length of the markdown text of a note
+const MaxNoteLength = 16384
+
+// Note is a free-form markdown note of an operator attached to a cluster, agent or entry
+type Note struct {
+	ID         int64  `json:"id"`
+	ObjectType string `json:"objectType"`
+	ObjectId   string `json:"objectId"`
+	// markdown text of the note, rendered by the UI
+	Body      string `json:"body"`
+	Author    string `json:"author"`
+	CreatedAt string `json:"createdAt"`
+	// user and time of the last edit, empty if never edited
+	UpdatedBy string `json:"updatedBy,omitempty"`
+	UpdatedAt string `json:"updatedAt,omitempty"`
+}
+
+// ValidateNoteObjectType checks objectType names an object notes are attached to
+func ValidateNoteObjectType(objectType string) error {
+	switch objectType {
+	case NoteObjectCluster, NoteObjectAgent, NoteObjectEntry:
+		return nil
+	}
+	return errors.Errorf("invalid object type %q, expected %s, %s or %s", objectType,
+		NoteObjectCluster, NoteObjectAgent, NoteObjectEntry)
+}
+
+// ValidateNoteBody checks the markdown text of a note is set and not too long
+func ValidateNoteBody(body string) error {
+	if len(body) == 0 {
+		return errors.New("input missing mandatory field - Body")
+	}
+	if len(body) > MaxNoteLength {
+		return errors.Errorf("note longer than %d characters", MaxNoteLength)
+	}
+	return nil
+}
+
+// Validate checks the object of the note and its text
+func (n Note) Validate() error {
+	if err := ValidateNoteObjectType(n.ObjectType); err != nil {
+		return err
+	}
+	if len(n.ObjectId) == 0 {
+		return errors.New("input missing mandatory field - ObjectId")
+	}
+	return ValidateNoteBody(n.Body)
+}
+
+// NoteList contains a list of notes
+type NoteList struct {
+	Notes []Note `json:"notes"`
+}
+
+// NoteRevision is a version of the text of a note, written at its creation or an edit
+type NoteRevision struct {
+	NoteID   int64  `json:"noteId"`
+	Body     string `json:"body"`
+	EditedBy string `json:"editedBy"`
+	EditedAt string `json:"editedAt"`
+}
+
+// NoteRevisionList contains the versions of a note, oldest first
+type NoteRevisionList struct {
+	Revisions []NoteRevision `json:"revisions"`
+}
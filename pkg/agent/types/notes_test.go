@@ -0,0 +1,24 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNoteValidate(t *testing.T) {
+	valid := Note{ObjectType: NoteObjectCluster, ObjectId: "uid1", Body: "Being migrated"}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	invalid := map[string]Note{
+		"unknown object type": {ObjectType: "team", ObjectId: "payments", Body: "x"},
+		"missing object":      {ObjectType: NoteObjectAgent, Body: "x"},
+		"empty body":          {ObjectType: NoteObjectEntry, ObjectId: "entry1"},
+		"long body":           {ObjectType: NoteObjectEntry, ObjectId: "entry1", Body: strings.Repeat("x", MaxNoteLength+1)},
+	}
+	for name, note := range invalid {
+		if err := note.Validate(); err == nil {
+			t.Fatalf("Expected %s to be invalid", name)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package types
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// maximum length of a cluster name, the size of the name column of the DataStores
+const MaxClusterNameLength = 255
+
+// NamePolicy restricts a kind of name, such as cluster names or label keys
+type NamePolicy struct {
+	// maximum length in characters, no limit beyond the built-in one if 0
+	MaxLength int
+	// regular expression the whole name must match, any name if empty
+	Pattern string
+	// prefixes the name must not start with
+	ReservedPrefixes []string
+}
+
+// ObjectPolicy restricts the names, labels and notes Tornjak stores
+// the policies add to the built-in checks, so they can only reject more values
+type ObjectPolicy struct {
+	ClusterName NamePolicy
+	LabelKey    NamePolicy
+	LabelValue  NamePolicy
+	// maximum length of the text of a note, MaxNoteLength if 0
+	NoteMaxLength int
+}
+
+type namePolicy struct {
+	NamePolicy
+	pattern *regexp.Regexp
+}
+
+// ObjectPolicies validates objects against an ObjectPolicy
+// the zero value only applies the built-in checks
+type ObjectPolicies struct {
+	clusterName   namePolicy
+	labelKey      namePolicy
+	labelValue    namePolicy
+	noteMaxLength int
+}
+
+// NewObjectPolicies checks the patterns and lengths of the policy
+func NewObjectPolicies(p ObjectPolicy) (ObjectPolicies, error) {
+	var ret ObjectPolicies
+	var err error
+	if ret.clusterName, err = newNamePolicy("cluster_name", p.ClusterName); err != nil {
+		return ObjectPolicies{}, err
+	}
+	if ret.labelKey, err = newNamePolicy("label_key", p.LabelKey); err != nil {
+		return ObjectPolicies{}, err
+	}
+	if ret.labelValue, err = newNamePolicy("label_value", p.LabelValue); err != nil {
+		return ObjectPolicies{}, err
+	}
+	if p.NoteMaxLength < 0 || p.NoteMaxLength > MaxNoteLength {
+		return ObjectPolicies{}, errors.Errorf("note_max_length must be between 0 and %d", MaxNoteLength)
+	}
+	ret.noteMaxLength = p.NoteMaxLength
+	return ret, nil
+}
+
+func newNamePolicy(kind string, p NamePolicy) (namePolicy, error) {
+	ret := namePolicy{NamePolicy: p}
+	if p.MaxLength < 0 {
+		return ret, errors.Errorf("%s: max_length must not be negative", kind)
+	}
+	if p.Pattern != "" {
+		// the whole name must match, not only a part
+		pattern, err := regexp.Compile(`^(?:` + p.Pattern + `)$`)
+		if err != nil {
+			return ret, errors.Errorf("%s: invalid pattern: %v", kind, err)
+		}
+		ret.pattern = pattern
+	}
+	for _, prefix := range p.ReservedPrefixes {
+		if prefix == "" {
+			return ret, errors.Errorf("%s: empty reserved prefix", kind)
+		}
+	}
+	return ret, nil
+}
+
+// validate checks what, a name such as "cluster name", against the policy
+func (p namePolicy) validate(what, name string) error {
+	if p.MaxLength > 0 && utf8.RuneCountInString(name) > p.MaxLength {
+		return errors.Errorf("%s %q is longer than %d characters", what, name, p.MaxLength)
+	}
+	if p.pattern != nil && !p.pattern.MatchString(name) {
+		return errors.Errorf("%s %q does not match pattern %s", what, name, p.Pattern)
+	}
+	for _, prefix := range p.ReservedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return errors.Errorf("%s %q starts with reserved prefix %q", what, name, prefix)
+		}
+	}
+	return nil
+}
+
+// ValidateClusterName checks a cluster name is printable and within the policy
+func (p ObjectPolicies) ValidateClusterName(name string) error {
+	if !utf8.ValidString(name) {
+		return errors.Errorf("cluster name %q is not valid UTF-8", name)
+	}
+	if utf8.RuneCountInString(name) > MaxClusterNameLength {
+		return errors.Errorf("cluster name %q is longer than %d characters", name, MaxClusterNameLength)
+	}
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return errors.Errorf("cluster name %q contains control characters", name)
+	}
+	return p.clusterName.validate("cluster name", name)
+}
+
+// ValidateLabel checks the format of a label key and value, then the policy
+func (p ObjectPolicies) ValidateLabel(key, value string) error {
+	if err := ValidateLabel(key, value); err != nil {
+		return err
+	}
+	if err := p.labelKey.validate("label key", key); err != nil {
+		return err
+	}
+	return p.labelValue.validate("value of label "+key, value)
+}
+
+// ValidateLabels checks each label
+func (p ObjectPolicies) ValidateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if err := p.ValidateLabel(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateLabelOperation checks the operation, then the labels it sets
+// against the policy; the labels an operation only matches are not checked,
+// so labels stored before the policy can still be removed or renamed
+func (p ObjectPolicies) ValidateLabelOperation(o LabelOperation) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	switch o.Action {
+	case LabelActionAdd:
+		return p.ValidateLabel(o.Key, o.Value)
+	case LabelActionRename:
+		newKey := o.NewKey
+		if len(newKey) == 0 {
+			newKey = o.Key
+		}
+		if err := p.labelKey.validate("label key", newKey); err != nil {
+			return err
+		}
+		if len(o.NewValue) > 0 {
+			return p.labelValue.validate("value of label "+newKey, o.NewValue)
+		}
+	}
+	return nil
+}
+
+// ValidateNoteBody checks the text of a note against the built-in checks and
+// the maximum length of the policy
+func (p ObjectPolicies) ValidateNoteBody(body string) error {
+	if err := ValidateNoteBody(body); err != nil {
+		return err
+	}
+	if p.noteMaxLength > 0 && len(body) > p.noteMaxLength {
+		return errors.Errorf("note longer than %d characters", p.noteMaxLength)
+	}
+	return nil
+}
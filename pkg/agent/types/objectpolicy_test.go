@@ -0,0 +1,104 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestObjectPolicies checks the policies only reject more values than the
+// built-in checks, and labels that violate them can still be removed
+func TestObjectPolicies(t *testing.T) {
+	// CHECK invalid policies [NewObjectPolicies]
+	for _, p := range []ObjectPolicy{
+		{ClusterName: NamePolicy{Pattern: "[a-z"}},
+		{LabelKey: NamePolicy{MaxLength: -1}},
+		{LabelValue: NamePolicy{ReservedPrefixes: []string{""}}},
+		{NoteMaxLength: MaxNoteLength + 1},
+	} {
+		if _, err := NewObjectPolicies(p); err == nil {
+			t.Fatalf("Expected policy %+v to be rejected", p)
+		}
+	}
+
+	// CHECK zero value only applies the built-in checks [ValidateClusterName]
+	var builtin ObjectPolicies
+	if err := builtin.ValidateClusterName("Cluster A"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{strings.Repeat("a", MaxClusterNameLength+1), "cluster\nA", "\xff"} {
+		if err := builtin.ValidateClusterName(name); err == nil {
+			t.Fatalf("Expected cluster name %q to be rejected", name)
+		}
+	}
+
+	p, err := NewObjectPolicies(ObjectPolicy{
+		ClusterName:   NamePolicy{MaxLength: 10, Pattern: "[a-z0-9-]+", ReservedPrefixes: []string{"tornjak-"}},
+		LabelKey:      NamePolicy{ReservedPrefixes: []string{"tornjak.io/"}},
+		LabelValue:    NamePolicy{MaxLength: 8},
+		NoteMaxLength: 16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CHECK cluster names against the policy [ValidateClusterName]
+	if err = p.ValidateClusterName("cluster-a"); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"cluster-abcd": "longer than 10 characters",
+		"Cluster":      "does not match pattern",
+		"cluster a":    "does not match pattern",
+		"tornjak-a":    "reserved prefix",
+	} {
+		if err = p.ValidateClusterName(name); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("Expected cluster name %q to be rejected with %q, got %v", name, want, err)
+		}
+	}
+
+	// CHECK labels against the built-in checks and the policy [ValidateLabels]
+	if err = p.ValidateLabels(map[string]string{"env": "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, labels := range []map[string]string{
+		{"env:prod": ""},
+		{"tornjak.io/owner": "me"},
+		{"env": "production"},
+	} {
+		if err = p.ValidateLabels(labels); err == nil {
+			t.Fatalf("Expected labels %v to be rejected", labels)
+		}
+	}
+
+	// CHECK only the labels an operation sets are checked [ValidateLabelOperation]
+	ops := []LabelOperation{
+		{Target: LabelTargetClusters, Action: LabelActionRemove, Key: "tornjak.io/owner"},
+		{Target: LabelTargetClusters, Action: LabelActionRename, Key: "env", Value: "production", NewValue: "prod"},
+	}
+	for _, o := range ops {
+		if err = p.ValidateLabelOperation(o); err != nil {
+			t.Fatalf("Expected %+v to be valid, got %v", o, err)
+		}
+	}
+	ops = []LabelOperation{
+		{Target: LabelTargetClusters, Action: LabelActionAdd, Key: "tornjak.io/owner", Value: "me"},
+		{Target: LabelTargetClusters, Action: LabelActionRename, Key: "owner", NewKey: "tornjak.io/owner"},
+		{Target: LabelTargetClusters, Action: LabelActionRename, Key: "env", NewValue: "production"},
+	}
+	for _, o := range ops {
+		if err = p.ValidateLabelOperation(o); err == nil {
+			t.Fatalf("Expected %+v to be rejected", o)
+		}
+	}
+
+	// CHECK note lengths [ValidateNoteBody]
+	if err = p.ValidateNoteBody("rotated the CA"); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.ValidateNoteBody("rotated the CA on monday"); err == nil {
+		t.Fatal("Expected a note longer than the policy to be rejected")
+	}
+	if err = builtin.ValidateNoteBody("rotated the CA on monday"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,35 @@
+package types
+
+// ObjectLink refers to an object related to a resolved object, e.g. the
+// cluster of an agent or the notes of an entry
+type ObjectLink struct {
+	// relation to the resolved object, e.g. cluster, parent or notes
+	Rel string `json:"rel"`
+	// type and canonical ID of the related object, empty for lists such as notes
+	Type string `json:"type,omitempty"`
+	ID   string `json:"id,omitempty"`
+	// Tornjak API request returning the related object, if there is one
+	Href string `json:"href,omitempty"`
+}
+
+// ResolvedObject is an object an identifier refers to, with its canonical ID
+// the types and canonical IDs are those notes are attached to: the UID of a
+// cluster, the SPIFFE ID of an agent and the ID of an entry
+type ResolvedObject struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	// cluster name, agent display name or entry SPIFFE ID
+	Name string `json:"name,omitempty"`
+	// field of the object the identifier matched, e.g. uid or name
+	MatchedBy string       `json:"matchedBy"`
+	Links     []ObjectLink `json:"links"`
+}
+
+// ResolvedObjectList contains the objects an identifier refers to, none if
+// it is unknown, more than one if e.g. a cluster is named after an entry ID
+type ResolvedObjectList struct {
+	Query   string           `json:"query"`
+	Objects []ResolvedObject `json:"objects"`
+	// errors of the sources that could not be searched, by source
+	Errors map[string]string `json:"errors,omitempty"`
+}
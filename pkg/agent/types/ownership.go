@@ -0,0 +1,92 @@
+package types
+
+import (
+	"github.com/pkg/errors"
+)
+
+// object types of ownership transfer records
+const (
+	OwnedObjectCluster = "cluster"
+	OwnedObjectEntry   = "entry"
+)
+
+// maximum length of a tenant name
+const maxTenantLength = 128
+
+// EntryOwner contains the ownership metadata of a SPIRE entry tracked by Tornjak
+type EntryOwner struct {
+	EntryId   string `json:"entryId"`
+	OwnerTeam string `json:"ownerTeam"`
+	Tenant    string `json:"tenant"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// Validate checks the entry id, owner team and tenant are set and not too long
+func (o EntryOwner) Validate() error {
+	if len(o.EntryId) == 0 || len(o.OwnerTeam) == 0 {
+		return errors.New("input missing mandatory field - EntryId or OwnerTeam")
+	}
+	if len(o.OwnerTeam) > maxOwnerTeamLength {
+		return errors.Errorf("owner team longer than %d characters", maxOwnerTeamLength)
+	}
+	if len(o.Tenant) > maxTenantLength {
+		return errors.Errorf("tenant longer than %d characters", maxTenantLength)
+	}
+	return nil
+}
+
+// EntryOwnerList contains the ownership metadata of entries
+type EntryOwnerList struct {
+	Entries []EntryOwner `json:"entries"`
+}
+
+// OwnershipTransfer moves the clusters and entries owned by one team to another
+type OwnershipTransfer struct {
+	FromTeam string `json:"fromTeam"`
+	ToTeam   string `json:"toTeam"`
+	// tenant the objects are moved to, their tenant is kept if empty
+	ToTenant string `json:"toTenant,omitempty"`
+	// names of the clusters and ids of the entries to transfer
+	// all clusters and entries of FromTeam are transferred if both are empty
+	Clusters []string `json:"clusters,omitempty"`
+	Entries  []string `json:"entries,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+
+	TransferredBy string `json:"-"`
+	TransferTime  string `json:"-"`
+}
+
+// Validate checks the teams and tenant of the transfer and that it changes ownership
+func (t OwnershipTransfer) Validate() error {
+	if len(t.FromTeam) == 0 || len(t.ToTeam) == 0 {
+		return errors.New("input missing mandatory field - FromTeam or ToTeam")
+	}
+	if len(t.ToTeam) > maxOwnerTeamLength {
+		return errors.Errorf("owner team longer than %d characters", maxOwnerTeamLength)
+	}
+	if len(t.ToTenant) > maxTenantLength {
+		return errors.Errorf("tenant longer than %d characters", maxTenantLength)
+	}
+	if t.FromTeam == t.ToTeam && len(t.ToTenant) == 0 {
+		return errors.New("transfer does not change owner team or tenant")
+	}
+	return nil
+}
+
+// OwnershipTransferRecord is the audit record of the transfer of one object
+type OwnershipTransferRecord struct {
+	ObjectType    string `json:"objectType"`
+	ObjectId      string `json:"objectId"`
+	FromTeam      string `json:"fromTeam"`
+	ToTeam        string `json:"toTeam"`
+	FromTenant    string `json:"fromTenant"`
+	ToTenant      string `json:"toTenant"`
+	Reason        string `json:"reason"`
+	TransferredBy string `json:"transferredBy"`
+	TransferTime  string `json:"transferTime"`
+}
+
+// OwnershipTransferResult lists the objects moved by a transfer
+type OwnershipTransferResult struct {
+	Transferred []OwnershipTransferRecord `json:"transferred"`
+}
@@ -0,0 +1,66 @@
+package types
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// workload attestor plugin types of SPIRE agents, in the spelling shown by the UI
+const (
+	PluginTypeDocker     = "Docker"
+	PluginTypeKubernetes = "Kubernetes"
+	PluginTypeUnix       = "Unix"
+	PluginTypeSystemd    = "Systemd"
+	PluginTypeWindows    = "Windows"
+)
+
+// maximum length of a custom plugin type
+const MaxPluginTypeLength = 64
+
+// KnownPluginTypes lists the plugin types of the attestors shipped with SPIRE
+var KnownPluginTypes = []string{PluginTypeDocker, PluginTypeKubernetes, PluginTypeUnix, PluginTypeSystemd, PluginTypeWindows}
+
+// spellings of known plugin types, in lower case, including SPIRE plugin names
+var pluginTypeAliases = map[string]string{
+	"docker":     PluginTypeDocker,
+	"kubernetes": PluginTypeKubernetes,
+	"k8s":        PluginTypeKubernetes,
+	"unix":       PluginTypeUnix,
+	"systemd":    PluginTypeSystemd,
+	"windows":    PluginTypeWindows,
+}
+
+// PluginType is a plugin type of agents, custom if not a known plugin type
+type PluginType struct {
+	Name   string `json:"name"`
+	Custom bool   `json:"custom"`
+}
+
+// PluginTypeList contains the plugin types assigned to agents or known to Tornjak
+type PluginTypeList struct {
+	PluginTypes []PluginType `json:"pluginTypes"`
+}
+
+// NormalizePluginType returns the canonical plugin type of value,
+// e.g. Kubernetes for k8s; other values are accepted as custom plugin types
+// of at most MaxPluginTypeLength printable characters
+func NormalizePluginType(value string) (PluginType, error) {
+	value = strings.TrimSpace(value)
+	if known, ok := pluginTypeAliases[strings.ToLower(value)]; ok {
+		return PluginType{Name: known}, nil
+	}
+	if len(value) == 0 {
+		return PluginType{}, errors.New("plugin type must not be empty")
+	}
+	if len(value) > MaxPluginTypeLength {
+		return PluginType{}, errors.Errorf("plugin type must be at most %d characters long", MaxPluginTypeLength)
+	}
+	for _, r := range value {
+		if !unicode.IsPrint(r) {
+			return PluginType{}, errors.Errorf("plugin type %q contains non-printable characters", value)
+		}
+	}
+	return PluginType{Name: value, Custom: true}, nil
+}
@@ -0,0 +1,37 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestNormalizePluginType checks spellings of known plugin types are normalized
+// and other values accepted as custom plugin types
+func TestNormalizePluginType(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected PluginType
+	}{
+		{"Docker", PluginType{Name: PluginTypeDocker}},
+		{" docker ", PluginType{Name: PluginTypeDocker}},
+		{"K8s", PluginType{Name: PluginTypeKubernetes}},
+		{"KUBERNETES", PluginType{Name: PluginTypeKubernetes}},
+		{"unix", PluginType{Name: PluginTypeUnix}},
+		{"tpm-attestor", PluginType{Name: "tpm-attestor", Custom: true}},
+	}
+	for _, test := range tests {
+		got, err := NormalizePluginType(test.value)
+		if err != nil {
+			t.Fatalf("Expected %q to be valid: %v", test.value, err)
+		}
+		if got != test.expected {
+			t.Fatalf("Expected %q to normalize to %+v, got %+v", test.value, test.expected, got)
+		}
+	}
+
+	for _, value := range []string{"", "  ", strings.Repeat("a", MaxPluginTypeLength+1), "dock\ter"} {
+		if _, err := NormalizePluginType(value); err == nil {
+			t.Fatalf("Expected %q to be invalid", value)
+		}
+	}
+}
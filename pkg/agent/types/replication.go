@@ -0,0 +1,69 @@
+package types
+
+// policies deciding conflicts between the primary and clusters changed on the standby
+const (
+	// the change of the primary overwrites the cluster of the standby
+	ReplicationPrimaryWins = "primary_wins"
+	// the cluster of the standby is kept and the change of the primary skipped
+	ReplicationStandbyWins = "standby_wins"
+)
+
+// ClusterChangeLogEntry is an entry of the history of clusters with the state
+// of the cluster after the change, as replicated to standby instances
+type ClusterChangeLogEntry struct {
+	// increasing position of the entry in the history
+	ID int64 `json:"id"`
+	ClusterChange
+	// nil for deletes
+	Cluster *ClusterInfo `json:"cluster,omitempty"`
+}
+
+// ClusterChangeLog is a range of the history of clusters, oldest first
+type ClusterChangeLog struct {
+	Entries []ClusterChangeLogEntry `json:"entries"`
+	// ID of the latest entry of the history, 0 if it is empty
+	LatestID int64 `json:"latestId"`
+}
+
+// ReplicationSnapshot is the state a standby starts replicating from: the
+// clusters as of the entry LatestID of the history or later
+type ReplicationSnapshot struct {
+	LatestID int64         `json:"latestId"`
+	Clusters []ClusterInfo `json:"clusters"`
+}
+
+// ReplicationConflict is a change of the primary to a cluster that was also
+// changed on the standby
+type ReplicationConflict struct {
+	EntryID int64  `json:"entryId"`
+	Cluster string `json:"cluster"`
+	Change  string `json:"change"`
+	// whether the change of the primary was applied, by the conflict policy
+	Applied    bool   `json:"applied"`
+	DetectedAt string `json:"detectedAt"`
+}
+
+// ReplicationStatus describes the replication of a standby from its primary
+type ReplicationStatus struct {
+	Primary        string `json:"primary"`
+	ConflictPolicy string `json:"conflictPolicy"`
+	// set once the clusters of the primary were copied
+	Bootstrapped bool `json:"bootstrapped"`
+	// ID of the last entry of the history of the primary applied
+	AppliedID       int64 `json:"appliedId"`
+	PrimaryLatestID int64 `json:"primaryLatestId"`
+	// entries of the history of the primary not applied yet
+	LagEntries int64 `json:"lagEntries"`
+	// age of the oldest change of the primary not applied yet, 0 when caught up
+	LagSeconds float64 `json:"lagSeconds"`
+	// time of the last successful poll of the primary
+	LastSyncAt string `json:"lastSyncAt,omitempty"`
+	// error of the last poll, until one succeeds
+	LastError string `json:"lastError,omitempty"`
+	// counters since startup
+	AppliedEntries int64 `json:"appliedEntries"`
+	Conflicts      int64 `json:"conflicts"`
+	Bootstraps     int64 `json:"bootstraps"`
+	// latest conflicts, most recent first
+	RecentConflicts []ReplicationConflict `json:"recentConflicts"`
+}
@@ -0,0 +1,22 @@
+package types
+
+// ServiceAccount contains the information about a non-interactive machine user
+// the API key of the service account is only returned on creation
+type ServiceAccount struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	Roles        []string `json:"roles"`
+	CreatedBy    string   `json:"createdBy"`
+	CreationTime string   `json:"creationTime"`
+}
+
+// ServiceAccountList contains a list of service accounts
+type ServiceAccountList struct {
+	ServiceAccounts []ServiceAccount `json:"serviceAccounts"`
+}
+
+// ServiceAccountCredential contains a newly issued service account API key
+type ServiceAccountCredential struct {
+	ServiceAccount ServiceAccount `json:"serviceAccount"`
+	APIKey         string         `json:"apiKey"`
+}
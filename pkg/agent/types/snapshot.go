@@ -0,0 +1,36 @@
+package types
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// names of snapshots are used as file names
+var snapshotNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+// Snapshot is a named copy of the Tornjak metadata, taken e.g. before a bulk operation
+type Snapshot struct {
+	Name         string `json:"name"`
+	CreatedBy    string `json:"createdBy"`
+	CreationTime string `json:"creationTime"`
+	// size of the snapshot file
+	Bytes int64 `json:"bytes"`
+}
+
+// SnapshotList contains the snapshots, most recent first
+type SnapshotList struct {
+	Snapshots []Snapshot `json:"snapshots"`
+}
+
+// ValidateSnapshotName checks a snapshot name has 1 to 64 letters, digits, '.', '_' or '-'
+// and starts with a letter or digit
+func ValidateSnapshotName(name string) error {
+	if len(name) == 0 {
+		return errors.New("snapshot name must not be empty")
+	}
+	if !snapshotNameRegexp.MatchString(name) {
+		return errors.Errorf("invalid snapshot name %q, must match %s", name, snapshotNameRegexp.String())
+	}
+	return nil
+}
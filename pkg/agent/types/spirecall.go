@@ -0,0 +1,11 @@
+package types
+
+// SPIRECallInfo contains the record of a single call Tornjak made to the SPIRE server API
+type SPIRECallInfo struct {
+	Method     string `json:"method"`
+	DurationMs int64  `json:"durationMs"`
+	Status     string `json:"status"`
+	User       string `json:"user"`
+	RequestID  string `json:"requestId"`
+	Timestamp  string `json:"timestamp"`
+}
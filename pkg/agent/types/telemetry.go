@@ -0,0 +1,35 @@
+package types
+
+// version of the format of telemetry reports, increased on incompatible changes
+const TelemetrySchemaVersion = 1
+
+// TelemetryReport contains the anonymous usage aggregates sent by opt-in telemetry
+// it holds no names, IDs, addresses or exact fleet sizes
+type TelemetryReport struct {
+	SchemaVersion int `json:"schemaVersion"`
+	// Tornjak version from the VERSION environment variable set in the images, unknown if unset
+	Version string `json:"version"`
+	// DataStore plugin, e.g. sql, none if not configured
+	Backend string `json:"backend"`
+	// optional server features enabled in the config, sorted
+	Features []string `json:"features"`
+	// API calls since the last report, by method and route, e.g. GET /api/v1/tornjak/clusters
+	Usage map[string]int64 `json:"usage"`
+	// bucket of the number of clusters, e.g. 11-100
+	Clusters string `json:"clusters"`
+	// bucket of the number of agents in clusters
+	Agents string `json:"agents"`
+}
+
+// TelemetryPreview shows the report telemetry would send next
+type TelemetryPreview struct {
+	// set if telemetry is enabled in the config
+	Enabled bool `json:"enabled"`
+	// endpoint reports are sent to, empty if disabled
+	Endpoint string `json:"endpoint,omitempty"`
+	// time of the last successful report
+	LastSentAt string `json:"lastSentAt,omitempty"`
+	// error of the last attempt to send a report
+	LastError string          `json:"lastError,omitempty"`
+	Report    TelemetryReport `json:"report"`
+}
@@ -0,0 +1,60 @@
+package types
+
+// entry fields checked by the TTL advisor
+const (
+	TTLFieldX509SVID = "x509SvidTtl"
+	TTLFieldJWTSVID  = "jwtSvidTtl"
+)
+
+// TTLFinding is a TTL of a SPIRE entry outside the configured policy
+// TTLs are in seconds
+type TTLFinding struct {
+	EntryId   string `json:"entryId"`
+	SpiffeId  string `json:"spiffeId"`
+	ParentId  string `json:"parentId"`
+	Field     string `json:"field"`
+	Current   int32  `json:"current"`
+	Suggested int32  `json:"suggested"`
+	Reason    string `json:"reason"`
+}
+
+// TTLAdvice lists the entry TTLs outside the configured policy
+type TTLAdvice struct {
+	CheckedEntries int          `json:"checkedEntries"`
+	Findings       []TTLFinding `json:"findings"`
+}
+
+// TTLRemediationResult is the outcome of applying the suggested TTLs of one entry
+type TTLRemediationResult struct {
+	EntryId  string       `json:"entryId"`
+	Findings []TTLFinding `json:"findings"`
+	Applied  bool         `json:"applied"`
+	// code of the SPIRE error, see package spireerror
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// TTLRemediation lists the outcome of applying the suggested TTLs per entry
+type TTLRemediation struct {
+	DryRun  bool                   `json:"dryRun"`
+	Results []TTLRemediationResult `json:"results"`
+	// outcome of each entry, by its index in Results, see TTLRemediationBatch
+	Batch BatchResult `json:"batch"`
+}
+
+// TTLRemediationBatch returns the outcome of each entry of a remediation
+// entries are skipped in dry runs and when SPIRE returned no status for them
+func TTLRemediationBatch(remediation TTLRemediation) BatchResult {
+	batch := NewBatchResult()
+	for i, r := range remediation.Results {
+		item := BatchItem{Index: i, ID: r.EntryId, Status: BatchItemSkipped}
+		switch {
+		case r.Applied:
+			item.Status = BatchItemSucceeded
+		case r.Error != "":
+			item.Status, item.Code, item.Error = BatchItemFailed, r.Code, r.Error
+		}
+		batch.Add(item)
+	}
+	return batch
+}
@@ -0,0 +1,44 @@
+package types
+
+// causes of rolled back DB transactions
+const (
+	// a constraint of the DB was violated, or the change conflicts with the
+	// stored data, e.g. a cluster that already exists
+	RollbackCauseConstraint = "constraint"
+	// a call to SPIRE made as part of the transaction failed
+	RollbackCauseDependency = "dependency"
+	// the context of the transaction was canceled or timed out
+	RollbackCauseCanceled = "canceled"
+	// the DB was locked by another connection
+	RollbackCauseBusy  = "busy"
+	RollbackCauseOther = "other"
+)
+
+// categories of the PostFailures of DB transactions, the changes rejected as
+// they conflict with the stored data
+const (
+	PostFailureClusterExists        = "cluster-exists"
+	PostFailureClusterMissing       = "cluster-missing"
+	PostFailureAgentAlreadyAssigned = "agent-already-assigned"
+	PostFailureOther                = "other"
+)
+
+// TxOperationStats counts the transactions of one DB operation
+type TxOperationStats struct {
+	Operation      string `json:"operation"`
+	Commits        int64  `json:"commits"`
+	CommitFailures int64  `json:"commitFailures"`
+	// rollbacks by cause
+	Rollbacks map[string]int64 `json:"rollbacks"`
+	// rollbacks upon a PostFailure by category, a subset of the constraint
+	// rollbacks
+	PostFailures map[string]int64 `json:"postFailures"`
+}
+
+// TxStats counts the DB transactions by operation since Since
+type TxStats struct {
+	Since      string             `json:"since"`
+	Operations []TxOperationStats `json:"operations"`
+	// PostFailures of all operations by category
+	PostFailures map[string]int64 `json:"postFailures"`
+}
@@ -0,0 +1,128 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/cache"
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+)
+
+// headers of signed webhook requests
+const (
+	SignatureHeader = "X-Tornjak-Signature"
+	TimestampHeader = "X-Tornjak-Timestamp"
+	NonceHeader     = "X-Tornjak-Nonce"
+)
+
+// prefix of the hex-encoded HMAC-SHA256 in the signature header
+const signaturePrefix = "sha256="
+
+// prefix of the cache keys of seen nonces
+const nonceKeyPrefix = "webhook:nonce:"
+
+// nonces are random URL-safe strings
+var nonceRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+
+// Verifier checks the signature, timestamp and nonce of webhook requests
+type Verifier struct {
+	secrets [][]byte
+	maxSkew time.Duration
+	// seen nonces, shared between replicas if the cache is
+	nonces cache.Cache
+	clock  clock.Clock
+}
+
+// NewVerifier returns a verifier accepting requests signed with any of secrets
+// several secrets allow rotating them without rejecting requests of senders
+// that still use the previous one
+// requests are accepted up to maxSkew before or after their timestamp, and
+// their nonces are kept in nonces for twice as long to reject replays
+// timestamps are checked against c, the clock of the system if nil
+func NewVerifier(secrets []string, maxSkew time.Duration, nonces cache.Cache, c clock.Clock) (*Verifier, error) {
+	if len(secrets) == 0 {
+		return nil, errors.New("no webhook secrets")
+	}
+	if maxSkew <= 0 {
+		return nil, errors.New("maximum clock skew must be positive")
+	}
+	v := &Verifier{maxSkew: maxSkew, nonces: nonces, clock: clock.OrNew(c)}
+	for _, secret := range secrets {
+		if len(secret) < 32 {
+			return nil, errors.New("webhook secrets must be at least 32 characters")
+		}
+		v.secrets = append(v.secrets, []byte(secret))
+	}
+	return v, nil
+}
+
+// Sign returns the signature header value of a request with the timestamp,
+// nonce and body
+func Sign(secret string, timestamp int64, nonce string, body []byte) string {
+	return signaturePrefix + hex.EncodeToString(mac([]byte(secret), timestamp, nonce, body))
+}
+
+func mac(secret []byte, timestamp int64, nonce string, body []byte) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(strconv.FormatInt(timestamp, 10) + "." + nonce + "."))
+	m.Write(body)
+	return m.Sum(nil)
+}
+
+// Verify returns an error if the request with header and body is not signed
+// with a secret of the verifier, is outside the allowed clock skew or reuses
+// the nonce of an accepted request
+func (v *Verifier) Verify(ctx context.Context, header http.Header, body []byte) error {
+	signature, ok := strings.CutPrefix(header.Get(SignatureHeader), signaturePrefix)
+	if !ok {
+		return errors.Errorf("missing or malformed %s header", SignatureHeader)
+	}
+	sum, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.Errorf("malformed %s header", SignatureHeader)
+	}
+	timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return errors.Errorf("missing or malformed %s header", TimestampHeader)
+	}
+	nonce := header.Get(NonceHeader)
+	if !nonceRegexp.MatchString(nonce) {
+		return errors.Errorf("missing or malformed %s header", NonceHeader)
+	}
+
+	skew := v.clock.Now().Sub(time.Unix(timestamp, 0))
+	if skew > v.maxSkew || skew < -v.maxSkew {
+		return errors.New("request timestamp outside the allowed clock skew")
+	}
+
+	valid := false
+	for _, secret := range v.secrets {
+		if hmac.Equal(sum, mac(secret, timestamp, nonce, body)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return errors.New("invalid request signature")
+	}
+
+	// record the nonce only for signed requests, so unsigned requests cannot
+	// use up the nonces of a sender
+	count, err := v.nonces.Incr(ctx, nonceKeyPrefix+nonce, 2*v.maxSkew)
+	if err != nil {
+		return errors.Errorf("could not check request nonce: %v", err)
+	}
+	if count > 1 {
+		return errors.New("request nonce already used")
+	}
+	return nil
+}
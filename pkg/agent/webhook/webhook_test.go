@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spiffe/tornjak/pkg/agent/cache"
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func signedHeader(secret string, timestamp int64, nonce string, body []byte) http.Header {
+	header := http.Header{}
+	header.Set(SignatureHeader, Sign(secret, timestamp, nonce, body))
+	header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	header.Set(NonceHeader, nonce)
+	return header
+}
+
+// TestVerify checks signed requests are accepted once and tampered, stale or replayed requests are rejected
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	v, err := NewVerifier([]string{"ffffffffffffffffffffffffffffffff", testSecret}, 5*time.Minute, cache.NewMemoryCache(), clock.NewFake(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"spiffeid":"spiffe://example.org/agent"}`)
+
+	// signed with the second of the rotated secrets
+	header := signedHeader(testSecret, now.Unix(), "nonce-0000000000001", body)
+	if err = v.Verify(ctx, header, body); err != nil {
+		t.Fatalf("Expected signed request to be accepted: %v", err)
+	}
+	if err = v.Verify(ctx, header, body); err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Fatalf("Expected replayed request to be rejected, got %v", err)
+	}
+
+	rejected := map[string]struct {
+		header http.Header
+		body   []byte
+	}{
+		"tampered body":  {signedHeader(testSecret, now.Unix(), "nonce-0000000000002", body), []byte(`{}`)},
+		"wrong secret":   {signedHeader(strings.Repeat("x", 32), now.Unix(), "nonce-0000000000003", body), body},
+		"stale":          {signedHeader(testSecret, now.Add(-6*time.Minute).Unix(), "nonce-0000000000004", body), body},
+		"future":         {signedHeader(testSecret, now.Add(6*time.Minute).Unix(), "nonce-0000000000005", body), body},
+		"short nonce":    {signedHeader(testSecret, now.Unix(), "short", body), body},
+		"missing header": {http.Header{}, body},
+	}
+	for name, req := range rejected {
+		if err = v.Verify(ctx, req.header, req.body); err == nil {
+			t.Fatalf("Expected %s request to be rejected", name)
+		}
+	}
+
+	// nonce of a rejected request is still usable by a signed request
+	header = signedHeader(testSecret, now.Unix(), "nonce-0000000000002", body)
+	if err = v.Verify(ctx, header, body); err != nil {
+		t.Fatalf("Expected nonce of rejected request to be unused: %v", err)
+	}
+}
+
+// TestNewVerifier checks weak secrets and invalid clock skews are rejected
+func TestNewVerifier(t *testing.T) {
+	nonces := cache.NewMemoryCache()
+	if _, err := NewVerifier(nil, time.Minute, nonces, nil); err == nil {
+		t.Fatal("Expected error without secrets")
+	}
+	if _, err := NewVerifier([]string{"short"}, time.Minute, nonces, nil); err == nil {
+		t.Fatal("Expected error on short secret")
+	}
+	if _, err := NewVerifier([]string{testSecret}, 0, nonces, nil); err == nil {
+		t.Fatal("Expected error on zero clock skew")
+	}
+}
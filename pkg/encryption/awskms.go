@@ -0,0 +1,230 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// prefix of ciphertexts produced by AWS KMS, followed by the base64 ciphertext blob
+const awsKMSCiphertextPrefix = "awskms:"
+
+// AWSCredentials are the credentials of an AWS principal, signing requests to AWS KMS
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// token of temporary credentials, empty for long-term credentials
+	SessionToken string
+}
+
+// AWSKMSProvider encrypts with a symmetric key of AWS KMS
+// key material never leaves KMS; rotation is done in KMS, or by pointing an
+// alias at a new key, and stored values are migrated with Reencrypt, which
+// uses the ReEncrypt operation
+type AWSKMSProvider struct {
+	endpoint    string
+	region      string
+	keyID       string
+	credentials AWSCredentials
+	client      *http.Client
+	now         func() time.Time
+}
+
+// NewAWSKMSProvider returns a provider for key keyID, an ID, ARN or alias, of
+// AWS KMS in region, called at endpoint, by default the KMS endpoint of the region
+func NewAWSKMSProvider(region, keyID, endpoint string, credentials AWSCredentials) (*AWSKMSProvider, error) {
+	if region == "" {
+		return nil, errors.New("AWS KMS region not given")
+	}
+	if keyID == "" {
+		return nil, errors.New("AWS KMS key id not given")
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return nil, errors.New("AWS credentials not given")
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://kms.%s.amazonaws.com", region)
+	}
+	if _, err := url.ParseRequestURI(endpoint); err != nil {
+		return nil, errors.Errorf("invalid AWS KMS endpoint %q: %v", endpoint, err)
+	}
+	return &AWSKMSProvider{
+		endpoint:    strings.TrimSuffix(endpoint, "/"),
+		region:      region,
+		keyID:       keyID,
+		credentials: credentials,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		now:         time.Now,
+	}, nil
+}
+
+type awsKMSResponse struct {
+	CiphertextBlob string `json:"CiphertextBlob"`
+	Plaintext      string `json:"Plaintext"`
+	Type           string `json:"__type"`
+	Message        string `json:"message"`
+}
+
+func (p *AWSKMSProvider) call(operation string, body map[string]string) (*awsKMSResponse, error) {
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.endpoint+"/", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+operation)
+	signAWSRequest(req, reqBody, p.credentials, p.region, "kms", p.now())
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, errors.Errorf("AWS KMS %s failed: %v", operation, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Errorf("AWS KMS %s failed: %v", operation, err)
+	}
+	var ret awsKMSResponse
+	if err := json.Unmarshal(respBody, &ret); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, errors.Errorf("AWS KMS %s returned invalid response: %v", operation, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// the type is namespaced with the service in some responses
+		errorType := ret.Type[strings.LastIndex(ret.Type, "#")+1:]
+		return nil, errors.Errorf("AWS KMS %s failed with status %d: %s %s", operation, resp.StatusCode, errorType, ret.Message)
+	}
+	return &ret, nil
+}
+
+// ciphertext returns the ciphertext blob of a response tagged as produced by the provider
+func (p *AWSKMSProvider) ciphertext(ret *awsKMSResponse) []byte {
+	return []byte(awsKMSCiphertextPrefix + ret.CiphertextBlob)
+}
+
+func (p *AWSKMSProvider) Encrypt(plaintext []byte) ([]byte, error) {
+	ret, err := p.call("Encrypt", map[string]string{
+		"KeyId":     p.keyID,
+		"Plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p.ciphertext(ret), nil
+}
+
+// Decrypt decrypts with the key the ciphertext blob names, so values encrypted
+// under the key an alias pointed at before stay readable
+func (p *AWSKMSProvider) Decrypt(ciphertext []byte) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, []byte(awsKMSCiphertextPrefix)) {
+		return ciphertext, nil
+	}
+	ret, err := p.call("Decrypt", map[string]string{
+		"CiphertextBlob": string(ciphertext[len(awsKMSCiphertextPrefix):]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(ret.Plaintext)
+	if err != nil {
+		return nil, errors.Errorf("AWS KMS Decrypt returned invalid plaintext: %v", err)
+	}
+	return plaintext, nil
+}
+
+func (p *AWSKMSProvider) Reencrypt(ciphertext []byte) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, []byte(awsKMSCiphertextPrefix)) {
+		return p.Encrypt(ciphertext)
+	}
+	ret, err := p.call("ReEncrypt", map[string]string{
+		"CiphertextBlob":   string(ciphertext[len(awsKMSCiphertextPrefix):]),
+		"DestinationKeyId": p.keyID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p.ciphertext(ret), nil
+}
+
+// signAWSRequest adds the headers of AWS Signature Version 4 to req, whose
+// body is body, for service in region at time now
+func signAWSRequest(req *http.Request, body []byte, credentials AWSCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+	payloadHash := sha256.Sum256(body)
+
+	// CANONICAL request, signing the host and all headers set on req
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{req.Method, path, canonicalAWSQuery(req.URL.Query()),
+		canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:])}, "\n")
+
+	// SIGN the request with the key derived for the date, region and service
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := []byte("AWS4" + credentials.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalAWSQuery returns the query sorted by name and value, encoded as signed by AWS
+func canonicalAWSQuery(query url.Values) string {
+	pairs := []string{}
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, awsEscape(name)+"="+awsEscape(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape percent-encodes s except for the unreserved characters of RFC 3986
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
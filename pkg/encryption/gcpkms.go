@@ -0,0 +1,247 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+)
+
+// prefix of ciphertexts produced by Cloud KMS, followed by the base64 ciphertext
+const gcpKMSCiphertextPrefix = "gcpkms:"
+
+const (
+	defaultGCPKMSEndpoint = "https://cloudkms.googleapis.com"
+	// OAuth scope of the Cloud KMS API
+	gcpKMSScope = "https://www.googleapis.com/auth/cloudkms"
+	// time before the expiry of an access token it is renewed at
+	gcpTokenRenewal = time.Minute
+)
+
+// token endpoint of the metadata server of Google Cloud, returning the access
+// tokens of the service account attached to the instance or workload
+var gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// GCPKMSProvider encrypts with a symmetric key of Google Cloud KMS
+// key material never leaves Cloud KMS; new values are encrypted with the
+// primary version of the key, rotation is done in Cloud KMS, and stored
+// values are migrated with Reencrypt
+type GCPKMSProvider struct {
+	endpoint string
+	keyName  string
+	// service account signing the requests of access tokens, the service
+	// account of the metadata server if nil
+	account *gcpServiceAccount
+	client  *http.Client
+	now     func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// key file of a Google Cloud service account
+type gcpServiceAccount struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// NewGCPKMSProvider returns a provider for the crypto key keyName, of the form
+// projects/<project>/locations/<location>/keyRings/<key ring>/cryptoKeys/<key>,
+// called at endpoint, by default the Cloud KMS API
+// requests are authorized as the service account of credentialsFile, a key
+// file, or with the service account of the metadata server if empty
+func NewGCPKMSProvider(keyName, credentialsFile, endpoint string) (*GCPKMSProvider, error) {
+	parts := strings.Split(keyName, "/")
+	if len(parts) != 8 || parts[0] != "projects" || parts[2] != "locations" || parts[4] != "keyRings" || parts[6] != "cryptoKeys" {
+		return nil, errors.Errorf("invalid Cloud KMS key name %q", keyName)
+	}
+	if endpoint == "" {
+		endpoint = defaultGCPKMSEndpoint
+	}
+	if _, err := url.ParseRequestURI(endpoint); err != nil {
+		return nil, errors.Errorf("invalid Cloud KMS endpoint %q: %v", endpoint, err)
+	}
+	p := &GCPKMSProvider{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		keyName:  keyName,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		now:      time.Now,
+	}
+	if credentialsFile != "" {
+		data, err := os.ReadFile(credentialsFile)
+		if err != nil {
+			return nil, errors.Errorf("could not read Google Cloud credentials: %v", err)
+		}
+		p.account = &gcpServiceAccount{}
+		if err = json.Unmarshal(data, p.account); err != nil {
+			return nil, errors.Errorf("invalid Google Cloud credentials %s: %v", credentialsFile, err)
+		}
+		if p.account.ClientEmail == "" || p.account.TokenURI == "" {
+			return nil, errors.Errorf("Google Cloud credentials %s are not a service account key", credentialsFile)
+		}
+		if _, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(p.account.PrivateKey)); err != nil {
+			return nil, errors.Errorf("invalid private key in Google Cloud credentials %s: %v", credentialsFile, err)
+		}
+	}
+	return p, nil
+}
+
+type gcpTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// accessToken returns an access token of the Cloud KMS API, renewed before it expires
+func (p *GCPKMSProvider) accessToken() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	if p.token != "" && now.Add(gcpTokenRenewal).Before(p.expires) {
+		return p.token, nil
+	}
+
+	var req *http.Request
+	var err error
+	if p.account == nil {
+		req, err = http.NewRequest(http.MethodGet, gcpMetadataTokenURL, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	} else {
+		// exchange a JWT signed by the service account for an access token
+		key, _ := jwt.ParseRSAPrivateKeyFromPEM([]byte(p.account.PrivateKey))
+		assertion := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":   p.account.ClientEmail,
+			"scope": gcpKMSScope,
+			"aud":   p.account.TokenURI,
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+		})
+		assertion.Header["kid"] = p.account.PrivateKeyID
+		signed, err := assertion.SignedString(key)
+		if err != nil {
+			return "", errors.Errorf("could not sign Google Cloud token request: %v", err)
+		}
+		form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {signed}}
+		req, err = http.NewRequest(http.MethodPost, p.account.TokenURI, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", errors.Errorf("could not get Google Cloud access token: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Errorf("could not get Google Cloud access token: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("could not get Google Cloud access token, status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	var ret gcpTokenResponse
+	if err = json.Unmarshal(respBody, &ret); err != nil || ret.AccessToken == "" {
+		return "", errors.Errorf("invalid Google Cloud access token response: %v", err)
+	}
+	p.token = ret.AccessToken
+	p.expires = now.Add(time.Duration(ret.ExpiresIn) * time.Second)
+	return p.token, nil
+}
+
+type gcpKMSResponse struct {
+	Ciphertext string `json:"ciphertext"`
+	Plaintext  string `json:"plaintext"`
+	Error      struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *GCPKMSProvider) call(operation string, body map[string]string) (*gcpKMSResponse, error) {
+	token, err := p.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := fmt.Sprintf("%s/v1/%s:%s", p.endpoint, p.keyName, operation)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, errors.Errorf("Cloud KMS %s failed: %v", operation, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Errorf("Cloud KMS %s failed: %v", operation, err)
+	}
+	var ret gcpKMSResponse
+	if err := json.Unmarshal(respBody, &ret); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, errors.Errorf("Cloud KMS %s returned invalid response: %v", operation, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Cloud KMS %s failed with status %d: %s %s", operation, resp.StatusCode, ret.Error.Status, ret.Error.Message)
+	}
+	return &ret, nil
+}
+
+func (p *GCPKMSProvider) Encrypt(plaintext []byte) ([]byte, error) {
+	ret, err := p.call("encrypt", map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(gcpKMSCiphertextPrefix + ret.Ciphertext), nil
+}
+
+func (p *GCPKMSProvider) Decrypt(ciphertext []byte) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, []byte(gcpKMSCiphertextPrefix)) {
+		return ciphertext, nil
+	}
+	ret, err := p.call("decrypt", map[string]string{
+		"ciphertext": string(ciphertext[len(gcpKMSCiphertextPrefix):]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(ret.Plaintext)
+	if err != nil {
+		return nil, errors.Errorf("Cloud KMS decrypt returned invalid plaintext: %v", err)
+	}
+	return plaintext, nil
+}
+
+// Reencrypt decrypts ciphertext and encrypts it with the primary version of the key,
+// Cloud KMS has no operation reencrypting values
+func (p *GCPKMSProvider) Reencrypt(ciphertext []byte) ([]byte, error) {
+	plaintext, err := p.Decrypt(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return p.Encrypt(plaintext)
+}
@@ -0,0 +1,93 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// length of the peppers created by LoadPepper
+const pepperLength = 32
+
+// Hasher hashes API keys and tokens, which are stored and looked up by hash
+// with a pepper the hashes are HMAC-SHA256 keyed with it, so stored hashes
+// cannot be checked against guessed keys without the pepper
+// a nil Hasher hashes with SHA-256
+type Hasher struct {
+	pepper []byte
+}
+
+// NewHasher returns a Hasher keyed with pepper, hashing with SHA-256 if pepper is empty
+func NewHasher(pepper []byte) *Hasher {
+	return &Hasher{pepper: pepper}
+}
+
+// Hash returns the hash a secret is stored under
+func (h *Hasher) Hash(secret string) string {
+	if h == nil || len(h.pepper) == 0 {
+		sum := sha256.Sum256([]byte(secret))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, h.pepper)
+	mac.Write([]byte(secret))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Hashes returns the hashes a secret may be stored under: Hash, then the
+// SHA-256 hash of secrets stored before the pepper was configured
+func (h *Hasher) Hashes(secret string) []string {
+	hashes := []string{h.Hash(secret)}
+	if h != nil && len(h.pepper) > 0 {
+		hashes = append(hashes, (*Hasher)(nil).Hash(secret))
+	}
+	return hashes
+}
+
+// PepperStore keeps the encrypted pepper, shared by replicas using the same store
+type PepperStore interface {
+	// GetOrCreateSecret stores value under name unless a value is stored
+	// under it, and returns the stored value
+	GetOrCreateSecret(name string, value []byte) ([]byte, error)
+	// UpdateSecret replaces the value stored under name
+	UpdateSecret(name string, value []byte) error
+}
+
+// LoadPepper returns the pepper stored in store under name, encrypted by provider
+// a random pepper is stored if there is none; the stored pepper is reencrypted
+// under the current key of provider, so it stays readable after a rotation
+func LoadPepper(store PepperStore, provider Provider, name string) ([]byte, error) {
+	pepper := make([]byte, pepperLength)
+	if _, err := rand.Read(pepper); err != nil {
+		return nil, errors.Errorf("could not generate pepper: %v", err)
+	}
+	ciphertext, err := provider.Encrypt(pepper)
+	if err != nil {
+		return nil, errors.Errorf("could not encrypt pepper: %v", err)
+	}
+	stored, err := store.GetOrCreateSecret(name, ciphertext)
+	if err != nil {
+		return nil, errors.Errorf("could not store pepper: %v", err)
+	}
+	pepper, err = provider.Decrypt(stored)
+	if err != nil {
+		return nil, errors.Errorf("could not decrypt pepper: %v", err)
+	}
+	if len(pepper) != pepperLength {
+		return nil, errors.Errorf("pepper %q is not encrypted by the configured Encryption plugin", name)
+	}
+
+	reencrypted, err := provider.Reencrypt(stored)
+	if err != nil {
+		return nil, errors.Errorf("could not reencrypt pepper: %v", err)
+	}
+	if !bytes.Equal(reencrypted, stored) {
+		if err := store.UpdateSecret(name, reencrypted); err != nil {
+			return nil, errors.Errorf("could not store reencrypted pepper: %v", err)
+		}
+	}
+	return pepper, nil
+}
@@ -0,0 +1,103 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+type memorySecretStore struct {
+	secrets map[string][]byte
+	updates int
+}
+
+func (s *memorySecretStore) GetOrCreateSecret(name string, value []byte) ([]byte, error) {
+	if _, ok := s.secrets[name]; !ok {
+		s.secrets[name] = value
+	}
+	return s.secrets[name], nil
+}
+
+func (s *memorySecretStore) UpdateSecret(name string, value []byte) error {
+	s.secrets[name] = value
+	s.updates++
+	return nil
+}
+
+// TestHasher checks hashes are keyed with the pepper, and that the SHA-256
+// hash of secrets stored before the pepper is looked up as well
+func TestHasher(t *testing.T) {
+	sum := sha256.Sum256([]byte("tjk_key"))
+	unpeppered := hex.EncodeToString(sum[:])
+
+	// CHECK without a pepper, secrets are hashed with SHA-256
+	for _, h := range []*Hasher{nil, NewHasher(nil)} {
+		if got := h.Hash("tjk_key"); got != unpeppered {
+			t.Fatalf("Expected SHA-256 hash %s, got %s", unpeppered, got)
+		}
+		if got := h.Hashes("tjk_key"); len(got) != 1 || got[0] != unpeppered {
+			t.Fatalf("Expected only the SHA-256 hash, got %v", got)
+		}
+	}
+
+	// CHECK with a pepper, hashes depend on it and fall back to SHA-256
+	a, b := NewHasher([]byte("pepper-a")), NewHasher([]byte("pepper-b"))
+	if a.Hash("tjk_key") == unpeppered || a.Hash("tjk_key") == b.Hash("tjk_key") {
+		t.Fatalf("Expected hashes keyed with the pepper, got %s and %s", a.Hash("tjk_key"), b.Hash("tjk_key"))
+	}
+	if got := a.Hashes("tjk_key"); len(got) != 2 || got[0] != a.Hash("tjk_key") || got[1] != unpeppered {
+		t.Fatalf("Unexpected hashes %v", got)
+	}
+}
+
+// TestLoadPepper checks the pepper is created once, stored encrypted and
+// reencrypted after a key rotation
+func TestLoadPepper(t *testing.T) {
+	store := &memorySecretStore{secrets: map[string][]byte{}}
+	oldProvider, err := NewLocalAESProvider("k1", map[string][]byte{"k1": testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ATTEMPT load the pepper twice
+	pepper, err := LoadPepper(store, oldProvider, "pepper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := LoadPepper(store, oldProvider, "pepper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK the pepper is created once and stored encrypted
+	if len(pepper) != pepperLength || !bytes.Equal(pepper, again) {
+		t.Fatalf("Expected the same pepper, got %x and %x", pepper, again)
+	}
+	if bytes.Contains(store.secrets["pepper"], pepper) || store.updates != 0 {
+		t.Fatalf("Expected the pepper stored encrypted once, got %q and %d updates", store.secrets["pepper"], store.updates)
+	}
+
+	// ATTEMPT load the pepper after a rotation
+	newProvider, err := NewLocalAESProvider("k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := LoadPepper(store, newProvider, "pepper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CHECK the pepper is kept, reencrypted under the new key
+	if !bytes.Equal(pepper, rotated) {
+		t.Fatalf("Expected the pepper kept, got %x", rotated)
+	}
+	if !strings.HasPrefix(string(store.secrets["pepper"]), localCiphertextPrefix+"k2:") || store.updates != 1 {
+		t.Fatalf("Expected the pepper reencrypted under k2, got %q", store.secrets["pepper"])
+	}
+
+	// CHECK a pepper that is not encrypted by the provider is rejected
+	store.secrets["plain"] = []byte("not a pepper")
+	if _, err = LoadPepper(store, newProvider, "plain"); err == nil {
+		t.Fatal("Expected error loading a pepper that is not encrypted")
+	}
+}
@@ -0,0 +1,131 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// prefix of ciphertexts produced by LocalAESProvider, followed by "<key id>:<base64 nonce and sealed data>"
+const localCiphertextPrefix = "tjenc:v1:"
+
+// LocalAESProvider encrypts with AES-256-GCM keys held by Tornjak
+// new values use the primary key; older keys are kept for decryption after rotation
+type LocalAESProvider struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewLocalAESProvider returns a provider for the given 32-byte keys by id
+// primary is the id of the key used for encryption
+func NewLocalAESProvider(primary string, keys map[string][]byte) (*LocalAESProvider, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, errors.Errorf("primary key %q not configured", primary)
+	}
+	aeads := make(map[string]cipher.AEAD, len(keys))
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, errors.Errorf("invalid key id %q", id)
+		}
+		if len(key) != 32 {
+			return nil, errors.Errorf("key %q must be 32 bytes, got %d", id, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.Errorf("key %q: %v", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.Errorf("key %q: %v", id, err)
+		}
+		aeads[id] = aead
+	}
+	return &LocalAESProvider{
+		primary: primary,
+		keys:    aeads,
+	}, nil
+}
+
+// ReadKeyFile reads a base64-encoded 32-byte key from path
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Errorf("could not read key file %s: %v", path, err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, errors.Errorf("key file %s is not base64: %v", path, err)
+	}
+	return key, nil
+}
+
+func (p *LocalAESProvider) Encrypt(plaintext []byte) ([]byte, error) {
+	aead := p.keys[p.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Errorf("could not generate nonce: %v", err)
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(p.primary))
+	return []byte(localCiphertextPrefix + p.primary + ":" + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// parse splits a ciphertext into its key id and sealed data
+// returns false if the data was not produced by LocalAESProvider
+func (p *LocalAESProvider) parse(ciphertext []byte) (string, []byte, bool, error) {
+	if !bytes.HasPrefix(ciphertext, []byte(localCiphertextPrefix)) {
+		return "", nil, false, nil
+	}
+	id, encoded, found := strings.Cut(string(ciphertext[len(localCiphertextPrefix):]), ":")
+	if !found {
+		return "", nil, true, errors.New("malformed ciphertext")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, true, errors.Errorf("malformed ciphertext: %v", err)
+	}
+	return id, sealed, true, nil
+}
+
+func (p *LocalAESProvider) Decrypt(ciphertext []byte) ([]byte, error) {
+	id, sealed, ok, err := p.parse(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return ciphertext, nil
+	}
+	aead, ok := p.keys[id]
+	if !ok {
+		return nil, errors.Errorf("key %q not configured", id)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed ciphertext")
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, []byte(id))
+	if err != nil {
+		return nil, errors.Errorf("could not decrypt with key %q: %v", id, err)
+	}
+	return plaintext, nil
+}
+
+func (p *LocalAESProvider) Reencrypt(ciphertext []byte) ([]byte, error) {
+	id, _, ok, err := p.parse(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if ok && id == p.primary {
+		return ciphertext, nil
+	}
+	plaintext, err := p.Decrypt(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return p.Encrypt(plaintext)
+}
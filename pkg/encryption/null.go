@@ -0,0 +1,20 @@
+package encryption
+
+// NullProvider stores values as given, used when no encryption is configured
+type NullProvider struct{}
+
+func NewNullProvider() *NullProvider {
+	return &NullProvider{}
+}
+
+func (p *NullProvider) Encrypt(plaintext []byte) ([]byte, error) {
+	return plaintext, nil
+}
+
+func (p *NullProvider) Decrypt(ciphertext []byte) ([]byte, error) {
+	return ciphertext, nil
+}
+
+func (p *NullProvider) Reencrypt(ciphertext []byte) ([]byte, error) {
+	return ciphertext, nil
+}
@@ -0,0 +1,19 @@
+package encryption
+
+// Provider encrypts sensitive material before Tornjak stores it, such as
+// manager server credentials and the pepper of API key hashes. Implementations may use a
+// local key or delegate to an external key management service.
+type Provider interface {
+	// Encrypt returns the ciphertext of plaintext under the current key
+	// the ciphertext identifies the key it was encrypted with
+	Encrypt(plaintext []byte) ([]byte, error)
+
+	// Decrypt returns the plaintext of ciphertext produced by Encrypt
+	// data not produced by the provider is returned unchanged, so values
+	// stored before encryption was enabled stay readable
+	Decrypt(ciphertext []byte) ([]byte, error)
+
+	// Reencrypt returns ciphertext encrypted under the current key
+	// used to migrate stored values after a key rotation
+	Reencrypt(ciphertext []byte) ([]byte, error)
+}
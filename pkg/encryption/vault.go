@@ -0,0 +1,133 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// prefix of ciphertexts produced by the Vault transit secrets engine
+const vaultCiphertextPrefix = "vault:"
+
+// default mount path of the Vault transit secrets engine
+const defaultVaultTransitMount = "transit"
+
+// VaultTransitProvider encrypts with a named key of the Vault transit secrets engine
+// key material never leaves Vault; rotation is done in Vault and stored values
+// are migrated with Reencrypt, which uses the rewrap endpoint
+type VaultTransitProvider struct {
+	address string
+	token   string
+	mount   string
+	keyName string
+	client  *http.Client
+}
+
+// NewVaultTransitProvider returns a provider for key keyName of the transit
+// engine mounted at mount ("transit" if empty) on the Vault server at address
+func NewVaultTransitProvider(address, token, mount, keyName string) (*VaultTransitProvider, error) {
+	if _, err := url.ParseRequestURI(address); err != nil {
+		return nil, errors.Errorf("invalid Vault address %q: %v", address, err)
+	}
+	if keyName == "" {
+		return nil, errors.New("Vault transit key name not given")
+	}
+	if mount == "" {
+		mount = defaultVaultTransitMount
+	}
+	return &VaultTransitProvider{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		mount:   strings.Trim(mount, "/"),
+		keyName: keyName,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type vaultTransitResponse struct {
+	Data struct {
+		Ciphertext string `json:"ciphertext"`
+		Plaintext  string `json:"plaintext"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+func (p *VaultTransitProvider) call(operation string, body map[string]string) (*vaultTransitResponse, error) {
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := fmt.Sprintf("%s/v1/%s/%s/%s", p.address, p.mount, operation, url.PathEscape(p.keyName))
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, errors.Errorf("Vault transit %s failed: %v", operation, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Errorf("Vault transit %s failed: %v", operation, err)
+	}
+	var ret vaultTransitResponse
+	if err := json.Unmarshal(respBody, &ret); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, errors.Errorf("Vault transit %s returned invalid response: %v", operation, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Vault transit %s failed with status %d: %s", operation, resp.StatusCode, strings.Join(ret.Errors, "; "))
+	}
+	return &ret, nil
+}
+
+func (p *VaultTransitProvider) Encrypt(plaintext []byte) ([]byte, error) {
+	ret, err := p.call("encrypt", map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(ret.Data.Ciphertext), nil
+}
+
+func (p *VaultTransitProvider) Decrypt(ciphertext []byte) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, []byte(vaultCiphertextPrefix)) {
+		return ciphertext, nil
+	}
+	ret, err := p.call("decrypt", map[string]string{
+		"ciphertext": string(ciphertext),
+	})
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(ret.Data.Plaintext)
+	if err != nil {
+		return nil, errors.Errorf("Vault transit decrypt returned invalid plaintext: %v", err)
+	}
+	return plaintext, nil
+}
+
+func (p *VaultTransitProvider) Reencrypt(ciphertext []byte) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, []byte(vaultCiphertextPrefix)) {
+		return p.Encrypt(ciphertext)
+	}
+	ret, err := p.call("rewrap", map[string]string{
+		"ciphertext": string(ciphertext),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(ret.Data.Ciphertext), nil
+}
@@ -0,0 +1,102 @@
+package aggregation
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+	"github.com/spiffe/tornjak/pkg/manager/types"
+)
+
+// Cache keeps the last result of each source of a fan-out query, such as a
+// registered server or a peer manager, with the time it was fetched
+// results younger than the TTL are served without querying the source again,
+// and results younger than the maximum staleness are served, marked stale,
+// when the source cannot be queried
+type Cache struct {
+	ttl      time.Duration
+	maxStale time.Duration
+	clock    clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+type entry struct {
+	value     interface{}
+	fetchedAt time.Time
+}
+
+// New returns a cache serving results for ttl and stale results for maxStale
+// after they were fetched, by c, the clock of the system if nil
+// a ttl of 0 queries the sources on every call, and a maxStale of 0 never
+// serves stale results
+func New(ttl, maxStale time.Duration, c clock.Clock) *Cache {
+	return &Cache{
+		ttl:      ttl,
+		maxStale: maxStale,
+		clock:    clock.OrNew(c),
+		entries:  make(map[string]entry),
+	}
+}
+
+// finalError is an error of a source that answered the query
+type finalError struct {
+	err error
+}
+
+func (e finalError) Error() string { return e.err.Error() }
+func (e finalError) Unwrap() error { return e.err }
+
+// Final marks an error the source answered with, such as a rejected request,
+// for which no stale result is served
+func Final(err error) error {
+	return finalError{err: err}
+}
+
+// Fetch returns the result of the source the query of key was sent to, from
+// the cache if fetched within the TTL, otherwise by calling fetch
+// if fetch fails, the cached result is returned marked stale with the error,
+// and the error is only returned if there is none or it is older than the
+// maximum staleness, or if the error is Final
+func Fetch[T any](c *Cache, key string, fetch func() (T, error)) (T, types.SourceFreshness, error) {
+	now := c.clock.Now()
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && c.ttl > 0 && now.Sub(e.fetchedAt) < c.ttl {
+		return e.value.(T), freshness(e.fetchedAt, ""), nil
+	}
+
+	value, err := fetch()
+	if err != nil {
+		var final finalError
+		if !errors.As(err, &final) && ok && now.Sub(e.fetchedAt) < c.maxStale {
+			ret := freshness(e.fetchedAt, err.Error())
+			ret.Stale = true
+			return e.value.(T), ret, nil
+		}
+		var zero T
+		return zero, types.SourceFreshness{}, err
+	}
+	c.store(key, value, now)
+	return value, freshness(now, ""), nil
+}
+
+// store caches value under key, removing the entries too old to be served
+func (c *Cache) store(key string, value interface{}, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if now.Sub(e.fetchedAt) >= max(c.ttl, c.maxStale) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = entry{value: value, fetchedAt: now}
+}
+
+func freshness(fetchedAt time.Time, err string) types.SourceFreshness {
+	return types.SourceFreshness{FetchedAt: fetchedAt.UTC().Format(time.RFC3339), Error: err}
+}
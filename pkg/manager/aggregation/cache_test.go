@@ -0,0 +1,70 @@
+package aggregation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spiffe/tornjak/pkg/agent/clock"
+)
+
+// TestFetch checks results are cached for the TTL and served stale while the
+// source fails, up to the maximum staleness
+func TestFetch(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	c := New(time.Minute, time.Hour, fake)
+	calls := 0
+	var fetchErr error
+	fetch := func() (string, error) {
+		calls++
+		if fetchErr != nil {
+			return "", fetchErr
+		}
+		return "result", nil
+	}
+
+	// ATTEMPT first query; should fetch the result [Fetch]
+	value, freshness, err := Fetch(c, "server1", fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "result" || freshness.FetchedAt != "2024-03-01T12:00:00Z" || freshness.Stale || calls != 1 {
+		t.Fatalf("Expected a fresh result, got %q, %+v after %d calls", value, freshness, calls)
+	}
+
+	// ATTEMPT query within the TTL; should be served from the cache [Fetch]
+	fake.Add(30 * time.Second)
+	if _, _, err = Fetch(c, "server1", fetch); err != nil || calls != 1 {
+		t.Fatalf("Expected a cached result, got %v after %d calls", err, calls)
+	}
+
+	// ATTEMPT query of an unreachable source; should serve the stale result [Fetch]
+	fake.Add(time.Minute)
+	fetchErr = errors.New("connection refused")
+	value, freshness, err = Fetch(c, "server1", fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "result" || !freshness.Stale || freshness.Error != "connection refused" || freshness.FetchedAt != "2024-03-01T12:00:00Z" {
+		t.Fatalf("Expected a stale result, got %q, %+v", value, freshness)
+	}
+
+	// CHECK final errors are returned rather than stale results [Fetch]
+	fetchErr = Final(errors.New("loop"))
+	if _, _, err = Fetch(c, "server1", fetch); err == nil || err.Error() != "loop" {
+		t.Fatalf("Expected the final error, got %v", err)
+	}
+
+	// CHECK source without cached result [Fetch]
+	fetchErr = errors.New("connection refused")
+	if _, _, err = Fetch(c, "server2", fetch); err == nil {
+		t.Fatal("Expected an error without cached result")
+	}
+
+	// CHECK result older than the maximum staleness [Fetch]
+	fake.Add(time.Hour)
+	if _, _, err = Fetch(c, "server1", fetch); err == nil {
+		t.Fatal("Expected an error once the cached result is too old")
+	}
+}
@@ -8,4 +8,8 @@ type ManagerDB interface {
 	CreateServerEntry(sinfo types.ServerInfo) error
 	GetServers() (types.ServerInfoList, error)
 	GetServer(name string) (types.ServerInfo, error)
+	CreatePeerEntry(pinfo types.PeerInfo) error
+	GetPeers() (types.PeerInfoList, error)
+	GetPeer(name string) (types.PeerInfo, error)
+	ReencryptSecrets() error
 }
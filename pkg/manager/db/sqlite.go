@@ -2,39 +2,52 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 
+	"github.com/spiffe/tornjak/pkg/encryption"
 	"github.com/spiffe/tornjak/pkg/manager/types"
 )
 
 // TO DO: Add DELETE servers option from the data base
 const (
 	initServersTable = "CREATE TABLE IF NOT EXISTS servers (servername TEXT PRIMARY KEY, address TEXT, tls bool, mtls bool, ca varBinary, cert varBinary, key varBinary)"
+	initPeersTable   = "CREATE TABLE IF NOT EXISTS peers (peername TEXT PRIMARY KEY, address TEXT, token varBinary, ca varBinary)"
 )
 
 type LocalSqliteDb struct {
 	database *sql.DB
+	// encrypts server private keys and peer tokens at rest
+	encryption encryption.Provider
 }
 
 func NewLocalSqliteDB(dbpath string) (ManagerDB, error) {
+	return NewLocalSqliteDBWithEncryption(dbpath, encryption.NewNullProvider())
+}
+
+// NewLocalSqliteDBWithEncryption returns a DB storing server private keys and peer tokens encrypted by provider
+func NewLocalSqliteDBWithEncryption(dbpath string, provider encryption.Provider) (ManagerDB, error) {
 	database, err := sql.Open("sqlite3", dbpath)
 	if err != nil {
 		return nil, errors.New("Unable to open connection to DB")
 	}
-	// Table for servers
-	statement, err := database.Prepare(initServersTable)
-	if err != nil {
-		return nil, errors.Errorf("Unable to execute SQL query :%v", initServersTable)
-	}
-	_, err = statement.Exec()
-	if err != nil {
-		return nil, errors.Errorf("Unable to execute SQL query :%v", initServersTable)
+	// Tables for servers and peer managers
+	for _, cmd := range []string{initServersTable, initPeersTable} {
+		statement, err := database.Prepare(cmd)
+		if err != nil {
+			return nil, errors.Errorf("Unable to execute SQL query :%v", cmd)
+		}
+		_, err = statement.Exec()
+		if err != nil {
+			return nil, errors.Errorf("Unable to execute SQL query :%v", cmd)
+		}
 	}
 
 	return &LocalSqliteDb{
-		database: database,
+		database:   database,
+		encryption: provider,
 	}, nil
 }
 
@@ -43,7 +56,11 @@ func (db *LocalSqliteDb) CreateServerEntry(sinfo types.ServerInfo) error {
 	if err != nil {
 		return errors.Errorf("Unable to execute SQL query: %v", err)
 	}
-	_, err = statement.Exec(sinfo.Name, sinfo.Address, sinfo.TLS, sinfo.MTLS, sinfo.CA, sinfo.Cert, sinfo.Key)
+	key, err := db.encrypt(sinfo.Key, "server key")
+	if err != nil {
+		return err
+	}
+	_, err = statement.Exec(sinfo.Name, sinfo.Address, sinfo.TLS, sinfo.MTLS, sinfo.CA, sinfo.Cert, key)
 
 	return err
 }
@@ -68,6 +85,9 @@ func (db *LocalSqliteDb) GetServers() (types.ServerInfoList, error) {
 		if err = rows.Scan(&name, &address, &tls, &mtls, &ca, &cert, &key); err != nil {
 			return types.ServerInfoList{}, err
 		}
+		if key, err = db.decrypt(key, "server key"); err != nil {
+			return types.ServerInfoList{}, err
+		}
 
 		sinfos = append(sinfos, types.ServerInfo{
 			Name:    name,
@@ -93,6 +113,134 @@ func (db *LocalSqliteDb) GetServer(name string) (types.ServerInfo, error) {
 	if err != nil {
 		return types.ServerInfo{}, err
 	}
+	if sinfo.Key, err = db.decrypt(sinfo.Key, "server key"); err != nil {
+		return types.ServerInfo{}, err
+	}
 
 	return sinfo, nil
 }
+
+func (db *LocalSqliteDb) CreatePeerEntry(pinfo types.PeerInfo) error {
+	statement, err := db.database.Prepare("INSERT INTO peers (peername, address, token, ca) VALUES (?,?,?,?)")
+	if err != nil {
+		return errors.Errorf("Unable to execute SQL query: %v", err)
+	}
+	token, err := db.encrypt([]byte(pinfo.Token), "peer token")
+	if err != nil {
+		return err
+	}
+	_, err = statement.Exec(pinfo.Name, pinfo.Address, token, pinfo.CA)
+
+	return err
+}
+
+func (db *LocalSqliteDb) GetPeers() (types.PeerInfoList, error) {
+	rows, err := db.database.Query("SELECT peername, address, token, ca FROM peers")
+	if err != nil {
+		return types.PeerInfoList{}, errors.New("Unable to execute SQL query")
+	}
+	defer rows.Close()
+
+	pinfos := []types.PeerInfo{}
+	for rows.Next() {
+		pinfo, err := db.scanPeer(rows)
+		if err != nil {
+			return types.PeerInfoList{}, err
+		}
+		pinfos = append(pinfos, pinfo)
+	}
+
+	return types.PeerInfoList{
+		Peers: pinfos,
+	}, nil
+}
+
+func (db *LocalSqliteDb) GetPeer(name string) (types.PeerInfo, error) {
+	row := db.database.QueryRow("SELECT peername, address, token, ca FROM peers WHERE peername=?", name)
+	return db.scanPeer(row)
+}
+
+func (db *LocalSqliteDb) scanPeer(row interface{ Scan(...interface{}) error }) (types.PeerInfo, error) {
+	pinfo := types.PeerInfo{}
+	var token []byte
+	if err := row.Scan(&pinfo.Name, &pinfo.Address, &token, &pinfo.CA); err != nil {
+		return types.PeerInfo{}, err
+	}
+	token, err := db.decrypt(token, "peer token")
+	if err != nil {
+		return types.PeerInfo{}, err
+	}
+	pinfo.Token = string(token)
+	return pinfo, nil
+}
+
+// ReencryptSecrets encrypts all stored server private keys and peer tokens under the current key
+// used after a key rotation, or to encrypt secrets stored before encryption was enabled
+func (db *LocalSqliteDb) ReencryptSecrets() error {
+	if err := db.reencryptColumn("servers", "servername", "key"); err != nil {
+		return err
+	}
+	return db.reencryptColumn("peers", "peername", "token")
+}
+
+func (db *LocalSqliteDb) reencryptColumn(table, nameColumn, column string) error {
+	rows, err := db.database.Query(fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s IS NOT NULL", nameColumn, column, table, column))
+	if err != nil {
+		return errors.Errorf("Unable to execute SQL query: %v", err)
+	}
+	keys := make(map[string][]byte)
+	for rows.Next() {
+		var (
+			name string
+			key  []byte
+		)
+		if err = rows.Scan(&name, &key); err != nil {
+			rows.Close()
+			return err
+		}
+		keys[name] = key
+	}
+	rows.Close()
+
+	for name, key := range keys {
+		if len(key) == 0 {
+			continue
+		}
+		reencrypted, err := db.encryption.Reencrypt(key)
+		if err != nil {
+			return errors.Errorf("Unable to reencrypt %s of %s: %v", column, name, err)
+		}
+		if string(reencrypted) == string(key) {
+			continue
+		}
+		cmd := fmt.Sprintf("UPDATE %s SET %s=? WHERE %s=?", table, column, nameColumn)
+		if _, err = db.database.Exec(cmd, reencrypted, name); err != nil {
+			return errors.Errorf("Unable to execute SQL query: %v", err)
+		}
+	}
+	return nil
+}
+
+// encrypt encrypts a secret before it is stored, what names the secret in errors
+func (db *LocalSqliteDb) encrypt(value []byte, what string) ([]byte, error) {
+	if len(value) == 0 {
+		return value, nil
+	}
+	ciphertext, err := db.encryption.Encrypt(value)
+	if err != nil {
+		return nil, errors.Errorf("Unable to encrypt %s: %v", what, err)
+	}
+	return ciphertext, nil
+}
+
+// decrypt decrypts a stored secret, what names the secret in errors
+func (db *LocalSqliteDb) decrypt(value []byte, what string) ([]byte, error) {
+	if len(value) == 0 {
+		return value, nil
+	}
+	plaintext, err := db.encryption.Decrypt(value)
+	if err != nil {
+		return nil, errors.Errorf("Unable to decrypt %s: %v", what, err)
+	}
+	return plaintext, nil
+}
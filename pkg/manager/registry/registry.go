@@ -0,0 +1,124 @@
+package registry
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/invopop/yaml"
+	"github.com/pkg/errors"
+)
+
+// version and kind of the registry documents
+const (
+	APIVersion = "tornjak.io/v1"
+	Kind       = "ServerRegistry"
+)
+
+// minimum length of signing keys
+const minKeyLen = 32
+
+// Server is a server registered with a manager, as exported
+// CA and Cert are PEM; the private key of mutual TLS is only exported
+// encrypted, as the ciphertext of the encryption provider of the manager,
+// which names the key it was encrypted with
+type Server struct {
+	Name         string `json:"name"`
+	Address      string `json:"address"`
+	TLS          bool   `json:"tls"`
+	MTLS         bool   `json:"mtls"`
+	CA           string `json:"ca,omitempty"`
+	Cert         string `json:"cert,omitempty"`
+	EncryptedKey string `json:"encryptedKey,omitempty"`
+}
+
+// Document is the registry of servers of a manager, exported to restore it or
+// to register the servers with another manager
+type Document struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Manager    string     `json:"manager"`
+	ExportedAt string     `json:"exportedAt"`
+	Servers    []Server   `json:"servers"`
+	Signature  *Signature `json:"signature,omitempty"`
+}
+
+// Signature is the HMAC-SHA256 of the document without its signature, hex
+// encoded, with the key named by KeyID
+type Signature struct {
+	KeyID string `json:"keyId"`
+	Value string `json:"value"`
+}
+
+// Signer signs exported documents and verifies imported ones with a key
+// shared by the managers, e.g. a key file copied to each of them
+type Signer struct {
+	keyID string
+	key   []byte
+}
+
+// NewSigner returns the signer of the key identified by keyID
+func NewSigner(keyID string, key []byte) (*Signer, error) {
+	if keyID == "" {
+		return nil, errors.New("missing signing key id")
+	}
+	if len(key) < minKeyLen {
+		return nil, errors.Errorf("signing keys must be at least %d bytes", minKeyLen)
+	}
+	return &Signer{keyID: keyID, key: key}, nil
+}
+
+// Marshal returns the YAML of doc signed by s
+func (s *Signer) Marshal(doc Document) ([]byte, error) {
+	doc.APIVersion, doc.Kind = APIVersion, Kind
+	sum, err := s.sum(doc)
+	if err != nil {
+		return nil, err
+	}
+	doc.Signature = &Signature{KeyID: s.keyID, Value: hex.EncodeToString(sum)}
+	return yaml.Marshal(doc)
+}
+
+// Unmarshal returns the document of data, or an error if it is not a
+// registry document signed by s
+func (s *Signer) Unmarshal(data []byte) (Document, error) {
+	var doc Document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return Document{}, errors.Errorf("invalid registry document: %v", err)
+	}
+	if doc.APIVersion != APIVersion || doc.Kind != Kind {
+		return Document{}, errors.Errorf("not a registry document: apiVersion %q, kind %q", doc.APIVersion, doc.Kind)
+	}
+	if doc.Signature == nil {
+		return Document{}, errors.New("registry document is not signed")
+	}
+	if doc.Signature.KeyID != s.keyID {
+		return Document{}, errors.Errorf("registry document is signed with key %q, not %q", doc.Signature.KeyID, s.keyID)
+	}
+	signature, err := hex.DecodeString(doc.Signature.Value)
+	if err != nil {
+		return Document{}, errors.New("malformed registry document signature")
+	}
+	sum, err := s.sum(doc)
+	if err != nil {
+		return Document{}, err
+	}
+	if !hmac.Equal(signature, sum) {
+		return Document{}, errors.New("invalid registry document signature")
+	}
+	return doc, nil
+}
+
+// sum returns the HMAC of the JSON of doc without its signature, so the
+// signature does not depend on the layout of the YAML
+func (s *Signer) sum(doc Document) ([]byte, error) {
+	doc.Signature = nil
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	m := hmac.New(sha256.New, s.key)
+	m.Write(data)
+	return m.Sum(nil), nil
+}
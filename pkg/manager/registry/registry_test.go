@@ -0,0 +1,77 @@
+package registry
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+// TestSigner checks documents round trip through their signed YAML, and that
+// documents changed or signed with another key are rejected
+func TestSigner(t *testing.T) {
+	signer, err := NewSigner("registry.key", testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := Document{
+		Manager:    "manager-eu",
+		ExportedAt: "2024-05-01T12:00:00Z",
+		Servers: []Server{
+			{Name: "eu-de", Address: "https://tornjak.eu-de.example.org", TLS: true, CA: "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"},
+			{Name: "local", Address: "http://localhost:10000"},
+		},
+	}
+
+	// CHECK round trip [Marshal, Unmarshal]
+	data, err := signer.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := signer.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Signature == nil || imported.Kind != Kind || !reflect.DeepEqual(imported.Servers, doc.Servers) {
+		t.Fatalf("Unexpected document %+v", imported)
+	}
+
+	// CHECK changed document rejected [Unmarshal]
+	tampered := bytes.Replace(data, []byte("http://localhost:10000"), []byte("http://attacker:10000"), 1)
+	if _, err = signer.Unmarshal(tampered); err == nil || !strings.Contains(err.Error(), "invalid registry document signature") {
+		t.Fatalf("Expected invalid signature, got %v", err)
+	}
+
+	// CHECK document signed with another key rejected [Unmarshal]
+	other, err := NewSigner("registry.key", testKey(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.Unmarshal(data); err == nil {
+		t.Fatal("Expected error verifying with another key")
+	}
+	renamed, err := NewSigner("other.key", testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = renamed.Unmarshal(data); err == nil {
+		t.Fatal("Expected error verifying with another key id")
+	}
+
+	// CHECK unsigned and foreign documents rejected [Unmarshal]
+	if _, err = signer.Unmarshal([]byte("apiVersion: tornjak.io/v1\nkind: ServerRegistry\nservers: []\n")); err == nil {
+		t.Fatal("Expected error for unsigned document")
+	}
+	if _, err = signer.Unmarshal([]byte("apiVersion: v1\nkind: ConfigMap\n")); err == nil {
+		t.Fatal("Expected error for foreign document")
+	}
+
+	// CHECK short keys rejected [NewSigner]
+	if _, err = NewSigner("registry.key", testKey(1)[:16]); err == nil {
+		t.Fatal("Expected error for short key")
+	}
+}
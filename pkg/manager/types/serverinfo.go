@@ -48,3 +48,21 @@ func (s ServerInfo) HttpClient() (*http.Client, error) {
 	// default to no TLS
 	return &http.Client{}, nil
 }
+
+func (p PeerInfo) HttpClient() (*http.Client, error) {
+	if len(p.CA) == 0 {
+		// system roots are used for https peers without a CA
+		return &http.Client{}, nil
+	}
+	caCertPool := x509.NewCertPool()
+	if !caCertPool.AppendCertsFromPEM(p.CA) {
+		return nil, errors.New("Cannot parse CA of peer")
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		},
+	}, nil
+}
@@ -15,3 +15,63 @@ type ServerInfo struct {
 type ServerInfoList struct {
 	Servers []ServerInfo `json:"servers"`
 }
+
+// PeerInfo contains the information about peer managers federated with this manager
+// Token authenticates this manager to the peer and is never returned by list calls
+type PeerInfo struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Token   string `json:"token,omitempty"`
+	CA      []byte `json:"ca,omitempty"`
+}
+
+// PeerInfoList contains the information about peer managers
+type PeerInfoList struct {
+	Peers []PeerInfo `json:"peers"`
+}
+
+// FederatedServerInfo contains a server registered with this manager or a peer
+// Managers lists the peer names on the path from this manager to the server,
+// empty for servers registered with this manager
+type FederatedServerInfo struct {
+	Managers []string   `json:"managers"`
+	Server   ServerInfo `json:"server"`
+}
+
+// FederatedServerInfoList contains the servers of this manager and its peers
+// Errors maps peer names to the error querying them, and Freshness peer names
+// to the freshness of the servers listed for them
+type FederatedServerInfoList struct {
+	Servers   []FederatedServerInfo      `json:"servers"`
+	Errors    map[string]string          `json:"errors,omitempty"`
+	Freshness map[string]SourceFreshness `json:"freshness,omitempty"`
+}
+
+// AgentLocation contains a registered server whose Tornjak knows an agent,
+// with the agent metadata stored there
+type AgentLocation struct {
+	Server      string            `json:"server"`
+	Cluster     string            `json:"cluster,omitempty"`
+	Plugin      string            `json:"plugin,omitempty"`
+	DisplayName string            `json:"displayName,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// AgentSearchResult contains the servers that know an agent
+// Errors maps server names to the error querying them, and Freshness server
+// names to the freshness of the results of the servers
+type AgentSearchResult struct {
+	Spiffeid  string                     `json:"spiffeid"`
+	Locations []AgentLocation            `json:"locations"`
+	Errors    map[string]string          `json:"errors,omitempty"`
+	Freshness map[string]SourceFreshness `json:"freshness,omitempty"`
+}
+
+// SourceFreshness tells when the result of a server or peer manager was fetched
+// Stale results are served from the cache of the manager because the source
+// could not be queried, with Error the reason
+type SourceFreshness struct {
+	FetchedAt string `json:"fetchedAt"`
+	Stale     bool   `json:"stale,omitempty"`
+	Error     string `json:"error,omitempty"`
+}